@@ -3,19 +3,22 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 	"reflect"
-	"regexp"
-	"runtime"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/newrelic/nri-prometheus/internal/integration"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/newrelic/infra-integrations-sdk/v4/args"
 	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -23,19 +26,25 @@ import (
 type ArgumentList struct {
 	ConfigPath string `default:"" help:"Path to the config file"`
 	Configfile string `default:"" help:"Deprecated. --config_path takes precedence if both are set"`
+	Estimate   bool   `default:"false" help:"Scrape the targets once and print the estimated series and datapoints per minute, without emitting them"`
+	Once       bool   `default:"false" help:"Discover, scrape and emit the targets once and exit, with a non-zero status if any of them couldn't be scraped or emitted"`
+	Replay     string `default:"" help:"Directory of archived or exposition format payloads to process and emit with their original timestamps, instead of scraping the targets, and exit"`
 }
 
+// Configuration schema versions. Files without a `version` key are treated as
+// unversioned: unknown keys are logged and ignored. Starting with version 1,
+// unknown keys are rejected so typos don't silently disable options.
 const (
-	windowsDefinitionPath = "C:\\Program Files\\New Relic\\newrelic-infra\\definition-files"
-	linuxDefinitionPath   = "/etc/newrelic-infra/definition-files"
+	configVersionUnversioned = 0
+	currentConfigVersion     = 1
 )
 
-func loadConfig() (*scraper.Config, error) {
+func loadConfig() (*scraper.Config, ArgumentList, error) {
 
 	c := ArgumentList{}
 	err := args.SetupArgs(&c)
 	if err != nil {
-		return nil, err
+		return nil, c, err
 	}
 
 	cfg := viper.New()
@@ -58,59 +67,107 @@ func loadConfig() (*scraper.Config, error) {
 
 	err = cfg.ReadInConfig()
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read configuration")
+		return nil, c, errors.Wrap(err, "could not read configuration")
+	}
+	if err := setProfileDefaults(cfg); err != nil {
+		return nil, c, err
 	}
 
+	scraperCfg, err := unmarshalConfig(cfg)
+	if err != nil {
+		return nil, c, err
+	}
+	scraperCfg.LoadProcessingRules = func() ([]integration.ProcessingRule, error) {
+		if err := cfg.ReadInConfig(); err != nil {
+			return nil, errors.Wrap(err, "could not read configuration")
+		}
+		reloaded, err := unmarshalConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return reloaded.ProcessingRules, nil
+	}
+	return scraperCfg, c, nil
+}
+
+// unmarshalConfig validates the contents of the Viper registry against the
+// schema of the declared config version and returns the resulting configuration.
+func unmarshalConfig(cfg *viper.Viper) (*scraper.Config, error) {
 	var scraperCfg scraper.Config
-	bindViperEnv(cfg, scraperCfg)
-	err = cfg.Unmarshal(&scraperCfg)
+	if err := bindViperEnv(cfg, scraperCfg); err != nil {
+		return nil, err
+	}
 
+	version := cfg.GetInt("version")
+	if version < configVersionUnversioned || version > currentConfigVersion {
+		return nil, fmt.Errorf("unsupported configuration version %d, the maximum supported version is %d", version, currentConfigVersion)
+	}
+
+	md := mapstructure.Metadata{}
+	err := cfg.Unmarshal(&scraperCfg, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &md
+	}, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		jsonStringHookFunc,
+		targetURLHookFunc,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
 	if err != nil {
 		return nil, errors.Wrap(err, "could not parse configuration file")
 	}
 
-	// Set emitter default according to standalone mode.
-	if len(scraperCfg.Emitters) == 0 {
-		if scraperCfg.Standalone {
-			scraperCfg.Emitters = append(scraperCfg.Emitters, "telemetry")
+	if len(md.Unused) > 0 {
+		sort.Strings(md.Unused)
+		unknown := strings.Join(md.Unused, ", ")
+		if version == configVersionUnversioned {
+			logrus.Warnf("ignoring unknown configuration keys: %s. Run `nri-prometheus migrate-config` to upgrade "+
+				"the configuration to version %d, which rejects unknown keys", unknown, currentConfigVersion)
 		} else {
-			scraperCfg.Emitters = append(scraperCfg.Emitters, "infra-sdk")
+			return nil, fmt.Errorf("unknown configuration keys for version %d: %s", version, unknown)
 		}
 	}
 
-	if scraperCfg.DefinitionFilesPath == "" {
-		if runtime.GOOS == "windows" {
-			scraperCfg.DefinitionFilesPath = windowsDefinitionPath
-		} else {
-			scraperCfg.DefinitionFilesPath = linuxDefinitionPath
+	// The key in the file replaces the configured one. The emitters read it
+	// again when it's rotated.
+	if scraperCfg.LicenseKeyFile != "" {
+		key, err := integration.ReadLicenseKeyFile(scraperCfg.LicenseKeyFile)
+		if err != nil {
+			return nil, err
 		}
+		scraperCfg.LicenseKey = scraper.LicenseKey(key)
 	}
 
-	if scraperCfg.MetricAPIURL == "" {
-		scraperCfg.MetricAPIURL = determineMetricAPIURL(string(scraperCfg.LicenseKey))
-	}
+	scraper.CompleteConfig(&scraperCfg)
 
 	return &scraperCfg, nil
 }
 
 // setViperDefaults loads the default configuration into the given Viper registry.
 func setViperDefaults(viper *viper.Viper) {
-	viper.SetDefault("debug", false)
-	viper.SetDefault("verbose", false)
-	viper.SetDefault("audit", false)
-	viper.SetDefault("scrape_enabled_label", "prometheus.io/scrape")
-	viper.SetDefault("require_scrape_enabled_label_for_nodes", true)
-	viper.SetDefault("scrape_timeout", 5*time.Second)
-	viper.SetDefault("scrape_duration", "30s")
-	viper.SetDefault("emitter_harvest_period", fmt.Sprint(integration.BoundedHarvesterDefaultHarvestPeriod))
-	viper.SetDefault("min_emitter_harvest_period", fmt.Sprint(integration.BoundedHarvesterDefaultMinReportInterval))
-	viper.SetDefault("max_stored_metrics", fmt.Sprint(integration.BoundedHarvesterDefaultMetricsCap))
-	viper.SetDefault("auto_decorate", false)
-	viper.SetDefault("insecure_skip_verify", false)
-	viper.SetDefault("standalone", true)
-	viper.SetDefault("disable_autodiscovery", false)
-	viper.SetDefault("percentiles", []float64{50.0, 95.0, 99.0})
-	viper.SetDefault("worker_threads", 4)
+	for key, value := range scraper.Defaults() {
+		viper.SetDefault(key, value)
+	}
+}
+
+// setProfileDefaults replaces the defaults in the given Viper registry with the
+// ones of the configured profile.
+func setProfileDefaults(viper *viper.Viper) error {
+	_ = viper.BindEnv("profile", "PROFILE")
+	defaults, err := scraper.ProfileDefaults(viper.GetString("profile"))
+	if err != nil {
+		return err
+	}
+	for key, value := range defaults {
+		viper.SetDefault(key, value)
+	}
+	return nil
+}
+
+// envExcludedKeys are configuration keys that can't be set from environment
+// variables. The config schema version describes the file itself, and VERSION
+// is commonly defined by container images.
+var envExcludedKeys = map[string]bool{
+	"version": true,
 }
 
 // bindViperEnv automatically binds the variables in given configuration struct to environment variables.
@@ -118,39 +175,81 @@ func setViperDefaults(viper *viper.Viper) {
 // defined in the configuration file. We need to be able to use environment variables even if such variable is not in
 // the config file.
 // For more information see https://github.com/spf13/viper/issues/188.
-func bindViperEnv(vCfg *viper.Viper, iface interface{}, parts ...string) {
+//
+// Environment variables take precedence over the values of the config file, which take precedence over the defaults.
+// The name of the variable is the upper-cased config key, e.g. SCRAPE_DURATION for scrape_duration. Lists, maps and
+// blocks of options, like `transformations`, `targets` or `spiffe`, can be set with a JSON-encoded value.
+func bindViperEnv(vCfg *viper.Viper, iface interface{}, parts ...string) error {
 	ifv := reflect.ValueOf(iface)
 	ift := reflect.TypeOf(iface)
 	for i := 0; i < ift.NumField(); i++ {
 		v := ifv.Field(i)
 		t := ift.Field(i)
 		tv, ok := t.Tag.Lookup("mapstructure")
-		if !ok {
+		if !ok || tv == "-" || envExcludedKeys[tv] {
 			continue
 		}
+		path := append(append([]string{}, parts...), tv)
+		key := strings.Join(path, ".")
+		// Nested keys are bound to variables joining their parts with underscores,
+		// e.g. spiffe.svid_file is bound to SPIFFE_SVID_FILE.
+		env := strings.ToUpper(strings.Join(path, "_"))
 		switch v.Kind() {
 		case reflect.Struct:
-			bindViperEnv(vCfg, v.Interface(), append(parts, tv)...)
+			if err := bindViperEnv(vCfg, v.Interface(), path...); err != nil {
+				return err
+			}
+			// A whole block can be set with a JSON object too, e.g. SPIFFE for
+			// spiffe. It replaces the block of the config file and the variables
+			// of its options. The variables holding other values are ignored, as
+			// they can't be one and may be unrelated to the integration, like
+			// SERVER.
+			value, ok := os.LookupEnv(env)
+			if !ok || !strings.HasPrefix(strings.TrimSpace(value), "{") {
+				continue
+			}
+			if !json.Valid([]byte(value)) {
+				return fmt.Errorf("%s is not a valid JSON object", env)
+			}
+			// The value shadows the nested keys, which are decoded from it by
+			// jsonStringHookFunc.
+			vCfg.Set(key, value)
 		default:
-			_ = vCfg.BindEnv(strings.Join(append(parts, tv), "_"))
+			_ = vCfg.BindEnv(key, env)
 		}
 	}
+	return nil
 }
 
-var (
-	regionLicenseRegex = regexp.MustCompile(`^([a-z]{2,3})[0-9]{2}x{1,2}`)
-	metricAPIRegionURL = "https://metric-api.%s.newrelic.com/metric/v1/infra"
-	// for historical reasons the US datacenter is the default Metric API
-	defaultMetricAPIURL = "https://metric-api.newrelic.com/metric/v1/infra"
-)
+// jsonStringHookFunc decodes JSON strings that are assigned to lists, maps or
+// structs, so nested options can be set from a single environment variable.
+func jsonStringHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	switch to.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+	default:
+		return data, nil
+	}
 
-// determineMetricAPIURL determines the Metric API URL based on the license key.
-// The first 5 characters of the license URL indicates the region.
-func determineMetricAPIURL(license string) string {
-	m := regionLicenseRegex.FindStringSubmatch(license)
-	if len(m) > 1 {
-		return fmt.Sprintf(metricAPIRegionURL, m[1])
+	raw := strings.TrimSpace(data.(string))
+	if !strings.HasPrefix(raw, "[") && !strings.HasPrefix(raw, "{") {
+		return data, nil
 	}
 
-	return defaultMetricAPIURL
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return nil, fmt.Errorf("decoding JSON value: %w", err)
+	}
+	return decoded, nil
+}
+
+// targetURLHookFunc decodes the plain strings assigned to the urls of the
+// targets, which only need their options when they are set per URL.
+func targetURLHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(endpoints.TargetURL{}) {
+		return data, nil
+	}
+	return endpoints.TargetURL{URL: data.(string)}, nil
 }
@@ -3,29 +3,283 @@
 package main
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestDetermineMetricAPIURL(t *testing.T) {
+func readTestConfig(t *testing.T, content string) *viper.Viper {
+	t.Helper()
+
+	vCfg := viper.New()
+	vCfg.SetConfigType("yaml")
+	require.NoError(t, vCfg.ReadConfig(strings.NewReader(content)))
+	return vCfg
+}
+
+func TestUnmarshalConfigVersions(t *testing.T) {
 	testCases := []struct {
-		license     string
-		expectedURL string
+		name        string
+		config      string
+		expectedErr string
 	}{
-		// empty license
-		{license: "", expectedURL: defaultMetricAPIURL},
-		// non-region license
-		{license: "0123456789012345678901234567890123456789", expectedURL: defaultMetricAPIURL},
-		// four letter region
-		{license: "eu01xx6789012345678901234567890123456789", expectedURL: fmt.Sprintf(metricAPIRegionURL, "eu")},
-		// five letter region
-		{license: "gov01x6789012345678901234567890123456789", expectedURL: fmt.Sprintf(metricAPIRegionURL, "gov")},
+		{
+			name:   "unversioned config ignores unknown keys",
+			config: "cluster_name: test\ntransformations:\n  - ignore_metric:\n      - prefixes: [go_]\n",
+		},
+		{
+			name:   "versioned config without unknown keys",
+			config: "version: 1\ncluster_name: test\ntransformations:\n  - ignore_metrics:\n      - prefixes: [go_]\n",
+		},
+		{
+			name:        "versioned config rejects unknown top level keys",
+			config:      "version: 1\ncluster_nam: test\n",
+			expectedErr: "cluster_nam",
+		},
+		{
+			name:        "versioned config rejects unknown nested keys",
+			config:      "version: 1\ntransformations:\n  - ignore_metric:\n      - prefixes: [go_]\n",
+			expectedErr: "transformations[0].ignore_metric",
+		},
+		{
+			name:        "unsupported version",
+			config:      "version: 2\n",
+			expectedErr: "unsupported configuration version 2",
+		},
 	}
 
 	for _, tt := range testCases {
-		actualURL := determineMetricAPIURL(tt.license)
-		if actualURL != tt.expectedURL {
-			t.Fatalf("URL does not match expected URL, got=%s, expected=%s", actualURL, tt.expectedURL)
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unmarshalConfig(readTestConfig(t, tt.config))
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	input := `cluster_name: test
+percentiles: [50, 95]
+transformations:
+  - description: rules
+    ignore_metric:
+      - prefixes: [go_]
+`
+	migrated, notes, err := migrateConfig([]byte(input))
+	require.NoError(t, err)
+	assert.Len(t, notes, 2)
+
+	cfg, err := unmarshalConfig(readTestConfig(t, string(migrated)))
+	require.NoError(t, err)
+	assert.Equal(t, currentConfigVersion, cfg.ConfigVersion)
+	assert.Equal(t, "test", cfg.ClusterName)
+	require.Len(t, cfg.ProcessingRules, 1)
+	require.Len(t, cfg.ProcessingRules[0].IgnoreMetrics, 1)
+	assert.Equal(t, []string{"go_"}, cfg.ProcessingRules[0].IgnoreMetrics[0].Prefixes)
+
+	// Migrating an up to date configuration doesn't change it.
+	again, notes, err := migrateConfig(migrated)
+	require.NoError(t, err)
+	assert.Empty(t, notes)
+	assert.Equal(t, string(migrated), string(again))
+}
+
+func TestMigrateConfigReportsUnknownKeys(t *testing.T) {
+	_, notes, err := migrateConfig([]byte("cluster_nam: test\n"))
+	require.NoError(t, err)
+	require.Len(t, notes, 1)
+	assert.Contains(t, notes[0], "cluster_nam")
+}
+
+func TestUnmarshalConfigFromEnvironment(t *testing.T) {
+	env := map[string]string{
+		"CLUSTER_NAME":     "from-env",
+		"SCRAPE_TIMEOUT":   "7s",
+		"EMITTERS":         "stdout,telemetry",
+		"VERSION":          "2.4.0",
+		"TRANSFORMATIONS":  `[{"description":"env rules","ignore_metrics":[{"prefixes":["go_"]}]}]`,
+		"TARGETS":          `[{"description":"env targets","urls":[{"url":"localhost:9100"}]}]`,
+		"SPIFFE_SVID_FILE": "/run/spiffe/svid.pem",
+	}
+	for k, v := range env {
+		require.NoError(t, os.Setenv(k, v))
+	}
+	defer func() {
+		for k := range env {
+			_ = os.Unsetenv(k)
 		}
+	}()
+
+	cfg, err := unmarshalConfig(readTestConfig(t, "version: 1\ncluster_name: from-file\nscrape_duration: 10s\n"))
+	require.NoError(t, err)
+
+	// Environment variables take precedence over the config file.
+	assert.Equal(t, "from-env", cfg.ClusterName)
+	assert.Equal(t, "10s", cfg.ScrapeDuration)
+	assert.Equal(t, 7*time.Second, cfg.ScrapeTimeout)
+	assert.Equal(t, []string{"stdout", "telemetry"}, cfg.Emitters)
+	// The config version can't be overridden from the environment.
+	assert.Equal(t, 1, cfg.ConfigVersion)
+
+	require.Len(t, cfg.ProcessingRules, 1)
+	assert.Equal(t, "env rules", cfg.ProcessingRules[0].Description)
+	require.Len(t, cfg.ProcessingRules[0].IgnoreMetrics, 1)
+	assert.Equal(t, []string{"go_"}, cfg.ProcessingRules[0].IgnoreMetrics[0].Prefixes)
+
+	require.Len(t, cfg.TargetConfigs, 1)
+	require.Len(t, cfg.TargetConfigs[0].URLs, 1)
+	assert.Equal(t, "localhost:9100", cfg.TargetConfigs[0].URLs[0].URL)
+
+	// Nested options are set joining their keys with underscores.
+	assert.Equal(t, "/run/spiffe/svid.pem", cfg.SPIFFE.SVIDFile)
+}
+
+func TestUnmarshalConfigLicenseKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "license")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "license-key")
+	require.NoError(t, ioutil.WriteFile(path, []byte("eu01xx6789012345678901234567890123456789\n"), 0600))
+
+	cfg, err := unmarshalConfig(readTestConfig(t, "version: 1\nlicense_key: configured\nlicense_key_file: "+path+"\n"))
+	require.NoError(t, err)
+	// The key in the file replaces the configured one, and sets the region.
+	assert.Equal(t, scraper.LicenseKey("eu01xx6789012345678901234567890123456789"), cfg.LicenseKey)
+	assert.Equal(t, "https://metric-api.eu.newrelic.com/metric/v1/infra", cfg.MetricAPIURL)
+
+	_, err = unmarshalConfig(readTestConfig(t, "version: 1\nlicense_key_file: "+filepath.Join(dir, "missing")+"\n"))
+	assert.Error(t, err)
+}
+
+func TestUnmarshalConfigTargetURLs(t *testing.T) {
+	cfg, err := unmarshalConfig(readTestConfig(t, `
+version: 1
+targets:
+  - description: mixed exporters
+    urls:
+      - "http://node:9100"
+      - url: "https://app:8443"
+        metric_namespace: app
+        timeout: 2s
+        headers:
+          X-Scope-OrgID: tenant
+        auth:
+          bearer_token_file: /etc/app/token
+        tls_config:
+          insecure_skip_verify: true
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.TargetConfigs, 1)
+	urls := cfg.TargetConfigs[0].URLs
+	require.Len(t, urls, 2)
+	assert.Equal(t, endpoints.TargetURL{URL: "http://node:9100"}, urls[0])
+	assert.Equal(t, endpoints.TargetURL{
+		URL:             "https://app:8443",
+		MetricNamespace: "app",
+		TLSConfig:       endpoints.TLSConfig{InsecureSkipVerify: true},
+		Auth:            endpoints.TargetAuthConfig{BearerTokenFile: "/etc/app/token"},
+		Headers:         map[string]string{"X-Scope-OrgID": "tenant"},
+		Timeout:         2 * time.Second,
+	}, urls[1])
+}
+
+func TestUnmarshalConfigRuleMetadata(t *testing.T) {
+	cfg, err := unmarshalConfig(readTestConfig(t, `
+version: 1
+transformations:
+  - ignore_metrics:
+      - prefixes: ["go_"]
+        enabled: false
+        owner: team-platform
+        labels:
+          ticket: OPS-123
+threshold_events:
+  - name: down
+    metric: up
+    operator: "=="
+    owner: team-sre
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.ProcessingRules, 1)
+	ignore := cfg.ProcessingRules[0].IgnoreMetrics[0]
+	assert.False(t, ignore.IsEnabled())
+	assert.Equal(t, "team-platform", ignore.Owner)
+	assert.Equal(t, map[string]string{"ticket": "OPS-123"}, ignore.Labels)
+	require.Len(t, cfg.ThresholdEvents, 1)
+	assert.True(t, cfg.ThresholdEvents[0].IsEnabled())
+	assert.Equal(t, "team-sre", cfg.ThresholdEvents[0].Owner)
+}
+
+func TestSetProfileDefaults(t *testing.T) {
+	vCfg := readTestConfig(t, "cluster_name: test\nprofile: edge\nqueue_length: 20\n")
+	setViperDefaults(vCfg)
+	require.NoError(t, setProfileDefaults(vCfg))
+	cfg, err := unmarshalConfig(vCfg)
+	require.NoError(t, err)
+
+	// The defaults of the profile replace the ones of each setting.
+	assert.Equal(t, 2, cfg.WorkerThreads)
+	assert.Equal(t, 1000, cfg.MaxStoredMetrics)
+	assert.Equal(t, "64Mi", cfg.MemoryLimit)
+	assert.Equal(t, "8Mi", cfg.MaxPayloadSize)
+	// The configured settings take precedence over the profile.
+	assert.Equal(t, 20, cfg.QueueLength)
+
+	vCfg = readTestConfig(t, "profile: tiny\n")
+	assert.Error(t, setProfileDefaults(vCfg))
+}
+
+func TestUnmarshalConfigBlockFromEnvironment(t *testing.T) {
+	env := map[string]string{
+		"SPIFFE": `{"svid_file": "/run/spiffe/svid.pem", "svid_key_file": "/run/spiffe/key.pem", "allowed_ids": ["spiffe://example.org/exporter"]}`,
+		"SERVER": "build-01",
+	}
+	for k, v := range env {
+		require.NoError(t, os.Setenv(k, v))
 	}
+	defer func() {
+		for k := range env {
+			_ = os.Unsetenv(k)
+		}
+	}()
+
+	cfg, err := unmarshalConfig(readTestConfig(t, "version: 1\ncluster_name: test\nspiffe:\n  bundle_file: /etc/bundle.pem\n"))
+	require.NoError(t, err)
+
+	// The block in the variable replaces the one of the config file.
+	assert.Equal(t, "/run/spiffe/svid.pem", cfg.SPIFFE.SVIDFile)
+	assert.Equal(t, "/run/spiffe/key.pem", cfg.SPIFFE.SVIDKeyFile)
+	assert.Equal(t, []string{"spiffe://example.org/exporter"}, cfg.SPIFFE.AllowedIDs)
+	assert.Empty(t, cfg.SPIFFE.BundleFile)
+	// The variables that aren't JSON objects are ignored for the blocks.
+	assert.Empty(t, cfg.Server.Address)
+}
+
+func TestUnmarshalConfigInvalidJSONFromEnvironment(t *testing.T) {
+	require.NoError(t, os.Setenv("TRANSFORMATIONS", `[{"description": }]`))
+	defer os.Unsetenv("TRANSFORMATIONS")
+
+	_, err := unmarshalConfig(readTestConfig(t, "cluster_name: test\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "decoding JSON value")
+
+	require.NoError(t, os.Setenv("SPIFFE", `{"svid_file": }`))
+	defer os.Unsetenv("SPIFFE")
+
+	_, err = unmarshalConfig(readTestConfig(t, "cluster_name: test\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SPIFFE is not a valid JSON object")
 }
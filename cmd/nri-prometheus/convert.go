@@ -0,0 +1,569 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// prometheusConfig is the part of a prometheus.yml file that is converted.
+// Other has the rest of the top-level keys, which are reported.
+type prometheusConfig struct {
+	Global        prometheusGlobalConfig   `yaml:"global"`
+	ScrapeConfigs []prometheusScrapeConfig `yaml:"scrape_configs"`
+	Other         map[string]interface{}   `yaml:",inline"`
+}
+
+type prometheusGlobalConfig struct {
+	ScrapeInterval string                 `yaml:"scrape_interval"`
+	ScrapeTimeout  string                 `yaml:"scrape_timeout"`
+	ExternalLabels map[string]string      `yaml:"external_labels"`
+	Other          map[string]interface{} `yaml:",inline"`
+}
+
+type prometheusScrapeConfig struct {
+	JobName              string                         `yaml:"job_name"`
+	ScrapeInterval       string                         `yaml:"scrape_interval"`
+	ScrapeTimeout        string                         `yaml:"scrape_timeout"`
+	MetricsPath          string                         `yaml:"metrics_path"`
+	Scheme               string                         `yaml:"scheme"`
+	Params               map[string][]string            `yaml:"params"`
+	BasicAuth            *prometheusBasicAuth           `yaml:"basic_auth"`
+	BearerToken          string                         `yaml:"bearer_token"`
+	BearerTokenFile      string                         `yaml:"bearer_token_file"`
+	Authorization        *prometheusAuthorization       `yaml:"authorization"`
+	TLSConfig            *prometheusTLSConfig           `yaml:"tls_config"`
+	StaticConfigs        []prometheusStaticConfig       `yaml:"static_configs"`
+	KubernetesSDConfigs  []prometheusKubernetesSDConfig `yaml:"kubernetes_sd_configs"`
+	RelabelConfigs       []prometheusRelabelConfig      `yaml:"relabel_configs"`
+	MetricRelabelConfigs []prometheusRelabelConfig      `yaml:"metric_relabel_configs"`
+	Other                map[string]interface{}         `yaml:",inline"`
+}
+
+type prometheusBasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type prometheusAuthorization struct {
+	Type            string `yaml:"type"`
+	Credentials     string `yaml:"credentials"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+type prometheusTLSConfig struct {
+	CAFile             string                 `yaml:"ca_file"`
+	CertFile           string                 `yaml:"cert_file"`
+	KeyFile            string                 `yaml:"key_file"`
+	ServerName         string                 `yaml:"server_name"`
+	InsecureSkipVerify bool                   `yaml:"insecure_skip_verify"`
+	MinVersion         string                 `yaml:"min_version"`
+	Other              map[string]interface{} `yaml:",inline"`
+}
+
+type prometheusStaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+type prometheusKubernetesSDConfig struct {
+	Role           string                 `yaml:"role"`
+	APIServer      string                 `yaml:"api_server"`
+	KubeConfigFile string                 `yaml:"kubeconfig_file"`
+	Other          map[string]interface{} `yaml:",inline"`
+}
+
+type prometheusRelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+// regex returns the regular expression of the relabeling, which defaults to
+// (.*) as in Prometheus.
+func (r prometheusRelabelConfig) regex() string {
+	if r.Regex == nil {
+		return "(.*)"
+	}
+	return *r.Regex
+}
+
+// replacement returns the replacement of the relabeling, which defaults to
+// $1 as in Prometheus.
+func (r prometheusRelabelConfig) replacement() string {
+	if r.Replacement == nil {
+		return "$1"
+	}
+	return *r.Replacement
+}
+
+// action returns the action of the relabeling, which defaults to replace.
+func (r prometheusRelabelConfig) action() string {
+	if r.Action == "" {
+		return "replace"
+	}
+	return strings.ToLower(r.Action)
+}
+
+// prometheusTLSVersions maps the TLS versions of Prometheus to the ones of
+// the integration.
+var prometheusTLSVersions = map[string]string{
+	"TLS10": "1.0",
+	"TLS11": "1.1",
+	"TLS12": "1.2",
+	"TLS13": "1.3",
+}
+
+var (
+	// kubernetesScrapeLabelRegexp matches the source labels of the usual
+	// relabeling keeping the objects with the prometheus.io/scrape annotation
+	// or label, which is what the Kubernetes retriever does by default.
+	kubernetesScrapeLabelRegexp = regexp.MustCompile(`^__meta_kubernetes_(pod|service|endpoints|endpointslice|node)_(annotation|label)(present)?_prometheus_io_scrape$`)
+	// kubernetesBuiltinRegexp matches the source labels of the relabelings
+	// setting the path, port and scheme from the prometheus.io annotations,
+	// along with the __address__, and the namespace and object names, which
+	// the Kubernetes retriever does on its own.
+	kubernetesBuiltinRegexp = regexp.MustCompile(`^__meta_kubernetes_((pod|service|endpoints|endpointslice|node)_(annotation|label)(present)?_prometheus_io_(path|port|scheme)|namespace|pod_name|service_name|node_name)$`)
+	// kubernetesLabelMapRegexp matches the regular expressions of the
+	// labelmap relabelings copying the labels of the objects, which the
+	// Kubernetes retriever adds as label.* attributes.
+	kubernetesLabelMapRegexp = regexp.MustCompile(`^__meta_kubernetes_(pod|service|node)_label_\(\.[+*]\)$`)
+)
+
+// runConvert implements the `convert` subcommand, which translates the
+// scrape_configs of a Prometheus configuration file to a configuration of the
+// integration.
+func runConvert(arguments []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	prometheusConfigPath := fs.String("prometheus_config", "", "Path to the prometheus.yml file to convert")
+	output := fs.String("output", "", "Path where the converted config is written. Defaults to stdout")
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+	if *prometheusConfigPath == "" {
+		return fmt.Errorf("--prometheus_config is required")
+	}
+
+	content, err := ioutil.ReadFile(*prometheusConfigPath)
+	if err != nil {
+		return fmt.Errorf("reading Prometheus config file: %w", err)
+	}
+
+	converted, notes, err := convertPrometheusConfig(content)
+	if err != nil {
+		return err
+	}
+	for _, n := range notes {
+		fmt.Fprintln(os.Stderr, n)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(converted)
+		return err
+	}
+	return ioutil.WriteFile(*output, converted, 0644)
+}
+
+// converter accumulates the configuration translated from the scrape_configs
+// and the notes about what couldn't be.
+type converter struct {
+	notes           []string
+	targets         []interface{}
+	transformations []interface{}
+	clusters        []interface{}
+	inCluster       bool
+	// nodesWithoutLabel is true if any job scrapes the nodes without the
+	// prometheus.io/scrape label.
+	nodesWithoutLabel bool
+}
+
+func (c *converter) notef(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// convertPrometheusConfig translates the given prometheus.yml to a
+// configuration of the integration: the static_configs to targets, the
+// kubernetes_sd_configs to the options of the Kubernetes retriever, and the
+// relabel_configs to transformations when they have an equivalent. It returns
+// the converted document along with human readable notes about what couldn't
+// be translated.
+func convertPrometheusConfig(content []byte) ([]byte, []string, error) {
+	var pc prometheusConfig
+	if err := yaml.Unmarshal(content, &pc); err != nil {
+		return nil, nil, fmt.Errorf("parsing Prometheus config file: %w", err)
+	}
+
+	c := &converter{}
+	for _, key := range sortedKeys(pc.Other) {
+		c.notef("%s isn't translated", key)
+	}
+	for _, key := range sortedKeys(pc.Global.Other) {
+		c.notef("global.%s isn't translated", key)
+	}
+	converted := yaml.MapSlice{{Key: "version", Value: currentConfigVersion}}
+	if pc.Global.ScrapeInterval != "" {
+		converted = append(converted, yaml.MapItem{Key: "scrape_duration", Value: pc.Global.ScrapeInterval})
+	}
+	if pc.Global.ScrapeTimeout != "" {
+		converted = append(converted, yaml.MapItem{Key: "scrape_timeout", Value: pc.Global.ScrapeTimeout})
+	}
+	if len(pc.Global.ExternalLabels) > 0 {
+		c.transformations = append(c.transformations, yaml.MapSlice{
+			{Key: "description", Value: "global.external_labels"},
+			{Key: "add_attributes", Value: []interface{}{addAttributesRule(pc.Global.ExternalLabels)}},
+		})
+	}
+
+	jobTransformations := 0
+	for _, sc := range pc.ScrapeConfigs {
+		if sc.ScrapeInterval != "" && sc.ScrapeInterval != pc.Global.ScrapeInterval {
+			c.notef("job %q: scrape_interval %s isn't translated, all the targets are scraped every scrape_duration", sc.JobName, sc.ScrapeInterval)
+		}
+		for _, key := range sortedKeys(sc.Other) {
+			c.notef("job %q: %s isn't translated", sc.JobName, key)
+		}
+		rules := c.convertRelabelConfigs(sc)
+		if len(rules) > 0 {
+			jobTransformations++
+			c.transformations = append(c.transformations, append(yaml.MapSlice{{Key: "description", Value: "job " + sc.JobName}}, rules...))
+		}
+		c.convertStaticConfigs(sc)
+		c.convertKubernetesSDConfigs(sc)
+	}
+	if jobTransformations > 1 {
+		c.notef("the transformations apply to the metrics of all the targets, not only the ones of the job they were converted from")
+	}
+
+	if !c.inCluster {
+		converted = append(converted, yaml.MapItem{Key: "disable_autodiscovery", Value: true})
+	}
+	if c.nodesWithoutLabel {
+		converted = append(converted, yaml.MapItem{Key: "require_scrape_enabled_label_for_nodes", Value: false})
+	}
+	if len(c.clusters) > 0 {
+		converted = append(converted, yaml.MapItem{Key: "kubernetes_clusters", Value: c.clusters})
+	}
+	if len(c.targets) > 0 {
+		converted = append(converted, yaml.MapItem{Key: "targets", Value: c.targets})
+	}
+	if len(c.transformations) > 0 {
+		converted = append(converted, yaml.MapItem{Key: "transformations", Value: c.transformations})
+	}
+
+	out, err := yaml.Marshal(converted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Report the problems of the converted configuration, e.g. invalid
+	// durations, so they can be fixed by hand.
+	vCfg := viper.New()
+	vCfg.SetConfigType("yaml")
+	if err := vCfg.ReadConfig(bytes.NewReader(out)); err != nil {
+		return nil, nil, err
+	}
+	if _, err := unmarshalConfig(vCfg); err != nil {
+		c.notef("the converted configuration needs manual changes: %s", err)
+	}
+
+	return out, c.notes, nil
+}
+
+// convertStaticConfigs adds a target with the URLs of the static_configs of
+// the job, scraped with its options.
+func (c *converter) convertStaticConfigs(sc prometheusScrapeConfig) {
+	scheme := sc.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := sc.MetricsPath
+	if path == "" {
+		path = "/metrics"
+	}
+	var query string
+	if len(sc.Params) > 0 {
+		query = "?" + url.Values(sc.Params).Encode()
+	}
+	options := c.urlOptions(sc)
+
+	var urls []interface{}
+	for i, static := range sc.StaticConfigs {
+		if len(static.Labels) > 0 {
+			c.notef("job %q: static_configs[%d].labels aren't translated, the targets have no static attributes", sc.JobName, i)
+		}
+		for _, t := range static.Targets {
+			u := scheme + "://" + t + path + query
+			if len(options) == 0 {
+				urls = append(urls, u)
+				continue
+			}
+			urls = append(urls, append(yaml.MapSlice{{Key: "url", Value: u}}, options...))
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	target := yaml.MapSlice{
+		{Key: "description", Value: "job " + sc.JobName},
+		{Key: "urls", Value: urls},
+	}
+	if tls := c.tlsConfig(sc); len(tls) > 0 {
+		target = append(target, yaml.MapItem{Key: "tls_config", Value: tls})
+	}
+	c.targets = append(c.targets, target)
+}
+
+// urlOptions returns the options of the URLs of the job: its credentials and
+// timeout.
+func (c *converter) urlOptions(sc prometheusScrapeConfig) yaml.MapSlice {
+	var options yaml.MapSlice
+	var auth yaml.MapSlice
+	if sc.BasicAuth != nil {
+		auth = yaml.MapSlice{{Key: "username", Value: sc.BasicAuth.Username}}
+		if sc.BasicAuth.PasswordFile != "" {
+			auth = append(auth, yaml.MapItem{Key: "password_file", Value: sc.BasicAuth.PasswordFile})
+		} else {
+			c.notef("job %q: basic_auth.password isn't translated, write it to a file and set it as auth.password_file", sc.JobName)
+		}
+	}
+	if sc.BearerTokenFile != "" {
+		auth = append(auth, yaml.MapItem{Key: "bearer_token_file", Value: sc.BearerTokenFile})
+	}
+	if sc.BearerToken != "" {
+		c.notef("job %q: bearer_token isn't translated, write it to a file and set it as auth.bearer_token_file", sc.JobName)
+	}
+	if a := sc.Authorization; a != nil {
+		switch {
+		case a.Type != "" && !strings.EqualFold(a.Type, "Bearer"):
+			c.notef("job %q: authorization of type %s isn't translated, set the Authorization header instead", sc.JobName, a.Type)
+		case a.CredentialsFile != "":
+			auth = append(auth, yaml.MapItem{Key: "bearer_token_file", Value: a.CredentialsFile})
+		case a.Credentials != "":
+			c.notef("job %q: authorization.credentials isn't translated, write them to a file and set it as auth.bearer_token_file", sc.JobName)
+		}
+	}
+	if len(auth) > 0 {
+		options = append(options, yaml.MapItem{Key: "auth", Value: auth})
+	}
+	if sc.ScrapeTimeout != "" {
+		if _, err := time.ParseDuration(sc.ScrapeTimeout); err != nil {
+			c.notef("job %q: scrape_timeout %s isn't translated: %s", sc.JobName, sc.ScrapeTimeout, err)
+		} else {
+			options = append(options, yaml.MapItem{Key: "timeout", Value: sc.ScrapeTimeout})
+		}
+	}
+	return options
+}
+
+// tlsConfig returns the tls_config of the targets of the job.
+func (c *converter) tlsConfig(sc prometheusScrapeConfig) yaml.MapSlice {
+	if sc.TLSConfig == nil {
+		return nil
+	}
+	t := sc.TLSConfig
+	var tls yaml.MapSlice
+	for _, field := range []struct {
+		key, value string
+	}{
+		{"ca_file_path", t.CAFile},
+		{"cert_file_path", t.CertFile},
+		{"key_file_path", t.KeyFile},
+		{"server_name", t.ServerName},
+	} {
+		if field.value != "" {
+			tls = append(tls, yaml.MapItem{Key: field.key, Value: field.value})
+		}
+	}
+	if t.InsecureSkipVerify {
+		tls = append(tls, yaml.MapItem{Key: "insecure_skip_verify", Value: true})
+	}
+	if t.MinVersion != "" {
+		if version, ok := prometheusTLSVersions[strings.ToUpper(t.MinVersion)]; ok {
+			tls = append(tls, yaml.MapItem{Key: "min_version", Value: version})
+		} else {
+			c.notef("job %q: tls_config.min_version %s isn't translated", sc.JobName, t.MinVersion)
+		}
+	}
+	for _, key := range sortedKeys(t.Other) {
+		c.notef("job %q: tls_config.%s isn't translated", sc.JobName, key)
+	}
+	return tls
+}
+
+// convertKubernetesSDConfigs enables the Kubernetes retrievers of the
+// kubernetes_sd_configs of the job: the in-cluster one, or one per
+// kubeconfig file.
+func (c *converter) convertKubernetesSDConfigs(sc prometheusScrapeConfig) {
+	for i, sd := range sc.KubernetesSDConfigs {
+		for _, key := range sortedKeys(sd.Other) {
+			c.notef("job %q: kubernetes_sd_configs[%d].%s isn't translated, the targets of all the namespaces are discovered", sc.JobName, i, key)
+		}
+		switch strings.ToLower(sd.Role) {
+		case "pod", "service", "endpoints", "endpointslice":
+			if !keepsScrapeLabel(sc) {
+				c.notef("job %q: only the pods and services with the prometheus.io/scrape label or annotation are scraped", sc.JobName)
+			}
+		case "node":
+			if !keepsScrapeLabel(sc) {
+				c.nodesWithoutLabel = true
+			}
+		default:
+			c.notef("job %q: kubernetes_sd_configs[%d] with role %s isn't translated, only pods, services and nodes are discovered", sc.JobName, i, sd.Role)
+			continue
+		}
+
+		switch {
+		case sd.KubeConfigFile != "":
+			c.clusters = append(c.clusters, yaml.MapSlice{
+				{Key: "name", Value: sc.JobName},
+				{Key: "kubeconfig", Value: sd.KubeConfigFile},
+			})
+		case sd.APIServer != "":
+			c.notef("job %q: kubernetes_sd_configs[%d].api_server isn't translated, use a kubeconfig file in kubernetes_clusters", sc.JobName, i)
+		default:
+			c.inCluster = true
+		}
+	}
+}
+
+// keepsScrapeLabel returns true if the job only keeps the objects with the
+// prometheus.io/scrape annotation or label.
+func keepsScrapeLabel(sc prometheusScrapeConfig) bool {
+	for _, r := range sc.RelabelConfigs {
+		if r.action() == "keep" && len(r.SourceLabels) == 1 && kubernetesScrapeLabelRegexp.MatchString(r.SourceLabels[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertRelabelConfigs returns the rules of the transformations equivalent
+// to the relabel_configs and metric_relabel_configs of the job. The target
+// relabelings of the Kubernetes jobs that the retriever does on its own
+// aren't translated.
+func (c *converter) convertRelabelConfigs(sc prometheusScrapeConfig) yaml.MapSlice {
+	var addAttributes, renameMetrics, ignoreMetrics []interface{}
+	convert := func(kind string, i int, r prometheusRelabelConfig) {
+		regex := r.regex()
+		re, err := regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			c.notef("job %q: %s[%d] isn't translated: %s", sc.JobName, kind, i, err)
+			return
+		}
+		switch action := r.action(); {
+		case (action == "keep" || action == "drop") && len(r.SourceLabels) == 1 &&
+			(r.SourceLabels[0] == "__name__" || !strings.HasPrefix(r.SourceLabels[0], "__")):
+			op := "=~"
+			if action == "keep" {
+				op = "!~"
+			}
+			ignoreMetrics = append(ignoreMetrics, yaml.MapSlice{
+				{Key: "series", Value: []string{fmt.Sprintf("{%s%s%s}", r.SourceLabels[0], op, strconv.Quote(regex))}},
+			})
+		case action == "replace" && len(r.SourceLabels) == 0 && r.TargetLabel != "" && !strings.HasPrefix(r.TargetLabel, "__") &&
+			!strings.Contains(r.replacement(), "$") && re.MatchString(""):
+			addAttributes = append(addAttributes, addAttributesRule(map[string]string{r.TargetLabel: r.replacement()}))
+		case action == "replace" && r.TargetLabel == "__name__" && len(r.SourceLabels) == 1 && r.SourceLabels[0] == "__name__" &&
+			regexp.QuoteMeta(regex) == regex && !strings.Contains(r.replacement(), "$"):
+			renameMetrics = append(renameMetrics, yaml.MapSlice{
+				{Key: "from_metric", Value: regex},
+				{Key: "to_metric", Value: r.replacement()},
+			})
+		default:
+			c.notef("job %q: %s[%d] with action %s isn't translated", sc.JobName, kind, i, action)
+		}
+	}
+
+	kubernetes := len(sc.KubernetesSDConfigs) > 0
+	for i, r := range sc.RelabelConfigs {
+		if kubernetes && isKubernetesBuiltin(r) {
+			continue
+		}
+		convert("relabel_configs", i, r)
+	}
+	for i, r := range sc.MetricRelabelConfigs {
+		convert("metric_relabel_configs", i, r)
+	}
+
+	var rules yaml.MapSlice
+	if len(addAttributes) > 0 {
+		rules = append(rules, yaml.MapItem{Key: "add_attributes", Value: addAttributes})
+	}
+	if len(renameMetrics) > 0 {
+		rules = append(rules, yaml.MapItem{Key: "rename_metrics", Value: renameMetrics})
+	}
+	if len(ignoreMetrics) > 0 {
+		rules = append(rules, yaml.MapItem{Key: "ignore_metrics", Value: ignoreMetrics})
+	}
+	return rules
+}
+
+// isKubernetesBuiltin returns true for the target relabelings of the
+// Kubernetes jobs that the retriever does on its own.
+func isKubernetesBuiltin(r prometheusRelabelConfig) bool {
+	switch r.action() {
+	case "keep":
+		return len(r.SourceLabels) == 1 && kubernetesScrapeLabelRegexp.MatchString(r.SourceLabels[0])
+	case "labelmap":
+		return kubernetesLabelMapRegexp.MatchString(r.regex())
+	case "replace":
+		builtin := false
+		for _, l := range r.SourceLabels {
+			if kubernetesBuiltinRegexp.MatchString(l) {
+				builtin = true
+			} else if l != "__address__" {
+				return false
+			}
+		}
+		return builtin
+	}
+	return false
+}
+
+// addAttributesRule returns an add_attributes rule adding the attributes to
+// all the metrics.
+func addAttributesRule(attributes map[string]string) yaml.MapSlice {
+	attrs := make(yaml.MapSlice, 0, len(attributes))
+	for _, k := range sortedKeys(attributes) {
+		attrs = append(attrs, yaml.MapItem{Key: k, Value: attributes[k]})
+	}
+	return yaml.MapSlice{
+		{Key: "metric_prefix", Value: ""},
+		{Key: "attributes", Value: attrs},
+	}
+}
+
+// sortedKeys returns the keys of a map[string]string or
+// map[string]interface{}, sorted.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestConvertPrometheusConfig(t *testing.T) {
+	converted, notes, err := convertPrometheusConfig([]byte(`
+global:
+  scrape_interval: 15s
+  evaluation_interval: 15s
+  external_labels:
+    env: prod
+rule_files: ["alerts.yml"]
+scrape_configs:
+  - job_name: node
+    scrape_timeout: 5s
+    basic_auth:
+      username: prometheus
+      password_file: /etc/prometheus/password
+    static_configs:
+      - targets: ["node-1:9100", "node-2:9100"]
+        labels:
+          team: infra
+    metric_relabel_configs:
+      - source_labels: [__name__]
+        regex: "go_.*"
+        action: drop
+      - source_labels: [__name__]
+        regex: node_load1
+        target_label: __name__
+        replacement: load
+      - regex: "tmp_.*"
+        action: labeldrop
+  - job_name: app
+    scheme: https
+    metrics_path: /prometheus
+    params:
+      format: [prometheus]
+    tls_config:
+      ca_file: /etc/ca.pem
+      min_version: TLS12
+    static_configs:
+      - targets: ["app:8443"]
+  - job_name: kubernetes-pods
+    kubernetes_sd_configs:
+      - role: pod
+        namespaces:
+          names: [default]
+    relabel_configs:
+      - source_labels: [__meta_kubernetes_pod_annotation_prometheus_io_scrape]
+        action: keep
+        regex: "true"
+      - source_labels: [__address__, __meta_kubernetes_pod_annotation_prometheus_io_port]
+        action: replace
+        regex: ([^:]+)(?::\d+)?;(\d+)
+        replacement: $1:$2
+        target_label: __address__
+      - action: labelmap
+        regex: __meta_kubernetes_pod_label_(.+)
+      - target_label: cluster
+        replacement: main
+    metric_relabel_configs:
+      - source_labels: [namespace]
+        regex: "kube-.*"
+        action: keep
+  - job_name: kubernetes-nodes
+    kubernetes_sd_configs:
+      - role: node
+        kubeconfig_file: /etc/kubeconfig
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"rule_files isn't translated",
+		"global.evaluation_interval isn't translated",
+		`job "node": metric_relabel_configs[2] with action labeldrop isn't translated`,
+		`job "node": static_configs[0].labels aren't translated, the targets have no static attributes`,
+		`job "kubernetes-pods": kubernetes_sd_configs[0].namespaces isn't translated, the targets of all the namespaces are discovered`,
+		"the transformations apply to the metrics of all the targets, not only the ones of the job they were converted from",
+	}, notes)
+
+	vCfg := viper.New()
+	vCfg.SetConfigType("yaml")
+	require.NoError(t, vCfg.ReadConfig(bytes.NewReader(converted)))
+	cfg, err := unmarshalConfig(vCfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "15s", cfg.ScrapeDuration)
+	assert.False(t, cfg.DisableAutodiscovery)
+	assert.False(t, cfg.RequireScrapeEnabledLabelForNodes)
+	require.Len(t, cfg.KubernetesClusters, 1)
+	assert.Equal(t, "/etc/kubeconfig", cfg.KubernetesClusters[0].KubeConfig)
+
+	require.Len(t, cfg.TargetConfigs, 2)
+	auth := endpoints.TargetAuthConfig{Username: "prometheus", PasswordFile: "/etc/prometheus/password"}
+	assert.Equal(t, []endpoints.TargetURL{
+		{URL: "http://node-1:9100/metrics", Auth: auth, Timeout: 5 * time.Second},
+		{URL: "http://node-2:9100/metrics", Auth: auth, Timeout: 5 * time.Second},
+	}, cfg.TargetConfigs[0].URLs)
+	assert.Equal(t, []endpoints.TargetURL{{URL: "https://app:8443/prometheus?format=prometheus"}}, cfg.TargetConfigs[1].URLs)
+	assert.Equal(t, endpoints.TLSConfig{CaFilePath: "/etc/ca.pem", MinVersion: "1.2"}, cfg.TargetConfigs[1].TLSConfig)
+
+	require.Len(t, cfg.ProcessingRules, 3)
+	assert.Equal(t, "prod", cfg.ProcessingRules[0].AddAttributes[0].Attributes["env"])
+	assert.Equal(t, []integration.IgnoreRule{{Series: []string{`{__name__=~"go_.*"}`}}}, cfg.ProcessingRules[1].IgnoreMetrics)
+	assert.Equal(t, []integration.RenameMetricRule{{FromMetric: "node_load1", ToMetric: "load"}}, cfg.ProcessingRules[1].RenameMetrics)
+	assert.Equal(t, "main", cfg.ProcessingRules[2].AddAttributes[0].Attributes["cluster"])
+	assert.Equal(t, []integration.IgnoreRule{{Series: []string{`{namespace!~"kube-.*"}`}}}, cfg.ProcessingRules[2].IgnoreMetrics)
+}
@@ -3,22 +3,58 @@
 package main
 
 import (
+	"os"
+
 	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
 	"github.com/newrelic/nri-prometheus/internal/integration"
 	"github.com/sirupsen/logrus"
 )
 
+// subcommands are alternative entry points selected by the first command line
+// argument. Each one receives the remaining arguments.
+var subcommands = map[string]func(arguments []string) error{
+	"convert":        runConvert,
+	"migrate-config": runMigrateConfig,
+	"test-rules":     runTestRules,
+}
+
 func main() {
-	cfg, err := loadConfig()
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				exit("while running "+os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	cfg, arguments, err := loadConfig()
 	if err != nil {
-		logrus.WithError(err).Fatal("while loading configuration")
+		exit("while loading configuration", scraper.NewConfigError(err))
 	}
 
+	if arguments.Estimate {
+		if err := scraper.Estimate(cfg, os.Stdout); err != nil {
+			exit("error occurred while estimating the ingest", err)
+		}
+		return
+	}
+
+	cfg.Once = arguments.Once
+	cfg.Replay = arguments.Replay
+
 	logrus.Infof("Starting New Relic's Prometheus OpenMetrics Integration version %s", integration.Version)
 	logrus.Debugf("Config: %#v", cfg)
 
 	err = scraper.Run(cfg)
 	if err != nil {
-		logrus.WithError(err).Fatal("error occurred while running scraper")
+		exit("error occurred while running scraper", err)
 	}
 }
+
+// exit logs the fatal error, writes its summary as JSON to stderr and exits
+// with the exit code of its class.
+func exit(message string, err error) {
+	logrus.WithError(err).Error(message)
+	os.Exit(scraper.WriteErrorSummary(os.Stderr, message, err))
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// removedConfigKeys are top-level keys that were accepted by unversioned
+// configurations but never had any effect.
+var removedConfigKeys = map[string]string{
+	"percentiles": "percentiles are not calculated by the integration since version 2.0.0",
+}
+
+// ruleKeyAliases maps frequent misspellings of the transformation rule types
+// to their valid names.
+var ruleKeyAliases = map[string]string{
+	"add_attribute":    "add_attributes",
+	"rename_attribute": "rename_attributes",
+	"rename_metric":    "rename_metrics",
+	"ignore_metric":    "ignore_metrics",
+	"copy_attribute":   "copy_attributes",
+}
+
+// runMigrateConfig implements the `migrate-config` subcommand, which upgrades
+// a configuration file to the current configuration version.
+func runMigrateConfig(arguments []string) error {
+	fs := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	configPath := fs.String("config_path", "", "Path to the config file to migrate")
+	output := fs.String("output", "", "Path where the migrated config is written. Defaults to stdout")
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("--config_path is required")
+	}
+
+	content, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	migrated, notes, err := migrateConfig(content)
+	if err != nil {
+		return err
+	}
+	for _, n := range notes {
+		fmt.Fprintln(os.Stderr, n)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(migrated)
+		return err
+	}
+	return ioutil.WriteFile(*output, migrated, 0644)
+}
+
+// migrateConfig upgrades the given YAML configuration to currentConfigVersion.
+// It returns the migrated document along with human readable notes about the
+// changes made and the problems that need manual intervention. Comments are
+// not preserved.
+func migrateConfig(content []byte) ([]byte, []string, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	var notes []string
+	version := configVersionUnversioned
+	migrated := make(yaml.MapSlice, 0, len(doc)+1)
+	for _, item := range doc {
+		key := fmt.Sprint(item.Key)
+		switch lk := strings.ToLower(key); {
+		case lk == "version":
+			v, ok := item.Value.(int)
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid configuration version %v", item.Value)
+			}
+			version = v
+			continue
+		case removedConfigKeys[lk] != "":
+			notes = append(notes, fmt.Sprintf("removed %q: %s", key, removedConfigKeys[lk]))
+			continue
+		case lk == "transformations":
+			item.Value = migrateTransformations(item.Value, &notes)
+		}
+		migrated = append(migrated, item)
+	}
+
+	if version > currentConfigVersion {
+		return nil, nil, fmt.Errorf("unsupported configuration version %d, the maximum supported version is %d", version, currentConfigVersion)
+	}
+	migrated = append(yaml.MapSlice{{Key: "version", Value: currentConfigVersion}}, migrated...)
+
+	out, err := yaml.Marshal(migrated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Report the keys that are still unknown so they can be fixed by hand.
+	vCfg := viper.New()
+	vCfg.SetConfigType("yaml")
+	if err := vCfg.ReadConfig(bytes.NewReader(out)); err != nil {
+		return nil, nil, err
+	}
+	if _, err := unmarshalConfig(vCfg); err != nil {
+		notes = append(notes, fmt.Sprintf("the migrated configuration needs manual changes: %s", err))
+	}
+
+	return out, notes, nil
+}
+
+// migrateTransformations renames the misspelled rule types of every
+// transformation block.
+func migrateTransformations(value interface{}, notes *[]string) interface{} {
+	transformations, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	for i, t := range transformations {
+		rules, ok := t.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		for j := range rules {
+			key := fmt.Sprint(rules[j].Key)
+			if alias, ok := ruleKeyAliases[strings.ToLower(key)]; ok {
+				*notes = append(*notes, fmt.Sprintf("renamed transformations[%d].%s to %s", i, key, alias))
+				rules[j].Key = alias
+			}
+		}
+	}
+	return transformations
+}
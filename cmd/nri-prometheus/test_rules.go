@@ -0,0 +1,160 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// runTestRules implements the `test-rules` subcommand, which applies the
+// processing rules of a file to a fixture in the Prometheus text format and
+// compares the result with the expected metrics, failing if they differ.
+func runTestRules(arguments []string) error {
+	fs := flag.NewFlagSet("test-rules", flag.ContinueOnError)
+	rulesPath := fs.String("rules", "", "Path to a YAML file with the processing rules in the transformations key")
+	inputPath := fs.String("input", "", "Path to the fixture in the Prometheus text format")
+	expectPath := fs.String("expect", "", "Path to the JSON file with the expected metrics")
+	target := fs.String("target", "fixture", "Name of the target the fixture is scraped from")
+	update := fs.Bool("update", false, "Write the resulting metrics to the --expect file instead of comparing them")
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+	if *rulesPath == "" || *inputPath == "" || *expectPath == "" {
+		return fmt.Errorf("--rules, --input and --expect are required")
+	}
+
+	rules, err := scraper.ReadProcessingRules(*rulesPath)
+	if err != nil {
+		return err
+	}
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		return fmt.Errorf("reading the fixture: %w", err)
+	}
+	defer input.Close()
+	result, err := testRules(rules, input, *target)
+	if err != nil {
+		return err
+	}
+	if *update {
+		return ioutil.WriteFile(*expectPath, result, 0644)
+	}
+
+	expected, err := ioutil.ReadFile(*expectPath)
+	if err != nil {
+		return fmt.Errorf("reading the expected metrics: %w", err)
+	}
+	diff, err := diffMetrics(expected, result)
+	if err != nil {
+		return err
+	}
+	for _, d := range diff {
+		fmt.Println(d)
+	}
+	if len(diff) > 0 {
+		return fmt.Errorf("%d metrics differ from %s", len(diff), *expectPath)
+	}
+	fmt.Fprintf(os.Stderr, "the metrics match %s\n", *expectPath)
+	return nil
+}
+
+// testRules parses the fixture as scraped from the target with the given
+// name, applies the rules and returns the resulting metrics as a JSON array,
+// in the format of the expected metrics.
+func testRules(rules []integration.ProcessingRule, input io.Reader, target string) ([]byte, error) {
+	metrics, err := integration.ParseMetrics(input, target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the fixture: %w", err)
+	}
+	pair := integration.TargetMetrics{Target: endpoints.Target{Name: target}, Metrics: metrics}
+	integration.NewRuleSet(rules).Apply(&pair)
+
+	lines, err := metricLines(pair.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	result := []byte("[\n")
+	for i, l := range lines {
+		result = append(result, "  "...)
+		result = append(result, l...)
+		if i < len(lines)-1 {
+			result = append(result, ',')
+		}
+		result = append(result, '\n')
+	}
+	return append(result, "]\n"...), nil
+}
+
+// diffMetrics returns the expected metrics missing from the actual ones,
+// prefixed by -, and the unexpected ones, prefixed by +. Both are JSON arrays
+// of metrics.
+func diffMetrics(expected, actual []byte) ([]string, error) {
+	var expectedMetrics, actualMetrics []interface{}
+	if err := json.Unmarshal(expected, &expectedMetrics); err != nil {
+		return nil, fmt.Errorf("parsing the expected metrics: %w", err)
+	}
+	if err := json.Unmarshal(actual, &actualMetrics); err != nil {
+		return nil, err
+	}
+	expectedLines, err := metricLines(expectedMetrics)
+	if err != nil {
+		return nil, err
+	}
+	actualLines, err := metricLines(actualMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]int, len(actualLines))
+	for _, l := range actualLines {
+		remaining[l]++
+	}
+	var diff []string
+	for _, l := range expectedLines {
+		if remaining[l] > 0 {
+			remaining[l]--
+			continue
+		}
+		diff = append(diff, "- "+l)
+	}
+	for _, l := range actualLines {
+		if remaining[l] > 0 {
+			remaining[l]--
+			diff = append(diff, "+ "+l)
+		}
+	}
+	return diff, nil
+}
+
+// metricLines returns the metrics marshaled as JSON, sorted. The attributes
+// are sorted by name, so equal metrics have equal lines.
+func metricLines(metrics interface{}) ([]string, error) {
+	marshaled, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling the metrics: %w", err)
+	}
+	var generic []interface{}
+	if err := json.Unmarshal(marshaled, &generic); err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0, len(generic))
+	for _, m := range generic {
+		l, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(l))
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+const testRulesFixture = `# TYPE temperature gauge
+temperature{room="kitchen"} 21.5
+# TYPE go_goroutines gauge
+go_goroutines 8
+`
+
+func TestTestRules(t *testing.T) {
+	rules := []integration.ProcessingRule{{
+		IgnoreMetrics: []integration.IgnoreRule{{Prefixes: []string{"go_"}}},
+		AddAttributes: []integration.AddAttributesRule{{MetricPrefix: "temperature", Attributes: map[string]interface{}{"unit": "celsius"}}},
+	}}
+	result, err := testRules(rules, strings.NewReader(testRulesFixture), "fixture")
+	require.NoError(t, err)
+
+	expected := `[{"name": "temperature", "type": "gauge", "value": 21.5,
+	  "attributes": {"room": "kitchen", "unit": "celsius", "targetName": "fixture", "nrMetricType": "gauge", "promMetricType": "gauge"}}]`
+	diff, err := diffMetrics([]byte(expected), result)
+	require.NoError(t, err)
+	assert.Empty(t, diff, string(result))
+
+	diff, err = diffMetrics([]byte(strings.Replace(expected, "21.5", "20", 1)), result)
+	require.NoError(t, err)
+	require.Len(t, diff, 2)
+	assert.True(t, strings.HasPrefix(diff[0], "- "))
+	assert.Contains(t, diff[0], `"value":20`)
+	assert.True(t, strings.HasPrefix(diff[1], "+ "))
+	assert.Contains(t, diff[1], `"value":21.5`)
+}
+
+func TestRunTestRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	rules := write("rules.yaml", "transformations:\n  - ignore_metrics:\n      - prefixes: [go_]\n")
+	input := write("fixture.prom", testRulesFixture)
+	expect := filepath.Join(dir, "expected.json")
+
+	require.NoError(t, runTestRules([]string{"--rules", rules, "--input", input, "--expect", expect, "--update"}))
+	require.NoError(t, runTestRules([]string{"--rules", rules, "--input", input, "--expect", expect}))
+
+	write("fixture.prom", testRulesFixture+"temperature{room=\"garage\"} 12\n")
+	assert.EqualError(t, runTestRules([]string{"--rules", rules, "--input", input, "--expect", expect}),
+		"1 metrics differ from "+expect)
+}
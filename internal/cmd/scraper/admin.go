@@ -0,0 +1,370 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminAPIConfig configures the API to change the targets and rules while the
+// integration is running, served under /admin/ by the metrics server, along
+// with the scrapes on demand of /debug/scrape.
+type AdminAPIConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Token is the bearer token the requests must be authenticated with.
+	Token Secret `mapstructure:"token"`
+	// TokenFile is a file with the token, read when Token is empty.
+	TokenFile string `mapstructure:"token_file"`
+}
+
+// Validate returns an error if the API is enabled without a token, reading
+// it from the TokenFile if needed.
+func (c *AdminAPIConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Token == "" && c.TokenFile != "" {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return fmt.Errorf("reading token_file: %w", err)
+		}
+		c.Token = Secret(strings.TrimSpace(string(token)))
+	}
+	if c.Token == "" {
+		return fmt.Errorf("token or token_file is required")
+	}
+	return nil
+}
+
+// Secret is a credential that is masked when printed using standard formatters.
+type Secret string
+
+// String masks the secret.
+func (s Secret) String() string {
+	return maskedLicenseKey
+}
+
+// GoString masks the secret.
+func (s Secret) GoString() string {
+	return maskedLicenseKey
+}
+
+// adminAPI is the http.Handler of the admin API.
+type adminAPI struct {
+	token      string
+	retrievers []endpoints.TargetRetriever
+	static     endpoints.TargetEditor
+	paused     *integration.PausedTargets
+	rules      *integration.ReloadableRuleSet
+	// shadow evaluates the candidate rules, if any.
+	shadow *integration.ShadowRules
+	// fetcher scrapes the targets on demand.
+	fetcher integration.Fetcher
+	// loadRules returns the processing rules of the current configuration.
+	loadRules func() ([]integration.ProcessingRule, error)
+
+	reloadMtx sync.Mutex
+	mux       *http.ServeMux
+}
+
+// adminTarget is a target listed by the admin API.
+type adminTarget struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Retriever string `json:"retriever"`
+	Paused    bool   `json:"paused"`
+}
+
+// scrapedTarget is the result of a scrape on demand.
+type scrapedTarget struct {
+	adminTarget
+	Metrics []integration.Metric `json:"metrics"`
+}
+
+// adminTargetRequest is the body of the requests adding static targets.
+type adminTargetRequest struct {
+	URLs            []string `json:"urls"`
+	MetricNamespace string   `json:"metric_namespace"`
+	Priority        string   `json:"priority"`
+}
+
+func newAdminAPI(
+	token string,
+	retrievers []endpoints.TargetRetriever,
+	static endpoints.TargetEditor,
+	paused *integration.PausedTargets,
+	rules *integration.ReloadableRuleSet,
+	shadow *integration.ShadowRules,
+	fetcher integration.Fetcher,
+	loadRules func() ([]integration.ProcessingRule, error),
+) *adminAPI {
+	a := &adminAPI{
+		token:      token,
+		retrievers: retrievers,
+		static:     static,
+		paused:     paused,
+		rules:      rules,
+		shadow:     shadow,
+		fetcher:    fetcher,
+		loadRules:  loadRules,
+		mux:        http.NewServeMux(),
+	}
+	a.mux.HandleFunc("/admin/targets", a.targets)
+	a.mux.HandleFunc("/admin/targets/pause", a.pause)
+	a.mux.HandleFunc("/admin/targets/resume", a.resume)
+	a.mux.HandleFunc("/admin/scrape", a.scrape)
+	a.mux.HandleFunc("/admin/rules/reload", a.reloadRules)
+	a.mux.HandleFunc("/admin/rules/promote", a.promoteRules)
+	a.mux.HandleFunc("/debug/scrape", a.debugScrape)
+	return a
+}
+
+// ServeHTTP authenticates the request and routes it to the operation.
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// targets lists all the targets on GET, adds static targets on POST and
+// removes the static targets with the name or URL of the target parameter
+// on DELETE.
+func (a *adminAPI) targets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		all, err := a.allTargets()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		targets := make([]adminTarget, 0, len(all))
+		for i := range all {
+			targets = append(targets, a.describe(&all[i]))
+		}
+		writeJSON(w, http.StatusOK, targets)
+	case http.MethodPost:
+		var req adminTargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.URLs) == 0 {
+			http.Error(w, "urls is required", http.StatusBadRequest)
+			return
+		}
+		cfg := endpoints.TargetConfig{Description: "added by the admin API", Priority: req.Priority}
+		for _, u := range req.URLs {
+			cfg.URLs = append(cfg.URLs, endpoints.TargetURL{URL: u, MetricNamespace: req.MetricNamespace})
+		}
+		added, err := a.static.AddTargets(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		targets := make([]adminTarget, 0, len(added))
+		for i := range added {
+			targets = append(targets, adminTarget{Name: added[i].Name, URL: added[i].URL.String(), Retriever: "fixed"})
+		}
+		logrus.WithField("targets", req.URLs).Info("static targets added through the admin API")
+		writeJSON(w, http.StatusCreated, targets)
+	case http.MethodDelete:
+		ref, ok := targetParam(w, r)
+		if !ok {
+			return
+		}
+		if a.static.RemoveTargets(ref) == 0 {
+			http.Error(w, fmt.Sprintf("no static target %q", ref), http.StatusNotFound)
+			return
+		}
+		logrus.WithField("target", ref).Info("static target removed through the admin API")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// pause stops scraping the targets with the name or URL of the target
+// parameter until they are resumed.
+func (a *adminAPI) pause(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	ref, ok := targetParam(w, r)
+	if !ok {
+		return
+	}
+	a.paused.Pause(ref)
+	logrus.WithField("target", ref).Info("target paused through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resume scrapes again the paused targets.
+func (a *adminAPI) resume(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	ref, ok := targetParam(w, r)
+	if !ok {
+		return
+	}
+	if !a.paused.Resume(ref) {
+		http.Error(w, fmt.Sprintf("target %q is not paused", ref), http.StatusNotFound)
+		return
+	}
+	logrus.WithField("target", ref).Info("target resumed through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scrape starts a scrape cycle without waiting for the scrape interval.
+func (a *adminAPI) scrape(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	integration.TriggerScrape()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reloadRules replaces the processing rules with the ones of the current
+// configuration file.
+func (a *adminAPI) reloadRules(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if a.loadRules == nil {
+		http.Error(w, "reloading the rules is not supported", http.StatusNotImplemented)
+		return
+	}
+	a.reloadMtx.Lock()
+	defer a.reloadMtx.Unlock()
+	rules, err := a.loadRules()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("loading the rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	a.rules.Reload(rules)
+	logrus.Info("processing rules reloaded through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// promoteRules replaces the active processing rules with the candidate ones.
+func (a *adminAPI) promoteRules(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if a.shadow == nil {
+		http.Error(w, integration.ErrNoCandidateRules.Error(), http.StatusNotFound)
+		return
+	}
+	a.reloadMtx.Lock()
+	defer a.reloadMtx.Unlock()
+	if err := a.shadow.Promote(); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logrus.Info("candidate processing rules promoted through the admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// debugScrape scrapes the targets with the name or URL of the target
+// parameter and returns their metrics after applying the processing rules,
+// without emitting them. Paused targets are scraped too.
+func (a *adminAPI) debugScrape(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	ref, ok := targetParam(w, r)
+	if !ok {
+		return
+	}
+	all, err := a.allTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	scraped := []scrapedTarget{}
+	for i := range all {
+		if !all[i].Matches(ref) {
+			continue
+		}
+		metrics, ok := integration.ScrapeTarget(r.Context(), all[i], a.fetcher, integration.ReloadableRuleProcessor(a.rules, defaultQueueLength))
+		if !ok {
+			http.Error(w, fmt.Sprintf("could not scrape %s, see the integration logs", all[i].URL.String()), http.StatusBadGateway)
+			return
+		}
+		scraped = append(scraped, scrapedTarget{adminTarget: a.describe(&all[i]), Metrics: metrics})
+	}
+	if len(scraped) == 0 {
+		http.Error(w, fmt.Sprintf("no target %q", ref), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, scraped)
+}
+
+// allTargets returns the current targets of all the retrievers.
+func (a *adminAPI) allTargets() ([]endpoints.Target, error) {
+	var targets []endpoints.Target
+	for _, retriever := range a.retrievers {
+		t, err := retriever.GetTargets()
+		if err != nil {
+			return nil, fmt.Errorf("getting the targets of %s: %w", retriever.Name(), err)
+		}
+		for i := range t {
+			if t[i].Retriever == "" {
+				t[i].Retriever = retriever.Name()
+			}
+		}
+		targets = append(targets, t...)
+	}
+	return targets, nil
+}
+
+func (a *adminAPI) describe(t *endpoints.Target) adminTarget {
+	return adminTarget{
+		Name:      t.Name,
+		URL:       t.URL.String(),
+		Retriever: t.Retriever,
+		Paused:    a.paused.Paused(t),
+	}
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func targetParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ref := r.URL.Query().Get("target")
+	if ref == "" {
+		http.Error(w, "the target parameter is required", http.StatusBadRequest)
+		return "", false
+	}
+	return ref, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("encoding the response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func newTestAdminAPI(t *testing.T, loadRules func() ([]integration.ProcessingRule, error)) (*adminAPI, *integration.PausedTargets) {
+	t.Helper()
+	fixed, err := endpoints.FixedRetriever(endpoints.TargetConfig{URLs: []endpoints.TargetURL{{URL: "http://noisy:9100/metrics"}}})
+	require.NoError(t, err)
+	paused := integration.NewPausedTargets()
+	rules := integration.NewReloadableRuleSet(nil)
+	fetcher := integration.NewFetcher(time.Second, time.Second, 4, "", "", false, defaultQueueLength)
+	return newAdminAPI("s3cr3t", []endpoints.TargetRetriever{fixed}, fixed.(endpoints.TargetEditor), paused, rules, nil, fetcher, loadRules), paused
+}
+
+func adminRequest(api http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func listAdminTargets(t *testing.T, api http.Handler) []adminTarget {
+	t.Helper()
+	w := adminRequest(api, http.MethodGet, "/admin/targets", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var targets []adminTarget
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &targets))
+	return targets
+}
+
+func TestAdminAPI_RequiresToken(t *testing.T) {
+	api, _ := newTestAdminAPI(t, nil)
+
+	for _, header := range []string{"", "Bearer wrong"} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/targets", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+	}
+}
+
+func TestAdminAPI_Targets(t *testing.T) {
+	api, _ := newTestAdminAPI(t, nil)
+
+	w := adminRequest(api, http.MethodPost, "/admin/targets", `{"urls": ["other:8080"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	targets := listAdminTargets(t, api)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "http://noisy:9100/metrics", targets[0].URL)
+	assert.Equal(t, adminTarget{Name: "other:8080", URL: "http://other:8080/metrics", Retriever: "fixed"}, targets[1])
+
+	w = adminRequest(api, http.MethodDelete, "/admin/targets?target=other:8080", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Len(t, listAdminTargets(t, api), 1)
+
+	w = adminRequest(api, http.MethodDelete, "/admin/targets?target=other:8080", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = adminRequest(api, http.MethodPost, "/admin/targets", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminAPI_PauseResume(t *testing.T) {
+	api, paused := newTestAdminAPI(t, nil)
+
+	w := adminRequest(api, http.MethodPost, "/admin/targets/pause?target=http://noisy:9100/metrics", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"http://noisy:9100/metrics"}, paused.List())
+	assert.True(t, listAdminTargets(t, api)[0].Paused)
+
+	w = adminRequest(api, http.MethodPost, "/admin/targets/resume?target=http://noisy:9100/metrics", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, listAdminTargets(t, api)[0].Paused)
+
+	w = adminRequest(api, http.MethodPost, "/admin/targets/resume?target=http://noisy:9100/metrics", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = adminRequest(api, http.MethodGet, "/admin/targets/pause?target=noisy:9100", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAdminAPI_ReloadRules(t *testing.T) {
+	api, _ := newTestAdminAPI(t, nil)
+	w := adminRequest(api, http.MethodPost, "/admin/rules/reload", "")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	api, _ = newTestAdminAPI(t, func() ([]integration.ProcessingRule, error) {
+		return nil, errors.New("invalid configuration")
+	})
+	w = adminRequest(api, http.MethodPost, "/admin/rules/reload", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	api, _ = newTestAdminAPI(t, func() ([]integration.ProcessingRule, error) {
+		return []integration.ProcessingRule{{IgnoreMetrics: []integration.IgnoreRule{{Prefixes: []string{"go_"}}}}}, nil
+	})
+	w = adminRequest(api, http.MethodPost, "/admin/rules/reload", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	pair := integration.TargetMetrics{Metrics: []integration.Metric{
+		integration.NewGaugeMetric("go_goroutines", 1, labels.Set{}),
+		integration.NewGaugeMetric("up", 1, labels.Set{}),
+	}}
+	api.rules.Apply(&pair)
+	require.Len(t, pair.Metrics, 1)
+	assert.Equal(t, "up", pair.Metrics[0].Name())
+}
+
+func TestAdminAPI_PromoteRules(t *testing.T) {
+	api, _ := newTestAdminAPI(t, nil)
+	w := adminRequest(api, http.MethodPost, "/admin/rules/promote", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	f, err := ioutil.TempFile("", "candidate-rules*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("transformations:\n  - ignore_metrics:\n      - prefixes: [go_]\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	candidate, err := loadCandidateRules(&Config{CandidateRulesFile: f.Name(), DisableKubernetes: true})
+	require.NoError(t, err)
+	// The candidate rules are followed by the default ones.
+	require.Len(t, candidate, 2)
+	api.shadow = integration.NewShadowRules(api.rules, candidate)
+
+	w = adminRequest(api, http.MethodPost, "/admin/rules/promote", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	pair := integration.TargetMetrics{Metrics: []integration.Metric{
+		integration.NewGaugeMetric("go_goroutines", 1, labels.Set{}),
+	}}
+	api.rules.Apply(&pair)
+	assert.Empty(t, pair.Metrics)
+
+	_, err = loadCandidateRules(&Config{CandidateRulesFile: f.Name() + ".missing"})
+	assert.Error(t, err)
+}
+
+func TestAdminAPI_DebugScrape(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("go_goroutines 8\nup 1\n"))
+	}))
+	defer target.Close()
+
+	api, paused := newTestAdminAPI(t, nil)
+	api.rules.Reload([]integration.ProcessingRule{{IgnoreMetrics: []integration.IgnoreRule{{Prefixes: []string{"go_"}}}}})
+	w := adminRequest(api, http.MethodPost, "/admin/targets", `{"urls": ["`+target.URL+`"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	// Paused targets can be scraped on demand.
+	paused.Pause(target.URL + "/metrics")
+
+	w = adminRequest(api, http.MethodPost, "/debug/scrape?target="+target.URL+"/metrics", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var scraped []struct {
+		URL     string `json:"url"`
+		Paused  bool   `json:"paused"`
+		Metrics []struct {
+			Name  string  `json:"name"`
+			Value float64 `json:"value"`
+		} `json:"metrics"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &scraped))
+	require.Len(t, scraped, 1)
+	assert.True(t, scraped[0].Paused)
+	require.Len(t, scraped[0].Metrics, 1)
+	assert.Equal(t, "up", scraped[0].Metrics[0].Name)
+	assert.Equal(t, 1.0, scraped[0].Metrics[0].Value)
+
+	w = adminRequest(api, http.MethodPost, "/debug/scrape?target=unknown:9100", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = adminRequest(api, http.MethodPost, "/debug/scrape?target=noisy:9100", "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestAdminAPIConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&AdminAPIConfig{}).Validate())
+	assert.Error(t, (&AdminAPIConfig{Enabled: true}).Validate())
+
+	f, err := ioutil.TempFile("", "admin-token")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("from-file\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cfg := AdminAPIConfig{Enabled: true, TokenFile: f.Name()}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, Secret("from-file"), cfg.Token)
+	assert.Equal(t, maskedLicenseKey, cfg.Token.String())
+}
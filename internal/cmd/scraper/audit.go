@@ -0,0 +1,35 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// AuditDigestConfig configures the log of the digest of each payload sent by
+// the telemetry emitter, to verify later what was sent.
+type AuditDigestConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// HMACKey signs the digests with HMAC-SHA256 when it's set.
+	HMACKey Secret `mapstructure:"hmac_key"`
+	// HMACKeyFile is a file with the key, read when HMACKey is empty.
+	HMACKeyFile string `mapstructure:"hmac_key_file"`
+}
+
+// Validate reads the key from the HMACKeyFile if needed.
+func (c *AuditDigestConfig) Validate() error {
+	if !c.Enabled || c.HMACKey != "" || c.HMACKeyFile == "" {
+		return nil
+	}
+	key, err := ioutil.ReadFile(c.HMACKeyFile)
+	if err != nil {
+		return fmt.Errorf("reading hmac_key_file: %w", err)
+	}
+	c.HMACKey = Secret(strings.TrimSpace(string(key)))
+	if c.HMACKey == "" {
+		return fmt.Errorf("hmac_key_file %s is empty", c.HMACKeyFile)
+	}
+	return nil
+}
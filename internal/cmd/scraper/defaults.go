@@ -0,0 +1,129 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"compress/gzip"
+	"fmt"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+const (
+	windowsDefinitionPath = "C:\\Program Files\\New Relic\\newrelic-infra\\definition-files"
+	linuxDefinitionPath   = "/etc/newrelic-infra/definition-files"
+)
+
+var (
+	regionLicenseRegex = regexp.MustCompile(`^([a-z]{2,3})[0-9]{2}x{1,2}`)
+	metricAPIRegionURL = "https://metric-api.%s.newrelic.com/metric/v1/infra"
+	// for historical reasons the US datacenter is the default Metric API
+	defaultMetricAPIURL = "https://metric-api.newrelic.com/metric/v1/infra"
+	euEventAPIURL       = "https://insights-collector.eu01.nr-data.net/v1/accounts/events"
+)
+
+// Defaults returns the default settings, by config key, of the integration
+// and of the programs embedding the scraper.
+func Defaults() map[string]interface{} {
+	return map[string]interface{}{
+		"debug":                                  false,
+		"verbose":                                false,
+		"audit":                                  false,
+		"scrape_enabled_label":                   "prometheus.io/scrape",
+		"require_scrape_enabled_label_for_nodes": true,
+		"scrape_timeout":                         5 * time.Second,
+		"scrape_duration":                        "30s",
+		"emitter_harvest_period":                 fmt.Sprint(integration.BoundedHarvesterDefaultHarvestPeriod),
+		"min_emitter_harvest_period":             fmt.Sprint(integration.BoundedHarvesterDefaultMinReportInterval),
+		"max_stored_metrics":                     fmt.Sprint(integration.BoundedHarvesterDefaultMetricsCap),
+		"auto_decorate":                          false,
+		"insecure_skip_verify":                   false,
+		"standalone":                             true,
+		"disable_autodiscovery":                  false,
+		"worker_threads":                         4,
+		"disable_license_key_check":              false,
+		"gc_percent":                             0,
+		"memory_limit":                           "",
+		"memory_watermark":                       0,
+		"emitter_compression":                    "gzip",
+		"emitter_compression_level":              gzip.DefaultCompression,
+		"emitter_common_attributes":              false,
+		"promote_target_info":                    false,
+		"spill_dir":                              "",
+		"spill_max_size":                         "1Gi",
+		"max_payload_size":                       "",
+		"queue_length":                           100,
+		"shutdown_timeout":                       DefaultShutdownTimeout,
+	}
+}
+
+// NewConfig returns the configuration with the default settings, the one of
+// the integration without any configured setting.
+func NewConfig() (*Config, error) {
+	v := viper.New()
+	for key, value := range Defaults() {
+		v.SetDefault(key, value)
+	}
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("decoding the default configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+// CompleteConfig sets the settings whose defaults depend on other ones: the
+// emitters, by the standalone mode, and the URLs of the APIs, by the region
+// of the license key.
+func CompleteConfig(cfg *Config) {
+	if len(cfg.Emitters) == 0 {
+		if cfg.Standalone {
+			cfg.Emitters = append(cfg.Emitters, "telemetry")
+		} else {
+			cfg.Emitters = append(cfg.Emitters, "infra-sdk")
+		}
+	}
+
+	if cfg.DefinitionFilesPath == "" {
+		if runtime.GOOS == "windows" {
+			cfg.DefinitionFilesPath = windowsDefinitionPath
+		} else {
+			cfg.DefinitionFilesPath = linuxDefinitionPath
+		}
+	}
+
+	if cfg.MetricAPIURL == "" {
+		cfg.MetricAPIURL = determineMetricAPIURL(string(cfg.LicenseKey))
+	}
+
+	if cfg.EventAPIURL == "" {
+		cfg.EventAPIURL = determineEventAPIURL(string(cfg.LicenseKey))
+	}
+}
+
+// determineMetricAPIURL determines the Metric API URL based on the license key.
+// The first 5 characters of the license URL indicates the region.
+func determineMetricAPIURL(license string) string {
+	m := regionLicenseRegex.FindStringSubmatch(license)
+	if len(m) > 1 {
+		return fmt.Sprintf(metricAPIRegionURL, m[1])
+	}
+
+	return defaultMetricAPIURL
+}
+
+// determineEventAPIURL determines the Event API URL based on the license key.
+// An empty URL means the default one of the telemetry SDK, for the US
+// datacenter.
+func determineEventAPIURL(license string) string {
+	m := regionLicenseRegex.FindStringSubmatch(license)
+	if len(m) > 1 && m[1] == "eu" {
+		return euEventAPIURL
+	}
+
+	return ""
+}
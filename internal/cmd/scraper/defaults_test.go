@@ -0,0 +1,67 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfig(t *testing.T) {
+	cfg, err := NewConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "30s", cfg.ScrapeDuration)
+	assert.Equal(t, 5*time.Second, cfg.ScrapeTimeout)
+	assert.Equal(t, 4, cfg.WorkerThreads)
+	assert.Equal(t, 100, cfg.QueueLength)
+	assert.True(t, cfg.Standalone)
+	assert.Equal(t, DefaultShutdownTimeout, cfg.ShutdownTimeout)
+	cfg.ClusterName, cfg.LicenseKey = "test", "key"
+	require.NoError(t, validateConfig(cfg))
+
+	CompleteConfig(cfg)
+	assert.Equal(t, []string{"telemetry"}, cfg.Emitters)
+	assert.Equal(t, defaultMetricAPIURL, cfg.MetricAPIURL)
+
+	cfg, err = NewConfig()
+	require.NoError(t, err)
+	cfg.Standalone = false
+	cfg.LicenseKey = "eu01xx6789012345678901234567890123456789"
+	CompleteConfig(cfg)
+	assert.Equal(t, []string{"infra-sdk"}, cfg.Emitters)
+	assert.Equal(t, fmt.Sprintf(metricAPIRegionURL, "eu"), cfg.MetricAPIURL)
+	assert.Equal(t, euEventAPIURL, cfg.EventAPIURL)
+}
+
+func TestDetermineMetricAPIURL(t *testing.T) {
+	testCases := []struct {
+		license     string
+		expectedURL string
+	}{
+		// empty license
+		{license: "", expectedURL: defaultMetricAPIURL},
+		// non-region license
+		{license: "0123456789012345678901234567890123456789", expectedURL: defaultMetricAPIURL},
+		// four letter region
+		{license: "eu01xx6789012345678901234567890123456789", expectedURL: fmt.Sprintf(metricAPIRegionURL, "eu")},
+		// five letter region
+		{license: "gov01x6789012345678901234567890123456789", expectedURL: fmt.Sprintf(metricAPIRegionURL, "gov")},
+	}
+
+	for _, tt := range testCases {
+		actualURL := determineMetricAPIURL(tt.license)
+		if actualURL != tt.expectedURL {
+			t.Fatalf("URL does not match expected URL, got=%s, expected=%s", actualURL, tt.expectedURL)
+		}
+	}
+}
+
+func TestDetermineEventAPIURL(t *testing.T) {
+	assert.Equal(t, "", determineEventAPIURL(""))
+	assert.Equal(t, "", determineEventAPIURL("0123456789012345678901234567890123456789"))
+	assert.Equal(t, euEventAPIURL, determineEventAPIURL("eu01xx6789012345678901234567890123456789"))
+}
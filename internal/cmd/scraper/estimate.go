@@ -0,0 +1,50 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// Estimate discovers and scrapes the targets once and writes to w a report of
+// the series, datapoints per minute and estimated ingest of their metrics,
+// without emitting them.
+func Estimate(cfg *Config, w io.Writer) error {
+	// Nothing is emitted, so the license key isn't required.
+	cfg.Standalone = false
+	if err := validateConfig(cfg); err != nil {
+		return NewConfigError(fmt.Errorf("while getting configuration options: %w", err))
+	}
+	retrievers, _, err := newRetrievers(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	scrapeDuration, err := time.ParseDuration(cfg.ScrapeDuration)
+	if err != nil {
+		return NewConfigError(fmt.Errorf(
+			"parsing scrape_duration value (%v): %w",
+			cfg.ScrapeDuration,
+			err,
+		))
+	}
+	fetcherOpts, err := fetcherOptions(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+
+	estimator := integration.NewEstimateEmitter(scrapeDuration)
+	chain, err := newProcessorChain(cfg, defaultProcessingRules(cfg), []integration.Emitter{estimator})
+	if err != nil {
+		return NewConfigError(err)
+	}
+	integration.ExecuteOnce(
+		retrievers,
+		integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength(cfg), fetcherOpts...),
+		chain.processor,
+		[]integration.Emitter{estimator})
+	return estimator.WriteReport(w)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// Classes of the fatal errors, so the tooling running the integration can
+// react differently to each of them.
+const (
+	// ErrorInternal is any error not in another class.
+	ErrorInternal = "internal"
+	// ErrorConfig is an invalid configuration.
+	ErrorConfig = "config"
+	// ErrorAuth is a license key rejected by New Relic.
+	ErrorAuth = "auth"
+	// ErrorBind is a failure serving the integration endpoints, like an
+	// address already in use.
+	ErrorBind = "bind"
+	// ErrorScrape is a target that couldn't be scraped or emitted by a single
+	// scrape cycle run with --once.
+	ErrorScrape = "scrape"
+)
+
+// exitCodes are the exit codes of the error classes.
+var exitCodes = map[string]int{
+	ErrorInternal: 1,
+	ErrorConfig:   2,
+	ErrorAuth:     3,
+	ErrorBind:     4,
+	ErrorScrape:   5,
+}
+
+// Error is a fatal error of a class.
+type Error struct {
+	Class string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewConfigError returns the error classified as a configuration one.
+func NewConfigError(err error) error {
+	return &Error{Class: ErrorConfig, Err: err}
+}
+
+// ErrorClass returns the class of the error. The rejected license keys are
+// always classified as ErrorAuth.
+func ErrorClass(err error) string {
+	if errors.Is(err, integration.ErrLicenseKeyRejected) {
+		return ErrorAuth
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	return ErrorInternal
+}
+
+// ExitCode returns the exit code of the class of the error, or 0 if it's nil.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return exitCodes[ErrorClass(err)]
+}
+
+// errorSummary is the machine-readable summary of a fatal error.
+type errorSummary struct {
+	Time     time.Time `json:"time"`
+	Class    string    `json:"class"`
+	ExitCode int       `json:"exitCode"`
+	Message  string    `json:"message"`
+	Error    string    `json:"error"`
+	Version  string    `json:"version"`
+}
+
+// WriteErrorSummary writes the summary of the fatal error as a line of JSON
+// to w, with the given message of what the integration was doing. It returns
+// the exit code of the error.
+func WriteErrorSummary(w io.Writer, message string, err error) int {
+	summary := errorSummary{
+		Time:     time.Now().UTC(),
+		Class:    ErrorClass(err),
+		ExitCode: ExitCode(err),
+		Message:  message,
+		Error:    err.Error(),
+		Version:  integration.Version,
+	}
+	_ = json.NewEncoder(w).Encode(summary)
+	return summary.ExitCode
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+		code  int
+	}{
+		{fmt.Errorf("boom"), ErrorInternal, 1},
+		{NewConfigError(fmt.Errorf("invalid")), ErrorConfig, 2},
+		{fmt.Errorf("checking: %w", fmt.Errorf("%w: 403 Forbidden", integration.ErrLicenseKeyRejected)), ErrorAuth, 3},
+		{fmt.Errorf("running: %w", &Error{Class: ErrorBind, Err: fmt.Errorf("address already in use")}), ErrorBind, 4},
+		{&Error{Class: ErrorScrape, Err: fmt.Errorf("1 of 2 targets scraped")}, ErrorScrape, 5},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, ErrorClass(tt.err), tt.err.Error())
+		assert.Equal(t, tt.code, ExitCode(tt.err), tt.err.Error())
+	}
+	assert.Equal(t, 0, ExitCode(nil))
+}
+
+func TestWriteErrorSummary(t *testing.T) {
+	var out bytes.Buffer
+	code := WriteErrorSummary(&out, "while loading configuration", NewConfigError(fmt.Errorf("unknown key")))
+	assert.Equal(t, 2, code)
+
+	var summary map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &summary))
+	assert.Equal(t, "config", summary["class"])
+	assert.Equal(t, float64(2), summary["exitCode"])
+	assert.Equal(t, "while loading configuration", summary["message"])
+	assert.Equal(t, "unknown key", summary["error"])
+	assert.Equal(t, integration.Version, summary["version"])
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// scrapedByHeader identifies the instance of the integration scraping a
+// target.
+const scrapedByHeader = "X-Scraped-By"
+
+// ScrapeIdentificationConfig configures the headers identifying the
+// integration in the scrape requests, so the owners of the targets can
+// recognize and rate-limit its traffic, and firewalls can allow it.
+type ScrapeIdentificationConfig struct {
+	// UserAgent of the scrape requests. Defaults to nri-prometheus/<version>.
+	UserAgent string `mapstructure:"user_agent"`
+	// ScrapedBy sends the X-Scraped-By header with the identity of the
+	// instance scraping the target.
+	ScrapedBy bool `mapstructure:"scraped_by"`
+	// Instance identifies the instance in the X-Scraped-By header. Defaults
+	// to the host name, which is the pod name in Kubernetes.
+	Instance string `mapstructure:"instance"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *ScrapeIdentificationConfig) Validate() error {
+	if c.UserAgent == "" {
+		c.UserAgent = integration.DefaultUserAgent()
+	}
+	if strings.ContainsAny(c.UserAgent+c.Instance, "\r\n") {
+		return fmt.Errorf("user_agent and instance can't contain line breaks")
+	}
+	if c.ScrapedBy && c.Instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("instance is required when the host name is unknown: %w", err)
+		}
+		c.Instance = hostname
+	}
+	return nil
+}
+
+// scrapeHeaders returns the headers identifying the integration in the scrape
+// requests. X-Scraped-By has the instance, and the cluster and node it runs
+// in when they are known, e.g. "nri-prometheus; instance=nri-prometheus-x7k2p;
+// cluster=production; node=node-1".
+func scrapeHeaders(cfg *Config) http.Header {
+	headers := http.Header{}
+	headers.Set("User-Agent", cfg.ScrapeIdentification.UserAgent)
+	if !cfg.ScrapeIdentification.ScrapedBy {
+		return headers
+	}
+	identity := []string{integration.Name, "instance=" + cfg.ScrapeIdentification.Instance}
+	if !cfg.DisableKubernetes && cfg.ClusterName != "" {
+		identity = append(identity, "cluster="+cfg.ClusterName)
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		identity = append(identity, "node="+node)
+	}
+	headers.Set(scrapedByHeader, strings.Join(identity, "; "))
+	return headers
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+func TestScrapeHeaders(t *testing.T) {
+	defer os.Setenv("NODE_NAME", os.Getenv("NODE_NAME"))
+	require.NoError(t, os.Setenv("NODE_NAME", "node-1"))
+
+	cfg := &Config{ClusterName: "production"}
+	require.NoError(t, cfg.ScrapeIdentification.Validate())
+	headers := scrapeHeaders(cfg)
+	assert.Equal(t, integration.DefaultUserAgent(), headers.Get("User-Agent"))
+	assert.Empty(t, headers.Get(scrapedByHeader), "X-Scraped-By isn't sent by default")
+
+	cfg.ScrapeIdentification = ScrapeIdentificationConfig{UserAgent: "acme-monitoring", ScrapedBy: true, Instance: "nri-prometheus-x7k2p"}
+	require.NoError(t, cfg.ScrapeIdentification.Validate())
+	headers = scrapeHeaders(cfg)
+	assert.Equal(t, "acme-monitoring", headers.Get("User-Agent"))
+	assert.Equal(t, "nri-prometheus; instance=nri-prometheus-x7k2p; cluster=production; node=node-1", headers.Get(scrapedByHeader))
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	cfg.ScrapeIdentification = ScrapeIdentificationConfig{ScrapedBy: true}
+	require.NoError(t, cfg.ScrapeIdentification.Validate())
+	assert.Equal(t, hostname, cfg.ScrapeIdentification.Instance)
+
+	assert.Error(t, (&ScrapeIdentificationConfig{UserAgent: "a\r\nX-Injected: b"}).Validate())
+}
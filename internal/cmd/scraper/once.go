@@ -0,0 +1,104 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// RunCycleWithEmitters discovers, scrapes and emits the targets once, with
+// the preselected emitters, for the usage from cron jobs. Unlike
+// RunOnceWithEmitters, the Kubernetes targets are discovered too. It returns
+// an error if any target couldn't be discovered, scraped or emitted.
+func RunCycleWithEmitters(cfg *Config, emitters []integration.Emitter) error {
+	if len(emitters) == 0 {
+		return NewConfigError(fmt.Errorf("you need to configure at least one valid emitter"))
+	}
+
+	retrievers, _, err := newRetrievers(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	scrapeDuration, err := time.ParseDuration(cfg.ScrapeDuration)
+	if err != nil {
+		return NewConfigError(fmt.Errorf(
+			"parsing scrape_duration value (%v): %w",
+			cfg.ScrapeDuration,
+			err,
+		))
+	}
+	if !cfg.DisableLicenseKeyCheck {
+		if err := checkEmittersAuth(context.Background(), emitters); err != nil {
+			return err
+		}
+	}
+	fetcherOpts, err := fetcherOptions(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	if cfg.DroppedSampling.Rate > 0 {
+		if err := integration.DefaultDroppedSampler.Configure(cfg.DroppedSampling); err != nil {
+			return NewConfigError(err)
+		}
+	}
+	chain, err := newProcessorChain(cfg, defaultProcessingRules(cfg), emitters)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	result := integration.ExecuteOnce(
+		retrievers,
+		integration.NewSchedulingFetcher(integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength(cfg), fetcherOpts...)),
+		chain.processor,
+		emitters)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+	defer cancel()
+	flushErr := integration.FlushEmitters(ctx, emitters)
+
+	logrus.WithFields(logrus.Fields{
+		"targets":         result.Targets,
+		"scraped":         result.Scraped,
+		"emitErrors":      result.EmitErrors,
+		"retrieverErrors": result.RetrieverErrors,
+	}).Info("scrape cycle finished")
+	if !result.Succeeded() {
+		return &Error{Class: ErrorScrape, Err: fmt.Errorf("%d of %d targets scraped, %d emit errors and %d retriever errors",
+			result.Scraped, result.Targets, result.EmitErrors, result.RetrieverErrors)}
+	}
+	if flushErr != nil {
+		return &Error{Class: ErrorScrape, Err: flushErr}
+	}
+	return nil
+}
+
+// NewCycleRunner validates the configuration and creates its emitters,
+// returning a function that discovers, scrapes and emits the targets with
+// RunCycleWithEmitters each time it's called. The emitters are kept between
+// the cycles, so the counters are emitted from the second one on.
+func NewCycleRunner(cfg *Config) (func() error, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, NewConfigError(fmt.Errorf("while getting configuration options: %w", err))
+	}
+	emitters, err := newEmitters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return RunCycleWithEmitters(cfg, emitters)
+	}, nil
+}
+
+// NewEmitters validates the configuration and creates its emitters, for the
+// programs embedding the scraper that emit the metrics on their own.
+func NewEmitters(cfg *Config) ([]integration.Emitter, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, NewConfigError(fmt.Errorf("while getting configuration options: %w", err))
+	}
+	return newEmitters(cfg)
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// processorChain is the processing of the scraped metrics, with the stages
+// served by the debug endpoints and the admin API, which are nil when they
+// aren't configured.
+type processorChain struct {
+	processor   integration.Processor
+	ruleSet     *integration.ReloadableRuleSet
+	ruleUsage   *integration.RuleUsageTracker
+	shadowRules *integration.ShadowRules
+	snapshots   *integration.SnapshotStore
+}
+
+// newProcessorChain returns the processing of the metrics with the rules and
+// the configured stages. All the modes of the integration process the
+// metrics with it, so what is emitted or printed is always, e.g., redacted.
+func newProcessorChain(cfg *Config, processingRules []integration.ProcessingRule, emitters []integration.Emitter) (processorChain, error) {
+	var chain processorChain
+	chain.ruleSet = integration.NewReloadableRuleSet(processingRules)
+	processor := integration.ReloadableRuleProcessor(chain.ruleSet, queueLength(cfg))
+	if cfg.TargetQueues.Enabled() {
+		processor = integration.ShardedProcessor(cfg.TargetQueues, processor, queueLength(cfg))
+	}
+	if cfg.RuleUsageCycles > 0 {
+		chain.ruleUsage = integration.NewRuleUsageTracker(chain.ruleSet, cfg.RuleUsageCycles)
+		processor = integration.RuleUsageProcessor(chain.ruleUsage, processor, queueLength(cfg))
+	}
+	if cfg.CandidateRulesFile != "" {
+		candidate, err := loadCandidateRules(cfg)
+		if err != nil {
+			return processorChain{}, err
+		}
+		chain.shadowRules = integration.NewShadowRules(chain.ruleSet, candidate)
+		processor = integration.ShadowProcessor(chain.shadowRules, processor, queueLength(cfg))
+	}
+	if cfg.OnlyMetrics.Enabled() {
+		processor = integration.AllowlistProcessor(cfg.OnlyMetrics, processor, queueLength(cfg))
+	}
+	if cfg.AutoDecorate {
+		processor = integration.AutoDecorateProcessor(cfg.AutoDecorateOptions, processor, queueLength(cfg))
+	}
+	if cfg.IngestBudgets.Enabled() {
+		processor = integration.BudgetProcessor(cfg.IngestBudgets, processor, queueLength(cfg))
+	}
+	// The metrics are redacted before any event, snapshot or datapoint
+	// including their attributes is emitted or exposed.
+	if cfg.Redaction.Enabled() {
+		processor = integration.RedactionProcessor(cfg.Redaction, processor, queueLength(cfg))
+	}
+	if len(cfg.ThresholdEvents) > 0 {
+		processor = integration.ThresholdProcessor(cfg.ThresholdEvents, cfg.ThresholdEventType, emitters, processor, queueLength(cfg))
+	}
+	if cfg.TargetSnapshots > 0 {
+		chain.snapshots = integration.NewSnapshotStore(cfg.TargetSnapshots)
+		processor = integration.SnapshotProcessor(chain.snapshots, processor, queueLength(cfg))
+	}
+	if cfg.DeterministicOutput {
+		processor = integration.OrderedProcessor(processor, queueLength(cfg))
+	}
+	chain.processor = processor
+	return chain, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+)
+
+// Profiles of the settings bounding the memory usage.
+const (
+	// ProfileDefault keeps the defaults of each setting.
+	ProfileDefault = "default"
+	// ProfileEdge is tuned for memory-constrained devices, like the 32-bit
+	// and ARM64 boards of edge gateways. The process is kept under 64MiB,
+	// no target is scraped above 80% of it, and payloads larger than 8MiB
+	// are rejected before they are parsed.
+	ProfileEdge = "edge"
+)
+
+// profileDefaults are the default settings of the profiles, by config key.
+var profileDefaults = map[string]map[string]interface{}{
+	ProfileDefault: {},
+	ProfileEdge: {
+		"worker_threads":     2,
+		"queue_length":       10,
+		"max_stored_metrics": 1000,
+		"gc_percent":         50,
+		"memory_limit":       "64Mi",
+		"memory_watermark":   0.8,
+		"max_payload_size":   "8Mi",
+		"spill_max_size":     "64Mi",
+	},
+}
+
+// ProfileDefaults returns the default settings of the profile, by config key,
+// which take precedence over the defaults of each setting, but not over the
+// configured ones. An empty profile is the default one.
+func ProfileDefaults(profile string) (map[string]interface{}, error) {
+	if profile == "" {
+		profile = ProfileDefault
+	}
+	defaults, ok := profileDefaults[profile]
+	if !ok {
+		return nil, fmt.Errorf("invalid profile %q, must be one of: %s, %s", profile, ProfileDefault, ProfileEdge)
+	}
+	return defaults, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/sirupsen/logrus"
+)
+
+// authRetryInterval is the time to wait before verifying again credentials
+// that were rejected.
+var authRetryInterval = time.Minute
+
+// authCheckTimeout bounds the time spent verifying the credentials of an emitter.
+const authCheckTimeout = 10 * time.Second
+
+// readiness is an http.Handler that reports whether the integration is
+// scraping targets and sending their metrics.
+type readiness struct {
+	ready int32
+	// retrievers are checked for the resources whose targets can't be
+	// discovered.
+	retrievers []endpoints.TargetRetriever
+}
+
+func (r *readiness) set(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&r.ready, v)
+}
+
+func (r *readiness) isReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+// degraded returns the resources whose targets can't be discovered.
+func (r *readiness) degraded() []string {
+	var degraded []string
+	for _, retriever := range r.retrievers {
+		if d, ok := retriever.(endpoints.DegradationReporter); ok {
+			degraded = append(degraded, d.Degraded()...)
+		}
+	}
+	return degraded
+}
+
+// ServeHTTP answers 200 when the integration is ready and 503 otherwise. The
+// integration stays ready while some resources can't be discovered, as the
+// last known targets are still scraped, but they are listed in the body.
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if !r.isReady() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if degraded := r.degraded(); len(degraded) > 0 {
+		_, _ = w.Write([]byte("ok, degraded: " + strings.Join(degraded, ", ")))
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+// checkEmittersAuth verifies the credentials of the emitters that support it.
+// It returns an error wrapping integration.ErrLicenseKeyRejected if any of
+// them is rejected. Errors reaching the endpoints are only logged, as they
+// don't prove the credentials are invalid.
+func checkEmittersAuth(ctx context.Context, emitters []integration.Emitter) error {
+	for _, e := range emitters {
+		err := checkEmitterAuth(ctx, e)
+		if errors.Is(err, integration.ErrLicenseKeyRejected) {
+			return err
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("emitter", e.Name()).Warn("could not verify the license key, continuing")
+		}
+	}
+	return nil
+}
+
+// checkEmitterAuth verifies the credentials of the emitter, if it supports
+// it.
+func checkEmitterAuth(ctx context.Context, e integration.Emitter) error {
+	checker, ok := e.(integration.AuthChecker)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, authCheckTimeout)
+	defer cancel()
+	return checker.CheckAuth(ctx)
+}
+
+// waitForEmittersAuth blocks until the credentials of the emitters are
+// accepted. It returns an error wrapping integration.ErrLicenseKeyRejected as
+// soon as they are rejected, as they won't be accepted by checking them
+// again. The emitters whose endpoint can't be reached are verified again
+// every authRetryInterval. It returns the error of the context if it's done
+// before.
+func waitForEmittersAuth(ctx context.Context, emitters []integration.Emitter) error {
+	pending := emitters
+	for {
+		var unreachable []integration.Emitter
+		for _, e := range pending {
+			err := checkEmitterAuth(ctx, e)
+			if errors.Is(err, integration.ErrLicenseKeyRejected) {
+				return err
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("emitter", e.Name()).Warnf(
+					"could not verify the license key, metrics won't be scraped until it is. Checking again in %s",
+					authRetryInterval,
+				)
+				unreachable = append(unreachable, e)
+			}
+		}
+		if len(unreachable) == 0 {
+			return nil
+		}
+		pending = unreachable
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(authRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// RetrieverFactory creates a TargetRetriever with the scraper configuration.
+type RetrieverFactory func(cfg *Config) (endpoints.TargetRetriever, error)
+
+// EmitterFactory creates an Emitter with the scraper configuration.
+type EmitterFactory func(cfg *Config) (integration.Emitter, error)
+
+// registry has the retrievers and emitters registered by the programs
+// embedding the scraper.
+var registry = struct {
+	mtx        sync.Mutex
+	retrievers map[string]RetrieverFactory
+	emitters   map[string]EmitterFactory
+}{
+	retrievers: make(map[string]RetrieverFactory),
+	emitters:   make(map[string]EmitterFactory),
+}
+
+// RegisterRetriever registers a TargetRetriever, whose targets are scraped
+// along with the ones of the built-in retrievers. Registering a name twice
+// replaces the previous factory.
+func RegisterRetriever(name string, factory RetrieverFactory) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	registry.retrievers[name] = factory
+}
+
+// RegisterEmitter registers an Emitter, used when its name is in the
+// emitters of the configuration. The built-in emitters can't be replaced.
+func RegisterEmitter(name string, factory EmitterFactory) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	registry.emitters[name] = factory
+}
+
+// registeredRetrievers creates the registered retrievers, by name.
+func registeredRetrievers(cfg *Config) ([]endpoints.TargetRetriever, error) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	names := make([]string, 0, len(registry.retrievers))
+	for name := range registry.retrievers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	retrievers := make([]endpoints.TargetRetriever, 0, len(names))
+	for _, name := range names {
+		r, err := registry.retrievers[name](cfg)
+		if err != nil {
+			return nil, fmt.Errorf("while creating the %s retriever: %w", name, err)
+		}
+		retrievers = append(retrievers, r)
+	}
+	return retrievers, nil
+}
+
+// registeredEmitter creates the emitter registered with the given name. It
+// returns false if there isn't any.
+func registeredEmitter(name string, cfg *Config) (integration.Emitter, bool, error) {
+	registry.mtx.Lock()
+	factory, ok := registry.emitters[name]
+	registry.mtx.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+	e, err := factory(cfg)
+	if err != nil {
+		return nil, true, fmt.Errorf("while creating the %s emitter: %w", name, err)
+	}
+	return e, true, nil
+}
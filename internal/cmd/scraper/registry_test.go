@@ -0,0 +1,52 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestRegisterRetriever(t *testing.T) {
+	RegisterRetriever("custom", func(cfg *Config) (endpoints.TargetRetriever, error) {
+		return endpoints.FixedRetriever(endpoints.TargetConfig{URLs: []endpoints.TargetURL{{URL: "http://custom:8080/metrics"}}})
+	})
+	defer delete(registry.retrievers, "custom")
+
+	retrievers, _, err := newRetrievers(&Config{DisableKubernetes: true})
+	require.NoError(t, err)
+	require.Len(t, retrievers, 2)
+	targets, err := retrievers[1].GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "http://custom:8080/metrics", targets[0].URL.String())
+
+	RegisterRetriever("failing", func(cfg *Config) (endpoints.TargetRetriever, error) {
+		return nil, errors.New("boom")
+	})
+	defer delete(registry.retrievers, "failing")
+	_, _, err = newRetrievers(&Config{DisableKubernetes: true})
+	assert.Error(t, err)
+}
+
+func TestRegisterEmitter(t *testing.T) {
+	RegisterEmitter("custom", func(cfg *Config) (integration.Emitter, error) {
+		return integration.NewStdoutEmitter(), nil
+	})
+	defer delete(registry.emitters, "custom")
+
+	emitter, ok, err := registeredEmitter("custom", &Config{})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "stdout", emitter.Name())
+
+	_, ok, err = registeredEmitter("unknown", &Config{})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/integration"
+)
+
+// ReplayWithEmitters processes and emits the payloads of the Replay
+// directory, with the preselected emitters, instead of scraping the targets.
+// It returns an error if any payload couldn't be decoded or emitted.
+func ReplayWithEmitters(cfg *Config, emitters []integration.Emitter) error {
+	if len(emitters) == 0 {
+		return NewConfigError(fmt.Errorf("you need to configure at least one valid emitter"))
+	}
+
+	payloads, err := integration.ReplayPayloads(cfg.Replay)
+	if err != nil {
+		return NewConfigError(fmt.Errorf("reading the replayed payloads: %w", err))
+	}
+	if !cfg.DisableLicenseKeyCheck {
+		if err := checkEmittersAuth(context.Background(), emitters); err != nil {
+			return err
+		}
+	}
+	fetcherOpts, err := fetcherOptions(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	chain, err := newProcessorChain(cfg, defaultProcessingRules(cfg), emitters)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	result := integration.Replay(payloads, chain.processor, emitters, queueLength(cfg), fetcherOpts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+	defer cancel()
+	flushErr := integration.FlushEmitters(ctx, emitters)
+
+	logrus.WithFields(logrus.Fields{
+		"payloads":   result.Targets,
+		"decoded":    result.Scraped,
+		"emitErrors": result.EmitErrors,
+	}).Info("replay finished")
+	if !result.Succeeded() {
+		return &Error{Class: ErrorScrape, Err: fmt.Errorf("%d of %d payloads decoded and %d emit errors",
+			result.Scraped, result.Targets, result.EmitErrors)}
+	}
+	if flushErr != nil {
+		return &Error{Class: ErrorScrape, Err: flushErr}
+	}
+	return nil
+}
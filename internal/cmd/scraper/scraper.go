@@ -4,25 +4,40 @@
 package scraper
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/pprof"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
 	"github.com/newrelic/nri-prometheus/internal/integration"
 	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/fips"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+	"github.com/newrelic/nri-prometheus/internal/pkg/memory"
 	"github.com/pkg/errors"
+	promcli "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // Config is the config struct for the scraper.
 type Config struct {
+	// ConfigVersion is the schema version of the configuration file. Unversioned
+	// files are accepted leniently, while versioned ones reject unknown keys.
+	ConfigVersion                     int                          `mapstructure:"version"`
 	MetricAPIURL                      string                       `mapstructure:"metric_api_url"`
+	EventAPIURL                       string                       `mapstructure:"event_api_url"`
 	LicenseKey                        LicenseKey                   `mapstructure:"license_key"`
 	ClusterName                       string                       `mapstructure:"cluster_name"`
 	Debug                             bool                         `mapstructure:"debug"`
@@ -50,15 +65,272 @@ type Config struct {
 	EmitterProxyURL                              *url.URL
 	EmitterCAFile                                string        `mapstructure:"emitter_ca_file"`
 	EmitterInsecureSkipVerify                    bool          `mapstructure:"emitter_insecure_skip_verify" default:"false"`
+	EmitterCompression                           string        `mapstructure:"emitter_compression"`
+	EmitterCompressionLevel                      int           `mapstructure:"emitter_compression_level"`
 	TelemetryEmitterDeltaExpirationAge           time.Duration `mapstructure:"telemetry_emitter_delta_expiration_age"`
 	TelemetryEmitterDeltaExpirationCheckInterval time.Duration `mapstructure:"telemetry_emitter_delta_expiration_check_interval"`
 	DefinitionFilesPath                          string        `mapstructure:"definition_files_path"`
 	WorkerThreads                                int           `mapstructure:"worker_threads"`
 	DisableKubernetes                            bool          `mapstructure:"disable_kubernetes"`
+	// EmitterCommonAttributes moves the attributes shared by the metrics of
+	// a target to the common block of the payloads.
+	EmitterCommonAttributes bool `mapstructure:"emitter_common_attributes"`
+	// LicenseKeyFile is a file the license key is read from instead of
+	// license_key, e.g. mounted from a Kubernetes Secret. It's read again
+	// every LicenseKeyFileInterval, so the key can be rotated without
+	// restarting.
+	LicenseKeyFile string `mapstructure:"license_key_file"`
+	// LicenseKeyFileInterval is how often the license key file is checked
+	// for a new key. Defaults to 1m.
+	LicenseKeyFileInterval time.Duration `mapstructure:"license_key_file_interval"`
+	// DisableLicenseKeyCheck skips the verification of the license key done
+	// before scraping the targets.
+	DisableLicenseKeyCheck bool `mapstructure:"disable_license_key_check"`
+	// GCPercent sets the garbage collection target percentage, as GOGC does.
+	GCPercent int `mapstructure:"gc_percent"`
+	// MemoryLimit is the memory limit of the process, e.g. 512Mi. When empty,
+	// the limit of the container is used.
+	MemoryLimit string `mapstructure:"memory_limit"`
+	// Parsed version of `MemoryLimit`
+	MemoryLimitBytes int64
+	// MemoryWatermark is the fraction of the memory limit above which the low
+	// priority targets are not scraped. Zero disables it.
+	MemoryWatermark float64 `mapstructure:"memory_watermark"`
+	// Profile is the preset of the defaults of the settings bounding the
+	// memory usage: default or edge. See ProfileDefaults.
+	Profile string `mapstructure:"profile"`
+	// QueueLength is the length of the channels of scraped targets between
+	// the stages of the pipeline. Defaults to 100.
+	QueueLength int `mapstructure:"queue_length"`
+	// MaxPayloadSize is the maximum size of the scraped payloads, e.g. 16Mi.
+	// The scrapes of larger payloads fail. Empty means unlimited.
+	MaxPayloadSize string `mapstructure:"max_payload_size"`
+	// Parsed version of `MaxPayloadSize`
+	MaxPayloadSizeBytes int64
+	// RefreshIntervals configures how often the retrievers refresh their
+	// targets.
+	RefreshIntervals endpoints.RefreshConfig `mapstructure:"refresh_intervals"`
+	// NetworkPolicy restricts the addresses the discovered targets are
+	// scraped at.
+	NetworkPolicy endpoints.NetworkPolicyConfig `mapstructure:"network_policy"`
+	// TargetIdentity identifies the pod targets by some of their attributes,
+	// so the recreated pods are treated as the same targets.
+	TargetIdentity endpoints.IdentityConfig `mapstructure:"target_identity"`
+	// ZoneAwareness makes each replica scrape only the targets of the
+	// Kubernetes cluster in its availability zone.
+	ZoneAwareness endpoints.ZoneConfig `mapstructure:"zone_awareness"`
+	// DaemonSetMode makes the integration scrape only the pods of the node
+	// given by the NODE_NAME environment variable, and the node itself, to
+	// run it as a DaemonSet. The services are not scraped.
+	DaemonSetMode bool `mapstructure:"daemonset_mode"`
+	// ScrapeHTTPClient configures the HTTP client used to scrape the targets.
+	ScrapeHTTPClient integration.HTTPClientConfig `mapstructure:"scrape_http_client"`
+	// ScrapeIdentification configures the User-Agent and X-Scraped-By headers
+	// of the scrape requests.
+	ScrapeIdentification ScrapeIdentificationConfig `mapstructure:"scrape_identification"`
+	// StrictContentType rejects the scrape responses whose Content-Type is not
+	// an exposition format, like the HTML pages of proxies, instead of
+	// parsing them.
+	StrictContentType bool `mapstructure:"strict_content_type"`
+	// ErrorPayloads configures the detection of the payloads of the exporters
+	// that failed collecting their metrics, which fail the scrape.
+	ErrorPayloads integration.ErrorPayloadConfig `mapstructure:"error_payloads"`
+	// RetrieverHTTPClients overrides ScrapeHTTPClient for the targets of
+	// the given retrievers: fixed, kubernetes or self.
+	RetrieverHTTPClients map[string]integration.HTTPClientConfig `mapstructure:"retriever_http_clients"`
+	// SPIFFE configures the SVID presented when scraping the targets and,
+	// optionally, when emitting.
+	SPIFFE integration.SPIFFEConfig `mapstructure:"spiffe"`
+	// Istio configures how the pods with an Istio sidecar are scraped.
+	Istio endpoints.IstioConfig `mapstructure:"istio"`
+	// APIServerProxy scrapes the pods and services through the proxy of the
+	// Kubernetes API server, when their IPs aren't routable.
+	APIServerProxy endpoints.APIServerProxyConfig `mapstructure:"api_server_proxy"`
+	// KubernetesClusters are other clusters whose targets are discovered and
+	// scraped, besides the one the integration runs in.
+	KubernetesClusters []KubernetesCluster `mapstructure:"kubernetes_clusters"`
+	// TombstoneTTL is how long the Kubernetes objects that disappear are
+	// kept as tombstoned, so they are restored instead of re-added if they
+	// reappear meanwhile. Zero disables it.
+	TombstoneTTL time.Duration `mapstructure:"tombstone_ttl"`
+	// DeduplicateTargets scrapes only once the targets with the same URL
+	// discovered by several retrievers.
+	DeduplicateTargets bool `mapstructure:"deduplicate_targets"`
+	// TargetPrecedence are the names of the retrievers whose targets are kept
+	// first when deduplicating them, e.g. fixed, kubernetes.
+	TargetPrecedence []string `mapstructure:"target_precedence"`
+	// ForwardMetadata adds the description of the metrics, from their HELP,
+	// and their unit as the description and unit attributes.
+	ForwardMetadata bool `mapstructure:"forward_metadata"`
+	// NormalizeUnits converts the metrics measured in units like milliseconds
+	// or megabytes to seconds or bytes, replacing the unit in their names.
+	NormalizeUnits bool `mapstructure:"normalize_units"`
+	// ParseMode is how the malformed lines of the payloads are handled: strict
+	// (default) fails the scrape, lenient skips up to ParseErrorBudget of them.
+	ParseMode string `mapstructure:"parse_mode"`
+	// ParseErrorBudget is the maximum number of malformed lines skipped in
+	// each payload in lenient mode. Defaults to 10.
+	ParseErrorBudget int `mapstructure:"parse_error_budget"`
+	// ParserQuarantine decodes the payloads with a timeout and captures the
+	// ones that make the parser panic or time out.
+	ParserQuarantine integration.ParserQuarantineConfig `mapstructure:"parser_quarantine"`
+	// NonFiniteValuesPolicy handles the NaN and Inf values of the counters and
+	// gauges: drop (default), clamp or attribute.
+	NonFiniteValuesPolicy string `mapstructure:"non_finite_values_policy"`
+	// LabelValidation configures the validation of the names of the scraped
+	// labels and the maximum number of labels of the series.
+	LabelValidation integration.LabelValidationConfig `mapstructure:"label_validation"`
+	// AutoDecorateOptions configures the decoration of the metrics with the
+	// labels of the info metrics of their target, when AutoDecorate is set.
+	AutoDecorateOptions integration.AutoDecorateConfig `mapstructure:"auto_decorate_options"`
+	// OnlyMetrics is an allowlist of the metrics to emit. When set, the
+	// metrics not matching any of its prefixes or patterns are dropped.
+	OnlyMetrics integration.OnlyMetricsConfig `mapstructure:"only_metrics"`
+	// TargetQueues splits the scraped targets in shards processed
+	// concurrently, each with its own bounded queue.
+	TargetQueues integration.TargetQueuesConfig `mapstructure:"target_queues"`
+	// Redaction replaces the sensitive values of the attributes of all the
+	// metrics, after all the processing rules.
+	Redaction integration.RedactionConfig `mapstructure:"redaction"`
+	// DroppedSampling emits a sample of the series dropped by the processing
+	// rules and the limits, to verify what is dropped.
+	DroppedSampling integration.DroppedSamplingConfig `mapstructure:"dropped_sampling"`
+	// OpenMetrics configures how the OpenMetrics info and stateset metrics are
+	// emitted.
+	OpenMetrics integration.OpenMetricsConfig `mapstructure:"openmetrics"`
+	// PromoteTargetInfo adds the labels of the target_info metric of the
+	// targets instrumented with OpenTelemetry to all their metrics, as
+	// resource attributes like service.name.
+	PromoteTargetInfo bool `mapstructure:"promote_target_info"`
+	// TimestampSkew configures the check of the timestamps reported by the
+	// targets.
+	TimestampSkew integration.TimestampSkewConfig `mapstructure:"timestamp_skew"`
+	// TimestampBounds configures the check of the timestamps emitted by the
+	// telemetry emitter against the window accepted by the Metric API.
+	TimestampBounds integration.TimestampBoundsConfig `mapstructure:"timestamp_bounds"`
+	// CounterCheckpoint persists the last values of the counters the
+	// telemetry emitter calculates the deltas from, across restarts.
+	CounterCheckpoint integration.CounterCheckpointConfig `mapstructure:"counter_checkpoint"`
+	// MaxTargets is the maximum number of targets scraped in each cycle. Zero
+	// means no limit.
+	MaxTargets int `mapstructure:"max_targets"`
+	// MaxTargetsPolicy chooses the targets scraped when there are more than
+	// MaxTargets: alphabetical (default), priority or fail.
+	MaxTargetsPolicy string `mapstructure:"max_targets_policy"`
+	// StartupRampDuration is the time after the start during which the
+	// fraction of the targets scraped grows, from a tenth of them to all.
+	// Zero disables the ramp.
+	StartupRampDuration time.Duration `mapstructure:"startup_ramp_duration"`
+	// ScrapeBudget skips, fairly, the targets that don't fit in the time
+	// budget of the scrape cycles.
+	ScrapeBudget integration.ScrapeBudgetConfig `mapstructure:"scrape_budget"`
+	// LatencySLO flags the targets whose scrapes are persistently slower
+	// than an objective.
+	LatencySLO integration.LatencySLOConfig `mapstructure:"latency_slo"`
+	// IngestBudgets limits the datapoints emitted for each Kubernetes
+	// namespace, or each value of another attribute.
+	IngestBudgets integration.IngestBudgetConfig `mapstructure:"ingest_budgets"`
+	// TargetSnapshots is the number of targets whose metrics of the last
+	// scrape are kept in memory, as sent, and served by /debug/snapshots.
+	// Zero disables it.
+	TargetSnapshots int `mapstructure:"target_snapshots"`
+	// DeterministicOutput emits the metrics of each cycle sorted by target,
+	// name and attributes, so the emitted payloads can be compared. The
+	// metrics are held until all the targets of the cycle are processed.
+	DeterministicOutput bool `mapstructure:"deterministic_output"`
+	// CardinalityTopN is the number of metrics with the most series, and
+	// attributes with the most values, reported in each scrape cycle. Zero
+	// disables it.
+	CardinalityTopN int `mapstructure:"cardinality_top_n"`
+	// Heartbeat configures a metric emitted periodically even when there are
+	// no targets, to alert if the integration stops.
+	Heartbeat integration.HeartbeatConfig `mapstructure:"heartbeat"`
+	// UpdateCheck configures the periodic check of the latest released
+	// version of the integration. It's disabled by default.
+	UpdateCheck integration.UpdateCheckConfig `mapstructure:"update_check"`
+	// CounterRollup configures the counters emitted by the telemetry emitter
+	// as a summary per interval instead of a datapoint per scrape.
+	CounterRollup integration.CounterRollupConfig `mapstructure:"counter_rollup"`
+	// HistogramEmission is how the telemetry emitter sends the histograms:
+	// buckets, with a series per bucket, or distribution, with a single
+	// datapoint per histogram with its bucket boundaries and counts.
+	HistogramEmission string `mapstructure:"histogram_emission"`
+	// ThresholdEvents are rules generating an event when a metric crosses a
+	// threshold, and another one when it stops crossing it.
+	ThresholdEvents []integration.ThresholdRule `mapstructure:"threshold_events"`
+	// ThresholdEventType is the type of the threshold events. Defaults to
+	// PrometheusThresholdEvent.
+	ThresholdEventType string `mapstructure:"threshold_event_type"`
+	// LifecycleEvents sends an event when the targets are added, updated or
+	// removed by the retrievers.
+	LifecycleEvents integration.LifecycleEventsConfig `mapstructure:"target_lifecycle_events"`
+	// Tracing exports OpenTelemetry traces of the scrape cycles.
+	Tracing integration.TracingConfig `mapstructure:"tracing"`
+	// SpillDir is the directory where the scraped payloads are stored until
+	// they are processed. When empty, they are kept in memory.
+	SpillDir string `mapstructure:"spill_dir"`
+	// SpillMaxSize is the maximum size of the payloads stored in SpillDir, e.g. 1Gi.
+	SpillMaxSize string `mapstructure:"spill_max_size"`
+	// Parsed version of `SpillMaxSize`
+	SpillMaxSizeBytes int64
+	// PayloadArchive stores the raw payloads of the selected targets,
+	// compressed, to replay them through the rules.
+	PayloadArchive integration.PayloadArchiveConfig `mapstructure:"payload_archive"`
+	// ShutdownTimeout is the maximum time to wait for the in-flight scrapes and
+	// the pending metrics to be sent when the integration is stopped.
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// CandidateRulesFile is a YAML file with a transformations list evaluated
+	// alongside the active one without being applied. The metrics it would
+	// drop, keep or rename differently are reported by /debug/rules/shadow,
+	// until it's promoted through the admin API.
+	CandidateRulesFile string `mapstructure:"candidate_rules_file"`
+	// RuleUsageCycles is the number of scrape cycles the transformations are
+	// evaluated in after they are loaded or reloaded. The rules that didn't
+	// match any metric are logged as a warning and flagged by /debug/rules.
+	// Zero disables the evaluation.
+	RuleUsageCycles int `mapstructure:"rule_usage_cycles"`
+	// AdminAPI configures the API to change the targets and rules while the
+	// integration is running. It's disabled by default.
+	AdminAPI AdminAPIConfig `mapstructure:"admin_api"`
+	// FIPSMode restricts the TLS connections to scrape and emit to the FIPS
+	// approved versions, cipher suites and curves, and rejects the
+	// configurations that aren't compliant. It's always enabled in the
+	// binaries built with the fips tag.
+	FIPSMode bool `mapstructure:"fips_mode"`
+	// AuditDigest configures the log of the digest of each payload sent by
+	// the telemetry emitter.
+	AuditDigest AuditDigestConfig `mapstructure:"audit_digest"`
+	// Server configures the HTTP server with the integration metrics and the
+	// readiness, debug and admin endpoints.
+	Server ServerConfig `mapstructure:"server"`
+	// WAL makes the integration emit the samples written in the write-ahead
+	// log of a Prometheus in agent mode, instead of scraping the targets.
+	WAL integration.WALConfig `mapstructure:"wal"`
+	// LoadProcessingRules returns the processing rules of the current
+	// configuration, so the admin API can reload them. It's set by the
+	// entry point reading the configuration file.
+	LoadProcessingRules func() ([]integration.ProcessingRule, error) `mapstructure:"-"`
+	// Once discovers, scrapes and emits the targets a single time and exits,
+	// failing if any of them couldn't be scraped or emitted. It's set with
+	// the --once flag.
+	Once bool `mapstructure:"-"`
+	// Replay is a directory of payloads, archived or in the exposition
+	// format, processed and emitted with their original timestamps instead of
+	// scraping the targets. It's set with the --replay flag.
+	Replay string `mapstructure:"-"`
 }
 
 const maskedLicenseKey = "****"
 
+// KubernetesCluster is a Kubernetes cluster reached through a kubeconfig file.
+type KubernetesCluster struct {
+	// Name is added as clusterName to the metrics of its targets.
+	Name       string `mapstructure:"name"`
+	KubeConfig string `mapstructure:"kubeconfig"`
+	// Context is the kubeconfig context used. When empty, the current one is.
+	Context string `mapstructure:"context"`
+}
+
 // LicenseKey is a New Relic license key that will be masked when printed using standard formatters
 type LicenseKey string
 
@@ -72,8 +344,25 @@ func (l LicenseKey) GoString() string {
 	return maskedLicenseKey
 }
 
-// channel length for entities
-const queueLength = 100
+// defaultQueueLength is the channel length for entities, unless configured
+// otherwise.
+const defaultQueueLength = 100
+
+// queueLength returns the configured channel length for entities, or the
+// default one for the configurations that weren't validated.
+func queueLength(cfg *Config) int {
+	if cfg.QueueLength <= 0 {
+		return defaultQueueLength
+	}
+	return cfg.QueueLength
+}
+
+// defaultParseErrorBudget is the maximum number of malformed lines skipped in
+// each payload in lenient parse mode, unless configured otherwise.
+const defaultParseErrorBudget = 10
+
+// DefaultShutdownTimeout is the shutdown deadline used when ShutdownTimeout is not set.
+const DefaultShutdownTimeout = 20 * time.Second
 
 func validateConfig(cfg *Config) error {
 	requiredMsg := "%s is required and can't be empty"
@@ -83,6 +372,9 @@ func validateConfig(cfg *Config) error {
 	if cfg.LicenseKey == "" && cfg.Standalone {
 		return fmt.Errorf(requiredMsg, "license_key")
 	}
+	if cfg.LicenseKeyFileInterval < 0 {
+		return fmt.Errorf("license_key_file_interval can't be negative")
+	}
 
 	if cfg.EmitterProxy != "" {
 		proxyURL, err := url.Parse(cfg.EmitterProxy)
@@ -99,41 +391,417 @@ func validateConfig(cfg *Config) error {
 		}
 	}
 
-	if cfg.WorkerThreads < 4 {
-		logrus.Infof("Minimum amount of 4 worker threads required, %d given. Setting to 4.", cfg.WorkerThreads)
-		cfg.WorkerThreads = 4
+	if err := cfg.SPIFFE.Validate(); err != nil {
+		return fmt.Errorf("invalid spiffe configuration: %w", err)
+	}
+	if cfg.SPIFFE.Emitter && !cfg.SPIFFE.Enabled() {
+		return fmt.Errorf("spiffe.emitter requires spiffe.svid_file")
 	}
 
-	return nil
-}
+	if err := cfg.Istio.Validate(); err != nil {
+		return fmt.Errorf("invalid istio configuration: %w", err)
+	}
+	if err := cfg.APIServerProxy.Validate(); err != nil {
+		return fmt.Errorf("invalid api_server_proxy configuration: %w", err)
+	}
+	if cfg.APIServerProxy.Enabled && cfg.Istio.Mode == endpoints.IstioModeMutualTLS {
+		return fmt.Errorf("the pods can't be scraped with the istio mtls mode through the api_server_proxy")
+	}
 
-// RunWithEmitters runs the scraper with preselected emitters.
-func RunWithEmitters(cfg *Config, emitters []integration.Emitter) error {
+	if len(cfg.TargetPrecedence) > 0 && !cfg.DeduplicateTargets {
+		return fmt.Errorf("target_precedence requires deduplicate_targets")
+	}
 
-	if len(emitters) == 0 {
-		return fmt.Errorf("you need to configure at least one valid emitter")
+	switch cfg.ParseMode {
+	case "":
+		cfg.ParseMode = integration.ParseStrict
+	case integration.ParseStrict, integration.ParseLenient:
+	default:
+		return fmt.Errorf("invalid parse_mode %q, must be one of: %s, %s", cfg.ParseMode,
+			integration.ParseStrict, integration.ParseLenient)
+	}
+	if cfg.ParseErrorBudget < 0 {
+		return fmt.Errorf("parse_error_budget can't be negative")
+	}
+	if cfg.ParseErrorBudget == 0 {
+		cfg.ParseErrorBudget = defaultParseErrorBudget
+	}
+	if err := cfg.ParserQuarantine.Validate(); err != nil {
+		return fmt.Errorf("invalid parser_quarantine configuration: %w", err)
+	}
+	if err := cfg.PayloadArchive.Validate(); err != nil {
+		return fmt.Errorf("invalid payload_archive configuration: %w", err)
 	}
 
-	selfRetriever, err := endpoints.SelfRetriever()
-	if err != nil {
-		return fmt.Errorf("while parsing provided endpoints: %w", err)
+	switch cfg.NonFiniteValuesPolicy {
+	case "":
+		cfg.NonFiniteValuesPolicy = integration.NonFiniteDrop
+	case integration.NonFiniteDrop, integration.NonFiniteClamp, integration.NonFiniteAttribute:
+	default:
+		return fmt.Errorf("invalid non_finite_values_policy %q, must be one of: %s, %s, %s", cfg.NonFiniteValuesPolicy,
+			integration.NonFiniteDrop, integration.NonFiniteClamp, integration.NonFiniteAttribute)
+	}
+
+	if err := cfg.LabelValidation.Validate(); err != nil {
+		return fmt.Errorf("invalid label_validation configuration: %w", err)
+	}
+
+	if err := cfg.OnlyMetrics.Validate(); err != nil {
+		return fmt.Errorf("invalid only_metrics configuration: %w", err)
+	}
+	if cfg.RuleUsageCycles < 0 {
+		return fmt.Errorf("rule_usage_cycles can't be negative")
+	}
+	if err := cfg.TargetQueues.Validate(); err != nil {
+		return fmt.Errorf("invalid target_queues configuration: %w", err)
+	}
+	if err := cfg.Redaction.Validate(); err != nil {
+		return fmt.Errorf("invalid redaction configuration: %w", err)
+	}
+	if cfg.AutoDecorate {
+		if err := cfg.AutoDecorateOptions.Validate(); err != nil {
+			return fmt.Errorf("invalid auto_decorate_options configuration: %w", err)
+		}
+	}
+
+	if err := cfg.DroppedSampling.Validate(); err != nil {
+		return fmt.Errorf("invalid dropped_sampling configuration: %w", err)
+	}
+
+	if err := cfg.OpenMetrics.Validate(); err != nil {
+		return fmt.Errorf("invalid openmetrics configuration: %w", err)
+	}
+
+	if err := cfg.ErrorPayloads.Validate(); err != nil {
+		return fmt.Errorf("invalid error_payloads configuration: %w", err)
+	}
+
+	if err := cfg.TimestampSkew.Validate(); err != nil {
+		return fmt.Errorf("invalid timestamp_skew configuration: %w", err)
+	}
+	if err := cfg.TimestampBounds.Validate(); err != nil {
+		return fmt.Errorf("invalid timestamp_bounds configuration: %w", err)
+	}
+	if err := cfg.CounterCheckpoint.Validate(); err != nil {
+		return fmt.Errorf("invalid counter_checkpoint configuration: %w", err)
+	}
+
+	if err := cfg.IngestBudgets.Validate(); err != nil {
+		return fmt.Errorf("invalid ingest_budgets configuration: %w", err)
+	}
+
+	if err := validateProcessingRules(cfg.ProcessingRules); err != nil {
+		return err
+	}
+
+	if err := cfg.CounterRollup.Validate(); err != nil {
+		return fmt.Errorf("invalid counter_rollup configuration: %w", err)
+	}
+
+	if err := integration.ValidateHistogramEmission(cfg.HistogramEmission); err != nil {
+		return fmt.Errorf("invalid histogram_emission: %w", err)
+	}
+
+	for _, rule := range cfg.ThresholdEvents {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid threshold event %q: %w", rule.Name, err)
+		}
+	}
+
+	if err := cfg.AdminAPI.Validate(); err != nil {
+		return fmt.Errorf("invalid admin_api configuration: %w", err)
+	}
+
+	if cfg.FIPSMode || fips.Enabled() {
+		if err := validateFIPS(cfg); err != nil {
+			return fmt.Errorf("invalid configuration for the FIPS mode: %w", err)
+		}
+	}
+
+	if err := cfg.AuditDigest.Validate(); err != nil {
+		return fmt.Errorf("invalid audit_digest configuration: %w", err)
+	}
+
+	if err := cfg.Server.Validate(); err != nil {
+		return fmt.Errorf("invalid server configuration: %w", err)
+	}
+	if cfg.Server.Disabled && cfg.AdminAPI.Enabled {
+		return fmt.Errorf("admin_api requires the server to be enabled")
+	}
+
+	if err := cfg.Heartbeat.Validate(); err != nil {
+		return fmt.Errorf("invalid heartbeat configuration: %w", err)
+	}
+
+	if err := cfg.UpdateCheck.Validate(); err != nil {
+		return fmt.Errorf("invalid update_check configuration: %w", err)
+	}
+
+	if cfg.CardinalityTopN < 0 {
+		return fmt.Errorf("cardinality_top_n can't be negative")
+	}
+
+	if cfg.TargetSnapshots < 0 {
+		return fmt.Errorf("target_snapshots can't be negative")
+	}
+
+	if cfg.MaxTargets < 0 {
+		return fmt.Errorf("max_targets can't be negative")
+	}
+	switch cfg.MaxTargetsPolicy {
+	case "":
+		cfg.MaxTargetsPolicy = integration.MaxTargetsAlphabetical
+	case integration.MaxTargetsAlphabetical, integration.MaxTargetsPriority, integration.MaxTargetsFail:
+	default:
+		return fmt.Errorf("invalid max_targets_policy %q, must be one of: %s, %s, %s", cfg.MaxTargetsPolicy,
+			integration.MaxTargetsAlphabetical, integration.MaxTargetsPriority, integration.MaxTargetsFail)
+	}
+	if cfg.StartupRampDuration < 0 {
+		return fmt.Errorf("startup_ramp_duration can't be negative")
+	}
+
+	if err := cfg.ScrapeBudget.Validate(); err != nil {
+		return fmt.Errorf("invalid scrape_budget configuration: %w", err)
+	}
+	if err := cfg.LatencySLO.Validate(); err != nil {
+		return fmt.Errorf("invalid latency_slo configuration: %w", err)
+	}
+
+	if cfg.TombstoneTTL < 0 {
+		return fmt.Errorf("tombstone_ttl can't be negative")
+	}
+
+	clusterNames := map[string]bool{cfg.ClusterName: true}
+	for i, cluster := range cfg.KubernetesClusters {
+		if cluster.Name == "" || cluster.KubeConfig == "" {
+			return fmt.Errorf("kubernetes_clusters[%d]: name and kubeconfig are required", i)
+		}
+		if clusterNames[cluster.Name] {
+			return fmt.Errorf("kubernetes_clusters[%d]: duplicated cluster name %q", i, cluster.Name)
+		}
+		clusterNames[cluster.Name] = true
+	}
+
+	switch cfg.EmitterCompression {
+	case "", "gzip":
+	case "zstd":
+		// Not vendored, and the Metric API is only sent gzip payloads.
+		return fmt.Errorf("emitter compression zstd is not supported yet, only gzip is supported")
+	default:
+		return fmt.Errorf("unsupported emitter compression %q, only gzip is supported", cfg.EmitterCompression)
+	}
+
+	if cfg.EmitterCompressionLevel < gzip.HuffmanOnly || cfg.EmitterCompressionLevel > gzip.BestCompression {
+		return fmt.Errorf("emitter_compression_level must be between %d and %d, %d given", gzip.HuffmanOnly, gzip.BestCompression, cfg.EmitterCompressionLevel)
+	}
+
+	if err := cfg.RefreshIntervals.Validate(); err != nil {
+		return fmt.Errorf("invalid refresh_intervals configuration: %w", err)
+	}
+	if err := cfg.NetworkPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid network_policy configuration: %w", err)
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		return fmt.Errorf("invalid tracing configuration: %w", err)
+	}
+	if err := cfg.WAL.Validate(); err != nil {
+		return fmt.Errorf("invalid wal configuration: %w", err)
+	}
+
+	if err := cfg.TargetIdentity.Validate(); err != nil {
+		return fmt.Errorf("invalid target_identity configuration: %w", err)
+	}
+
+	if err := cfg.ZoneAwareness.Validate(); err != nil {
+		return fmt.Errorf("invalid zone_awareness configuration: %w", err)
+	}
+
+	if cfg.DaemonSetMode && cfg.ZoneAwareness.Enabled {
+		return fmt.Errorf("daemonset_mode and zone_awareness can't be enabled together")
+	}
+
+	if err := cfg.ScrapeHTTPClient.Validate(); err != nil {
+		return fmt.Errorf("invalid scrape_http_client: %w", err)
+	}
+
+	if err := cfg.ScrapeIdentification.Validate(); err != nil {
+		return fmt.Errorf("invalid scrape_identification configuration: %w", err)
+	}
+	for retriever, httpCfg := range cfg.RetrieverHTTPClients {
+		if err := httpCfg.Validate(); err != nil {
+			return fmt.Errorf("invalid retriever_http_clients.%s: %w", retriever, err)
+		}
+	}
+
+	if cfg.MemoryLimit != "" {
+		limit, err := resource.ParseQuantity(cfg.MemoryLimit)
+		if err != nil {
+			return fmt.Errorf("couldn't parse memory limit: %w", err)
+		}
+		cfg.MemoryLimitBytes = limit.Value()
+	}
+
+	if cfg.SpillMaxSize != "" {
+		size, err := resource.ParseQuantity(cfg.SpillMaxSize)
+		if err != nil {
+			return fmt.Errorf("couldn't parse spill max size: %w", err)
+		}
+		cfg.SpillMaxSizeBytes = size.Value()
+	}
+
+	if cfg.MemoryWatermark < 0 || cfg.MemoryWatermark > 1 {
+		return fmt.Errorf("memory_watermark must be between 0 and 1, %v given", cfg.MemoryWatermark)
+	}
+
+	if cfg.MaxPayloadSize != "" {
+		size, err := resource.ParseQuantity(cfg.MaxPayloadSize)
+		if err != nil {
+			return fmt.Errorf("couldn't parse max payload size: %w", err)
+		}
+		cfg.MaxPayloadSizeBytes = size.Value()
+	}
+
+	if cfg.QueueLength < 0 {
+		return fmt.Errorf("queue_length can't be negative, %d given", queueLength(cfg))
+	}
+	if cfg.QueueLength == 0 {
+		cfg.QueueLength = defaultQueueLength
+	}
+
+	if _, err := ProfileDefaults(cfg.Profile); err != nil {
+		return err
+	}
+	minWorkerThreads := 4
+	if cfg.Profile == ProfileEdge {
+		minWorkerThreads = 1
+	}
+	if cfg.WorkerThreads < minWorkerThreads {
+		logrus.Infof("Minimum amount of %d worker threads required, %d given. Setting to %d.", minWorkerThreads, cfg.WorkerThreads, minWorkerThreads)
+		cfg.WorkerThreads = minWorkerThreads
 	}
+
+	return nil
+}
+
+// validateProcessingRules returns an error if any of the rules is not valid.
+func validateProcessingRules(rules []integration.ProcessingRule) error {
+	for _, rule := range rules {
+		for _, ignore := range rule.IgnoreMetrics {
+			if err := ignore.Validate(); err != nil {
+				return fmt.Errorf("invalid ignore_metrics rule: %w", err)
+			}
+		}
+		for _, copyRule := range rule.CopyAttributes {
+			if err := copyRule.Validate(); err != nil {
+				return fmt.Errorf("invalid copy_attributes rule: %w", err)
+			}
+		}
+		for _, derived := range rule.DerivedMetrics {
+			if err := derived.Validate(); err != nil {
+				return fmt.Errorf("invalid derived metric %q: %w", derived.Name, err)
+			}
+		}
+		for _, mapping := range rule.MapValues {
+			if err := mapping.Validate(); err != nil {
+				return fmt.Errorf("invalid map_values rule: %w", err)
+			}
+		}
+		for _, folding := range rule.FoldStates {
+			if err := folding.Validate(); err != nil {
+				return fmt.Errorf("invalid fold_states rule: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// newRetrievers returns the TargetRetrievers of the static targets and the
+// Kubernetes clusters, and the TargetEditor of the static targets.
+func newRetrievers(cfg *Config) ([]endpoints.TargetRetriever, endpoints.TargetEditor, error) {
 	var retrievers []endpoints.TargetRetriever
 	fixedRetriever, err := endpoints.FixedRetriever(cfg.TargetConfigs...)
 	if err != nil {
-		return fmt.Errorf("while parsing provided endpoints: %w", err)
+		return nil, nil, fmt.Errorf("while parsing provided endpoints: %w", err)
 	}
 	retrievers = append(retrievers, fixedRetriever)
 
 	if !cfg.DisableKubernetes && !cfg.DisableAutodiscovery {
-		kubernetesRetriever, err := endpoints.NewKubernetesTargetRetriever(cfg.ScrapeEnabledLabel, cfg.RequireScrapeEnabledLabelForNodes, endpoints.WithInClusterConfig())
+		options := []endpoints.Option{
+			endpoints.WithInClusterConfig(),
+			endpoints.WithIstio(cfg.Istio),
+			endpoints.WithAPIServerProxy(cfg.APIServerProxy),
+			endpoints.WithTombstoneTTL(cfg.TombstoneTTL),
+			endpoints.WithPreferIPProtocol(retrieverHTTPClient(cfg, "kubernetes").PreferIPProtocol),
+			endpoints.WithZoneAwareness(cfg.ZoneAwareness, os.Getenv("NODE_NAME")),
+			endpoints.WithIdentity(cfg.TargetIdentity),
+			endpoints.WithResyncInterval(cfg.RefreshIntervals.KubernetesResync),
+			endpoints.WithTargetAnnotations(integration.TargetAnnotations(cfg.ProcessingRules)),
+		}
+		if cfg.DaemonSetMode {
+			options = append(options, endpoints.WithLocalNode(os.Getenv("NODE_NAME")))
+		}
+		kubernetesRetriever, err := endpoints.NewKubernetesTargetRetriever(
+			cfg.ScrapeEnabledLabel,
+			cfg.RequireScrapeEnabledLabelForNodes,
+			options...,
+		)
 		if err != nil {
 			logrus.WithError(err).Errorf("not possible to get a Kubernetes client. If you aren't running this integration in a Kubernetes cluster, you can ignore this error")
 		} else {
-			retrievers = append(retrievers, kubernetesRetriever)
+			r, err := endpoints.WithNetworkPolicy(kubernetesRetriever, cfg.NetworkPolicy)
+			if err != nil {
+				return nil, nil, err
+			}
+			retrievers = append(retrievers, r)
 		}
 	}
 
+	for _, cluster := range cfg.KubernetesClusters {
+		clusterRetriever, err := endpoints.NewKubernetesTargetRetriever(
+			cfg.ScrapeEnabledLabel,
+			cfg.RequireScrapeEnabledLabelForNodes,
+			endpoints.WithKubeConfigContext(cluster.KubeConfig, cluster.Context),
+			endpoints.WithIstio(cfg.Istio),
+			endpoints.WithAPIServerProxy(cfg.APIServerProxy),
+			endpoints.WithClusterName(cluster.Name),
+			endpoints.WithTombstoneTTL(cfg.TombstoneTTL),
+			endpoints.WithPreferIPProtocol(retrieverHTTPClient(cfg, "kubernetes/"+cluster.Name).PreferIPProtocol),
+			endpoints.WithResyncInterval(cfg.RefreshIntervals.KubernetesResync),
+			endpoints.WithTargetAnnotations(integration.TargetAnnotations(cfg.ProcessingRules)),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while creating the retriever of cluster %s: %w", cluster.Name, err)
+		}
+		r, err := endpoints.WithNetworkPolicy(clusterRetriever, cfg.NetworkPolicy)
+		if err != nil {
+			return nil, nil, err
+		}
+		retrievers = append(retrievers, r)
+	}
+
+	registered, err := registeredRetrievers(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	retrievers = append(retrievers, registered...)
+
+	for i, r := range retrievers {
+		retrievers[i] = endpoints.WithRefreshInterval(r, cfg.RefreshIntervals.For(r.Name()))
+	}
+	// The static targets are edited through the retriever refreshing them.
+	editor := retrievers[0].(endpoints.TargetEditor)
+
+	if cfg.DeduplicateTargets {
+		retrievers = []endpoints.TargetRetriever{
+			endpoints.NewCompositeRetriever(cfg.TargetPrecedence, retrievers...),
+		}
+	}
+	return retrievers, editor, nil
+}
+
+// defaultProcessingRules returns the configured processing rules followed by
+// the ones adding the attributes of the integration.
+func defaultProcessingRules(cfg *Config) []integration.ProcessingRule {
 	attributes := map[string]interface{}{
 		// Keeping these for backward compatibility
 		"integrationVersion": integration.Version,
@@ -162,27 +830,215 @@ func RunWithEmitters(cfg *Config, emitters []integration.Emitter) error {
 		},
 	}
 
-	processingRules := append(cfg.ProcessingRules, defaultTransformations)
+	return append(cfg.ProcessingRules, defaultTransformations)
+}
+
+// reloadedProcessingRules returns a function loading the processing rules of
+// the current configuration, followed by the ones adding the attributes of the
+// integration. It returns nil if the configuration can't be reloaded.
+func reloadedProcessingRules(cfg *Config) func() ([]integration.ProcessingRule, error) {
+	if cfg.LoadProcessingRules == nil {
+		return nil
+	}
+	return func() ([]integration.ProcessingRule, error) {
+		rules, err := cfg.LoadProcessingRules()
+		if err != nil {
+			return nil, err
+		}
+		if err := validateProcessingRules(rules); err != nil {
+			return nil, err
+		}
+		reloaded := *cfg
+		reloaded.ProcessingRules = rules
+		return defaultProcessingRules(&reloaded), nil
+	}
+}
+
+// ReadProcessingRules returns the processing rules in the transformations
+// key of a YAML file, like the configuration file.
+func ReadProcessingRules(path string) ([]integration.ProcessingRule, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var rules []integration.ProcessingRule
+	if err := v.UnmarshalKey("transformations", &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := validateProcessingRules(rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// loadCandidateRules returns the processing rules of the CandidateRulesFile,
+// followed by the ones adding the attributes of the integration.
+func loadCandidateRules(cfg *Config) ([]integration.ProcessingRule, error) {
+	rules, err := ReadProcessingRules(cfg.CandidateRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("candidate_rules_file: %w", err)
+	}
+	candidate := *cfg
+	candidate.ProcessingRules = rules
+	return defaultProcessingRules(&candidate), nil
+}
+
+// heartbeatAttributes returns the attributes identifying the integration in
+// the heartbeat.
+func heartbeatAttributes(cfg *Config) labels.Set {
+	attributes := labels.Set{}
+	if !cfg.DisableKubernetes {
+		attributes["k8s.cluster.name"] = cfg.ClusterName
+		attributes["clusterName"] = cfg.ClusterName
+	}
+	return attributes
+}
+
+// RunWithEmitters runs the scraper with preselected emitters.
+func RunWithEmitters(cfg *Config, emitters []integration.Emitter) error {
+
+	if len(emitters) == 0 {
+		return NewConfigError(fmt.Errorf("you need to configure at least one valid emitter"))
+	}
+
+	if cfg.LifecycleEvents.Enabled {
+		integration.EmitLifecycleEvents(endpoints.DefaultDiscoveryLog, cfg.LifecycleEvents, emitters)
+	}
+
+	// The integration metrics are scraped from its own server, or gathered in
+	// process.
+	var selfRetriever endpoints.TargetRetriever
+	if cfg.Server.scrapesSelf() {
+		var err error
+		selfRetriever, err = endpoints.SelfRetriever(cfg.Server.selfTarget())
+		if err != nil {
+			return NewConfigError(fmt.Errorf("while parsing provided endpoints: %w", err))
+		}
+	}
+	retrievers, staticTargets, err := newRetrievers(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	processingRules := defaultProcessingRules(cfg)
 
 	scrapeDuration, err := time.ParseDuration(cfg.ScrapeDuration)
 	if err != nil {
-		return fmt.Errorf(
+		return NewConfigError(fmt.Errorf(
 			"parsing scrape_duration value (%v): %w",
 			cfg.ScrapeDuration,
 			err,
-		)
+		))
 	}
 
-	go integration.Execute(
-		scrapeDuration,
-		selfRetriever,
-		retrievers,
-		integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength),
-		integration.RuleProcessor(processingRules, queueLength),
-		emitters)
+	fetcherOpts, err := fetcherOptions(cfg)
+	if err != nil {
+		return NewConfigError(err)
+	}
+	var fetcher integration.Fetcher
+	fetcher = integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength(cfg), fetcherOpts...)
+	guard := memory.Apply(memory.Config{
+		GCPercent: cfg.GCPercent,
+		Limit:     cfg.MemoryLimitBytes,
+		Watermark: cfg.MemoryWatermark,
+	})
+	if guard != nil {
+		fetcher = integration.NewSheddingFetcher(fetcher, guard)
+	}
+	if cfg.MaxTargets > 0 {
+		fetcher = integration.NewLimitingFetcher(fetcher, cfg.MaxTargets, cfg.MaxTargetsPolicy)
+	}
+
+	// The scrapes on demand of the admin API include the paused targets, and
+	// the ones out of their schedule.
+	onDemandFetcher := fetcher
+	fetcher = integration.NewSchedulingFetcher(fetcher)
+	if cfg.StartupRampDuration > 0 {
+		fetcher = integration.NewRampingFetcher(fetcher, cfg.StartupRampDuration)
+	}
+	pausedTargets := integration.NewPausedTargets()
+	if cfg.AdminAPI.Enabled {
+		fetcher = integration.NewPausingFetcher(fetcher, pausedTargets)
+	}
+
+	integration.DefaultCardinalityTracker.SetTopN(cfg.CardinalityTopN)
+	if cfg.DroppedSampling.Rate > 0 {
+		if err := integration.DefaultDroppedSampler.Configure(cfg.DroppedSampling); err != nil {
+			return NewConfigError(err)
+		}
+	}
+
+	chain, err := newProcessorChain(cfg, processingRules, emitters)
+	if err != nil {
+		return err
+	}
+	processor := chain.processor
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cfg.Tracing.Enabled {
+		integration.DefaultTracer = integration.NewTracer(cfg.Tracing)
+		go integration.DefaultTracer.Run(ctx)
+	}
+
+	ready := &readiness{retrievers: retrievers}
+	done := make(chan struct{})
+	// authErr gets the rejection of the license key, which stops the
+	// integration.
+	authErr := make(chan error, 1)
+	go func() {
+		defer close(done)
+		if !cfg.DisableLicenseKeyCheck {
+			if err := waitForEmittersAuth(ctx, emitters); err != nil {
+				authErr <- err
+				return
+			}
+		}
+		ready.set(true)
+		if cfg.Heartbeat.Enabled {
+			go integration.RunHeartbeat(ctx, cfg.Heartbeat, heartbeatAttributes(cfg), emitters)
+		}
+		if cfg.UpdateCheck.Enabled {
+			go integration.RunUpdateCheck(ctx, cfg.UpdateCheck, emitters)
+		}
+		if cfg.WAL.Enabled() {
+			logrus.Infof("Reading the write-ahead log of %s...", cfg.WAL.Dir)
+			if err := integration.TailWAL(ctx, cfg.WAL, processor, emitters); err != nil {
+				logrus.WithError(err).Error("can't read the write-ahead log")
+			}
+			return
+		}
+		integration.Execute(
+			ctx,
+			scrapeDuration,
+			selfRetriever,
+			retrievers,
+			fetcher,
+			processor,
+			emitters)
+	}()
 
 	r := http.NewServeMux()
 	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/ready", ready)
+	r.Handle("/debug/discovery", endpoints.DefaultDiscoveryLog)
+	r.Handle("/debug/cardinality", integration.DefaultCardinalityTracker)
+	if chain.snapshots != nil {
+		r.Handle("/debug/snapshots", chain.snapshots)
+	}
+	if chain.ruleUsage != nil {
+		r.Handle("/debug/rules", chain.ruleUsage)
+	}
+	if chain.shadowRules != nil {
+		r.Handle("/debug/rules/shadow", chain.shadowRules)
+	}
+	if cfg.AdminAPI.Enabled {
+		admin := newAdminAPI(string(cfg.AdminAPI.Token), retrievers, staticTargets, pausedTargets, chain.ruleSet, chain.shadowRules, onDemandFetcher, reloadedProcessingRules(cfg))
+		r.Handle("/admin/", admin)
+		r.Handle("/debug/scrape", admin)
+	}
 	if cfg.Debug {
 		r.HandleFunc("/debug/pprof/", pprof.Index)
 		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -190,7 +1046,177 @@ func RunWithEmitters(cfg *Config, emitters []integration.Emitter) error {
 		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
-	return http.ListenAndServe(":8080", r)
+	// The admin API has its own token.
+	handler := cfg.Server.BasicAuth.handler(r, "/ready", "/admin/", "/debug/scrape")
+	server := &http.Server{Addr: cfg.Server.Address, Handler: handler}
+	serverErr := make(chan error, 1)
+	if !cfg.Server.Disabled {
+		go func() {
+			serverErr <- cfg.Server.listen(server)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serverErr:
+		return &Error{Class: ErrorBind, Err: fmt.Errorf("serving on %s: %w", cfg.Server.Address, err)}
+	case err := <-authErr:
+		return fmt.Errorf("verifying the license key: %w", err)
+	case sig := <-signals:
+		logrus.WithField("signal", sig).Info("shutting down")
+	}
+	ready.set(false)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+	defer shutdownCancel()
+
+	// Stop discovering and scraping targets, and wait for the scraped metrics
+	// to be processed before flushing the emitters.
+	cancel()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		logrus.Warn("timed out waiting for the scraped metrics to be processed")
+	}
+	integration.FlushEmitters(shutdownCtx, emitters)
+	if err := integration.DefaultTracer.Flush(shutdownCtx); err != nil {
+		logrus.WithError(err).Warn("could not export the pending traces")
+	}
+
+	if !cfg.Server.Disabled {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).Warn("could not shut down the metrics server")
+		}
+	}
+	logrus.Info("shutdown complete")
+	return nil
+}
+
+// retrieverHTTPClient returns the configuration of the HTTP client used to
+// scrape the targets of the retriever. As in the fetcher, the retrievers of
+// additional clusters, named kubernetes/<cluster>, fall back to the
+// configuration of the kubernetes one.
+func retrieverHTTPClient(cfg *Config, retriever string) integration.HTTPClientConfig {
+	if httpCfg, ok := cfg.RetrieverHTTPClients[retriever]; ok {
+		return httpCfg.Merge(cfg.ScrapeHTTPClient)
+	}
+	if httpCfg, ok := cfg.RetrieverHTTPClients[strings.SplitN(retriever, "/", 2)[0]]; ok {
+		return httpCfg.Merge(cfg.ScrapeHTTPClient)
+	}
+	return cfg.ScrapeHTTPClient
+}
+
+// fetcherOptions returns the optional configuration of the fetcher.
+func fetcherOptions(cfg *Config) ([]integration.FetcherOpt, error) {
+	var opts []integration.FetcherOpt
+	if cfg.SPIFFE.Enabled() {
+		tlsConfig, err := cfg.SPIFFE.ScrapeTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE configuration: %w", err)
+		}
+		// It must be set before the HTTP client configuration.
+		opts = append(opts, integration.FetcherWithTLSConfig(tlsConfig))
+	}
+	opts = append(opts, integration.FetcherWithHTTPClientConfig(cfg.ScrapeHTTPClient))
+	opts = append(opts, integration.FetcherWithHeaders(scrapeHeaders(cfg)))
+	if cfg.Server.InternalSelfMetrics {
+		opts = append(opts, integration.FetcherWithSelfGatherer(promcli.DefaultGatherer))
+	}
+	if cfg.ScrapeBudget.Enabled {
+		opts = append(opts, integration.FetcherWithScrapeBudget(cfg.ScrapeBudget))
+	}
+	if cfg.LatencySLO.Enabled() {
+		opts = append(opts, integration.FetcherWithLatencySLO(cfg.LatencySLO))
+	}
+	if cfg.MaxPayloadSizeBytes > 0 {
+		opts = append(opts, integration.FetcherWithMaxPayloadSize(cfg.MaxPayloadSizeBytes))
+	}
+	if cfg.StrictContentType {
+		opts = append(opts, integration.FetcherWithContentTypeCheck())
+	}
+	if cfg.ErrorPayloads.Enabled {
+		opts = append(opts, integration.FetcherWithErrorPayloadDetection(cfg.ErrorPayloads))
+	}
+	if cfg.ForwardMetadata {
+		opts = append(opts, integration.FetcherWithMetadata())
+	}
+	if cfg.NormalizeUnits {
+		opts = append(opts, integration.FetcherWithUnitNormalization())
+	}
+	if cfg.ParseMode == integration.ParseLenient {
+		opts = append(opts, integration.FetcherWithLenientParsing(cfg.ParseErrorBudget))
+	}
+	if cfg.ParserQuarantine.Dir != "" {
+		q, err := integration.NewParserQuarantine(cfg.ParserQuarantine)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, integration.FetcherWithParserQuarantine(q))
+	}
+	opts = append(opts, integration.FetcherWithNonFinitePolicy(cfg.NonFiniteValuesPolicy))
+	// The timestamps of the replayed payloads are kept as they were scraped.
+	if cfg.Replay == "" {
+		opts = append(opts, integration.FetcherWithTimestampSkew(cfg.TimestampSkew))
+	}
+	opts = append(opts, integration.FetcherWithLabelValidation(cfg.LabelValidation))
+	opts = append(opts, integration.FetcherWithOpenMetrics(cfg.OpenMetrics))
+	if cfg.PromoteTargetInfo {
+		opts = append(opts, integration.FetcherWithTargetInfo())
+	}
+	for retriever, httpCfg := range cfg.RetrieverHTTPClients {
+		opts = append(opts, integration.FetcherWithRetrieverHTTPClient(retriever, httpCfg.Merge(cfg.ScrapeHTTPClient)))
+	}
+	if cfg.PayloadArchive.Dir != "" {
+		a, err := integration.NewPayloadArchive(cfg.PayloadArchive)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, integration.FetcherWithPayloadArchive(a))
+	}
+	if cfg.SpillDir != "" {
+		q, err := integration.NewSpillQueue(cfg.SpillDir, cfg.SpillMaxSizeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("while creating the spill queue: %w", err)
+		}
+		logrus.Infof("storing scraped payloads in %s until they are processed", cfg.SpillDir)
+		opts = append(opts, integration.FetcherWithSpillQueue(q))
+	}
+	return opts, nil
+}
+
+// validateFIPS returns an error if the static targets are configured with TLS
+// settings that aren't FIPS approved, or with an SSH jump host, whose
+// algorithms are chosen by the ssh client. The settings of the discovered
+// targets are restricted when they are scraped.
+func validateFIPS(cfg *Config) error {
+	for _, tc := range cfg.TargetConfigs {
+		version, err := tc.TLSConfig.TLSMinVersion()
+		if err != nil {
+			return err
+		}
+		suites, err := tc.TLSConfig.CipherSuiteIDs()
+		if err != nil {
+			return err
+		}
+		if err := fips.Check(version, suites); err != nil {
+			return fmt.Errorf("target %s: %w", tc.Description, err)
+		}
+		if tc.SSHProxy.Enabled() {
+			return fmt.Errorf("target %s: ssh_proxy can't be used", tc.Description)
+		}
+	}
+	return nil
+}
+
+// shutdownTimeout returns the configured shutdown deadline or the default one.
+func shutdownTimeout(cfg *Config) time.Duration {
+	if cfg.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return cfg.ShutdownTimeout
 }
 
 // RunOnceWithEmitters runs the scraper with preselected emitters once.
@@ -205,6 +1231,11 @@ func RunOnceWithEmitters(cfg *Config, emitters []integration.Emitter) error {
 		return fmt.Errorf("while parsing provided endpoints: %w", err)
 	}
 	retrievers = append(retrievers, fixedRetriever)
+	registered, err := registeredRetrievers(cfg)
+	if err != nil {
+		return err
+	}
+	retrievers = append(retrievers, registered...)
 
 	defaultTransformations := integration.ProcessingRule{
 		Description: "Default transformation rules",
@@ -229,13 +1260,32 @@ func RunOnceWithEmitters(cfg *Config, emitters []integration.Emitter) error {
 		)
 	}
 
+	if !cfg.DisableLicenseKeyCheck {
+		if err := checkEmittersAuth(context.Background(), emitters); err != nil {
+			return err
+		}
+	}
+
+	fetcherOpts, err := fetcherOptions(cfg)
+	if err != nil {
+		return err
+	}
+	chain, err := newProcessorChain(cfg, processingRules, emitters)
+	if err != nil {
+		return err
+	}
+
 	//fetch duration is hardcoded to 1 since the target is scraped only once
 	integration.ExecuteOnce(
 		retrievers,
-		integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength),
-		integration.RuleProcessor(processingRules, queueLength),
+		integration.NewFetcher(scrapeDuration, cfg.ScrapeTimeout, cfg.WorkerThreads, cfg.BearerTokenFile, cfg.CaFile, cfg.InsecureSkipVerify, queueLength(cfg), fetcherOpts...),
+		chain.processor,
 		emitters)
 
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+	defer cancel()
+	integration.FlushEmitters(ctx, emitters)
+
 	return nil
 }
 
@@ -243,12 +1293,38 @@ func RunOnceWithEmitters(cfg *Config, emitters []integration.Emitter) error {
 func Run(cfg *Config) error {
 	err := validateConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("while getting configuration options: %w", err)
+		return NewConfigError(fmt.Errorf("while getting configuration options: %w", err))
 	}
 	if cfg.Verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	if cfg.FIPSMode {
+		fips.Enable()
+	}
 
+	emitters, err := newEmitters(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Replay != "" {
+		logrus.Infof("Replaying the payloads of %s...", cfg.Replay)
+		err = ReplayWithEmitters(cfg, emitters)
+	} else if cfg.Once {
+		logrus.Info("Running a single scrape cycle...")
+		err = RunCycleWithEmitters(cfg, emitters)
+	} else if cfg.Standalone {
+		logrus.Info("Running in standalone mode...")
+		err = RunWithEmitters(cfg, emitters)
+	} else {
+		logrus.Info("Running in run-once mode...")
+		err = RunOnceWithEmitters(cfg, emitters)
+	}
+	return err
+}
+
+// newEmitters returns the emitters of the validated configuration.
+func newEmitters(cfg *Config) ([]integration.Emitter, error) {
 	var emitters []integration.Emitter
 	for _, e := range cfg.Emitters {
 		switch e {
@@ -259,6 +1335,7 @@ func Run(cfg *Config) error {
 				telemetry.ConfigAPIKey(string(cfg.LicenseKey)),
 				telemetry.ConfigBasicErrorLogger(os.Stdout),
 				integration.TelemetryHarvesterWithMetricsURL(cfg.MetricAPIURL),
+				integration.TelemetryHarvesterWithEventsURL(cfg.EventAPIURL),
 			}
 
 			if cfg.EmitterProxyURL != nil {
@@ -268,13 +1345,21 @@ func Run(cfg *Config) error {
 				)
 			}
 
-			if cfg.EmitterCAFile != "" {
+			// The TLS configuration is restricted in FIPS mode.
+			if cfg.EmitterCAFile != "" || cfg.SPIFFE.Emitter || fips.Enabled() {
 				tlsConfig, err := integration.NewTLSConfig(
 					cfg.EmitterCAFile,
 					cfg.EmitterInsecureSkipVerify,
 				)
 				if err != nil {
-					return fmt.Errorf("invalid TLS configuration: %w", err)
+					return nil, NewConfigError(fmt.Errorf("invalid TLS configuration: %w", err))
+				}
+				if cfg.SPIFFE.Emitter {
+					svidConfig, err := cfg.SPIFFE.EmitterTLSConfig()
+					if err != nil {
+						return nil, NewConfigError(fmt.Errorf("invalid SPIFFE configuration: %w", err))
+					}
+					tlsConfig.GetClientCertificate = svidConfig.GetClientCertificate
 				}
 				harvesterOpts = append(
 					harvesterOpts,
@@ -283,12 +1368,30 @@ func Run(cfg *Config) error {
 			}
 
 			// Options that rely on modifying the emitter Client Transport
-			// should go before this one, as this changes the type of the
-			// Transport to `integration.licenseKeyRoundTripper`.
+			// should go before these ones, as they change the type of the
+			// Transport.
+			if cfg.AuditDigest.Enabled {
+				harvesterOpts = append(
+					harvesterOpts,
+					integration.TelemetryHarvesterWithAuditDigest([]byte(cfg.AuditDigest.HMACKey)),
+				)
+			}
 			harvesterOpts = append(
 				harvesterOpts,
-				integration.TelemetryHarvesterWithLicenseKeyRoundTripper(string(cfg.LicenseKey)),
+				integration.TelemetryHarvesterWithCompressionLevel(cfg.EmitterCompressionLevel),
 			)
+			if cfg.EmitterCommonAttributes {
+				harvesterOpts = append(harvesterOpts, integration.TelemetryHarvesterWithCommonAttributes())
+			}
+			if cfg.LicenseKeyFile != "" {
+				keyFile, err := integration.NewLicenseKeyFile(cfg.LicenseKeyFile, cfg.LicenseKeyFileInterval)
+				if err != nil {
+					return nil, NewConfigError(err)
+				}
+				harvesterOpts = append(harvesterOpts, integration.TelemetryHarvesterWithLicenseKeyFile(keyFile))
+			} else {
+				harvesterOpts = append(harvesterOpts, integration.TelemetryHarvesterWithLicenseKeyRoundTripper(string(cfg.LicenseKey)))
+			}
 
 			if cfg.Verbose {
 				harvesterOpts = append(harvesterOpts, telemetry.ConfigBasicDebugLogger(os.Stdout))
@@ -300,25 +1403,30 @@ func Run(cfg *Config) error {
 
 			hTime, err := time.ParseDuration(cfg.EmitterHarvestPeriod)
 			if err != nil {
-				return fmt.Errorf(
+				return nil, NewConfigError(fmt.Errorf(
 					"invalid telemetry emitter harvest period %s: %w",
 					cfg.EmitterHarvestPeriod,
 					err,
-				)
+				))
 			}
 			mhTime, err := time.ParseDuration(cfg.MinEmitterHarvestPeriod)
 			if err != nil {
-				return fmt.Errorf(
+				return nil, NewConfigError(fmt.Errorf(
 					"invalid minimum telemetry emitter harvest period %s: %w",
 					cfg.MinEmitterHarvestPeriod,
 					err,
-				)
+				))
 			}
 
 			c := integration.TelemetryEmitterConfig{
 				HarvesterOpts:                 harvesterOpts,
 				DeltaExpirationAge:            cfg.TelemetryEmitterDeltaExpirationAge,
 				DeltaExpirationCheckInternval: cfg.TelemetryEmitterDeltaExpirationCheckInterval,
+				CounterRollup:                 cfg.CounterRollup,
+				HistogramEmission:             cfg.HistogramEmission,
+				TimestampBounds:               cfg.TimestampBounds,
+				FailOnDroppedTimestamps:       cfg.Replay != "",
+				CounterCheckpoint:             cfg.CounterCheckpoint,
 				BoundedHarvesterCfg: integration.BoundedHarvesterCfg{
 					HarvestPeriod:     hTime,
 					MinReportInterval: mhTime,
@@ -328,7 +1436,7 @@ func Run(cfg *Config) error {
 
 			emitter, err := integration.NewTelemetryEmitter(c)
 			if err != nil {
-				return errors.Wrap(err, "could not create new TelemetryEmitter")
+				return nil, NewConfigError(errors.Wrap(err, "could not create new TelemetryEmitter"))
 			}
 			emitters = append(emitters, emitter)
 		case "infra-sdk":
@@ -339,17 +1447,16 @@ func Run(cfg *Config) error {
 			emitter := integration.NewInfraSdkEmitter(specs)
 			emitters = append(emitters, emitter)
 		default:
-			logrus.Debugf("unknown emitter: %s", e)
-			continue
+			emitter, ok, err := registeredEmitter(e, cfg)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				logrus.Debugf("unknown emitter: %s", e)
+				continue
+			}
+			emitters = append(emitters, emitter)
 		}
 	}
-
-	if cfg.Standalone {
-		logrus.Info("Running in standalone mode...")
-		err = RunWithEmitters(cfg, emitters)
-	} else {
-		logrus.Info("Running in run-once mode...")
-		err = RunOnceWithEmitters(cfg, emitters)
-	}
-	return err
+	return emitters, nil
 }
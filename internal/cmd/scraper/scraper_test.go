@@ -5,6 +5,8 @@ package scraper
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/newrelic/nri-prometheus/internal/integration"
 	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
 	"github.com/stretchr/testify/require"
 
@@ -201,3 +204,201 @@ func TestScrapingNotAnswering(t *testing.T) {
 	require.NoError(t, err)
 
 }
+
+func TestRunOnceFlag(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer srv.Close()
+
+	c := &Config{
+		TargetConfigs: []endpoints.TargetConfig{
+			{
+				URLs: []endpoints.TargetURL{{URL: srv.URL}},
+			},
+		},
+		Emitters:          []string{"stdout"},
+		DisableKubernetes: true,
+		ScrapeDuration:    "500ms",
+		ScrapeTimeout:     time.Duration(500) * time.Millisecond,
+		Once:              true,
+	}
+	require.NoError(t, Run(c))
+
+	c.TargetConfigs[0].URLs = append(c.TargetConfigs[0].URLs, endpoints.TargetURL{URL: "127.1.1.0:9012"})
+	err := Run(c)
+	require.Error(t, err, "the cycle fails if a target can't be scraped")
+	assert.Equal(t, ErrorScrape, ErrorClass(err))
+}
+
+type recordingEmitter struct {
+	metrics []integration.Metric
+}
+
+func (e *recordingEmitter) Name() string { return "recording" }
+
+func (e *recordingEmitter) Emit(metrics []integration.Metric) error {
+	e.metrics = append(e.metrics, metrics...)
+	return nil
+}
+
+func TestRunOnceRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup{token=\"secret\"} 1\n"))
+	}))
+	defer srv.Close()
+
+	c := &Config{
+		TargetConfigs: []endpoints.TargetConfig{
+			{
+				URLs: []endpoints.TargetURL{{URL: srv.URL}},
+			},
+		},
+		Emitters:               []string{"stdout"},
+		DisableKubernetes:      true,
+		DisableLicenseKeyCheck: true,
+		ScrapeDuration:         "500ms",
+		ScrapeTimeout:          time.Duration(500) * time.Millisecond,
+		Once:                   true,
+		Redaction:              integration.RedactionConfig{Names: []string{"token"}},
+	}
+	require.NoError(t, validateConfig(c))
+	emitter := &recordingEmitter{}
+	require.NoError(t, RunCycleWithEmitters(c, []integration.Emitter{emitter}))
+
+	var up []integration.Metric
+	for _, m := range emitter.metrics {
+		if m.Name() == "up" {
+			up = append(up, m)
+		}
+	}
+	require.Len(t, up, 1)
+	assert.Equal(t, "[REDACTED]", up[0].Attributes()["token"])
+}
+
+type fakeAuthEmitter struct {
+	integration.StdoutEmitter
+	errs  []error
+	calls int
+}
+
+func (e *fakeAuthEmitter) CheckAuth(_ context.Context) error {
+	err := e.errs[e.calls]
+	e.calls++
+	return err
+}
+
+func TestCheckEmittersAuth(t *testing.T) {
+	rejected := fmt.Errorf("%w: 403 Forbidden", integration.ErrLicenseKeyRejected)
+
+	err := checkEmittersAuth(context.Background(), []integration.Emitter{
+		integration.NewStdoutEmitter(),
+		&fakeAuthEmitter{errs: []error{fmt.Errorf("connection refused")}},
+	})
+	assert.NoError(t, err, "unreachable endpoints must not be considered a rejection")
+
+	err = checkEmittersAuth(context.Background(), []integration.Emitter{
+		&fakeAuthEmitter{errs: []error{rejected}},
+	})
+	assert.True(t, errors.Is(err, integration.ErrLicenseKeyRejected))
+}
+
+func TestWaitForEmittersAuth(t *testing.T) {
+	defer func(interval time.Duration) { authRetryInterval = interval }(authRetryInterval)
+	authRetryInterval = time.Millisecond
+
+	unreachable := fmt.Errorf("connection refused")
+	e := &fakeAuthEmitter{errs: []error{unreachable, unreachable, nil}}
+	err := waitForEmittersAuth(context.Background(), []integration.Emitter{e})
+	require.NoError(t, err)
+	assert.Equal(t, 3, e.calls)
+
+	// The rejected credentials aren't verified again.
+	rejected := fmt.Errorf("%w: 403 Forbidden", integration.ErrLicenseKeyRejected)
+	e = &fakeAuthEmitter{errs: []error{unreachable, rejected, nil}}
+	err = waitForEmittersAuth(context.Background(), []integration.Emitter{e})
+	assert.True(t, errors.Is(err, integration.ErrLicenseKeyRejected))
+	assert.Equal(t, 2, e.calls)
+	assert.Equal(t, ErrorAuth, ErrorClass(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e = &fakeAuthEmitter{errs: []error{unreachable}}
+	err = waitForEmittersAuth(ctx, []integration.Emitter{e})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	r := &readiness{}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	r.set(true)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+type degradedRetriever struct {
+	endpoints.TargetRetriever
+	degraded []string
+}
+
+func (d degradedRetriever) Degraded() []string { return d.degraded }
+
+func TestReadinessHandler_Degraded(t *testing.T) {
+	fixed, err := endpoints.FixedRetriever()
+	require.NoError(t, err)
+	r := &readiness{retrievers: []endpoints.TargetRetriever{
+		fixed,
+		degradedRetriever{TargetRetriever: fixed, degraded: []string{"kubernetes/pod", "kubernetes/service"}},
+	}}
+	r.set(true)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok, degraded: kubernetes/pod, kubernetes/service", rec.Body.String())
+}
+
+func TestReadinessHandler_DegradedWithNetworkPolicy(t *testing.T) {
+	fixed, err := endpoints.FixedRetriever()
+	require.NoError(t, err)
+	retriever, err := endpoints.WithNetworkPolicy(
+		degradedRetriever{TargetRetriever: fixed, degraded: []string{"kubernetes/pod"}},
+		endpoints.NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}},
+	)
+	require.NoError(t, err)
+	r := &readiness{retrievers: []endpoints.TargetRetriever{retriever}}
+	r.set(true)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok, degraded: kubernetes/pod", rec.Body.String())
+}
+
+func TestValidateFIPS(t *testing.T) {
+	target := func(tlsConfig endpoints.TLSConfig, sshProxy endpoints.SSHProxyConfig) *Config {
+		return &Config{TargetConfigs: []endpoints.TargetConfig{{
+			Description: "exporter",
+			URLs:        []endpoints.TargetURL{{URL: "https://exporter:9100"}},
+			TLSConfig:   tlsConfig,
+			SSHProxy:    sshProxy,
+		}}}
+	}
+
+	assert.NoError(t, validateFIPS(target(endpoints.TLSConfig{}, endpoints.SSHProxyConfig{})))
+	assert.NoError(t, validateFIPS(target(endpoints.TLSConfig{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}, endpoints.SSHProxyConfig{})))
+	assert.Error(t, validateFIPS(target(endpoints.TLSConfig{MinVersion: "1.0"}, endpoints.SSHProxyConfig{})))
+	assert.Error(t, validateFIPS(target(endpoints.TLSConfig{
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305"},
+	}, endpoints.SSHProxyConfig{})))
+	assert.Error(t, validateFIPS(target(endpoints.TLSConfig{}, endpoints.SSHProxyConfig{Host: "bastion", User: "ops"})))
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/fips"
+)
+
+// DefaultServerAddress is the address the integration server listens on
+// when it isn't configured.
+const DefaultServerAddress = ":8080"
+
+// ServerConfig configures the HTTP server of the integration, serving its own
+// metrics, the readiness probe and the debug and admin endpoints. The
+// integration scrapes its own metrics from it, unless they are gathered in
+// process.
+type ServerConfig struct {
+	// Disabled stops serving the endpoints, and scraping the integration
+	// metrics.
+	Disabled bool `mapstructure:"disabled"`
+	// Address is the host and port the server listens on. Defaults to :8080.
+	Address string `mapstructure:"address"`
+	// TLS makes the server use HTTPS when the certificate and key are set.
+	TLS ServerTLSConfig `mapstructure:"tls"`
+	// BasicAuth requires the requests to be authenticated with the username
+	// and password, when set. The readiness probe and the admin API, which has
+	// its own token, don't require it.
+	BasicAuth ServerBasicAuth `mapstructure:"basic_auth"`
+	// InternalSelfMetrics sends the integration metrics through the pipeline
+	// gathering them in process, instead of scraping them from the server,
+	// even if it's disabled.
+	InternalSelfMetrics bool `mapstructure:"internal_self_metrics"`
+	// SelfScrape configures the scrape of the integration metrics.
+	SelfScrape SelfScrapeConfig `mapstructure:"self_scrape"`
+}
+
+// SelfScrapeConfig configures the target of the integration metrics.
+type SelfScrapeConfig struct {
+	// Disabled stops sending the integration metrics, while the server keeps
+	// serving them.
+	Disabled bool `mapstructure:"disabled"`
+	// Port is the port the integration metrics are scraped from, when it
+	// isn't the one of the server address, e.g. when it's published through
+	// another one.
+	Port int `mapstructure:"port"`
+	// MetricNamespace prefixes the names of the integration metrics, to tell
+	// them apart from the ones of the other targets.
+	MetricNamespace string `mapstructure:"metric_namespace"`
+}
+
+// ServerTLSConfig has the certificate the server is served with.
+type ServerTLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// Enabled returns true if the server is served over TLS.
+func (c ServerTLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ServerBasicAuth are the credentials required by the server.
+type ServerBasicAuth struct {
+	Username string `mapstructure:"username"`
+	Password Secret `mapstructure:"password"`
+	// PasswordFile is a file with the password, read when Password is empty.
+	PasswordFile string `mapstructure:"password_file"`
+}
+
+// Enabled returns true if the server requires credentials.
+func (c ServerBasicAuth) Enabled() bool {
+	return c.Username != ""
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *ServerConfig) Validate() error {
+	if c.Address == "" {
+		c.Address = DefaultServerAddress
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid address %q: %w", c.Address, err)
+	}
+	if c.SelfScrape.Port < 0 || c.SelfScrape.Port > 65535 {
+		return fmt.Errorf("invalid self_scrape port %d", c.SelfScrape.Port)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires both cert_file and key_file")
+	}
+	if !c.BasicAuth.Enabled() {
+		return nil
+	}
+	if c.BasicAuth.Password == "" && c.BasicAuth.PasswordFile != "" {
+		password, err := ioutil.ReadFile(c.BasicAuth.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("reading basic_auth password_file: %w", err)
+		}
+		c.BasicAuth.Password = Secret(strings.TrimSpace(string(password)))
+	}
+	if c.BasicAuth.Password == "" {
+		return fmt.Errorf("basic_auth requires a password or password_file")
+	}
+	return nil
+}
+
+// scrapesSelf returns true if the integration metrics are sent, either
+// scraped from the server or gathered in process.
+func (c ServerConfig) scrapesSelf() bool {
+	return !c.SelfScrape.Disabled && (!c.Disabled || c.InternalSelfMetrics)
+}
+
+// selfTarget returns the target of the integration metrics served by the
+// server. It's scraped through localhost unless the server listens on a
+// specific address, or gathered in process.
+func (c ServerConfig) selfTarget() endpoints.TargetURL {
+	target := endpoints.TargetURL{MetricNamespace: c.SelfScrape.MetricNamespace}
+	if c.InternalSelfMetrics {
+		target.URL = endpoints.SelfInternalURL
+		return target
+	}
+	host, port, _ := net.SplitHostPort(c.Address)
+	if c.SelfScrape.Port != 0 {
+		port = strconv.Itoa(c.SelfScrape.Port)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, port), Path: "/metrics"}
+	if c.TLS.Enabled() {
+		u.Scheme = "https"
+		// The certificate is the integration own one, which is usually not
+		// issued for the address it's scraped through.
+		target.TLSConfig.InsecureSkipVerify = true
+	}
+	if c.BasicAuth.Enabled() {
+		u.User = url.UserPassword(c.BasicAuth.Username, string(c.BasicAuth.Password))
+	}
+	target.URL = u.String()
+	return target
+}
+
+// handler wraps the given handler to require the basic auth credentials,
+// except in the public paths and the ones under a public prefix ending in /.
+func (c ServerBasicAuth) handler(next http.Handler, public ...string) http.Handler {
+	if !c.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range public {
+			if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(c.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(c.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nri-prometheus"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listen serves the handler until the server is closed.
+func (c ServerConfig) listen(server *http.Server) error {
+	if c.TLS.Enabled() {
+		server.TLSConfig = fips.Apply(server.TLSConfig)
+		return server.ListenAndServeTLS(c.TLS.CertFile, c.TLS.KeyFile)
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OnlyMetricsConfig is an allowlist of the metrics, by the name they are
+// scraped with. When any entry is configured, the metrics not matching any
+// of them are not emitted.
+type OnlyMetricsConfig struct {
+	// Prefixes of the names of the allowed metrics.
+	Prefixes []string `mapstructure:"prefixes"`
+	// Patterns are regular expressions matching the whole names of the
+	// allowed metrics.
+	Patterns []string `mapstructure:"patterns"`
+
+	compiled []*regexp.Regexp
+}
+
+// Enabled returns true if any entry is configured.
+func (c OnlyMetricsConfig) Enabled() bool {
+	return len(c.Prefixes) > 0 || len(c.Patterns) > 0
+}
+
+// Validate returns an error if any of the patterns is not valid.
+func (c *OnlyMetricsConfig) Validate() error {
+	c.compiled = make([]*regexp.Regexp, 0, len(c.Patterns))
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		c.compiled = append(c.compiled, re)
+	}
+	return nil
+}
+
+// allowlist keeps the metrics matching the entries of the validated
+// OnlyMetricsConfig.
+type allowlist struct {
+	cfg OnlyMetricsConfig
+	log *logrus.Entry
+
+	mtx sync.Mutex
+	// allowed caches if the metrics are allowed, by name.
+	allowed map[string]bool
+	// matched counts the metrics matched by each entry until the first scrape
+	// cycle is reported, prefixes first.
+	matched  []int
+	reported bool
+}
+
+func newAllowlist(cfg OnlyMetricsConfig) *allowlist {
+	return &allowlist{
+		cfg:     cfg,
+		log:     logrus.WithField("component", "only_metrics"),
+		allowed: make(map[string]bool),
+		matched: make([]int, len(cfg.Prefixes)+len(cfg.compiled)),
+	}
+}
+
+// AllowlistProcessor wraps the given Processor, dropping the scraped metrics
+// that don't match the allowlist before they are processed. The entries
+// that didn't match any metric in the first scrape cycle are reported.
+func AllowlistProcessor(cfg OnlyMetricsConfig, next Processor, queueLength int) Processor {
+	a := newAllowlist(cfg)
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		allowed := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(allowed)
+			for pair := range pairs {
+				a.apply(&pair)
+				allowed <- pair
+			}
+			a.report()
+		}()
+		return next(allowed)
+	}
+}
+
+// apply removes from the pair the metrics not in the allowlist.
+func (a *allowlist) apply(pair *TargetMetrics) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	kept := pair.Metrics[:0]
+	for _, m := range pair.Metrics {
+		if a.isAllowed(m.name) {
+			kept = append(kept, m)
+		} else {
+			DefaultDroppedSampler.Sample(DroppedByOnlyMetrics, pair.Target.Name, m)
+		}
+	}
+	pair.Metrics = kept
+}
+
+// isAllowed returns true if the metric name matches any entry, counting the
+// matches of each entry until they are reported.
+func (a *allowlist) isAllowed(name string) bool {
+	if !a.reported {
+		return a.match(name)
+	}
+	allowed, ok := a.allowed[name]
+	if !ok {
+		allowed = a.match(name)
+		a.allowed[name] = allowed
+	}
+	return allowed
+}
+
+func (a *allowlist) match(name string) bool {
+	allowed := false
+	count := func(i int) {
+		allowed = true
+		if !a.reported {
+			a.matched[i]++
+		}
+	}
+	for i, prefix := range a.cfg.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			count(i)
+		}
+	}
+	for i, re := range a.cfg.compiled {
+		if re.MatchString(name) {
+			count(len(a.cfg.Prefixes) + i)
+		}
+	}
+	return allowed
+}
+
+// report logs the entries that didn't match any metric, the first time it's
+// called.
+func (a *allowlist) report() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.reported {
+		return
+	}
+	a.reported = true
+	var unmatched []string
+	for i, prefix := range a.cfg.Prefixes {
+		if a.matched[i] == 0 {
+			unmatched = append(unmatched, "prefix "+prefix)
+		}
+	}
+	for i := range a.cfg.compiled {
+		if a.matched[len(a.cfg.Prefixes)+i] == 0 {
+			unmatched = append(unmatched, "pattern "+a.cfg.Patterns[i])
+		}
+	}
+	if len(unmatched) > 0 {
+		a.log.Warnf("only_metrics entries that didn't match any metric in the first scrape cycle: %s", strings.Join(unmatched, ", "))
+		return
+	}
+	a.log.Info("all the only_metrics entries matched metrics in the first scrape cycle")
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestOnlyMetricsConfigValidate(t *testing.T) {
+	assert.False(t, OnlyMetricsConfig{}.Enabled())
+	assert.True(t, OnlyMetricsConfig{Patterns: []string{"up"}}.Enabled())
+	assert.Error(t, (&OnlyMetricsConfig{Patterns: []string{"("}}).Validate())
+}
+
+func TestAllowlist(t *testing.T) {
+	cfg := OnlyMetricsConfig{
+		Prefixes: []string{"kube_deployment_", "unused_"},
+		Patterns: []string{"http_requests_(total|errors)", "unused"},
+	}
+	require.NoError(t, cfg.Validate())
+	metrics := func() []Metric {
+		return []Metric{
+			{name: "kube_deployment_replicas", attributes: labels.Set{}},
+			{name: "http_requests_total", attributes: labels.Set{}},
+			{name: "http_requests_total_created", attributes: labels.Set{}},
+			{name: "go_goroutines", attributes: labels.Set{}},
+		}
+	}
+	names := func(pair TargetMetrics) []string {
+		var names []string
+		for _, m := range pair.Metrics {
+			names = append(names, m.name)
+		}
+		return names
+	}
+
+	a := newAllowlist(cfg)
+	pair := TargetMetrics{Metrics: metrics()}
+	a.apply(&pair)
+	assert.Equal(t, []string{"kube_deployment_replicas", "http_requests_total"}, names(pair))
+	assert.Equal(t, []int{1, 0, 1, 0}, a.matched)
+	a.report()
+	assert.True(t, a.reported)
+
+	pair = TargetMetrics{Metrics: metrics()}
+	a.apply(&pair)
+	assert.Equal(t, []string{"kube_deployment_replicas", "http_requests_total"}, names(pair))
+	assert.Equal(t, []int{1, 0, 1, 0}, a.matched, "the matches are not counted after the report")
+
+	pairs := make(chan TargetMetrics, 1)
+	pairs <- TargetMetrics{Metrics: metrics()}
+	close(pairs)
+	var processed []string
+	for pair := range AllowlistProcessor(cfg, RuleProcessor(nil, 1), 1)(pairs) {
+		processed = append(processed, names(pair)...)
+	}
+	assert.Equal(t, []string{"kube_deployment_replicas", "http_requests_total"}, processed)
+}
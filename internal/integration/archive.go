@@ -0,0 +1,249 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// ArchiveFileSuffix is the suffix of the archived payloads, which are named
+// after the Unix time in milliseconds they were scraped at.
+const ArchiveFileSuffix = ".prom.gz"
+
+// PayloadArchiveConfig configures the archive of the raw payloads of the
+// selected targets, to replay them through the rules when investigating a
+// discrepancy.
+type PayloadArchiveConfig struct {
+	// Dir is the directory the payloads are archived in, in a subdirectory
+	// per target. Empty disables the archive.
+	Dir string `mapstructure:"dir"`
+	// Targets are regular expressions matching the whole names of the
+	// targets whose payloads are archived.
+	Targets []string `mapstructure:"targets"`
+	// MaxAge is the time the payloads are kept. Defaults to 24h.
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MaxFiles is the maximum number of payloads kept for each target.
+	// Defaults to 100.
+	MaxFiles int `mapstructure:"max_files"`
+
+	compiled []*regexp.Regexp
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// defaults.
+func (c *PayloadArchiveConfig) Validate() error {
+	if c.Dir == "" {
+		return nil
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("targets is required")
+	}
+	if c.MaxAge < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("max_age and max_files can't be negative")
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 24 * time.Hour
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = 100
+	}
+	c.compiled = make([]*regexp.Regexp, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		re, err := regexp.Compile("^(?:" + t + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid target pattern %q: %w", t, err)
+		}
+		c.compiled = append(c.compiled, re)
+	}
+	return nil
+}
+
+// PayloadArchive stores the raw payloads of the selected targets, compressed,
+// removing the ones exceeding the retention limits.
+type PayloadArchive struct {
+	cfg PayloadArchiveConfig
+	log *logrus.Entry
+	now func() time.Time
+
+	mtx sync.Mutex
+}
+
+// NewPayloadArchive returns a PayloadArchive with the validated
+// configuration, creating its directory.
+func NewPayloadArchive(cfg PayloadArchiveConfig) (*PayloadArchive, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating the payload archive directory: %w", err)
+	}
+	return &PayloadArchive{cfg: cfg, log: logrus.WithField("component", "PayloadArchive"), now: time.Now}, nil
+}
+
+// FetcherWithPayloadArchive makes the Fetcher archive the payloads of the
+// selected targets as they are read.
+func FetcherWithPayloadArchive(a *PayloadArchive) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.archive = a
+	}
+}
+
+// selected returns true if the payloads of the target are archived.
+func (a *PayloadArchive) selected(target string) bool {
+	for _, re := range a.cfg.compiled {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetDir returns the directory the payloads of the target are archived in.
+func TargetDir(dir, target string) string {
+	return filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(target, "_"))
+}
+
+// create returns a writer of a new archived payload of the target, after
+// removing the ones exceeding the retention limits.
+func (a *PayloadArchive) create(target string) (io.WriteCloser, error) {
+	dir := TargetDir(a.cfg.Dir, target)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	now := a.now()
+	a.prune(dir, now)
+	f, err := os.OpenFile(filepath.Join(dir, strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)+ArchiveFileSuffix),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &archivedPayload{f: f, gz: gzip.NewWriter(f)}, nil
+}
+
+// prune removes the payloads of the directory older than the maximum age,
+// and the oldest ones leaving room for a new one within the maximum number.
+func (a *PayloadArchive) prune(dir string, now time.Time) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		a.log.WithError(err).Warn("can't remove the expired payloads")
+		return
+	}
+	var names []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ArchiveFileSuffix) {
+			names = append(names, f.Name())
+		}
+	}
+	// The names are timestamps with the same number of digits.
+	sort.Strings(names)
+	for i, name := range names {
+		scraped, ok := ArchivedAt(name)
+		if i >= len(names)-a.cfg.MaxFiles+1 && ok && now.Sub(scraped) <= a.cfg.MaxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			a.log.WithError(err).Warn("can't remove an expired payload")
+		}
+	}
+}
+
+// ArchivedAt returns the time the archived payload with the given file name
+// was scraped at.
+func ArchivedAt(name string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), ArchiveFileSuffix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// archivedPayload compresses a payload into its file.
+type archivedPayload struct {
+	f  *os.File
+	gz *gzip.Writer
+}
+
+func (p *archivedPayload) Write(b []byte) (int, error) {
+	return p.gz.Write(b)
+}
+
+func (p *archivedPayload) Close() error {
+	err := p.gz.Close()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// archiveDoer archives the payloads of a target as they are read.
+type archiveDoer struct {
+	inner   prometheus.HTTPDoer
+	target  string
+	archive *PayloadArchive
+}
+
+// Do does the request and wraps the body of the response to copy it to the
+// archive while it's read.
+func (d archiveDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.inner.Do(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 300 {
+		return resp, err
+	}
+	w, err := d.archive.create(d.target)
+	if err != nil {
+		d.archive.log.WithError(err).WithField("target", d.target).Warn("can't archive the payload")
+		return resp, nil
+	}
+	resp.Body = &archiveReader{ReadCloser: resp.Body, w: w, log: d.archive.log.WithField("target", d.target)}
+	return resp, nil
+}
+
+// archiveReader copies the read payload to the archive, which is closed with
+// it.
+type archiveReader struct {
+	io.ReadCloser
+	w   io.WriteCloser
+	log *logrus.Entry
+	err error
+}
+
+func (r *archiveReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && r.err == nil {
+		if _, r.err = r.w.Write(p[:n]); r.err != nil {
+			r.log.WithError(r.err).Warn("can't archive the payload")
+		}
+	}
+	return n, err
+}
+
+func (r *archiveReader) Close() error {
+	if err := r.w.Close(); err != nil && r.err == nil {
+		r.log.WithError(err).Warn("can't archive the payload")
+	}
+	return r.ReadCloser.Close()
+}
+
+// withPayloadArchive returns the client archiving the payloads of the target,
+// if the fetcher has an archive and the target is selected.
+func (pf *prometheusFetcher) withPayloadArchive(target string, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if pf.archive == nil || !pf.archive.selected(target) {
+		return c
+	}
+	return archiveDoer{inner: c, target: target, archive: pf.archive}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func newTestArchive(t *testing.T, dir string, cfg PayloadArchiveConfig) *PayloadArchive {
+	t.Helper()
+	cfg.Dir = filepath.Join(dir, "archive")
+	require.NoError(t, cfg.Validate())
+	a, err := NewPayloadArchive(cfg)
+	require.NoError(t, err)
+	return a
+}
+
+func readArchived(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestPayloadArchiveConfigValidate(t *testing.T) {
+	assert.NoError(t, (&PayloadArchiveConfig{}).Validate(), "disabled")
+	assert.Error(t, (&PayloadArchiveConfig{Dir: "archive"}).Validate(), "without targets")
+	assert.Error(t, (&PayloadArchiveConfig{Dir: "archive", Targets: []string{"("}}).Validate())
+
+	cfg := PayloadArchiveConfig{Dir: "archive", Targets: []string{"app-.*"}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, 24*time.Hour, cfg.MaxAge)
+	assert.Equal(t, 100, cfg.MaxFiles)
+}
+
+func TestFetcher_PayloadArchive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	targets := []endpoints.Target{
+		endpoints.New("app-1", *addr, endpoints.Object{}),
+		endpoints.New("other", *addr, endpoints.Object{}),
+	}
+
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := newTestArchive(t, dir, PayloadArchiveConfig{Targets: []string{"app-.*"}})
+	now := time.Unix(1600000000, 0)
+	a.now = func() time.Time { return now }
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithPayloadArchive(a))
+	var scraped int
+	for range fetcher.Fetch(context.Background(), targets) {
+		scraped++
+	}
+	assert.Equal(t, 2, scraped)
+
+	archived, err := filepath.Glob(filepath.Join(a.cfg.Dir, "*", "*"))
+	require.NoError(t, err)
+	require.Len(t, archived, 1, "only the selected targets are archived")
+	assert.Equal(t, filepath.Join(TargetDir(a.cfg.Dir, "app-1"), "1600000000000"+ArchiveFileSuffix), archived[0])
+	assert.Equal(t, "# TYPE up gauge\nup 1\n", readArchived(t, archived[0]))
+	at, ok := ArchivedAt(archived[0])
+	assert.True(t, ok)
+	assert.Equal(t, now, at)
+}
+
+func TestPayloadArchive_Retention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := newTestArchive(t, dir, PayloadArchiveConfig{Targets: []string{".*"}, MaxAge: time.Hour, MaxFiles: 3})
+	now := time.Unix(1600000000, 0)
+	a.now = func() time.Time { return now }
+	archive := func() {
+		w, err := a.create("target")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		now = now.Add(20 * time.Minute)
+	}
+	names := func() []string {
+		files, err := ioutil.ReadDir(TargetDir(a.cfg.Dir, "target"))
+		require.NoError(t, err)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		return names
+	}
+
+	for i := 0; i < 4; i++ {
+		archive()
+	}
+	assert.Equal(t, []string{"1600001200000.prom.gz", "1600002400000.prom.gz", "1600003600000.prom.gz"}, names(),
+		"the oldest ones beyond max_files are removed")
+
+	now = now.Add(time.Hour)
+	archive()
+	assert.Equal(t, []string{"1600008400000.prom.gz"}, names(), "the ones older than max_age are removed")
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+// auditRoundTripper logs the SHA-256 digest of the body of every request,
+// and its HMAC-SHA256 signature when there is a key, along with a batch id,
+// the targets of its datapoints and their number.
+type auditRoundTripper struct {
+	hmacKey []byte
+	rt      http.RoundTripper
+	log     *logrus.Entry
+}
+
+// TelemetryHarvesterWithAuditDigest wraps the emitter client Transport to log
+// the digest of each emitted payload, signed with the key if it isn't empty,
+// so it can be verified later what was sent.
+//
+// It must be set after the options that change the transport, and before the
+// compression one, so the digest is the one of the bytes that are sent.
+func TelemetryHarvesterWithAuditDigest(hmacKey []byte) TelemetryHarvesterOpt {
+	return func(cfg *telemetry.Config) {
+		cfg.Client.Transport = newAuditRoundTripper(cfg.Client.Transport, hmacKey)
+	}
+}
+
+// newAuditRoundTripper wraps the given http.RoundTripper to log the digest of
+// the request bodies.
+func newAuditRoundTripper(rt http.RoundTripper, hmacKey []byte) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return auditRoundTripper{
+		hmacKey: hmacKey,
+		rt:      rt,
+		log:     logrus.WithField("component", "audit"),
+	}
+}
+
+// RoundTrip logs the digest of the request body before sending it.
+func (t auditRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return t.rt.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(body)
+	fields := logrus.Fields{
+		"batchId": newBatchID(),
+		"url":     req.URL.Path,
+		"bytes":   len(body),
+		"sha256":  hex.EncodeToString(digest[:]),
+	}
+	if len(t.hmacKey) > 0 {
+		mac := hmac.New(sha256.New, t.hmacKey)
+		_, _ = mac.Write(body)
+		fields["hmacSha256"] = hex.EncodeToString(mac.Sum(nil))
+	}
+	targets, datapoints, err := auditPayload(body, req.Header.Get("Content-Encoding") == "gzip")
+	if err != nil {
+		fields["decodeError"] = err.Error()
+	} else {
+		fields["targets"] = targets
+		fields["datapoints"] = datapoints
+	}
+	t.log.WithFields(fields).Info("emitting batch")
+
+	// RoundTrippers must not modify the original request.
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// auditBatch is a batch of metrics of the Metric API, or an event of the
+// Event API, that only has its targetName at the top level.
+type auditBatch struct {
+	Common struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"common"`
+	Metrics []struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"metrics"`
+	TargetName interface{} `json:"targetName"`
+}
+
+// auditPayload returns the sorted names of the targets of the datapoints in
+// the payload, and the number of datapoints.
+func auditPayload(body []byte, gzipped bool) ([]string, int, error) {
+	var r io.Reader = bytes.NewReader(body)
+	if gzipped {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	var batches []auditBatch
+	if err := json.NewDecoder(r).Decode(&batches); err != nil {
+		return nil, 0, err
+	}
+
+	names := make(map[string]struct{})
+	addTarget := func(name interface{}) {
+		if s, ok := name.(string); ok && s != "" {
+			names[s] = struct{}{}
+		}
+	}
+	datapoints := 0
+	for _, b := range batches {
+		if b.Metrics == nil {
+			// An event.
+			addTarget(b.TargetName)
+			datapoints++
+			continue
+		}
+		addTarget(b.Common.Attributes["targetName"])
+		for _, m := range b.Metrics {
+			addTarget(m.Attributes["targetName"])
+		}
+		datapoints += len(b.Metrics)
+	}
+
+	targets := make([]string, 0, len(names))
+	for name := range names {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	return targets, datapoints, nil
+}
+
+// newBatchID returns a random id identifying a batch in the logs.
+func newBatchID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditPayload(t *testing.T) {
+	metrics := `[{
+		"common": {"attributes": {"clusterName": "c"}},
+		"metrics": [
+			{"name": "a", "attributes": {"targetName": "target-b"}},
+			{"name": "b", "attributes": {"targetName": "target-a"}},
+			{"name": "c", "attributes": {"targetName": "target-b"}}
+		]
+	}]`
+	targets, datapoints, err := auditPayload([]byte(metrics), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"target-a", "target-b"}, targets)
+	assert.Equal(t, 3, datapoints)
+
+	events := `[{"eventType": "PrometheusThresholdEvent", "targetName": "target-a"}, {"eventType": "Heartbeat"}]`
+	targets, datapoints, err = auditPayload([]byte(events), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"target-a"}, targets)
+	assert.Equal(t, 2, datapoints)
+
+	_, _, err = auditPayload([]byte("not json"), false)
+	assert.Error(t, err)
+}
+
+func TestAuditRoundTripper(t *testing.T) {
+	payload := `[{"common": {}, "metrics": [{"name": "a", "attributes": {"targetName": "target-a"}}]}]`
+	req, err := http.NewRequest(http.MethodPost, "http://metrics/metric/v1", strings.NewReader(payload))
+	require.NoError(t, err)
+
+	var sent []byte
+	tr := newAuditRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent, err = ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		return emptyResponse(202), nil
+	}), []byte("key"))
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &logs
+	logger.Formatter = &logrus.JSONFormatter{}
+	audit := tr.(auditRoundTripper)
+	audit.log = logrus.NewEntry(logger)
+
+	_, err = audit.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(sent), "the payload is sent unchanged")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	digest := sha256.Sum256([]byte(payload))
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(payload))
+	assert.Equal(t, hex.EncodeToString(digest[:]), entry["sha256"])
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), entry["hmacSha256"])
+	assert.Equal(t, []interface{}{"target-a"}, entry["targets"])
+	assert.Equal(t, float64(1), entry["datapoints"])
+	assert.Equal(t, "/metric/v1", entry["url"])
+	assert.Len(t, entry["batchId"], 16)
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// defaultAutoDecorateFormat is the format of the names of the attributes
+// added by AutoDecorateLabels.
+const defaultAutoDecorateFormat = "{label}.{info}"
+
+// AutoDecorateConfig configures the automatic decoration of the metrics with
+// the labels of the info metrics of the same target, as AutoDecorateLabels
+// does.
+type AutoDecorateConfig struct {
+	// InfoMetrics are regular expressions matching the whole names of the
+	// info metrics decorating the others. Empty matches all of them.
+	InfoMetrics []string `mapstructure:"info_metrics"`
+	// Format is the name of the attributes added, where {label} is replaced
+	// with the name of the label and {info} with the one of the info metric.
+	// Defaults to {label}.{info}.
+	Format string `mapstructure:"format"`
+
+	compiled []*regexp.Regexp
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *AutoDecorateConfig) Validate() error {
+	if c.Format == "" {
+		c.Format = defaultAutoDecorateFormat
+	}
+	if !strings.Contains(c.Format, "{label}") {
+		return fmt.Errorf("format must contain {label}")
+	}
+	c.compiled = make([]*regexp.Regexp, 0, len(c.InfoMetrics))
+	for _, m := range c.InfoMetrics {
+		re, err := regexp.Compile("^(?:" + m + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid info metric pattern %q: %w", m, err)
+		}
+		c.compiled = append(c.compiled, re)
+	}
+	return nil
+}
+
+// AutoDecorateProcessor decorates the metrics of the targets with the labels
+// of their info metrics, before processing them with the next processor.
+func AutoDecorateProcessor(cfg AutoDecorateConfig, next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		decorated := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(decorated)
+			for pair := range pairs {
+				cfg.apply(&pair)
+				decorated <- pair
+			}
+		}()
+		return next(decorated)
+	}
+}
+
+// isInfo returns true if the metric is an info metric decorating the others.
+func (c AutoDecorateConfig) isInfo(m Metric) bool {
+	if !strings.HasSuffix(m.name, "_info") && m.attributes["promMetricType"] != "info" {
+		return false
+	}
+	if len(c.compiled) == 0 {
+		return true
+	}
+	for _, re := range c.compiled {
+		if re.MatchString(m.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply adds the labels of the info metrics to the other metrics, as
+// described in AutoDecorateLabels. The attributes added by the integration
+// to the info metrics are not taken into account.
+func (c AutoDecorateConfig) apply(targetMetrics *TargetMetrics) {
+	var infos []labels.InfoSource
+	for _, m := range targetMetrics.Metrics {
+		if !c.isInfo(m) {
+			continue
+		}
+		infoLabels := make(labels.Set, len(m.attributes))
+		for name, value := range m.attributes {
+			if !integrationAttributes[name] {
+				infoLabels[name] = value
+			}
+		}
+		infos = append(infos, labels.InfoSource{Name: m.name, Labels: infoLabels})
+	}
+	if len(infos) == 0 {
+		return
+	}
+	format := func(label, info string) string {
+		return strings.NewReplacer("{label}", label, "{info}", info).Replace(c.Format)
+	}
+	for _, m := range targetMetrics.Metrics {
+		if !strings.HasSuffix(m.name, "_info") && m.attributes["promMetricType"] != "info" {
+			labels.Accumulate(m.attributes, labels.ToAddFormatted(infos, m.attributes, format))
+		}
+	}
+	fingerprintMetrics(targetMetrics.Metrics)
+}
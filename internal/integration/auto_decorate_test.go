@@ -0,0 +1,44 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoDecorateConfigValidate(t *testing.T) {
+	cfg := AutoDecorateConfig{}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "{label}.{info}", cfg.Format)
+
+	assert.Error(t, (&AutoDecorateConfig{Format: "{info}"}).Validate())
+	assert.Error(t, (&AutoDecorateConfig{InfoMetrics: []string{"("}}).Validate())
+}
+
+func TestAutoDecorateProcessor(t *testing.T) {
+	cfg := AutoDecorateConfig{InfoMetrics: []string{"redis_instance_info"}, Format: "{info}_{label}"}
+	require.NoError(t, cfg.Validate())
+
+	pairs := make(chan TargetMetrics, 1)
+	pairs <- scrapeString(t, prometheusInput)
+	close(pairs)
+	var decorated []Metric
+	for pair := range AutoDecorateProcessor(cfg, RuleProcessor(nil, 1), 1)(pairs) {
+		decorated = append(decorated, pair.Metrics...)
+	}
+
+	var checked int
+	for _, m := range decorated {
+		if m.name != "redis_instantaneous_input_kbps" {
+			continue
+		}
+		checked++
+		assert.Contains(t, []interface{}{"master", "slave"}, m.attributes["redis_instance_info_role"])
+		assert.NotContains(t, m.attributes, "redis_exporter_build_info_version", "only the selected info metrics are used")
+		assert.NotContains(t, m.attributes, "role.redis_instance_info")
+	}
+	assert.Equal(t, 2, checked)
+}
@@ -106,6 +106,11 @@ func (h *boundedHarvester) RecordMetric(m telemetry.Metric) {
 	h.inner.RecordMetric(m)
 }
 
+// RecordEvent records the event in the underlying harvester.
+func (h *boundedHarvester) RecordEvent(e telemetry.Event) error {
+	return recordEvent(h.inner, e)
+}
+
 // HarvestNow forces a new report
 func (h *boundedHarvester) HarvestNow(ctx context.Context) {
 	h.reportIfNeeded(ctx, true)
@@ -122,6 +127,19 @@ func (h *boundedHarvester) Stop() {
 	}
 }
 
+// flush stops the periodic harvest and synchronously reports the stored metrics, so they are sent before
+// the process exits.
+func (h *boundedHarvester) flush(ctx context.Context) {
+	h.Stop()
+
+	h.mtx.Lock()
+	h.lastReport = time.Now()
+	h.storedMetrics = 0
+	h.mtx.Unlock()
+
+	h.inner.HarvestNow(ctx)
+}
+
 // reportIfNeeded carries the logic to report metrics.
 // A report is triggered if:
 // - Force is set to true, or
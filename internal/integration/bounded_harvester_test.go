@@ -150,6 +150,32 @@ func TestHarvestNow(t *testing.T) {
 	}
 }
 
+func TestFlush(t *testing.T) {
+	cfg := BoundedHarvesterCfg{
+		HarvestPeriod: time.Hour,
+	}
+
+	mock := &mockHarvester{}
+	h := bindHarvester(mock, cfg)
+
+	bh, ok := h.(*boundedHarvester)
+	if !ok {
+		t.Fatalf("returned harvester is not a boundedHarvester")
+	}
+
+	h.RecordMetric(telemetry.Gauge{})
+	harvesterDecorator{h}.flush(context.Background())
+	if mock.harvests != 1 {
+		t.Fatalf("flush did not synchronously trigger a harvest")
+	}
+	if bh.storedMetrics != 0 {
+		t.Fatalf("flush did not reset the stored metrics")
+	}
+	if !bh.stopped {
+		t.Fatalf("flush did not stop the harvest routine")
+	}
+}
+
 func TestMetricCap(t *testing.T) {
 	cfg := BoundedHarvesterCfg{
 		HarvestPeriod: time.Hour,
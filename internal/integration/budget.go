@@ -0,0 +1,202 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions applied to the metrics of a key that exceeded its budget.
+const (
+	// BudgetActionDrop drops the metrics until the window ends.
+	BudgetActionDrop = "drop"
+	// BudgetActionThrottle emits the metrics of each target at most once per
+	// throttle interval until the window ends.
+	BudgetActionThrottle = "throttle"
+)
+
+const (
+	defaultBudgetAttribute        = "namespaceName"
+	defaultBudgetWindow           = time.Hour
+	defaultBudgetThrottleInterval = 5 * time.Minute
+)
+
+// IngestBudgetConfig limits the datapoints emitted for each value of an
+// attribute, e.g. each Kubernetes namespace, in a time window.
+type IngestBudgetConfig struct {
+	// Attribute whose values are budgeted. Defaults to namespaceName. The
+	// metrics without it are not budgeted.
+	Attribute string `mapstructure:"attribute"`
+	// Window is the period the budgets are for. Defaults to 1h.
+	Window time.Duration `mapstructure:"window"`
+	// Action is applied to the metrics over budget: drop (default) or throttle.
+	Action string `mapstructure:"action"`
+	// ThrottleInterval is how often the metrics of a target over budget are
+	// emitted with the throttle action. Defaults to 5m.
+	ThrottleInterval time.Duration `mapstructure:"throttle_interval"`
+	// Default is the budget of the values not in Budgets. Zero means no limit.
+	Default int64 `mapstructure:"default"`
+	// Budgets are the datapoints allowed per window for each value.
+	Budgets map[string]int64 `mapstructure:"budgets"`
+}
+
+// Enabled returns true if any budget is configured.
+func (c IngestBudgetConfig) Enabled() bool {
+	return c.Default > 0 || len(c.Budgets) > 0
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *IngestBudgetConfig) Validate() error {
+	if c.Attribute == "" {
+		c.Attribute = defaultBudgetAttribute
+	}
+	if c.Window == 0 {
+		c.Window = defaultBudgetWindow
+	}
+	if c.ThrottleInterval == 0 {
+		c.ThrottleInterval = defaultBudgetThrottleInterval
+	}
+	switch c.Action {
+	case "":
+		c.Action = BudgetActionDrop
+	case BudgetActionDrop, BudgetActionThrottle:
+	default:
+		return fmt.Errorf("invalid action %q, must be one of: %s, %s", c.Action, BudgetActionDrop, BudgetActionThrottle)
+	}
+	if c.Window < 0 || c.ThrottleInterval < 0 {
+		return fmt.Errorf("window and throttle_interval can't be negative")
+	}
+	if c.Default < 0 {
+		return fmt.Errorf("default can't be negative")
+	}
+	for value, budget := range c.Budgets {
+		if budget < 0 {
+			return fmt.Errorf("budget of %q can't be negative", value)
+		}
+	}
+	return nil
+}
+
+// ingestBudgets accounts the datapoints emitted for each value of the
+// budgeted attribute in the current window.
+type ingestBudgets struct {
+	cfg IngestBudgetConfig
+	now func() time.Time
+
+	mtx         sync.Mutex
+	windowStart time.Time
+	used        map[string]int64
+	// lastEmitted is the last time the metrics of a target over budget were
+	// emitted with the throttle action, by value and target.
+	lastEmitted map[string]time.Time
+}
+
+func newIngestBudgets(cfg IngestBudgetConfig) *ingestBudgets {
+	return &ingestBudgets{
+		cfg:         cfg,
+		now:         time.Now,
+		used:        make(map[string]int64),
+		lastEmitted: make(map[string]time.Time),
+	}
+}
+
+// BudgetProcessor wraps the given Processor, applying the budgets to the
+// metrics it returns.
+func BudgetProcessor(cfg IngestBudgetConfig, next Processor, queueLength int) Processor {
+	budgets := newIngestBudgets(cfg)
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		budgeted := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(budgeted)
+			for pair := range next(pairs) {
+				budgets.apply(&pair)
+				budgeted <- pair
+			}
+		}()
+		return budgeted
+	}
+}
+
+// apply removes from the pair the metrics over budget.
+func (b *ingestBudgets) apply(pair *TargetMetrics) {
+	counts := make(map[string]int64)
+	for _, m := range pair.Metrics {
+		if value := b.value(m); value != "" {
+			counts[value]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	if now.Sub(b.windowStart) >= b.cfg.Window {
+		b.windowStart = now
+		b.used = make(map[string]int64)
+		b.lastEmitted = make(map[string]time.Time)
+		budgetUsageMetric.Reset()
+	}
+
+	dropped := make(map[string]bool)
+	for value, n := range counts {
+		budget, ok := b.cfg.Budgets[value]
+		if !ok {
+			budget = b.cfg.Default
+		}
+		if budget > 0 && !b.allowed(value, pair.Target.Name, b.used[value]+n > budget, now) {
+			dropped[value] = true
+			budgetDroppedMetric.WithLabelValues(value).Add(float64(n))
+			logrus.WithField("component", "budgets").Debugf("%s %s is over budget, dropping %d metrics of target %s",
+				b.cfg.Attribute, value, n, pair.Target.Name)
+			continue
+		}
+		b.used[value] += n
+		budgetDatapointsMetric.WithLabelValues(value).Add(float64(n))
+		if budget > 0 {
+			budgetUsageMetric.WithLabelValues(value).Set(float64(b.used[value]) / float64(budget))
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+
+	kept := pair.Metrics[:0]
+	for _, m := range pair.Metrics {
+		if !dropped[b.value(m)] {
+			kept = append(kept, m)
+		} else {
+			DefaultDroppedSampler.Sample(DroppedByBudget, pair.Target.Name, m)
+		}
+	}
+	pair.Metrics = kept
+}
+
+// value returns the value of the budgeted attribute of the metric, or an
+// empty string if the metric is not budgeted.
+func (b *ingestBudgets) value(m Metric) string {
+	value, _ := m.attributes[b.cfg.Attribute].(string)
+	return value
+}
+
+// allowed returns true if the metrics of the target can be emitted.
+func (b *ingestBudgets) allowed(value, target string, overBudget bool, now time.Time) bool {
+	if !overBudget {
+		return true
+	}
+	if b.cfg.Action != BudgetActionThrottle {
+		return false
+	}
+	key := value + "\x00" + target
+	if last, ok := b.lastEmitted[key]; ok && now.Sub(last) < b.cfg.ThrottleInterval {
+		return false
+	}
+	b.lastEmitted[key] = now
+	return true
+}
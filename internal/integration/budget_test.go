@@ -0,0 +1,80 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func budgetPair(target string, namespaces ...string) TargetMetrics {
+	pair := TargetMetrics{Target: endpoints.Target{Name: target}}
+	for _, ns := range namespaces {
+		attrs := labels.Set{}
+		if ns != "" {
+			attrs["namespaceName"] = ns
+		}
+		pair.Metrics = append(pair.Metrics, Metric{name: "m", value: 1.0, metricType: metricType_GAUGE, attributes: attrs})
+	}
+	return pair
+}
+
+func TestIngestBudgetsDrop(t *testing.T) {
+	cfg := IngestBudgetConfig{Budgets: map[string]int64{"a": 3}}
+	require.NoError(t, cfg.Validate())
+	b := newIngestBudgets(cfg)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	pair := budgetPair("t1", "a", "b", "a")
+	b.apply(&pair)
+	assert.Len(t, pair.Metrics, 3)
+
+	// The metrics of namespace a are over budget, the others are kept.
+	pair = budgetPair("t2", "a", "b", "a", "")
+	b.apply(&pair)
+	require.Len(t, pair.Metrics, 2)
+	assert.Equal(t, "b", pair.Metrics[0].attributes["namespaceName"])
+	assert.Nil(t, pair.Metrics[1].attributes["namespaceName"])
+
+	// Until the window ends.
+	now = now.Add(time.Hour)
+	pair = budgetPair("t2", "a", "a")
+	b.apply(&pair)
+	assert.Len(t, pair.Metrics, 2)
+}
+
+func TestIngestBudgetsThrottle(t *testing.T) {
+	cfg := IngestBudgetConfig{Default: 1, Action: BudgetActionThrottle, ThrottleInterval: time.Minute}
+	require.NoError(t, cfg.Validate())
+	b := newIngestBudgets(cfg)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	for _, expected := range []int{1, 1, 0} {
+		pair := budgetPair("t1", "a")
+		b.apply(&pair)
+		assert.Len(t, pair.Metrics, expected)
+	}
+	now = now.Add(time.Minute)
+	pair := budgetPair("t1", "a")
+	b.apply(&pair)
+	assert.Len(t, pair.Metrics, 1)
+}
+
+func TestIngestBudgetConfigValidate(t *testing.T) {
+	cfg := IngestBudgetConfig{}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "namespaceName", cfg.Attribute)
+	assert.Equal(t, BudgetActionDrop, cfg.Action)
+	assert.False(t, cfg.Enabled())
+
+	assert.Error(t, (&IngestBudgetConfig{Action: "sample"}).Validate())
+	assert.Error(t, (&IngestBudgetConfig{Budgets: map[string]int64{"a": -1}}).Validate())
+}
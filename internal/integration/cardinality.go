@@ -0,0 +1,202 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultCardinalityTracker tracks the cardinality of the metrics emitted by
+// the integration. It's disabled until its topN is set.
+var DefaultCardinalityTracker = NewCardinalityTracker(0)
+
+// CardinalityReport has the metrics with the most series and the attributes
+// with the most values in a scrape cycle.
+type CardinalityReport struct {
+	Time          time.Time              `json:"time"`
+	TopSeries     []MetricSeries         `json:"topSeries"`
+	TopAttributes []AttributeCardinality `json:"topAttributes"`
+}
+
+// MetricSeries is the number of unique series of a metric.
+type MetricSeries struct {
+	Metric string `json:"metric"`
+	Series int    `json:"series"`
+}
+
+// AttributeCardinality is the number of unique values of an attribute of a
+// metric.
+type AttributeCardinality struct {
+	Metric    string `json:"metric"`
+	Attribute string `json:"attribute"`
+	Values    int    `json:"values"`
+}
+
+// CardinalityTracker counts the unique series and attribute values of the
+// metrics observed in a scrape cycle, and keeps the top ones of the last
+// completed cycle.
+type CardinalityTracker struct {
+	seriesDesc    *prometheus.Desc
+	attributeDesc *prometheus.Desc
+	now           func() time.Time
+
+	mtx     sync.Mutex
+	topN    int
+	current map[string]*metricCardinality
+	report  CardinalityReport
+}
+
+// metricCardinality has the hashes of the series and attribute values of a
+// metric.
+type metricCardinality struct {
+	series map[uint64]struct{}
+	values map[string]map[uint64]struct{}
+}
+
+// NewCardinalityTracker returns a CardinalityTracker reporting the topN
+// metrics and attributes. Zero disables the tracking.
+func NewCardinalityTracker(topN int) *CardinalityTracker {
+	return &CardinalityTracker{
+		seriesDesc: prometheus.NewDesc(
+			"nr_stats_top_metric_series",
+			"The number of unique series of the metrics with the most series in the last scrape cycle",
+			[]string{"metric"},
+			nil,
+		),
+		attributeDesc: prometheus.NewDesc(
+			"nr_stats_top_attribute_cardinality",
+			"The number of unique values of the metric attributes with the most values in the last scrape cycle",
+			[]string{"metric", "attribute"},
+			nil,
+		),
+		now:     time.Now,
+		topN:    topN,
+		current: make(map[string]*metricCardinality),
+	}
+}
+
+// SetTopN changes the number of metrics and attributes reported. Zero
+// disables the tracking.
+func (c *CardinalityTracker) SetTopN(topN int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.topN = topN
+}
+
+// Observe accounts the series of the metrics in the current cycle.
+func (c *CardinalityTracker) Observe(metrics []Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.topN <= 0 {
+		return
+	}
+	for _, m := range metrics {
+		mc, ok := c.current[m.name]
+		if !ok {
+			mc = &metricCardinality{
+				series: make(map[uint64]struct{}),
+				values: make(map[string]map[uint64]struct{}),
+			}
+			c.current[m.name] = mc
+		}
+
+		for k, v := range m.attributes {
+			values, ok := mc.values[k]
+			if !ok {
+				values = make(map[uint64]struct{})
+				mc.values[k] = values
+			}
+			s, ok := v.(string)
+			if !ok {
+				s = fmt.Sprint(v)
+			}
+			values[xxhash.Sum64String(s)] = struct{}{}
+		}
+		mc.series[m.Fingerprint()] = struct{}{}
+	}
+}
+
+// Commit ends the current cycle, replacing the report with its top metrics
+// and attributes.
+func (c *CardinalityTracker) Commit() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.topN <= 0 {
+		return
+	}
+
+	report := CardinalityReport{Time: c.now()}
+	for name, mc := range c.current {
+		report.TopSeries = append(report.TopSeries, MetricSeries{Metric: name, Series: len(mc.series)})
+		for attribute, values := range mc.values {
+			report.TopAttributes = append(report.TopAttributes, AttributeCardinality{
+				Metric:    name,
+				Attribute: attribute,
+				Values:    len(values),
+			})
+		}
+	}
+	sort.Slice(report.TopSeries, func(i, j int) bool {
+		a, b := report.TopSeries[i], report.TopSeries[j]
+		if a.Series != b.Series {
+			return a.Series > b.Series
+		}
+		return a.Metric < b.Metric
+	})
+	sort.Slice(report.TopAttributes, func(i, j int) bool {
+		a, b := report.TopAttributes[i], report.TopAttributes[j]
+		if a.Values != b.Values {
+			return a.Values > b.Values
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Attribute < b.Attribute
+	})
+	if len(report.TopSeries) > c.topN {
+		report.TopSeries = report.TopSeries[:c.topN]
+	}
+	if len(report.TopAttributes) > c.topN {
+		report.TopAttributes = report.TopAttributes[:c.topN]
+	}
+	c.report = report
+	c.current = make(map[string]*metricCardinality)
+}
+
+// Report returns the top metrics and attributes of the last completed cycle.
+func (c *CardinalityTracker) Report() CardinalityReport {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.report
+}
+
+// Describe implements prometheus.Collector.
+func (c *CardinalityTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.seriesDesc
+	ch <- c.attributeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *CardinalityTracker) Collect(ch chan<- prometheus.Metric) {
+	report := c.Report()
+	for _, s := range report.TopSeries {
+		ch <- prometheus.MustNewConstMetric(c.seriesDesc, prometheus.GaugeValue, float64(s.Series), s.Metric)
+	}
+	for _, a := range report.TopAttributes {
+		ch <- prometheus.MustNewConstMetric(c.attributeDesc, prometheus.GaugeValue, float64(a.Values), a.Metric, a.Attribute)
+	}
+}
+
+// ServeHTTP returns the report of the last completed cycle as JSON.
+func (c *CardinalityTracker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.Report())
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestCardinalityTracker(t *testing.T) {
+	c := NewCardinalityTracker(2)
+	var metrics []Metric
+	for i := 0; i < 5; i++ {
+		metrics = append(metrics, Metric{
+			name:       "requests",
+			metricType: metricType_COUNTER,
+			attributes: labels.Set{"path": fmt.Sprintf("/%d", i), "method": "GET"},
+		})
+	}
+	metrics = append(metrics,
+		Metric{name: "up", metricType: metricType_GAUGE, attributes: labels.Set{"job": "a"}},
+		// The same series twice counts once.
+		Metric{name: "up", metricType: metricType_GAUGE, attributes: labels.Set{"job": "a"}},
+		Metric{name: "errors", metricType: metricType_COUNTER, attributes: labels.Set{"job": "a"}},
+	)
+	c.Observe(metrics)
+	assert.Empty(t, c.Report().TopSeries)
+	c.Commit()
+
+	report := c.Report()
+	assert.Equal(t, []MetricSeries{{Metric: "requests", Series: 5}, {Metric: "errors", Series: 1}}, report.TopSeries)
+	assert.Equal(t, []AttributeCardinality{
+		{Metric: "requests", Attribute: "path", Values: 5},
+		{Metric: "errors", Attribute: "job", Values: 1},
+	}, report.TopAttributes)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/cardinality", nil))
+	var served CardinalityReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, report.TopSeries, served.TopSeries)
+
+	// The next cycle starts from scratch.
+	c.Commit()
+	assert.Empty(t, c.Report().TopSeries)
+}
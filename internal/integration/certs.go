@@ -0,0 +1,240 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certificateFiles holds a client certificate and a CA bundle read from
+// files, reloading them when the files are rotated.
+type certificateFiles struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mtx     sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	roots   *x509.CertPool
+	rootMod time.Time
+}
+
+// newCertificateFiles loads the given files. Empty file names are ignored.
+func newCertificateFiles(caFile, certFile, keyFile string) (*certificateFiles, error) {
+	f := &certificateFiles{caFile: caFile, certFile: certFile, keyFile: keyFile}
+	// Fail early if the files can't be loaded.
+	if certFile != "" {
+		if _, err := f.certificate(); err != nil {
+			return nil, err
+		}
+	}
+	if caFile != "" {
+		if _, err := f.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// configure makes the TLS configuration use the files, reloading them on
+// every handshake if they were modified. The servers are verified with the
+// CA bundle loaded first, unless the connections are established by
+// verifiedTLSDialer, which verifies them with the current one.
+func (f *certificateFiles) configure(tlsConfig *tls.Config) {
+	if f.certFile != "" {
+		tlsConfig.GetClientCertificate = f.clientCertificate
+	}
+	if f.caFile != "" && !tlsConfig.InsecureSkipVerify {
+		if roots, err := f.rootCAs(); err == nil {
+			tlsConfig.RootCAs = roots
+		}
+		verifiedConfigs.Store(tlsConfig, f)
+	}
+}
+
+// verifiedConfigs has the certificate files whose CA bundle verifies the
+// servers of the TLS configurations set up by configure. RootCAs can't be
+// replaced once a configuration is in use, so the transports look them up
+// to verify their connections with the current bundle.
+var verifiedConfigs sync.Map
+
+// verifyingFiles returns the certificate files whose CA bundle verifies the
+// servers of the TLS configuration, if any.
+func verifyingFiles(tlsConfig *tls.Config) (*certificateFiles, bool) {
+	if tlsConfig == nil || tlsConfig.InsecureSkipVerify {
+		return nil, false
+	}
+	f, ok := verifiedConfigs.Load(tlsConfig)
+	if !ok {
+		return nil, false
+	}
+	return f.(*certificateFiles), true
+}
+
+func (f *certificateFiles) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return f.certificate()
+}
+
+// certificate returns the client certificate, reloading it if its files were
+// modified.
+func (f *certificateFiles) certificate() (*tls.Certificate, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	mod, err := lastModification(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			return f.cert, nil
+		}
+		return nil, err
+	}
+	if f.cert != nil && !mod.After(f.certMod) {
+		return f.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			// The files may be in the middle of a rotation.
+			return f.cert, nil
+		}
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+	observeCertificateExpiry(f.certFile, &cert)
+	f.cert = &cert
+	f.certMod = mod
+	return f.cert, nil
+}
+
+// rootCAs returns the CA bundle, reloading it if its file was modified.
+func (f *certificateFiles) rootCAs() (*x509.CertPool, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	mod, err := lastModification(f.caFile)
+	if err != nil {
+		if f.roots != nil {
+			return f.roots, nil
+		}
+		return nil, err
+	}
+	if f.roots != nil && !mod.After(f.rootMod) {
+		return f.roots, nil
+	}
+
+	content, err := ioutil.ReadFile(f.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use specified CA cert %s: %s", f.caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) && f.roots != nil {
+		return f.roots, nil
+	}
+	f.roots = pool
+	f.rootMod = mod
+	return f.roots, nil
+}
+
+// verifyPeerCertificate returns a tls.Config.VerifyPeerCertificate that
+// validates the server certificate chain and name as the tls package does,
+// but with the current CA bundle. The name must be the one the connection is
+// established to, as the function doesn't get it.
+func (f *certificateFiles) verifyPeerCertificate(serverName string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented by the server")
+		}
+		if serverName == "" {
+			return errors.New("no server name to verify the certificate against")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("parsing the server certificate: %w", err)
+			}
+			certs = append(certs, cert)
+		}
+		roots, err := f.rootCAs()
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err = certs[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// observeCertificateExpiry records the expiration of the leaf of a client
+// certificate loaded from the given file.
+func observeCertificateExpiry(file string, cert *tls.Certificate) {
+	if len(cert.Certificate) == 0 {
+		return
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	clientCertificateExpiryMetric.set(file, leaf.NotAfter)
+}
+
+// certificateExpiryCollector exposes the days left until the client
+// certificates expire, computed when the metrics are collected.
+type certificateExpiryCollector struct {
+	desc *prometheus.Desc
+	now  func() time.Time
+
+	mtx      sync.Mutex
+	notAfter map[string]time.Time
+}
+
+func newCertificateExpiryCollector() *certificateExpiryCollector {
+	return &certificateExpiryCollector{
+		desc: prometheus.NewDesc(
+			"nr_stats_client_certificate_expiry_days",
+			"Days left until the client certificate expires",
+			[]string{"file"},
+			nil,
+		),
+		now:      time.Now,
+		notAfter: make(map[string]time.Time),
+	}
+}
+
+func (c *certificateExpiryCollector) set(file string, notAfter time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.notAfter[file] = notAfter
+}
+
+// Describe implements prometheus.Collector.
+func (c *certificateExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *certificateExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := c.now()
+	for file, notAfter := range c.notAfter {
+		days := notAfter.Sub(now).Hours() / 24
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, days, file)
+	}
+}
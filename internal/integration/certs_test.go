@@ -0,0 +1,146 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateFilesReloadClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert := func(serial int64) {
+		_, certPEM, keyPEM := ca.issue(t, serial, "spiffe://example.org/client")
+		require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	}
+
+	writeCert(1)
+	files, err := newCertificateFiles("", certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := files.clientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), leaf.SerialNumber.Int64())
+
+	// When the certificate is rotated
+	writeCert(2)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	cert, err = files.clientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), leaf.SerialNumber.Int64())
+}
+
+func TestNewTLSConfigReloadsCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+
+	// Given a CA file that doesn't trust the server
+	require.NoError(t, ioutil.WriteFile(caFile, newTestCA(t).pem, 0600))
+	tlsConfig, err := NewTLSConfig(caFile, false)
+	require.NoError(t, err)
+	client := &http.Client{Transport: HTTPClientConfig{DisableKeepAlives: true}.transport(tlsConfig)}
+
+	_, err = client.Get(srv.URL)
+	require.Error(t, err)
+
+	// When the CA file is rotated to one that trusts it
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caFile, serverCA, 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(caFile, later, later))
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+}
+
+func TestNewTLSConfigVerifiesIPAddresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Given a server on an IP address with a certificate for another name
+	ca := newTestCA(t)
+	_, certPEM, keyPEM := ca.issue(t, 1, "spiffe://example.org/exporter")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, ca.pem, 0600))
+	tlsConfig, err := NewTLSConfig(caFile, false)
+	require.NoError(t, err)
+	client := &http.Client{Transport: HTTPClientConfig{DisableKeepAlives: true}.transport(tlsConfig)}
+
+	// The certificate isn't accepted even if it's signed by the CA
+	_, err = client.Get(srv.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "127.0.0.1")
+
+	// Given a server whose certificate is valid for 127.0.0.1 and example.com
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer other.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caFile, serverCA, 0600))
+
+	for serverName, valid := range map[string]bool{"": true, "example.com": true, "example.org": false} {
+		tlsConfig, err := NewTLSConfig(caFile, false)
+		require.NoError(t, err)
+		tlsConfig.ServerName = serverName
+		client := &http.Client{Transport: HTTPClientConfig{DisableKeepAlives: true}.transport(tlsConfig)}
+
+		resp, err := client.Get(other.URL)
+		if !valid {
+			assert.Error(t, err, "server name %q", serverName)
+			continue
+		}
+		require.NoError(t, err, "server name %q", serverName)
+		_ = resp.Body.Close()
+	}
+}
+
+func TestCertificateExpiryCollector(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newCertificateExpiryCollector()
+	c.now = func() time.Time { return now }
+	c.set("/etc/certs/client.pem", now.Add(36*time.Hour))
+
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	var m dto.Metric
+	require.NoError(t, (<-ch).Write(&m))
+	assert.Equal(t, 1.5, m.GetGauge().GetValue())
+	assert.Equal(t, "/etc/certs/client.pem", m.GetLabel()[0].GetValue())
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+)
+
+// commonAttributesRoundTripper factors the attributes shared by the metrics
+// of each target in the Metric API payloads into the common block of a batch
+// of their own, instead of repeating them in every datapoint.
+type commonAttributesRoundTripper struct {
+	rt http.RoundTripper
+}
+
+// TelemetryHarvesterWithCommonAttributes wraps the emitter client Transport
+// to move the attributes with the same value in all the metrics of a target,
+// like the cluster name, the integration version or the target metadata, to
+// the common block of the payloads.
+//
+// It must be set after the compression one, so the factored payloads are
+// compressed with the configured level.
+func TelemetryHarvesterWithCommonAttributes() TelemetryHarvesterOpt {
+	return func(cfg *telemetry.Config) {
+		rt := cfg.Client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		cfg.Client.Transport = commonAttributesRoundTripper{rt: rt}
+	}
+}
+
+// RoundTrip replaces the body of the gzip encoded Metric API requests with
+// the factored payload. The other requests, like the ones of the Event API,
+// are sent as they are.
+func (t commonAttributesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "gzip" {
+		return t.rt.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if factored, ok := factorCommonAttributes(body); ok {
+		body = factored
+	}
+
+	// RoundTrippers must not modify the original request.
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// commonAttributesBatch is a batch of metrics of the Metric API, with the
+// values kept as they were encoded.
+type commonAttributesBatch struct {
+	Common  map[string]json.RawMessage   `json:"common,omitempty"`
+	Metrics []map[string]json.RawMessage `json:"metrics"`
+}
+
+// factorCommonAttributes splits the batches of the gzipped Metric API
+// payload by target, moving the attributes with the same value in all the
+// metrics of the target to the common block of its batch. It returns false
+// if the payload isn't a Metric API one or can't be factored.
+func factorCommonAttributes(payload []byte) ([]byte, bool) {
+	gzr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, false
+	}
+	defer gzr.Close()
+	var batches []commonAttributesBatch
+	if err := json.NewDecoder(gzr).Decode(&batches); err != nil {
+		return nil, false
+	}
+
+	var factored []commonAttributesBatch
+	for _, b := range batches {
+		if b.Metrics == nil {
+			// An event.
+			return nil, false
+		}
+		common := map[string]json.RawMessage{}
+		if raw, ok := b.Common["attributes"]; ok {
+			if err := json.Unmarshal(raw, &common); err != nil {
+				return nil, false
+			}
+		}
+
+		groups, err := groupByTarget(b.Metrics)
+		if err != nil {
+			return nil, false
+		}
+		for _, g := range groups {
+			batch, err := factorBatch(b.Common, common, g)
+			if err != nil {
+				return nil, false
+			}
+			factored = append(factored, batch)
+		}
+	}
+
+	// The level of the telemetry SDK. The payloads are compressed again with
+	// the configured one, if it's another, by the compression RoundTripper.
+	var buf bytes.Buffer
+	gzw, err := gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+	if err != nil {
+		return nil, false
+	}
+	if err := json.NewEncoder(gzw).Encode(factored); err != nil {
+		return nil, false
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// commonAttributesMetric is a metric with its attributes decoded.
+type commonAttributesMetric struct {
+	fields     map[string]json.RawMessage
+	attributes map[string]json.RawMessage
+}
+
+// groupByTarget groups the metrics by their targetName attribute, in the
+// order the targets first appear.
+func groupByTarget(metrics []map[string]json.RawMessage) ([][]commonAttributesMetric, error) {
+	var groups [][]commonAttributesMetric
+	index := map[string]int{}
+	for _, fields := range metrics {
+		m := commonAttributesMetric{fields: fields, attributes: map[string]json.RawMessage{}}
+		if raw, ok := fields["attributes"]; ok {
+			if err := json.Unmarshal(raw, &m.attributes); err != nil {
+				return nil, err
+			}
+		}
+		target := string(m.attributes["targetName"])
+		i, ok := index[target]
+		if !ok {
+			i = len(groups)
+			index[target] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], m)
+	}
+	return groups, nil
+}
+
+// factorBatch returns a batch with the metrics, whose common block has the
+// attributes of the original one and the ones with the same value in all the
+// metrics. The attributes of the original common block that the metrics
+// override aren't factored.
+func factorBatch(commonBlock, common map[string]json.RawMessage, metrics []commonAttributesMetric) (commonAttributesBatch, error) {
+	shared := map[string]json.RawMessage{}
+	if len(metrics) > 1 {
+		for k, v := range metrics[0].attributes {
+			if c, ok := common[k]; ok && !bytes.Equal(c, v) {
+				continue
+			}
+			shared[k] = v
+		}
+		for _, m := range metrics[1:] {
+			for k, v := range shared {
+				if mv, ok := m.attributes[k]; !ok || !bytes.Equal(mv, v) {
+					delete(shared, k)
+				}
+			}
+		}
+	}
+
+	batch := commonAttributesBatch{Metrics: make([]map[string]json.RawMessage, 0, len(metrics))}
+	for _, m := range metrics {
+		if len(shared) == 0 {
+			batch.Metrics = append(batch.Metrics, m.fields)
+			continue
+		}
+		attributes := make(map[string]json.RawMessage, len(m.attributes))
+		for k, v := range m.attributes {
+			if _, ok := shared[k]; !ok {
+				attributes[k] = v
+			}
+		}
+		fields := make(map[string]json.RawMessage, len(m.fields))
+		for k, v := range m.fields {
+			fields[k] = v
+		}
+		delete(fields, "attributes")
+		if len(attributes) > 0 {
+			raw, err := json.Marshal(attributes)
+			if err != nil {
+				return commonAttributesBatch{}, err
+			}
+			fields["attributes"] = raw
+		}
+		batch.Metrics = append(batch.Metrics, fields)
+	}
+
+	if len(shared) == 0 {
+		batch.Common = commonBlock
+		return batch, nil
+	}
+	attributes := make(map[string]json.RawMessage, len(common)+len(shared))
+	for k, v := range common {
+		attributes[k] = v
+	}
+	for k, v := range shared {
+		attributes[k] = v
+	}
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return commonAttributesBatch{}, err
+	}
+	batch.Common = make(map[string]json.RawMessage, len(commonBlock)+1)
+	for k, v := range commonBlock {
+		batch.Common[k] = v
+	}
+	batch.Common["attributes"] = raw
+	return batch, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommonAttributesRoundTripper(t *testing.T) {
+	payload := `[{"common":{"attributes":{"clusterName":"prod","env":"a"}},"metrics":[
+		{"name":"up","type":"gauge","value":1,"attributes":{"targetName":"a:9100","integrationVersion":"2.0","env":"b","job":"node"}},
+		{"name":"load","type":"gauge","value":0.5,"attributes":{"targetName":"a:9100","integrationVersion":"2.0","env":"b","cpu":"0"}},
+		{"name":"up","type":"gauge","value":1,"attributes":{"targetName":"b:8080","integrationVersion":"2.0"}},
+		{"name":"up","type":"gauge","value":0,"attributes":{"targetName":"c:8080","integrationVersion":"2.0"}},
+		{"name":"errors","type":"count","value":3,"attributes":{"targetName":"c:8080","integrationVersion":"2.0"}}
+	]}]`
+
+	var sent []byte
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		gzr, err := gzip.NewReader(req.Body)
+		require.NoError(t, err)
+		sent, err = ioutil.ReadAll(gzr)
+		require.NoError(t, err)
+		return emptyResponse(202), nil
+	})
+	cfg := &telemetry.Config{Client: &http.Client{Transport: rt}}
+	TelemetryHarvesterWithCommonAttributes()(cfg)
+
+	var body bytes.Buffer
+	gzw := gzip.NewWriter(&body)
+	_, err := gzw.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	req, err := http.NewRequest("POST", "https://metric-api.newrelic.com/metric/v1", &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "gzip")
+	_, err = cfg.Client.Transport.RoundTrip(req)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `[
+		{"common":{"attributes":{"clusterName":"prod","env":"a","targetName":"a:9100","integrationVersion":"2.0"}},"metrics":[
+			{"name":"up","type":"gauge","value":1,"attributes":{"env":"b","job":"node"}},
+			{"name":"load","type":"gauge","value":0.5,"attributes":{"env":"b","cpu":"0"}}
+		]},
+		{"common":{"attributes":{"clusterName":"prod","env":"a"}},"metrics":[
+			{"name":"up","type":"gauge","value":1,"attributes":{"targetName":"b:8080","integrationVersion":"2.0"}}
+		]},
+		{"common":{"attributes":{"clusterName":"prod","env":"a","targetName":"c:8080","integrationVersion":"2.0"}},"metrics":[
+			{"name":"up","type":"gauge","value":0},
+			{"name":"errors","type":"count","value":3}
+		]}
+	]`, string(sent))
+}
+
+func TestFactorCommonAttributesSkipsEvents(t *testing.T) {
+	var body bytes.Buffer
+	gzw := gzip.NewWriter(&body)
+	require.NoError(t, json.NewEncoder(gzw).Encode([]map[string]interface{}{
+		{"eventType": "PrometheusThresholdEvent", "targetName": "a:9100"},
+	}))
+	require.NoError(t, gzw.Close())
+
+	_, ok := factorCommonAttributes(body.Bytes())
+	assert.False(t, ok)
+}
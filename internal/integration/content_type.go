@@ -0,0 +1,85 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// contentTypeSampleSize is the number of bytes of the rejected responses that
+// are logged.
+const contentTypeSampleSize = 256
+
+// expositionContentTypes are the media types of the exposition formats.
+var expositionContentTypes = map[string]bool{
+	"text/plain":                   true,
+	"application/openmetrics-text": true,
+}
+
+// FetcherWithContentTypeCheck makes the Fetcher reject the scrape responses
+// whose Content-Type is not an exposition format, like the HTML error pages
+// of the proxies, before parsing them. The responses without Content-Type
+// are accepted.
+func FetcherWithContentTypeCheck() FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.checkContentType = true
+	}
+}
+
+// contentTypeDoer fails the requests whose response Content-Type is not an
+// exposition format.
+type contentTypeDoer struct {
+	inner  prometheus.HTTPDoer
+	target string
+	log    *logrus.Entry
+}
+
+// Do does the request and checks the Content-Type of the successful
+// responses, logging the first bytes of the rejected ones.
+func (d contentTypeDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.inner.Do(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 300 {
+		return resp, err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if isExpositionContentType(contentType) {
+		return resp, nil
+	}
+
+	sample, _ := ioutil.ReadAll(io.LimitReader(resp.Body, contentTypeSampleSize))
+	_ = resp.Body.Close()
+	rejectedContentTypeMetric.WithLabelValues(d.target).Inc()
+	d.log.WithFields(logrus.Fields{
+		"target":      d.target,
+		"contentType": contentType,
+		"body":        string(sample),
+	}).Warn("rejecting the scrape response, its content type is not an exposition format")
+	return nil, fmt.Errorf("unexpected content type %q of the scrape response", contentType)
+}
+
+// isExpositionContentType returns true if the Content-Type is empty or the
+// one of an exposition format.
+func isExpositionContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && expositionContentTypes[mediaType]
+}
+
+// withContentTypeCheck returns the client checking the Content-Type of the
+// responses of the target, if the fetcher checks them.
+func (pf *prometheusFetcher) withContentTypeCheck(target string, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if !pf.checkContentType {
+		return c
+	}
+	return contentTypeDoer{inner: c, target: target, log: pf.log}
+}
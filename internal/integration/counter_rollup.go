@@ -0,0 +1,132 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+)
+
+const defaultCounterRollupInterval = time.Minute
+
+// CounterRollupConfig configures the counters that are rolled up by the
+// telemetry emitter into a summary per interval, with the count, sum, min and
+// max of their increases in the scrapes of the interval, instead of a
+// datapoint per scrape.
+type CounterRollupConfig struct {
+	// MetricPrefixes are the prefixes of the names of the rolled up counters.
+	MetricPrefixes []string `mapstructure:"metric_prefixes"`
+	// Interval of the summaries. Defaults to 1m.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Enabled returns true if any counter is rolled up.
+func (c CounterRollupConfig) Enabled() bool {
+	return len(c.MetricPrefixes) > 0
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *CounterRollupConfig) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("interval can't be negative")
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultCounterRollupInterval
+	}
+	return nil
+}
+
+// counterRollup accumulates the increases of the counters in the current
+// interval.
+type counterRollup struct {
+	cfg CounterRollupConfig
+
+	mtx   sync.Mutex
+	start time.Time
+	rolls map[string]*telemetry.Summary
+}
+
+func newCounterRollup(cfg CounterRollupConfig, now time.Time) *counterRollup {
+	return &counterRollup{
+		cfg:   cfg,
+		start: now,
+		rolls: make(map[string]*telemetry.Summary),
+	}
+}
+
+// matches returns true if the counter with the given name is rolled up.
+func (r *counterRollup) matches(name string) bool {
+	for _, prefix := range r.cfg.MetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// add accounts the increase of a counter in the current interval.
+func (r *counterRollup) add(c telemetry.Count) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	key := c.Name + "\x00" + string(c.AttributesJSON)
+	s, ok := r.rolls[key]
+	if !ok {
+		s = &telemetry.Summary{
+			Name:           c.Name,
+			AttributesJSON: c.AttributesJSON,
+			Min:            math.Inf(1),
+			Max:            math.Inf(-1),
+		}
+		r.rolls[key] = s
+	}
+	s.Count++
+	s.Sum += c.Value
+	s.Min = math.Min(s.Min, c.Value)
+	s.Max = math.Max(s.Max, c.Value)
+}
+
+// due returns the summaries of the current interval if it has ended, starting
+// a new one.
+func (r *counterRollup) due(now time.Time) []telemetry.Summary {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if now.Sub(r.start) < r.cfg.Interval {
+		return nil
+	}
+	return r.rollUp(now)
+}
+
+// flush returns the summaries of the current interval, even if it hasn't
+// ended, starting a new one.
+func (r *counterRollup) flush(now time.Time) []telemetry.Summary {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.rollUp(now)
+}
+
+func (r *counterRollup) rollUp(now time.Time) []telemetry.Summary {
+	// The summaries are sorted by series, so the batches are the same for
+	// the same counters.
+	keys := make([]string, 0, len(r.rolls))
+	for key := range r.rolls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	summaries := make([]telemetry.Summary, 0, len(r.rolls))
+	for _, key := range keys {
+		s := r.rolls[key]
+		s.Timestamp = r.start
+		s.Interval = now.Sub(r.start)
+		summaries = append(summaries, *s)
+	}
+	r.start = now
+	r.rolls = make(map[string]*telemetry.Summary)
+	return summaries
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+type recordingHarvester struct {
+	metrics []telemetry.Metric
+}
+
+func (h *recordingHarvester) RecordMetric(m telemetry.Metric) {
+	h.metrics = append(h.metrics, m)
+}
+
+func (h *recordingHarvester) HarvestNow(context.Context) {}
+
+func TestCounterRollupConfigValidate(t *testing.T) {
+	cfg := CounterRollupConfig{MetricPrefixes: []string{"http_"}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, defaultCounterRollupInterval, cfg.Interval)
+	assert.True(t, cfg.Enabled())
+	assert.False(t, CounterRollupConfig{}.Enabled())
+	assert.Error(t, (&CounterRollupConfig{Interval: -time.Second}).Validate())
+}
+
+func TestCounterRollup(t *testing.T) {
+	start := time.Now()
+	r := newCounterRollup(CounterRollupConfig{MetricPrefixes: []string{"http_"}, Interval: time.Minute}, start)
+	assert.True(t, r.matches("http_requests_total"))
+	assert.False(t, r.matches("process_cpu_seconds_total"))
+
+	for _, v := range []float64{3, 1, 5} {
+		r.add(telemetry.Count{Name: "http_requests_total", AttributesJSON: []byte(`{"code":"200"}`), Value: v})
+	}
+	r.add(telemetry.Count{Name: "http_requests_total", AttributesJSON: []byte(`{"code":"500"}`), Value: 2})
+	assert.Empty(t, r.due(start.Add(30*time.Second)))
+
+	summaries := r.due(start.Add(time.Minute))
+	require.Len(t, summaries, 2)
+	byCode := map[string]telemetry.Summary{}
+	for _, s := range summaries {
+		byCode[string(s.AttributesJSON)] = s
+	}
+	assert.Equal(t, telemetry.Summary{
+		Name:           "http_requests_total",
+		AttributesJSON: []byte(`{"code":"200"}`),
+		Count:          3,
+		Sum:            9,
+		Min:            1,
+		Max:            5,
+		Timestamp:      start,
+		Interval:       time.Minute,
+	}, byCode[`{"code":"200"}`])
+	assert.Equal(t, 2.0, byCode[`{"code":"500"}`].Sum)
+
+	assert.Empty(t, r.flush(start.Add(90*time.Second)), "a new interval is started")
+}
+
+func TestTelemetryEmitter_CounterRollup(t *testing.T) {
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{
+		harvester:       h,
+		deltaCalculator: newDeltaCalculator(),
+		rollup:          newCounterRollup(CounterRollupConfig{MetricPrefixes: []string{"http_"}, Interval: time.Hour}, time.Now()),
+	}
+	counter := func(name string, value float64) Metric {
+		return Metric{name: name, metricType: metricType_COUNTER, value: value, attributes: labels.Set{"targetName": "a"}}
+	}
+
+	for i, v := range []float64{1, 4, 6} {
+		require.NoError(t, te.Emit([]Metric{counter("http_requests_total", v), counter("other_total", v)}))
+		// The delta calculator requires increasing timestamps.
+		if i < 2 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	require.Len(t, h.metrics, 2, "only the counters not rolled up are recorded on each emission")
+	for _, m := range h.metrics {
+		assert.Equal(t, "other_total", m.(telemetry.Count).Name)
+	}
+
+	require.NoError(t, te.Flush(context.Background()))
+	require.Len(t, h.metrics, 3)
+	summary, ok := h.metrics[2].(telemetry.Summary)
+	require.True(t, ok)
+	assert.Equal(t, "http_requests_total", summary.Name)
+	assert.Equal(t, 2.0, summary.Count)
+	assert.Equal(t, 5.0, summary.Sum)
+	assert.Equal(t, 2.0, summary.Min)
+	assert.Equal(t, 3.0, summary.Max)
+}
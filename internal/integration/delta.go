@@ -0,0 +1,259 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+const defaultCounterCheckpointInterval = time.Minute
+
+// CounterCheckpointConfig configures the checkpoints of the last values of
+// the counters, from which the telemetry emitter calculates the deltas. They
+// are written to a file periodically and when the integration stops, and
+// restored when it starts, so a restart doesn't lose the first delta of
+// every counter.
+type CounterCheckpointConfig struct {
+	// Path is the file the checkpoints are written to. Empty disables them.
+	Path string `mapstructure:"path"`
+	// Interval is how often the checkpoints are written. Defaults to 1m.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Enabled returns true if the counters are checkpointed.
+func (c CounterCheckpointConfig) Enabled() bool {
+	return c.Path != ""
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *CounterCheckpointConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval can't be negative")
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultCounterCheckpointInterval
+	}
+	return nil
+}
+
+// deltaValue is the last value of a series, with its name and attributes
+// encoded once, when the series is first seen.
+type deltaValue struct {
+	name       string
+	attributes []byte
+	when       time.Time
+	value      float64
+}
+
+// deltaCalculator creates Count metrics from cumulative values, as the
+// DeltaCalculator of the telemetry SDK, keeping the last values in a way they
+// can be checkpointed. The series are identified by their fingerprints.
+type deltaCalculator struct {
+	lock                    sync.Mutex
+	datapoints              map[uint64]deltaValue
+	lastClean               time.Time
+	expirationCheckInterval time.Duration
+	expirationAge           time.Duration
+}
+
+func newDeltaCalculator() *deltaCalculator {
+	return &deltaCalculator{
+		datapoints:              make(map[uint64]deltaValue),
+		expirationCheckInterval: defaultDeltaExpirationCheckInterval,
+		expirationAge:           defaultDeltaExpirationAge,
+	}
+}
+
+// CountMetric returns the count metric with the difference between the value
+// and the previous one of the series, and false if it's the first value of
+// the series, the counter was reset or the timestamps are not ordered.
+func (dc *deltaCalculator) CountMetric(name string, attributes map[string]interface{}, val float64, now time.Time) (count telemetry.Count, valid bool) {
+	return dc.countSeries(labels.Fingerprint(name, attributes), name, attributes, val, now)
+}
+
+// countSeries is CountMetric for the series with the given fingerprint, so
+// the attributes are only encoded when the series is first seen.
+func (dc *deltaCalculator) countSeries(fingerprint uint64, name string, attributes map[string]interface{}, val float64, now time.Time) (count telemetry.Count, valid bool) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	if now.Sub(dc.lastClean) > dc.expirationCheckInterval {
+		cutoff := now.Add(-dc.expirationAge)
+		for k, v := range dc.datapoints {
+			if v.when.Before(cutoff) {
+				delete(dc.datapoints, k)
+			}
+		}
+		dc.lastClean = now
+	}
+
+	var timestampsOrdered bool
+	last, ok := dc.datapoints[fingerprint]
+	if !ok {
+		last.name = name
+		if attributes != nil {
+			last.attributes = marshalOrderedAttributes(attributes)
+		}
+	}
+	if ok {
+		delta := val - last.value
+		timestampsOrdered = now.After(last.when)
+		if timestampsOrdered && delta >= 0 {
+			count.Name = name
+			count.AttributesJSON = last.attributes
+			count.Value = delta
+			count.Timestamp = last.when
+			count.Interval = now.Sub(last.when)
+			valid = true
+		}
+	}
+	if !ok || timestampsOrdered {
+		last.value, last.when = val, now
+		dc.datapoints[fingerprint] = last
+	}
+	return
+}
+
+// marshalOrderedAttributes returns the attributes encoded as a JSON object
+// with the keys sorted. The values that can't be encoded are encoded as
+// strings.
+func marshalOrderedAttributes(attributes map[string]interface{}) []byte {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(attributes[k])
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(attributes[k]))
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// counterCheckpoint is the content of the checkpoint file.
+type counterCheckpoint struct {
+	Series []checkpointedSeries `json:"series"`
+}
+
+type checkpointedSeries struct {
+	Name       string          `json:"name"`
+	Attributes json.RawMessage `json:"attributes,omitempty"`
+	Value      float64         `json:"value"`
+	Time       time.Time       `json:"time"`
+}
+
+// save writes the last values of the series to the file, replacing it once
+// they are written.
+func (dc *deltaCalculator) save(path string) (int, error) {
+	dc.lock.Lock()
+	checkpoint := counterCheckpoint{Series: make([]checkpointedSeries, 0, len(dc.datapoints))}
+	for _, v := range dc.datapoints {
+		// JSON can't encode them, and they never produce a delta anyway.
+		if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+			continue
+		}
+		checkpoint.Series = append(checkpoint.Series, checkpointedSeries{
+			Name:       v.name,
+			Attributes: json.RawMessage(v.attributes),
+			Value:      v.value,
+			Time:       v.when,
+		})
+	}
+	dc.lock.Unlock()
+
+	content, err := json.Marshal(checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return len(checkpoint.Series), nil
+}
+
+// load restores the last values of the series in the file, except the
+// expired ones. A missing file isn't an error.
+func (dc *deltaCalculator) load(path string, now time.Time) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var checkpoint counterCheckpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return 0, fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	cutoff := now.Add(-dc.expirationAge)
+	restored := 0
+	for _, s := range checkpoint.Series {
+		if s.Time.Before(cutoff) {
+			continue
+		}
+		var attributes labels.Set
+		if len(s.Attributes) > 0 {
+			if err := json.Unmarshal(s.Attributes, &attributes); err != nil {
+				return restored, fmt.Errorf("decoding the attributes of %s: %w", s.Name, err)
+			}
+		}
+		fingerprint := labels.Fingerprint(s.Name, attributes)
+		if _, ok := dc.datapoints[fingerprint]; ok {
+			continue
+		}
+		dc.datapoints[fingerprint] = deltaValue{
+			name:       s.Name,
+			attributes: s.Attributes,
+			value:      s.Value,
+			when:       s.Time,
+		}
+		restored++
+	}
+	return restored, nil
+}
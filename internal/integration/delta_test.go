@@ -0,0 +1,144 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterCheckpointConfigValidate(t *testing.T) {
+	cfg := CounterCheckpointConfig{}
+	require.NoError(t, cfg.Validate())
+	assert.False(t, cfg.Enabled())
+
+	cfg = CounterCheckpointConfig{Path: "counters.json"}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, time.Minute, cfg.Interval)
+
+	assert.Error(t, (&CounterCheckpointConfig{Path: "counters.json", Interval: -time.Second}).Validate())
+}
+
+func TestDeltaCalculator(t *testing.T) {
+	dc := newDeltaCalculator()
+	now := time.Now()
+	attrs := map[string]interface{}{"method": "GET", "code": "200"}
+
+	_, ok := dc.CountMetric("http_requests_total", attrs, 10, now)
+	assert.False(t, ok, "the first value has no delta")
+
+	count, ok := dc.CountMetric("http_requests_total", attrs, 15, now.Add(time.Second))
+	require.True(t, ok)
+	assert.Equal(t, 5.0, count.Value)
+	assert.Equal(t, now, count.Timestamp)
+	assert.Equal(t, time.Second, count.Interval)
+	assert.JSONEq(t, `{"code":"200","method":"GET"}`, string(count.AttributesJSON))
+
+	_, ok = dc.CountMetric("http_requests_total", attrs, 3, now.Add(2*time.Second))
+	assert.False(t, ok, "the counter was reset")
+	count, ok = dc.CountMetric("http_requests_total", attrs, 4, now.Add(3*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, 1.0, count.Value)
+
+	_, ok = dc.CountMetric("http_requests_total", attrs, 10, now)
+	assert.False(t, ok, "the timestamps are not ordered")
+}
+
+func TestDeltaCalculator_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counters.json")
+
+	now := time.Now()
+	dc := newDeltaCalculator()
+	restored, err := dc.load(path, now)
+	require.NoError(t, err, "a missing checkpoint isn't an error")
+	assert.Equal(t, 0, restored)
+
+	dc.CountMetric("http_requests_total", map[string]interface{}{"code": "200"}, 10, now.Add(-time.Minute))
+	dc.CountMetric("process_cpu_seconds_total", nil, 2.5, now.Add(-time.Minute))
+	dc.CountMetric("stale_total", nil, 1, now.Add(-time.Hour))
+	saved, err := dc.save(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, saved)
+
+	dc = newDeltaCalculator()
+	restored, err = dc.load(path, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored, "the expired series are not restored")
+
+	count, ok := dc.CountMetric("http_requests_total", map[string]interface{}{"code": "200"}, 12, now)
+	require.True(t, ok)
+	assert.Equal(t, 2.0, count.Value)
+	assert.Equal(t, time.Minute, count.Interval)
+	count, ok = dc.CountMetric("process_cpu_seconds_total", nil, 3, now)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, count.Value)
+	_, ok = dc.CountMetric("stale_total", nil, 5, now)
+	assert.False(t, ok)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0600))
+	_, err = newDeltaCalculator().load(path, now)
+	assert.Error(t, err)
+}
+
+func TestDeltaCalculator_CheckpointNumericAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counters.json")
+
+	now := time.Now()
+	attrs := map[string]interface{}{"port": 9100, "shard": uint64(1e19), "ratio": float32(0.1), "enabled": true}
+	dc := newDeltaCalculator()
+	dc.CountMetric("http_requests_total", attrs, 10, now.Add(-time.Minute))
+	_, err = dc.save(path)
+	require.NoError(t, err)
+
+	dc = newDeltaCalculator()
+	restored, err := dc.load(path, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored)
+
+	count, ok := dc.CountMetric("http_requests_total", attrs, 12, now)
+	require.True(t, ok, "the restored series is the same")
+	assert.Equal(t, 2.0, count.Value)
+	assert.Len(t, dc.datapoints, 1)
+}
+
+func TestTelemetryEmitter_CounterCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := CounterCheckpointConfig{Path: filepath.Join(dir, "counters.json"), Interval: time.Hour}
+	bounds := TimestampBoundsConfig{MaxAge: time.Hour, MaxFuture: time.Minute, Action: TimestampBoundsDrop}
+
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{harvester: h, deltaCalculator: newDeltaCalculator(), checkpoint: cfg, lastCheckpoint: time.Now(), timestampBounds: bounds}
+	counter := func(v float64, ts time.Time) []Metric {
+		return []Metric{{name: "http_requests_total", metricType: metricType_COUNTER, value: v, timestamp: ts}}
+	}
+	now := time.Now()
+	require.NoError(t, te.Emit(counter(10, now.Add(-time.Minute))))
+	_, err = os.Stat(cfg.Path)
+	assert.True(t, os.IsNotExist(err), "the checkpoint isn't due yet")
+	require.NoError(t, te.Flush(context.Background()))
+
+	// The emitter of the restarted integration.
+	h = &recordingHarvester{}
+	te = &TelemetryEmitter{harvester: h, deltaCalculator: newDeltaCalculator(), checkpoint: cfg, timestampBounds: bounds}
+	_, err = te.deltaCalculator.load(cfg.Path, now)
+	require.NoError(t, err)
+	require.NoError(t, te.Emit(counter(15, now)))
+	require.Len(t, h.metrics, 1)
+	assert.Equal(t, 5.0, h.metrics[0].(telemetry.Count).Value)
+}
@@ -0,0 +1,320 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// DerivedMetricRule computes a new gauge on every scrape from an expression
+// over other counters and gauges of the same target, e.g.
+// errors_total / requests_total. Each metric in the expression is summed by
+// the By attributes, and the expression is evaluated for each combination of
+// their values present in all the metrics. Counters are used with their
+// cumulative value.
+type DerivedMetricRule struct {
+	Name       string   `mapstructure:"name"`
+	Expression string   `mapstructure:"expression"`
+	By         []string `mapstructure:"by"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if the rule is not complete or its expression
+// can't be parsed.
+func (r DerivedMetricRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	_, err := parseExpression(r.Expression)
+	return err
+}
+
+// derivedMetric is a DerivedMetricRule with its expression parsed.
+type derivedMetric struct {
+	DerivedMetricRule
+	expr    expression
+	metrics []string
+}
+
+// compileDerivedMetrics parses the expressions of the rules, skipping the
+// invalid ones.
+func compileDerivedMetrics(rules []DerivedMetricRule) []derivedMetric {
+	var compiled []derivedMetric
+	for _, rule := range rules {
+		expr, err := parseExpression(rule.Expression)
+		if err != nil {
+			ilog.WithError(err).Errorf("invalid expression of derived metric %s", rule.Name)
+			continue
+		}
+		refs := map[string]bool{}
+		expr.metrics(refs)
+		metrics := make([]string, 0, len(refs))
+		for m := range refs {
+			metrics = append(metrics, m)
+		}
+		compiled = append(compiled, derivedMetric{DerivedMetricRule: rule, expr: expr, metrics: metrics})
+	}
+	return compiled
+}
+
+// deriveMetrics adds to the target metrics the ones computed by the rules.
+func deriveMetrics(targetMetrics *TargetMetrics, rules []derivedMetric) {
+	for _, rule := range rules {
+		targetMetrics.Metrics = append(targetMetrics.Metrics, rule.derive(targetMetrics.Metrics)...)
+	}
+}
+
+// derive evaluates the rule over the metrics.
+func (d derivedMetric) derive(metrics []Metric) []Metric {
+	// Sum of the values of each metric in the expression, by group.
+	sums := make(map[string]map[string]float64, len(d.metrics))
+	groups := make(map[string]labels.Set)
+	var targetName interface{}
+	for _, m := range metrics {
+		if !d.references(m.name) {
+			continue
+		}
+		value, ok := m.value.(float64)
+		if !ok {
+			continue
+		}
+		targetName = m.attributes["targetName"]
+		key, group := d.group(m)
+		if sums[m.name] == nil {
+			sums[m.name] = make(map[string]float64)
+		}
+		sums[m.name][key] += value
+		groups[key] = group
+	}
+	if len(sums) < len(d.metrics) {
+		return nil
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var derived []Metric
+	for _, key := range keys {
+		values := make(map[string]float64, len(d.metrics))
+		for _, name := range d.metrics {
+			v, ok := sums[name][key]
+			if !ok {
+				break
+			}
+			values[name] = v
+		}
+		if len(values) < len(d.metrics) {
+			continue
+		}
+		value := d.expr.eval(values)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		attrs := labels.Set{
+			"nrMetricType":   string(metricType_GAUGE),
+			"promMetricType": "gauge",
+		}
+		if targetName != nil {
+			attrs["targetName"] = targetName
+		}
+		labels.Accumulate(attrs, groups[key])
+		derived = append(derived, Metric{
+			name:       d.Name,
+			value:      value,
+			metricType: metricType_GAUGE,
+			attributes: attrs,
+		})
+	}
+	return derived
+}
+
+func (d derivedMetric) references(name string) bool {
+	for _, m := range d.metrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// group returns the key and attributes of the group of the metric.
+func (d derivedMetric) group(m Metric) (string, labels.Set) {
+	group := labels.Set{}
+	parts := make([]string, 0, len(d.By))
+	for _, attr := range d.By {
+		v := m.attributes[attr]
+		if v != nil {
+			group[attr] = v
+		}
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, "\x00"), group
+}
+
+// expression is a node of a parsed arithmetic expression.
+type expression interface {
+	eval(values map[string]float64) float64
+	metrics(refs map[string]bool)
+}
+
+type metricRef string
+
+func (r metricRef) eval(values map[string]float64) float64 { return values[string(r)] }
+func (r metricRef) metrics(refs map[string]bool)           { refs[string(r)] = true }
+
+type constant float64
+
+func (c constant) eval(map[string]float64) float64 { return float64(c) }
+func (c constant) metrics(map[string]bool)         {}
+
+type binaryOp struct {
+	op          byte
+	left, right expression
+}
+
+func (b binaryOp) eval(values map[string]float64) float64 {
+	l, r := b.left.eval(values), b.right.eval(values)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		if r == 0 {
+			return math.NaN()
+		}
+		return l / r
+	}
+}
+
+func (b binaryOp) metrics(refs map[string]bool) {
+	b.left.metrics(refs)
+	b.right.metrics(refs)
+}
+
+// parseExpression parses expressions with metric names, numbers, the +, -, *
+// and / operators and parentheses.
+func parseExpression(s string) (expression, error) {
+	p := &exprParser{input: s}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d of %q", p.input[p.pos], p.pos, s)
+	}
+	refs := map[string]bool{}
+	expr.metrics(refs)
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("expression %q has no metrics", s)
+	}
+	return expr, nil
+}
+
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '+' && p.input[p.pos] != '-') {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseProduct() (expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '*' && p.input[p.pos] != '/') {
+			return left, nil
+		}
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseOperand() (expression, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("unexpected end of %q", p.input)
+	}
+	c := rune(p.input[p.pos])
+	switch {
+	case c == '(':
+		p.pos++
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("missing ) in %q", p.input)
+		}
+		p.pos++
+		return expr, nil
+	case unicode.IsDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in %q: %w", p.input, err)
+		}
+		return constant(v), nil
+	case c == '_' || c == ':' || unicode.IsLetter(c):
+		start := p.pos
+		for p.pos < len(p.input) {
+			c := rune(p.input[p.pos])
+			if c != '_' && c != ':' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		return metricRef(p.input[start:p.pos]), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d of %q", c, p.pos, p.input)
+}
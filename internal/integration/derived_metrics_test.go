@@ -0,0 +1,63 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{"a": 6, "b": 3, "c_total": 2}
+	for expr, expected := range map[string]float64{
+		"a / b":             2,
+		"a - b * c_total":   0,
+		"(a - b) * c_total": 6,
+		"100 * b / a":       50,
+	} {
+		e, err := parseExpression(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, expected, e.eval(values), expr)
+	}
+
+	for _, invalid := range []string{"", "a /", "(a + b", "a b", "1 + 2", "a % b"} {
+		_, err := parseExpression(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDeriveMetrics(t *testing.T) {
+	metric := func(name string, value float64, attrs labels.Set) Metric {
+		attrs["targetName"] = "target"
+		return Metric{name: name, value: value, metricType: metricType_COUNTER, attributes: attrs}
+	}
+	pair := TargetMetrics{Metrics: []Metric{
+		metric("errors_total", 1, labels.Set{"method": "GET", "code": "500"}),
+		metric("errors_total", 1, labels.Set{"method": "GET", "code": "503"}),
+		metric("requests_total", 10, labels.Set{"method": "GET"}),
+		metric("requests_total", 5, labels.Set{"method": "POST"}),
+		metric("errors_total", 0, labels.Set{"method": "PUT"}),
+	}}
+	rules := compileDerivedMetrics([]DerivedMetricRule{
+		{Name: "error_ratio", Expression: "errors_total / requests_total", By: []string{"method"}},
+		{Name: "total_requests", Expression: "requests_total"},
+		{Name: "missing", Expression: "unknown_total / requests_total"},
+	})
+	require.Len(t, rules, 3)
+
+	deriveMetrics(&pair, rules)
+
+	derived := pair.Metrics[5:]
+	require.Len(t, derived, 2)
+	assert.Equal(t, "error_ratio", derived[0].name)
+	assert.Equal(t, 0.2, derived[0].value)
+	assert.Equal(t, metricType_GAUGE, derived[0].metricType)
+	assert.Equal(t, "GET", derived[0].attributes["method"])
+	assert.Equal(t, "target", derived[0].attributes["targetName"])
+	assert.Equal(t, "total_requests", derived[1].name)
+	assert.Equal(t, 15.0, derived[1].value)
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reasons the series are dropped for, in the samples.
+const (
+	DroppedByIgnoreRules = "ignore_metrics"
+	DroppedBySeriesRules = "ignore_series"
+	DroppedByOnlyMetrics = "only_metrics"
+	DroppedByLabelLimit  = "label_limit"
+	DroppedByBudget      = "ingest_budget"
+)
+
+// DroppedSamplingConfig configures the emission of a sample of the series
+// dropped by the processing rules and the limits, to verify nothing important
+// is dropped without ingesting all of them.
+type DroppedSamplingConfig struct {
+	// Rate is N to emit 1 in N of the dropped series. Zero disables it.
+	Rate int `mapstructure:"rate"`
+	// Path of the file the samples are appended to as JSON lines. Defaults to
+	// the standard output.
+	Path string `mapstructure:"path"`
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c *DroppedSamplingConfig) Validate() error {
+	if c.Rate < 0 {
+		return fmt.Errorf("rate can't be negative")
+	}
+	return nil
+}
+
+// DefaultDroppedSampler samples the dropped series. It's disabled until it's
+// configured.
+var DefaultDroppedSampler = &DroppedSampler{}
+
+// DroppedSampler writes 1 in N of the dropped series.
+type DroppedSampler struct {
+	mtx  sync.Mutex
+	rate int
+	w    io.Writer
+	// dropped is the number of dropped series since the last sample.
+	dropped int
+	now     func() time.Time
+}
+
+// droppedSample is a line of the samples.
+type droppedSample struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Target string    `json:"target"`
+	Metric *Metric   `json:"metric"`
+}
+
+// Configure enables the sampler as configured, opening the file of the
+// samples. The previous file is not closed.
+func (s *DroppedSampler) Configure(cfg DroppedSamplingConfig) error {
+	var w io.Writer = os.Stdout
+	if cfg.Path != "" {
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening the dropped series samples file: %w", err)
+		}
+		w = f
+	}
+	s.set(cfg.Rate, w)
+	return nil
+}
+
+func (s *DroppedSampler) set(rate int, w io.Writer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rate = rate
+	s.w = w
+	s.dropped = 0
+	if s.now == nil {
+		s.now = time.Now
+	}
+}
+
+// Sample writes 1 in N of the given series of the target, dropped for the
+// given reason.
+func (s *DroppedSampler) Sample(reason, target string, dropped ...Metric) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.rate == 0 {
+		return
+	}
+	for i := range dropped {
+		s.dropped++
+		if s.dropped < s.rate {
+			continue
+		}
+		s.dropped = 0
+		line, err := json.Marshal(droppedSample{Time: s.now(), Reason: reason, Target: target, Metric: &dropped[i]})
+		if err != nil {
+			// The histograms with infinite buckets can't be marshaled.
+			ilog.WithError(err).Debug("can't write the sample of a dropped series")
+			continue
+		}
+		if _, err := s.w.Write(append(line, '\n')); err != nil {
+			ilog.WithError(err).Warn("can't write the sample of a dropped series")
+		}
+	}
+}
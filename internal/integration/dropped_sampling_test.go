@@ -0,0 +1,58 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestDroppedSampler(t *testing.T) {
+	var out bytes.Buffer
+	s := &DroppedSampler{now: func() time.Time { return time.Unix(0, 0).UTC() }}
+	s.Sample(DroppedByIgnoreRules, "target", NewGaugeMetric("disabled", 1, labels.Set{}))
+	s.set(3, &out)
+
+	var metrics []Metric
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		metrics = append(metrics, NewGaugeMetric(name, 1, labels.Set{"job": "x"}))
+	}
+	s.Sample(DroppedByIgnoreRules, "target", metrics[:4]...)
+	s.Sample(DroppedByBudget, "other", metrics[4:]...)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var sample map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &sample))
+	assert.Equal(t, "ignore_metrics", sample["reason"])
+	assert.Equal(t, "target", sample["target"])
+	assert.Equal(t, "1970-01-01T00:00:00Z", sample["time"])
+	assert.Equal(t, "c", sample["metric"].(map[string]interface{})["name"])
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &sample))
+	assert.Equal(t, "ingest_budget", sample["reason"])
+	assert.Equal(t, "f", sample["metric"].(map[string]interface{})["name"])
+}
+
+func TestFilter_SamplesDropped(t *testing.T) {
+	var out bytes.Buffer
+	DefaultDroppedSampler.set(1, &out)
+	defer DefaultDroppedSampler.set(0, nil)
+
+	pair := TargetMetrics{
+		Target:  endpoints.Target{Name: "target"},
+		Metrics: []Metric{NewGaugeMetric("go_goroutines", 1, labels.Set{}), NewGaugeMetric("up", 1, labels.Set{})},
+	}
+	Filter(&pair, ignoreRules{{Prefixes: []string{"go_"}}})
+	require.Len(t, pair.Metrics, 1)
+	assert.Contains(t, out.String(), `"name":"go_goroutines"`)
+	assert.NotContains(t, out.String(), `"name":"up"`)
+}
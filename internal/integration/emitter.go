@@ -4,9 +4,12 @@
 package integration
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -20,6 +23,37 @@ type Emitter interface {
 	Emit([]Metric) error
 }
 
+// Flusher is implemented by the Emitters that keep metrics in memory before
+// sending them. Flush sends the pending metrics and waits until they are
+// sent or the context is done.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// AuthChecker is implemented by the Emitters that send metrics to an
+// authenticated endpoint. CheckAuth verifies the configured credentials.
+type AuthChecker interface {
+	CheckAuth(ctx context.Context) error
+}
+
+// FlushEmitters flushes the pending metrics of the emitters that implement
+// the Flusher interface. It returns the last error, if any of them couldn't
+// be flushed.
+func FlushEmitters(ctx context.Context, emitters []Emitter) error {
+	var flushErr error
+	for _, e := range emitters {
+		f, ok := e.(Flusher)
+		if !ok {
+			continue
+		}
+		if err := f.Flush(ctx); err != nil {
+			logrus.WithError(err).WithField("emitter", e.Name()).Warn("could not flush pending metrics")
+			flushErr = fmt.Errorf("flushing emitter %s: %w", e.Name(), err)
+		}
+	}
+	return flushErr
+}
+
 // copyAttrs returns a (shallow) copy of the passed attrs.
 func copyAttrs(attrs map[string]interface{}) map[string]interface{} {
 	duplicate := make(map[string]interface{}, len(attrs))
@@ -56,3 +90,16 @@ func (se *StdoutEmitter) Emit(metrics []Metric) error {
 	fmt.Println(string(b))
 	return nil
 }
+
+// EmitEvent prints the event into stdout.
+func (se *StdoutEmitter) EmitEvent(eventType string, attributes map[string]interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"eventType":  eventType,
+		"attributes": attributes,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// DefaultErrorPayloadPatterns match the lines the exporters usually write in
+// the payloads when they fail collecting the metrics.
+var DefaultErrorPayloadPatterns = []string{
+	`(?i)error collecting metrics`,
+	`(?i)^error gathering metrics`,
+	`^An error has occurred while serving metrics`,
+}
+
+// maxErrorPayloadLineLength is the number of bytes of each line that is
+// matched against the patterns.
+const maxErrorPayloadLineLength = 64 * 1024
+
+// ErrorPayloadConfig configures the detection of the payloads of the
+// exporters that failed collecting their metrics, but answered with a
+// successful status anyway.
+type ErrorPayloadConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Patterns are the regular expressions matching the lines of the error
+	// payloads. Defaults to DefaultErrorPayloadPatterns.
+	Patterns []string `mapstructure:"patterns"`
+
+	compiled []*regexp.Regexp
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *ErrorPayloadConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Patterns) == 0 {
+		c.Patterns = DefaultErrorPayloadPatterns
+	}
+	c.compiled = make([]*regexp.Regexp, 0, len(c.Patterns))
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		c.compiled = append(c.compiled, re)
+	}
+	return nil
+}
+
+// FetcherWithErrorPayloadDetection makes the Fetcher fail the scrapes whose
+// payload has a line matching the patterns of the validated configuration,
+// instead of emitting the partial metrics of the payload.
+func FetcherWithErrorPayloadDetection(cfg ErrorPayloadConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.errorPayloadPatterns = cfg.compiled
+	}
+}
+
+// ErrorPayload is the error of the scrapes of error payloads, with the line
+// reported by the exporter.
+type ErrorPayload struct {
+	Line string
+}
+
+func (e ErrorPayload) Error() string {
+	return fmt.Sprintf("the exporter reported an error: %s", e.Line)
+}
+
+// errorPayloadDoer fails reading the responses with lines matching the
+// patterns.
+type errorPayloadDoer struct {
+	inner    prometheus.HTTPDoer
+	target   string
+	patterns []*regexp.Regexp
+}
+
+// Do does the request and wraps the body of the response to check its lines
+// while it's read.
+func (d errorPayloadDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.inner.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &errorPayloadReader{ReadCloser: resp.Body, target: d.target, patterns: d.patterns}
+	return resp, nil
+}
+
+// errorPayloadReader matches the lines of the read payload against the
+// patterns, failing with an ErrorPayload on the first match.
+type errorPayloadReader struct {
+	io.ReadCloser
+	target   string
+	patterns []*regexp.Regexp
+	// line is the beginning of the line being read.
+	line []byte
+	err  error
+}
+
+func (r *errorPayloadReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	read := p[:n]
+	for len(read) > 0 {
+		end := bytes.IndexByte(read, '\n')
+		if end < 0 {
+			r.buffer(read)
+			break
+		}
+		r.buffer(read[:end])
+		read = read[end+1:]
+		if r.match() {
+			return n, r.err
+		}
+	}
+	if err == io.EOF && r.match() {
+		return n, r.err
+	}
+	return n, err
+}
+
+// buffer adds the bytes to the current line, up to its maximum length.
+func (r *errorPayloadReader) buffer(b []byte) {
+	if room := maxErrorPayloadLineLength - len(r.line); room < len(b) {
+		b = b[:room]
+	}
+	r.line = append(r.line, b...)
+}
+
+// match checks the current line and starts the next one.
+func (r *errorPayloadReader) match() bool {
+	line := r.line
+	r.line = r.line[:0]
+	for _, re := range r.patterns {
+		if re.Match(line) {
+			r.err = ErrorPayload{Line: string(bytes.TrimSpace(line))}
+			errorPayloadsMetric.WithLabelValues(r.target).Inc()
+			return true
+		}
+	}
+	return false
+}
+
+// withErrorPayloadDetection returns the client checking the payloads of the
+// target, if the fetcher has patterns.
+func (pf *prometheusFetcher) withErrorPayloadDetection(target string, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if len(pf.errorPayloadPatterns) == 0 {
+		return c
+	}
+	return errorPayloadDoer{inner: c, target: target, patterns: pf.errorPayloadPatterns}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestErrorPayloadConfigValidate(t *testing.T) {
+	cfg := ErrorPayloadConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, DefaultErrorPayloadPatterns, cfg.Patterns)
+	assert.Len(t, cfg.compiled, len(DefaultErrorPayloadPatterns))
+
+	assert.Error(t, (&ErrorPayloadConfig{Enabled: true, Patterns: []string{"("}}).Validate())
+	assert.NoError(t, (&ErrorPayloadConfig{Patterns: []string{"("}}).Validate(), "not validated when disabled")
+}
+
+func TestErrorPayloadReader(t *testing.T) {
+	cfg := ErrorPayloadConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	read := func(payload string) (string, error) {
+		// The payload is read a byte at a time, to match the lines split
+		// across reads.
+		r := &errorPayloadReader{
+			ReadCloser: ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(payload))),
+			target:     "target",
+			patterns:   cfg.compiled,
+		}
+		b, err := ioutil.ReadAll(r)
+		return string(b), err
+	}
+
+	b, err := read("# TYPE up gauge\nup 1\n")
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE up gauge\nup 1\n", b)
+
+	_, err = read("up 1\n# error collecting metrics: connection refused\nup 0\n")
+	require.Error(t, err)
+	assert.Equal(t, ErrorPayload{Line: "# error collecting metrics: connection refused"}, err)
+
+	_, err = read("up 1\nAn error has occurred while serving metrics: timeout")
+	assert.Error(t, err, "the last line without a line break is matched")
+}
+
+func TestFetcher_ErrorPayloadDetection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 0\n# error collecting metrics: database is down\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	targets := []endpoints.Target{endpoints.New("target", *addr, endpoints.Object{})}
+
+	cfg := ErrorPayloadConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithErrorPayloadDetection(cfg))
+	var scraped int
+	for range fetcher.Fetch(context.Background(), targets) {
+		scraped++
+	}
+	assert.Zero(t, scraped, "the scrape fails instead of emitting the partial metrics")
+}
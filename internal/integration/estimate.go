@@ -0,0 +1,141 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// estimatedDatapointOverhead is the approximate size in bytes of a datapoint
+// besides its name and attributes: value, timestamp, type and encoding.
+const estimatedDatapointOverhead = 48
+
+// EstimateEmitter doesn't emit the metrics, it counts them to estimate the
+// datapoints and bytes that would be ingested per minute.
+type EstimateEmitter struct {
+	scrapeDuration time.Duration
+
+	mtx         sync.Mutex
+	byTarget    map[string]*estimate
+	byNamespace map[string]*estimate
+	byPrefix    map[string]*estimate
+}
+
+// estimate is the number of series and their approximate size in a scrape.
+type estimate struct {
+	series int
+	bytes  int
+}
+
+// NewEstimateEmitter returns an EstimateEmitter for metrics scraped every
+// scrapeDuration.
+func NewEstimateEmitter(scrapeDuration time.Duration) *EstimateEmitter {
+	return &EstimateEmitter{
+		scrapeDuration: scrapeDuration,
+		byTarget:       make(map[string]*estimate),
+		byNamespace:    make(map[string]*estimate),
+		byPrefix:       make(map[string]*estimate),
+	}
+}
+
+// Name is the EstimateEmitter name.
+func (ee *EstimateEmitter) Name() string {
+	return "estimate"
+}
+
+// Emit accounts the metrics by target, namespace and metric prefix.
+func (ee *EstimateEmitter) Emit(metrics []Metric) error {
+	ee.mtx.Lock()
+	defer ee.mtx.Unlock()
+	for _, m := range metrics {
+		size := estimatedDatapointOverhead + len(m.name)
+		for k, v := range m.attributes {
+			size += len(k) + len(fmt.Sprint(v))
+		}
+		add(ee.byTarget, attributeString(m, "scrapedTargetURL"), size)
+		add(ee.byNamespace, attributeString(m, "namespaceName"), size)
+		add(ee.byPrefix, metricPrefix(m.name), size)
+	}
+	return nil
+}
+
+func add(estimates map[string]*estimate, key string, size int) {
+	e, ok := estimates[key]
+	if !ok {
+		e = &estimate{}
+		estimates[key] = e
+	}
+	e.series++
+	e.bytes += size
+}
+
+func attributeString(m Metric, name string) string {
+	if v, ok := m.attributes[name]; ok && fmt.Sprint(v) != "" {
+		return fmt.Sprint(v)
+	}
+	return "(none)"
+}
+
+// metricPrefix returns the first part of the metric name, e.g. node for
+// node_cpu_seconds_total.
+func metricPrefix(name string) string {
+	if i := strings.Index(name, "_"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// WriteReport writes the series, datapoints per minute and estimated ingest
+// per target, namespace and metric prefix, the biggest first.
+func (ee *EstimateEmitter) WriteReport(w io.Writer) error {
+	ee.mtx.Lock()
+	defer ee.mtx.Unlock()
+
+	scrapesPerMinute := float64(time.Minute) / float64(ee.scrapeDuration)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	total := estimate{}
+	for _, e := range ee.byTarget {
+		total.series += e.series
+		total.bytes += e.bytes
+	}
+	fmt.Fprintf(tw, "Scrape interval: %s. Ingest estimates are approximate.\n\n", ee.scrapeDuration)
+	fmt.Fprintf(tw, "TOTAL\tSERIES\tDPM\tMB/DAY\t\n")
+	writeEstimate(tw, "", total, scrapesPerMinute)
+	for _, section := range []struct {
+		title     string
+		estimates map[string]*estimate
+	}{
+		{"TARGET", ee.byTarget},
+		{"NAMESPACE", ee.byNamespace},
+		{"METRIC PREFIX", ee.byPrefix},
+	} {
+		fmt.Fprintf(tw, "\n%s\tSERIES\tDPM\tMB/DAY\t\n", section.title)
+		keys := make([]string, 0, len(section.estimates))
+		for k := range section.estimates {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := section.estimates[keys[i]], section.estimates[keys[j]]
+			if a.series != b.series {
+				return a.series > b.series
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			writeEstimate(tw, k, *section.estimates[k], scrapesPerMinute)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeEstimate(w io.Writer, key string, e estimate, scrapesPerMinute float64) {
+	dpm := float64(e.series) * scrapesPerMinute
+	mbPerDay := float64(e.bytes) * scrapesPerMinute * 60 * 24 / 1e6
+	fmt.Fprintf(w, "%s\t%d\t%.0f\t%.1f\t\n", key, e.series, dpm, mbPerDay)
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestEstimateEmitter(t *testing.T) {
+	metric := func(name, target, namespace string) Metric {
+		attrs := labels.Set{"scrapedTargetURL": target}
+		if namespace != "" {
+			attrs["namespaceName"] = namespace
+		}
+		return Metric{name: name, value: 1.0, metricType: metricType_GAUGE, attributes: attrs}
+	}
+	e := NewEstimateEmitter(30 * time.Second)
+	require.NoError(t, e.Emit([]Metric{
+		metric("node_cpu_seconds_total", "http://a/metrics", "default"),
+		metric("node_load1", "http://a/metrics", "default"),
+		metric("go_goroutines", "http://b/metrics", ""),
+	}))
+
+	assert.Equal(t, 2, e.byTarget["http://a/metrics"].series)
+	assert.Equal(t, 1, e.byNamespace["(none)"].series)
+	assert.Equal(t, 2, e.byPrefix["node"].series)
+
+	var report bytes.Buffer
+	require.NoError(t, e.WriteReport(&report))
+	lines := strings.Split(report.String(), "\n")
+	// The total has 3 series, that is 6 datapoints per minute.
+	assert.Equal(t, []string{"3", "6"}, strings.Fields(lines[3])[:2])
+	assert.Contains(t, report.String(), "METRIC PREFIX")
+	// The biggest targets go first.
+	assert.Contains(t, lines[6], "http://a/metrics")
+}
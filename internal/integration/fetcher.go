@@ -4,14 +4,19 @@
 package integration
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	io_prometheus_client "github.com/prometheus/client_model/go"
@@ -20,6 +25,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/fips"
 	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
 	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
 )
@@ -28,7 +34,97 @@ import (
 type Fetcher interface {
 	// Fetcher fetches data from a set of Prometheus /metrics endpoints. It ignores failed endpoints.
 	// It returns each data entry from a channel, assuming this function may run in background.
-	Fetch(t []endpoints.Target) <-chan TargetMetrics
+	// Once the context is done no more targets are fetched, and the channel is closed after the
+	// ongoing fetches finish.
+	Fetch(ctx context.Context, t []endpoints.Target) <-chan TargetMetrics
+}
+
+// PressureChecker reports whether the integration is running out of resources.
+type PressureChecker interface {
+	UnderPressure() bool
+}
+
+// sheddingFetcher is a Fetcher decorator that skips the low priority targets
+// while the integration is under pressure.
+type sheddingFetcher struct {
+	inner   Fetcher
+	checker PressureChecker
+}
+
+// NewSheddingFetcher wraps the given Fetcher so the low priority targets are
+// not fetched while the checker reports pressure.
+func NewSheddingFetcher(inner Fetcher, checker PressureChecker) Fetcher {
+	return &sheddingFetcher{inner: inner, checker: checker}
+}
+
+func (sf *sheddingFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	if !sf.checker.UnderPressure() {
+		return sf.inner.Fetch(ctx, targets)
+	}
+
+	kept := make([]endpoints.Target, 0, len(targets))
+	for _, t := range targets {
+		if !t.LowPriority {
+			kept = append(kept, t)
+		}
+	}
+	if shed := len(targets) - len(kept); shed > 0 {
+		shedTargetsMetric.Add(float64(shed))
+		logrus.WithField("component", "fetcher").Warnf("memory usage is close to the limit, skipping %d low priority targets", shed)
+	}
+	return sf.inner.Fetch(ctx, kept)
+}
+
+// Policies of the limitingFetcher to choose the targets fetched when there are
+// more than the maximum.
+const (
+	// MaxTargetsAlphabetical keeps the first targets by URL.
+	MaxTargetsAlphabetical = "alphabetical"
+	// MaxTargetsPriority keeps the targets that are not low priority first,
+	// and then the first ones by URL.
+	MaxTargetsPriority = "priority"
+	// MaxTargetsFail doesn't fetch any target.
+	MaxTargetsFail = "fail"
+)
+
+// limitingFetcher is a Fetcher decorator that fetches at most a maximum
+// number of targets, choosing them according to a policy.
+type limitingFetcher struct {
+	inner  Fetcher
+	max    int
+	policy string
+}
+
+// NewLimitingFetcher wraps the given Fetcher so at most max targets are
+// fetched in each scrape cycle. The policy is one of MaxTargetsAlphabetical,
+// MaxTargetsPriority or MaxTargetsFail.
+func NewLimitingFetcher(inner Fetcher, max int, policy string) Fetcher {
+	return &limitingFetcher{inner: inner, max: max, policy: policy}
+}
+
+func (lf *limitingFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	if len(targets) <= lf.max {
+		return lf.inner.Fetch(ctx, targets)
+	}
+
+	log := logrus.WithField("component", "fetcher")
+	if lf.policy == MaxTargetsFail {
+		droppedTargetsMetric.Add(float64(len(targets)))
+		log.Errorf("found %d targets, more than the maximum of %d, skipping the scrape cycle", len(targets), lf.max)
+		return lf.inner.Fetch(ctx, nil)
+	}
+
+	sorted := append([]endpoints.Target(nil), targets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if lf.policy == MaxTargetsPriority && sorted[i].LowPriority != sorted[j].LowPriority {
+			return !sorted[i].LowPriority
+		}
+		return sorted[i].URL.String() < sorted[j].URL.String()
+	})
+	dropped := len(sorted) - lf.max
+	droppedTargetsMetric.Add(float64(dropped))
+	log.Warnf("found %d targets, more than the maximum of %d, skipping %d targets", len(targets), lf.max, dropped)
+	return lf.inner.Fetch(ctx, sorted[:lf.max])
 }
 
 // TargetMetrics holds a pair of fetched metrics with the Target where they have been targetted from
@@ -38,18 +134,17 @@ type TargetMetrics struct {
 }
 
 // NewTLSConfig creates a TLS configuration. If a CA cert is provided it is
-// read and used to validate the scrape target's certificate properly.
+// read and used to validate the scrape target's certificate properly. The CA
+// cert is reloaded when the file is modified.
 func NewTLSConfig(CAFile string, InsecureSkipVerify bool) (*tls.Config, error) {
 	tlsConfig := &tls.Config{InsecureSkipVerify: InsecureSkipVerify}
 
 	if len(CAFile) > 0 {
-		caCertPool := x509.NewCertPool()
-		caCert, err := ioutil.ReadFile(CAFile)
+		files, err := newCertificateFiles(CAFile, "", "")
 		if err != nil {
-			return nil, fmt.Errorf("unable to use specified CA cert %s: %s", CAFile, err)
+			return nil, err
 		}
-		caCertPool.AppendCertsFromPEM(caCert)
-		tlsConfig.RootCAs = caCertPool
+		files.configure(tlsConfig)
 	}
 	return tlsConfig, nil
 }
@@ -69,17 +164,186 @@ func NewRoundTripper(BearerTokenFile string, CaFile string, InsecureSkipVerify b
 }
 
 func newDefaultRoundTripper(tlsConfig *tls.Config) http.RoundTripper {
-	var rt http.RoundTripper = &http.Transport{
+	return HTTPClientConfig{}.transport(tlsConfig)
+}
+
+// HTTP versions that can be forced in HTTPClientConfig.
+const (
+	HTTPVersion1 = "1.1"
+	HTTPVersion2 = "2"
+)
+
+// HTTPClientConfig holds the settings of the HTTP client used to scrape the
+// targets. Zero values keep the defaults.
+type HTTPClientConfig struct {
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept per host.
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost limits the number of connections per host, including
+	// the ones in use. Zero means no limit.
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"`
+	// TLSHandshakeTimeout is the maximum time to wait for a TLS handshake.
+	TLSHandshakeTimeout time.Duration `mapstructure:"tls_handshake_timeout"`
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool `mapstructure:"disable_keep_alives"`
+	// HTTPVersion forces HTTP/1.1 ("1.1") or HTTP/2 ("2") for TLS targets.
+	// When empty, HTTP/1.1 is used.
+	HTTPVersion string `mapstructure:"http_version"`
+	// PreferIPProtocol is the protocol, ipv4 or ipv6, of the addresses tried
+	// first when the host of a target resolves to addresses of both. When
+	// empty, the order of the resolver is kept.
+	PreferIPProtocol string `mapstructure:"prefer_ip_protocol"`
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c HTTPClientConfig) Validate() error {
+	switch c.HTTPVersion {
+	case "", HTTPVersion1, HTTPVersion2:
+	default:
+		return fmt.Errorf("unknown http_version %q, valid values are %q and %q", c.HTTPVersion, HTTPVersion1, HTTPVersion2)
+	}
+	if c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 {
+		return fmt.Errorf("the maximum number of connections can't be negative")
+	}
+	return endpoints.ValidateIPProtocol(c.PreferIPProtocol)
+}
+
+// Merge returns the configuration with the zero values replaced by the ones
+// of the given defaults.
+func (c HTTPClientConfig) Merge(defaults HTTPClientConfig) HTTPClientConfig {
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost == 0 {
+		c.MaxConnsPerHost = defaults.MaxConnsPerHost
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = defaults.TLSHandshakeTimeout
+	}
+	c.DisableKeepAlives = c.DisableKeepAlives || defaults.DisableKeepAlives
+	if c.HTTPVersion == "" {
+		c.HTTPVersion = defaults.HTTPVersion
+	}
+	if c.PreferIPProtocol == "" {
+		c.PreferIPProtocol = defaults.PreferIPProtocol
+	}
+	return c
+}
+
+func (c HTTPClientConfig) transport(tlsConfig *tls.Config) http.RoundTripper {
+	return c.transportWithControl(tlsConfig, nil)
+}
+
+// transportWithControl returns the transport with the configuration, whose
+// connections are checked by control, if set, before being established.
+func (c HTTPClientConfig) transportWithControl(tlsConfig *tls.Config, control func(network, address string, c syscall.RawConn) error) *http.Transport {
+	t := &http.Transport{
 		MaxIdleConns:        20000,
 		MaxIdleConnsPerHost: 1000, // see https://github.com/golang/go/issues/13801
-		DisableKeepAlives:   false,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		DisableKeepAlives:   c.DisableKeepAlives,
 		DisableCompression:  true,
 		// 5 minutes is typically above the maximum sane scrape interval. So we can
 		// use keepalive for all configurations.
-		IdleConnTimeout: 5 * time.Minute,
-		TLSClientConfig: tlsConfig,
+		IdleConnTimeout:     5 * time.Minute,
+		TLSHandshakeTimeout: c.TLSHandshakeTimeout,
+		TLSClientConfig:     fips.Apply(tlsConfig),
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+	if c.PreferIPProtocol != "" {
+		t.DialContext = preferIPDialer(c.PreferIPProtocol, dialer)
+	} else if control != nil {
+		t.DialContext = dialer.DialContext
+	}
+	if files, ok := verifyingFiles(tlsConfig); ok {
+		if t.DialContext == nil {
+			t.DialContext = dialer.DialContext
+		}
+		t.DialTLS = verifiedTLSDialer(t, files)
+	}
+	switch c.HTTPVersion {
+	case HTTPVersion2:
+		t.ForceAttemptHTTP2 = true
+	case HTTPVersion1:
+		// A non-nil empty map disables HTTP/2.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// preferIPDialer returns a dial function that tries the addresses of the
+// given protocol of the host before the other ones.
+func preferIPDialer(protocol string, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		sortByIPProtocol(ips, protocol)
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// verifiedTLSDialer returns a dial function establishing the TLS connections
+// of the transport, whose server certificates are verified with the current
+// CA bundle of the files against the configured server name or the host
+// dialed, IP addresses included. The connections are dialed with the
+// DialContext of the transport when they are established, so a jump host set
+// afterwards is used too.
+func verifiedTLSDialer(t *http.Transport, files *certificateFiles) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		conn, err := dial(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig := t.TLSClientConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = files.verifyPeerCertificate(tlsConfig.ServerName)
+
+		if t.TLSHandshakeTimeout > 0 {
+			_ = conn.SetDeadline(time.Now().Add(t.TLSHandshakeTimeout))
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return tlsConn, nil
 	}
-	return rt
+}
+
+// sortByIPProtocol moves the addresses of the protocol before the other ones,
+// keeping their order otherwise.
+func sortByIPProtocol(ips []net.IPAddr, protocol string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		return endpoints.MatchesIPProtocol(ips[i].IP, protocol) && !endpoints.MatchesIPProtocol(ips[j].IP, protocol)
+	})
 }
 
 // NewBearerAuthFileRoundTripper adds the bearer token read from the provided file to a request unless
@@ -123,20 +387,157 @@ func cloneRequest(r *http.Request) *http.Request {
 }
 
 // NewFetcher returns the default Fetcher implementation
-func NewFetcher(fetchDuration time.Duration, fetchTimeout time.Duration, workerThreads int, BearerTokenFile string, CaFile string, InsecureSkipVerify bool, queueLength int) Fetcher {
+func NewFetcher(fetchDuration time.Duration, fetchTimeout time.Duration, workerThreads int, BearerTokenFile string, CaFile string, InsecureSkipVerify bool, queueLength int, opts ...FetcherOpt) Fetcher {
 	tr, _ := NewRoundTripper(BearerTokenFile, CaFile, InsecureSkipVerify)
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   fetchTimeout,
 	}
-	return &prometheusFetcher{
-		workerThreads: workerThreads,
-		queueLength:   queueLength,
-		httpClient:    client,
-		duration:      fetchDuration,
-		fetchTimeout:  fetchTimeout,
-		getMetrics:    prometheus.Get,
-		log:           logrus.WithField("component", "Fetcher"),
+	pf := &prometheusFetcher{
+		workerThreads:        workerThreads,
+		queueLength:          queueLength,
+		httpClient:           client,
+		retrieverClients:     make(map[string]prometheus.HTTPDoer),
+		retrieverHTTPConfigs: make(map[string]HTTPClientConfig),
+		targetClients:        make(map[string]prometheus.HTTPDoer),
+		bearerTokenFile:      BearerTokenFile,
+		caFile:               CaFile,
+		insecureSkipVerify:   InsecureSkipVerify,
+		duration:             fetchDuration,
+		fetchTimeout:         fetchTimeout,
+		getMetrics:           prometheus.Get,
+		getPayload:           prometheus.GetPayload,
+		decode:               decodePayload,
+		log:                  logrus.WithField("component", "Fetcher"),
+	}
+	for _, opt := range opts {
+		opt(pf)
+	}
+	return pf
+}
+
+// FetcherOpt sets optional configuration of the Fetcher returned by NewFetcher.
+type FetcherOpt func(*prometheusFetcher)
+
+// FetcherWithHTTPClientConfig makes the Fetcher scrape the targets with an
+// HTTP client built with the given configuration.
+func FetcherWithHTTPClientConfig(httpCfg HTTPClientConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.httpConfig = httpCfg
+		if c := pf.newHTTPClient(httpCfg); c != nil {
+			pf.httpClient = c
+		}
+	}
+}
+
+// FetcherWithRetrieverHTTPClient makes the Fetcher scrape the targets of the
+// given retriever with an HTTP client built with the given configuration.
+func FetcherWithRetrieverHTTPClient(retriever string, httpCfg HTTPClientConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		if c := pf.newHTTPClient(httpCfg); c != nil {
+			pf.retrieverClients[retriever] = c
+			pf.retrieverHTTPConfigs[retriever] = httpCfg
+		}
+	}
+}
+
+// FetcherWithTLSConfig makes the Fetcher scrape the targets with the given TLS
+// configuration instead of the one built from the CA file. Options setting
+// the HTTP client configuration must be set after this one.
+func FetcherWithTLSConfig(tlsConfig *tls.Config) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.tlsConfig = tlsConfig
+		if c := pf.newHTTPClient(HTTPClientConfig{}); c != nil {
+			pf.httpClient = c
+		}
+	}
+}
+
+// newHTTPClient returns an HTTP client with the given configuration, or nil
+// if it can't be created.
+func (pf *prometheusFetcher) newHTTPClient(httpCfg HTTPClientConfig) *http.Client {
+	tlsConfig, err := pf.baseTLSConfig()
+	if err != nil {
+		pf.log.WithError(err).Warn("couldn't create the HTTP client, using the default one")
+		return nil
+	}
+	rt := httpCfg.transport(tlsConfig)
+	if pf.bearerTokenFile != "" {
+		rt = NewBearerAuthFileRoundTripper(pf.bearerTokenFile, rt)
+	}
+	return &http.Client{
+		Transport: rt,
+		Timeout:   pf.fetchTimeout,
+	}
+}
+
+// baseTLSConfig returns the TLS configuration the targets are scraped with,
+// unless they have their own.
+func (pf *prometheusFetcher) baseTLSConfig() (*tls.Config, error) {
+	if pf.tlsConfig != nil {
+		return pf.tlsConfig, nil
+	}
+	return NewTLSConfig(pf.caFile, pf.insecureSkipVerify)
+}
+
+// httpConfigFor returns the configuration of the HTTP client of the
+// retriever of the target, or the one of the integration.
+func (pf *prometheusFetcher) httpConfigFor(t endpoints.Target) HTTPClientConfig {
+	if c, ok := pf.retrieverHTTPConfigs[t.Retriever]; ok {
+		return c
+	}
+	// Retrievers of additional clusters are named kubernetes/<cluster>.
+	if c, ok := pf.retrieverHTTPConfigs[strings.SplitN(t.Retriever, "/", 2)[0]]; ok {
+		return c
+	}
+	return pf.httpConfig
+}
+
+// Parse modes of the scraped payloads.
+const (
+	// ParseStrict fails the scrape of the payloads with malformed lines.
+	ParseStrict = "strict"
+	// ParseLenient skips the malformed lines of the payloads, failing the
+	// scrape only if there are more than the maximum.
+	ParseLenient = "lenient"
+)
+
+// FetcherWithLenientParsing makes the Fetcher skip up to maxErrors malformed
+// lines of each payload, instead of failing the scrape of the target.
+func FetcherWithLenientParsing(maxErrors int) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.decode = func(r io.Reader, url string) (prometheus.MetricFamiliesByName, error) {
+			mfs, skipped, err := prometheus.DecodeLenient(r, maxErrors)
+			pf.recordParseErrors(url, skipped)
+			return mfs, err
+		}
+		pf.getMetrics = func(httpClient prometheus.HTTPDoer, url string) (prometheus.MetricFamiliesByName, error) {
+			mfs, skipped, err := prometheus.GetLenient(httpClient, url, maxErrors)
+			pf.recordParseErrors(url, skipped)
+			return mfs, err
+		}
+	}
+}
+
+// recordParseErrors accounts and logs the malformed lines skipped in the
+// payload of the target.
+func (pf *prometheusFetcher) recordParseErrors(url string, skipped []error) {
+	if len(skipped) == 0 {
+		return
+	}
+	parseErrorsMetric.WithLabelValues(url).Add(float64(len(skipped)))
+	for _, err := range skipped {
+		pf.log.WithField("target", url).WithError(err).Debug("skipped malformed line")
+	}
+	pf.log.WithField("target", url).Warnf("skipped %d malformed lines, the first one: %v", len(skipped), skipped[0])
+}
+
+// FetcherWithSpillQueue makes the Fetcher store the raw payloads of the
+// targets in the SpillQueue, decoding them one by one as they are processed,
+// instead of keeping the decoded metrics in memory.
+func FetcherWithSpillQueue(q *SpillQueue) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.spill = q
 	}
 }
 
@@ -146,14 +547,74 @@ type prometheusFetcher struct {
 	duration      time.Duration
 	fetchTimeout  time.Duration
 	httpClient    prometheus.HTTPDoer
+	// httpConfig is the configuration of httpClient.
+	httpConfig HTTPClientConfig
+	// retrieverClients are the HTTP clients used for the targets of specific retrievers.
+	retrieverClients map[string]prometheus.HTTPDoer
+	// retrieverHTTPConfigs are the configurations of the retrieverClients.
+	retrieverHTTPConfigs map[string]HTTPClientConfig
+	// targetClients are the HTTP clients of the targets with their own TLS
+	// configuration or jump host, by configuration.
+	targetClientsMtx   sync.Mutex
+	targetClients      map[string]prometheus.HTTPDoer
+	bearerTokenFile    string
+	caFile             string
+	insecureSkipVerify bool
+	// tlsConfig replaces the TLS configuration built from caFile when set.
+	tlsConfig *tls.Config
 	// Provides IoC for better testability. Its usual value is 'prometheus.Get'.
 	getMetrics func(httpClient prometheus.HTTPDoer, url string) (prometheus.MetricFamiliesByName, error)
-	log        *logrus.Entry
+	// Its usual value is 'prometheus.GetPayload'.
+	getPayload func(httpClient prometheus.HTTPDoer, url string, w io.Writer) error
+	// decode decodes the spilled payloads. Its usual value decodes them with
+	// 'prometheus.Decode'.
+	decode func(r io.Reader, url string) (prometheus.MetricFamiliesByName, error)
+	// spill is nil unless the payloads are stored on disk.
+	spill *SpillQueue
+	// timestampSkew checks the timestamps reported by the targets.
+	timestampSkew TimestampSkewConfig
+	// nonFinitePolicy handles the NaN and Inf values, kept if empty.
+	nonFinitePolicy string
+	// metadata adds the description and unit of the metrics as attributes.
+	metadata bool
+	// normalizeUnits converts the metrics to seconds and bytes.
+	normalizeUnits bool
+	// labelValidation sanitizes or drops the labels with invalid names.
+	labelValidation LabelValidationConfig
+	// openMetrics emits the info and stateset metrics.
+	openMetrics OpenMetricsConfig
+	// targetInfo promotes the labels of the OpenTelemetry target_info
+	// metric to resource attributes of the other metrics of the target.
+	targetInfo bool
+	// checkContentType rejects the responses that aren't an exposition
+	// format.
+	checkContentType bool
+	// errorPayloadPatterns fail the scrapes of the payloads with a matching
+	// line.
+	errorPayloadPatterns []*regexp.Regexp
+	// selfHandler serves the metrics of the targets gathered in process, if
+	// set.
+	selfHandler http.Handler
+	// archive stores the raw payloads of the selected targets, if set.
+	archive *PayloadArchive
+	// scheduler chooses the targets that fit in the scrape budget, if set.
+	scheduler *fairScheduler
+	// latencySLO flags the persistently slow targets, if set.
+	latencySLO *latencySLOTracker
+	// maxPayloadSize is the maximum size of the payloads in bytes, unlimited
+	// if zero.
+	maxPayloadSize int64
+	// headers are set in all the scrape requests.
+	headers http.Header
+	log     *logrus.Entry
 }
 
 // Fetch implementation runs the connections to many targets in parallel, limited by the maxTargetConnections constant,
 // and submits TargetMetrics entries by the buffered channel, as long as they are retrieved
-func (pf *prometheusFetcher) Fetch(targets []endpoints.Target) <-chan TargetMetrics {
+func (pf *prometheusFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	if pf.scheduler != nil {
+		targets = pf.scheduler.schedule(targets)
+	}
 	results := make(chan TargetMetrics, pf.queueLength)
 	finishedTasks := sync.WaitGroup{}
 	finishedTasks.Add(len(targets))
@@ -166,8 +627,18 @@ func (pf *prometheusFetcher) Fetch(targets []endpoints.Target) <-chan TargetMetr
 		"thread_count": pf.workerThreads,
 	}).Debug("Starting fetch worker threads...")
 
+	var spilled chan spilledPayload
+	if pf.spill != nil {
+		spilled = make(chan spilledPayload, len(targets))
+		go pf.decodeSpilled(spilled, results)
+	}
+
 	for i := 0; i < pf.workerThreads; i++ {
-		go pf.work(targetChan, &finishedTasks, results)
+		if spilled != nil {
+			go pf.workSpilling(ctx, targetChan, &finishedTasks, spilled)
+		} else {
+			go pf.work(ctx, targetChan, &finishedTasks, results)
+		}
 	}
 
 	go func() {
@@ -191,9 +662,18 @@ func (pf *prometheusFetcher) Fetch(targets []endpoints.Target) <-chan TargetMetr
 		// After 15 seconds all targets are added to the queue, with 15 seconds left in the cycle
 		ticker := time.NewTicker((pf.duration / 2) / time.Duration(nTargets))
 		defer ticker.Stop()
-		for _, target := range targets {
+		for i, target := range targets {
 			targetChan <- target
-			<-ticker.C
+			select {
+			case <-ctx.Done():
+				// The targets that won't be released count as finished.
+				for range targets[i+1:] {
+					finishedTasks.Done()
+				}
+				pf.log.WithField("component", "fetcher").Debug("Fetch process cancelled.")
+				return
+			case <-ticker.C:
+			}
 		}
 	}()
 
@@ -203,45 +683,208 @@ func (pf *prometheusFetcher) Fetch(targets []endpoints.Target) <-chan TargetMetr
 		finishedTasks.Wait()
 		pf.log.WithField("component", "fetcher").Debug("Finished fetch process.")
 		close(targetChan)
+		if spilled != nil {
+			// The results channel is closed once the spilled payloads are decoded.
+			close(spilled)
+			return
+		}
 		close(results)
 	}()
 	return results
 }
 
 // work fetch the metrics of targets, pushing results to a channel and marking work as done.
-func (pf *prometheusFetcher) work(targets <-chan endpoints.Target, wg *sync.WaitGroup, results chan<- TargetMetrics) {
+func (pf *prometheusFetcher) work(ctx context.Context, targets <-chan endpoints.Target, wg *sync.WaitGroup, results chan<- TargetMetrics) {
 	for target := range targets {
+		span := startScrapeSpan(ctx, target)
 		if mfs, err := pf.fetch(target); err == nil {
+			metrics := pf.convert(target, mfs, time.Now())
+			span.SetAttribute("series", len(metrics))
+			span.End()
 			results <- TargetMetrics{
-				Metrics: convertPromMetrics(pf.log, target.Name, mfs),
+				Metrics: metrics,
 				Target:  target,
 			}
 		} else {
+			span.SetError(err)
+			span.End()
 			pf.log.WithError(err).Warn("error while scraping target")
 		}
 		wg.Done()
 	}
 }
 
-func (pf *prometheusFetcher) fetch(t endpoints.Target) (prometheus.MetricFamiliesByName, error) {
-	pf.log.WithField("target", t.Name).Debug("fetching URL: ", t.URL)
-	timer := promcli.NewTimer(promcli.ObserverFunc(fetchTargetDurationMetric.WithLabelValues(t.Name).Set))
-	httpClient := pf.httpClient
+// workSpilling fetch the payloads of targets, storing them in the spill queue and marking work as done.
+func (pf *prometheusFetcher) workSpilling(ctx context.Context, targets <-chan endpoints.Target, wg *sync.WaitGroup, spilled chan<- spilledPayload) {
+	for target := range targets {
+		span := startScrapeSpan(ctx, target)
+		p, err := pf.fetchToDisk(target)
+		span.SetError(err)
+		span.End()
+		if err == nil {
+			spilled <- p
+		} else {
+			pf.log.WithError(err).Warn("error while scraping target")
+		}
+		wg.Done()
+	}
+}
 
-	if isMutualTLSTarget(t) {
-		rt, err := NewMutualTLSRoundTripper(t.TLSConfig)
+// decodeSpilled decodes the spilled payloads one by one, pushing the results to a channel that is closed
+// when there are no more payloads.
+func (pf *prometheusFetcher) decodeSpilled(spilled <-chan spilledPayload, results chan<- TargetMetrics) {
+	for p := range spilled {
+		mfs, err := pf.spill.read(p, pf.decode)
 		if err != nil {
-			pf.log.WithError(err).Warnf("Error reading mTLS certs for %s (%s) ", t.Name, t.URL.String())
-			fetchErrorsTotalMetric.WithLabelValues(t.Name).Set(1)
+			pf.log.WithError(err).Warnf("decoding Prometheus metrics: %s (%s)", p.target.URL.String(), p.target.Object.Name)
+			fetchErrorsTotalMetric.WithLabelValues(p.target.Name).Set(1)
+			continue
 		}
-		httpClient = &http.Client{
-			Transport: rt,
-			Timeout:   pf.fetchTimeout,
+		results <- TargetMetrics{
+			Metrics: pf.convert(p.target, mfs, time.Now()),
+			Target:  p.target,
 		}
 	}
+	close(results)
+}
 
-	mfs, err := pf.getMetrics(httpClient, t.URL.String())
-	timer.ObserveDuration()
+// convert returns the metrics of the target from the metric families fetched
+// at the given time.
+func (pf *prometheusFetcher) convert(target endpoints.Target, mfs prometheus.MetricFamiliesByName, now time.Time) []Metric {
+	metrics := convertPromMetrics(pf.log, target.Name, mfs)
+	if pf.metadata {
+		addMetadata(metrics, mfs)
+	}
+	if pf.normalizeUnits {
+		normalizeUnits(metrics)
+	}
+	// The resource attributes are collected before the info metrics are
+	// folded, and added after the labels are validated, as their names
+	// have dots.
+	var resources targetInfoResources
+	if pf.targetInfo {
+		resources = collectTargetInfo(metrics)
+	}
+	metrics = pf.openMetrics.apply(metrics)
+	metrics = pf.labelValidation.apply(pf.log, target, metrics)
+	resources.promote(metrics)
+	metrics = applyNonFinitePolicy(pf.log, target.Name, metrics, pf.nonFinitePolicy)
+	if target.OmitGaugeTimestamps {
+		omitGaugeTimestamps(metrics)
+	}
+	metrics = pf.timestampSkew.apply(pf.log, target.Name, metrics, now)
+	fingerprintMetrics(metrics)
+	return metrics
+}
+
+// scrapeClient returns the client of the target, setting the headers,
+// converting the responses to the text format and checking them as
+// configured. The headers of the target replace the
+// ones of the fetcher.
+func (pf *prometheusFetcher) scrapeClient(t endpoints.Target) (prometheus.HTTPDoer, error) {
+	c, err := pf.client(t)
+	if err != nil {
+		return nil, err
+	}
+	c = pf.withHeaders(withTargetOptions(t, c))
+	c = pf.withMaxPayloadSize(t.Name, c)
+	c = pf.withPayloadArchive(t.Name, c)
+	c = withFormat(t, c)
+	c = pf.withContentTypeCheck(t.Name, c)
+	return pf.withErrorPayloadDetection(t.Name, c), nil
+}
+
+// client returns the HTTP client used to fetch the given target. It returns
+// an error if the TLS configuration of the target can't be loaded, so the
+// target isn't scraped without it.
+func (pf *prometheusFetcher) client(t endpoints.Target) (prometheus.HTTPDoer, error) {
+	if c, ok := pf.selfClient(t); ok {
+		return c, nil
+	}
+	if t.TLSConfig.IsEmpty() && !t.SSHProxy.Enabled() && t.ScrapeTimeout == 0 && t.NetworkPolicy == nil {
+		if c, ok := pf.retrieverClients[t.Retriever]; ok {
+			return c, nil
+		}
+		// Retrievers of additional clusters are named kubernetes/<cluster>.
+		if c, ok := pf.retrieverClients[strings.SplitN(t.Retriever, "/", 2)[0]]; ok {
+			return c, nil
+		}
+		return pf.httpClient, nil
+	}
+
+	// Targets with the same TLS configuration, jump host, timeout, network
+	// policy and HTTP client configuration share the client, so their
+	// connections are reused. The certificates are reloaded when rotated.
+	httpCfg := pf.httpConfigFor(t)
+	key := fmt.Sprintf("%+v %+v %v %p %+v", t.TLSConfig, t.SSHProxy, t.ScrapeTimeout, t.NetworkPolicy, httpCfg)
+	timeout := pf.fetchTimeout
+	if t.ScrapeTimeout > 0 {
+		timeout = t.ScrapeTimeout
+	}
+	pf.targetClientsMtx.Lock()
+	defer pf.targetClientsMtx.Unlock()
+	if c, ok := pf.targetClients[key]; ok {
+		return c, nil
+	}
+
+	tlsConfig, err := pf.targetTLSConfig(t)
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS configuration of the target: %w", err)
+	}
+	var control func(network, address string, c syscall.RawConn) error
+	if t.NetworkPolicy != nil {
+		control = t.NetworkPolicy.Control
+	}
+	var rt http.RoundTripper = httpCfg.transportWithControl(tlsConfig, control)
+	// The targets authenticated with their client certificate don't get
+	// the bearer token.
+	if pf.bearerTokenFile != "" && !isMutualTLSTarget(t) {
+		rt = NewBearerAuthFileRoundTripper(pf.bearerTokenFile, rt)
+	}
+	if t.SSHProxy.Enabled() {
+		setDialer(rt, newSSHDialer(t.SSHProxy).DialContext)
+	}
+	c := &http.Client{
+		Transport: rt,
+		Timeout:   timeout,
+	}
+	if t.NetworkPolicy != nil {
+		// The addresses are checked when connecting to them, but the
+		// redirects to denied IPs are refused without trying.
+		c.CheckRedirect = t.NetworkPolicy.CheckRedirect
+	}
+	pf.targetClients[key] = c
+	return c, nil
+}
+
+func (pf *prometheusFetcher) fetchToDisk(t endpoints.Target) (spilledPayload, error) {
+	pf.log.WithField("target", t.Name).Debug("fetching URL: ", t.URL)
+	timer := promcli.NewTimer(promcli.ObserverFunc(fetchTargetDurationMetric.WithLabelValues(t.Name).Set))
+	p, err := pf.spill.write(t, func(w io.Writer) error {
+		httpClient, err := pf.scrapeClient(t)
+		if err != nil {
+			return err
+		}
+		return pf.getPayload(httpClient, t.URL.String(), w)
+	})
+	pf.observeDuration(t, timer.ObserveDuration())
+	if err != nil {
+		pf.log.WithError(err).Warnf("fetching Prometheus metrics: %s (%s)", t.URL.String(), t.Object.Name)
+		fetchErrorsTotalMetric.WithLabelValues(t.Name).Set(1)
+	}
+	fetchesTotalMetric.WithLabelValues(t.Name).Set(1)
+	return p, err
+}
+
+func (pf *prometheusFetcher) fetch(t endpoints.Target) (prometheus.MetricFamiliesByName, error) {
+	pf.log.WithField("target", t.Name).Debug("fetching URL: ", t.URL)
+	timer := promcli.NewTimer(promcli.ObserverFunc(fetchTargetDurationMetric.WithLabelValues(t.Name).Set))
+	var mfs prometheus.MetricFamiliesByName
+	httpClient, err := pf.scrapeClient(t)
+	if err == nil {
+		mfs, err = pf.getMetrics(httpClient, t.URL.String())
+	}
+	pf.observeDuration(t, timer.ObserveDuration())
 	if err != nil {
 		pf.log.WithError(err).Warnf("fetching Prometheus metrics: %s (%s)", t.URL.String(), t.Object.Name)
 		fetchErrorsTotalMetric.WithLabelValues(t.Name).Set(1)
@@ -250,40 +893,112 @@ func (pf *prometheusFetcher) fetch(t endpoints.Target) (prometheus.MetricFamilie
 	return mfs, err
 }
 
+// observeDuration updates the cost of the target in the scrape budget, and
+// its latency SLO, if any.
+func (pf *prometheusFetcher) observeDuration(t endpoints.Target, d time.Duration) {
+	if pf.scheduler != nil {
+		pf.scheduler.observe(t.Name, d)
+	}
+	if pf.latencySLO != nil {
+		pf.latencySLO.observe(t, d)
+	}
+}
+
+// isMutualTLSTarget returns true if the target is authenticated with its own
+// client certificate.
 func isMutualTLSTarget(t endpoints.Target) bool {
-	// If any of these is present it means we're looking at an mTLS-enabled target.
-	// These targets need their own HTTP client because of very unique and different TLS
-	// configuration.
-	return t.TLSConfig != endpoints.TLSConfig{}
+	return t.TLSConfig.CertFilePath != "" || t.TLSConfig.KeyFilePath != ""
+}
+
+// targetTLSConfig returns the TLS configuration of the integration with the
+// options of the target applied on top: its CA bundle, client certificate,
+// TLS versions, cipher suites, server name and skipping the verification.
+func (pf *prometheusFetcher) targetTLSConfig(t endpoints.Target) (*tls.Config, error) {
+	base, err := pf.baseTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := t.TLSConfig
+	tlsConfig := base.Clone()
+	baseFiles, verified := verifyingFiles(base)
+	if cfg.CaFilePath != "" {
+		// The CA bundle of the target replaces the one of the integration.
+		tlsConfig.RootCAs = nil
+		tlsConfig.InsecureSkipVerify = false
+		verified = false
+	}
+	if cfg.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+		verified = false
+	}
+	if verified {
+		// The clone is verified with the current CA bundle of the
+		// integration too.
+		verifiedConfigs.Store(tlsConfig, baseFiles)
+	}
+	minVersion, err := cfg.TLSMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	if minVersion != 0 {
+		tlsConfig.MinVersion = minVersion
+	}
+	cipherSuites, err := cfg.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherSuites) > 0 {
+		tlsConfig.CipherSuites = cipherSuites
+	}
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	if cfg.CaFilePath != "" || isMutualTLSTarget(t) {
+		files, err := newCertificateFiles(cfg.CaFilePath, cfg.CertFilePath, cfg.KeyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		files.configure(tlsConfig)
+	}
+	return tlsConfig, nil
 }
 
 // NewMutualTLSRoundTripper creates a new roundtripper with the specified Mutual TLS
 // configuration.
 func NewMutualTLSRoundTripper(cfg endpoints.TLSConfig) (http.RoundTripper, error) {
-	// Load our TLS key pair to use for authentication
-	cert, err := tls.LoadX509KeyPair(cfg.CertFilePath, cfg.KeyFilePath)
+	tlsConfig, err := newMutualTLSConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
+	return newDefaultRoundTripper(tlsConfig), nil
+}
 
-	// Load our CA certificate
-	clientCACert, err := ioutil.ReadFile(cfg.CaFilePath)
+// newMutualTLSConfig returns the TLS configuration of a target with its own
+// certificates, which are reloaded when rotated.
+func newMutualTLSConfig(cfg endpoints.TLSConfig) (*tls.Config, error) {
+	minVersion, err := cfg.TLSMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cfg.CipherSuiteIDs()
 	if err != nil {
 		return nil, err
 	}
-
-	clientCertPool := x509.NewCertPool()
-	clientCertPool.AppendCertsFromPEM(clientCACert)
-
 	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
-		RootCAs:            clientCertPool,
 		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         minVersion,
+		CipherSuites:       cipherSuites,
+		ServerName:         cfg.ServerName,
 	}
-	tlsConfig.BuildNameToCertificate()
 
-	rt := newDefaultRoundTripper(tlsConfig)
-	return rt, nil
+	// Load our TLS key pair to use for authentication and our CA certificate.
+	// They are reloaded when rotated.
+	files, err := newCertificateFiles(cfg.CaFilePath, cfg.CertFilePath, cfg.KeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	files.configure(tlsConfig)
+	return tlsConfig, nil
 }
 
 type metricValue interface{}
@@ -304,6 +1019,14 @@ type Metric struct {
 	value      metricValue
 	metricType metricType
 	attributes labels.Set
+	// timestamp reported by the target, zero if there isn't any.
+	timestamp time.Time
+	// omitTimestamp is true for the gauges emitted without a timestamp, so
+	// they take the time they are received at.
+	omitTimestamp bool
+	// fingerprint identifies the series of the metric. It's zero until the
+	// metric is parsed or processed. See Fingerprint.
+	fingerprint uint64
 }
 
 var supportedMetricTypes = map[io_prometheus_client.MetricType]string{
@@ -312,6 +1035,8 @@ var supportedMetricTypes = map[io_prometheus_client.MetricType]string{
 	io_prometheus_client.MetricType_HISTOGRAM: "histogram",
 	io_prometheus_client.MetricType_SUMMARY:   "summary",
 	io_prometheus_client.MetricType_UNTYPED:   "untyped",
+	prometheus.MetricTypeInfo:                 "info",
+	prometheus.MetricTypeStateset:             "stateset",
 }
 
 func convertPromMetrics(log *logrus.Entry, targetName string, mfs prometheus.MetricFamiliesByName) []Metric {
@@ -345,7 +1070,7 @@ func convertPromMetrics(log *logrus.Entry, targetName string, mfs prometheus.Met
 			case io_prometheus_client.MetricType_COUNTER:
 				value = m.GetCounter().GetValue()
 				nrType = metricType_COUNTER
-			case io_prometheus_client.MetricType_GAUGE:
+			case io_prometheus_client.MetricType_GAUGE, prometheus.MetricTypeInfo, prometheus.MetricTypeStateset:
 				value = m.GetGauge().GetValue()
 				nrType = metricType_GAUGE
 			case io_prometheus_client.MetricType_SUMMARY:
@@ -367,6 +1092,10 @@ func convertPromMetrics(log *logrus.Entry, targetName string, mfs prometheus.Met
 			}
 			attrs["nrMetricType"] = string(nrType)
 			attrs["promMetricType"] = mtype
+			var timestamp time.Time
+			if m.TimestampMs != nil {
+				timestamp = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+			}
 			metrics = append(
 				metrics,
 				Metric{
@@ -374,6 +1103,7 @@ func convertPromMetrics(log *logrus.Entry, targetName string, mfs prometheus.Met
 					metricType: nrType,
 					value:      value,
 					attributes: attrs,
+					timestamp:  timestamp,
 				},
 			)
 		}
@@ -381,6 +1111,55 @@ func convertPromMetrics(log *logrus.Entry, targetName string, mfs prometheus.Met
 	return metrics
 }
 
+// NewGaugeMetric returns a gauge with the given name, value and attributes.
+func NewGaugeMetric(name string, value float64, attributes labels.Set) Metric {
+	return Metric{name: name, value: value, metricType: metricType_GAUGE, attributes: attributes}
+}
+
+// NewCountMetric returns a counter with the given name, cumulative value and
+// attributes.
+func NewCountMetric(name string, value float64, attributes labels.Set) Metric {
+	return Metric{name: name, value: value, metricType: metricType_COUNTER, attributes: attributes}
+}
+
+// ParseMetrics decodes a payload in the Prometheus text format into the
+// metrics of the target with the given name.
+func ParseMetrics(r io.Reader, targetName string) ([]Metric, error) {
+	mfs, err := prometheus.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return convertPromMetrics(logrus.WithField("component", "Parser"), targetName, mfs), nil
+}
+
+// Name returns the name of the metric.
+func (m Metric) Name() string {
+	return m.name
+}
+
+// Type returns the New Relic type of the metric: count, gauge, summary or
+// histogram.
+func (m Metric) Type() string {
+	return string(m.metricType)
+}
+
+// Value returns the value of the metric: a float64 for counts and gauges, a
+// *dto.Summary for summaries and a *dto.Histogram for histograms.
+func (m Metric) Value() interface{} {
+	return m.value
+}
+
+// Attributes returns the attributes of the metric.
+func (m Metric) Attributes() labels.Set {
+	return m.attributes
+}
+
+// Timestamp returns the timestamp reported by the target, zero if there isn't
+// any.
+func (m Metric) Timestamp() time.Time {
+	return m.timestamp
+}
+
 // MarshalJSON marshals a metric to json
 func (m *Metric) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
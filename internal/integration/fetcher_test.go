@@ -3,9 +3,20 @@
 package integration
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -40,7 +51,7 @@ func TestFetcher(t *testing.T) {
 
 	// When it fetches data synchronously
 	addr := url.URL{Scheme: "http", Path: "hello/metrics"}
-	pairsCh := fetcher.Fetch([]endpoints.Target{endpoints.New("", addr, endpoints.Object{})})
+	pairsCh := fetcher.Fetch(context.Background(), []endpoints.Target{endpoints.New("", addr, endpoints.Object{})})
 
 	var pair TargetMetrics
 	select {
@@ -76,7 +87,7 @@ func TestFetcher_Error(t *testing.T) {
 
 	fail := url.URL{Scheme: "http", Path: "fail/metrics"}
 	hello := url.URL{Scheme: "http", Path: "hello/metrics"}
-	pairsCh := fetcher.Fetch([]endpoints.Target{
+	pairsCh := fetcher.Fetch(context.Background(), []endpoints.Target{
 		endpoints.New("", fail, endpoints.Object{}),
 		endpoints.New("", hello, endpoints.Object{}),
 	})
@@ -101,6 +112,162 @@ func TestFetcher_Error(t *testing.T) {
 	assert.Equal(t, "http://hello/metrics", invokedURLs[0])
 }
 
+func TestFetcher_LenientParsing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\nup{job=\"a\" 1\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	targets := []endpoints.Target{endpoints.New("target", *addr, endpoints.Object{})}
+
+	strict := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength)
+	var pairs []TargetMetrics
+	for pair := range strict.Fetch(context.Background(), targets) {
+		pairs = append(pairs, pair)
+	}
+	assert.Empty(t, pairs, "the scrape fails in strict mode")
+
+	lenient := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithLenientParsing(1))
+	for pair := range lenient.Fetch(context.Background(), targets) {
+		pairs = append(pairs, pair)
+	}
+	require.Len(t, pairs, 1)
+	require.Len(t, pairs[0].Metrics, 1)
+	assert.Equal(t, "up", pairs[0].Metrics[0].name)
+}
+
+func TestFetcher_Headers(t *testing.T) {
+	received := make(chan http.Header, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	targets := []endpoints.Target{endpoints.New("target", *addr, endpoints.Object{})}
+
+	headers := http.Header{}
+	headers.Set("User-Agent", "nri-prometheus/2.0.0")
+	headers.Set("X-Scraped-By", "nri-prometheus; instance=a")
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithHeaders(headers))
+	for range fetcher.Fetch(context.Background(), targets) {
+	}
+	header := <-received
+	assert.Equal(t, "nri-prometheus/2.0.0", header.Get("User-Agent"))
+	assert.Equal(t, "nri-prometheus; instance=a", header.Get("X-Scraped-By"))
+}
+
+func TestFetcher_TargetOptions(t *testing.T) {
+	received := make(chan *http.Request, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "target-options")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	target := endpoints.New("target", *addr, endpoints.Object{})
+	target.Headers = map[string]string{"X-Scraped-By": "tenant-a", "X-Tenant": "a"}
+	target.Auth = endpoints.TargetAuthConfig{Username: "scraper", PasswordFile: passwordFile}
+	target.ScrapeTimeout = time.Second
+
+	headers := http.Header{}
+	headers.Set("X-Scraped-By", "nri-prometheus")
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithHeaders(headers))
+	for range fetcher.Fetch(context.Background(), []endpoints.Target{target}) {
+	}
+	r := <-received
+	// The headers of the target replace the ones of the fetcher.
+	assert.Equal(t, "tenant-a", r.Header.Get("X-Scraped-By"))
+	assert.Equal(t, "a", r.Header.Get("X-Tenant"))
+	username, password, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "scraper", username)
+	assert.Equal(t, "secret", password)
+}
+
+func TestFetcher_ContentTypeCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html><body>Login</body></html>"))
+		case "/openmetrics":
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n# EOF\n"))
+		default:
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+		}
+	}))
+	defer ts.Close()
+
+	var targets []endpoints.Target
+	for _, path := range []string{"/html", "/openmetrics", "/metrics"} {
+		addr, err := url.Parse(ts.URL + path)
+		require.NoError(t, err)
+		targets = append(targets, endpoints.New(path, *addr, endpoints.Object{}))
+	}
+
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithContentTypeCheck())
+	var scraped []string
+	for pair := range fetcher.Fetch(context.Background(), targets) {
+		scraped = append(scraped, pair.Target.Name)
+	}
+	assert.ElementsMatch(t, []string{"/openmetrics", "/metrics"}, scraped, "the HTML response is rejected")
+}
+
+func TestFetcher_MaxPayloadSize(t *testing.T) {
+	small := "# TYPE up gauge\nup 1\n"
+	large := small + strings.Repeat("# padding\n", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/declared":
+			w.Header().Set("Content-Length", fmt.Sprint(len(large)))
+			_, _ = w.Write([]byte(large))
+		case "/chunked":
+			// Flushing before writing the payload omits its length.
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(large))
+		default:
+			_, _ = w.Write([]byte(small))
+		}
+	}))
+	defer ts.Close()
+
+	var targets []endpoints.Target
+	for _, path := range []string{"/declared", "/chunked", "/metrics"} {
+		addr, err := url.Parse(ts.URL + path)
+		require.NoError(t, err)
+		targets = append(targets, endpoints.New(path, *addr, endpoints.Object{}))
+	}
+
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithMaxPayloadSize(int64(len(small))))
+	var scraped []string
+	for pair := range fetcher.Fetch(context.Background(), targets) {
+		scraped = append(scraped, pair.Target.Name)
+	}
+	assert.Equal(t, []string{"/metrics"}, scraped, "the larger payloads are rejected")
+}
+
+func TestIsExpositionContentType(t *testing.T) {
+	assert.True(t, isExpositionContentType(""))
+	assert.True(t, isExpositionContentType("text/plain; version=0.0.4; charset=utf-8"))
+	assert.True(t, isExpositionContentType("application/openmetrics-text; version=1.0.0"))
+	assert.False(t, isExpositionContentType("text/html"))
+	assert.False(t, isExpositionContentType("application/json"))
+	assert.False(t, isExpositionContentType("not a media type;"))
+}
+
 func TestFetcher_ConcurrencyLimit(t *testing.T) {
 	// This test fetches a lot of targets and verifies that no more than "workerThreads" are executed in
 	// parallel
@@ -124,7 +291,7 @@ func TestFetcher_ConcurrencyLimit(t *testing.T) {
 		addr := url.URL{Scheme: "http", Host: fmt.Sprintf("target%v", i), Path: "/metrics"}
 		targets = append(targets, endpoints.New("", addr, endpoints.Object{}))
 	}
-	fetcher.Fetch(targets)
+	fetcher.Fetch(context.Background(), targets)
 
 	maxParallel := 0
 	timeout := time.After(5 * time.Second)
@@ -143,6 +310,407 @@ func TestFetcher_ConcurrencyLimit(t *testing.T) {
 		"no more nor less than %v connections should run in parallel. Actually %v", workerThreads, maxParallel)
 }
 
+func TestFetcher_Cancel(t *testing.T) {
+	// Given a fetcher that releases a target every 500ms
+	fetcher := NewFetcher(3*time.Second, fetchTimeout, workerThreads, "", "", true, queueLength)
+	var fetched int32
+	fetcher.(*prometheusFetcher).getMetrics = func(client prometheus.HTTPDoer, url string) (names prometheus.MetricFamiliesByName, e error) {
+		atomic.AddInt32(&fetched, 1)
+		return prometheus.MetricFamiliesByName{
+			"some-name": dto.MetricFamily{},
+		}, nil
+	}
+
+	var targets []endpoints.Target
+	for i := 0; i < 3; i++ {
+		targets = append(targets, endpoints.New("", url.URL{Scheme: "http", Path: fmt.Sprintf("target%d/metrics", i)}, endpoints.Object{}))
+	}
+
+	// When the fetch is cancelled after the first target is released
+	ctx, cancel := context.WithCancel(context.Background())
+	pairsCh := fetcher.Fetch(ctx, targets)
+	select {
+	case <-pairsCh:
+	case <-time.After(fetchTimeout):
+		t.Fatal("can't fetch data")
+	}
+	cancel()
+
+	// Then the results channel is closed without fetching the remaining targets
+	select {
+	case _, ok := <-pairsCh:
+		assert.False(t, ok)
+	case <-time.After(fetchTimeout):
+		t.Fatal("the results channel wasn't closed")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+}
+
+func TestFetcher_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	q, err := NewSpillQueue(dir, 0)
+	require.NoError(t, err)
+
+	// Given a fetcher that stores the payloads on disk
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithSpillQueue(q))
+	fetcher.(*prometheusFetcher).getPayload = func(client prometheus.HTTPDoer, url string, w io.Writer) error {
+		if strings.Contains(url, "fail") {
+			return errors.New("catapun")
+		}
+		_, err := io.WriteString(w, "# TYPE some_gauge gauge\nsome_gauge 1\n")
+		return err
+	}
+
+	// When it fetches data from many targets
+	var targets []endpoints.Target
+	for _, path := range []string{"a/metrics", "fail/metrics", "b/metrics"} {
+		targets = append(targets, endpoints.New(path, url.URL{Scheme: "http", Path: path}, endpoints.Object{}))
+	}
+	var names []string
+	for pair := range fetcher.Fetch(context.Background(), targets) {
+		require.Len(t, pair.Metrics, 1)
+		assert.Equal(t, "some_gauge", pair.Metrics[0].name)
+		names = append(names, pair.Target.Name)
+	}
+
+	// Then the decoded metrics of the successful targets are submitted
+	assert.ElementsMatch(t, []string{"a/metrics", "b/metrics"}, names)
+
+	// and no payload is left on disk
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestHTTPClientConfigTransport(t *testing.T) {
+	tr := HTTPClientConfig{}.transport(nil).(*http.Transport)
+	assert.Equal(t, 1000, tr.MaxIdleConnsPerHost)
+	assert.False(t, tr.DisableKeepAlives)
+	assert.False(t, tr.ForceAttemptHTTP2)
+	assert.Nil(t, tr.TLSNextProto)
+
+	tr = HTTPClientConfig{
+		MaxIdleConnsPerHost: 10,
+		MaxConnsPerHost:     20,
+		TLSHandshakeTimeout: time.Second,
+		DisableKeepAlives:   true,
+		HTTPVersion:         HTTPVersion1,
+	}.transport(nil).(*http.Transport)
+	assert.Equal(t, 10, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 20, tr.MaxConnsPerHost)
+	assert.Equal(t, time.Second, tr.TLSHandshakeTimeout)
+	assert.True(t, tr.DisableKeepAlives)
+	assert.NotNil(t, tr.TLSNextProto)
+	assert.Empty(t, tr.TLSNextProto)
+
+	tr = HTTPClientConfig{HTTPVersion: HTTPVersion2}.transport(nil).(*http.Transport)
+	assert.True(t, tr.ForceAttemptHTTP2)
+}
+
+func TestNewMutualTLSRoundTripper(t *testing.T) {
+	rt, err := NewMutualTLSRoundTripper(endpoints.TLSConfig{
+		InsecureSkipVerify: true,
+		MinVersion:         "1.2",
+		CipherSuites:       []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		ServerName:         "exporter.internal",
+	})
+	require.NoError(t, err)
+
+	tlsConfig := rt.(*http.Transport).TLSClientConfig
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, "exporter.internal", tlsConfig.ServerName)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Empty(t, tlsConfig.Certificates)
+
+	_, err = NewMutualTLSRoundTripper(endpoints.TLSConfig{MinVersion: "1.4"})
+	assert.Error(t, err)
+}
+
+func TestFetcher_InsecureTarget(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("some_metric 1\n"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/metrics")
+	require.NoError(t, err)
+
+	fetch := func(tlsConfig endpoints.TLSConfig) []TargetMetrics {
+		fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", false, queueLength)
+		target := endpoints.New("insecure", *u, endpoints.Object{})
+		target.TLSConfig = tlsConfig
+		var results []TargetMetrics
+		for r := range fetcher.Fetch(context.Background(), []endpoints.Target{target}) {
+			results = append(results, r)
+		}
+		return results
+	}
+
+	// The certificate of the test server is not trusted.
+	assert.Empty(t, fetch(endpoints.TLSConfig{}))
+	assert.Len(t, fetch(endpoints.TLSConfig{InsecureSkipVerify: true}), 1)
+}
+
+func TestHTTPClientConfigValidate(t *testing.T) {
+	assert.NoError(t, HTTPClientConfig{HTTPVersion: "2"}.Validate())
+	assert.Error(t, HTTPClientConfig{HTTPVersion: "3"}.Validate())
+	assert.Error(t, HTTPClientConfig{MaxConnsPerHost: -1}.Validate())
+	assert.NoError(t, HTTPClientConfig{PreferIPProtocol: endpoints.IPv6}.Validate())
+	assert.Error(t, HTTPClientConfig{PreferIPProtocol: "ipv5"}.Validate())
+}
+
+func TestSortByIPProtocol(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("fd00::1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("fd00::2")},
+	}
+	sortByIPProtocol(ips, endpoints.IPv6)
+	var sorted []string
+	for _, ip := range ips {
+		sorted = append(sorted, ip.String())
+	}
+	assert.Equal(t, []string{"fd00::1", "fd00::2", "10.0.0.1", "10.0.0.2"}, sorted)
+}
+
+func TestHTTPClientConfig_PreferIPProtocol(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("some_metric 1\n"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// localhost resolves to 127.0.0.1, and ::1 in some hosts.
+	client := &http.Client{Transport: HTTPClientConfig{PreferIPProtocol: endpoints.IPv4}.transport(nil)}
+	resp, err := client.Get("http://" + net.JoinHostPort("localhost", u.Port()) + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHTTPClientConfigMerge(t *testing.T) {
+	defaults := HTTPClientConfig{
+		MaxIdleConnsPerHost: 10,
+		TLSHandshakeTimeout: time.Second,
+		HTTPVersion:         HTTPVersion2,
+	}
+	merged := HTTPClientConfig{MaxIdleConnsPerHost: 5, DisableKeepAlives: true}.Merge(defaults)
+	assert.Equal(t, HTTPClientConfig{
+		MaxIdleConnsPerHost: 5,
+		TLSHandshakeTimeout: time.Second,
+		DisableKeepAlives:   true,
+		HTTPVersion:         HTTPVersion2,
+	}, merged)
+}
+
+func TestFetcher_RetrieverHTTPClient(t *testing.T) {
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength,
+		FetcherWithRetrieverHTTPClient("kubernetes", HTTPClientConfig{DisableKeepAlives: true}))
+	pf := fetcher.(*prometheusFetcher)
+
+	var clients []prometheus.HTTPDoer
+	var mtx sync.Mutex
+	pf.getMetrics = func(client prometheus.HTTPDoer, url string) (prometheus.MetricFamiliesByName, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		clients = append(clients, client)
+		return prometheus.MetricFamiliesByName{}, nil
+	}
+
+	target := endpoints.New("", url.URL{Scheme: "http", Path: "hello/metrics"}, endpoints.Object{})
+	target.Retriever = "kubernetes"
+	for range fetcher.Fetch(context.Background(), []endpoints.Target{target}) {
+	}
+
+	require.Len(t, clients, 1)
+	assert.NotEqual(t, pf.httpClient, clients[0])
+	tr := clients[0].(*http.Client).Transport.(*http.Transport)
+	assert.True(t, tr.DisableKeepAlives)
+}
+
+func TestFetcher_TargetClientsKeepHTTPClientConfig(t *testing.T) {
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", false, queueLength,
+		FetcherWithHTTPClientConfig(HTTPClientConfig{MaxConnsPerHost: 7, PreferIPProtocol: endpoints.IPv4}),
+		FetcherWithRetrieverHTTPClient("kubernetes", HTTPClientConfig{MaxConnsPerHost: 3, DisableKeepAlives: true}))
+	pf := fetcher.(*prometheusFetcher)
+
+	transport := func(target endpoints.Target) (*http.Client, *http.Transport) {
+		c, err := pf.client(target)
+		require.NoError(t, err)
+		client := c.(*http.Client)
+		return client, client.Transport.(*http.Transport)
+	}
+
+	target := endpoints.New("static", url.URL{Scheme: "http", Host: "exporter:9100"}, endpoints.Object{})
+	target.ScrapeTimeout = time.Second
+	client, tr := transport(target)
+	assert.Equal(t, time.Second, client.Timeout)
+	assert.Equal(t, 7, tr.MaxConnsPerHost)
+	assert.NotNil(t, tr.DialContext, "the IP protocol is preferred")
+
+	target.Retriever = "kubernetes/other"
+	_, tr = transport(target)
+	assert.Equal(t, 3, tr.MaxConnsPerHost)
+	assert.True(t, tr.DisableKeepAlives)
+}
+
+func TestFetcher_TargetTLSConfigError(t *testing.T) {
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", false, queueLength)
+	pf := fetcher.(*prometheusFetcher)
+	var fetched int32
+	pf.getMetrics = func(client prometheus.HTTPDoer, url string) (prometheus.MetricFamiliesByName, error) {
+		atomic.AddInt32(&fetched, 1)
+		return prometheus.MetricFamiliesByName{}, nil
+	}
+
+	target := endpoints.New("mtls", url.URL{Scheme: "https", Host: "exporter:9100"}, endpoints.Object{})
+	target.TLSConfig = endpoints.TLSConfig{CertFilePath: "missing.crt", KeyFilePath: "missing.key"}
+	_, err := pf.client(target)
+	assert.Error(t, err)
+
+	for range fetcher.Fetch(context.Background(), []endpoints.Target{target}) {
+	}
+	assert.Zero(t, atomic.LoadInt32(&fetched), "the target isn't scraped without its TLS configuration")
+}
+
+func TestFetcher_TargetTLSOptions(t *testing.T) {
+	roots := x509.NewCertPool()
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "token", "", false, queueLength,
+		FetcherWithTLSConfig(&tls.Config{RootCAs: roots}),
+		FetcherWithHTTPClientConfig(HTTPClientConfig{MaxConnsPerHost: 7}))
+	pf := fetcher.(*prometheusFetcher)
+
+	target := endpoints.New("tls", url.URL{Scheme: "https", Host: "exporter:9100"}, endpoints.Object{})
+	target.TLSConfig = endpoints.TLSConfig{InsecureSkipVerify: true, MinVersion: "1.2", ServerName: "exporter.internal"}
+	assert.False(t, isMutualTLSTarget(target))
+
+	c, err := pf.client(target)
+	require.NoError(t, err)
+	rt, ok := c.(*http.Client).Transport.(*bearerAuthFileRoundTripper)
+	require.True(t, ok, "the bearer token is kept for the targets without a client certificate")
+	tr := rt.rt.(*http.Transport)
+	assert.Equal(t, 7, tr.MaxConnsPerHost)
+	assert.Same(t, roots, tr.TLSClientConfig.RootCAs)
+	assert.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, uint16(tls.VersionTLS12), tr.TLSClientConfig.MinVersion)
+	assert.Equal(t, "exporter.internal", tr.TLSClientConfig.ServerName)
+	assert.Empty(t, pf.tlsConfig.ServerName, "the TLS configuration of the integration isn't modified")
+}
+
+func TestFetcher_NetworkPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("some_metric 1\n"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	get := func(policyCfg endpoints.NetworkPolicyConfig, rawURL string) error {
+		policy, err := policyCfg.Policy()
+		require.NoError(t, err)
+		fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", false, queueLength)
+		target := endpoints.New("discovered", url.URL{Scheme: "http", Host: u.Host}, endpoints.Object{})
+		target.NetworkPolicy = policy
+		c, err := fetcher.(*prometheusFetcher).client(target)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	loopback := endpoints.NetworkPolicyConfig{Allow: []string{"127.0.0.0/8", "::1/128"}}
+	local := "http://" + net.JoinHostPort("localhost", u.Port())
+
+	assert.NoError(t, get(loopback, local+"/metrics"))
+
+	err = get(loopback, srv.URL+"/redirect")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "denied network", "the redirects are checked")
+
+	// The address connected to is checked, whatever the host resolved to
+	// when the target was discovered.
+	err = get(endpoints.NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}}, local+"/metrics")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in an allowed network")
+}
+
+type fakeFetcher struct {
+	fetched []endpoints.Target
+}
+
+func (f *fakeFetcher) Fetch(_ context.Context, t []endpoints.Target) <-chan TargetMetrics {
+	f.fetched = t
+	ch := make(chan TargetMetrics)
+	close(ch)
+	return ch
+}
+
+type fakePressure bool
+
+func (p fakePressure) UnderPressure() bool {
+	return bool(p)
+}
+
+func TestSheddingFetcher(t *testing.T) {
+	targets := []endpoints.Target{
+		{Name: "normal"},
+		{Name: "low", LowPriority: true},
+	}
+
+	inner := &fakeFetcher{}
+	NewSheddingFetcher(inner, fakePressure(false)).Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 2)
+
+	NewSheddingFetcher(inner, fakePressure(true)).Fetch(context.Background(), targets)
+	require.Len(t, inner.fetched, 1)
+	assert.Equal(t, "normal", inner.fetched[0].Name)
+}
+
+func TestLimitingFetcher(t *testing.T) {
+	target := func(rawURL string, low bool) endpoints.Target {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return endpoints.Target{Name: rawURL, URL: *u, LowPriority: low}
+	}
+	targets := []endpoints.Target{
+		target("http://c:8080/metrics", false),
+		target("http://a:8080/metrics", true),
+		target("http://b:8080/metrics", false),
+	}
+	names := func(targets []endpoints.Target) []string {
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		return names
+	}
+
+	inner := &fakeFetcher{}
+	NewLimitingFetcher(inner, 3, MaxTargetsFail).Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 3)
+
+	NewLimitingFetcher(inner, 2, MaxTargetsAlphabetical).Fetch(context.Background(), targets)
+	assert.Equal(t, []string{"http://a:8080/metrics", "http://b:8080/metrics"}, names(inner.fetched))
+
+	NewLimitingFetcher(inner, 2, MaxTargetsPriority).Fetch(context.Background(), targets)
+	assert.Equal(t, []string{"http://b:8080/metrics", "http://c:8080/metrics"}, names(inner.fetched))
+
+	NewLimitingFetcher(inner, 2, MaxTargetsFail).Fetch(context.Background(), targets)
+	assert.Empty(t, inner.fetched)
+}
+
 func TestConvertPromMetrics(t *testing.T) {
 	tests := []struct {
 		target string
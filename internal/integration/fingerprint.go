@@ -0,0 +1,24 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import "github.com/newrelic/nri-prometheus/internal/pkg/labels"
+
+// Fingerprint returns the hash identifying the series of the metric by its
+// name and attributes. It's computed once the metrics are parsed, and again
+// once the rules change their names and attributes, so the tracking of the
+// series doesn't compare or encode the attributes for each one.
+func (m Metric) Fingerprint() uint64 {
+	if m.fingerprint != 0 {
+		return m.fingerprint
+	}
+	return labels.Fingerprint(m.name, m.attributes)
+}
+
+// fingerprintMetrics computes the fingerprints of the metrics, after their
+// names or attributes change.
+func fingerprintMetrics(metrics []Metric) {
+	for i := range metrics {
+		metrics[i].fingerprint = labels.Fingerprint(metrics[i].name, metrics[i].attributes)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestMetric_Fingerprint(t *testing.T) {
+	metrics, err := ParseMetrics(strings.NewReader("requests_total{code=\"200\"} 1\nrequests_total{code=\"500\"} 2\n"), "web")
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	fingerprintMetrics(metrics)
+	assert.NotEqual(t, metrics[0].Fingerprint(), metrics[1].Fingerprint())
+	for _, m := range metrics {
+		assert.Equal(t, labels.Fingerprint(m.name, m.attributes), m.Fingerprint())
+	}
+
+	// The rules change the identity of the series.
+	before := metrics[0].Fingerprint()
+	pair := TargetMetrics{Target: endpoints.Target{Name: "web"}, Metrics: metrics}
+	NewRuleSet([]ProcessingRule{{
+		AddAttributes: []AddAttributesRule{{MetricPrefix: "requests", Attributes: map[string]interface{}{"team": "a"}}},
+	}}).Apply(&pair)
+	assert.NotEqual(t, before, pair.Metrics[0].Fingerprint())
+	assert.Equal(t, labels.Fingerprint(pair.Metrics[0].name, pair.Metrics[0].attributes), pair.Metrics[0].fingerprint)
+}
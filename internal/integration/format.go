@@ -0,0 +1,40 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"net/http"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// formatDoer converts the scrape responses from the format forced for the
+// target to the Prometheus text format, whatever their Content-Type.
+type formatDoer struct {
+	inner  prometheus.HTTPDoer
+	format prometheus.Format
+}
+
+// Do does the request and converts the body of the successful responses.
+// Their Content-Type is replaced by the one of the text format, so it's
+// neither detected again nor rejected.
+func (d formatDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.inner.Do(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 300 {
+		return resp, err
+	}
+	if err := prometheus.TranscodeResponse(resp, d.format); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// withFormat returns the client converting the responses of the target from
+// its format, if it's forced.
+func withFormat(t endpoints.Target, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if t.Format == prometheus.FormatAuto {
+		return c
+	}
+	return formatDoer{inner: c, format: t.Format}
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func TestFetcher_TargetFormat(t *testing.T) {
+	// The appliance serves the line protocol as an HTML page.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("cpu,host=a usage_idle=90.5,usage_user=9.5\n"))
+	}))
+	defer srv.Close()
+
+	retriever, err := endpoints.FixedRetriever(endpoints.TargetConfig{
+		URLs: []endpoints.TargetURL{{URL: srv.URL, Format: prometheus.FormatInflux}},
+	})
+	require.NoError(t, err)
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithContentTypeCheck())
+	var pair TargetMetrics
+	select {
+	case pair = <-fetcher.Fetch(context.Background(), targets):
+	case <-time.After(fetchTimeout):
+		t.Fatal("can't fetch the metrics")
+	}
+
+	values := map[string]interface{}{}
+	for _, m := range pair.Metrics {
+		assert.Equal(t, "a", m.attributes["host"])
+		values[m.name] = m.value
+	}
+	assert.Equal(t, map[string]interface{}{"cpu_usage_idle": 90.5, "cpu_usage_user": 9.5}, values)
+}
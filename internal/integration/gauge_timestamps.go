@@ -0,0 +1,18 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import "time"
+
+// omitGaugeTimestamps makes the gauges be emitted without timestamps,
+// discarding the ones reported by the target, for the targets whose clocks
+// can't be trusted. The other metrics keep theirs, as their deltas are
+// computed from them.
+func omitGaugeTimestamps(metrics []Metric) {
+	for i := range metrics {
+		if metrics[i].metricType == metricType_GAUGE {
+			metrics[i].timestamp = time.Time{}
+			metrics[i].omitTimestamp = true
+		}
+	}
+}
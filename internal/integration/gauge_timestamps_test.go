@@ -0,0 +1,42 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOmitGaugeTimestamps(t *testing.T) {
+	reported := time.Now().Add(-5 * time.Minute)
+	metrics := []Metric{
+		{name: "gauge", metricType: metricType_GAUGE, value: 1.0, timestamp: reported},
+		{name: "counter", metricType: metricType_COUNTER, value: 1.0, timestamp: reported},
+	}
+	omitGaugeTimestamps(metrics)
+
+	assert.True(t, metrics[0].omitTimestamp)
+	assert.True(t, metrics[0].timestamp.IsZero())
+	assert.False(t, metrics[1].omitTimestamp)
+	assert.Equal(t, reported, metrics[1].timestamp)
+}
+
+func TestTelemetryEmitter_OmitGaugeTimestamps(t *testing.T) {
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{
+		harvester:       h,
+		deltaCalculator: newDeltaCalculator(),
+	}
+	require.NoError(t, te.Emit([]Metric{
+		{name: "omitted", metricType: metricType_GAUGE, value: 1.0, omitTimestamp: true},
+		{name: "kept", metricType: metricType_GAUGE, value: 1.0},
+	}))
+
+	require.Len(t, h.metrics, 2)
+	assert.True(t, h.metrics[0].(telemetry.Gauge).Timestamp.IsZero())
+	assert.False(t, h.metrics[1].(telemetry.Gauge).Timestamp.IsZero())
+}
@@ -27,10 +27,22 @@ func (ha harvesterDecorator) RecordMetric(m telemetry.Metric) {
 	}
 }
 
+func (ha harvesterDecorator) RecordEvent(e telemetry.Event) error {
+	return recordEvent(ha.innerHarvester, e)
+}
+
 func (ha harvesterDecorator) HarvestNow(ctx context.Context) {
 	ha.innerHarvester.HarvestNow(ctx)
 }
 
+func (ha harvesterDecorator) flush(ctx context.Context) {
+	if f, ok := ha.innerHarvester.(flusher); ok {
+		f.flush(ctx)
+		return
+	}
+	ha.innerHarvester.HarvestNow(ctx)
+}
+
 func (ha harvesterDecorator) processMetric(f float64, m telemetry.Metric) {
 	if math.IsNaN(f) {
 		logrus.Debugf("Ignoring NaN float value for metric: %v", m)
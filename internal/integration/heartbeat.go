@@ -0,0 +1,81 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+const (
+	defaultHeartbeatName     = "nri_prometheus.heartbeat"
+	defaultHeartbeatInterval = time.Minute
+)
+
+// HeartbeatConfig configures a gauge emitted periodically regardless of the
+// targets, so alerts can be set on the integration stopping silently.
+type HeartbeatConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Name of the metric. Defaults to nri_prometheus.heartbeat.
+	Name string `mapstructure:"name"`
+	// Interval between heartbeats. Defaults to 1m.
+	Interval time.Duration `mapstructure:"interval"`
+	// Attributes added to the heartbeat, e.g. the shard of the integration.
+	Attributes map[string]string `mapstructure:"attributes"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *HeartbeatConfig) Validate() error {
+	if c.Name == "" {
+		c.Name = defaultHeartbeatName
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultHeartbeatInterval
+	}
+	if c.Interval < 0 {
+		return errors.New("interval can't be negative")
+	}
+	return nil
+}
+
+// RunHeartbeat emits the heartbeat with the given attributes, plus the
+// configured ones, right away and then every interval until the context is
+// done.
+func RunHeartbeat(ctx context.Context, cfg HeartbeatConfig, attributes labels.Set, emitters []Emitter) {
+	heartbeat := heartbeatMetric(cfg, attributes)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		for _, e := range emitters {
+			if err := e.Emit([]Metric{heartbeat}); err != nil {
+				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting heartbeat")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func heartbeatMetric(cfg HeartbeatConfig, attributes labels.Set) Metric {
+	attrs := labels.Set{
+		"integrationName":    Name,
+		"integrationVersion": Version,
+	}
+	labels.Accumulate(attrs, attributes)
+	for k, v := range cfg.Attributes {
+		attrs[k] = v
+	}
+	return Metric{
+		name:       cfg.Name,
+		value:      float64(1),
+		metricType: metricType_GAUGE,
+		attributes: attrs,
+	}
+}
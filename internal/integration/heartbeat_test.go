@@ -0,0 +1,62 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+type recordingEmitter struct {
+	mtx     sync.Mutex
+	metrics []Metric
+}
+
+func (r *recordingEmitter) Name() string { return "recording" }
+
+func (r *recordingEmitter) Emit(metrics []Metric) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.metrics = append(r.metrics, metrics...)
+	return nil
+}
+
+func (r *recordingEmitter) emitted() []Metric {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]Metric(nil), r.metrics...)
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	cfg := HeartbeatConfig{Interval: 10 * time.Millisecond, Attributes: map[string]string{"shard": "1"}}
+	require.NoError(t, cfg.Validate())
+	emitter := &recordingEmitter{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunHeartbeat(ctx, cfg, labels.Set{"clusterName": "test"}, []Emitter{emitter})
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return len(emitter.emitted()) >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	heartbeat := emitter.emitted()[0]
+	assert.Equal(t, "nri_prometheus.heartbeat", heartbeat.name)
+	assert.Equal(t, 1.0, heartbeat.value)
+	assert.Equal(t, metricType_GAUGE, heartbeat.metricType)
+	assert.Equal(t, labels.Set{
+		"integrationName":    Name,
+		"integrationVersion": Version,
+		"clusterName":        "test",
+		"shard":              "1",
+	}, heartbeat.attributes)
+}
@@ -3,6 +3,7 @@
 package integration
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -47,7 +48,7 @@ func scrapeString(t *testing.T, inputMetrics string) TargetMetrics {
 	target, err := server.GetTargets()
 	require.NoError(t, err)
 
-	metricsCh := NewFetcher(time.Millisecond, 1*time.Second, workerThreads, "", "", true, queueLength).Fetch(target)
+	metricsCh := NewFetcher(time.Millisecond, 1*time.Second, workerThreads, "", "", true, queueLength).Fetch(context.Background(), target)
 
 	var pair TargetMetrics
 	select {
@@ -0,0 +1,90 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"math"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// HistogramBucketsRule reduces the buckets of the histograms whose name
+// matches MetricPrefix. As the bucket counts are cumulative, removing a bucket
+// merges its observations into the next one. The +Inf bucket is always kept.
+type HistogramBucketsRule struct {
+	MetricPrefix string `mapstructure:"metric_prefix"`
+	// Min drops the buckets with an upper bound (le) lower than it.
+	Min *float64 `mapstructure:"min"`
+	// Max drops the buckets with an upper bound (le) greater than it.
+	Max *float64 `mapstructure:"max"`
+	// Keep drops the buckets whose upper bound (le) is not in the list.
+	Keep []float64 `mapstructure:"keep"`
+	// Merge merges every given number of adjacent buckets into one, keeping
+	// the upper bound of the last of them.
+	Merge int `mapstructure:"merge"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// ReduceHistogramBuckets applies the rules to the histograms of the target
+// metrics. The first rule matching a histogram is applied.
+func ReduceHistogramBuckets(targetMetrics *TargetMetrics, rules []HistogramBucketsRule) {
+	if len(rules) == 0 {
+		return
+	}
+	for i, m := range targetMetrics.Metrics {
+		hist, ok := m.value.(*dto.Histogram)
+		if !ok {
+			continue
+		}
+		for _, rule := range rules {
+			if strings.HasPrefix(m.name, rule.MetricPrefix) {
+				targetMetrics.Metrics[i].value = rule.apply(hist)
+				break
+			}
+		}
+	}
+}
+
+// apply returns a copy of the histogram with the buckets reduced.
+func (r HistogramBucketsRule) apply(hist *dto.Histogram) *dto.Histogram {
+	reduced := *hist
+	reduced.Bucket = nil
+	var finite []*dto.Bucket
+	for _, b := range hist.GetBucket() {
+		le := b.GetUpperBound()
+		if math.IsInf(le, +1) {
+			continue
+		}
+		if (r.Min != nil && le < *r.Min) || (r.Max != nil && le > *r.Max) || !r.keeps(le) {
+			continue
+		}
+		finite = append(finite, b)
+	}
+	for i, b := range finite {
+		// The last bucket of every group of merged buckets is kept.
+		if r.Merge > 1 && (i+1)%r.Merge != 0 && i != len(finite)-1 {
+			continue
+		}
+		reduced.Bucket = append(reduced.Bucket, b)
+	}
+	for _, b := range hist.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			reduced.Bucket = append(reduced.Bucket, b)
+		}
+	}
+	return &reduced
+}
+
+func (r HistogramBucketsRule) keeps(le float64) bool {
+	if len(r.Keep) == 0 {
+		return true
+	}
+	for _, k := range r.Keep {
+		if k == le {
+			return true
+		}
+	}
+	return false
+}
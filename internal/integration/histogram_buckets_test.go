@@ -0,0 +1,57 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"math"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func testHistogram(bounds ...float64) *dto.Histogram {
+	hist := &dto.Histogram{}
+	for i, le := range bounds {
+		le := le
+		count := uint64(i + 1)
+		hist.Bucket = append(hist.Bucket, &dto.Bucket{UpperBound: &le, CumulativeCount: &count})
+	}
+	return hist
+}
+
+func bucketBounds(m Metric) []float64 {
+	var bounds []float64
+	for _, b := range m.value.(*dto.Histogram).GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+	}
+	return bounds
+}
+
+func TestReduceHistogramBuckets(t *testing.T) {
+	inf := math.Inf(+1)
+	min, max := 0.01, 1.0
+	rules := []HistogramBucketsRule{
+		{MetricPrefix: "range_", Min: &min, Max: &max},
+		{MetricPrefix: "keep_", Keep: []float64{0.1, 1}},
+		{MetricPrefix: "merge_", Merge: 2},
+	}
+	hist := testHistogram(0.005, 0.01, 0.1, 0.5, 1, 5, inf)
+	pair := TargetMetrics{Metrics: []Metric{
+		{name: "range_seconds", metricType: metricType_HISTOGRAM, value: hist},
+		{name: "keep_seconds", metricType: metricType_HISTOGRAM, value: hist},
+		{name: "merge_seconds", metricType: metricType_HISTOGRAM, value: hist},
+		{name: "other_seconds", metricType: metricType_HISTOGRAM, value: hist},
+	}}
+
+	ReduceHistogramBuckets(&pair, rules)
+
+	assert.Equal(t, []float64{0.01, 0.1, 0.5, 1, inf}, bucketBounds(pair.Metrics[0]))
+	assert.Equal(t, []float64{0.1, 1, inf}, bucketBounds(pair.Metrics[1]))
+	assert.Equal(t, []float64{0.01, 0.5, 5, inf}, bucketBounds(pair.Metrics[2]))
+	assert.Len(t, bucketBounds(pair.Metrics[3]), 7)
+	// The cumulative counts of the buckets kept don't change.
+	assert.Equal(t, uint64(3), pair.Metrics[1].value.(*dto.Histogram).GetBucket()[0].GetCumulativeCount())
+	// The scraped histogram is not modified.
+	assert.Len(t, hist.GetBucket(), 7)
+}
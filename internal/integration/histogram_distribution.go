@@ -0,0 +1,94 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Modes of emission of the histograms by the telemetry emitter.
+const (
+	// HistogramEmissionBuckets emits the histograms as the _sum, _count and a
+	// _bucket series per bucket, as they are exposed by Prometheus.
+	HistogramEmissionBuckets = "buckets"
+	// HistogramEmissionDistribution emits each histogram as a single summary
+	// with the bucket boundaries and the counts of each bucket in the
+	// histogram.boundaries and histogram.counts attributes.
+	HistogramEmissionDistribution = "distribution"
+)
+
+// Attributes of the histograms emitted as a distribution.
+const (
+	histogramBoundariesAttribute = "histogram.boundaries"
+	histogramCountsAttribute     = "histogram.counts"
+)
+
+// ValidateHistogramEmission returns an error if the mode of emission of the
+// histograms isn't known. An empty mode is the same as HistogramEmissionBuckets.
+func ValidateHistogramEmission(mode string) error {
+	switch mode {
+	case "", HistogramEmissionBuckets, HistogramEmissionDistribution:
+		return nil
+	}
+	return fmt.Errorf("unknown histogram emission mode %q, it must be %s or %s",
+		mode, HistogramEmissionBuckets, HistogramEmissionDistribution)
+}
+
+// emitDistribution records the histogram as a summary with the increases of
+// the sum and count of its observations, and of the observations of each
+// bucket, since the previous scrape. The counts of the buckets aren't
+// cumulative, so they can be rendered as a heatmap directly. Nothing is
+// recorded the first time the histogram is scraped.
+func (te *TelemetryEmitter) emitDistribution(metric Metric, hist *dto.Histogram, timestamp time.Time) {
+	sum, sumOK := te.deltaCalculator.CountMetric(metric.name+"_sum", metric.attributes, hist.GetSampleSum(), timestamp)
+	count, countOK := te.deltaCalculator.CountMetric(metric.name+"_count", metric.attributes, float64(hist.GetSampleCount()), timestamp)
+
+	// The deltas of all the buckets are calculated, even if some aren't
+	// available, so they are in the following scrapes.
+	buckets := hist.GetBucket()
+	boundaries := make([]string, 0, len(buckets))
+	counts := make([]string, 0, len(buckets))
+	bucketsOK := true
+	previous := 0.0
+	for _, b := range buckets {
+		bucketAttrs := copyAttrs(metric.attributes)
+		bucketAttrs["le"] = fmt.Sprintf("%g", b.GetUpperBound())
+		cumulative, ok := te.deltaCalculator.CountMetric(
+			metric.name+"_bucket",
+			bucketAttrs,
+			float64(b.GetCumulativeCount()),
+			timestamp,
+		)
+		if !ok {
+			bucketsOK = false
+			continue
+		}
+		boundaries = append(boundaries, strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64))
+		counts = append(counts, strconv.FormatFloat(cumulative.Value-previous, 'g', -1, 64))
+		previous = cumulative.Value
+	}
+	if !sumOK || !countOK || !bucketsOK {
+		return
+	}
+
+	attrs := copyAttrs(metric.attributes)
+	attrs[histogramBoundariesAttribute] = strings.Join(boundaries, ",")
+	attrs[histogramCountsAttribute] = strings.Join(counts, ",")
+	te.harvester.RecordMetric(telemetry.Summary{
+		Name:       metric.name,
+		Attributes: attrs,
+		Count:      count.Value,
+		Sum:        sum.Value,
+		Min:        math.NaN(),
+		Max:        math.NaN(),
+		Timestamp:  timestamp,
+		Interval:   count.Interval,
+	})
+}
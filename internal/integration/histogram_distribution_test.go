@@ -0,0 +1,60 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestValidateHistogramEmission(t *testing.T) {
+	assert.NoError(t, ValidateHistogramEmission(""))
+	assert.NoError(t, ValidateHistogramEmission(HistogramEmissionBuckets))
+	assert.NoError(t, ValidateHistogramEmission(HistogramEmissionDistribution))
+	assert.Error(t, ValidateHistogramEmission("heatmap"))
+}
+
+func TestTelemetryEmitter_HistogramDistribution(t *testing.T) {
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{
+		harvester:       h,
+		deltaCalculator: newDeltaCalculator(),
+		distribution:    true,
+	}
+
+	hist, err := newHistogram([]int64{1, 2, 4})
+	require.NoError(t, err)
+	metric := Metric{
+		name:       "request_duration_seconds",
+		metricType: metricType_HISTOGRAM,
+		value:      hist,
+		attributes: labels.Set{"targetName": "a"},
+	}
+	require.NoError(t, te.Emit([]Metric{metric}))
+	assert.Empty(t, h.metrics, "nothing is recorded until there are deltas")
+
+	// The delta calculator requires increasing timestamps.
+	time.Sleep(time.Millisecond)
+	hist2, err := newHistogram([]int64{3, 7, 10})
+	require.NoError(t, err)
+	*hist = *hist2
+	require.NoError(t, te.Emit([]Metric{metric}))
+
+	require.Len(t, h.metrics, 1, "a single datapoint per histogram")
+	summary, ok := h.metrics[0].(telemetry.Summary)
+	require.True(t, ok)
+	assert.Equal(t, "request_duration_seconds", summary.Name)
+	assert.Equal(t, 0.0, summary.Count, "the sample count of the test histograms doesn't change")
+	assert.Equal(t, 6.0, summary.Sum)
+	assert.Equal(t, map[string]interface{}{
+		"targetName":           "a",
+		"histogram.boundaries": "0,1,+Inf",
+		"histogram.counts":     "2,3,1",
+	}, summary.Attributes)
+}
@@ -38,18 +38,22 @@ func (e *InfraSdkEmitter) Emit(metrics []Metric) error {
 
 	now := time.Now()
 	for _, me := range metrics {
+		timestamp := now
+		if !me.timestamp.IsZero() {
+			timestamp = me.timestamp
+		}
 		switch me.metricType {
 		case metricType_GAUGE:
-			err = e.emitGauge(i, me, now)
+			err = e.emitGauge(i, me, timestamp)
 			break
 		case metricType_COUNTER:
-			err = e.emitCounter(i, me, now)
+			err = e.emitCounter(i, me, timestamp)
 			break
 		case metricType_SUMMARY:
-			err = e.emitSummary(i, me, now)
+			err = e.emitSummary(i, me, timestamp)
 			break
 		case metricType_HISTOGRAM:
-			err = e.emitHistogram(i, me, now)
+			err = e.emitHistogram(i, me, timestamp)
 			break
 		default:
 			err = fmt.Errorf("unknown metric type %q", me.metricType)
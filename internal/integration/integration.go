@@ -4,6 +4,7 @@
 package integration
 
 import (
+	"context"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -31,7 +32,14 @@ var ilog = logrus.WithField("component", "integration.Execute")
 // of rules and emits them.
 //
 // with first-class functions
+//
+// The targets of the selfRetriever, if not nil, are scraped after each cycle.
+//
+// When the context is done, the retrievers stop watching for targets, the
+// ongoing scrape cycle stops fetching new targets and Execute returns once the
+// metrics already fetched have been processed and emitted.
 func Execute(
+	ctx context.Context,
 	scrapeDuration time.Duration,
 	selfRetriever endpoints.TargetRetriever,
 	retrievers []endpoints.TargetRetriever,
@@ -46,6 +54,8 @@ func Execute(
 		}
 	}
 
+	defer stopRetrievers(retrievers)
+
 	for {
 		totalTimeseriesMetric.Set(0)
 		totalTimeseriesByTargetMetric.Reset()
@@ -57,86 +67,185 @@ func Execute(
 		nrprom.ResetTargetSize()
 
 		startTime := time.Now()
-		process(retrievers, fetcher, processor, emitters)
+		process(ctx, retrievers, fetcher, processor, emitters)
 		totalExecutionsMetric.Inc()
 		if duration := time.Since(startTime); duration < scrapeDuration {
-			time.Sleep(scrapeDuration - duration)
+			select {
+			case <-ctx.Done():
+			case <-time.After(scrapeDuration - duration):
+			case <-scrapeNow:
+			}
+		}
+		if ctx.Err() != nil {
+			ilog.Info("stopped scraping targets")
+			return
+		}
+		if selfRetriever != nil {
+			processWithoutTelemetry(ctx, selfRetriever, fetcher, processor, emitters)
 		}
-		processWithoutTelemetry(selfRetriever, fetcher, processor, emitters)
 	}
 }
 
+// stopRetrievers stops the background discovery of the retrievers that
+// support it.
+func stopRetrievers(retrievers []endpoints.TargetRetriever) {
+	for _, retriever := range retrievers {
+		if s, ok := retriever.(endpoints.Stopper); ok {
+			s.Stop()
+		}
+	}
+}
+
+// CycleResult summarizes a scrape cycle run by ExecuteOnce.
+type CycleResult struct {
+	// Targets is the number of targets found.
+	Targets int
+	// Scraped is the number of targets whose metrics were fetched.
+	Scraped int
+	// EmitErrors is the number of times an emitter failed to emit the
+	// metrics of a target.
+	EmitErrors int
+	// RetrieverErrors is the number of retrievers whose targets couldn't be
+	// listed.
+	RetrieverErrors int
+}
+
+// Succeeded returns true if all the targets were scraped and emitted.
+func (r CycleResult) Succeeded() bool {
+	return r.Scraped == r.Targets && r.EmitErrors == 0 && r.RetrieverErrors == 0
+}
+
 // ExecuteOnce executes the integration once. The pipeline fetches
 // metrics from the registered targets, transforms them according to a set
-// of rules and emits them.
-func ExecuteOnce(retrievers []endpoints.TargetRetriever, fetcher Fetcher, processor Processor, emitters []Emitter) {
+// of rules and emits them. The retrievers are stopped afterwards.
+func ExecuteOnce(retrievers []endpoints.TargetRetriever, fetcher Fetcher, processor Processor, emitters []Emitter) CycleResult {
+	var result CycleResult
 	for _, retriever := range retrievers {
 		err := retriever.Watch()
 		if err != nil {
 			ilog.WithError(err).WithField("retriever", retriever.Name()).Error("while getting the initial list of targets")
 		}
 	}
+	defer stopRetrievers(retrievers)
 
 	for _, retriever := range retrievers {
-		processWithoutTelemetry(retriever, fetcher, processor, emitters)
+		r := processWithoutTelemetry(context.Background(), retriever, fetcher, processor, emitters)
+		result.Targets += r.Targets
+		result.Scraped += r.Scraped
+		result.EmitErrors += r.EmitErrors
+		result.RetrieverErrors += r.RetrieverErrors
 	}
+	return result
+}
+
+// ScrapeTarget fetches the metrics of the target and processes them, without
+// emitting them. It returns false if they could not be fetched.
+func ScrapeTarget(ctx context.Context, target endpoints.Target, fetcher Fetcher, processor Processor) ([]Metric, bool) {
+	var metrics []Metric
+	fetched := false
+	for pair := range processor(fetcher.Fetch(ctx, []endpoints.Target{target})) {
+		metrics = append(metrics, pair.Metrics...)
+		fetched = true
+	}
+	return metrics, fetched
 }
 
 // processWithoutTelemetry processes a target retriever without doing any
-// kind of telemetry calculation.
+// kind of telemetry calculation. It returns the summary of the processing.
 func processWithoutTelemetry(
+	ctx context.Context,
 	retriever endpoints.TargetRetriever,
 	fetcher Fetcher,
 	processor Processor,
 	emitters []Emitter,
-) {
-	targets, err := retriever.GetTargets()
+) CycleResult {
+	var result CycleResult
+	targets, err := retrieverTargets(retriever)
 	if err != nil {
 		ilog.WithError(err).Error("error getting targets")
-		return
+		result.RetrieverErrors++
+		return result
 	}
-	pairs := fetcher.Fetch(targets)
+	result.Targets += len(targets)
+	pairs := fetcher.Fetch(ctx, targets)
 	processed := processor(pairs)
 	for pair := range processed {
+		result.Scraped++
 		for _, e := range emitters {
 			err := e.Emit(pair.Metrics)
 			if err != nil {
 				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting metrics")
+				result.EmitErrors++
 			}
 		}
 	}
+	return result
+}
+
+// retrieverTargets returns a copy of the targets of the retriever, tagged with
+// its name unless the retriever tagged them already.
+func retrieverTargets(retriever endpoints.TargetRetriever) ([]endpoints.Target, error) {
+	t, err := retriever.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]endpoints.Target, len(t))
+	for i := range t {
+		targets[i] = t[i]
+		if targets[i].Retriever == "" {
+			targets[i].Retriever = retriever.Name()
+		}
+	}
+	return targets, nil
 }
 
-func process(retrievers []endpoints.TargetRetriever, fetcher Fetcher, processor Processor, emitters []Emitter) {
+func process(ctx context.Context, retrievers []endpoints.TargetRetriever, fetcher Fetcher, processor Processor, emitters []Emitter) {
 	ptimer := prometheus.NewTimer(prometheus.ObserverFunc(processDurationMetric.Set))
+	ctx, cycle := DefaultTracer.Start(ctx, "scrape_cycle")
+	defer cycle.End()
 
 	targets := make([]endpoints.Target, 0)
 	for _, retriever := range retrievers {
 		totalDiscoveriesMetric.WithLabelValues(retriever.Name()).Set(1)
-		t, err := retriever.GetTargets()
+		_, span := DefaultTracer.Start(ctx, "discover")
+		span.SetAttribute("retriever", retriever.Name())
+		t, err := retrieverTargets(retriever)
+		span.SetAttribute("targets", len(t))
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			ilog.WithError(err).Error("error getting targets")
 			totalErrorsDiscoveryMetric.WithLabelValues(retriever.Name()).Set(1)
+			cycle.SetError(err)
 			return
 		}
 		totalTargetsMetric.WithLabelValues(retriever.Name()).Set(float64(len(t)))
 		targets = append(targets, t...)
 	}
-	pairs := fetcher.Fetch(targets) // fetch metrics from /metrics endpoints
-	processed := processor(pairs)   // apply processing
+	cycle.SetAttribute("targets", len(targets))
+	pairs := fetcher.Fetch(ctx, targets)                // fetch metrics from /metrics endpoints
+	processed := tracedProcessor(ctx, processor, pairs) // apply processing
 
 	emittedMetrics := 0
 	for pair := range processed {
 		emittedMetrics += len(pair.Metrics)
+		DefaultCardinalityTracker.Observe(pair.Metrics)
 
 		for _, e := range emitters {
+			_, span := DefaultTracer.Start(ctx, "emit")
+			span.SetAttribute("emitter", e.Name())
+			span.SetAttribute("target", pair.Target.Name)
 			err := e.Emit(pair.Metrics)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting metrics")
 			}
 		}
 	}
+	cycle.SetAttribute("series", emittedMetrics)
 
+	DefaultCardinalityTracker.Commit()
 	duration := ptimer.ObserveDuration()
 
 	logrus.WithFields(logrus.Fields{
@@ -3,6 +3,7 @@
 package integration
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -61,9 +62,25 @@ func BenchmarkIntegration(b *testing.B) {
 func do(b *testing.B, retrievers []endpoints.TargetRetriever) {
 	b.ReportAllocs()
 	process(
+		context.Background(),
 		retrievers,
 		NewFetcher(30*time.Second, 5000000000, 4, "", "", false, queueLength),
 		RuleProcessor([]ProcessingRule{}, queueLength),
 		[]Emitter{&nilEmit{}},
 	)
 }
+
+func TestRetrieverTargets(t *testing.T) {
+	retriever, err := endpoints.FixedRetriever(endpoints.TargetConfig{URLs: []endpoints.TargetURL{{URL: "somehost"}}})
+	assert.NoError(t, err)
+
+	targets, err := retrieverTargets(retriever)
+	assert.NoError(t, err)
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "fixed", targets[0].Retriever)
+
+	// The targets of the retriever are not modified
+	original, err := retriever.GetTargets()
+	assert.NoError(t, err)
+	assert.Equal(t, "", original[0].Retriever)
+}
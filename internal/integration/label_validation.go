@@ -0,0 +1,184 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// Modes of the validation of the label names.
+const (
+	// LabelValidationSanitize replaces the invalid characters of the label
+	// names.
+	LabelValidationSanitize = "sanitize"
+	// LabelValidationDrop drops the labels with invalid names.
+	LabelValidationDrop = "drop"
+)
+
+// maxLabelNameLength is the maximum length of the attribute names accepted by
+// the New Relic APIs.
+const maxLabelNameLength = 255
+
+// integrationAttributes are the attributes added by the integration to all
+// the scraped metrics, which are not labels of the target.
+var integrationAttributes = map[string]bool{
+	"targetName":     true,
+	"nrMetricType":   true,
+	"promMetricType": true,
+}
+
+// LabelValidationConfig configures the validation of the names of the scraped
+// labels, which are rejected by the New Relic APIs for each datapoint when
+// they are not valid.
+type LabelValidationConfig struct {
+	// Mode is sanitize or drop. The labels are not validated when empty.
+	Mode string `mapstructure:"mode"`
+	// Replacement of the invalid characters when sanitizing. Defaults to _.
+	Replacement string `mapstructure:"replacement"`
+	// LabelLimit is the maximum number of labels of a series. The series
+	// with more are dropped. Zero means no limit. Static targets can
+	// override it.
+	LabelLimit int `mapstructure:"label_limit"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *LabelValidationConfig) Validate() error {
+	switch c.Mode {
+	case "", LabelValidationSanitize, LabelValidationDrop:
+	default:
+		return fmt.Errorf("invalid mode %q, must be one of: %s, %s", c.Mode, LabelValidationSanitize, LabelValidationDrop)
+	}
+	if c.Replacement == "" {
+		c.Replacement = "_"
+	}
+	if len(c.Replacement) != 1 || !isLabelNameChar(c.Replacement[0], 1) {
+		return fmt.Errorf("replacement must be a letter, a digit or _, %q given", c.Replacement)
+	}
+	if c.LabelLimit < 0 {
+		return fmt.Errorf("label_limit can't be negative")
+	}
+	return nil
+}
+
+// FetcherWithLabelValidation makes the Fetcher sanitize or drop the labels
+// with invalid names, and drop the series with more labels than the limit.
+func FetcherWithLabelValidation(cfg LabelValidationConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.labelValidation = cfg
+	}
+}
+
+// apply validates the labels of the metrics of the target.
+func (c LabelValidationConfig) apply(log *logrus.Entry, target endpoints.Target, metrics []Metric) []Metric {
+	limit := c.LabelLimit
+	if target.LabelLimit > 0 {
+		limit = target.LabelLimit
+	}
+	if c.Mode == "" && limit == 0 {
+		return metrics
+	}
+
+	var sanitized, dropped, overLimit int
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if limit > 0 && len(m.attributes)-countIntegrationAttributes(m.attributes) > limit {
+			overLimit++
+			DefaultDroppedSampler.Sample(DroppedByLabelLimit, target.Name, m)
+			continue
+		}
+		if c.Mode == "" {
+			kept = append(kept, m)
+			continue
+		}
+		for name, value := range m.attributes {
+			if integrationAttributes[name] || isValidLabelName(name) {
+				continue
+			}
+			delete(m.attributes, name)
+			if c.Mode == LabelValidationDrop {
+				dropped++
+				continue
+			}
+			valid := sanitizeLabelName(name, c.Replacement[0])
+			if _, ok := m.attributes[valid]; ok {
+				// The label isn't overwritten.
+				dropped++
+				continue
+			}
+			m.attributes[valid] = value
+			sanitized++
+		}
+		kept = append(kept, m)
+	}
+
+	tlog := log.WithField("target", target.Name)
+	if sanitized > 0 {
+		invalidLabelsMetric.WithLabelValues(target.Name, LabelValidationSanitize).Add(float64(sanitized))
+		tlog.Debugf("sanitized %d labels with invalid names", sanitized)
+	}
+	if dropped > 0 {
+		invalidLabelsMetric.WithLabelValues(target.Name, LabelValidationDrop).Add(float64(dropped))
+		tlog.Debugf("dropped %d labels with invalid names", dropped)
+	}
+	if overLimit > 0 {
+		labelLimitDroppedMetric.WithLabelValues(target.Name).Add(float64(overLimit))
+		tlog.Warnf("dropped %d series with more than %d labels", overLimit, limit)
+	}
+	return kept
+}
+
+// countIntegrationAttributes returns the number of the attributes added by
+// the integration.
+func countIntegrationAttributes(attributes map[string]interface{}) int {
+	n := 0
+	for name := range integrationAttributes {
+		if _, ok := attributes[name]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// isLabelNameChar returns true if the character is valid at the position of a
+// label name: letters and _, and digits after the first one.
+func isLabelNameChar(c byte, position int) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (position > 0 && c >= '0' && c <= '9')
+}
+
+// isValidLabelName returns true if the name is a valid Prometheus label name,
+// [a-zA-Z_][a-zA-Z0-9_]*, not longer than the New Relic attribute names.
+func isValidLabelName(name string) bool {
+	if name == "" || len(name) > maxLabelNameLength {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isLabelNameChar(name[i], i) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeLabelName returns the name with the invalid characters replaced,
+// prefixed with the replacement if it starts with a digit, and truncated to
+// the maximum length.
+func sanitizeLabelName(name string, replacement byte) string {
+	var b strings.Builder
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		b.WriteByte(replacement)
+	}
+	for i := 0; i < len(name) && b.Len() < maxLabelNameLength; i++ {
+		if isLabelNameChar(name[i], 1) {
+			b.WriteByte(name[i])
+		} else {
+			b.WriteByte(replacement)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func invalidLabelMetrics() []Metric {
+	return []Metric{
+		{name: "valid", value: 1.0, attributes: labels.Set{"targetName": "target", "nrMetricType": "gauge", "job": "a"}},
+		{name: "invalid", value: 1.0, attributes: labels.Set{"targetName": "target", "http.method": "GET", "1st": "x", "http_method": "POST"}},
+	}
+}
+
+func TestLabelValidationConfigValidate(t *testing.T) {
+	cfg := LabelValidationConfig{Mode: LabelValidationSanitize}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "_", cfg.Replacement)
+
+	assert.Error(t, (&LabelValidationConfig{Mode: "fix"}).Validate())
+	assert.Error(t, (&LabelValidationConfig{Replacement: "."}).Validate())
+	assert.Error(t, (&LabelValidationConfig{Replacement: "__"}).Validate())
+	assert.Error(t, (&LabelValidationConfig{LabelLimit: -1}).Validate())
+}
+
+func TestLabelValidation(t *testing.T) {
+	log := logrus.WithField("component", "test")
+	target := endpoints.Target{Name: "target"}
+
+	t.Run("sanitize", func(t *testing.T) {
+		cfg := LabelValidationConfig{Mode: LabelValidationSanitize, Replacement: "_"}
+		metrics := cfg.apply(log, target, invalidLabelMetrics())
+		require.Len(t, metrics, 2)
+		assert.Equal(t, labels.Set{"targetName": "target", "nrMetricType": "gauge", "job": "a"}, metrics[0].attributes)
+		assert.Equal(t, labels.Set{"targetName": "target", "_1st": "x", "http_method": "POST"}, metrics[1].attributes,
+			"the sanitized labels don't overwrite the existing ones")
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		cfg := LabelValidationConfig{Mode: LabelValidationDrop, Replacement: "_"}
+		metrics := cfg.apply(log, target, invalidLabelMetrics())
+		require.Len(t, metrics, 2)
+		assert.Equal(t, labels.Set{"targetName": "target", "http_method": "POST"}, metrics[1].attributes)
+	})
+
+	t.Run("label limit", func(t *testing.T) {
+		cfg := LabelValidationConfig{LabelLimit: 1}
+		metrics := cfg.apply(log, target, invalidLabelMetrics())
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "valid", metrics[0].name)
+
+		limited := endpoints.Target{Name: "target", LabelLimit: 3}
+		assert.Len(t, cfg.apply(log, limited, invalidLabelMetrics()), 2, "the limit of the target overrides the global one")
+	})
+
+	t.Run("none", func(t *testing.T) {
+		metrics := LabelValidationConfig{}.apply(log, target, invalidLabelMetrics())
+		require.Len(t, metrics, 2)
+		assert.Equal(t, "GET", metrics[1].attributes["http.method"])
+	})
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	assert.Equal(t, "http_method", sanitizeLabelName("http.method", '_'))
+	assert.Equal(t, "_9xx", sanitizeLabelName("9xx", '_'))
+	assert.Equal(t, "a_b", sanitizeLabelName("a-b", '_'))
+	long := sanitizeLabelName(strings.Repeat("a", 300), '_')
+	assert.Len(t, long, maxLabelNameLength)
+	assert.True(t, isValidLabelName(long))
+	assert.False(t, isValidLabelName("a-b"))
+	assert.False(t, isValidLabelName(""))
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// DefaultLatencySLOCycles is the number of consecutive slow scrapes after
+// which a target is slow, by default.
+const DefaultLatencySLOCycles = 3
+
+// LatencySLOConfig flags the targets whose scrapes are persistently slower
+// than an objective, before they make the cycles overrun the scrape duration.
+type LatencySLOConfig struct {
+	// Objective is the maximum duration of the scrapes of a target. Zero
+	// disables the tracking.
+	Objective time.Duration `mapstructure:"objective"`
+	// Cycles is the number of consecutive scrapes slower than the objective
+	// after which the target is slow. Defaults to 3.
+	Cycles int `mapstructure:"cycles"`
+}
+
+// Enabled returns true if the scrapes are tracked.
+func (c LatencySLOConfig) Enabled() bool {
+	return c.Objective > 0
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c LatencySLOConfig) Validate() error {
+	if c.Objective < 0 {
+		return fmt.Errorf("objective can't be negative")
+	}
+	if c.Cycles < 0 {
+		return fmt.Errorf("cycles can't be negative")
+	}
+	return nil
+}
+
+// FetcherWithLatencySLO makes the Fetcher track the duration of the scrapes
+// of each target. A target whose scrapes took longer than the objective for
+// the configured number of consecutive cycles is logged as a warning and
+// reported by the slow_target metric, until a scrape meets the objective.
+func FetcherWithLatencySLO(cfg LatencySLOConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.latencySLO = newLatencySLOTracker(cfg)
+	}
+}
+
+// latencySLOTracker counts the consecutive slow scrapes of the targets.
+type latencySLOTracker struct {
+	objective time.Duration
+	cycles    int
+	log       *logrus.Entry
+
+	mtx     sync.Mutex
+	targets map[string]*latencySLOState
+}
+
+type latencySLOState struct {
+	// slowScrapes is the number of consecutive scrapes slower than the
+	// objective.
+	slowScrapes int
+	slow        bool
+}
+
+func newLatencySLOTracker(cfg LatencySLOConfig) *latencySLOTracker {
+	cycles := cfg.Cycles
+	if cycles == 0 {
+		cycles = DefaultLatencySLOCycles
+	}
+	return &latencySLOTracker{
+		objective: cfg.Objective,
+		cycles:    cycles,
+		log:       logrus.WithField("component", "LatencySLO"),
+		targets:   make(map[string]*latencySLOState),
+	}
+}
+
+// observe records the duration of a scrape of the target, flagging it as
+// slow, or not anymore, when its state changes.
+func (s *latencySLOTracker) observe(t endpoints.Target, d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	state, ok := s.targets[t.Name]
+	if !ok {
+		state = &latencySLOState{}
+		s.targets[t.Name] = state
+	}
+	fields := logrus.Fields{
+		"target":    t.Name,
+		"url":       t.URL.String(),
+		"duration":  d.String(),
+		"objective": s.objective.String(),
+	}
+
+	if d <= s.objective {
+		state.slowScrapes = 0
+		if state.slow {
+			state.slow = false
+			slowTargetMetric.WithLabelValues(t.Name).Set(0)
+			s.log.WithFields(fields).Info("the scrapes of the target meet the latency objective again")
+		}
+		return
+	}
+	state.slowScrapes++
+	if !state.slow && state.slowScrapes >= s.cycles {
+		state.slow = true
+		slowTargetMetric.WithLabelValues(t.Name).Set(1)
+		s.log.WithFields(fields).WithField("cycles", state.slowScrapes).
+			Warn("the scrapes of the target are persistently slower than the latency objective")
+	}
+}
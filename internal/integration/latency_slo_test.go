@@ -0,0 +1,45 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestLatencySLOConfigValidate(t *testing.T) {
+	assert.False(t, LatencySLOConfig{}.Enabled())
+	assert.True(t, LatencySLOConfig{Objective: time.Second}.Enabled())
+	assert.NoError(t, LatencySLOConfig{Objective: time.Second, Cycles: 2}.Validate())
+	assert.Error(t, LatencySLOConfig{Objective: -time.Second}.Validate())
+	assert.Error(t, LatencySLOConfig{Cycles: -1}.Validate())
+}
+
+func TestLatencySLOTracker(t *testing.T) {
+	target := endpoints.Target{Name: "slo-target"}
+	slow := func() float64 {
+		var m dto.Metric
+		require.NoError(t, slowTargetMetric.WithLabelValues(target.Name).Write(&m))
+		return m.GetGauge().GetValue()
+	}
+	tracker := newLatencySLOTracker(LatencySLOConfig{Objective: time.Second, Cycles: 2})
+
+	tracker.observe(target, 2*time.Second)
+	tracker.observe(target, time.Second)
+	tracker.observe(target, 2*time.Second)
+	assert.Zero(t, slow(), "the slow scrapes must be consecutive")
+
+	tracker.observe(target, 2*time.Second)
+	assert.Equal(t, float64(1), slow())
+	tracker.observe(target, 3*time.Second)
+	assert.Equal(t, float64(1), slow())
+
+	tracker.observe(target, 500*time.Millisecond)
+	assert.Zero(t, slow())
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLicenseKeyFileInterval is how often the license key file is checked
+// for a new key by default.
+const DefaultLicenseKeyFileInterval = time.Minute
+
+// ReadLicenseKeyFile returns the license key in the file, without the
+// surrounding whitespace.
+func ReadLicenseKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read license key file %s: %w", path, err)
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", fmt.Errorf("license key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// LicenseKeyFile holds the license key read from a file, e.g. mounted from a
+// Kubernetes Secret, which is read again periodically so the key can be
+// rotated without restarting. The previous key is kept after a rotation
+// until the new one is accepted, as either may be valid in the meantime.
+type LicenseKeyFile struct {
+	path     string
+	interval time.Duration
+	now      func() time.Time
+	log      *logrus.Entry
+
+	mtx     sync.Mutex
+	checked time.Time
+	current string
+	// previous is the key replaced by the current one, until the current one
+	// is accepted.
+	previous string
+	// rejected is when the current key was last rejected while the previous
+	// one was accepted. The previous one is tried first until the file is
+	// checked again.
+	rejected time.Time
+}
+
+// NewLicenseKeyFile reads the license key of the file, which is checked for
+// a new key every interval, or every DefaultLicenseKeyFileInterval if zero.
+func NewLicenseKeyFile(path string, interval time.Duration) (*LicenseKeyFile, error) {
+	key, err := ReadLicenseKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultLicenseKeyFileInterval
+	}
+	return &LicenseKeyFile{
+		path:     path,
+		interval: interval,
+		now:      time.Now,
+		log:      logrus.WithField("component", "LicenseKeyFile"),
+		checked:  time.Now(),
+		current:  key,
+	}, nil
+}
+
+// keys returns the keys to try, in order, reading the file again if it
+// wasn't checked in the last interval.
+func (f *LicenseKeyFile) keys() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	now := f.now()
+	if now.Sub(f.checked) >= f.interval {
+		f.checked = now
+		f.reload()
+	}
+	if f.previous == "" {
+		return []string{f.current}
+	}
+	if now.Sub(f.rejected) < f.interval {
+		return []string{f.previous, f.current}
+	}
+	return []string{f.current, f.previous}
+}
+
+// reload replaces the current key if the one in the file changed. The file
+// is ignored while it can't be read, as it may be in the middle of a
+// rotation.
+func (f *LicenseKeyFile) reload() {
+	key, err := ReadLicenseKeyFile(f.path)
+	if err != nil {
+		f.log.WithError(err).Warn("keeping the current license key")
+		return
+	}
+	if key == f.current {
+		return
+	}
+	f.log.Info("license key rotated, the previous one is used until the new one is accepted")
+	if key == f.previous {
+		// Rolled back before the rotated key was accepted.
+		f.previous = ""
+	} else {
+		f.previous = f.current
+	}
+	f.current = key
+	f.rejected = time.Time{}
+}
+
+// accepted records the key accepted by the endpoint after the given ones
+// were rejected.
+func (f *LicenseKeyFile) accepted(key string, rejected []string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	switch {
+	case key == f.current && f.previous != "":
+		f.log.Info("rotated license key accepted, dropping the previous one")
+		f.previous = ""
+	case key == f.previous && len(rejected) > 0:
+		f.rejected = f.now()
+	}
+}
+
+// TelemetryHarvesterWithLicenseKeyFile wraps the emitter client Transport to
+// use the license key of the file instead of the `apiKey`, retrying the
+// requests rejected with the current key with the previous one.
+//
+// Other options that modify the underlying Client.Transport should be
+// set before this one, because this will change the Transport type
+// to licenseKeyFileRoundTripper.
+func TelemetryHarvesterWithLicenseKeyFile(f *LicenseKeyFile) TelemetryHarvesterOpt {
+	return func(cfg *telemetry.Config) {
+		rt := cfg.Client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		cfg.Client.Transport = licenseKeyFileRoundTripper{file: f, rt: rt}
+	}
+}
+
+// licenseKeyFileRoundTripper adds the license key of a file to every request.
+type licenseKeyFileRoundTripper struct {
+	file *LicenseKeyFile
+	rt   http.RoundTripper
+}
+
+// RoundTrip sends the request with each of the keys of the file until one
+// isn't rejected. The requests whose body can't be sent again are only sent
+// with the first key.
+func (t licenseKeyFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	keys := t.file.keys()
+	for i, key := range keys {
+		attempt := cloneRequest(req)
+		attempt.Header.Del("Api-Key")
+		attempt.Header.Set("X-License-Key", key)
+		if i > 0 {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+		resp, err := t.rt.RoundTrip(attempt)
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(keys)-1 || req.GetBody == nil
+		if !last && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			_ = resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+			t.file.accepted(key, keys[:i])
+		}
+		return resp, nil
+	}
+	// Not reached, there is always a key.
+	return nil, fmt.Errorf("no license key")
+}
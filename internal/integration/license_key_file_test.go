@@ -0,0 +1,93 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLicenseKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "license")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "license-key")
+	write := func(key string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(key+"\n"), 0600))
+	}
+
+	_, err = NewLicenseKeyFile(path, 0)
+	assert.Error(t, err, "the file must exist")
+	write(" ")
+	_, err = NewLicenseKeyFile(path, 0)
+	assert.Error(t, err, "the file can't be empty")
+
+	write("old")
+	file, err := NewLicenseKeyFile(path, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	file.now = func() time.Time { return now }
+
+	// The keys the endpoint accepts, and the ones each request was sent with.
+	valid := map[string]bool{"old": true}
+	var sent []string
+	rt := licenseKeyFileRoundTripper{file: file, rt: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		key := req.Header.Get("X-License-Key")
+		assert.Empty(t, req.Header.Get("Api-Key"))
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(body))
+		sent = append(sent, key)
+		if !valid[key] {
+			return emptyResponse(http.StatusForbidden), nil
+		}
+		return emptyResponse(http.StatusAccepted), nil
+	})}
+	send := func() int {
+		sent = nil
+		req, err := http.NewRequest(http.MethodPost, "http://metrics", strings.NewReader("payload"))
+		require.NoError(t, err)
+		req.Header.Set("Api-Key", "configured")
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"old"}, sent)
+
+	// The file isn't read again until the interval passes.
+	write("new")
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"old"}, sent)
+
+	// While the new key is rejected, the previous one is used, first
+	// until the file is checked again.
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"new", "old"}, sent)
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"old"}, sent)
+
+	// Once the new key is accepted, the previous one is dropped.
+	valid["new"] = true
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"new"}, sent)
+	delete(valid, "old")
+	assert.Equal(t, http.StatusAccepted, send())
+	assert.Equal(t, []string{"new"}, sent)
+
+	// The rejection of the only key is returned.
+	delete(valid, "new")
+	assert.Equal(t, http.StatusForbidden, send())
+	assert.Equal(t, []string{"new"}, sent)
+}
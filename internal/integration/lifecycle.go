@@ -0,0 +1,64 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// DefaultLifecycleEventType is the type of the target lifecycle events.
+const DefaultLifecycleEventType = "PrometheusTargetLifecycleEvent"
+
+// LifecycleEventsConfig configures the events sent when the targets are
+// added, updated or removed by the retrievers.
+type LifecycleEventsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// EventType is the type of the events. Defaults to
+	// PrometheusTargetLifecycleEvent.
+	EventType string `mapstructure:"event_type"`
+}
+
+// EmitLifecycleEvents sends an event through the emitters supporting them for
+// each event recorded in the discovery log from now on.
+func EmitLifecycleEvents(log *endpoints.DiscoveryLog, cfg LifecycleEventsConfig, emitters []Emitter) {
+	eventType := cfg.EventType
+	if eventType == "" {
+		eventType = DefaultLifecycleEventType
+	}
+	log.Subscribe(func(e endpoints.DiscoveryEvent) {
+		attributes := lifecycleEventAttributes(e)
+		for _, em := range emitters {
+			if ee, ok := em.(EventEmitter); ok {
+				if err := ee.EmitEvent(eventType, attributes); err != nil {
+					ilog.WithField("emitter", em.Name()).WithError(err).Warn("error emitting target lifecycle event")
+				}
+			}
+		}
+	})
+}
+
+// lifecycleEventAttributes returns the attributes of the event of a change of
+// the targets of an object.
+func lifecycleEventAttributes(e endpoints.DiscoveryEvent) map[string]interface{} {
+	attributes := map[string]interface{}{
+		"retriever":  e.Retriever,
+		"action":     e.Action,
+		"kind":       e.Kind,
+		"objectName": e.Name,
+	}
+	if e.Reason != "" {
+		attributes["reason"] = e.Reason
+	}
+	if e.Namespace != "" {
+		attributes["namespaceName"] = e.Namespace
+	}
+	if len(e.Targets) > 0 {
+		attributes["targets"] = strings.Join(e.Targets, ",")
+	}
+	if len(e.PreviousTargets) > 0 {
+		attributes["previousTargets"] = strings.Join(e.PreviousTargets, ",")
+	}
+	return attributes
+}
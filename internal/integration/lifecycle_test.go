@@ -0,0 +1,43 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestEmitLifecycleEvents(t *testing.T) {
+	log := endpoints.NewDiscoveryLog(10)
+	emitter := &eventRecordingEmitter{}
+	EmitLifecycleEvents(log, LifecycleEventsConfig{Enabled: true}, []Emitter{emitter, &recordingEmitter{}})
+
+	log.Record(endpoints.DiscoveryEvent{
+		Retriever:       "kubernetes",
+		Action:          endpoints.DiscoveryUpdated,
+		Reason:          endpoints.ReasonURLChanged,
+		Kind:            "pod",
+		Namespace:       "test-ns",
+		Name:            "my-pod",
+		Targets:         []string{"http://10.0.0.2:8080/metrics"},
+		PreviousTargets: []string{"http://10.0.0.1:8080/metrics"},
+	})
+
+	events := emitter.emittedEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, DefaultLifecycleEventType, events[0].eventType)
+	assert.Equal(t, map[string]interface{}{
+		"retriever":       "kubernetes",
+		"action":          "updated",
+		"reason":          "url_changed",
+		"kind":            "pod",
+		"namespaceName":   "test-ns",
+		"objectName":      "my-pod",
+		"targets":         "http://10.0.0.2:8080/metrics",
+		"previousTargets": "http://10.0.0.1:8080/metrics",
+	}, events[0].attributes)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// Attributes with the metadata of the metrics.
+const (
+	descriptionAttribute = "description"
+	unitAttribute        = "unit"
+)
+
+// baseUnits are the Prometheus base units, which are the suffix of the names
+// of the metrics measured in them, optionally followed by _total, _count,
+// _sum or _bucket.
+var baseUnits = []string{
+	"seconds",
+	"bytes",
+	"ratio",
+	"celsius",
+	"meters",
+	"volts",
+	"amperes",
+	"joules",
+	"grams",
+}
+
+// FetcherWithMetadata makes the Fetcher add the description of the metrics,
+// from their HELP, and their unit as attributes. Their type is always added
+// as the promMetricType attribute.
+func FetcherWithMetadata() FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.metadata = true
+	}
+}
+
+// addMetadata adds to the metrics the description and unit of their family.
+// The text format parser doesn't keep the UNIT comments of OpenMetrics, so
+// the unit is taken from the suffix of the family name, where OpenMetrics
+// requires it to be.
+func addMetadata(metrics []Metric, mfs prometheus.MetricFamiliesByName) {
+	for _, m := range metrics {
+		mf, ok := mfs[m.name]
+		if !ok {
+			continue
+		}
+		if help := mf.GetHelp(); help != "" {
+			m.attributes[descriptionAttribute] = help
+		}
+		if unit := metricUnit(m.name); unit != "" {
+			m.attributes[unitAttribute] = unit
+		}
+	}
+}
+
+// metricUnit returns the base unit, or a unit converted by normalizeUnits, in
+// the suffix of the metric name, if any.
+func metricUnit(name string) string {
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	for _, unit := range baseUnits {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	if i := strings.LastIndex(name, "_"); i >= 0 {
+		if _, ok := unitConversions[name[i+1:]]; ok {
+			return name[i+1:]
+		}
+	}
+	return ""
+}
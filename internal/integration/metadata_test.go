@@ -0,0 +1,44 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func TestMetricUnit(t *testing.T) {
+	assert.Equal(t, "seconds", metricUnit("http_request_duration_seconds"))
+	assert.Equal(t, "seconds", metricUnit("process_cpu_seconds_total"))
+	assert.Equal(t, "bytes", metricUnit("response_size_bytes_bucket"))
+	assert.Equal(t, "", metricUnit("http_requests_total"))
+	assert.Equal(t, "", metricUnit("up"))
+}
+
+func TestAddMetadata(t *testing.T) {
+	mfs, err := prometheus.Decode(strings.NewReader(`# HELP process_cpu_seconds_total Total user and system CPU time.
+# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total 12.5
+up 1
+`))
+	require.NoError(t, err)
+	metrics := convertPromMetrics(nil, "target", mfs)
+	addMetadata(metrics, mfs)
+
+	require.Len(t, metrics, 2)
+	for _, m := range metrics {
+		if m.name == "up" {
+			assert.NotContains(t, m.attributes, descriptionAttribute)
+			assert.NotContains(t, m.attributes, unitAttribute)
+			continue
+		}
+		assert.Equal(t, "Total user and system CPU time.", m.attributes[descriptionAttribute])
+		assert.Equal(t, "seconds", m.attributes[unitAttribute])
+		assert.Equal(t, "counter", m.attributes["promMetricType"])
+	}
+}
@@ -109,6 +109,265 @@ var (
 		Name:      "total_executions",
 		Help:      "The number of times the integration is executed",
 	})
+	shedTargetsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "shed_targets_total",
+		Help:      "The number of low priority target fetches skipped because of memory pressure",
+	})
+	droppedTargetsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "dropped_targets_total",
+		Help:      "The number of target fetches skipped because there were more targets than max_targets",
+	})
+	skewedDatapointsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "skewed_datapoints_total",
+		Help:      "The number of datapoints whose timestamp was skewed from the scrape time more than the maximum, by the action taken",
+	},
+		[]string{
+			"target",
+			"action",
+		},
+	)
+	outOfBoundsTimestampsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "emitter",
+		Name:      "out_of_bounds_timestamps_total",
+		Help:      "The number of datapoints whose timestamp was out of the window accepted by the Metric API, by bound and action taken",
+	},
+		[]string{
+			"bound",
+			"action",
+		},
+	)
+	nonFiniteValuesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "non_finite_values_total",
+		Help:      "The number of counter and gauge samples with NaN or Inf values, by the policy applied",
+	},
+		[]string{
+			"target",
+			"policy",
+		},
+	)
+	budgetSkippedTargetsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "budget_skipped_targets_total",
+		Help:      "The number of times a target was skipped because the scrape cycle didn't fit in the scrape budget",
+	},
+		[]string{
+			"target",
+		},
+	)
+	unscheduledTargetsMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "unscheduled_targets",
+		Help:      "The number of targets skipped in the last scrape cycle because they were out of their scrape schedule",
+	})
+	decorationConflictsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "decoration_conflicts_total",
+		Help:      "The number of attributes copied by the copy_attributes rules from several sources with different values, by the policy applied",
+	}, []string{"policy"})
+	ruleCacheLookupsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "rule_cache_lookups_total",
+		Help:      "The number of lookups of the processing rules matching a metric name in their cache, by result: hit or miss",
+	}, []string{"result"})
+	walUnknownSeriesSamplesMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "wal_unknown_series_samples_total",
+		Help:      "The number of samples of the write-ahead log discarded because their series was not found",
+	})
+	rampSkippedTargetsMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "ramp_skipped_targets",
+		Help:      "The number of targets skipped in the last scrape cycle because the scraping is ramping up after the start",
+	})
+	invalidLabelsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "invalid_labels_total",
+		Help:      "The number of labels with invalid names, by the action taken",
+	},
+		[]string{
+			"target",
+			"action",
+		},
+	)
+	labelLimitDroppedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "label_limit_dropped_series_total",
+		Help:      "The number of series dropped because they had more labels than the label limit",
+	},
+		[]string{
+			"target",
+		},
+	)
+	rejectedContentTypeMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "rejected_content_type_total",
+		Help:      "The number of scrape responses rejected because their Content-Type is not an exposition format",
+	},
+		[]string{
+			"target",
+		},
+	)
+	errorPayloadsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "error_payloads_total",
+		Help:      "The number of scrapes failed because the payload had a line matching the error payload patterns",
+	},
+		[]string{
+			"target",
+		},
+	)
+	oversizedPayloadsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "oversized_payloads_total",
+		Help:      "The number of scrapes failed because the payload was larger than the maximum size",
+	},
+		[]string{
+			"target",
+		},
+	)
+	droppedSpansMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "dropped_spans_total",
+		Help:      "The number of trace spans dropped because the queue was full or they couldn't be exported",
+	})
+	quarantinedPayloadsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "quarantined_payloads_total",
+		Help:      "The number of payloads that made the parser panic or time out, by reason",
+	},
+		[]string{
+			"target",
+			"reason",
+		},
+	)
+	parseErrorsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "parse_errors_total",
+		Help:      "The number of malformed lines skipped in the payloads of a target with lenient parsing",
+	},
+		[]string{
+			"target",
+		},
+	)
+	spillBytesMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "spill",
+		Name:      "bytes",
+		Help:      "The size in bytes of the compressed payloads stored on disk waiting to be processed",
+	})
+	spillDroppedMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "spill",
+		Name:      "dropped_payloads_total",
+		Help:      "The number of payloads discarded because the spill directory was full",
+	})
+	payloadBytesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "emitter",
+		Name:      "payload_bytes_total",
+		Help:      "The size in bytes of the payloads sent by the telemetry emitter, before and after compression",
+	},
+		[]string{
+			"encoding",
+		},
+	)
+	compressionRatioMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "emitter",
+		Name:      "compression_ratio",
+		Help:      "The uncompressed to compressed size ratio of the last payload sent by the telemetry emitter",
+	})
+	recompressionSecondsMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "emitter",
+		Name:      "recompression_seconds_total",
+		Help:      "The time in seconds spent compressing again the payloads sent by the telemetry emitter with the configured compression level",
+	})
+	budgetDatapointsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "budget",
+		Name:      "datapoints_total",
+		Help:      "The number of datapoints emitted for a value of the budgeted attribute",
+	},
+		[]string{
+			"value",
+		},
+	)
+	budgetDroppedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "budget",
+		Name:      "dropped_datapoints_total",
+		Help:      "The number of datapoints dropped because a value of the budgeted attribute was over budget",
+	},
+		[]string{
+			"value",
+		},
+	)
+	budgetUsageMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "budget",
+		Name:      "usage_ratio",
+		Help:      "The ratio of the budget of a value of the budgeted attribute used in the current window",
+	},
+		[]string{
+			"value",
+		},
+	)
+	redactionsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "redactions_total",
+		Help:      "The number of attribute values redacted, by the kind and the pattern matching them",
+	},
+		[]string{
+			"kind",
+			"pattern",
+		},
+	)
+	targetQueueDroppedMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "target_queue_dropped_total",
+		Help:      "The number of scrapes of a target whose metrics were dropped because the queue of its shard was full",
+	},
+		[]string{
+			"target",
+		},
+	)
+	slowTargetMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "slow_target",
+		Help:      "1 if the scrapes of the target are persistently slower than the latency objective, 0 otherwise",
+	},
+		[]string{
+			"target",
+		},
+	)
+	clientCertificateExpiryMetric = newCertificateExpiryCollector()
 )
 
 func init() {
@@ -124,4 +383,36 @@ func init() {
 	prometheus.MustRegister(fetchTargetDurationMetric)
 	prometheus.MustRegister(processDurationMetric)
 	prometheus.MustRegister(totalExecutionsMetric)
+	prometheus.MustRegister(shedTargetsMetric)
+	prometheus.MustRegister(droppedTargetsMetric)
+	prometheus.MustRegister(skewedDatapointsMetric)
+	prometheus.MustRegister(nonFiniteValuesMetric)
+	prometheus.MustRegister(budgetSkippedTargetsMetric)
+	prometheus.MustRegister(unscheduledTargetsMetric)
+	prometheus.MustRegister(rampSkippedTargetsMetric)
+	prometheus.MustRegister(decorationConflictsMetric)
+	prometheus.MustRegister(ruleCacheLookupsMetric)
+	prometheus.MustRegister(walUnknownSeriesSamplesMetric)
+	prometheus.MustRegister(invalidLabelsMetric)
+	prometheus.MustRegister(labelLimitDroppedMetric)
+	prometheus.MustRegister(rejectedContentTypeMetric)
+	prometheus.MustRegister(errorPayloadsMetric)
+	prometheus.MustRegister(outOfBoundsTimestampsMetric)
+	prometheus.MustRegister(oversizedPayloadsMetric)
+	prometheus.MustRegister(droppedSpansMetric)
+	prometheus.MustRegister(quarantinedPayloadsMetric)
+	prometheus.MustRegister(parseErrorsMetric)
+	prometheus.MustRegister(spillBytesMetric)
+	prometheus.MustRegister(spillDroppedMetric)
+	prometheus.MustRegister(payloadBytesMetric)
+	prometheus.MustRegister(compressionRatioMetric)
+	prometheus.MustRegister(recompressionSecondsMetric)
+	prometheus.MustRegister(budgetDatapointsMetric)
+	prometheus.MustRegister(budgetDroppedMetric)
+	prometheus.MustRegister(budgetUsageMetric)
+	prometheus.MustRegister(redactionsMetric)
+	prometheus.MustRegister(targetQueueDroppedMetric)
+	prometheus.MustRegister(slowTargetMetric)
+	prometheus.MustRegister(clientCertificateExpiryMetric)
+	prometheus.MustRegister(DefaultCardinalityTracker)
 }
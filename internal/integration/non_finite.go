@@ -0,0 +1,80 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policies for the NaN and ±Inf values of the counters and gauges.
+const (
+	// NonFiniteDrop drops the metric.
+	NonFiniteDrop = "drop"
+	// NonFiniteClamp replaces ±Inf with the maximum or minimum float value,
+	// and NaN with zero.
+	NonFiniteClamp = "clamp"
+	// NonFiniteAttribute replaces the value with zero, and adds the original
+	// one as the nonFiniteValue attribute.
+	NonFiniteAttribute = "attribute"
+)
+
+// nonFiniteAttribute is the attribute with the original value of the metrics
+// converted with the NonFiniteAttribute policy.
+const nonFiniteAttribute = "nonFiniteValue"
+
+// FetcherWithNonFinitePolicy makes the Fetcher handle the NaN and ±Inf values
+// of the counters and gauges with the given policy: NonFiniteDrop,
+// NonFiniteClamp or NonFiniteAttribute. Otherwise they are kept as they are.
+func FetcherWithNonFinitePolicy(policy string) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.nonFinitePolicy = policy
+	}
+}
+
+// applyNonFinitePolicy handles the NaN and ±Inf values of the metrics of the
+// target with the given policy.
+func applyNonFinitePolicy(log *logrus.Entry, targetName string, metrics []Metric, policy string) []Metric {
+	if policy == "" {
+		return metrics
+	}
+	var found int
+	kept := metrics[:0]
+	for _, m := range metrics {
+		value, ok := m.value.(float64)
+		if !ok || (!math.IsNaN(value) && !math.IsInf(value, 0)) {
+			kept = append(kept, m)
+			continue
+		}
+		found++
+		switch policy {
+		case NonFiniteDrop:
+			continue
+		case NonFiniteClamp:
+			m.value = clamp(value)
+		case NonFiniteAttribute:
+			m.value = 0.0
+			m.attributes[nonFiniteAttribute] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		kept = append(kept, m)
+	}
+	if found > 0 {
+		nonFiniteValuesMetric.WithLabelValues(targetName, policy).Add(float64(found))
+		log.WithField("target", targetName).Debugf("%d metrics with NaN or Inf values: policy %s", found, policy)
+	}
+	return kept
+}
+
+func clamp(value float64) float64 {
+	switch {
+	case math.IsInf(value, 1):
+		return math.MaxFloat64
+	case math.IsInf(value, -1):
+		return -math.MaxFloat64
+	case math.IsNaN(value):
+		return 0
+	}
+	return value
+}
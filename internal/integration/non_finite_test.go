@@ -0,0 +1,58 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func nonFiniteMetrics() []Metric {
+	return []Metric{
+		{name: "finite", value: 1.5, attributes: labels.Set{"targetName": "target"}},
+		{name: "nan", value: math.NaN(), attributes: labels.Set{"targetName": "target"}},
+		{name: "inf", value: math.Inf(1), attributes: labels.Set{"targetName": "target"}},
+		{name: "minus_inf", value: math.Inf(-1), attributes: labels.Set{"targetName": "target"}},
+	}
+}
+
+func TestApplyNonFinitePolicy(t *testing.T) {
+	log := logrus.WithField("component", "test")
+
+	t.Run("drop", func(t *testing.T) {
+		metrics := applyNonFinitePolicy(log, "target", nonFiniteMetrics(), NonFiniteDrop)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, "finite", metrics[0].name)
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		metrics := applyNonFinitePolicy(log, "target", nonFiniteMetrics(), NonFiniteClamp)
+		require.Len(t, metrics, 4)
+		assert.Equal(t, 1.5, metrics[0].value)
+		assert.Equal(t, 0.0, metrics[1].value)
+		assert.Equal(t, math.MaxFloat64, metrics[2].value)
+		assert.Equal(t, -math.MaxFloat64, metrics[3].value)
+	})
+
+	t.Run("attribute", func(t *testing.T) {
+		metrics := applyNonFinitePolicy(log, "target", nonFiniteMetrics(), NonFiniteAttribute)
+		require.Len(t, metrics, 4)
+		assert.Equal(t, labels.Set{"targetName": "target"}, metrics[0].attributes)
+		assert.Equal(t, 0.0, metrics[1].value)
+		assert.Equal(t, "NaN", metrics[1].attributes[nonFiniteAttribute])
+		assert.Equal(t, "+Inf", metrics[2].attributes[nonFiniteAttribute])
+		assert.Equal(t, "-Inf", metrics[3].attributes[nonFiniteAttribute])
+	})
+
+	t.Run("none", func(t *testing.T) {
+		metrics := applyNonFinitePolicy(log, "target", nonFiniteMetrics(), "")
+		require.Len(t, metrics, 4)
+		assert.True(t, math.IsNaN(metrics[1].value.(float64)))
+	})
+}
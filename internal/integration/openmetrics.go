@@ -0,0 +1,185 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// Emission modes of the OpenMetrics info families.
+const (
+	// InfoAsGauge emits the info series as gauges with value 1.
+	InfoAsGauge = "gauge"
+	// InfoAsAttributes adds the labels of the info series as attributes of
+	// all the other metrics of the target, instead of emitting them.
+	InfoAsAttributes = "attributes"
+)
+
+// Emission modes of the OpenMetrics stateset families.
+const (
+	// StatesetAsGauges emits a gauge per state, with value 1 when the state
+	// is set and 0 otherwise.
+	StatesetAsGauges = "gauges"
+	// StatesetAsEnum emits a single gauge per series, with the states that
+	// are set in the attribute named after the family, and their number as
+	// value.
+	StatesetAsEnum = "enum"
+)
+
+// OpenMetricsConfig configures how the OpenMetrics types that don't exist in
+// the Prometheus format are emitted.
+type OpenMetricsConfig struct {
+	// Info is gauge or attributes. Defaults to gauge.
+	Info string `mapstructure:"info"`
+	// Stateset is gauges or enum. Defaults to gauges.
+	Stateset string `mapstructure:"stateset"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *OpenMetricsConfig) Validate() error {
+	switch c.Info {
+	case "":
+		c.Info = InfoAsGauge
+	case InfoAsGauge, InfoAsAttributes:
+	default:
+		return fmt.Errorf("invalid info mode %q, must be one of: %s, %s", c.Info, InfoAsGauge, InfoAsAttributes)
+	}
+	switch c.Stateset {
+	case "":
+		c.Stateset = StatesetAsGauges
+	case StatesetAsGauges, StatesetAsEnum:
+	default:
+		return fmt.Errorf("invalid stateset mode %q, must be one of: %s, %s", c.Stateset, StatesetAsGauges, StatesetAsEnum)
+	}
+	return nil
+}
+
+// FetcherWithOpenMetrics makes the Fetcher emit the info and stateset
+// families as configured.
+func FetcherWithOpenMetrics(cfg OpenMetricsConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.openMetrics = cfg
+	}
+}
+
+// apply emits the info and stateset metrics of a target as configured.
+func (c OpenMetricsConfig) apply(metrics []Metric) []Metric {
+	if c.Info == InfoAsAttributes {
+		metrics = infoAsAttributes(metrics)
+	}
+	if c.Stateset == StatesetAsEnum {
+		metrics = statesetAsEnum(metrics)
+	}
+	return metrics
+}
+
+// infoAsAttributes removes the info metrics, adding their labels to the
+// other metrics that don't have an attribute with the same name. The first
+// info series wins when several have the same label.
+func infoAsAttributes(metrics []Metric) []Metric {
+	info := labels.Set{}
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if m.attributes["promMetricType"] != "info" {
+			kept = append(kept, m)
+			continue
+		}
+		for name, value := range m.attributes {
+			if _, ok := info[name]; !ok && !integrationAttributes[name] {
+				info[name] = value
+			}
+		}
+	}
+	if len(info) == 0 {
+		return kept
+	}
+	for _, m := range kept {
+		for name, value := range info {
+			if _, ok := m.attributes[name]; !ok {
+				m.attributes[name] = value
+			}
+		}
+	}
+	return kept
+}
+
+// statesetAsEnum replaces the series of each state of the stateset metrics by
+// a single one, with the sorted states that are set joined by commas.
+func statesetAsEnum(metrics []Metric) []Metric {
+	return foldStates(metrics, func(m Metric) string {
+		if m.attributes["promMetricType"] != "stateset" {
+			return ""
+		}
+		return m.name
+	})
+}
+
+// foldStates replaces the series of each state of the metrics for which
+// stateAttribute returns the attribute holding the state by a single one,
+// with the sorted states whose value isn't 0 joined by commas in the
+// attribute, and their number as value. The other metrics are kept.
+func foldStates(metrics []Metric, stateAttribute func(Metric) string) []Metric {
+	kept := metrics[:0]
+	enums := map[string]int{}
+	for _, m := range metrics {
+		attribute := stateAttribute(m)
+		if attribute == "" {
+			kept = append(kept, m)
+			continue
+		}
+		state, _ := m.attributes[attribute].(string)
+		value, _ := m.value.(float64)
+		key := statesetKey(m, attribute)
+		i, ok := enums[key]
+		if !ok {
+			attrs := labels.Set{}
+			for name, v := range m.attributes {
+				attrs[name] = v
+			}
+			attrs[attribute] = ""
+			m.attributes = attrs
+			m.value = float64(0)
+			enums[key] = len(kept)
+			i = len(kept)
+			kept = append(kept, m)
+		}
+		if value != 0 {
+			kept[i].value = kept[i].value.(float64) + 1
+			kept[i].attributes[attribute] = joinStates(kept[i].attributes[attribute].(string), state)
+		}
+	}
+	return kept
+}
+
+// statesetKey identifies the series of a metric, regardless of the state in
+// the attribute.
+func statesetKey(m Metric, attribute string) string {
+	names := make([]string, 0, len(m.attributes))
+	for name := range m.attributes {
+		if name != attribute {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	key.WriteString(m.name)
+	for _, name := range names {
+		fmt.Fprintf(&key, "\xff%s=%v", name, m.attributes[name])
+	}
+	return key.String()
+}
+
+// joinStates adds the state to the sorted comma separated states.
+func joinStates(states, state string) string {
+	if states == "" {
+		return state
+	}
+	all := append(strings.Split(states, ","), state)
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
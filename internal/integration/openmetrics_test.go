@@ -0,0 +1,80 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const openMetricsPayload = `# TYPE build info
+build_info{version="1.2.3",job="exporter"} 1
+# TYPE door stateset
+door{door="open",room="a"} 1
+door{door="closed",room="a"} 0
+door{door="locked",room="a"} 1
+door{door="open",room="b"} 0
+door{door="closed",room="b"} 1
+# TYPE up gauge
+up{job="a"} 1
+`
+
+func TestOpenMetricsConfigValidate(t *testing.T) {
+	cfg := OpenMetricsConfig{}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, InfoAsGauge, cfg.Info)
+	assert.Equal(t, StatesetAsGauges, cfg.Stateset)
+
+	assert.Error(t, (&OpenMetricsConfig{Info: "label"}).Validate())
+	assert.Error(t, (&OpenMetricsConfig{Stateset: "gauge"}).Validate())
+}
+
+func TestOpenMetrics(t *testing.T) {
+	byName := func(metrics []Metric) map[string][]Metric {
+		m := map[string][]Metric{}
+		for _, metric := range metrics {
+			m[metric.name] = append(m[metric.name], metric)
+		}
+		return m
+	}
+
+	t.Run("gauges", func(t *testing.T) {
+		metrics, err := ParseMetrics(strings.NewReader(openMetricsPayload), "target")
+		require.NoError(t, err)
+		cfg := OpenMetricsConfig{}
+		require.NoError(t, cfg.Validate())
+		m := byName(cfg.apply(metrics))
+
+		require.Len(t, m["build_info"], 1)
+		assert.Equal(t, "info", m["build_info"][0].attributes["promMetricType"])
+		assert.Equal(t, "gauge", m["build_info"][0].Type())
+		assert.Len(t, m["door"], 5)
+		assert.Equal(t, "stateset", m["door"][0].attributes["promMetricType"])
+	})
+
+	t.Run("attributes and enum", func(t *testing.T) {
+		metrics, err := ParseMetrics(strings.NewReader(openMetricsPayload), "target")
+		require.NoError(t, err)
+		cfg := OpenMetricsConfig{Info: InfoAsAttributes, Stateset: StatesetAsEnum}
+		m := byName(cfg.apply(metrics))
+
+		assert.NotContains(t, m, "build_info")
+		require.Len(t, m["up"], 1)
+		assert.Equal(t, "1.2.3", m["up"][0].attributes["version"])
+		assert.Equal(t, "a", m["up"][0].attributes["job"], "the metric labels are not overwritten")
+
+		require.Len(t, m["door"], 2)
+		states := map[interface{}]Metric{}
+		for _, door := range m["door"] {
+			states[door.attributes["room"]] = door
+		}
+		assert.Equal(t, "locked,open", states["a"].attributes["door"])
+		assert.Equal(t, 2.0, states["a"].value)
+		assert.Equal(t, "closed", states["b"].attributes["door"])
+		assert.Equal(t, 1.0, states["b"].value)
+		assert.Equal(t, "1.2.3", states["b"].attributes["version"])
+	})
+}
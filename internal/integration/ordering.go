@@ -0,0 +1,87 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// OrderedProcessor processes the pairs with the processor and, once all the
+// pairs of the cycle are processed, returns them sorted by target and their
+// metrics sorted by name and attributes. The emitted batches are then the
+// same for the same payloads, whatever the order the targets are scraped in,
+// so the emitted payloads of two versions or configurations can be compared.
+// The pairs are held until the end of the cycle.
+func OrderedProcessor(next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		ordered := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(ordered)
+			var cycle []TargetMetrics
+			for pair := range next(pairs) {
+				sortMetrics(pair.Metrics)
+				cycle = append(cycle, pair)
+			}
+			sort.SliceStable(cycle, func(i, j int) bool {
+				a, b := cycle[i].Target, cycle[j].Target
+				if a.Name != b.Name {
+					return a.Name < b.Name
+				}
+				return a.URL.String() < b.URL.String()
+			})
+			for _, pair := range cycle {
+				ordered <- pair
+			}
+		}()
+		return ordered
+	}
+}
+
+// sortMetrics sorts the metrics by name, attributes and timestamp.
+func sortMetrics(metrics []Metric) {
+	type keyed struct {
+		metric Metric
+		key    string
+	}
+	sorted := make([]keyed, len(metrics))
+	for i, m := range metrics {
+		sorted[i] = keyed{metric: m, key: attributesKey(m)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.metric.name != b.metric.name {
+			return a.metric.name < b.metric.name
+		}
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		return a.metric.timestamp.Before(b.metric.timestamp)
+	})
+	for i := range sorted {
+		metrics[i] = sorted[i].metric
+	}
+}
+
+// attributesKey returns the attributes of the metric as a string, with the
+// names sorted.
+func attributesKey(m Metric) string {
+	return labelsKey(m.attributes)
+}
+
+// labelsKey returns the labels as a string, with the names sorted.
+func labelsKey(ls labels.Set) string {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%v\xff", name, ls[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestOrderedProcessor(t *testing.T) {
+	target := func(name, host string) endpoints.Target {
+		return endpoints.New(name, url.URL{Scheme: "http", Host: host, Path: "/metrics"}, endpoints.Object{Name: name})
+	}
+	pairs := make(chan TargetMetrics, 3)
+	pairs <- TargetMetrics{Target: target("b", "b:8080"), Metrics: []Metric{
+		{name: "up", attributes: labels.Set{}},
+		{name: "http_requests_total", attributes: labels.Set{"code": "500", "method": "GET"}},
+		{name: "http_requests_total", attributes: labels.Set{"code": "200", "method": "POST"}},
+		{name: "http_requests_total", attributes: labels.Set{"code": "200", "method": "GET"}},
+	}}
+	pairs <- TargetMetrics{Target: target("a", "a:9090"), Metrics: []Metric{{name: "up", attributes: labels.Set{}}}}
+	pairs <- TargetMetrics{Target: target("a", "a:8080"), Metrics: []Metric{{name: "up", attributes: labels.Set{}}}}
+	close(pairs)
+
+	var targets []string
+	var processed []TargetMetrics
+	for pair := range OrderedProcessor(RuleProcessor(nil, 1), 1)(pairs) {
+		targets = append(targets, pair.Target.URL.Host)
+		processed = append(processed, pair)
+	}
+	assert.Equal(t, []string{"a:8080", "a:9090", "b:8080"}, targets)
+
+	var series []string
+	for _, m := range processed[2].Metrics {
+		series = append(series, fmt.Sprintf("%s{%v,%v}", m.name, m.attributes["code"], m.attributes["method"]))
+	}
+	assert.Equal(t, []string{
+		"http_requests_total{200,GET}",
+		"http_requests_total{200,POST}",
+		"http_requests_total{500,GET}",
+		"up{<nil>,<nil>}",
+	}, series)
+}
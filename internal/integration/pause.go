@@ -0,0 +1,99 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// scrapeNow starts a scrape cycle without waiting for the scrape interval.
+var scrapeNow = make(chan struct{}, 1)
+
+// TriggerScrape makes Execute start the next scrape cycle right away, or
+// right after the current one when it is running.
+func TriggerScrape() {
+	select {
+	case scrapeNow <- struct{}{}:
+	default:
+		// A cycle is already pending.
+	}
+}
+
+// PausedTargets is the set of targets that are not scraped until resumed,
+// identified by their name or URL.
+type PausedTargets struct {
+	mtx  sync.RWMutex
+	refs map[string]struct{}
+}
+
+// NewPausedTargets returns an empty PausedTargets.
+func NewPausedTargets() *PausedTargets {
+	return &PausedTargets{refs: make(map[string]struct{})}
+}
+
+// Pause stops scraping the targets with the given name or URL.
+func (p *PausedTargets) Pause(ref string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.refs[ref] = struct{}{}
+}
+
+// Resume scrapes again the targets with the given name or URL. It returns
+// false if they were not paused.
+func (p *PausedTargets) Resume(ref string) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	_, ok := p.refs[ref]
+	delete(p.refs, ref)
+	return ok
+}
+
+// List returns the names and URLs of the paused targets.
+func (p *PausedTargets) List() []string {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	refs := make([]string, 0, len(p.refs))
+	for ref := range p.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// Paused returns true if the target is paused.
+func (p *PausedTargets) Paused(t *endpoints.Target) bool {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	_, byName := p.refs[t.Name]
+	_, byURL := p.refs[t.URL.String()]
+	return byName || byURL
+}
+
+// pausingFetcher is a Fetcher decorator that skips the paused targets.
+type pausingFetcher struct {
+	inner  Fetcher
+	paused *PausedTargets
+}
+
+// NewPausingFetcher wraps the given Fetcher so the paused targets are not
+// fetched.
+func NewPausingFetcher(inner Fetcher, paused *PausedTargets) Fetcher {
+	return &pausingFetcher{inner: inner, paused: paused}
+}
+
+func (pf *pausingFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	active := make([]endpoints.Target, 0, len(targets))
+	for i := range targets {
+		if !pf.paused.Paused(&targets[i]) {
+			active = append(active, targets[i])
+		}
+	}
+	if skipped := len(targets) - len(active); skipped > 0 {
+		ilog.Debugf("skipping %d paused targets", skipped)
+	}
+	return pf.inner.Fetch(ctx, active)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestPausingFetcher(t *testing.T) {
+	targets, err := endpoints.EndpointToTarget(endpoints.TargetConfig{URLs: []endpoints.TargetURL{
+		{URL: "http://a:9100/metrics"},
+		{URL: "http://b:9100/metrics"},
+		{URL: "http://c:9100/metrics"},
+	}})
+	require.NoError(t, err)
+
+	paused := NewPausedTargets()
+	paused.Pause("a:9100")
+	paused.Pause("http://c:9100/metrics")
+	inner := &fakeFetcher{}
+	fetcher := NewPausingFetcher(inner, paused)
+
+	fetcher.Fetch(context.Background(), targets)
+	require.Len(t, inner.fetched, 1)
+	assert.Equal(t, "b:9100", inner.fetched[0].Name)
+
+	assert.True(t, paused.Resume("a:9100"))
+	assert.False(t, paused.Resume("a:9100"))
+	assert.Equal(t, []string{"http://c:9100/metrics"}, paused.List())
+
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 2)
+}
+
+func TestTriggerScrape(t *testing.T) {
+	// Several triggers before the next cycle start a single one.
+	TriggerScrape()
+	TriggerScrape()
+	select {
+	case <-scrapeNow:
+	case <-time.After(time.Second):
+		t.Fatal("the scrape wasn't triggered")
+	}
+	select {
+	case <-scrapeNow:
+		t.Fatal("only one scrape should be pending")
+	default:
+	}
+}
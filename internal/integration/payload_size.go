@@ -0,0 +1,76 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// FetcherWithMaxPayloadSize makes the Fetcher fail the scrapes of the
+// payloads larger than the given number of bytes, once they are read up to
+// it, so a single target can't exhaust the memory of the parser. Zero
+// disables the limit.
+func FetcherWithMaxPayloadSize(size int64) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.maxPayloadSize = size
+	}
+}
+
+// withMaxPayloadSize returns the client limiting the size of the responses
+// of the target, if the fetcher limits them.
+func (pf *prometheusFetcher) withMaxPayloadSize(target string, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if pf.maxPayloadSize <= 0 {
+		return c
+	}
+	return maxPayloadSizeDoer{inner: c, target: target, size: pf.maxPayloadSize}
+}
+
+// maxPayloadSizeDoer limits the size of the bodies of the responses.
+type maxPayloadSizeDoer struct {
+	inner  prometheus.HTTPDoer
+	target string
+	size   int64
+}
+
+func (d maxPayloadSizeDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.inner.Do(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 300 {
+		return resp, err
+	}
+	if resp.ContentLength > d.size {
+		_ = resp.Body.Close()
+		oversizedPayloadsMetric.WithLabelValues(d.target).Inc()
+		return nil, fmt.Errorf("the payload of %d bytes is larger than the maximum of %d bytes", resp.ContentLength, d.size)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, target: d.target, left: d.size}
+	return resp, nil
+}
+
+// limitedBody fails the reads past the maximum size.
+type limitedBody struct {
+	io.ReadCloser
+	target string
+	left   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, fmt.Errorf("the payload is larger than the maximum size")
+	}
+	// One byte more than allowed is read to tell a payload of the maximum
+	// size from a larger one.
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if b.left < 0 {
+		oversizedPayloadsMetric.WithLabelValues(b.target).Inc()
+		return 0, fmt.Errorf("the payload is larger than the maximum size")
+	}
+	return n, err
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// Reasons the payloads are quarantined for.
+const (
+	quarantinePanic   = "panic"
+	quarantineTimeout = "timeout"
+)
+
+// ParserQuarantineConfig configures the capture of the payloads that make the
+// parser panic or time out, for offline analysis.
+type ParserQuarantineConfig struct {
+	// Dir is the directory the payloads are written to. Empty disables the
+	// capture.
+	Dir string `mapstructure:"dir"`
+	// Timeout is the maximum time decoding a payload. Defaults to 30s.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxBytes is the size the payloads are truncated to. Defaults to 1MiB.
+	MaxBytes int `mapstructure:"max_bytes"`
+	// MaxFiles is the maximum number of payloads in the directory, no more
+	// are written once it's reached. Defaults to 100.
+	MaxFiles int `mapstructure:"max_files"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// defaults.
+func (c *ParserQuarantineConfig) Validate() error {
+	if c.Timeout < 0 || c.MaxBytes < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("timeout, max_bytes and max_files can't be negative")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 1 << 20
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = 100
+	}
+	return nil
+}
+
+// ParserQuarantine decodes the payloads with a timeout, writing the ones that
+// make the parser panic or time out to its directory.
+type ParserQuarantine struct {
+	cfg ParserQuarantineConfig
+	log *logrus.Entry
+
+	mtx sync.Mutex
+}
+
+// NewParserQuarantine returns a ParserQuarantine with the validated
+// configuration, creating its directory.
+func NewParserQuarantine(cfg ParserQuarantineConfig) (*ParserQuarantine, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating the quarantine directory: %w", err)
+	}
+	return &ParserQuarantine{cfg: cfg, log: logrus.WithField("component", "ParserQuarantine")}, nil
+}
+
+// FetcherWithParserQuarantine makes the Fetcher decode the payloads with the
+// timeout of the ParserQuarantine, capturing the ones that make the parser
+// panic or time out. It must be set after the parse mode.
+func FetcherWithParserQuarantine(q *ParserQuarantine) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		decode := pf.decode
+		pf.decode = func(r io.Reader, url string) (prometheus.MetricFamiliesByName, error) {
+			payload, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return q.decode(payload, url, decode)
+		}
+		pf.getMetrics = func(httpClient prometheus.HTTPDoer, url string) (prometheus.MetricFamiliesByName, error) {
+			var payload bytes.Buffer
+			if err := pf.getPayload(httpClient, url, &payload); err != nil {
+				return nil, err
+			}
+			return pf.decode(&payload, url)
+		}
+	}
+}
+
+// decode decodes the payload of the target with the timeout. The decoding
+// that times out is abandoned, it keeps running until it finishes.
+func (q *ParserQuarantine) decode(payload []byte, url string, decode func(r io.Reader, url string) (prometheus.MetricFamiliesByName, error)) (prometheus.MetricFamiliesByName, error) {
+	type result struct {
+		mfs prometheus.MetricFamiliesByName
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		mfs, err := decode(bytes.NewReader(payload), url)
+		done <- result{mfs: mfs, err: err}
+	}()
+
+	timer := time.NewTimer(q.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if errors.Is(r.err, prometheus.ErrParserPanic) {
+			q.capture(url, quarantinePanic, payload)
+		}
+		return r.mfs, r.err
+	case <-timer.C:
+		q.capture(url, quarantineTimeout, payload)
+		return nil, fmt.Errorf("decoding the payload took longer than %v", q.cfg.Timeout)
+	}
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// capture writes the payload of the target, truncated, unless the directory
+// is full.
+func (q *ParserQuarantine) capture(url, reason string, payload []byte) {
+	quarantinedPayloadsMetric.WithLabelValues(url, reason).Inc()
+	log := q.log.WithField("target", url).WithField("reason", reason)
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	files, err := ioutil.ReadDir(q.cfg.Dir)
+	if err != nil {
+		log.WithError(err).Warn("can't quarantine the payload")
+		return
+	}
+	if len(files) >= q.cfg.MaxFiles {
+		log.Warnf("the payload isn't quarantined, %s already has %d files", q.cfg.Dir, len(files))
+		return
+	}
+
+	name := unsafeFileNameChars.ReplaceAllString(url, "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	path := filepath.Join(q.cfg.Dir, fmt.Sprintf("%d-%s-%s.prom", time.Now().UnixNano(), name, reason))
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "# target: %s\n# reason: %s\n# size: %d bytes\n", url, reason, len(payload))
+	if len(payload) > q.cfg.MaxBytes {
+		payload = payload[:q.cfg.MaxBytes]
+	}
+	content.Write(payload)
+	if err := ioutil.WriteFile(path, content.Bytes(), 0600); err != nil {
+		log.WithError(err).Warn("can't quarantine the payload")
+		return
+	}
+	log.Warnf("the parser failed, the payload is quarantined in %s", path)
+}
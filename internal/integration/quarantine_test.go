@@ -0,0 +1,101 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func newTestQuarantine(t *testing.T, dir string, cfg ParserQuarantineConfig) *ParserQuarantine {
+	t.Helper()
+	cfg.Dir = filepath.Join(dir, "quarantine")
+	require.NoError(t, cfg.Validate())
+	q, err := NewParserQuarantine(cfg)
+	require.NoError(t, err)
+	return q
+}
+
+func quarantined(t *testing.T, q *ParserQuarantine) []string {
+	t.Helper()
+	files, err := ioutil.ReadDir(q.cfg.Dir)
+	require.NoError(t, err)
+	var contents []string
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(q.cfg.Dir, f.Name()))
+		require.NoError(t, err)
+		contents = append(contents, string(b))
+	}
+	return contents
+}
+
+func TestParserQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quarantine")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	q := newTestQuarantine(t, dir, ParserQuarantineConfig{Timeout: 50 * time.Millisecond, MaxBytes: 4, MaxFiles: 2})
+	panicking := func(io.Reader, string) (prometheus.MetricFamiliesByName, error) {
+		return nil, fmt.Errorf("%w: index out of range", prometheus.ErrParserPanic)
+	}
+	blocking := func(io.Reader, string) (prometheus.MetricFamiliesByName, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	}
+
+	mfs, err := q.decode([]byte("up 1\n"), "http://target/metrics", decodePayload)
+	require.NoError(t, err)
+	assert.Contains(t, mfs, "up")
+	assert.Empty(t, quarantined(t, q), "the decoded payloads aren't captured")
+
+	_, err = q.decode([]byte("up 1\n"), "http://target/metrics", panicking)
+	assert.Error(t, err)
+	_, err = q.decode([]byte("up 1\n"), "http://target/metrics", blocking)
+	assert.EqualError(t, err, "decoding the payload took longer than 50ms")
+	_, err = q.decode([]byte("up 1\n"), "http://target/metrics", panicking)
+	assert.Error(t, err)
+
+	contents := quarantined(t, q)
+	require.Len(t, contents, 2, "no more than max_files are captured")
+	assert.Equal(t, "# target: http://target/metrics\n# reason: panic\n# size: 5 bytes\nup 1", contents[0])
+	assert.True(t, strings.HasPrefix(contents[1], "# target: http://target/metrics\n# reason: timeout\n"))
+}
+
+func TestFetcher_ParserQuarantine(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer ts.Close()
+	addr, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	targets := []endpoints.Target{endpoints.New("target", *addr, endpoints.Object{})}
+
+	dir, err := ioutil.TempDir("", "quarantine")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	q := newTestQuarantine(t, dir, ParserQuarantineConfig{})
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength,
+		FetcherWithLenientParsing(1), FetcherWithParserQuarantine(q))
+	var metrics []Metric
+	for pair := range fetcher.Fetch(context.Background(), targets) {
+		metrics = append(metrics, pair.Metrics...)
+	}
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "up", metrics[0].name)
+	assert.Empty(t, quarantined(t, q))
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// minRampFraction is the fraction of the targets fetched in the first cycles
+// of the ramp.
+const minRampFraction = 0.1
+
+// rampingFetcher is a Fetcher decorator that fetches a growing fraction of the
+// targets after the integration starts, so thousands of targets aren't all
+// fetched, and their metrics sent, at once.
+type rampingFetcher struct {
+	inner    Fetcher
+	duration time.Duration
+	now      func() time.Time
+
+	mtx   sync.Mutex
+	start time.Time
+	done  bool
+}
+
+// NewRampingFetcher wraps the given Fetcher so the fraction of the targets
+// fetched grows linearly, from a tenth of them in the first cycle to all of
+// them once the duration has elapsed since the first cycle.
+func NewRampingFetcher(inner Fetcher, duration time.Duration) Fetcher {
+	return &rampingFetcher{inner: inner, duration: duration, now: time.Now}
+}
+
+func (rf *rampingFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	fraction := rf.fraction()
+	if fraction >= 1 {
+		rampSkippedTargetsMetric.Set(0)
+		return rf.inner.Fetch(ctx, targets)
+	}
+
+	// The targets are chosen by the hash of their URL, so the ones fetched
+	// keep being fetched while more are added, and the ones of the same host
+	// are not fetched together.
+	type hashed struct {
+		target endpoints.Target
+		url    string
+		hash   uint32
+	}
+	sorted := make([]hashed, len(targets))
+	for i, t := range targets {
+		u := t.URL.String()
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(u))
+		sorted[i] = hashed{target: t, url: u, hash: h.Sum32()}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].hash != sorted[j].hash {
+			return sorted[i].hash < sorted[j].hash
+		}
+		return sorted[i].url < sorted[j].url
+	})
+	n := int(math.Ceil(fraction * float64(len(targets))))
+	ramped := make([]endpoints.Target, 0, n)
+	for _, h := range sorted[:n] {
+		ramped = append(ramped, h.target)
+	}
+	rampSkippedTargetsMetric.Set(float64(len(targets) - n))
+	ilog.Infof("ramping up after the start: fetching %d of %d targets", n, len(targets))
+	return rf.inner.Fetch(ctx, ramped)
+}
+
+// fraction returns the fraction of the targets fetched in the cycle starting
+// now. The ramp starts with the first cycle.
+func (rf *rampingFetcher) fraction() float64 {
+	rf.mtx.Lock()
+	defer rf.mtx.Unlock()
+	if rf.done {
+		return 1
+	}
+	now := rf.now()
+	if rf.start.IsZero() {
+		rf.start = now
+	}
+	fraction := float64(now.Sub(rf.start)) / float64(rf.duration)
+	if fraction >= 1 {
+		rf.done = true
+		ilog.Info("ramp up finished, fetching all the targets")
+		return 1
+	}
+	return math.Max(fraction, minRampFraction)
+}
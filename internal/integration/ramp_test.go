@@ -0,0 +1,58 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestRampingFetcher(t *testing.T) {
+	var targets []endpoints.Target
+	for i := 0; i < 100; i++ {
+		targets = append(targets, endpoints.Target{
+			Name: fmt.Sprintf("target-%d", i),
+			URL:  url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:9100", i), Path: "/metrics"},
+		})
+	}
+	names := func(targets []endpoints.Target) map[string]bool {
+		set := map[string]bool{}
+		for _, t := range targets {
+			set[t.Name] = true
+		}
+		return set
+	}
+
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	inner := &fakeFetcher{}
+	fetcher := NewRampingFetcher(inner, 10*time.Minute).(*rampingFetcher)
+	fetcher.now = func() time.Time { return now }
+
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 10, "a tenth of the targets are fetched in the first cycle")
+	first := names(inner.fetched)
+
+	now = start.Add(5 * time.Minute)
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 50)
+	half := names(inner.fetched)
+	for name := range first {
+		assert.True(t, half[name], "the targets fetched keep being fetched")
+	}
+
+	now = start.Add(10 * time.Minute)
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 100)
+
+	now = start
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 100, "the ramp doesn't start again")
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultRedactionReplacement replaces the redacted values when no
+// replacement is configured.
+const defaultRedactionReplacement = "[REDACTED]"
+
+// RedactionConfig redacts the sensitive values of the attributes of all the
+// metrics before they are emitted, regardless of the processing rules.
+type RedactionConfig struct {
+	// Names are regular expressions matching the whole names of the
+	// attributes whose values are replaced.
+	Names []string `mapstructure:"names"`
+	// Values are regular expressions whose matches in the values of any
+	// attribute are replaced, e.g. credit card numbers or tokens.
+	Values []string `mapstructure:"values"`
+	// Replacement of the redacted values. Defaults to [REDACTED].
+	Replacement string `mapstructure:"replacement"`
+
+	names  []*regexp.Regexp
+	values []*regexp.Regexp
+}
+
+// Enabled returns true if any pattern is configured.
+func (c RedactionConfig) Enabled() bool {
+	return len(c.Names) > 0 || len(c.Values) > 0
+}
+
+// Validate returns an error if any of the patterns is not valid, and sets
+// the default replacement.
+func (c *RedactionConfig) Validate() error {
+	if c.Replacement == "" {
+		c.Replacement = defaultRedactionReplacement
+	}
+	c.names = make([]*regexp.Regexp, 0, len(c.Names))
+	for _, p := range c.Names {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid name pattern %q: %w", p, err)
+		}
+		c.names = append(c.names, re)
+	}
+	c.values = make([]*regexp.Regexp, 0, len(c.Values))
+	for _, p := range c.Values {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid value pattern %q: %w", p, err)
+		}
+		c.values = append(c.values, re)
+	}
+	return nil
+}
+
+// RedactionProcessor wraps the given Processor, redacting the attributes of
+// the metrics it processed. It must wrap the processors changing the metrics,
+// so their results are redacted too.
+func RedactionProcessor(cfg RedactionConfig, next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		redacted := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(redacted)
+			for pair := range next(pairs) {
+				cfg.apply(pair.Metrics)
+				redacted <- pair
+			}
+		}()
+		return redacted
+	}
+}
+
+// apply redacts the string attributes of the metrics, first the ones whose
+// names match and then the matches of the value patterns in the rest.
+func (c RedactionConfig) apply(metrics []Metric) {
+	for i := range metrics {
+		changed := false
+		for name, v := range metrics[i].attributes {
+			value, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if redacted, ok := c.redact(name, value); ok {
+				metrics[i].attributes[name] = redacted
+				changed = true
+			}
+		}
+		if changed {
+			fingerprintMetrics(metrics[i : i+1])
+		}
+	}
+}
+
+// redact returns the redacted value of the attribute, and false if nothing
+// is redacted. Each redaction is counted by the pattern matching it.
+func (c RedactionConfig) redact(name, value string) (string, bool) {
+	for i, re := range c.names {
+		if re.MatchString(name) {
+			redactionsMetric.WithLabelValues("name", c.Names[i]).Inc()
+			return c.Replacement, true
+		}
+	}
+	redacted := false
+	for i, re := range c.values {
+		if !re.MatchString(value) {
+			continue
+		}
+		value = re.ReplaceAllLiteralString(value, c.Replacement)
+		redactionsMetric.WithLabelValues("value", c.Values[i]).Inc()
+		redacted = true
+	}
+	return value, redacted
+}
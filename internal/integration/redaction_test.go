@@ -0,0 +1,69 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestRedactionConfigValidate(t *testing.T) {
+	assert.False(t, RedactionConfig{}.Enabled())
+	assert.True(t, RedactionConfig{Values: []string{"secret"}}.Enabled())
+	assert.Error(t, (&RedactionConfig{Names: []string{"("}}).Validate())
+	assert.Error(t, (&RedactionConfig{Values: []string{"("}}).Validate())
+
+	cfg := RedactionConfig{Names: []string{"token"}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "[REDACTED]", cfg.Replacement)
+}
+
+func TestRedactionProcessor(t *testing.T) {
+	cfg := RedactionConfig{
+		Names:  []string{"(?i).*token"},
+		Values: []string{`\b\d{4}-\d{4}-\d{4}-\d{4}\b`},
+	}
+	require.NoError(t, cfg.Validate())
+	counter := func(kind, pattern string) float64 {
+		var m dto.Metric
+		require.NoError(t, redactionsMetric.WithLabelValues(kind, pattern).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	names, values := counter("name", cfg.Names[0]), counter("value", cfg.Values[0])
+
+	metric := Metric{name: "payments_total", attributes: labels.Set{
+		"authToken":    "abc",
+		"card":         "paid with 1234-5678-9012-3456",
+		"copied_token": "abc",
+		"status":       "ok",
+		"code":         200,
+	}}
+	metric.fingerprint = metric.Fingerprint()
+
+	pairs := make(chan TargetMetrics, 1)
+	pairs <- TargetMetrics{Metrics: []Metric{metric}}
+	close(pairs)
+	var processed []TargetMetrics
+	for pair := range RedactionProcessor(cfg, RuleProcessor(nil, 1), 1)(pairs) {
+		processed = append(processed, pair)
+	}
+
+	require.Len(t, processed, 1)
+	require.Len(t, processed[0].Metrics, 1)
+	m := processed[0].Metrics[0]
+	assert.Equal(t, labels.Set{
+		"authToken":    "[REDACTED]",
+		"card":         "paid with [REDACTED]",
+		"copied_token": "[REDACTED]",
+		"status":       "ok",
+		"code":         200,
+	}, m.attributes)
+	assert.Equal(t, labels.Fingerprint(m.name, m.attributes), m.fingerprint, "the fingerprint is updated")
+	assert.Equal(t, names+2, counter("name", cfg.Names[0]))
+	assert.Equal(t, values+1, counter("value", cfg.Values[0]))
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"compress/gzip"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// ReplayPayload is a payload in the exposition format replayed through the
+// pipeline.
+type ReplayPayload struct {
+	Target endpoints.Target
+	Path   string
+	// Time is when the payload was scraped. The metrics without timestamp
+	// are emitted with it.
+	Time time.Time
+}
+
+// ReplayPayloads returns the payloads in the directory, sorted by the time
+// they were scraped. The payloads in a subdirectory, like the ones of a
+// PayloadArchive, are of the target named after it, and the others of the
+// target named after the file. The scrape time is the one in the name of the
+// archived payloads, or the modification time of the other files. The files
+// whose names end with .gz are decompressed.
+func ReplayPayloads(dir string) ([]ReplayPayload, error) {
+	var payloads []ReplayPayload
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		if name == rel {
+			name = strings.SplitN(info.Name(), ".", 2)[0]
+		}
+		scraped, ok := ArchivedAt(info.Name())
+		if !ok {
+			scraped = info.ModTime()
+		}
+		target := endpoints.New(name, url.URL{Scheme: "file", Path: path}, endpoints.Object{Name: name, Kind: "replay"})
+		payloads = append(payloads, ReplayPayload{Target: target, Path: path, Time: scraped})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(payloads, func(i, j int) bool {
+		return payloads[i].Time.Before(payloads[j].Time)
+	})
+	return payloads, nil
+}
+
+// Replay decodes the payloads in order, as configured by the Fetcher
+// options, and emits their metrics once they are processed. Each payload is
+// accounted as a target in the result.
+func Replay(payloads []ReplayPayload, processor Processor, emitters []Emitter, queueLength int, opts ...FetcherOpt) CycleResult {
+	pf := NewFetcher(0, 0, 1, "", "", false, queueLength, opts...).(*prometheusFetcher)
+	pairs := make(chan TargetMetrics, queueLength)
+	go func() {
+		defer close(pairs)
+		for _, p := range payloads {
+			mfs, err := pf.decodeFile(p)
+			if err != nil {
+				pf.log.WithError(err).Warnf("decoding the replayed payload %s", p.Path)
+				continue
+			}
+			metrics := pf.convert(p.Target, mfs, p.Time)
+			for i := range metrics {
+				if metrics[i].timestamp.IsZero() {
+					metrics[i].timestamp = p.Time
+				}
+			}
+			pairs <- TargetMetrics{Target: p.Target, Metrics: metrics}
+		}
+	}()
+
+	result := CycleResult{Targets: len(payloads)}
+	for pair := range processor(pairs) {
+		result.Scraped++
+		for _, e := range emitters {
+			if err := e.Emit(pair.Metrics); err != nil {
+				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting metrics")
+				result.EmitErrors++
+			}
+		}
+	}
+	return result
+}
+
+// decodeFile decodes the payload from its file.
+func (pf *prometheusFetcher) decodeFile(p ReplayPayload) (prometheus.MetricFamiliesByName, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(p.Path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return pf.decode(r, p.Target.URL.String())
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeReplayPayloads writes the payloads of a target in the archive layout,
+// and one of an exporter, to a new directory the caller must remove.
+func writeReplayPayloads(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "replay")
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "app"), 0700))
+	f, err := os.Create(filepath.Join(dir, "app", "1600000060000"+ArchiveFileSuffix))
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte("# TYPE up gauge\nup 1\n# TYPE late gauge\nlate 2 1600000000000\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+
+	path := filepath.Join(dir, "exporter.prom")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# TYPE up gauge\nup 0\n"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Unix(1600000000, 0), time.Unix(1600000000, 0)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("up 2\n"), 0600))
+	return dir
+}
+
+func TestReplayPayloads(t *testing.T) {
+	dir := writeReplayPayloads(t)
+	defer os.RemoveAll(dir)
+	payloads, err := ReplayPayloads(dir)
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "exporter", payloads[0].Target.Name, "sorted by scrape time")
+	assert.Equal(t, time.Unix(1600000000, 0), payloads[0].Time)
+	assert.Equal(t, "app", payloads[1].Target.Name)
+	assert.Equal(t, time.Unix(1600000060, 0), payloads[1].Time)
+}
+
+func TestReplay(t *testing.T) {
+	dir := writeReplayPayloads(t)
+	defer os.RemoveAll(dir)
+	payloads, err := ReplayPayloads(dir)
+	require.NoError(t, err)
+	payloads = append(payloads, ReplayPayload{Target: payloads[0].Target, Path: "missing.prom"})
+
+	emitter := &recordingEmitter{}
+	result := Replay(payloads, RuleProcessor(nil, queueLength), []Emitter{emitter}, queueLength,
+		FetcherWithTimestampSkew(TimestampSkewConfig{MaxSkew: time.Minute, Action: TimestampSkewDrop}))
+	assert.Equal(t, CycleResult{Targets: 3, Scraped: 2}, result)
+	assert.False(t, result.Succeeded(), "the missing payload fails the replay")
+
+	emitted := emitter.emitted()
+	require.Len(t, emitted, 3)
+	assert.Equal(t, "up", emitted[0].name)
+	assert.Equal(t, 0.0, emitted[0].value)
+	assert.Equal(t, time.Unix(1600000000, 0), emitted[0].timestamp, "the scrape time of the payload")
+	timestamps := map[string]time.Time{}
+	for _, m := range emitted[1:] {
+		timestamps[m.name] = m.timestamp
+	}
+	assert.Equal(t, map[string]time.Time{
+		"up": time.Unix(1600000060, 0),
+		// The skew is checked against the scrape time.
+		"late": time.Unix(1600000000, 0),
+	}, timestamps)
+}
+
+func TestReplay_OldPayloads(t *testing.T) {
+	dir := writeReplayPayloads(t)
+	defer os.RemoveAll(dir)
+	payloads, err := ReplayPayloads(dir)
+	require.NoError(t, err)
+
+	// Given payloads scraped longer than the maximum age accepted by the
+	// Metric API ago
+	bounds := TimestampBoundsConfig{}
+	require.NoError(t, bounds.Validate())
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{
+		harvester:               h,
+		deltaCalculator:         newDeltaCalculator(),
+		timestampBounds:         bounds,
+		failOnDroppedTimestamps: true,
+	}
+
+	// The replay fails instead of dropping their metrics silently
+	result := Replay(payloads, RuleProcessor(nil, queueLength), []Emitter{te}, queueLength)
+	assert.Equal(t, CycleResult{Targets: 2, Scraped: 2, EmitErrors: 2}, result)
+	assert.False(t, result.Succeeded())
+	assert.Empty(t, h.metrics)
+}
@@ -1,6 +1,14 @@
 package integration
 
-import "net/http"
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
 
 // licenseKeyRoundTripper adds the infra license key to every request.
 type licenseKeyRoundTripper struct {
@@ -32,3 +40,115 @@ func newLicenseKeyRoundTripper(
 		rt:         rt,
 	}
 }
+
+// compressionRoundTripper compresses again the gzipped request bodies with
+// the given compression level, and records the compression self-metrics.
+type compressionRoundTripper struct {
+	level int
+	rt    http.RoundTripper
+}
+
+// RoundTrip replaces the body of the gzip encoded requests with the same
+// payload compressed with the configured level.
+func (t compressionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "gzip" {
+		return t.rt.RoundTrip(req)
+	}
+
+	start := time.Now()
+	body, uncompressedSize, err := recompress(req.Body, t.level)
+	if err != nil {
+		return nil, err
+	}
+	recompressionSecondsMetric.Add(time.Since(start).Seconds())
+	observePayloadSizes(uncompressedSize, len(body))
+	return t.rt.RoundTrip(withBody(req, body))
+}
+
+// payloadSizeRoundTripper records the compression self-metrics of the
+// gzipped request bodies, sent as they are.
+type payloadSizeRoundTripper struct {
+	rt http.RoundTripper
+}
+
+// RoundTrip records the sizes of the gzip encoded requests. The uncompressed
+// size is the one in the gzip trailer, so they aren't decompressed.
+func (t payloadSizeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "gzip" {
+		return t.rt.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// The last 4 bytes of a gzip member are its uncompressed size, modulo
+	// 2^32, which is far above the size of a payload.
+	if len(body) >= 4 {
+		observePayloadSizes(int64(binary.LittleEndian.Uint32(body[len(body)-4:])), len(body))
+	}
+	return t.rt.RoundTrip(withBody(req, body))
+}
+
+func observePayloadSizes(uncompressedSize int64, compressedSize int) {
+	payloadBytesMetric.WithLabelValues("uncompressed").Add(float64(uncompressedSize))
+	payloadBytesMetric.WithLabelValues("compressed").Add(float64(compressedSize))
+	if compressedSize > 0 {
+		compressionRatioMetric.Set(float64(uncompressedSize) / float64(compressedSize))
+	}
+}
+
+// withBody returns a copy of the request with the given body, as
+// RoundTrippers must not modify the original request.
+func withBody(req *http.Request, body []byte) *http.Request {
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req
+}
+
+// recompress decompresses the gzipped body and compresses it again with the
+// given level. It returns the new body and the uncompressed size.
+func recompress(body io.ReadCloser, level int) ([]byte, int64, error) {
+	defer body.Close()
+
+	gzr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var buf bytes.Buffer
+	gzw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(gzw, gzr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), n, nil
+}
+
+// newCompressionRoundTripper wraps the given http.RoundTripper to compress
+// the request bodies with the given gzip level. The bodies already
+// compressed with the default level aren't compressed again, only measured.
+func newCompressionRoundTripper(rt http.RoundTripper, level int) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	if level == gzip.DefaultCompression {
+		return payloadSizeRoundTripper{rt: rt}
+	}
+	return compressionRoundTripper{
+		level: level,
+		rt:    rt,
+	}
+}
@@ -1,11 +1,17 @@
 package integration
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockedRoundTripper struct {
@@ -34,3 +40,74 @@ func TestRoundTripHeaderDecoration(t *testing.T) {
 	_, _ = tr.RoundTrip(req)
 	rt.AssertExpectations(t)
 }
+
+func TestCompressionRoundTripper(t *testing.T) {
+	payload := strings.Repeat(`{"name":"some_metric","type":"gauge","value":1}`, 100)
+	var body bytes.Buffer
+	gzw, err := gzip.NewWriterLevel(&body, gzip.NoCompression)
+	require.NoError(t, err)
+	_, err = gzw.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	originalSize := body.Len()
+
+	req, err := http.NewRequest(http.MethodPost, "http://metrics", &body)
+	require.NoError(t, err)
+	req.Header.Add("Content-Encoding", "gzip")
+
+	var sent *http.Request
+	tr := newCompressionRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return emptyResponse(202), nil
+	}), gzip.BestCompression)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+
+	assert.Less(t, int(sent.ContentLength), originalSize)
+	gzr, err := gzip.NewReader(sent.Body)
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(gzr)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(decompressed))
+}
+
+func TestCompressionRoundTripperIgnoresUncompressedBodies(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://metrics", strings.NewReader("plain"))
+	require.NoError(t, err)
+
+	var sent *http.Request
+	tr := newCompressionRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return emptyResponse(202), nil
+	}), gzip.BestCompression)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, req, sent)
+}
+
+func TestCompressionRoundTripperDefaultLevel(t *testing.T) {
+	payload := strings.Repeat(`{"name":"some_metric","type":"gauge","value":1}`, 100)
+	var body bytes.Buffer
+	gzw := gzip.NewWriter(&body)
+	_, err := gzw.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	compressed := body.Bytes()
+
+	req, err := http.NewRequest(http.MethodPost, "http://metrics", bytes.NewReader(compressed))
+	require.NoError(t, err)
+	req.Header.Add("Content-Encoding", "gzip")
+
+	var sent []byte
+	tr := newCompressionRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent, err = ioutil.ReadAll(r.Body)
+		return emptyResponse(202), err
+	}), gzip.DefaultCompression)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, compressed, sent, "the payloads aren't compressed again")
+	var m dto.Metric
+	require.NoError(t, compressionRatioMetric.Write(&m))
+	assert.Equal(t, float64(len(payload))/float64(len(compressed)), m.GetGauge().GetValue())
+}
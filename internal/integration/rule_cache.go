@@ -0,0 +1,144 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// maxRuleMatches bounds the metric names whose matching rules are cached, so
+// the targets exposing ever new names don't make the cache grow without
+// limit. The cache is emptied when it's full.
+const maxRuleMatches = 100000
+
+// ruleMatches are the rules of a RuleSet matching the metrics with a given
+// name, which only depend on it.
+type ruleMatches struct {
+	ignored       bool
+	addAttributes []AddAttributesRule
+	decorate      []DecorateRule
+	rename        []RenameRule
+	// renamedTo is the name given by the RenameMetricRules.
+	renamedTo string
+}
+
+// ruleMatchCache caches the rules matching each metric name across the
+// scrape cycles, since the names are highly repetitive. It belongs to a
+// RuleSet, so reloading the rules invalidates it.
+type ruleMatchCache struct {
+	mtx     sync.RWMutex
+	matches map[string]*ruleMatches
+}
+
+var (
+	ruleCacheHits   = ruleCacheLookupsMetric.WithLabelValues("hit")
+	ruleCacheMisses = ruleCacheLookupsMetric.WithLabelValues("miss")
+)
+
+// matching returns the rules matching the metrics with the given name.
+func (rs *RuleSet) matching(name string) *ruleMatches {
+	rs.cache.mtx.RLock()
+	m, ok := rs.cache.matches[name]
+	rs.cache.mtx.RUnlock()
+	if ok {
+		ruleCacheHits.Inc()
+		return m
+	}
+	ruleCacheMisses.Inc()
+
+	m = &ruleMatches{
+		ignored:   ignoreRules(rs.ignoreRules).shouldIgnore(name),
+		decorate:  decorationDests(name, rs.decorateRules),
+		renamedTo: name,
+	}
+	for _, rr := range rs.addAttributesRules {
+		if strings.HasPrefix(name, rr.MetricPrefix) {
+			m.addAttributes = append(m.addAttributes, rr)
+		}
+	}
+	for _, rr := range rs.renameRules {
+		if strings.HasPrefix(name, rr.MetricPrefix) {
+			m.rename = append(m.rename, rr)
+		}
+	}
+	for _, rr := range rs.renameMetricRules {
+		if rr.ToMetric != "" && m.renamedTo == rr.FromMetric {
+			m.renamedTo = rr.ToMetric
+		}
+	}
+
+	rs.cache.mtx.Lock()
+	defer rs.cache.mtx.Unlock()
+	if rs.cache.matches == nil || len(rs.cache.matches) >= maxRuleMatches {
+		rs.cache.matches = map[string]*ruleMatches{}
+	}
+	rs.cache.matches[name] = m
+	return m
+}
+
+// filter is Filter with the cached ignore decisions.
+func (rs *RuleSet) filter(targetMetrics *TargetMetrics) {
+	if len(rs.ignoreRules) == 0 {
+		return
+	}
+	copied := make([]Metric, 0, len(targetMetrics.Metrics))
+	for _, m := range targetMetrics.Metrics {
+		if !rs.matching(m.name).ignored {
+			copied = append(copied, m)
+		} else {
+			DefaultDroppedSampler.Sample(DroppedByIgnoreRules, targetMetrics.Target.Name, m)
+		}
+	}
+	targetMetrics.Metrics = copied
+}
+
+// addAttributes is AddAttributes with the cached matching rules.
+func (rs *RuleSet) addAttributes(targetMetrics *TargetMetrics) {
+	if len(rs.addAttributesRules) == 0 {
+		return
+	}
+	for mi := range targetMetrics.Metrics {
+		for _, rr := range rs.matching(targetMetrics.Metrics[mi].name).addAttributes {
+			labels.Accumulate(targetMetrics.Metrics[mi].attributes, rr.Attributes)
+		}
+	}
+}
+
+// decorate is Decorate with the cached matching rules.
+func (rs *RuleSet) decorate(targetMetrics *TargetMetrics) {
+	if len(rs.decorateRules) > 0 {
+		copyAttributes(targetMetrics, decorationSources(targetMetrics, rs.decorateRules), func(name string) []DecorateRule {
+			return rs.matching(name).decorate
+		})
+	}
+	addTargetMetadata(targetMetrics)
+}
+
+// rename is Rename with the cached matching rules.
+func (rs *RuleSet) rename(targetMetrics *TargetMetrics) {
+	if len(rs.renameRules) == 0 {
+		return
+	}
+	for mi := range targetMetrics.Metrics {
+		for _, rr := range rs.matching(targetMetrics.Metrics[mi].name).rename {
+			for current, updated := range rr.Attributes {
+				if value, ok := targetMetrics.Metrics[mi].attributes[current]; ok {
+					targetMetrics.Metrics[mi].attributes[updated.(string)] = value
+				}
+			}
+		}
+	}
+}
+
+// renameMetrics is RenameMetrics with the cached names.
+func (rs *RuleSet) renameMetrics(targetMetrics *TargetMetrics) {
+	if len(rs.renameMetricRules) == 0 {
+		return
+	}
+	for mi := range targetMetrics.Metrics {
+		targetMetrics.Metrics[mi].name = rs.matching(targetMetrics.Metrics[mi].name).renamedTo
+	}
+}
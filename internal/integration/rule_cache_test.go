@@ -0,0 +1,59 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestRuleSet_CachedMatches(t *testing.T) {
+	misses := func() float64 {
+		var m dto.Metric
+		require.NoError(t, ruleCacheMisses.Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	pair := func() *TargetMetrics {
+		return &TargetMetrics{Metrics: []Metric{
+			{name: "go_goroutines", attributes: labels.Set{}},
+			{name: "http_requests_total", attributes: labels.Set{"code": "200", "pod": "web"}},
+			{name: "http_requests_total", attributes: labels.Set{"code": "500", "pod": "web"}},
+			{name: "app_info", attributes: labels.Set{"pod": "web", "version": "1.0"}},
+		}}
+	}
+	rules := []ProcessingRule{{
+		IgnoreMetrics:    []IgnoreRule{{Prefixes: []string{"go_"}}},
+		AddAttributes:    []AddAttributesRule{{MetricPrefix: "http_", Attributes: map[string]interface{}{"team": "payments"}}},
+		CopyAttributes:   []CopyAttributesRule{{FromMetric: "app_info", ToMetrics: []string{"http_"}, MatchBy: []string{"pod"}}},
+		RenameAttributes: []RenameRule{{MetricPrefix: "http_", Attributes: map[string]interface{}{"code": "status"}}},
+		RenameMetrics:    []RenameMetricRule{{FromMetric: "http_requests_total", ToMetric: "requests_total"}},
+	}}
+
+	rs := NewReloadableRuleSet(rules)
+	before := misses()
+	first := pair()
+	rs.Apply(first)
+	assert.Equal(t, before+3, misses(), "one miss per metric name")
+	second := pair()
+	rs.Apply(second)
+	assert.Equal(t, before+3, misses(), "the matches are cached across cycles")
+	assert.Equal(t, first.Metrics, second.Metrics)
+
+	require.Len(t, second.Metrics, 3)
+	assert.Equal(t, "requests_total", second.Metrics[0].name)
+	assert.Equal(t, labels.Set{"code": "200", "status": "200", "pod": "web", "team": "payments", "version": "1.0"},
+		second.Metrics[0].attributes)
+
+	// Reloading the rules invalidates the cache.
+	rules[0].RenameMetrics[0].ToMetric = "http_requests"
+	rs.Reload(rules)
+	reloaded := pair()
+	rs.Apply(reloaded)
+	assert.Equal(t, before+6, misses())
+	assert.Equal(t, "http_requests", reloaded.Metrics[0].name)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+// RuleMetadata are the fields shared by all the kinds of rules: a flag to
+// toggle the rule without deleting it, and free-form metadata attributing it
+// to the team owning it, which is shown in the rules report.
+type RuleMetadata struct {
+	// Enabled is false for the rules that are skipped. The rules are
+	// enabled when it isn't set.
+	Enabled *bool             `mapstructure:"enabled"`
+	Owner   string            `mapstructure:"owner"`
+	Labels  map[string]string `mapstructure:"labels"`
+}
+
+// IsEnabled returns true unless the rule is disabled.
+func (m RuleMetadata) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// enabled returns the processing rule without its disabled rules.
+func (pr ProcessingRule) enabled() ProcessingRule {
+	enabled := ProcessingRule{Description: pr.Description}
+	for _, r := range pr.AddAttributes {
+		if r.IsEnabled() {
+			enabled.AddAttributes = append(enabled.AddAttributes, r)
+		}
+	}
+	for _, r := range pr.RenameAttributes {
+		if r.IsEnabled() {
+			enabled.RenameAttributes = append(enabled.RenameAttributes, r)
+		}
+	}
+	for _, r := range pr.RenameMetrics {
+		if r.IsEnabled() {
+			enabled.RenameMetrics = append(enabled.RenameMetrics, r)
+		}
+	}
+	for _, r := range pr.IgnoreMetrics {
+		if r.IsEnabled() {
+			enabled.IgnoreMetrics = append(enabled.IgnoreMetrics, r)
+		}
+	}
+	for _, r := range pr.CopyAttributes {
+		if r.IsEnabled() {
+			enabled.CopyAttributes = append(enabled.CopyAttributes, r)
+		}
+	}
+	for _, r := range pr.HistogramBuckets {
+		if r.IsEnabled() {
+			enabled.HistogramBuckets = append(enabled.HistogramBuckets, r)
+		}
+	}
+	for _, r := range pr.DerivedMetrics {
+		if r.IsEnabled() {
+			enabled.DerivedMetrics = append(enabled.DerivedMetrics, r)
+		}
+	}
+	for _, r := range pr.MapValues {
+		if r.IsEnabled() {
+			enabled.MapValues = append(enabled.MapValues, r)
+		}
+	}
+	for _, r := range pr.FoldStates {
+		if r.IsEnabled() {
+			enabled.FoldStates = append(enabled.FoldStates, r)
+		}
+	}
+	return enabled
+}
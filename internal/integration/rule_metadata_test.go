@@ -0,0 +1,38 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestRuleSetSkipsDisabledRules(t *testing.T) {
+	disabled := false
+	rs := NewRuleSet([]ProcessingRule{
+		{
+			IgnoreMetrics: []IgnoreRule{
+				{Prefixes: []string{"go_"}, RuleMetadata: RuleMetadata{Enabled: &disabled, Owner: "team-a"}},
+				{Prefixes: []string{"process_"}},
+			},
+			RenameMetrics: []RenameMetricRule{
+				{FromMetric: "up", ToMetric: "target_up", RuleMetadata: RuleMetadata{Enabled: &disabled}},
+			},
+		},
+	})
+	pair := TargetMetrics{Metrics: []Metric{
+		{name: "go_goroutines", attributes: labels.Set{}},
+		{name: "process_open_fds", attributes: labels.Set{}},
+		{name: "up", attributes: labels.Set{}},
+	}}
+	rs.Apply(&pair)
+
+	var names []string
+	for _, m := range pair.Metrics {
+		names = append(names, m.name)
+	}
+	assert.Equal(t, []string{"go_goroutines", "up"}, names)
+}
@@ -0,0 +1,309 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RuleUsageReport tells which of the processing rules matched any of the
+// metrics scraped in the first cycles since they were loaded.
+type RuleUsageReport struct {
+	// Cycles is the number of cycles the rules were evaluated in.
+	Cycles int `json:"cycles"`
+	// Complete is true once all the cycles were evaluated.
+	Complete bool        `json:"complete"`
+	Rules    []RuleUsage `json:"rules"`
+}
+
+// RuleUsage tells if a processing rule matched any scraped metric.
+type RuleUsage struct {
+	// Transformation is the index of the processing rule in the
+	// transformations, and Description its description.
+	Transformation int    `json:"transformation"`
+	Description    string `json:"description,omitempty"`
+	// Kind is the kind of the rule, e.g. ignore_metrics, and Index its
+	// index in the rules of the kind of the processing rule.
+	Kind  string `json:"kind"`
+	Index int    `json:"index"`
+	// Enabled is false for the disabled rules, which are never matched.
+	Enabled bool              `json:"enabled"`
+	Owner   string            `json:"owner,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Matched bool              `json:"matched"`
+}
+
+// name identifies the rule in the logs.
+func (u RuleUsage) name() string {
+	name := fmt.Sprintf("transformations[%d].%s[%d]", u.Transformation, u.Kind, u.Index)
+	if u.Description != "" {
+		name += fmt.Sprintf(" (%s)", u.Description)
+	}
+	if u.Owner != "" {
+		name += fmt.Sprintf(" [owner: %s]", u.Owner)
+	}
+	return name
+}
+
+// ruleMatcher matches the metrics a rule applies to. A rule matches once each
+// of its parts matched any metric, e.g. both the source and the destination
+// of a copy_attributes rule.
+type ruleMatcher struct {
+	usage RuleUsage
+	parts []func(Metric) bool
+	// matched are the parts that matched any metric.
+	matched []bool
+}
+
+// RuleUsageTracker evaluates the active processing rules on the metrics
+// scraped in the first cycles after the rules are loaded or reloaded, and
+// warns about the ones that didn't match any metric, which are likely typos.
+// The derived_metrics rules match when all the metrics in their expressions
+// were scraped. The disabled rules are reported but not evaluated.
+type RuleUsageTracker struct {
+	active *ReloadableRuleSet
+	cycles int
+	log    *logrus.Entry
+
+	mtx sync.Mutex
+	// activeSet is the active RuleSet the matchers were prepared for.
+	activeSet *RuleSet
+	matchers  []*ruleMatcher
+	evaluated int
+}
+
+// NewRuleUsageTracker returns a RuleUsageTracker evaluating the active rules
+// during the given number of cycles.
+func NewRuleUsageTracker(active *ReloadableRuleSet, cycles int) *RuleUsageTracker {
+	return &RuleUsageTracker{
+		active: active,
+		cycles: cycles,
+		log:    logrus.WithField("component", "RuleUsage"),
+	}
+}
+
+// RuleUsageProcessor wraps the given Processor, evaluating the active rules
+// on the scraped metrics before they are processed. The unmatched rules are
+// reported once the cycles were evaluated.
+func RuleUsageProcessor(t *RuleUsageTracker, next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		observed := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(observed)
+			for pair := range pairs {
+				t.observe(pair.Metrics)
+				observed <- pair
+			}
+			t.commit()
+		}()
+		return next(observed)
+	}
+}
+
+// observe marks the rules matching the metrics, until the cycles were
+// evaluated.
+func (t *RuleUsageTracker) observe(metrics []Metric) {
+	active := t.active.current()
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if active != t.activeSet {
+		// The rules were loaded or reloaded.
+		t.activeSet = active
+		t.matchers = ruleMatchers(active.rules)
+		t.evaluated = 0
+	}
+	if t.evaluated >= t.cycles {
+		return
+	}
+	for _, rm := range t.matchers {
+		if !rm.usage.Enabled {
+			continue
+		}
+		for i, part := range rm.parts {
+			if rm.matched[i] {
+				continue
+			}
+			for _, m := range metrics {
+				if part(m) {
+					rm.matched[i] = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// commit ends the evaluation of a cycle, and warns about the unmatched rules
+// after the last one.
+func (t *RuleUsageTracker) commit() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.activeSet == nil || t.evaluated >= t.cycles {
+		return
+	}
+	t.evaluated++
+	if t.evaluated < t.cycles {
+		return
+	}
+	var unmatched []string
+	for _, rm := range t.matchers {
+		if rm.usage.Enabled && !rm.isMatched() {
+			unmatched = append(unmatched, rm.usage.name())
+		}
+	}
+	if len(unmatched) > 0 {
+		t.log.Warnf("processing rules that didn't match any metric in the first %d scrape cycles: %s", t.cycles, strings.Join(unmatched, ", "))
+		return
+	}
+	t.log.Infof("all the processing rules matched metrics in the first %d scrape cycles", t.cycles)
+}
+
+func (rm *ruleMatcher) isMatched() bool {
+	for _, matched := range rm.matched {
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Report returns which rules matched so far.
+func (t *RuleUsageTracker) Report() RuleUsageReport {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	report := RuleUsageReport{
+		Cycles:   t.evaluated,
+		Complete: t.activeSet != nil && t.evaluated >= t.cycles,
+		Rules:    make([]RuleUsage, 0, len(t.matchers)),
+	}
+	for _, rm := range t.matchers {
+		usage := rm.usage
+		usage.Matched = rm.usage.Enabled && rm.isMatched()
+		report.Rules = append(report.Rules, usage)
+	}
+	return report
+}
+
+// ServeHTTP returns the report as JSON.
+func (t *RuleUsageTracker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t.Report())
+}
+
+// ruleMatchers returns the matchers of all the rules, in the order they are
+// configured.
+func ruleMatchers(rules []ProcessingRule) []*ruleMatcher {
+	var matchers []*ruleMatcher
+	for ti, pr := range rules {
+		add := func(kind string, index int, meta RuleMetadata, parts ...func(Metric) bool) {
+			matchers = append(matchers, &ruleMatcher{
+				usage: RuleUsage{
+					Transformation: ti,
+					Description:    pr.Description,
+					Kind:           kind,
+					Index:          index,
+					Enabled:        meta.IsEnabled(),
+					Owner:          meta.Owner,
+					Labels:         meta.Labels,
+				},
+				parts:   parts,
+				matched: make([]bool, len(parts)),
+			})
+		}
+		for i, r := range pr.AddAttributes {
+			add("add_attributes", i, r.RuleMetadata, withPrefix(r.MetricPrefix))
+		}
+		for i, r := range pr.RenameAttributes {
+			add("rename_attributes", i, r.RuleMetadata, withPrefix(r.MetricPrefix))
+		}
+		for i, r := range pr.RenameMetrics {
+			add("rename_metrics", i, r.RuleMetadata, withName(r.FromMetric))
+		}
+		for i, r := range pr.IgnoreMetrics {
+			add("ignore_metrics", i, r.RuleMetadata, ignoredBy(r))
+		}
+		for i, r := range pr.CopyAttributes {
+			dest := withPrefix(r.ToMetrics...)
+			if r.FromMetric == "" {
+				// The attributes are copied from the target.
+				add("copy_attributes", i, r.RuleMetadata, dest)
+			} else {
+				add("copy_attributes", i, r.RuleMetadata, withName(r.FromMetric), dest)
+			}
+		}
+		for i, r := range pr.HistogramBuckets {
+			add("histogram_buckets", i, r.RuleMetadata, withPrefix(r.MetricPrefix))
+		}
+		for i, r := range pr.DerivedMetrics {
+			expr, err := parseExpression(r.Expression)
+			if err != nil {
+				continue
+			}
+			refs := map[string]bool{}
+			expr.metrics(refs)
+			var parts []func(Metric) bool
+			for name := range refs {
+				parts = append(parts, withName(name))
+			}
+			add("derived_metrics", i, r.RuleMetadata, parts...)
+		}
+		for i, r := range pr.MapValues {
+			prefix, attribute := withPrefix(r.MetricPrefix), r.Attribute
+			add("map_values", i, r.RuleMetadata, func(m Metric) bool {
+				_, ok := m.attributes[attribute]
+				return ok && prefix(m)
+			})
+		}
+		for i, r := range pr.FoldStates {
+			add("fold_states", i, r.RuleMetadata, withName(r.Metric))
+		}
+	}
+	return matchers
+}
+
+// withPrefix matches the metrics whose name starts with any of the prefixes.
+func withPrefix(prefixes ...string) func(Metric) bool {
+	return func(m Metric) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(m.name, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// withName matches the metrics with the given name.
+func withName(name string) func(Metric) bool {
+	return func(m Metric) bool {
+		return m.name == name
+	}
+}
+
+// ignoredBy matches the metrics the rule ignores on its own.
+func ignoredBy(r IgnoreRule) func(Metric) bool {
+	rules := ignoreRules{{Prefixes: r.Prefixes, Except: r.Except}}
+	var selectors []seriesSelector
+	for _, s := range r.Series {
+		if selector, err := parseSeriesSelector(s); err == nil {
+			selectors = append(selectors, selector)
+		}
+	}
+	return func(m Metric) bool {
+		if (len(r.Prefixes) > 0 || len(r.Except) > 0) && rules.shouldIgnore(m.name) {
+			return true
+		}
+		for _, s := range selectors {
+			if s.matches(m) {
+				return true
+			}
+		}
+		return false
+	}
+}
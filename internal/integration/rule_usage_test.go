@@ -0,0 +1,111 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestRuleUsageTracker(t *testing.T) {
+	disabled := false
+	rules := []ProcessingRule{
+		{
+			Description: "used",
+			AddAttributes: []AddAttributesRule{
+				{MetricPrefix: "http_", Attributes: map[string]interface{}{"team": "web"}},
+			},
+			IgnoreMetrics: []IgnoreRule{
+				{Series: []string{`go_info{version="go1.15"}`}},
+			},
+			DerivedMetrics: []DerivedMetricRule{
+				{Name: "ratio", Expression: "http_errors_total / http_requests_total"},
+			},
+		},
+		{
+			Description: "typos",
+			RenameMetrics: []RenameMetricRule{
+				{FromMetric: "http_request_total", ToMetric: "requests", RuleMetadata: RuleMetadata{Owner: "team-web"}},
+				{FromMetric: "go_info", ToMetric: "go", RuleMetadata: RuleMetadata{Enabled: &disabled}},
+			},
+			CopyAttributes: []CopyAttributesRule{
+				{FromMetric: "kube_pod_info", ToMetrics: []string{"http_"}, MatchBy: []string{"pod"}},
+			},
+			DerivedMetrics: []DerivedMetricRule{
+				{Name: "missing", Expression: "http_requests_total / http_responses_total"},
+			},
+		},
+	}
+	ruleSet := NewReloadableRuleSet(rules)
+	tracker := NewRuleUsageTracker(ruleSet, 2)
+	processor := RuleUsageProcessor(tracker, RuleProcessor(nil, 1), 1)
+	cycle := func(metrics ...Metric) {
+		pairs := make(chan TargetMetrics, 1)
+		pairs <- TargetMetrics{Metrics: metrics}
+		close(pairs)
+		for range processor(pairs) {
+		}
+	}
+	matched := func() map[string]bool {
+		m := map[string]bool{}
+		for _, u := range tracker.Report().Rules {
+			m[u.name()] = u.Matched
+		}
+		return m
+	}
+
+	cycle(
+		Metric{name: "http_requests_total", attributes: labels.Set{}},
+		Metric{name: "go_info", attributes: labels.Set{"version": "go1.15"}},
+	)
+	report := tracker.Report()
+	assert.Equal(t, 1, report.Cycles)
+	assert.False(t, report.Complete)
+
+	cycle(Metric{name: "http_errors_total", attributes: labels.Set{}})
+	report = tracker.Report()
+	assert.Equal(t, 2, report.Cycles)
+	assert.True(t, report.Complete)
+	assert.Equal(t, map[string]bool{
+		"transformations[0].add_attributes[0] (used)":                    true,
+		"transformations[0].ignore_metrics[0] (used)":                    true,
+		"transformations[0].derived_metrics[0] (used)":                   true,
+		"transformations[1].rename_metrics[0] (typos) [owner: team-web]": false,
+		"transformations[1].rename_metrics[1] (typos)":                   false,
+		"transformations[1].copy_attributes[0] (typos)":                  false,
+		"transformations[1].derived_metrics[0] (typos)":                  false,
+	}, matched())
+	assert.False(t, report.Rules[4].Enabled, "the disabled rules are reported")
+	assert.Equal(t, "team-web", report.Rules[3].Owner)
+
+	// The metrics scraped after the evaluation aren't matched.
+	cycle(Metric{name: "kube_pod_info", attributes: labels.Set{}})
+	assert.False(t, matched()["transformations[1].copy_attributes[0] (typos)"])
+
+	// The reloaded rules are evaluated again.
+	ruleSet.Reload(rules[1:])
+	cycle(
+		Metric{name: "kube_pod_info", attributes: labels.Set{}},
+		Metric{name: "http_requests_total", attributes: labels.Set{}},
+	)
+	report = tracker.Report()
+	assert.Equal(t, 1, report.Cycles)
+	assert.Equal(t, map[string]bool{
+		"transformations[0].rename_metrics[0] (typos) [owner: team-web]": false,
+		"transformations[0].rename_metrics[1] (typos)":                   false,
+		"transformations[0].copy_attributes[0] (typos)":                  true,
+		"transformations[0].derived_metrics[0] (typos)":                  false,
+	}, matched())
+
+	rec := httptest.NewRecorder()
+	tracker.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/rules", nil))
+	var served RuleUsageReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, report, served)
+}
@@ -5,8 +5,11 @@ package integration
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
 	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
 )
 
@@ -14,11 +17,15 @@ import (
 // be applied to metrics.
 type ProcessingRule struct {
 	Description      string
-	AddAttributes    []AddAttributesRule  `mapstructure:"add_attributes"`
-	RenameAttributes []RenameRule         `mapstructure:"rename_attributes"`
-	RenameMetrics    []RenameMetricRule   `mapstructure:"rename_metrics"`
-	IgnoreMetrics    []IgnoreRule         `mapstructure:"ignore_metrics"`
-	CopyAttributes   []CopyAttributesRule `mapstructure:"copy_attributes"`
+	AddAttributes    []AddAttributesRule    `mapstructure:"add_attributes"`
+	RenameAttributes []RenameRule           `mapstructure:"rename_attributes"`
+	RenameMetrics    []RenameMetricRule     `mapstructure:"rename_metrics"`
+	IgnoreMetrics    []IgnoreRule           `mapstructure:"ignore_metrics"`
+	CopyAttributes   []CopyAttributesRule   `mapstructure:"copy_attributes"`
+	HistogramBuckets []HistogramBucketsRule `mapstructure:"histogram_buckets"`
+	DerivedMetrics   []DerivedMetricRule    `mapstructure:"derived_metrics"`
+	MapValues        []ValueMappingRule     `mapstructure:"map_values"`
+	FoldStates       []StateFoldingRule     `mapstructure:"fold_states"`
 }
 
 // RenameRule is a rule for changing the name of attributes of metrics that
@@ -27,25 +34,116 @@ type ProcessingRule struct {
 type RenameRule struct {
 	MetricPrefix string                 `mapstructure:"metric_prefix"`
 	Attributes   map[string]interface{} `mapstructure:"attributes"`
+
+	RuleMetadata `mapstructure:",squash"`
 }
 
 // IgnoreRule skips for processing metrics that match any of the Prefixes.
 // Metrics that match any of the Except are never skipped.
 // If Prefixes is empty and Except is not, then all metrics that do not
 // match Except will be skipped.
+// The series selected by any of the Series, which are metric names with
+// label matchers like node_filesystem_avail_bytes{mountpoint=~"/var/.*"},
+// are also skipped, regardless of Except.
 type IgnoreRule struct {
 	Prefixes []string `mapstructure:"prefixes"`
 	Except   []string `mapstructure:"except"`
+	Series   []string `mapstructure:"series"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if any of the series selectors can't be parsed.
+func (r IgnoreRule) Validate() error {
+	for _, s := range r.Series {
+		if _, err := parseSeriesSelector(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CopyAttributesRule is a rule that copies the Attributes from the metric that
 // matches FromMetric to the metrics that matches (as prefix) with ToMetrics
 // only if both have the same values for all the labels defined in MatchBy.
+// A label of MatchBy named differently in both is given as
+// from_label=to_label, e.g. instance=node. The values are compared after
+// being transformed by the Normalize functions, in order: lowercase,
+// trim_port or strip_scheme. OnConflict decides which value is kept when an
+// attribute is copied from several sources with different values: first
+// (default), last or suffix.
+// Instead of a metric, the source can be the target itself: FromTarget maps
+// the names of the attributes to copy to references of the fields of the
+// target, like metadata.annotations['app.kubernetes.io/version'] (see
+// endpoints.Target.Field).
 type CopyAttributesRule struct {
-	FromMetric string   `mapstructure:"from_metric"`
-	ToMetrics  []string `mapstructure:"to_metrics"`
-	MatchBy    []string `mapstructure:"match_by"`
-	Attributes []string `mapstructure:"attributes"`
+	FromMetric string            `mapstructure:"from_metric"`
+	FromTarget map[string]string `mapstructure:"from_target"`
+	ToMetrics  []string          `mapstructure:"to_metrics"`
+	MatchBy    []string          `mapstructure:"match_by"`
+	Attributes []string          `mapstructure:"attributes"`
+	Normalize  []string          `mapstructure:"normalize"`
+	OnConflict string            `mapstructure:"on_conflict"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if any of the normalizers doesn't exist, or if
+// the rule has both a source metric and target fields.
+func (r CopyAttributesRule) Validate() error {
+	if r.FromMetric != "" && len(r.FromTarget) > 0 {
+		return fmt.Errorf("from_metric and from_target can't be both set")
+	}
+	for name, ref := range r.FromTarget {
+		if err := endpoints.ValidateField(ref); err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+	}
+	for _, n := range r.Normalize {
+		if _, ok := labels.Normalizers[n]; !ok {
+			return fmt.Errorf("unknown normalizer %q", n)
+		}
+	}
+	for _, mk := range r.MatchBy {
+		if from, to := splitMatchBy(mk); from == "" || to == "" {
+			return fmt.Errorf("invalid match_by label %q", mk)
+		}
+	}
+	switch r.OnConflict {
+	case "", DecorationFirstWins, DecorationLastWins, DecorationSuffix:
+	default:
+		return fmt.Errorf("invalid on_conflict policy %q, must be one of: %s, %s, %s", r.OnConflict,
+			DecorationFirstWins, DecorationLastWins, DecorationSuffix)
+	}
+	return nil
+}
+
+// splitMatchBy returns the names of a MatchBy label in the source and
+// destination metrics.
+func splitMatchBy(mk string) (string, string) {
+	if i := strings.Index(mk, "="); i >= 0 {
+		return mk[:i], mk[i+1:]
+	}
+	return mk, mk
+}
+
+// TargetAnnotations returns the keys of the annotations the rules copy from
+// the targets, which the retrievers must keep.
+func TargetAnnotations(processingRules []ProcessingRule) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, pr := range processingRules {
+		for _, car := range pr.enabled().CopyAttributes {
+			for _, ref := range car.FromTarget {
+				if key, ok := endpoints.AnnotationField(ref); ok && !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // AddAttributesRule adds the Attributes to the metrics that match with
@@ -53,6 +151,8 @@ type CopyAttributesRule struct {
 type AddAttributesRule struct {
 	MetricPrefix string                 `mapstructure:"metric_prefix"`
 	Attributes   map[string]interface{} `mapstructure:"attributes"`
+
+	RuleMetadata `mapstructure:",squash"`
 }
 
 // A RenameMetricRule defines a rule to allow a metric to have its name
@@ -60,6 +160,8 @@ type AddAttributesRule struct {
 type RenameMetricRule struct {
 	FromMetric string `mapstructure:"from_metric"`
 	ToMetric   string `mapstructure:"to_metric"`
+
+	RuleMetadata `mapstructure:",squash"`
 }
 
 // AutoDecorateLabels mixes automatically all the "_info" labels within the other metrics, when correspond, according to
@@ -88,23 +190,7 @@ type RenameMetricRule struct {
 //     stuff_metric{os="linux", version.stuff_info="1.2.3", id.stuff_info="12345", version.thing_info="3.3.3", id.thing_info="4432"}
 //
 func AutoDecorateLabels(targetMetrics *TargetMetrics) {
-	// Get all the labels from the _info metrics
-	infos := make([]labels.InfoSource, 0)
-	for _, metric := range targetMetrics.Metrics {
-		if strings.HasSuffix(metric.name, "_info") {
-			infos = append(infos, labels.InfoSource{
-				Name:   metric.name,
-				Labels: metric.attributes,
-			})
-		}
-	}
-
-	// For any other non-info metric, try to consolidate the info labels, when apply
-	for _, metric := range targetMetrics.Metrics {
-		if !strings.HasSuffix(metric.name, "_info") {
-			labels.Accumulate(metric.attributes, labels.ToAdd(infos, metric.attributes))
-		}
-	}
+	AutoDecorateConfig{Format: defaultAutoDecorateFormat}.apply(targetMetrics)
 }
 
 // DecorateRule specifies a label decoration rule: a Source metric may decorate a set of Dest metrics if they have in common
@@ -114,8 +200,42 @@ type DecorateRule struct {
 	Dest       []string   // destination metrics names
 	Join       labels.Set // Join labels: values of this set are ignored, it's only to mark the label names
 	Attributes labels.Set // Only attributes here will be copied. If empty: all the attributes are copied
+	// JoinOptions configures the comparison of the Join labels
+	JoinOptions labels.JoinOptions
+	// OnConflict is the policy when an attribute was copied from another
+	// source with a different value: DecorationFirstWins (default),
+	// DecorationLastWins or DecorationSuffix
+	OnConflict string
+	// TargetFields maps the names of the attributes to the fields of the
+	// target they are copied from. The rules with them have no Source.
+	TargetFields map[string]string
+}
+
+// targetSource is the source of the attributes copied from the fields of the
+// target, when their names are suffixed after a conflict.
+const targetSource = "target"
+
+// source returns the name of the source of the attributes the rule copies.
+func (r DecorateRule) source() string {
+	if len(r.TargetFields) > 0 {
+		return targetSource
+	}
+	return r.Source
 }
 
+// Policies of the DecorateRules when an attribute was copied from several
+// sources with different values. The sources are applied in the order of the
+// rules, and the series of each source in the order of their labels.
+const (
+	// DecorationFirstWins keeps the value copied first.
+	DecorationFirstWins = "first"
+	// DecorationLastWins keeps the value copied last.
+	DecorationLastWins = "last"
+	// DecorationSuffix adds all the values, with the name of the attribute
+	// suffixed with the one of their source metric, as AutoDecorateLabels.
+	DecorationSuffix = "suffix"
+)
+
 // CopyAttributes decorate the labels of an entity
 func CopyAttributes(targetMetrics *TargetMetrics, rules []DecorateRule) {
 
@@ -125,20 +245,36 @@ func CopyAttributes(targetMetrics *TargetMetrics, rules []DecorateRule) {
 	}
 
 	dc := MatchingDecorate(targetMetrics, rules)
+	copyAttributes(targetMetrics, dc.SourceLabels, func(name string) []DecorateRule {
+		return dc.Dests[name]
+	})
+}
+
+// copyAttributes decorates the metrics with the labels of the sources, as
+// the rules returned by dests for their names specify.
+func copyAttributes(targetMetrics *TargetMetrics, sourceLabels map[string][]labels.Set, dests func(name string) []DecorateRule) {
 	for _, metrics := range targetMetrics.Metrics {
 		// Gets the decoration rules where the entity is "destination" of labels
-		dstRules, ok := dc.Dests[metrics.name]
-		if !ok {
+		dstRules := dests(metrics.name)
+		if len(dstRules) == 0 {
 			continue
 		}
+		d := decoration{attributes: metrics.attributes, sources: map[string]string{}}
 		for _, rule := range dstRules {
-			srcAllLabels := dc.SourceLabels[rule.Source]
+			for name, ref := range rule.TargetFields {
+				if v, ok := targetMetrics.Target.Field(ref); ok {
+					d.add(name, v, rule)
+				}
+			}
+			srcAllLabels := sourceLabels[rule.Source]
 			for _, srcLabels := range srcAllLabels {
-				if toAdd, ok := labels.Join(srcLabels, metrics.attributes, rule.Join); ok {
-					if len(rule.Attributes) > 0 {
-						labels.AccumulateOnly(metrics.attributes, toAdd, rule.Attributes)
-					} else {
-						labels.Accumulate(metrics.attributes, toAdd)
+				toAdd, ok := labels.JoinWith(srcLabels, metrics.attributes, rule.Join, rule.JoinOptions)
+				if !ok {
+					continue
+				}
+				for k, v := range toAdd {
+					if _, ok := rule.Attributes[k]; ok || len(rule.Attributes) == 0 {
+						d.add(k, v, rule)
 					}
 				}
 			}
@@ -146,6 +282,56 @@ func CopyAttributes(targetMetrics *TargetMetrics, rules []DecorateRule) {
 	}
 }
 
+// decoration adds the attributes of the source metrics to a destination
+// metric, resolving the conflicts between the sources.
+type decoration struct {
+	attributes labels.Set
+	// sources are the source metrics that set the attributes, and "" for
+	// the conflicting attributes suffixed with their sources.
+	sources map[string]string
+}
+
+// add adds the attribute from the source of the rule. The attributes of the
+// destination that were not copied from a source are never replaced. When
+// the attribute was copied from another source with a different value, the
+// policy of the rule is applied.
+func (d decoration) add(name string, value interface{}, rule DecorateRule) {
+	current, exists := d.attributes[name]
+	source, decorated := d.sources[name]
+	switch {
+	case !exists && decorated:
+		// The conflicting values are suffixed with their sources.
+		if _, ok := d.attributes[name+"."+rule.source()]; !ok {
+			d.attributes[name+"."+rule.source()] = value
+		}
+		return
+	case !exists:
+		d.attributes[name] = value
+		d.sources[name] = rule.source()
+		return
+	case !decorated || current == value:
+		return
+	}
+	policy := rule.OnConflict
+	if policy == "" {
+		policy = DecorationFirstWins
+	}
+	decorationConflictsMetric.WithLabelValues(policy).Inc()
+	switch policy {
+	case DecorationLastWins:
+		d.attributes[name] = value
+		d.sources[name] = rule.source()
+	case DecorationSuffix:
+		if source == rule.source() {
+			return
+		}
+		delete(d.attributes, name)
+		d.attributes[name+"."+source] = current
+		d.attributes[name+"."+rule.source()] = value
+		d.sources[name] = ""
+	}
+}
+
 // DecorationMap is an intermediate rules representation that allows accessing in hashtable-complexity from destination
 // metrics to the source metrics that may decorate them
 type DecorationMap struct {
@@ -158,38 +344,73 @@ type DecorationMap struct {
 func MatchingDecorate(targetMetrics *TargetMetrics, rules []DecorateRule) DecorationMap {
 	dc := DecorationMap{
 		Dests:        map[string][]DecorateRule{},
-		SourceLabels: map[string][]labels.Set{},
+		SourceLabels: decorationSources(targetMetrics, rules),
 	}
 
-	sources := map[string][]DecorateRule{}
+	// Maps all the destination entries to their belonging rules
+	for _, m := range targetMetrics.Metrics {
+		if _, ok := dc.Dests[m.name]; ok {
+			continue
+		}
+		if dests := decorationDests(m.name, rules); len(dests) > 0 {
+			dc.Dests[m.name] = dests
+		}
+	}
+	return dc
+}
 
-	// Maps all the source and destination entries to their belonging rules
+// decorationDests returns the rules that have as destination the metric with
+// the given name.
+func decorationDests(name string, rules []DecorateRule) []DecorateRule {
+	var dests []DecorateRule
 	for i := range rules {
+		// this iteration level allows decorate based on prefix
 		for _, destPrefix := range rules[i].Dest {
-
-			duplicatedMetrics := map[string]interface{}{} // avoids adding twice the same rule to the same metric
-
-			// this iteration level allows decorate based on prefix
-			for _, m := range targetMetrics.Metrics {
-				if _, ok := duplicatedMetrics[m.name]; !ok {
-					duplicatedMetrics[m.name] = true
-					if strings.HasPrefix(m.name, destPrefix) {
-						appendDecorate(dc.Dests, m.name, rules[i])
-					}
-				}
+			if strings.HasPrefix(name, destPrefix) {
+				dests = append(dests, rules[i])
+				break
 			}
 		}
-		appendDecorate(sources, rules[i].Source, rules[i])
+	}
+	return dests
+}
+
+// decorationSources returns the labels of all the metrics that are sources of
+// the rules, by metric name.
+func decorationSources(targetMetrics *TargetMetrics, rules []DecorateRule) map[string][]labels.Set {
+	sourceLabels := map[string][]labels.Set{}
+	sources := map[string][]DecorateRule{}
+	for i := range rules {
+		if len(rules[i].TargetFields) == 0 {
+			appendDecorate(sources, rules[i].Source, rules[i])
+		}
 	}
 
 	// Caches the labels from all the metrics that are marked as source
 	for i := range targetMetrics.Metrics {
 		if _, ok := sources[targetMetrics.Metrics[i].name]; ok {
-			appendLabels(dc.SourceLabels, targetMetrics.Metrics[i].name, targetMetrics.Metrics[i].attributes)
+			appendLabels(sourceLabels, targetMetrics.Metrics[i].name, targetMetrics.Metrics[i].attributes)
 		}
 	}
-
-	return dc
+	// Sorts the labels of each source, for the conflicts to be resolved the
+	// same way whatever the order of the scraped metrics.
+	for _, ls := range sourceLabels {
+		if len(ls) < 2 {
+			continue
+		}
+		keyed := make([]struct {
+			set labels.Set
+			key string
+		}, len(ls))
+		for i, set := range ls {
+			keyed[i].set, keyed[i].key = set, labelsKey(set)
+		}
+		sort.SliceStable(keyed, func(i, j int) bool { return keyed[i].key < keyed[j].key })
+		for i := range keyed {
+			ls[i] = keyed[i].set
+		}
+	}
+	return sourceLabels
 }
 
 // appends a rule to the map with a given key, creating or updating the slice when necessary
@@ -217,6 +438,11 @@ func appendLabels(m map[string][]labels.Set, key string, ls labels.Set) {
 // Decorate merges the entity and metrics metadata into each metric label
 func Decorate(targetMetrics *TargetMetrics, decorateRules []DecorateRule) {
 	CopyAttributes(targetMetrics, decorateRules)
+	addTargetMetadata(targetMetrics)
+}
+
+// addTargetMetadata adds the metadata of the target to its metrics.
+func addTargetMetadata(targetMetrics *TargetMetrics) {
 	for mi := range targetMetrics.Metrics {
 		labels.Accumulate(targetMetrics.Metrics[mi].attributes, targetMetrics.Target.Metadata())
 	}
@@ -279,6 +505,22 @@ func AddAttributes(targetMetrics *TargetMetrics, rules []AddAttributesRule) {
 	}
 }
 
+// AddClusterName adds the name of the cluster of the target, if any, to its
+// metrics. It must be applied before the AddAttributes rules, which add the
+// cluster name of the integration, to take precedence over it.
+func AddClusterName(targetMetrics *TargetMetrics) {
+	clusterName := targetMetrics.Target.ClusterName
+	if clusterName == "" {
+		return
+	}
+	for mi := range targetMetrics.Metrics {
+		labels.Accumulate(targetMetrics.Metrics[mi].attributes, labels.Set{
+			"clusterName":      clusterName,
+			"k8s.cluster.name": clusterName,
+		})
+	}
+}
+
 type ignoreRules []IgnoreRule
 
 func (rules ignoreRules) shouldIgnore(name string) bool {
@@ -319,6 +561,8 @@ func Filter(targetMetrics *TargetMetrics, rules ignoreRules) {
 	for _, m := range targetMetrics.Metrics {
 		if !rules.shouldIgnore(m.name) {
 			copied = append(copied, m)
+		} else {
+			DefaultDroppedSampler.Sample(DroppedByIgnoreRules, targetMetrics.Target.Name, m)
 		}
 	}
 	targetMetrics.Metrics = copied
@@ -328,10 +572,10 @@ func Filter(targetMetrics *TargetMetrics, rules ignoreRules) {
 // as configured for the URL they were fetched from.
 func ReNamespaceMetrics(targetMetrics *TargetMetrics) {
 	for mi := range targetMetrics.Metrics {
-		if targetMetrics.Target.MetricNamespace != "" {
+		if namespace := targetMetrics.Target.Namespace(targetMetrics.Metrics[mi].name); namespace != "" {
 			targetMetrics.Metrics[mi].name = fmt.Sprintf(
 				"%s.%s",
-				targetMetrics.Target.MetricNamespace,
+				namespace,
 				targetMetrics.Metrics[mi].name,
 			)
 		}
@@ -342,37 +586,154 @@ func ReNamespaceMetrics(targetMetrics *TargetMetrics) {
 // by another channel
 type Processor func(pairs <-chan TargetMetrics) <-chan TargetMetrics
 
-// RuleProcessor process apply the Rename, Decorate and Filter metrics
-// processing and returns them through a channel.
-func RuleProcessor(processingRules []ProcessingRule, queueLength int) Processor {
-	var renameRules []RenameRule
-	var renameMetricRules []RenameMetricRule
-	var ignoreRules []IgnoreRule
-	var decorateRules []DecorateRule
-	var addAttributesRules []AddAttributesRule
+// RuleSet are the processing rules ready to be applied to the metrics of the
+// targets.
+type RuleSet struct {
+	renameRules           []RenameRule
+	renameMetricRules     []RenameMetricRule
+	ignoreRules           []IgnoreRule
+	seriesSelectors       []seriesSelector
+	decorateRules         []DecorateRule
+	addAttributesRules    []AddAttributesRule
+	histogramBucketsRules []HistogramBucketsRule
+	derivedMetrics        []derivedMetric
+	valueMappingRules     []ValueMappingRule
+	stateFoldingRules     []StateFoldingRule
+	// cache has the rules matching each metric name.
+	cache ruleMatchCache
+	// rules are the processing rules the set was prepared from.
+	rules []ProcessingRule
+}
+
+// NewRuleSet prepares the given processing rules to be applied. The disabled
+// rules are skipped.
+func NewRuleSet(processingRules []ProcessingRule) *RuleSet {
+	rs := &RuleSet{rules: processingRules}
+	var derivedMetricRules []DerivedMetricRule
 	for _, pr := range processingRules {
-		renameRules = append(renameRules, pr.RenameAttributes...)
-		ignoreRules = append(ignoreRules, pr.IgnoreMetrics...)
-		addAttributesRules = append(addAttributesRules, pr.AddAttributes...)
+		pr = pr.enabled()
+		rs.renameRules = append(rs.renameRules, pr.RenameAttributes...)
+		rs.ignoreRules = append(rs.ignoreRules, pr.IgnoreMetrics...)
+		rs.addAttributesRules = append(rs.addAttributesRules, pr.AddAttributes...)
 		for _, car := range pr.CopyAttributes {
 			join := labels.Set{}
+			var joinOpts labels.JoinOptions
 			for _, mk := range car.MatchBy {
-				join[mk] = struct{}{}
+				from, to := splitMatchBy(mk)
+				join[from] = struct{}{}
+				if from != to {
+					if joinOpts.Names == nil {
+						joinOpts.Names = map[string]string{}
+					}
+					joinOpts.Names[from] = to
+				}
+			}
+			for _, n := range car.Normalize {
+				if normalizer, ok := labels.Normalizers[n]; ok {
+					joinOpts.Normalizers = append(joinOpts.Normalizers, normalizer)
+				}
 			}
 			attrs := labels.Set{}
 			for _, mk := range car.Attributes {
 				attrs[mk] = struct{}{}
 			}
-			decorateRules = append(decorateRules, DecorateRule{
-				Source:     car.FromMetric,
-				Dest:       car.ToMetrics,
-				Join:       join,
-				Attributes: attrs,
+			rs.decorateRules = append(rs.decorateRules, DecorateRule{
+				Source:       car.FromMetric,
+				Dest:         car.ToMetrics,
+				Join:         join,
+				Attributes:   attrs,
+				JoinOptions:  joinOpts,
+				OnConflict:   car.OnConflict,
+				TargetFields: car.FromTarget,
 			})
 		}
-		renameMetricRules = append(renameMetricRules, pr.RenameMetrics...)
+		rs.renameMetricRules = append(rs.renameMetricRules, pr.RenameMetrics...)
+		rs.histogramBucketsRules = append(rs.histogramBucketsRules, pr.HistogramBuckets...)
+		rs.valueMappingRules = append(rs.valueMappingRules, pr.MapValues...)
+		rs.stateFoldingRules = append(rs.stateFoldingRules, pr.FoldStates...)
+		derivedMetricRules = append(derivedMetricRules, pr.DerivedMetrics...)
+	}
+	rs.derivedMetrics = compileDerivedMetrics(derivedMetricRules)
+	rs.seriesSelectors = compileSeriesSelectors(rs.ignoreRules)
+	return rs
+}
+
+// Apply applies the Rename, Decorate and Filter metrics processing to the
+// metrics of a target.
+func (rs *RuleSet) Apply(pair *TargetMetrics) {
+	deriveMetrics(pair, rs.derivedMetrics)
+	rs.filter(pair)
+	filterSeries(pair, rs.seriesSelectors)
+	ReduceHistogramBuckets(pair, rs.histogramBucketsRules)
+	FoldStates(pair, rs.stateFoldingRules)
+	AddClusterName(pair)
+	rs.addAttributes(pair)
+	rs.decorate(pair)
+	MapAttributeValues(pair, rs.valueMappingRules)
+	rs.rename(pair)
+	rs.renameMetrics(pair)
+	ReNamespaceMetrics(pair)
+	fingerprintMetrics(pair.Metrics)
+}
+
+// metricName returns the name the rules give to the metric with the given
+// name, and false if they drop it. The namespace of the target isn't added.
+func (rs *RuleSet) metricName(name string) (string, bool) {
+	m := rs.matching(name)
+	if m.ignored {
+		return "", false
 	}
+	return m.renamedTo, true
+}
+
+// ReloadableRuleSet is a RuleSet that can be replaced while the integration
+// is running.
+type ReloadableRuleSet struct {
+	mtx sync.RWMutex
+	rs  *RuleSet
+}
+
+// NewReloadableRuleSet prepares the given processing rules to be applied.
+func NewReloadableRuleSet(processingRules []ProcessingRule) *ReloadableRuleSet {
+	return &ReloadableRuleSet{rs: NewRuleSet(processingRules)}
+}
+
+// Reload replaces the rules, and with them the cache of the rules matching
+// each metric name. The metrics being processed keep the previous ones.
+func (r *ReloadableRuleSet) Reload(processingRules []ProcessingRule) {
+	r.set(NewRuleSet(processingRules))
+}
+
+func (r *ReloadableRuleSet) set(rs *RuleSet) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.rs = rs
+}
+
+func (r *ReloadableRuleSet) current() *RuleSet {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.rs
+}
+
+// Apply applies the current rules to the metrics of a target.
+func (r *ReloadableRuleSet) Apply(pair *TargetMetrics) {
+	r.current().Apply(pair)
+}
+
+// RuleProcessor process apply the Rename, Decorate and Filter metrics
+// processing and returns them through a channel.
+func RuleProcessor(processingRules []ProcessingRule, queueLength int) Processor {
+	return ruleSetProcessor(NewRuleSet(processingRules), queueLength)
+}
+
+// ReloadableRuleProcessor is a RuleProcessor applying the rules the
+// ReloadableRuleSet has when each target is processed.
+func ReloadableRuleProcessor(rs *ReloadableRuleSet, queueLength int) Processor {
+	return ruleSetProcessor(rs, queueLength)
+}
 
+func ruleSetProcessor(rs interface{ Apply(*TargetMetrics) }, queueLength int) Processor {
 	return func(targetMetrics <-chan TargetMetrics) <-chan TargetMetrics {
 		processedPairs := make(chan TargetMetrics, queueLength)
 
@@ -383,12 +744,7 @@ func RuleProcessor(processingRules []ProcessingRule, queueLength int) Processor
 			defer close(processedPairs)
 
 			for pair := range targetMetrics {
-				Filter(&pair, ignoreRules)
-				AddAttributes(&pair, addAttributesRules)
-				Decorate(&pair, decorateRules)
-				Rename(&pair, renameRules)
-				RenameMetrics(&pair, renameMetricRules)
-				ReNamespaceMetrics(&pair)
+				rs.Apply(&pair)
 
 				processedPairs <- pair
 			}
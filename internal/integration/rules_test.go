@@ -10,6 +10,7 @@ import (
 	"strings"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -18,7 +19,6 @@ import (
 )
 
 func TestConsolideLabels(t *testing.T) {
-	t.Skip("Auto-decoration isn't used at this moment.")
 	pair := scrapeString(t, prometheusInput)
 	AutoDecorateLabels(&pair)
 	fmt.Println("PAIR: ", pair.Metrics)
@@ -599,3 +599,143 @@ func TestRenamespaceMetrics(t *testing.T) {
 		assert.Regexp(t, regexp.MustCompile(`^beowulf\.`), metric.name)
 	}
 }
+
+func TestRenamespaceMetricsByPrefix(t *testing.T) {
+	entity := TargetMetrics{
+		Target: endpoints.Target{
+			MetricNamespace:  "app",
+			MetricNamespaces: []endpoints.NamespaceRoute{{Prefix: "jvm_", Namespace: "jvm"}},
+		},
+		Metrics: []Metric{
+			{name: "jvm_memory_used_bytes"},
+			{name: "http_requests_total"},
+		},
+	}
+	ReNamespaceMetrics(&entity)
+
+	assert.Equal(t, "jvm.jvm_memory_used_bytes", entity.Metrics[0].name)
+	assert.Equal(t, "app.http_requests_total", entity.Metrics[1].name)
+}
+
+func TestAddClusterName(t *testing.T) {
+	entity := scrapeString(t, prometheusInput)
+	entity.Target.ClusterName = "staging"
+	AddClusterName(&entity)
+	AddAttributes(&entity, []AddAttributesRule{
+		{
+			Attributes: map[string]interface{}{
+				"clusterName":      "production",
+				"k8s.cluster.name": "production",
+			},
+		},
+	})
+	for _, metric := range entity.Metrics {
+		assert.Equal(t, "staging", metric.attributes["clusterName"])
+		assert.Equal(t, "staging", metric.attributes["k8s.cluster.name"])
+	}
+}
+
+func TestCopyAttributesRuleValidate(t *testing.T) {
+	assert.NoError(t, CopyAttributesRule{MatchBy: []string{"namespace", "internal_ip=instance"}, Normalize: []string{"trim_port", "lowercase"}}.Validate())
+	assert.Error(t, CopyAttributesRule{Normalize: []string{"uppercase"}}.Validate())
+	assert.Error(t, CopyAttributesRule{MatchBy: []string{"internal_ip="}}.Validate())
+}
+
+func TestCopyAttributes_NormalizedJoin(t *testing.T) {
+	rs := NewRuleSet([]ProcessingRule{{CopyAttributes: []CopyAttributesRule{{
+		FromMetric: "kube_node_info",
+		ToMetrics:  []string{"node_"},
+		MatchBy:    []string{"internal_ip=instance"},
+		Attributes: []string{"node"},
+		Normalize:  []string{"trim_port"},
+	}}}})
+	pair := TargetMetrics{Metrics: []Metric{
+		{name: "kube_node_info", attributes: labels.Set{"internal_ip": "10.0.0.1", "node": "worker-1"}},
+		{name: "node_load1", attributes: labels.Set{"instance": "10.0.0.1:9100"}},
+		{name: "node_load5", attributes: labels.Set{"instance": "10.0.0.2:9100"}},
+	}}
+
+	rs.Apply(&pair)
+
+	assert.Equal(t, "worker-1", pair.Metrics[1].attributes["node"])
+	assert.NotContains(t, pair.Metrics[2].attributes, "node")
+}
+
+func TestCopyAttributes_Conflicts(t *testing.T) {
+	conflicts := func() float64 {
+		var m dto.Metric
+		require.NoError(t, decorationConflictsMetric.WithLabelValues(DecorationFirstWins).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	metrics := func() []Metric {
+		return []Metric{
+			{name: "app_version_info", attributes: labels.Set{"pod": "web", "version": "1.0"}},
+			{name: "image_info", attributes: labels.Set{"pod": "web", "version": "2.0"}},
+			{name: "image_info", attributes: labels.Set{"pod": "web", "version": "1.5"}},
+			{name: "app_requests_total", attributes: labels.Set{"pod": "web"}},
+			{name: "app_errors_total", attributes: labels.Set{"pod": "web", "version": "scraped"}},
+		}
+	}
+	rules := func(policy string) []DecorateRule {
+		return []DecorateRule{
+			{Source: "app_version_info", Dest: []string{"app_"}, Join: labels.Set{"pod": 1}},
+			{Source: "image_info", Dest: []string{"app_"}, Join: labels.Set{"pod": 1}, OnConflict: policy},
+		}
+	}
+
+	for _, tc := range []struct {
+		policy   string
+		expected labels.Set
+	}{
+		{policy: "", expected: labels.Set{"pod": "web", "version": "1.0"}},
+		{policy: DecorationFirstWins, expected: labels.Set{"pod": "web", "version": "1.0"}},
+		{policy: DecorationLastWins, expected: labels.Set{"pod": "web", "version": "2.0"}},
+		{policy: DecorationSuffix, expected: labels.Set{"pod": "web", "version.app_version_info": "1.0", "version.image_info": "1.5"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			pair := TargetMetrics{Metrics: metrics()}
+			before := conflicts()
+			CopyAttributes(&pair, rules(tc.policy))
+			assert.Equal(t, tc.expected, pair.Metrics[3].attributes)
+			assert.Equal(t, labels.Set{"pod": "web", "version": "scraped"}, pair.Metrics[4].attributes,
+				"the labels of the destination are not replaced")
+			if tc.policy == "" {
+				assert.Equal(t, before+2, conflicts())
+			}
+		})
+	}
+}
+
+func TestCopyAttributes_FromTarget(t *testing.T) {
+	target := endpoints.New("web", url.URL{Scheme: "http", Host: "10.0.0.1:8080"}, endpoints.Object{
+		Name:        "web",
+		Kind:        "pod",
+		Labels:      labels.Set{"label.team": "payments", "namespaceName": "shop"},
+		Annotations: labels.Set{"app.kubernetes.io/version": "1.4.2"},
+	})
+	pair := TargetMetrics{Target: target, Metrics: []Metric{
+		{name: "http_requests_total", attributes: labels.Set{"code": "200"}},
+		{name: "http_errors_total", attributes: labels.Set{"team": "scraped"}},
+		{name: "process_cpu_seconds_total", attributes: labels.Set{}},
+	}}
+	rules := []ProcessingRule{{CopyAttributes: []CopyAttributesRule{{
+		FromTarget: map[string]string{
+			"app.version": "metadata.annotations['app.kubernetes.io/version']",
+			"team":        "metadata.labels['team']",
+			"missing":     "metadata.annotations['missing']",
+		},
+		ToMetrics: []string{"http_"},
+	}}}}
+	assert.Equal(t, []string{"app.kubernetes.io/version", "missing"}, TargetAnnotations(rules))
+	rs := NewRuleSet(rules)
+	CopyAttributes(&pair, rs.decorateRules)
+
+	assert.Equal(t, labels.Set{"code": "200", "app.version": "1.4.2", "team": "payments"}, pair.Metrics[0].attributes)
+	assert.Equal(t, labels.Set{"team": "scraped", "app.version": "1.4.2"}, pair.Metrics[1].attributes,
+		"the labels of the destination are not replaced")
+	assert.Empty(t, pair.Metrics[2].attributes)
+
+	assert.NoError(t, rules[0].CopyAttributes[0].Validate())
+	assert.Error(t, CopyAttributesRule{FromMetric: "kube_pod_info", FromTarget: map[string]string{"team": "metadata.labels['team']"}}.Validate())
+	assert.Error(t, CopyAttributesRule{FromTarget: map[string]string{"team": "metadata.labels[team]"}}.Validate())
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// schedulingFetcher is a Fetcher decorator that skips the targets out of the
+// time windows of their schedule.
+type schedulingFetcher struct {
+	inner Fetcher
+	now   func() time.Time
+}
+
+// NewSchedulingFetcher wraps the given Fetcher so the targets are fetched
+// only in the time windows of their schedule.
+func NewSchedulingFetcher(inner Fetcher) Fetcher {
+	return &schedulingFetcher{inner: inner, now: time.Now}
+}
+
+func (sf *schedulingFetcher) Fetch(ctx context.Context, targets []endpoints.Target) <-chan TargetMetrics {
+	now := sf.now()
+	active := make([]endpoints.Target, 0, len(targets))
+	for _, t := range targets {
+		if t.Schedule.Active(now) {
+			active = append(active, t)
+		}
+	}
+	if skipped := len(targets) - len(active); skipped > 0 {
+		unscheduledTargetsMetric.Set(float64(skipped))
+		ilog.Debugf("skipping %d targets out of their scrape schedule", skipped)
+	} else {
+		unscheduledTargetsMetric.Set(0)
+	}
+	return sf.inner.Fetch(ctx, active)
+}
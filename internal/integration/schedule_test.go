@@ -0,0 +1,35 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestSchedulingFetcher(t *testing.T) {
+	paused, err := endpoints.ScheduleConfig{Timezone: "UTC", Paused: []string{"02:00-03:00"}}.Parse()
+	require.NoError(t, err)
+	targets := []endpoints.Target{
+		{Name: "always"},
+		{Name: "backup", Schedule: paused},
+	}
+
+	inner := &fakeFetcher{}
+	fetcher := NewSchedulingFetcher(inner).(*schedulingFetcher)
+
+	fetcher.now = func() time.Time { return time.Date(2021, 3, 1, 2, 30, 0, 0, time.UTC) }
+	fetcher.Fetch(context.Background(), targets)
+	require.Len(t, inner.fetched, 1)
+	assert.Equal(t, "always", inner.fetched[0].Name)
+
+	fetcher.now = func() time.Time { return time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC) }
+	fetcher.Fetch(context.Background(), targets)
+	assert.Len(t, inner.fetched, 2)
+}
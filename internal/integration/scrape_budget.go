@@ -0,0 +1,164 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// scrapeCostWeight is the weight of the last scrape duration of a target in
+// the estimation of its cost.
+const scrapeCostWeight = 0.5
+
+// ScrapeBudgetConfig configures the time budget of the scrape cycles.
+type ScrapeBudgetConfig struct {
+	// Enabled makes the targets that don't fit in the budget be skipped,
+	// fairly, instead of delaying the next cycles.
+	Enabled bool `mapstructure:"enabled"`
+	// Budget is the time each worker thread can spend scraping targets in a
+	// cycle. Defaults to the scrape duration.
+	Budget time.Duration `mapstructure:"budget"`
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c *ScrapeBudgetConfig) Validate() error {
+	if c.Budget < 0 {
+		return fmt.Errorf("budget can't be negative")
+	}
+	return nil
+}
+
+// FetcherWithScrapeBudget makes the Fetcher scrape, in each cycle, the
+// targets that fit in the budget of all its worker threads, according to the
+// time their last scrapes took. When they don't fit, the targets are visited
+// round-robin, each cycle starting after the last target scraped, and every
+// visited target adds its share of the budget to a deficit. A target is
+// scraped once its deficit covers its cost, so the slow targets are scraped
+// less often than the fast ones, but they are not always the ones skipped.
+func FetcherWithScrapeBudget(cfg ScrapeBudgetConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		budget := cfg.Budget
+		if budget == 0 {
+			budget = pf.duration
+		}
+		pf.scheduler = newFairScheduler(budget * time.Duration(pf.workerThreads))
+	}
+}
+
+// fairScheduler chooses the targets scraped in each cycle with deficit
+// round-robin.
+type fairScheduler struct {
+	capacity time.Duration
+
+	mtx      sync.Mutex
+	costs    map[string]time.Duration
+	deficits map[string]time.Duration
+	// next is the name of the target the next round starts at.
+	next string
+}
+
+func newFairScheduler(capacity time.Duration) *fairScheduler {
+	return &fairScheduler{
+		capacity: capacity,
+		costs:    make(map[string]time.Duration),
+		deficits: make(map[string]time.Duration),
+	}
+}
+
+// observe updates the estimated cost of the target with the duration of a
+// scrape.
+func (s *fairScheduler) observe(target string, d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	cost, ok := s.costs[target]
+	if !ok {
+		s.costs[target] = d
+		return
+	}
+	s.costs[target] = time.Duration(scrapeCostWeight*float64(d) + (1-scrapeCostWeight)*float64(cost))
+}
+
+// schedule returns the targets to scrape in the cycle, counting the skipped
+// ones. The targets that were never scraped are always scraped, to learn
+// their cost.
+func (s *fairScheduler) schedule(targets []endpoints.Target) []endpoints.Target {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	present := make(map[string]bool, len(targets))
+	var total time.Duration
+	for _, t := range targets {
+		present[t.Name] = true
+		total += s.costs[t.Name]
+	}
+	for name := range s.costs {
+		if !present[name] {
+			delete(s.costs, name)
+			delete(s.deficits, name)
+		}
+	}
+	if total <= s.capacity {
+		return targets
+	}
+
+	sorted := append([]endpoints.Target(nil), targets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	start := sort.Search(len(sorted), func(i int) bool {
+		return sorted[i].Name >= s.next
+	})
+	quantum := s.capacity / time.Duration(len(sorted))
+	if quantum <= 0 {
+		quantum = 1
+	}
+	left := s.capacity
+	scheduled := make([]endpoints.Target, 0, len(sorted))
+	done := make([]bool, len(sorted))
+	// Rounds are visited until none of the remaining targets fits in what's
+	// left of the budget.
+	for fits := true; fits; {
+		fits = false
+		for i := range sorted {
+			j := (start + i) % len(sorted)
+			t := sorted[j]
+			cost := s.costs[t.Name]
+			// A target slower than the whole budget takes all of it.
+			if cost > s.capacity {
+				cost = s.capacity
+			}
+			if done[j] || cost > left {
+				continue
+			}
+			fits = true
+			s.deficits[t.Name] += quantum
+			if cost > s.deficits[t.Name] {
+				continue
+			}
+			s.deficits[t.Name] -= cost
+			// The unused deficit isn't accumulated beyond a round, as the
+			// fast targets would take the budget of the next cycles.
+			if s.deficits[t.Name] > quantum {
+				s.deficits[t.Name] = quantum
+			}
+			left -= cost
+			done[j] = true
+			scheduled = append(scheduled, t)
+			s.next = sorted[(j+1)%len(sorted)].Name
+		}
+	}
+	for j, t := range sorted {
+		if !done[j] {
+			budgetSkippedTargetsMetric.WithLabelValues(t.Name).Inc()
+		}
+	}
+	if skipped := len(targets) - len(scheduled); skipped > 0 {
+		ilog.Warnf("scraping the %d targets would take %v, more than the budget of %v, skipping %d targets", len(targets), total, s.capacity, skipped)
+	}
+	return scheduled
+}
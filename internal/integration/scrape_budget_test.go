@@ -0,0 +1,88 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func budgetTargets(names ...string) []endpoints.Target {
+	targets := make([]endpoints.Target, 0, len(names))
+	for _, name := range names {
+		targets = append(targets, endpoints.New(name, url.URL{Scheme: "http", Host: name}, endpoints.Object{Name: name}))
+	}
+	return targets
+}
+
+func targetNames(targets []endpoints.Target) []string {
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func TestFairScheduler_WithinBudget(t *testing.T) {
+	s := newFairScheduler(10 * time.Second)
+	targets := budgetTargets("a", "b", "c")
+	assert.Equal(t, targets, s.schedule(targets), "the unknown targets are scraped")
+
+	for _, name := range []string{"a", "b", "c"} {
+		s.observe(name, 3*time.Second)
+	}
+	assert.Equal(t, targets, s.schedule(targets))
+}
+
+func TestFairScheduler_SkipsFairly(t *testing.T) {
+	s := newFairScheduler(10 * time.Second)
+	targets := budgetTargets("a", "b", "c", "d", "e")
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.observe(name, 4*time.Second)
+	}
+
+	scrapes := map[string]int{}
+	for cycle := 0; cycle < 10; cycle++ {
+		scheduled := s.schedule(targets)
+		assert.LessOrEqual(t, len(scheduled), 2, "cycle %d: %v", cycle, targetNames(scheduled))
+		for _, name := range targetNames(scheduled) {
+			scrapes[name]++
+		}
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		assert.Equal(t, 4, scrapes[name], fmt.Sprintf("scrapes of %s", name))
+	}
+}
+
+func TestFairScheduler_SlowTarget(t *testing.T) {
+	s := newFairScheduler(10 * time.Second)
+	targets := budgetTargets("fast1", "fast2", "slow")
+	s.observe("fast1", time.Second)
+	s.observe("fast2", time.Second)
+	s.observe("slow", 20*time.Second)
+
+	scrapes := map[string]int{}
+	for cycle := 0; cycle < 9; cycle++ {
+		for _, name := range targetNames(s.schedule(targets)) {
+			scrapes[name]++
+		}
+	}
+	// The slow target takes the whole budget when it's scraped.
+	assert.GreaterOrEqual(t, scrapes["fast1"], 6)
+	assert.GreaterOrEqual(t, scrapes["fast2"], 6)
+	assert.NotZero(t, scrapes["slow"], "the slow target isn't always skipped")
+	assert.Less(t, scrapes["slow"], 9)
+}
+
+func TestFairScheduler_ForgetsRemovedTargets(t *testing.T) {
+	s := newFairScheduler(10 * time.Second)
+	s.observe("gone", time.Minute)
+	s.schedule(budgetTargets("a"))
+	assert.NotContains(t, s.costs, "gone")
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// DefaultUserAgent returns the User-Agent of the scrape requests when it isn't
+// configured.
+func DefaultUserAgent() string {
+	return fmt.Sprintf("%s/%s", Name, Version)
+}
+
+// FetcherWithHeaders makes the Fetcher set the given headers in all the scrape
+// requests, replacing the ones with the same name, so the owners of the
+// targets can identify the integration traffic.
+func FetcherWithHeaders(headers http.Header) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.headers = headers
+	}
+}
+
+// headerDoer sets the headers in the requests before doing them.
+type headerDoer struct {
+	inner   prometheus.HTTPDoer
+	headers http.Header
+}
+
+// Do sets the headers in the request and does it.
+func (d headerDoer) Do(req *http.Request) (*http.Response, error) {
+	for name, values := range d.headers {
+		req.Header[name] = values
+	}
+	return d.inner.Do(req)
+}
+
+// withHeaders returns the client setting the headers of the fetcher, if any.
+func (pf *prometheusFetcher) withHeaders(c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if len(pf.headers) == 0 {
+		return c
+	}
+	return headerDoer{inner: c, headers: pf.headers}
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	promcli "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// FetcherWithSelfGatherer makes the Fetcher read the metrics of the targets
+// with the endpoints.InternalScheme from the gatherer, in process, instead of
+// scraping them over HTTP. The integration own metrics go through the
+// pipeline this way without exposing them in a local port.
+func FetcherWithSelfGatherer(g promcli.Gatherer) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.selfHandler = promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+	}
+}
+
+// gatherDoer answers the requests with the handler of the gathered metrics,
+// as the integration server would.
+type gatherDoer struct {
+	handler http.Handler
+}
+
+// Do serves the request in process.
+func (d gatherDoer) Do(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	d.handler.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+// selfClient returns the client of the target gathered in process, and false
+// if the target is scraped over HTTP.
+func (pf *prometheusFetcher) selfClient(t endpoints.Target) (prometheus.HTTPDoer, bool) {
+	if pf.selfHandler == nil || t.URL.Scheme != endpoints.InternalScheme {
+		return nil, false
+	}
+	return gatherDoer{handler: pf.selfHandler}, true
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	promcli "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestFetcherWithSelfGatherer(t *testing.T) {
+	registry := promcli.NewRegistry()
+	gauge := promcli.NewGauge(promcli.GaugeOpts{Name: "nr_stats_targets", Help: "targets"})
+	gauge.Set(3)
+	registry.MustRegister(gauge)
+
+	retriever, err := endpoints.SelfRetriever(endpoints.TargetURL{URL: endpoints.SelfInternalURL})
+	require.NoError(t, err)
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength, FetcherWithSelfGatherer(registry))
+	var pair TargetMetrics
+	select {
+	case pair = <-fetcher.Fetch(context.Background(), targets):
+	case <-time.After(fetchTimeout):
+		t.Fatal("can't gather the metrics")
+	}
+
+	assert.Equal(t, "nri-prometheus", pair.Target.Name)
+	require.Len(t, pair.Metrics, 1)
+	assert.Equal(t, "nr_stats_targets", pair.Metrics[0].name)
+	assert.Equal(t, float64(3), pair.Metrics[0].value)
+}
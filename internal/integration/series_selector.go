@@ -0,0 +1,199 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// seriesSelector selects the series by metric name and label matchers, like
+// the PromQL series selectors, e.g.
+// node_filesystem_avail_bytes{mountpoint=~"/var/lib/docker/.*"}.
+type seriesSelector struct {
+	name     string
+	matchers []labelMatcher
+}
+
+// labelMatcher matches the value of a label with the =, !=, =~ or !~
+// operators. The regular expressions are anchored.
+type labelMatcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// matches returns true if the series of the metric is selected. The missing
+// labels match the empty value, and the __name__ label is the metric name.
+func (s seriesSelector) matches(m Metric) bool {
+	if s.name != "" && s.name != m.name {
+		return false
+	}
+	for _, lm := range s.matchers {
+		var value string
+		if lm.name == "__name__" {
+			value = m.name
+		} else if v, ok := m.attributes[lm.name]; ok {
+			value = fmt.Sprint(v)
+		}
+		if !lm.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (lm labelMatcher) matches(value string) bool {
+	switch lm.op {
+	case "=":
+		return value == lm.value
+	case "!=":
+		return value != lm.value
+	case "=~":
+		return lm.re.MatchString(value)
+	default:
+		return !lm.re.MatchString(value)
+	}
+}
+
+// compileSeriesSelectors parses the series selectors of the ignore rules,
+// skipping the invalid ones.
+func compileSeriesSelectors(rules []IgnoreRule) []seriesSelector {
+	var selectors []seriesSelector
+	for _, rule := range rules {
+		for _, s := range rule.Series {
+			selector, err := parseSeriesSelector(s)
+			if err != nil {
+				ilog.WithError(err).Errorf("invalid series selector %s", s)
+				continue
+			}
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// filterSeries removes the series selected by any of the selectors.
+func filterSeries(targetMetrics *TargetMetrics, selectors []seriesSelector) {
+	if len(selectors) == 0 {
+		return
+	}
+
+	copied := make([]Metric, 0, len(targetMetrics.Metrics))
+	for _, m := range targetMetrics.Metrics {
+		selected := false
+		for _, s := range selectors {
+			if s.matches(m) {
+				selected = true
+				break
+			}
+		}
+		if !selected {
+			copied = append(copied, m)
+		} else {
+			DefaultDroppedSampler.Sample(DroppedBySeriesRules, targetMetrics.Target.Name, m)
+		}
+	}
+	targetMetrics.Metrics = copied
+}
+
+// parseSeriesSelector parses a metric name followed by label matchers between
+// braces. The name or at least one matcher is required.
+func parseSeriesSelector(s string) (seriesSelector, error) {
+	p := &exprParser{input: strings.TrimSpace(s)}
+	selector := seriesSelector{name: p.identifier()}
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == '{' {
+		p.pos++
+		for {
+			p.skipSpaces()
+			if p.pos < len(p.input) && p.input[p.pos] == '}' {
+				p.pos++
+				break
+			}
+			lm, err := p.labelMatcher()
+			if err != nil {
+				return seriesSelector{}, fmt.Errorf("%w in %q", err, s)
+			}
+			selector.matchers = append(selector.matchers, lm)
+			p.skipSpaces()
+			if p.pos < len(p.input) && p.input[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.pos >= len(p.input) || p.input[p.pos] != '}' {
+				return seriesSelector{}, fmt.Errorf("expected , or } at position %d of %q", p.pos, s)
+			}
+		}
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return seriesSelector{}, fmt.Errorf("unexpected %q at position %d of %q", p.input[p.pos], p.pos, s)
+	}
+	if selector.name == "" && len(selector.matchers) == 0 {
+		return seriesSelector{}, fmt.Errorf("series selector %q has no metric name nor label matchers", s)
+	}
+	return selector, nil
+}
+
+// identifier reads a metric or label name, which is empty if there is none.
+func (p *exprParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (p.pos > start && c >= '0' && c <= '9') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// labelMatcher reads a label name, an operator and a quoted value.
+func (p *exprParser) labelMatcher() (labelMatcher, error) {
+	lm := labelMatcher{name: p.identifier()}
+	if lm.name == "" {
+		return lm, fmt.Errorf("expected a label name at position %d", p.pos)
+	}
+	p.skipSpaces()
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			lm.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if lm.op == "" {
+		return lm, fmt.Errorf("expected =, !=, =~ or !~ at position %d", p.pos)
+	}
+	p.skipSpaces()
+	if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+		return lm, fmt.Errorf("expected a quoted value at position %d", p.pos)
+	}
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != '"' {
+		if p.input[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.input) {
+		return lm, fmt.Errorf("unterminated value at position %d", p.pos)
+	}
+	value, err := strconv.Unquote(p.input[p.pos : end+1])
+	if err != nil {
+		return lm, fmt.Errorf("invalid value at position %d: %w", p.pos, err)
+	}
+	p.pos = end + 1
+	lm.value = value
+	if lm.op == "=~" || lm.op == "!~" {
+		if lm.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+			return lm, err
+		}
+	}
+	return lm, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestParseSeriesSelector(t *testing.T) {
+	s, err := parseSeriesSelector(`node_filesystem_avail_bytes{mountpoint=~"/var/lib/docker/.*", fstype != "tmpfs"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "node_filesystem_avail_bytes", s.name)
+	require.Len(t, s.matchers, 2)
+	assert.Equal(t, "mountpoint", s.matchers[0].name)
+	assert.Equal(t, "=~", s.matchers[0].op)
+	assert.Equal(t, "/var/lib/docker/.*", s.matchers[0].value)
+	assert.Equal(t, "!=", s.matchers[1].op)
+
+	for _, valid := range []string{"up", `{job="a"}`, `up{}`, `up{job="a\"b",}`, `{__name__=~"go_.*"}`} {
+		_, err := parseSeriesSelector(valid)
+		assert.NoError(t, err, valid)
+	}
+	for _, invalid := range []string{"", "{}", `up{job}`, `up{job="a"`, `up{job=a}`, `up{job=~"("}`, `up{job="a" code="b"}`, "up down"} {
+		_, err := parseSeriesSelector(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFilterSeries(t *testing.T) {
+	metric := func(name string, attrs labels.Set) Metric {
+		return Metric{name: name, value: 1.0, metricType: metricType_GAUGE, attributes: attrs}
+	}
+	pair := TargetMetrics{Metrics: []Metric{
+		metric("node_filesystem_avail_bytes", labels.Set{"mountpoint": "/var/lib/docker/overlay2/x"}),
+		metric("node_filesystem_avail_bytes", labels.Set{"mountpoint": "/"}),
+		metric("node_filesystem_size_bytes", labels.Set{"mountpoint": "/var/lib/docker/overlay2/x"}),
+		metric("http_requests_total", labels.Set{"code": "200"}),
+		metric("http_requests_total", labels.Set{}),
+	}}
+	rs := NewRuleSet([]ProcessingRule{{IgnoreMetrics: []IgnoreRule{{Series: []string{
+		`node_filesystem_avail_bytes{mountpoint=~"/var/lib/docker/.*"}`,
+		`http_requests_total{code=""}`,
+		`invalid{`,
+	}}}}})
+	require.Len(t, rs.seriesSelectors, 2, "the invalid selectors are skipped")
+
+	rs.Apply(&pair)
+
+	require.Len(t, pair.Metrics, 3)
+	assert.Equal(t, "/", pair.Metrics[0].attributes["mountpoint"])
+	assert.Equal(t, "node_filesystem_size_bytes", pair.Metrics[1].name, "other metrics with the same labels are kept")
+	assert.Equal(t, "200", pair.Metrics[2].attributes["code"], "the missing labels match the empty value")
+}
+
+func TestIgnoreRuleValidate(t *testing.T) {
+	assert.NoError(t, IgnoreRule{Prefixes: []string{"go_"}}.Validate())
+	assert.NoError(t, IgnoreRule{Series: []string{`up{job="a"}`}}.Validate())
+	assert.Error(t, IgnoreRule{Series: []string{`up{job=}`}}.Validate())
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Changes reported by the shadow evaluation of the candidate rules.
+const (
+	// ShadowDropped metrics are emitted with the active rules but not with
+	// the candidate ones.
+	ShadowDropped = "dropped"
+	// ShadowKept metrics are emitted with the candidate rules but not with
+	// the active ones.
+	ShadowKept = "kept"
+	// ShadowRenamed metrics are emitted with another name with the candidate
+	// rules.
+	ShadowRenamed = "renamed"
+)
+
+// ErrNoCandidateRules is returned when promoting the candidate rules if there
+// aren't any.
+var ErrNoCandidateRules = errors.New("there are no candidate rules")
+
+// ShadowReport has the scraped metrics that the candidate rules handle
+// differently than the active ones, since the candidate or the active rules
+// were loaded.
+type ShadowReport struct {
+	Time    time.Time      `json:"time"`
+	Changes []ShadowChange `json:"changes"`
+}
+
+// ShadowChange is a scraped metric handled differently by the candidate
+// rules.
+type ShadowChange struct {
+	Metric        string `json:"metric"`
+	Change        string `json:"change"`
+	ActiveName    string `json:"activeName,omitempty"`
+	CandidateName string `json:"candidateName,omitempty"`
+	// Series is the number of series of the metric in the last scrape cycle
+	// it was found in.
+	Series int `json:"series"`
+}
+
+// ShadowRules evaluates candidate processing rules alongside the active ones
+// without applying them, until they are promoted.
+type ShadowRules struct {
+	active *ReloadableRuleSet
+	now    func() time.Time
+
+	mtx          sync.Mutex
+	candidateSet *RuleSet
+	// activeSet is the active RuleSet the changes were found with.
+	activeSet *RuleSet
+	// current has the changes of the ongoing cycle, nil for the metrics
+	// handled the same way.
+	current map[string]*ShadowChange
+	changes map[string]ShadowChange
+	updated time.Time
+}
+
+// NewShadowRules returns the ShadowRules evaluating the candidate rules
+// against the active ones.
+func NewShadowRules(active *ReloadableRuleSet, candidate []ProcessingRule) *ShadowRules {
+	return &ShadowRules{
+		active:       active,
+		now:          time.Now,
+		candidateSet: NewRuleSet(candidate),
+		current:      make(map[string]*ShadowChange),
+		changes:      make(map[string]ShadowChange),
+	}
+}
+
+// ShadowProcessor wraps the given Processor, evaluating the candidate rules
+// on the scraped metrics before they are processed. The report is updated
+// at the end of each scrape cycle.
+func ShadowProcessor(s *ShadowRules, next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		observed := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(observed)
+			for pair := range pairs {
+				s.observe(pair.Metrics)
+				observed <- pair
+			}
+			s.commit()
+		}()
+		return next(observed)
+	}
+}
+
+// observe compares the names given by both rule sets to the scraped metrics.
+func (s *ShadowRules) observe(metrics []Metric) {
+	active := s.active.current()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.candidateSet == nil {
+		return
+	}
+	if active != s.activeSet {
+		// The active rules were reloaded.
+		s.activeSet = active
+		s.current = make(map[string]*ShadowChange)
+		s.changes = make(map[string]ShadowChange)
+	}
+	for _, m := range metrics {
+		if c, ok := s.current[m.name]; ok {
+			if c != nil {
+				c.Series++
+			}
+			continue
+		}
+		activeName, activeKept := active.metricName(m.name)
+		candidateName, candidateKept := s.candidateSet.metricName(m.name)
+		var change string
+		switch {
+		case activeKept && !candidateKept:
+			change = ShadowDropped
+		case !activeKept && candidateKept:
+			change = ShadowKept
+		case activeKept && activeName != candidateName:
+			change = ShadowRenamed
+		default:
+			// Handled the same way, it's not reported.
+			s.current[m.name] = nil
+			continue
+		}
+		s.current[m.name] = &ShadowChange{
+			Metric:        m.name,
+			Change:        change,
+			ActiveName:    activeName,
+			CandidateName: candidateName,
+			Series:        1,
+		}
+	}
+}
+
+// commit adds the changes of the cycle to the report.
+func (s *ShadowRules) commit() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.candidateSet == nil {
+		return
+	}
+	for name, c := range s.current {
+		if c != nil {
+			s.changes[name] = *c
+		}
+	}
+	s.current = make(map[string]*ShadowChange)
+	s.updated = s.now()
+}
+
+// Report returns the changes found until the last completed cycle.
+func (s *ShadowRules) Report() ShadowReport {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	report := ShadowReport{Time: s.updated, Changes: make([]ShadowChange, 0, len(s.changes))}
+	for _, c := range s.changes {
+		report.Changes = append(report.Changes, c)
+	}
+	sort.Slice(report.Changes, func(i, j int) bool {
+		return report.Changes[i].Metric < report.Changes[j].Metric
+	})
+	return report
+}
+
+// Promote replaces atomically the active rules with the candidate ones, which
+// stop being evaluated.
+func (s *ShadowRules) Promote() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.candidateSet == nil {
+		return ErrNoCandidateRules
+	}
+	s.active.set(s.candidateSet)
+	s.candidateSet = nil
+	s.current = make(map[string]*ShadowChange)
+	s.changes = make(map[string]ShadowChange)
+	return nil
+}
+
+// ServeHTTP returns the report as JSON.
+func (s *ShadowRules) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Report())
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestShadowRules(t *testing.T) {
+	active := NewReloadableRuleSet([]ProcessingRule{{
+		IgnoreMetrics: []IgnoreRule{{Prefixes: []string{"go_"}}},
+		RenameMetrics: []RenameMetricRule{{FromMetric: "up", ToMetric: "target_up"}},
+	}})
+	shadow := NewShadowRules(active, []ProcessingRule{{
+		IgnoreMetrics: []IgnoreRule{{Prefixes: []string{"process_"}}},
+		RenameMetrics: []RenameMetricRule{{FromMetric: "up", ToMetric: "up_status"}},
+	}})
+	processor := ShadowProcessor(shadow, ReloadableRuleProcessor(active, 1), 1)
+
+	pairs := make(chan TargetMetrics, 1)
+	pairs <- TargetMetrics{Metrics: []Metric{
+		NewGaugeMetric("go_goroutines", 8, labels.Set{}),
+		NewGaugeMetric("process_open_fds", 10, labels.Set{"fd": "a"}),
+		NewGaugeMetric("process_open_fds", 12, labels.Set{"fd": "b"}),
+		NewGaugeMetric("up", 1, labels.Set{}),
+		NewGaugeMetric("requests", 1, labels.Set{}),
+	}}
+	close(pairs)
+	var emitted []string
+	for pair := range processor(pairs) {
+		for _, m := range pair.Metrics {
+			emitted = append(emitted, m.name)
+		}
+	}
+	// The active rules are the ones applied.
+	assert.ElementsMatch(t, []string{"process_open_fds", "process_open_fds", "target_up", "requests"}, emitted)
+
+	report := shadow.Report()
+	assert.Equal(t, []ShadowChange{
+		{Metric: "go_goroutines", Change: ShadowKept, CandidateName: "go_goroutines", Series: 1},
+		{Metric: "process_open_fds", Change: ShadowDropped, ActiveName: "process_open_fds", Series: 2},
+		{Metric: "up", Change: ShadowRenamed, ActiveName: "target_up", CandidateName: "up_status", Series: 1},
+	}, report.Changes)
+
+	require.NoError(t, shadow.Promote())
+	assert.Empty(t, shadow.Report().Changes)
+	assert.Equal(t, ErrNoCandidateRules, shadow.Promote())
+
+	pair := TargetMetrics{Metrics: []Metric{NewGaugeMetric("up", 1, labels.Set{})}}
+	active.Apply(&pair)
+	assert.Equal(t, "up_status", pair.Metrics[0].name)
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TargetSnapshot has the metrics of the last scrape of a target, as they
+// were sent by the emitters.
+type TargetSnapshot struct {
+	Target  string    `json:"target"`
+	URL     string    `json:"url"`
+	Time    time.Time `json:"time"`
+	Metrics []Metric  `json:"metrics,omitempty"`
+	// MetricCount is the number of metrics of the scrape.
+	MetricCount int `json:"metricCount"`
+}
+
+// SnapshotStore keeps the TargetSnapshot of the last scrape of a bounded
+// number of targets, discarding the least recently scraped ones.
+type SnapshotStore struct {
+	max int
+	now func() time.Time
+
+	mtx       sync.Mutex
+	snapshots map[string]TargetSnapshot
+}
+
+// NewSnapshotStore returns a SnapshotStore keeping at most max targets.
+func NewSnapshotStore(max int) *SnapshotStore {
+	return &SnapshotStore{
+		max:       max,
+		now:       time.Now,
+		snapshots: make(map[string]TargetSnapshot),
+	}
+}
+
+// SnapshotProcessor wraps the given Processor, recording in the store the
+// metrics it returns for each target.
+func SnapshotProcessor(store *SnapshotStore, next Processor, queueLength int) Processor {
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		recorded := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(recorded)
+			for pair := range next(pairs) {
+				store.Record(pair)
+				recorded <- pair
+			}
+		}()
+		return recorded
+	}
+}
+
+// Record replaces the snapshot of the target with its processed metrics.
+func (s *SnapshotStore) Record(pair TargetMetrics) {
+	url := pair.Target.URL.String()
+	snapshot := TargetSnapshot{
+		Target:      pair.Target.Name,
+		URL:         url,
+		Time:        s.now(),
+		Metrics:     append([]Metric(nil), pair.Metrics...),
+		MetricCount: len(pair.Metrics),
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.snapshots[url] = snapshot
+	if len(s.snapshots) <= s.max {
+		return
+	}
+	oldest := url
+	for key, snapshot := range s.snapshots {
+		if snapshot.Time.Before(s.snapshots[oldest].Time) {
+			oldest = key
+		}
+	}
+	delete(s.snapshots, oldest)
+}
+
+// Get returns the snapshots of the targets with the given name or URL.
+func (s *SnapshotStore) Get(ref string) []TargetSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var snapshots []TargetSnapshot
+	for _, snapshot := range s.snapshots {
+		if snapshot.Target == ref || snapshot.URL == ref {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sortSnapshots(snapshots)
+	return snapshots
+}
+
+// List returns the snapshots of all the targets, without their metrics.
+func (s *SnapshotStore) List() []TargetSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	snapshots := make([]TargetSnapshot, 0, len(s.snapshots))
+	for _, snapshot := range s.snapshots {
+		snapshot.Metrics = nil
+		snapshots = append(snapshots, snapshot)
+	}
+	sortSnapshots(snapshots)
+	return snapshots
+}
+
+func sortSnapshots(snapshots []TargetSnapshot) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].URL < snapshots[j].URL
+	})
+}
+
+// ServeHTTP returns as JSON the snapshots of the targets with the name or URL
+// of the target parameter, or the list of targets without their metrics when
+// it is not set.
+func (s *SnapshotStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ref := r.URL.Query().Get("target")
+	snapshots := s.List()
+	if ref != "" {
+		snapshots = s.Get(ref)
+		if len(snapshots) == 0 {
+			http.Error(w, fmt.Sprintf("no snapshot of target %q", ref), http.StatusNotFound)
+			return
+		}
+	}
+	body, err := json.Marshal(snapshots)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("encoding the snapshots: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
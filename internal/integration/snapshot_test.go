@@ -0,0 +1,82 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func snapshotPair(t *testing.T, url string, metrics ...Metric) TargetMetrics {
+	t.Helper()
+	targets, err := endpoints.EndpointToTarget(endpoints.TargetConfig{URLs: []endpoints.TargetURL{{URL: url}}})
+	require.NoError(t, err)
+	return TargetMetrics{Target: targets[0], Metrics: metrics}
+}
+
+func TestSnapshotProcessor(t *testing.T) {
+	store := NewSnapshotStore(2)
+	now := time.Unix(0, 0)
+	store.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	pairs := make(chan TargetMetrics, 3)
+	pairs <- snapshotPair(t, "a:9100", NewGaugeMetric("up", 1, labels.Set{}))
+	pairs <- snapshotPair(t, "b:9100", NewGaugeMetric("up", 0, labels.Set{}))
+	pairs <- snapshotPair(t, "c:9100")
+	close(pairs)
+	processed := 0
+	for range SnapshotProcessor(store, RuleProcessor(nil, 3), 3)(pairs) {
+		processed++
+	}
+	assert.Equal(t, 3, processed)
+
+	// The least recently scraped target is discarded.
+	list := store.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "http://b:9100/metrics", list[0].URL)
+	assert.Nil(t, list[0].Metrics)
+	assert.Equal(t, 1, list[0].MetricCount)
+	assert.Empty(t, store.Get("a:9100"))
+
+	snapshots := store.Get("b:9100")
+	require.Len(t, snapshots, 1)
+	require.Len(t, snapshots[0].Metrics, 1)
+	assert.Equal(t, 0.0, snapshots[0].Metrics[0].Value())
+}
+
+func TestSnapshotStore_ServeHTTP(t *testing.T) {
+	store := NewSnapshotStore(10)
+	store.Record(snapshotPair(t, "a:9100", NewGaugeMetric("up", 1, labels.Set{"job": "a"})))
+
+	w := httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/snapshots?target=a:9100", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var snapshots []struct {
+		URL     string `json:"url"`
+		Metrics []struct {
+			Name       string            `json:"name"`
+			Attributes map[string]string `json:"attributes"`
+		} `json:"metrics"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snapshots))
+	require.Len(t, snapshots, 1)
+	require.Len(t, snapshots[0].Metrics, 1)
+	assert.Equal(t, "up", snapshots[0].Metrics[0].Name)
+	assert.Equal(t, "a", snapshots[0].Metrics[0].Attributes["job"])
+
+	w = httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/snapshots?target=b:9100", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
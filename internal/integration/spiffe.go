@@ -0,0 +1,257 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// SPIFFEConfig configures the use of SPIFFE X.509 SVIDs as TLS identity. The
+// Workload API isn't used directly: the SVID, its key and the trust bundle
+// are read from the files kept up to date by a Workload API client running
+// next to the integration, like spiffe-helper, and they are reloaded when the
+// files are rotated.
+type SPIFFEConfig struct {
+	// SVIDFile is the PEM file with the X.509 SVID and its intermediates.
+	SVIDFile string `mapstructure:"svid_file"`
+	// SVIDKeyFile is the PEM file with the private key of the SVID.
+	SVIDKeyFile string `mapstructure:"svid_key_file"`
+	// BundleFile is the PEM file with the trust bundle used to validate the
+	// SVIDs presented by the scraped targets.
+	BundleFile string `mapstructure:"bundle_file"`
+	// TrustDomain is the trust domain the SVIDs of the targets must belong to.
+	TrustDomain string `mapstructure:"trust_domain"`
+	// AllowedIDs are the SPIFFE IDs accepted for the targets. When empty, any
+	// ID in the TrustDomain is accepted.
+	AllowedIDs []string `mapstructure:"allowed_ids"`
+	// Emitter makes the emitter present the SVID as client certificate.
+	Emitter bool `mapstructure:"emitter"`
+}
+
+// Enabled returns true if an SVID is configured.
+func (c SPIFFEConfig) Enabled() bool {
+	return c.SVIDFile != ""
+}
+
+// Validate returns an error if the configuration is not complete.
+func (c SPIFFEConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.SVIDKeyFile == "" {
+		return errors.New("svid_key_file is required")
+	}
+	if c.BundleFile == "" && c.TrustDomain != "" {
+		return errors.New("bundle_file is required to validate the targets SVIDs")
+	}
+	for _, id := range c.AllowedIDs {
+		if _, err := parseSPIFFEID(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrapeTLSConfig returns the TLS configuration to scrape the targets with the
+// SVID. If a bundle file is configured, the targets must present a valid SVID
+// instead of a certificate for their host name.
+func (c SPIFFEConfig) ScrapeTLSConfig() (*tls.Config, error) {
+	src, err := newSVIDSource(c)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetClientCertificate: src.clientCertificate,
+	}
+	if c.BundleFile != "" {
+		// The host name verification is replaced by the SPIFFE ID one.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = src.verifyPeerSVID
+	}
+	return tlsConfig, nil
+}
+
+// EmitterTLSConfig returns the TLS configuration to present the SVID as client
+// certificate when emitting. The server certificate is validated as usual.
+func (c SPIFFEConfig) EmitterTLSConfig() (*tls.Config, error) {
+	src, err := newSVIDSource(c)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetClientCertificate: src.clientCertificate}, nil
+}
+
+// svidSource holds the SVID and the trust bundle, reloading them when their
+// files are modified.
+type svidSource struct {
+	cfg SPIFFEConfig
+
+	mtx        sync.Mutex
+	svid       *tls.Certificate
+	svidMod    time.Time
+	bundle     *x509.CertPool
+	bundleMod  time.Time
+	allowedIDs map[string]bool
+}
+
+func newSVIDSource(cfg SPIFFEConfig) (*svidSource, error) {
+	src := &svidSource{cfg: cfg, allowedIDs: make(map[string]bool)}
+	for _, id := range cfg.AllowedIDs {
+		src.allowedIDs[id] = true
+	}
+	// Fail early if the files can't be loaded.
+	if _, err := src.certificate(); err != nil {
+		return nil, err
+	}
+	if cfg.BundleFile != "" {
+		if _, err := src.trustBundle(); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func (s *svidSource) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.certificate()
+}
+
+// certificate returns the SVID, reloading it if its files were modified.
+func (s *svidSource) certificate() (*tls.Certificate, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	mod, err := lastModification(s.cfg.SVIDFile, s.cfg.SVIDKeyFile)
+	if err != nil {
+		if s.svid != nil {
+			return s.svid, nil
+		}
+		return nil, err
+	}
+	if s.svid != nil && !mod.After(s.svidMod) {
+		return s.svid, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.cfg.SVIDFile, s.cfg.SVIDKeyFile)
+	if err != nil {
+		if s.svid != nil {
+			// The files may be in the middle of a rotation.
+			return s.svid, nil
+		}
+		return nil, fmt.Errorf("loading SVID: %w", err)
+	}
+	observeCertificateExpiry(s.cfg.SVIDFile, &cert)
+	s.svid = &cert
+	s.svidMod = mod
+	return s.svid, nil
+}
+
+// trustBundle returns the trust bundle, reloading it if its file was modified.
+func (s *svidSource) trustBundle() (*x509.CertPool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	mod, err := lastModification(s.cfg.BundleFile)
+	if err != nil {
+		if s.bundle != nil {
+			return s.bundle, nil
+		}
+		return nil, err
+	}
+	if s.bundle != nil && !mod.After(s.bundleMod) {
+		return s.bundle, nil
+	}
+
+	content, err := ioutil.ReadFile(s.cfg.BundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading trust bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		if s.bundle != nil {
+			return s.bundle, nil
+		}
+		return nil, fmt.Errorf("no certificates found in trust bundle %s", s.cfg.BundleFile)
+	}
+	s.bundle = pool
+	s.bundleMod = mod
+	return s.bundle, nil
+}
+
+// verifyPeerSVID validates that the certificate chain presented by the target
+// is an SVID of the trust bundle with an allowed SPIFFE ID.
+func (s *svidSource) verifyPeerSVID(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented by the target")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parsing target certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	bundle, err := s.trustBundle()
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("verifying target SVID: %w", err)
+	}
+
+	if len(certs[0].URIs) != 1 {
+		return fmt.Errorf("target SVID must have exactly one URI SAN, %d found", len(certs[0].URIs))
+	}
+	id := certs[0].URIs[0]
+	if id.Scheme != "spiffe" {
+		return fmt.Errorf("target certificate URI %q is not a SPIFFE ID", id)
+	}
+	if s.cfg.TrustDomain != "" && id.Host != s.cfg.TrustDomain {
+		return fmt.Errorf("target SPIFFE ID %q doesn't belong to trust domain %q", id, s.cfg.TrustDomain)
+	}
+	if len(s.allowedIDs) > 0 && !s.allowedIDs[id.String()] {
+		return fmt.Errorf("target SPIFFE ID %q is not allowed", id)
+	}
+	return nil
+}
+
+func parseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.Host == "" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+	return u, nil
+}
+
+// lastModification returns the latest modification time of the files.
+func lastModification(files ...string) (time.Time, error) {
+	var last time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
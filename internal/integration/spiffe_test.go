@@ -0,0 +1,143 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the DER certificate and the PEM certificate and key of an SVID.
+func (ca testCA) issue(t *testing.T, serial int64, spiffeID string) ([]byte, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id, err := url.Parse(spiffeID)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{id},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return der,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeSPIFFEFiles(t *testing.T, dir string, ca testCA, serial int64) SPIFFEConfig {
+	_, certPEM, keyPEM := ca.issue(t, serial, "spiffe://example.org/nri-prometheus")
+	cfg := SPIFFEConfig{
+		SVIDFile:    filepath.Join(dir, "svid.pem"),
+		SVIDKeyFile: filepath.Join(dir, "svid_key.pem"),
+		BundleFile:  filepath.Join(dir, "bundle.pem"),
+		TrustDomain: "example.org",
+	}
+	require.NoError(t, ioutil.WriteFile(cfg.SVIDFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(cfg.SVIDKeyFile, keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(cfg.BundleFile, ca.pem, 0600))
+	return cfg
+}
+
+func TestSVIDSourceReloadsRotatedSVID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+
+	cfg := writeSPIFFEFiles(t, dir, ca, 10)
+	src, err := newSVIDSource(cfg)
+	require.NoError(t, err)
+	cert, err := src.clientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), leaf.SerialNumber.Int64())
+
+	// When the SVID is rotated
+	writeSPIFFEFiles(t, dir, ca, 11)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cfg.SVIDFile, later, later))
+
+	cert, err = src.clientCertificate(nil)
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), leaf.SerialNumber.Int64())
+}
+
+func TestSVIDSourceVerifyPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	cfg := writeSPIFFEFiles(t, dir, ca, 1)
+	cfg.AllowedIDs = []string{"spiffe://example.org/exporter"}
+
+	src, err := newSVIDSource(cfg)
+	require.NoError(t, err)
+
+	allowed, _, _ := ca.issue(t, 2, "spiffe://example.org/exporter")
+	assert.NoError(t, src.verifyPeerSVID([][]byte{allowed}, nil))
+
+	notAllowed, _, _ := ca.issue(t, 3, "spiffe://example.org/other")
+	assert.Error(t, src.verifyPeerSVID([][]byte{notAllowed}, nil))
+
+	otherDomain, _, _ := ca.issue(t, 4, "spiffe://other.org/exporter")
+	assert.Error(t, src.verifyPeerSVID([][]byte{otherDomain}, nil))
+
+	unknownCA, _, _ := newTestCA(t).issue(t, 5, "spiffe://example.org/exporter")
+	assert.Error(t, src.verifyPeerSVID([][]byte{unknownCA}, nil))
+}
+
+func TestSPIFFEConfigValidate(t *testing.T) {
+	assert.NoError(t, SPIFFEConfig{}.Validate())
+	assert.Error(t, SPIFFEConfig{SVIDFile: "svid.pem"}.Validate())
+	assert.Error(t, SPIFFEConfig{SVIDFile: "svid.pem", SVIDKeyFile: "key.pem", TrustDomain: "example.org"}.Validate())
+	assert.Error(t, SPIFFEConfig{SVIDFile: "svid.pem", SVIDKeyFile: "key.pem", AllowedIDs: []string{"https://example.org"}}.Validate())
+	assert.NoError(t, SPIFFEConfig{SVIDFile: "svid.pem", SVIDKeyFile: "key.pem", AllowedIDs: []string{"spiffe://example.org/a"}}.Validate())
+}
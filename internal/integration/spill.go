@@ -0,0 +1,136 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+const spillFilePattern = "payload-*.gz"
+
+// errSpillQueueFull is returned when a payload doesn't fit in the SpillQueue.
+var errSpillQueueFull = errors.New("spill queue is full")
+
+// SpillQueue stores the raw payloads of the scraped targets, compressed, in a
+// directory until they are decoded. It bounds the memory used by the payloads
+// that are waiting to be processed when the emitters are slower than the
+// targets.
+type SpillQueue struct {
+	dir      string
+	maxBytes int64
+
+	mtx  sync.Mutex
+	size int64
+}
+
+// spilledPayload is a payload stored in the SpillQueue.
+type spilledPayload struct {
+	target endpoints.Target
+	path   string
+	size   int64
+}
+
+// NewSpillQueue returns a SpillQueue that stores up to maxBytes of compressed
+// payloads in dir. The payloads left in dir by previous executions are removed.
+func NewSpillQueue(dir string, maxBytes int64) (*SpillQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating spill directory: %w", err)
+	}
+	leftovers, err := filepath.Glob(filepath.Join(dir, spillFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range leftovers {
+		if err := os.Remove(f); err != nil {
+			return nil, fmt.Errorf("removing spilled payload: %w", err)
+		}
+	}
+	spillBytesMetric.Set(0)
+	return &SpillQueue{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// write stores the payload written by the fetch function. The payload is
+// discarded if the queue is full after storing it.
+func (q *SpillQueue) write(target endpoints.Target, fetch func(w io.Writer) error) (spilledPayload, error) {
+	f, err := ioutil.TempFile(q.dir, spillFilePattern)
+	if err != nil {
+		return spilledPayload{}, err
+	}
+	p := spilledPayload{target: target, path: f.Name()}
+
+	gz := gzip.NewWriter(f)
+	err = fetch(gz)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(p.path); err == nil {
+			p.size = info.Size()
+			err = q.reserve(p.size)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(p.path)
+		return spilledPayload{}, err
+	}
+	return p, nil
+}
+
+// read decodes a stored payload with the given function and removes it from
+// the queue.
+func (q *SpillQueue) read(p spilledPayload, decode func(r io.Reader, url string) (prometheus.MetricFamiliesByName, error)) (prometheus.MetricFamiliesByName, error) {
+	defer q.release(p)
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return decode(gz, p.target.URL.String())
+}
+
+// decodePayload decodes a payload in the Prometheus text format.
+func decodePayload(r io.Reader, _ string) (prometheus.MetricFamiliesByName, error) {
+	return prometheus.Decode(r)
+}
+
+func (q *SpillQueue) reserve(size int64) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.maxBytes > 0 && q.size+size > q.maxBytes {
+		spillDroppedMetric.Inc()
+		return errSpillQueueFull
+	}
+	q.size += size
+	spillBytesMetric.Set(float64(q.size))
+	return nil
+}
+
+func (q *SpillQueue) release(p spilledPayload) {
+	_ = os.Remove(p.path)
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.size -= p.size
+	spillBytesMetric.Set(float64(q.size))
+}
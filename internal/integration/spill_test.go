@@ -0,0 +1,79 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+const spillTestPayload = `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+`
+
+func writePayload(payload string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, payload)
+		return err
+	}
+}
+
+func TestSpillQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Leftovers of previous executions are removed
+	leftover := filepath.Join(dir, "payload-leftover.gz")
+	require.NoError(t, ioutil.WriteFile(leftover, []byte("garbage"), 0600))
+	q, err := NewSpillQueue(dir, 0)
+	require.NoError(t, err)
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+
+	p, err := q.write(endpoints.Target{Name: "target"}, writePayload(spillTestPayload))
+	require.NoError(t, err)
+	assert.Equal(t, p.size, q.size)
+	assert.Equal(t, "target", p.target.Name)
+
+	mfs, err := q.read(p, decodePayload)
+	require.NoError(t, err)
+	require.Contains(t, mfs, "go_goroutines")
+	assert.Equal(t, 42.0, mfs["go_goroutines"].Metric[0].GetGauge().GetValue())
+
+	// The payload is removed once read
+	assert.Equal(t, int64(0), q.size)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSpillQueueFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := NewSpillQueue(dir, 0)
+	require.NoError(t, err)
+
+	_, err = q.write(endpoints.Target{}, writePayload(spillTestPayload))
+	require.NoError(t, err)
+
+	// The queue is limited to the size of the stored payload
+	q.maxBytes = q.size
+	_, err = q.write(endpoints.Target{}, writePayload(spillTestPayload))
+	assert.Equal(t, errSpillQueueFull, err)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "the discarded payload must be removed")
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// sshCommand is the OpenSSH client used to reach the targets through a jump
+// host. Every connection to a target is forwarded by its own ssh process.
+var sshCommand = []string{"ssh"}
+
+// sshDialer dials the targets through an SSH jump host using the standard
+// input and output forwarding of the OpenSSH client (ssh -W).
+type sshDialer struct {
+	cfg     endpoints.SSHProxyConfig
+	command []string
+}
+
+func newSSHDialer(cfg endpoints.SSHProxyConfig) *sshDialer {
+	return &sshDialer{cfg: cfg, command: sshCommand}
+}
+
+// args returns the arguments of the ssh command forwarding a connection to addr.
+func (d *sshDialer) args(addr string) []string {
+	host, port, err := net.SplitHostPort(d.cfg.Host)
+	if err != nil {
+		host, port = d.cfg.Host, "22"
+	}
+	args := []string{
+		"-W", addr,
+		"-p", port,
+		"-l", d.cfg.User,
+		// Never prompt for passwords or host key confirmations.
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+	}
+	if d.cfg.KeyFile != "" {
+		args = append(args, "-i", d.cfg.KeyFile, "-o", "IdentitiesOnly=yes")
+	}
+	if d.cfg.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+d.cfg.KnownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	return append(args, "--", host)
+}
+
+// DialContext starts an ssh process forwarding its standard input and output
+// to addr, and returns them as a connection.
+func (d *sshDialer) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The process must outlive the dial context, as the connection is reused.
+	cmd := exec.Command(d.command[0], append(d.command[1:], d.args(addr)...)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &lockedBuffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting ssh to %s: %w", d.cfg.Host, err)
+	}
+	return &commandConn{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		local:  sshAddr(d.cfg.Host),
+		remote: sshAddr(addr),
+	}, nil
+}
+
+// commandConn is a net.Conn backed by the standard input and output of a
+// process. Deadlines are not supported, the process is killed on Close.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *lockedBuffer
+
+	closeOnce sync.Once
+	local     net.Addr
+	remote    net.Addr
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err == io.EOF && n == 0 {
+		if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+			return 0, fmt.Errorf("ssh: %s", msg)
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stdin.Close()
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return c.local }
+func (c *commandConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *commandConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+// lockedBuffer is a bytes.Buffer that can be written by the process while
+// being read by the connection.
+type lockedBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
+
+// setDialer makes the round tripper open its connections with the dialer.
+func setDialer(rt http.RoundTripper, dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	switch t := rt.(type) {
+	case *http.Transport:
+		t.DialContext = dial
+		t.Proxy = nil
+	case *bearerAuthFileRoundTripper:
+		setDialer(t.rt, dial)
+	}
+}
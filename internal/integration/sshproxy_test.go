@@ -0,0 +1,88 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// TestSSHHelperProcess isn't a real test. It's used as a fake ssh command that
+// forwards its standard input and output to the address of the -W argument.
+func TestSSHHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_SSH_HELPER_PROCESS") != "1" {
+		return
+	}
+	var addr string
+	for i, arg := range os.Args {
+		if arg == "-W" {
+			addr = os.Args[i+1]
+		}
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		os.Exit(1)
+	}
+	go func() { _, _ = io.Copy(conn, os.Stdin) }()
+	_, _ = io.Copy(os.Stdout, conn)
+	os.Exit(0)
+}
+
+func TestSSHDialerArgs(t *testing.T) {
+	d := newSSHDialer(endpoints.SSHProxyConfig{
+		Host:           "jump.example.com:2222",
+		User:           "scraper",
+		KeyFile:        "/etc/ssh/id_ed25519",
+		KnownHostsFile: "/etc/ssh/known_hosts",
+	})
+	assert.Equal(t, []string{
+		"-W", "10.0.0.1:9100",
+		"-p", "2222",
+		"-l", "scraper",
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-i", "/etc/ssh/id_ed25519", "-o", "IdentitiesOnly=yes",
+		"-o", "UserKnownHostsFile=/etc/ssh/known_hosts", "-o", "StrictHostKeyChecking=yes",
+		"--", "jump.example.com",
+	}, d.args("10.0.0.1:9100"))
+
+	d = newSSHDialer(endpoints.SSHProxyConfig{Host: "jump.example.com", User: "scraper"})
+	assert.Equal(t, []string{"-p", "22"}, d.args("10.0.0.1:9100")[2:4])
+}
+
+func TestFetcher_SSHProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("some_metric 1\n"))
+	}))
+	defer srv.Close()
+
+	defer func(command []string) { sshCommand = command }(sshCommand)
+	sshCommand = []string{os.Args[0], "-test.run=TestSSHHelperProcess", "--"}
+	require.NoError(t, os.Setenv("GO_WANT_SSH_HELPER_PROCESS", "1"))
+	defer os.Unsetenv("GO_WANT_SSH_HELPER_PROCESS")
+
+	u, err := url.Parse(srv.URL + "/metrics")
+	require.NoError(t, err)
+	target := endpoints.New("behind-jump-host", *u, endpoints.Object{})
+	target.SSHProxy = endpoints.SSHProxyConfig{Host: "jump.example.com", User: "scraper"}
+
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", false, queueLength)
+	var results []TargetMetrics
+	for r := range fetcher.Fetch(context.Background(), []endpoints.Target{target}) {
+		results = append(results, r)
+	}
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Metrics, 1)
+	assert.Equal(t, "some_metric", results[0].Metrics[0].name)
+}
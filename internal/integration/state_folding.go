@@ -0,0 +1,52 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import "fmt"
+
+// StateFoldingRule folds the families that encode a state as a gauge per
+// possible value, 1 for the current one and 0 for the others, like
+// kube_pod_status_phase{phase="Running"}, into a single series with the
+// current value in the attribute. The value of the folded series is the
+// number of states set, so it's 1 unless the exporter reports several states,
+// which are then joined by commas, or none, and the attribute is empty.
+type StateFoldingRule struct {
+	// Metric is the name of the family.
+	Metric string `mapstructure:"metric"`
+	// Attribute is the attribute holding the state.
+	Attribute string `mapstructure:"attribute"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if the rule is not valid.
+func (r StateFoldingRule) Validate() error {
+	if r.Metric == "" {
+		return fmt.Errorf("metric is required")
+	}
+	if r.Attribute == "" {
+		return fmt.Errorf("attribute is required")
+	}
+	return nil
+}
+
+// FoldStates applies the rules to the metrics of the target. The series of
+// the families without the attribute are not folded.
+func FoldStates(targetMetrics *TargetMetrics, rules []StateFoldingRule) {
+	if len(rules) == 0 {
+		return
+	}
+	attributes := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		if _, ok := attributes[rule.Metric]; !ok {
+			attributes[rule.Metric] = rule.Attribute
+		}
+	}
+	targetMetrics.Metrics = foldStates(targetMetrics.Metrics, func(m Metric) string {
+		attribute := attributes[m.name]
+		if _, ok := m.attributes[attribute].(string); !ok || m.metricType != metricType_GAUGE {
+			return ""
+		}
+		return attribute
+	})
+}
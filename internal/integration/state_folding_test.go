@@ -0,0 +1,44 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestStateFoldingRuleValidate(t *testing.T) {
+	assert.NoError(t, StateFoldingRule{Metric: "kube_pod_status_phase", Attribute: "phase"}.Validate())
+	assert.Error(t, StateFoldingRule{Attribute: "phase"}.Validate())
+	assert.Error(t, StateFoldingRule{Metric: "kube_pod_status_phase"}.Validate())
+}
+
+func TestFoldStates(t *testing.T) {
+	phase := func(pod, phase string, value float64) Metric {
+		return Metric{name: "kube_pod_status_phase", metricType: metricType_GAUGE, value: value,
+			attributes: labels.Set{"pod": pod, "phase": phase}}
+	}
+	pair := TargetMetrics{Metrics: []Metric{
+		phase("web", "Pending", 0),
+		phase("web", "Running", 1),
+		phase("web", "Failed", 0),
+		phase("job", "Pending", 0),
+		phase("job", "Succeeded", 0),
+		{name: "kube_pod_info", metricType: metricType_GAUGE, value: 1.0, attributes: labels.Set{"pod": "web"}},
+		{name: "kube_pod_status_phase", metricType: metricType_GAUGE, value: 1.0, attributes: labels.Set{"pod": "other"}},
+	}}
+
+	FoldStates(&pair, []StateFoldingRule{{Metric: "kube_pod_status_phase", Attribute: "phase"}})
+
+	require.Len(t, pair.Metrics, 4)
+	assert.Equal(t, labels.Set{"pod": "web", "phase": "Running"}, pair.Metrics[0].attributes)
+	assert.Equal(t, 1.0, pair.Metrics[0].value)
+	assert.Equal(t, labels.Set{"pod": "job", "phase": ""}, pair.Metrics[1].attributes)
+	assert.Equal(t, 0.0, pair.Metrics[1].value)
+	assert.Equal(t, "kube_pod_info", pair.Metrics[2].name)
+	assert.Equal(t, labels.Set{"pod": "other"}, pair.Metrics[3].attributes, "the series without the attribute are kept")
+}
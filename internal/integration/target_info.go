@@ -0,0 +1,149 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// targetInfoName is the metric with the resource attributes of the
+// applications instrumented with OpenTelemetry, emitted by its Prometheus
+// exporters.
+const targetInfoName = "target_info"
+
+// resourceNamespaces are the namespaces of the OpenTelemetry semantic
+// conventions for resources. The labels of target_info starting with one of
+// them followed by _ are named after the resource attribute they come from.
+var resourceNamespaces = []string{
+	"aws", "azure", "browser", "cloud", "container", "deployment", "device",
+	"faas", "gcp", "heroku", "host", "k8s", "os", "process", "service",
+	"telemetry", "webengine",
+}
+
+// resourceAttributeNames are the resource attributes of the semantic
+// conventions whose names have underscores, which can't be restored by
+// replacing them with dots.
+var resourceAttributeNames = map[string]string{
+	"cloud_availability_zone": "cloud.availability_zone",
+	"cloud_resource_id":       "cloud.resource_id",
+	"os_build_id":             "os.build_id",
+	"process_command_args":    "process.command_args",
+	"process_command_line":    "process.command_line",
+	"process_parent_pid":      "process.parent_pid",
+}
+
+// FetcherWithTargetInfo makes the Fetcher add the labels of the target_info
+// metric of the targets instrumented with OpenTelemetry to all their other
+// metrics, named after the resource attributes they come from, e.g.
+// service.name for service_name, so they keep identifying the service.
+func FetcherWithTargetInfo() FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.targetInfo = true
+	}
+}
+
+// targetInfoKey identifies the resource of the series of an OpenTelemetry
+// Collector, which exports the metrics of several resources with their job
+// and instance labels. They are empty for the series of an application.
+type targetInfoKey struct {
+	job, instance string
+}
+
+func targetInfoKeyOf(m Metric) targetInfoKey {
+	job, _ := m.attributes["job"].(string)
+	instance, _ := m.attributes["instance"].(string)
+	return targetInfoKey{job: job, instance: instance}
+}
+
+// targetInfoResources are the resource attributes of the target_info series
+// of a target, by the resource they belong to.
+type targetInfoResources map[targetInfoKey]labels.Set
+
+// collectTargetInfo returns the resource attributes of the target_info
+// series of the metrics. The service name, namespace and instance are taken
+// from the job and instance labels when they aren't labels of their own, as
+// the exporters translate them.
+func collectTargetInfo(metrics []Metric) targetInfoResources {
+	var resources targetInfoResources
+	for _, m := range metrics {
+		if m.name != targetInfoName {
+			continue
+		}
+		key := targetInfoKeyOf(m)
+		attributes := labels.Set{}
+		for name, value := range m.attributes {
+			if integrationAttributes[name] || name == "job" || name == "instance" {
+				continue
+			}
+			attributes[resourceAttributeName(name)] = value
+		}
+		if key.job != "" {
+			namespace, name := "", key.job
+			if i := strings.Index(key.job, "/"); i >= 0 {
+				namespace, name = key.job[:i], key.job[i+1:]
+			}
+			setIfMissing(attributes, "service.name", name)
+			if namespace != "" {
+				setIfMissing(attributes, "service.namespace", namespace)
+			}
+		}
+		if key.instance != "" {
+			setIfMissing(attributes, "service.instance.id", key.instance)
+		}
+		if len(attributes) == 0 {
+			continue
+		}
+		if resources == nil {
+			resources = targetInfoResources{}
+		}
+		resources[key] = attributes
+	}
+	return resources
+}
+
+// promote adds the resource attributes to the metrics of the resource, or to
+// all of them for the target_info series without job and instance, unless
+// they have an attribute with the same name.
+func (r targetInfoResources) promote(metrics []Metric) {
+	if len(r) == 0 {
+		return
+	}
+	for _, m := range metrics {
+		if m.name == targetInfoName {
+			continue
+		}
+		attributes, ok := r[targetInfoKeyOf(m)]
+		if !ok {
+			attributes, ok = r[targetInfoKey{}]
+		}
+		if !ok {
+			continue
+		}
+		for name, value := range attributes {
+			setIfMissing(m.attributes, name, value)
+		}
+	}
+}
+
+// resourceAttributeName returns the name of the resource attribute the label
+// of target_info comes from. The labels out of the semantic conventions are
+// kept as they are.
+func resourceAttributeName(label string) string {
+	if name, ok := resourceAttributeNames[label]; ok {
+		return name
+	}
+	for _, ns := range resourceNamespaces {
+		if strings.HasPrefix(label, ns+"_") {
+			return strings.ReplaceAll(label, "_", ".")
+		}
+	}
+	return label
+}
+
+func setIfMissing(attributes labels.Set, name string, value interface{}) {
+	if _, ok := attributes[name]; !ok {
+		attributes[name] = value
+	}
+}
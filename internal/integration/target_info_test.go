@@ -0,0 +1,69 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetInfo(t *testing.T) {
+	byName := func(metrics []Metric) map[string]Metric {
+		m := map[string]Metric{}
+		for _, metric := range metrics {
+			instance, _ := metric.attributes["instance"].(string)
+			m[metric.name+"/"+instance] = metric
+		}
+		return m
+	}
+
+	t.Run("application", func(t *testing.T) {
+		metrics, err := ParseMetrics(strings.NewReader(`# TYPE target_info gauge
+target_info{service_name="checkout",service_namespace="shop",telemetry_sdk_language="go",cloud_availability_zone="a",team="payments"} 1
+# TYPE http_requests counter
+http_requests{route="/",team="web"} 10
+`), "target")
+		require.NoError(t, err)
+		collectTargetInfo(metrics).promote(metrics)
+		m := byName(metrics)
+
+		requests := m["http_requests/"].attributes
+		assert.Equal(t, "checkout", requests["service.name"])
+		assert.Equal(t, "shop", requests["service.namespace"])
+		assert.Equal(t, "go", requests["telemetry.sdk.language"])
+		assert.Equal(t, "a", requests["cloud.availability_zone"])
+		assert.Equal(t, "web", requests["team"], "the metric labels are not overwritten")
+		assert.NotContains(t, requests, "service_name")
+		assert.NotContains(t, m["target_info/"].attributes, "service.name")
+	})
+
+	t.Run("collector", func(t *testing.T) {
+		metrics, err := ParseMetrics(strings.NewReader(`# TYPE target_info gauge
+target_info{job="shop/checkout",instance="pod-1",host_name="node-1"} 1
+target_info{job="cart",instance="pod-2"} 1
+# TYPE up gauge
+up{job="shop/checkout",instance="pod-1"} 1
+up{job="cart",instance="pod-2"} 1
+up{job="other",instance="pod-3"} 1
+`), "target")
+		require.NoError(t, err)
+		collectTargetInfo(metrics).promote(metrics)
+		m := byName(metrics)
+
+		checkout := m["up/pod-1"].attributes
+		assert.Equal(t, "checkout", checkout["service.name"])
+		assert.Equal(t, "shop", checkout["service.namespace"])
+		assert.Equal(t, "pod-1", checkout["service.instance.id"])
+		assert.Equal(t, "node-1", checkout["host.name"])
+
+		cart := m["up/pod-2"].attributes
+		assert.Equal(t, "cart", cart["service.name"])
+		assert.NotContains(t, cart, "service.namespace")
+		assert.NotContains(t, cart, "host.name")
+
+		assert.NotContains(t, m["up/pod-3"].attributes, "service.name", "the resource of the series must match")
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+// targetOptionsDoer sets the headers and credentials of a target in its
+// requests before doing them.
+type targetOptionsDoer struct {
+	inner   prometheus.HTTPDoer
+	headers map[string]string
+	auth    endpoints.TargetAuthConfig
+}
+
+// Do sets the headers and credentials of the target in the request and does
+// it. The credential files are read for every request.
+func (d targetOptionsDoer) Do(req *http.Request) (*http.Response, error) {
+	for name, value := range d.headers {
+		req.Header.Set(name, value)
+	}
+	if d.auth.Username != "" {
+		password, err := readCredential(d.auth.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(d.auth.Username, password)
+	}
+	if d.auth.BearerTokenFile != "" {
+		token, err := readCredential(d.auth.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return d.inner.Do(req)
+}
+
+// readCredential returns the trimmed contents of the file.
+func readCredential(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read credentials file %s: %s", file, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// withTargetOptions returns the client setting the headers and credentials of
+// the target, if it has any.
+func withTargetOptions(t endpoints.Target, c prometheus.HTTPDoer) prometheus.HTTPDoer {
+	if len(t.Headers) == 0 && t.Auth.IsEmpty() {
+		return c
+	}
+	return targetOptionsDoer{inner: c, headers: t.Headers, auth: t.Auth}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// TargetQueuesConfig splits the targets in shards, each with its own bounded
+// queue processed concurrently, so a target with a huge payload, like
+// kube-state-metrics, doesn't delay the processing of the others.
+type TargetQueuesConfig struct {
+	// Shards is the number of queues the targets are distributed among, by
+	// their URL. The targets share a single queue when zero.
+	Shards int `mapstructure:"shards"`
+	// Length is the number of scraped targets each queue holds. The metrics
+	// of the targets whose queue is full are dropped. Defaults to
+	// queue_length.
+	Length int `mapstructure:"length"`
+}
+
+// Enabled returns true if the targets are split in shards.
+func (c TargetQueuesConfig) Enabled() bool {
+	return c.Shards > 0
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c TargetQueuesConfig) Validate() error {
+	if c.Shards < 0 {
+		return fmt.Errorf("shards can't be negative")
+	}
+	if c.Length < 0 {
+		return fmt.Errorf("length can't be negative")
+	}
+	return nil
+}
+
+// ShardedProcessor wraps the given Processor, running it for each shard of
+// the targets with its own queue. The metrics of the targets are never
+// waiting for the queue of another shard: if the queue of their shard is full
+// they are dropped, and counted by target. The targets of each shard keep
+// their order, but the ones of different shards are interleaved.
+func ShardedProcessor(cfg TargetQueuesConfig, next Processor, queueLength int) Processor {
+	length := cfg.Length
+	if length == 0 {
+		length = queueLength
+	}
+	log := logrus.WithField("component", "TargetQueues")
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		merged := make(chan TargetMetrics, queueLength)
+		queues := make([]chan TargetMetrics, cfg.Shards)
+		var wg sync.WaitGroup
+		wg.Add(cfg.Shards)
+		for i := range queues {
+			queues[i] = make(chan TargetMetrics, length)
+			go func(processed <-chan TargetMetrics) {
+				defer wg.Done()
+				for pair := range processed {
+					merged <- pair
+				}
+			}(next(queues[i]))
+		}
+		go func() {
+			wg.Wait()
+			close(merged)
+		}()
+
+		go func() {
+			defer func() {
+				for _, q := range queues {
+					close(q)
+				}
+			}()
+			for pair := range pairs {
+				shard := int(xxhash.Sum64String(pair.Target.URL.String()) % uint64(cfg.Shards))
+				select {
+				case queues[shard] <- pair:
+				default:
+					targetQueueDroppedMetric.WithLabelValues(pair.Target.Name).Inc()
+					log.WithField("target", pair.Target.Name).WithField("shard", shard).
+						Warnf("dropping %d metrics, the queue of the target is full", len(pair.Metrics))
+				}
+			}
+		}()
+		return merged
+	}
+}
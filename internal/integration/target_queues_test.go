@@ -0,0 +1,84 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestTargetQueuesConfigValidate(t *testing.T) {
+	assert.False(t, TargetQueuesConfig{}.Enabled())
+	assert.True(t, TargetQueuesConfig{Shards: 2}.Enabled())
+	assert.NoError(t, TargetQueuesConfig{Shards: 2, Length: 10}.Validate())
+	assert.Error(t, TargetQueuesConfig{Shards: -1}.Validate())
+	assert.Error(t, TargetQueuesConfig{Length: -1}.Validate())
+}
+
+func TestShardedProcessor(t *testing.T) {
+	const shards = 2
+	// targets returns targets of the given shard.
+	targets := func(shard, n int) []endpoints.Target {
+		var ts []endpoints.Target
+		for i := 0; len(ts) < n; i++ {
+			u, err := url.Parse(fmt.Sprintf("http://target-%d:8080/metrics", i))
+			require.NoError(t, err)
+			if xxhash.Sum64String(u.String())%shards == uint64(shard) {
+				ts = append(ts, endpoints.Target{Name: u.Host, URL: *u})
+			}
+		}
+		return ts
+	}
+	slow, fast := targets(0, 3), targets(1, 1)
+	dropped := func() float64 {
+		var m dto.Metric
+		require.NoError(t, targetQueueDroppedMetric.WithLabelValues(slow[2].Name).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	before := dropped()
+
+	// The processing of the first slow target blocks its shard.
+	started, release := make(chan struct{}), make(chan struct{})
+	next := func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		processed := make(chan TargetMetrics)
+		go func() {
+			defer close(processed)
+			for pair := range pairs {
+				if pair.Target.Name == slow[0].Name {
+					close(started)
+					<-release
+				}
+				processed <- pair
+			}
+		}()
+		return processed
+	}
+	pairs := make(chan TargetMetrics)
+	processed := ShardedProcessor(TargetQueuesConfig{Shards: shards, Length: 1}, next, 0)(pairs)
+
+	pairs <- TargetMetrics{Target: slow[0]}
+	<-started
+	// The targets of other shards don't wait for it.
+	pairs <- TargetMetrics{Target: fast[0]}
+	assert.Equal(t, fast[0].Name, (<-processed).Target.Name)
+	// The ones of its shard are queued until the queue is full.
+	pairs <- TargetMetrics{Target: slow[1]}
+	pairs <- TargetMetrics{Target: slow[2]}
+	close(pairs)
+	close(release)
+
+	var names []string
+	for pair := range processed {
+		names = append(names, pair.Target.Name)
+	}
+	assert.Equal(t, []string{slow[0].Name, slow[1].Name}, names)
+	assert.Equal(t, before+1, dropped())
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/newrelic/newrelic-telemetry-sdk-go/cumulative"
 	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/newrelic/nri-prometheus/internal/pkg/fips"
 	"github.com/pkg/errors"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
@@ -22,13 +26,62 @@ type harvester interface {
 	HarvestNow(ct context.Context)
 }
 
+// eventRecorder is implemented by the harvesters that can report events.
+type eventRecorder interface {
+	RecordEvent(e telemetry.Event) error
+}
+
+// recordEvent records the event in the harvester if it supports events.
+func recordEvent(h harvester, e telemetry.Event) error {
+	r, ok := h.(eventRecorder)
+	if !ok {
+		return fmt.Errorf("the harvester doesn't support events")
+	}
+	return r.RecordEvent(e)
+}
+
+// flusher is implemented by the harvesters that can report their stored
+// metrics synchronously.
+type flusher interface {
+	flush(ctx context.Context)
+}
+
 // TelemetryEmitter emits metrics using the go-telemetry-sdk.
 type TelemetryEmitter struct {
 	name            string
 	harvester       harvester
-	deltaCalculator *cumulative.DeltaCalculator
+	deltaCalculator *deltaCalculator
+	// checkpoint configures the checkpoints of the delta calculator, and
+	// lastCheckpoint is when the last one was written.
+	checkpoint     CounterCheckpointConfig
+	checkpointMtx  sync.Mutex
+	lastCheckpoint time.Time
+	// rollup is nil unless some counters are rolled up.
+	rollup *counterRollup
+	// distribution is true when the histograms are emitted as a single
+	// datapoint with their buckets.
+	distribution bool
+	// timestampBounds checks the timestamps reported by the targets.
+	timestampBounds TimestampBoundsConfig
+	// failOnDroppedTimestamps makes the metrics dropped out of bounds an
+	// error of Emit.
+	failOnDroppedTimestamps bool
+
+	// client, apiKey and metricsURL are used to verify the credentials
+	// outside of the harvester.
+	client     *http.Client
+	apiKey     string
+	metricsURL string
 }
 
+// defaultMetricsURL is the metrics endpoint used by the telemetry harvester
+// when it is not overridden.
+const defaultMetricsURL = "https://metric-api.newrelic.com/metric/v1"
+
+// ErrLicenseKeyRejected is returned by CheckAuth when the metrics endpoint
+// rejects the configured credentials.
+var ErrLicenseKeyRejected = errors.New("license key rejected by the metrics endpoint")
+
 // TelemetryEmitterConfig is the configuration required for the
 // `TelemetryEmitter`
 type TelemetryEmitterConfig struct {
@@ -43,6 +96,28 @@ type TelemetryEmitterConfig struct {
 	// duration between checking for expirations. Defaults to 30s.
 	DeltaExpirationCheckInternval time.Duration
 
+	// CounterRollup configures the counters emitted as a summary per
+	// interval instead of a datapoint per scrape.
+	CounterRollup CounterRollupConfig
+
+	// HistogramEmission is the mode of emission of the histograms,
+	// HistogramEmissionBuckets or HistogramEmissionDistribution. Defaults
+	// to HistogramEmissionBuckets.
+	HistogramEmission string
+
+	// TimestampBounds is the window of the timestamps accepted by the Metric
+	// API. The defaults are used if it's not set.
+	TimestampBounds TimestampBoundsConfig
+
+	// FailOnDroppedTimestamps makes Emit return an error when metrics are
+	// dropped for being out of TimestampBounds, so a replay of old payloads
+	// doesn't succeed without them.
+	FailOnDroppedTimestamps bool
+
+	// CounterCheckpoint configures the checkpoints of the last values of the
+	// counters, restored when the emitter is created.
+	CounterCheckpoint CounterCheckpointConfig
+
 	// boundedHarvester configuration
 	DisableBoundedHarvester bool
 	BoundedHarvesterCfg
@@ -59,6 +134,14 @@ func TelemetryHarvesterWithMetricsURL(url string) TelemetryHarvesterOpt {
 	}
 }
 
+// TelemetryHarvesterWithEventsURL sets the url to use for the events
+// endpoint. The default one is used if it is empty.
+func TelemetryHarvesterWithEventsURL(url string) TelemetryHarvesterOpt {
+	return func(config *telemetry.Config) {
+		config.EventsURLOverride = url
+	}
+}
+
 // TelemetryHarvesterWithHarvestPeriod sets harvest period.
 func telemetryHarvesterZeroPeriod(config *telemetry.Config) {
 	config.HarvestPeriod = 0
@@ -79,8 +162,27 @@ func TelemetryHarvesterWithLicenseKeyRoundTripper(licenseKey string) TelemetryHa
 	}
 }
 
+// TelemetryHarvesterWithCompressionLevel wraps the emitter client Transport
+// to compress the payloads with the given gzip level, from
+// gzip.HuffmanOnly (-2) to gzip.BestCompression (9), and record the
+// compression self-metrics. The payloads are compressed again only if the
+// level isn't gzip.DefaultCompression, the one of the telemetry SDK.
+//
+// Other options that modify the underlying Client.Transport should be
+// set before this one, because this will change the Transport type
+// to compressionRoundTripper.
+func TelemetryHarvesterWithCompressionLevel(level int) TelemetryHarvesterOpt {
+	return func(cfg *telemetry.Config) {
+		cfg.Client.Transport = newCompressionRoundTripper(
+			cfg.Client.Transport,
+			level,
+		)
+	}
+}
+
 // TelemetryHarvesterWithTLSConfig sets the TLS configuration to the
-// emitter client transport.
+// emitter client transport, restricted to the FIPS approved settings when the
+// mode is enabled.
 func TelemetryHarvesterWithTLSConfig(tlsConfig *tls.Config) TelemetryHarvesterOpt {
 
 	return func(cfg *telemetry.Config) {
@@ -99,7 +201,10 @@ func TelemetryHarvesterWithTLSConfig(tlsConfig *tls.Config) TelemetryHarvesterOp
 		}
 
 		t = t.Clone()
-		t.TLSClientConfig = tlsConfig
+		t.TLSClientConfig = fips.Apply(tlsConfig)
+		if files, ok := verifyingFiles(tlsConfig); ok {
+			t.DialTLS = verifiedTLSDialer(t, files)
+		}
 		cfg.Client.Transport = http.RoundTripper(t)
 		return
 	}
@@ -133,13 +238,13 @@ func TelemetryHarvesterWithProxy(proxyURL *url.URL) TelemetryHarvesterOpt {
 
 // NewTelemetryEmitter returns a new TelemetryEmitter.
 func NewTelemetryEmitter(cfg TelemetryEmitterConfig) (*TelemetryEmitter, error) {
-	dc := cumulative.NewDeltaCalculator()
+	dc := newDeltaCalculator()
 
 	deltaExpirationAge := defaultDeltaExpirationAge
 	if cfg.DeltaExpirationAge != 0 {
 		deltaExpirationAge = cfg.DeltaExpirationAge
 	}
-	dc.SetExpirationAge(deltaExpirationAge)
+	dc.expirationAge = deltaExpirationAge
 	logrus.Debugf(
 		"telemetry emitter configured with delta counter expiration age: %s",
 		deltaExpirationAge,
@@ -149,7 +254,7 @@ func NewTelemetryEmitter(cfg TelemetryEmitterConfig) (*TelemetryEmitter, error)
 	if cfg.DeltaExpirationCheckInternval != 0 {
 		deltaExpirationCheckInterval = cfg.DeltaExpirationCheckInternval
 	}
-	dc.SetExpirationCheckInterval(deltaExpirationCheckInterval)
+	dc.expirationCheckInterval = deltaExpirationCheckInterval
 	logrus.Debugf(
 		"telemetry emitter configured with delta counter expiration check interval: %s",
 		deltaExpirationCheckInterval,
@@ -170,48 +275,181 @@ func NewTelemetryEmitter(cfg TelemetryEmitterConfig) (*TelemetryEmitter, error)
 	// If we do send them, the harvester will always output these as errors
 	h = harvesterDecorator{h}
 
+	// The harvester doesn't expose its configuration, so it is built again
+	// to send the requests that verify the credentials.
+	hCfg := telemetry.Config{Client: &http.Client{}}
+	for _, opt := range cfg.HarvesterOpts {
+		opt(&hCfg)
+	}
+	metricsURL := hCfg.MetricsURLOverride
+	if metricsURL == "" {
+		metricsURL = defaultMetricsURL
+	}
+
+	if err := cfg.TimestampBounds.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid timestamp bounds")
+	}
+
+	if err := cfg.CounterCheckpoint.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid counter checkpoint")
+	}
+	if cfg.CounterCheckpoint.Enabled() {
+		restored, err := dc.load(cfg.CounterCheckpoint.Path, time.Now())
+		if err != nil {
+			logrus.WithError(err).Warn("could not restore the last values of the counters")
+		} else {
+			logrus.Debugf("restored the last values of %d counters from %s", restored, cfg.CounterCheckpoint.Path)
+		}
+	}
+
+	var rollup *counterRollup
+	if cfg.CounterRollup.Enabled() {
+		rollup = newCounterRollup(cfg.CounterRollup, time.Now())
+	}
+
 	return &TelemetryEmitter{
-		name:            "telemetry",
-		harvester:       h,
-		deltaCalculator: dc,
+		name:                    "telemetry",
+		harvester:               h,
+		deltaCalculator:         dc,
+		checkpoint:              cfg.CounterCheckpoint,
+		lastCheckpoint:          time.Now(),
+		rollup:                  rollup,
+		distribution:            cfg.HistogramEmission == HistogramEmissionDistribution,
+		timestampBounds:         cfg.TimestampBounds,
+		failOnDroppedTimestamps: cfg.FailOnDroppedTimestamps,
+		client:                  hCfg.Client,
+		apiKey:                  hCfg.APIKey,
+		metricsURL:              metricsURL,
 	}, nil
 }
 
+// CheckAuth sends an empty batch of metrics to the metrics endpoint to verify
+// that it accepts the configured credentials. It returns an error wrapping
+// ErrLicenseKeyRejected when they are rejected, and a different error when the
+// endpoint can't be reached.
+func (te *TelemetryEmitter) CheckAuth(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodPost, te.metricsURL, strings.NewReader("[]"))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Api-Key", te.apiKey)
+
+	resp, err := te.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("verifying credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrLicenseKeyRejected, resp.Status)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("verifying credentials: unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
 // Name returns the emitter name.
 func (te *TelemetryEmitter) Name() string {
 	return te.name
 }
 
+// Flush sends the metrics recorded in the harvester. It returns an error if
+// the context is done before they are sent.
+func (te *TelemetryEmitter) Flush(ctx context.Context) error {
+	if te.rollup != nil {
+		for _, s := range te.rollup.flush(time.Now()) {
+			te.harvester.RecordMetric(s)
+		}
+	}
+	if f, ok := te.harvester.(flusher); ok {
+		f.flush(ctx)
+	} else {
+		te.harvester.HarvestNow(ctx)
+	}
+	if te.checkpoint.Enabled() {
+		te.saveCheckpoint(time.Now())
+	}
+	return ctx.Err()
+}
+
+// saveCheckpoint writes the last values of the counters.
+func (te *TelemetryEmitter) saveCheckpoint(now time.Time) {
+	te.checkpointMtx.Lock()
+	defer te.checkpointMtx.Unlock()
+	te.lastCheckpoint = now
+	saved, err := te.deltaCalculator.save(te.checkpoint.Path)
+	if err != nil {
+		logrus.WithError(err).Warn("could not checkpoint the last values of the counters")
+		return
+	}
+	logrus.Debugf("checkpointed the last values of %d counters to %s", saved, te.checkpoint.Path)
+}
+
+// EmitEvent records an event to be sent with the metrics.
+func (te *TelemetryEmitter) EmitEvent(eventType string, attributes map[string]interface{}) error {
+	return recordEvent(te.harvester, telemetry.Event{
+		EventType:  eventType,
+		Timestamp:  time.Now(),
+		Attributes: attributes,
+	})
+}
+
 // Emit makes the mapping between Prometheus and NR metrics and records them
 // into the NR telemetry harvester.
 func (te *TelemetryEmitter) Emit(metrics []Metric) error {
 	var results error
 
 	// Record metrics at a uniform time so processing is not reflected in
-	// the measurement that already took place.
+	// the measurement that already took place, unless the target reported
+	// their timestamp.
 	now := time.Now()
+	var outOfBounds, dropped int
 	for _, metric := range metrics {
+		timestamp := now
+		if !metric.timestamp.IsZero() {
+			var ok bool
+			timestamp, ok = te.timestampBounds.check(metric.timestamp, now)
+			if timestamp != metric.timestamp {
+				outOfBounds++
+			}
+			if !ok {
+				dropped++
+				continue
+			}
+		}
 
 		switch metric.metricType {
 		case metricType_GAUGE:
+			if metric.omitTimestamp {
+				// The Metric API sets the time it receives the gauges
+				// without timestamp.
+				timestamp = time.Time{}
+			}
 			te.harvester.RecordMetric(telemetry.Gauge{
 				Name:       metric.name,
 				Attributes: metric.attributes,
 				Value:      metric.value.(float64),
-				Timestamp:  now,
+				Timestamp:  timestamp,
 			})
 		case metricType_COUNTER:
-			m, ok := te.deltaCalculator.CountMetric(
+			m, ok := te.deltaCalculator.countSeries(
+				metric.Fingerprint(),
 				metric.name,
 				metric.attributes,
 				metric.value.(float64),
-				now,
+				timestamp,
 			)
-			if ok {
+			if ok && te.rollup != nil && te.rollup.matches(metric.name) {
+				te.rollup.add(m)
+			} else if ok {
 				te.harvester.RecordMetric(m)
 			}
 		case metricType_SUMMARY:
-			if err := te.emitSummary(metric, now); err != nil {
+			if err := te.emitSummary(metric, timestamp); err != nil {
 				if results == nil {
 					results = err
 				} else {
@@ -219,7 +457,7 @@ func (te *TelemetryEmitter) Emit(metrics []Metric) error {
 				}
 			}
 		case metricType_HISTOGRAM:
-			if err := te.emitHistogram(metric, now); err != nil {
+			if err := te.emitHistogram(metric, timestamp); err != nil {
 				if results == nil {
 					results = err
 				} else {
@@ -236,9 +474,37 @@ func (te *TelemetryEmitter) Emit(metrics []Metric) error {
 			}
 		}
 	}
+	if outOfBounds > 0 {
+		logrus.Warnf("%d metrics have timestamps out of the window accepted by the Metric API, from %s ago to %s ahead: action %s",
+			outOfBounds, te.timestampBounds.MaxAge, te.timestampBounds.MaxFuture, te.timestampBounds.Action)
+	}
+	if dropped > 0 && te.failOnDroppedTimestamps {
+		err := fmt.Errorf("%d metrics dropped with timestamps out of the window accepted by the Metric API", dropped)
+		if results == nil {
+			results = err
+		} else {
+			results = fmt.Errorf("%v: %w", err, results)
+		}
+	}
+	if te.rollup != nil {
+		for _, s := range te.rollup.due(now) {
+			te.harvester.RecordMetric(s)
+		}
+	}
+	if te.checkpoint.Enabled() && te.checkpointDue(now) {
+		te.saveCheckpoint(now)
+	}
 	return results
 }
 
+// checkpointDue returns true if the interval since the last checkpoint has
+// elapsed.
+func (te *TelemetryEmitter) checkpointDue(now time.Time) bool {
+	te.checkpointMtx.Lock()
+	defer te.checkpointMtx.Unlock()
+	return now.Sub(te.lastCheckpoint) >= te.checkpoint.Interval
+}
+
 func (te *TelemetryEmitter) emitSummary(metric Metric, timestamp time.Time) error {
 	summary, ok := metric.value.(*dto.Summary)
 	if !ok {
@@ -281,6 +547,11 @@ func (te *TelemetryEmitter) emitHistogram(metric Metric, timestamp time.Time) er
 		return fmt.Errorf("unknown histogram metric type for %q: %T", metric.name, metric.value)
 	}
 
+	if te.distribution {
+		te.emitDistribution(metric, hist, timestamp)
+		return nil
+	}
+
 	if sumCount, ok := te.deltaCalculator.CountMetric(metric.name+"_sum", metric.attributes, float64(hist.GetSampleSum()), timestamp); ok {
 		te.harvester.RecordMetric(telemetry.Summary{
 			Name:       metric.name + "_sum",
@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strconv"
@@ -381,6 +382,46 @@ func TestTelemetryHarvesterWithProxy(t *testing.T) {
 	assert.Equal(t, proxyURL, actualProxyURL)
 }
 
+func TestTelemetryEmitterCheckAuth(t *testing.T) {
+	cases := []struct {
+		name       string
+		status     int
+		wantErr    bool
+		wantReject bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "bad request is authenticated", status: http.StatusBadRequest},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: true, wantReject: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantErr: true, wantReject: true},
+		{name: "server error", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var licenseKey string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				licenseKey = r.Header.Get("X-License-Key")
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			e, err := NewTelemetryEmitter(TelemetryEmitterConfig{
+				HarvesterOpts: []TelemetryHarvesterOpt{
+					telemetry.ConfigAPIKey("license"),
+					TelemetryHarvesterWithMetricsURL(srv.URL),
+					TelemetryHarvesterWithLicenseKeyRoundTripper("license"),
+				},
+				DisableBoundedHarvester: true,
+			})
+			require.NoError(t, err)
+
+			err = e.CheckAuth(context.Background())
+			assert.Equal(t, "license", licenseKey)
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantReject, errors.Is(err, ErrLicenseKeyRejected))
+		})
+	}
+}
+
 func emptyResponse(status int) *http.Response {
 	return &http.Response{
 		StatusCode: status,
@@ -0,0 +1,215 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultThresholdEventType is the type of the events generated by the
+// ThresholdRules.
+const DefaultThresholdEventType = "PrometheusThresholdEvent"
+
+// States of the series reported in the threshold events.
+const (
+	ThresholdTriggered = "triggered"
+	ThresholdResolved  = "resolved"
+)
+
+// thresholdStateTTL is how long the state of a series that is not scraped
+// anymore is kept.
+const thresholdStateTTL = time.Hour
+
+// ThresholdRule generates an event when a series of Metric meets the
+// condition for ForCycles consecutive scrapes, and another one when it
+// stops meeting it, e.g. up == 0 for 3 cycles.
+type ThresholdRule struct {
+	// Name identifies the rule in the events.
+	Name   string `mapstructure:"name"`
+	Metric string `mapstructure:"metric"`
+	// Operator is one of >, >=, <, <=, == or !=.
+	Operator string  `mapstructure:"operator"`
+	Value    float64 `mapstructure:"value"`
+	// ForCycles is the number of consecutive scrapes the condition must be
+	// met for. Defaults to 1.
+	ForCycles int `mapstructure:"for_cycles"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if the rule is not valid.
+func (r ThresholdRule) Validate() error {
+	if r.Name == "" || r.Metric == "" {
+		return fmt.Errorf("name and metric are required")
+	}
+	if _, ok := thresholdOperators[r.Operator]; !ok {
+		return fmt.Errorf("invalid operator %q, must be one of: >, >=, <, <=, ==, !=", r.Operator)
+	}
+	if r.ForCycles < 0 {
+		return fmt.Errorf("for_cycles can't be negative")
+	}
+	return nil
+}
+
+var thresholdOperators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// EventEmitter is implemented by the Emitters that can send events.
+type EventEmitter interface {
+	EmitEvent(eventType string, attributes map[string]interface{}) error
+}
+
+// thresholdState is the state of a series for a rule.
+type thresholdState struct {
+	cycles    int
+	triggered bool
+	lastSeen  time.Time
+}
+
+// thresholdWatcher evaluates the ThresholdRules on the scraped metrics.
+type thresholdWatcher struct {
+	rules     []ThresholdRule
+	eventType string
+	emitters  []Emitter
+	now       func() time.Time
+
+	mtx    sync.Mutex
+	states map[thresholdKey]*thresholdState
+}
+
+// ThresholdProcessor wraps the given Processor, generating events of the
+// given type when the metrics it returns meet the rules. The events are
+// logged, and sent by the emitters that support them.
+func ThresholdProcessor(rules []ThresholdRule, eventType string, emitters []Emitter, next Processor, queueLength int) Processor {
+	w := newThresholdWatcher(rules, eventType, emitters)
+	return func(pairs <-chan TargetMetrics) <-chan TargetMetrics {
+		watched := make(chan TargetMetrics, queueLength)
+		go func() {
+			defer close(watched)
+			for pair := range next(pairs) {
+				w.watch(pair.Metrics)
+				watched <- pair
+			}
+			w.expire()
+		}()
+		return watched
+	}
+}
+
+func newThresholdWatcher(rules []ThresholdRule, eventType string, emitters []Emitter) *thresholdWatcher {
+	if eventType == "" {
+		eventType = DefaultThresholdEventType
+	}
+	var enabled []ThresholdRule
+	for _, r := range rules {
+		if r.IsEnabled() {
+			enabled = append(enabled, r)
+		}
+	}
+	return &thresholdWatcher{
+		rules:     enabled,
+		eventType: eventType,
+		emitters:  emitters,
+		now:       time.Now,
+		states:    make(map[thresholdKey]*thresholdState),
+	}
+}
+
+// watch evaluates the rules on the metrics of a scrape.
+func (w *thresholdWatcher) watch(metrics []Metric) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	now := w.now()
+	for _, m := range metrics {
+		value, ok := m.value.(float64)
+		if !ok {
+			continue
+		}
+		for i, rule := range w.rules {
+			if rule.Metric != m.name {
+				continue
+			}
+			key := seriesKey(i, m)
+			state, ok := w.states[key]
+			if !ok {
+				state = &thresholdState{}
+				w.states[key] = state
+			}
+			state.lastSeen = now
+
+			forCycles := rule.ForCycles
+			if forCycles == 0 {
+				forCycles = 1
+			}
+			if thresholdOperators[rule.Operator](value, rule.Value) {
+				state.cycles++
+				if !state.triggered && state.cycles >= forCycles {
+					state.triggered = true
+					w.emit(rule, m, value, ThresholdTriggered)
+				}
+				continue
+			}
+			state.cycles = 0
+			if state.triggered {
+				state.triggered = false
+				w.emit(rule, m, value, ThresholdResolved)
+			}
+		}
+	}
+}
+
+// expire removes the state of the series not scraped for a while.
+func (w *thresholdWatcher) expire() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	now := w.now()
+	for key, state := range w.states {
+		if now.Sub(state.lastSeen) > thresholdStateTTL {
+			delete(w.states, key)
+		}
+	}
+}
+
+// emit logs the event and sends it through the emitters supporting events.
+func (w *thresholdWatcher) emit(rule ThresholdRule, m Metric, value float64, state string) {
+	attributes := map[string]interface{}{}
+	for k, v := range m.attributes {
+		attributes[k] = v
+	}
+	attributes["ruleName"] = rule.Name
+	attributes["metricName"] = m.name
+	attributes["value"] = value
+	attributes["condition"] = fmt.Sprintf("%s %s %g", rule.Metric, rule.Operator, rule.Value)
+	attributes["state"] = state
+	if rule.Owner != "" {
+		attributes["ruleOwner"] = rule.Owner
+	}
+
+	ilog.WithField("rule", rule.Name).Warnf("%s %s: %s is %g (%s)", w.eventType, state, m.name, value, attributes["condition"])
+	for _, e := range w.emitters {
+		if ee, ok := e.(EventEmitter); ok {
+			if err := ee.EmitEvent(w.eventType, attributes); err != nil {
+				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting threshold event")
+			}
+		}
+	}
+}
+
+// thresholdKey identifies a series of a metric for a rule.
+type thresholdKey struct {
+	rule   int
+	series uint64
+}
+
+// seriesKey identifies a series of a metric for the rule with the given index.
+func seriesKey(rule int, m Metric) thresholdKey {
+	return thresholdKey{rule: rule, series: m.Fingerprint()}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+type recordedEvent struct {
+	eventType  string
+	attributes map[string]interface{}
+}
+
+type eventRecordingEmitter struct {
+	recordingEmitter
+	mtx    sync.Mutex
+	events []recordedEvent
+}
+
+func (r *eventRecordingEmitter) EmitEvent(eventType string, attributes map[string]interface{}) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, recordedEvent{eventType: eventType, attributes: attributes})
+	return nil
+}
+
+func (r *eventRecordingEmitter) emittedEvents() []recordedEvent {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]recordedEvent(nil), r.events...)
+}
+
+func upMetric(target string, value float64) Metric {
+	return Metric{
+		name:       "up",
+		value:      value,
+		metricType: metricType_GAUGE,
+		attributes: labels.Set{"targetName": target},
+	}
+}
+
+func TestThresholdRuleValidate(t *testing.T) {
+	assert.NoError(t, ThresholdRule{Name: "down", Metric: "up", Operator: "==", Value: 0}.Validate())
+	assert.Error(t, ThresholdRule{Metric: "up", Operator: "=="}.Validate())
+	assert.Error(t, ThresholdRule{Name: "down", Metric: "up", Operator: "="}.Validate())
+	assert.Error(t, ThresholdRule{Name: "down", Metric: "up", Operator: "==", ForCycles: -1}.Validate())
+}
+
+func TestThresholdWatcher(t *testing.T) {
+	emitter := &eventRecordingEmitter{}
+	rules := []ThresholdRule{{Name: "target-down", Metric: "up", Operator: "==", Value: 0, ForCycles: 2}}
+	w := newThresholdWatcher(rules, "", []Emitter{emitter, &recordingEmitter{}})
+
+	w.watch([]Metric{upMetric("a", 0), upMetric("b", 1)})
+	assert.Empty(t, emitter.emittedEvents(), "the condition must be met for 2 cycles")
+
+	w.watch([]Metric{upMetric("a", 0), upMetric("b", 0)})
+	events := emitter.emittedEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, DefaultThresholdEventType, events[0].eventType)
+	assert.Equal(t, map[string]interface{}{
+		"targetName": "a",
+		"ruleName":   "target-down",
+		"metricName": "up",
+		"value":      0.0,
+		"condition":  "up == 0",
+		"state":      ThresholdTriggered,
+	}, events[0].attributes)
+
+	w.watch([]Metric{upMetric("a", 0), upMetric("b", 1)})
+	assert.Len(t, emitter.emittedEvents(), 1, "triggered series don't generate events while the condition is met")
+
+	w.watch([]Metric{upMetric("a", 1), upMetric("b", 1)})
+	events = emitter.emittedEvents()
+	require.Len(t, events, 2)
+	assert.Equal(t, "a", events[1].attributes["targetName"])
+	assert.Equal(t, ThresholdResolved, events[1].attributes["state"])
+	assert.Equal(t, 1.0, events[1].attributes["value"])
+}
+
+func TestThresholdWatcher_Expire(t *testing.T) {
+	now := time.Now()
+	rules := []ThresholdRule{{Name: "target-down", Metric: "up", Operator: "==", Value: 0, ForCycles: 2}}
+	w := newThresholdWatcher(rules, "CustomEvent", nil)
+	w.now = func() time.Time { return now }
+
+	w.watch([]Metric{upMetric("a", 0)})
+	require.Len(t, w.states, 1)
+
+	now = now.Add(thresholdStateTTL + time.Second)
+	w.expire()
+	assert.Empty(t, w.states)
+}
+
+func TestThresholdProcessor(t *testing.T) {
+	emitter := &eventRecordingEmitter{}
+	rules := []ThresholdRule{{Name: "target-down", Metric: "up", Operator: "<", Value: 1}}
+	processor := ThresholdProcessor(rules, "CustomEvent", []Emitter{emitter}, RuleProcessor(nil, 10), 10)
+
+	pairs := make(chan TargetMetrics, 1)
+	pairs <- TargetMetrics{Metrics: []Metric{upMetric("a", 0)}}
+	close(pairs)
+	var processed []TargetMetrics
+	for pair := range processor(pairs) {
+		processed = append(processed, pair)
+	}
+
+	require.Len(t, processed, 1)
+	events := emitter.emittedEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, "CustomEvent", events[0].eventType)
+	assert.Equal(t, ThresholdTriggered, events[0].attributes["state"])
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults of the window of timestamps accepted by the Metric API.
+const (
+	DefaultTimestampMaxAge    = 48 * time.Hour
+	DefaultTimestampMaxFuture = 10 * time.Minute
+)
+
+// Actions on the metrics whose timestamps are out of bounds.
+const (
+	// TimestampBoundsDrop drops the metric.
+	TimestampBoundsDrop = "drop"
+	// TimestampBoundsClamp replaces the timestamp with the closest bound.
+	TimestampBoundsClamp = "clamp"
+)
+
+// TimestampBoundsConfig configures the check of the timestamps of the metrics
+// emitted by the telemetry emitter against the window accepted by the Metric
+// API, which rejects the others without reporting it. It applies to the
+// timestamps reported by the targets and the replayed ones.
+type TimestampBoundsConfig struct {
+	// MaxAge is how old the timestamps can be. Defaults to 48h.
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MaxFuture is how far in the future the timestamps can be. Defaults to
+	// 10m.
+	MaxFuture time.Duration `mapstructure:"max_future"`
+	// Action on the metrics out of bounds: drop (default) or clamp.
+	Action string `mapstructure:"action"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *TimestampBoundsConfig) Validate() error {
+	if c.MaxAge < 0 || c.MaxFuture < 0 {
+		return fmt.Errorf("max_age and max_future can't be negative")
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = DefaultTimestampMaxAge
+	}
+	if c.MaxFuture == 0 {
+		c.MaxFuture = DefaultTimestampMaxFuture
+	}
+	if c.Action == "" {
+		c.Action = TimestampBoundsDrop
+	}
+	if c.Action != TimestampBoundsDrop && c.Action != TimestampBoundsClamp {
+		return fmt.Errorf("invalid action %q, must be one of: %s, %s", c.Action, TimestampBoundsDrop, TimestampBoundsClamp)
+	}
+	return nil
+}
+
+// check returns the timestamp to emit the metric with, and false if it's
+// dropped. The timestamps out of bounds are counted.
+func (c TimestampBoundsConfig) check(timestamp, now time.Time) (time.Time, bool) {
+	bound, limit := "", timestamp
+	if oldest := now.Add(-c.MaxAge); timestamp.Before(oldest) {
+		bound, limit = "past", oldest
+	} else if newest := now.Add(c.MaxFuture); timestamp.After(newest) {
+		bound, limit = "future", newest
+	}
+	if bound == "" {
+		return timestamp, true
+	}
+	outOfBoundsTimestampsMetric.WithLabelValues(bound, c.Action).Inc()
+	return limit, c.Action == TimestampBoundsClamp
+}
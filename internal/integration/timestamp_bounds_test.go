@@ -0,0 +1,68 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampBoundsConfigValidate(t *testing.T) {
+	cfg := TimestampBoundsConfig{}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, TimestampBoundsConfig{MaxAge: 48 * time.Hour, MaxFuture: 10 * time.Minute, Action: TimestampBoundsDrop}, cfg)
+
+	assert.Error(t, (&TimestampBoundsConfig{MaxAge: -time.Hour}).Validate())
+	assert.Error(t, (&TimestampBoundsConfig{Action: "correct"}).Validate())
+}
+
+func TestTimestampBoundsConfigCheck(t *testing.T) {
+	now := time.Now()
+	cfg := TimestampBoundsConfig{MaxAge: time.Hour, MaxFuture: time.Minute, Action: TimestampBoundsClamp}
+
+	ts, ok := cfg.check(now.Add(-30*time.Minute), now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(-30*time.Minute), ts)
+	ts, ok = cfg.check(now.Add(-2*time.Hour), now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(-time.Hour), ts)
+	ts, ok = cfg.check(now.Add(time.Hour), now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Minute), ts)
+
+	cfg.Action = TimestampBoundsDrop
+	_, ok = cfg.check(now.Add(-2*time.Hour), now)
+	assert.False(t, ok)
+	_, ok = cfg.check(now.Add(time.Hour), now)
+	assert.False(t, ok)
+}
+
+func TestTelemetryEmitter_TimestampBounds(t *testing.T) {
+	h := &recordingHarvester{}
+	te := &TelemetryEmitter{
+		harvester:       h,
+		deltaCalculator: newDeltaCalculator(),
+		timestampBounds: TimestampBoundsConfig{MaxAge: time.Hour, MaxFuture: time.Minute, Action: TimestampBoundsDrop},
+	}
+	now := time.Now()
+	require.NoError(t, te.Emit([]Metric{
+		{name: "current", metricType: metricType_GAUGE, value: 1.0},
+		{name: "recent", metricType: metricType_GAUGE, value: 1.0, timestamp: now.Add(-time.Minute)},
+		{name: "old", metricType: metricType_GAUGE, value: 1.0, timestamp: now.Add(-48 * time.Hour)},
+		{name: "ahead", metricType: metricType_GAUGE, value: 1.0, timestamp: now.Add(time.Hour)},
+	}))
+
+	var names []string
+	for _, m := range h.metrics {
+		names = append(names, m.(telemetry.Gauge).Name)
+	}
+	assert.Equal(t, []string{"current", "recent"}, names)
+
+	te.failOnDroppedTimestamps = true
+	err := te.Emit([]Metric{{name: "old", metricType: metricType_GAUGE, value: 1.0, timestamp: now.Add(-48 * time.Hour)}})
+	assert.EqualError(t, err, "1 metrics dropped with timestamps out of the window accepted by the Metric API")
+}
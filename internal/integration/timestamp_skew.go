@@ -0,0 +1,92 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions on the metrics whose timestamps are skewed.
+const (
+	// TimestampSkewCorrect replaces the timestamp with the scrape time.
+	TimestampSkewCorrect = "correct"
+	// TimestampSkewDrop drops the metric.
+	TimestampSkewDrop = "drop"
+)
+
+// TimestampSkewConfig configures the check of the timestamps reported by the
+// exporters, which are rejected by the New Relic APIs when they are too far
+// from the current time.
+type TimestampSkewConfig struct {
+	// MaxSkew is the maximum difference between a reported timestamp and the
+	// scrape time. Zero disables the check.
+	MaxSkew time.Duration `mapstructure:"max_skew"`
+	// Action on the skewed metrics: correct (default) or drop.
+	Action string `mapstructure:"action"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *TimestampSkewConfig) Validate() error {
+	if c.MaxSkew < 0 {
+		return fmt.Errorf("max_skew can't be negative")
+	}
+	if c.Action == "" {
+		c.Action = TimestampSkewCorrect
+	}
+	if c.Action != TimestampSkewCorrect && c.Action != TimestampSkewDrop {
+		return fmt.Errorf("invalid action %q, must be one of: %s, %s", c.Action, TimestampSkewCorrect, TimestampSkewDrop)
+	}
+	return nil
+}
+
+// FetcherWithTimestampSkew makes the Fetcher correct or drop the metrics whose
+// timestamps are skewed from the scrape time more than the configured maximum.
+func FetcherWithTimestampSkew(cfg TimestampSkewConfig) FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.timestampSkew = cfg
+	}
+}
+
+// apply corrects or drops the metrics of the target with skewed timestamps.
+func (c TimestampSkewConfig) apply(log *logrus.Entry, targetName string, metrics []Metric, now time.Time) []Metric {
+	if c.MaxSkew <= 0 {
+		return metrics
+	}
+	var skewed int
+	var maxSkew time.Duration
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if m.timestamp.IsZero() {
+			kept = append(kept, m)
+			continue
+		}
+		skew := m.timestamp.Sub(now)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew <= c.MaxSkew {
+			kept = append(kept, m)
+			continue
+		}
+		skewed++
+		if skew > maxSkew {
+			maxSkew = skew
+		}
+		if c.Action == TimestampSkewDrop {
+			continue
+		}
+		m.timestamp = now
+		kept = append(kept, m)
+	}
+	if skewed > 0 {
+		skewedDatapointsMetric.WithLabelValues(targetName, c.Action).Add(float64(skewed))
+		log.WithField("target", targetName).Warnf(
+			"%d metrics have timestamps skewed up to %s from the scrape time, more than the maximum of %s: action %s",
+			skewed, maxSkew, c.MaxSkew, c.Action)
+	}
+	return kept
+}
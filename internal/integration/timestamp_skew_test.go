@@ -0,0 +1,77 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func TestTimestampSkewConfigValidate(t *testing.T) {
+	cfg := TimestampSkewConfig{MaxSkew: time.Minute}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, TimestampSkewCorrect, cfg.Action)
+
+	assert.Error(t, (&TimestampSkewConfig{MaxSkew: -time.Minute}).Validate())
+	assert.Error(t, (&TimestampSkewConfig{Action: "ignore"}).Validate())
+}
+
+func skewMetrics(now time.Time) []Metric {
+	return []Metric{
+		{name: "no_timestamp", value: 1.0},
+		{name: "in_time", value: 1.0, timestamp: now.Add(-30 * time.Second)},
+		{name: "past", value: 1.0, timestamp: now.Add(-2 * time.Hour)},
+		{name: "future", value: 1.0, timestamp: now.Add(time.Hour)},
+	}
+}
+
+func TestTimestampSkewConfigApply(t *testing.T) {
+	now := time.Now()
+	log := logrus.WithField("component", "test")
+
+	t.Run("correct", func(t *testing.T) {
+		cfg := TimestampSkewConfig{MaxSkew: time.Minute, Action: TimestampSkewCorrect}
+		metrics := cfg.apply(log, "target", skewMetrics(now), now)
+		require.Len(t, metrics, 4)
+		assert.True(t, metrics[0].timestamp.IsZero())
+		assert.Equal(t, now.Add(-30*time.Second), metrics[1].timestamp)
+		assert.Equal(t, now, metrics[2].timestamp)
+		assert.Equal(t, now, metrics[3].timestamp)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		cfg := TimestampSkewConfig{MaxSkew: time.Minute, Action: TimestampSkewDrop}
+		metrics := cfg.apply(log, "target", skewMetrics(now), now)
+		require.Len(t, metrics, 2)
+		assert.Equal(t, "no_timestamp", metrics[0].name)
+		assert.Equal(t, "in_time", metrics[1].name)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		metrics := TimestampSkewConfig{}.apply(log, "target", skewMetrics(now), now)
+		require.Len(t, metrics, 4)
+		assert.Equal(t, now.Add(-2*time.Hour), metrics[2].timestamp)
+	})
+}
+
+func TestConvertPromMetrics_Timestamp(t *testing.T) {
+	mfs, err := prometheus.Decode(strings.NewReader("# TYPE up gauge\nup 1 1600000000000\ndown 0\n"))
+	require.NoError(t, err)
+
+	metrics := convertPromMetrics(nil, "target", mfs)
+	require.Len(t, metrics, 2)
+	for _, m := range metrics {
+		if m.name == "up" {
+			assert.Equal(t, time.Unix(1600000000, 0), m.timestamp)
+		} else {
+			assert.True(t, m.timestamp.IsZero())
+		}
+	}
+}
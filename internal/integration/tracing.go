@@ -0,0 +1,383 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+// Defaults of the export of the traces.
+const (
+	DefaultTracingEndpoint      = "http://localhost:4318/v1/traces"
+	DefaultTracingFlushInterval = 5 * time.Second
+	// maxPendingSpans bounds the spans waiting to be exported, so an
+	// unavailable collector doesn't make them grow without limit.
+	maxPendingSpans = 10000
+)
+
+// TracingConfig configures the OpenTelemetry traces of the scrape cycles:
+// one trace per cycle, with spans per retriever, target and pipeline stage.
+// They are exported to an OTLP/HTTP endpoint, JSON encoded.
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Endpoint is the URL the traces are posted to. Defaults to
+	// http://localhost:4318/v1/traces.
+	Endpoint string `mapstructure:"endpoint"`
+	// Headers are set in the export requests, e.g. the api-key of New Relic.
+	Headers map[string]string `mapstructure:"headers"`
+	// ServiceName is the service.name resource attribute. Defaults to
+	// nri-prometheus.
+	ServiceName string `mapstructure:"service_name"`
+	// FlushInterval is how often the ended spans are exported. Defaults to
+	// 5s.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *TracingConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = DefaultTracingEndpoint
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = Name
+	}
+	if c.FlushInterval < 0 {
+		return fmt.Errorf("flush_interval can't be negative")
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = DefaultTracingFlushInterval
+	}
+	return nil
+}
+
+// DefaultTracer traces the scrape cycles. Tracing is disabled while it's nil.
+var DefaultTracer *Tracer
+
+// Tracer records spans and exports them in batches.
+type Tracer struct {
+	cfg    TracingConfig
+	client *http.Client
+	log    *logrus.Entry
+
+	mtx     sync.Mutex
+	pending []*Span
+}
+
+// NewTracer returns a Tracer with the validated configuration.
+func NewTracer(cfg TracingConfig) *Tracer {
+	return &Tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		log:    logrus.WithField("component", "Tracer"),
+	}
+}
+
+type spanContextKey struct{}
+
+// Span is an operation of a trace. Its methods do nothing on a nil Span, so
+// the callers don't need to check whether tracing is enabled.
+type Span struct {
+	tracer     *Tracer
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       int
+	start, end time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// Kinds of the spans, as defined by OTLP.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// Start starts a span, child of the one in the context if any, and returns
+// the context with it.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	return t.StartAt(ctx, name, time.Now())
+}
+
+// StartAt starts a span at the given time, for the operations measured
+// before they are known to be traced.
+func (t *Tracer) StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: spanKindInternal, start: start, attributes: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+	}
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// SetAttribute sets an attribute of the span: a string, bool, integer or
+// float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil {
+		return
+	}
+	s.err = err
+}
+
+// End ends the span, queueing it to be exported.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	t := s.tracer
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		droppedSpansMetric.Inc()
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// Run exports the ended spans every flush interval until the context is
+// done.
+func (t *Tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Flush(ctx); err != nil {
+				t.log.WithError(err).Warn("could not export the traces")
+			}
+		}
+	}
+}
+
+// Flush exports the ended spans. They are discarded if they can't be
+// exported.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mtx.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.export(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", DefaultUserAgent())
+	for name, value := range t.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		droppedSpansMetric.Add(float64(len(spans)))
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		droppedSpansMetric.Add(float64(len(spans)))
+		return fmt.Errorf("the collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON encoding of the spans.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlpStatusError is the status code of the failed spans.
+const otlpStatusError = 2
+
+// export returns the OTLP/JSON payload of the spans.
+func (t *Tracer) export(spans []*Span) otlpTraces {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		encoded = append(encoded, span)
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]interface{}{
+			"service.name":    t.cfg.ServiceName,
+			"service.version": Version,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: Name, Version: Version},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+// otlpAttributes returns the attributes encoded as OTLP key-values. The
+// integers are encoded as strings, as OTLP/JSON expects for 64 bit values.
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, key := range keys {
+		value := attributes[key]
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: key, Value: v})
+	}
+	return encoded
+}
+
+// startScrapeSpan starts the span of the scrape of the target, child of the
+// span of the cycle in the context.
+func startScrapeSpan(ctx context.Context, t endpoints.Target) *Span {
+	_, span := DefaultTracer.Start(ctx, "scrape")
+	if span == nil {
+		return nil
+	}
+	u := t.URL
+	u.User = nil
+	span.kind = spanKindClient
+	span.SetAttribute("target", t.Name)
+	span.SetAttribute("retriever", t.Retriever)
+	span.SetAttribute("http.url", u.String())
+	return span
+}
+
+// tracedProcessor processes the pairs with the processor, recording a span
+// per target from the time it's fetched until it's processed, queueing
+// included.
+func tracedProcessor(ctx context.Context, processor Processor, pairs <-chan TargetMetrics) <-chan TargetMetrics {
+	if DefaultTracer == nil {
+		return processor(pairs)
+	}
+	var fetched sync.Map
+	in := make(chan TargetMetrics)
+	go func() {
+		defer close(in)
+		for pair := range pairs {
+			fetched.Store(pair.Target.URL.String(), time.Now())
+			in <- pair
+		}
+	}()
+	out := make(chan TargetMetrics)
+	go func() {
+		defer close(out)
+		for pair := range processor(in) {
+			start := time.Now()
+			if t, ok := fetched.Load(pair.Target.URL.String()); ok {
+				start = t.(time.Time)
+			}
+			_, span := DefaultTracer.StartAt(ctx, "process", start)
+			span.SetAttribute("target", pair.Target.Name)
+			span.SetAttribute("series", len(pair.Metrics))
+			span.End()
+			out <- pair
+		}
+	}()
+	return out
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+func TestTracingConfigValidate(t *testing.T) {
+	cfg := TracingConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, DefaultTracingEndpoint, cfg.Endpoint)
+	assert.Equal(t, Name, cfg.ServiceName)
+	assert.Equal(t, DefaultTracingFlushInterval, cfg.FlushInterval)
+
+	cfg = TracingConfig{Enabled: true, FlushInterval: -1}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop")
+	assert.Nil(t, span)
+	assert.NotNil(t, ctx)
+	span.SetAttribute("key", "value")
+	span.End()
+	assert.NoError(t, tracer.Flush(ctx))
+}
+
+func TestProcess_Traces(t *testing.T) {
+	exported := make(chan otlpTraces, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "key", r.Header.Get("Api-Key"))
+		var traces otlpTraces
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&traces))
+		exported <- traces
+	}))
+	defer collector.Close()
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer exporter.Close()
+
+	cfg := TracingConfig{Enabled: true, Endpoint: collector.URL, Headers: map[string]string{"Api-Key": "key"}}
+	require.NoError(t, cfg.Validate())
+	DefaultTracer = NewTracer(cfg)
+	defer func() { DefaultTracer = nil }()
+
+	retriever, err := endpoints.FixedRetriever(endpoints.TargetConfig{URLs: []endpoints.TargetURL{{URL: exporter.URL}}})
+	require.NoError(t, err)
+	fetcher := NewFetcher(fetchDuration, fetchTimeout, workerThreads, "", "", true, queueLength)
+	emitter := &recordingEmitter{}
+	process(context.Background(), []endpoints.TargetRetriever{retriever}, fetcher, RuleProcessor(nil, queueLength), []Emitter{emitter})
+	require.NoError(t, DefaultTracer.Flush(context.Background()))
+
+	traces := <-exported
+	require.Len(t, traces.ResourceSpans, 1)
+	require.Len(t, traces.ResourceSpans[0].ScopeSpans, 1)
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	require.Len(t, byName, 5, "spans: %+v", spans)
+	cycle := byName["scrape_cycle"]
+	assert.Empty(t, cycle.ParentSpanID)
+	for _, name := range []string{"discover", "scrape", "process", "emit"} {
+		s, ok := byName[name]
+		require.True(t, ok, name)
+		assert.Equal(t, cycle.TraceID, s.TraceID, name)
+		assert.Equal(t, cycle.SpanID, s.ParentSpanID, name)
+		assert.Nil(t, s.Status, name)
+	}
+	assert.Equal(t, spanKindClient, byName["scrape"].Kind)
+	assert.Contains(t, byName["emit"].Attributes, otlpAttribute{Key: "emitter", Value: map[string]interface{}{"stringValue": "recording"}})
+	assert.Contains(t, cycle.Attributes, otlpAttribute{Key: "targets", Value: map[string]interface{}{"intValue": "1"}})
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// unitConversion converts the values of a unit to a canonical base unit.
+type unitConversion struct {
+	base   string
+	factor float64
+}
+
+// unitConversions are the units normalized to seconds and bytes.
+var unitConversions = map[string]unitConversion{
+	"nanoseconds":  {"seconds", 1e-9},
+	"microseconds": {"seconds", 1e-6},
+	"milliseconds": {"seconds", 1e-3},
+	"minutes":      {"seconds", 60},
+	"hours":        {"seconds", 3600},
+	"days":         {"seconds", 86400},
+	"bits":         {"bytes", 1.0 / 8},
+	"kilobytes":    {"bytes", 1e3},
+	"megabytes":    {"bytes", 1e6},
+	"gigabytes":    {"bytes", 1e9},
+	"terabytes":    {"bytes", 1e12},
+	"kibibytes":    {"bytes", 1 << 10},
+	"mebibytes":    {"bytes", 1 << 20},
+	"gibibytes":    {"bytes", 1 << 30},
+	"tebibytes":    {"bytes", 1 << 40},
+}
+
+// FetcherWithUnitNormalization makes the Fetcher convert the metrics measured
+// in units like milliseconds or megabytes to seconds or bytes, replacing the
+// unit suffix of their names.
+func FetcherWithUnitNormalization() FetcherOpt {
+	return func(pf *prometheusFetcher) {
+		pf.normalizeUnits = true
+	}
+}
+
+// normalizeUnits converts the metrics to the base units. As with
+// addMetadata, the unit is the one in the suffix of the metric name, where
+// OpenMetrics requires the UNIT to be.
+func normalizeUnits(metrics []Metric) {
+	for i := range metrics {
+		m := &metrics[i]
+		name, conversion, ok := normalizedName(m.name)
+		if !ok {
+			continue
+		}
+		m.name = name
+		switch v := m.value.(type) {
+		case float64:
+			m.value = v * conversion.factor
+		case *dto.Summary:
+			m.value = scaleSummary(v, conversion.factor)
+		case *dto.Histogram:
+			m.value = scaleHistogram(v, conversion.factor)
+		}
+		if _, ok := m.attributes[unitAttribute]; ok {
+			m.attributes[unitAttribute] = conversion.base
+		}
+	}
+}
+
+// normalizedName returns the name of the metric in the base unit, and the
+// conversion of its values. It returns false if its unit isn't converted.
+func normalizedName(name string) (string, unitConversion, bool) {
+	suffix := ""
+	for _, s := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if strings.HasSuffix(name, s) {
+			suffix = s
+			name = strings.TrimSuffix(name, s)
+			break
+		}
+	}
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return "", unitConversion{}, false
+	}
+	conversion, ok := unitConversions[name[i+1:]]
+	if !ok {
+		return "", unitConversion{}, false
+	}
+	return name[:i+1] + conversion.base + suffix, conversion, true
+}
+
+func scaleSummary(s *dto.Summary, factor float64) *dto.Summary {
+	scaled := &dto.Summary{SampleCount: s.SampleCount}
+	sum := s.GetSampleSum() * factor
+	scaled.SampleSum = &sum
+	for _, q := range s.GetQuantile() {
+		value := q.GetValue() * factor
+		scaled.Quantile = append(scaled.Quantile, &dto.Quantile{Quantile: q.Quantile, Value: &value})
+	}
+	return scaled
+}
+
+func scaleHistogram(h *dto.Histogram, factor float64) *dto.Histogram {
+	scaled := &dto.Histogram{SampleCount: h.SampleCount}
+	sum := h.GetSampleSum() * factor
+	scaled.SampleSum = &sum
+	for _, b := range h.GetBucket() {
+		upperBound := b.GetUpperBound() * factor
+		scaled.Bucket = append(scaled.Bucket, &dto.Bucket{CumulativeCount: b.CumulativeCount, UpperBound: &upperBound})
+	}
+	return scaled
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func TestNormalizedName(t *testing.T) {
+	name, conversion, ok := normalizedName("request_duration_milliseconds_total")
+	require.True(t, ok)
+	assert.Equal(t, "request_duration_seconds_total", name)
+	assert.Equal(t, 1e-3, conversion.factor)
+
+	name, _, ok = normalizedName("cache_size_mebibytes")
+	require.True(t, ok)
+	assert.Equal(t, "cache_size_bytes", name)
+
+	for _, name := range []string{"up", "process_cpu_seconds_total", "requests_total"} {
+		_, _, ok := normalizedName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestNormalizeUnits(t *testing.T) {
+	mfs, err := prometheus.Decode(strings.NewReader(`# TYPE gc_pause_milliseconds_total counter
+gc_pause_milliseconds_total 1500
+# TYPE latency_milliseconds histogram
+latency_milliseconds_bucket{le="100"} 3
+latency_milliseconds_bucket{le="+Inf"} 4
+latency_milliseconds_sum 250
+latency_milliseconds_count 4
+# TYPE heap_kilobytes gauge
+heap_kilobytes 2
+up 1
+`))
+	require.NoError(t, err)
+	metrics := convertPromMetrics(nil, "target", mfs)
+	addMetadata(metrics, mfs)
+	normalizeUnits(metrics)
+
+	byName := map[string]Metric{}
+	for _, m := range metrics {
+		byName[m.name] = m
+	}
+	require.Len(t, byName, 4)
+	assert.Equal(t, 1.5, byName["gc_pause_seconds_total"].value)
+	assert.Equal(t, "seconds", byName["gc_pause_seconds_total"].attributes[unitAttribute])
+	assert.Equal(t, 2000.0, byName["heap_bytes"].value)
+	assert.Equal(t, 1.0, byName["up"].value)
+
+	h := byName["latency_seconds"].value.(*dto.Histogram)
+	assert.Equal(t, 0.25, h.GetSampleSum())
+	assert.Equal(t, uint64(4), h.GetSampleCount())
+	assert.Equal(t, 0.1, h.GetBucket()[0].GetUpperBound())
+	assert.True(t, math.IsInf(h.GetBucket()[1].GetUpperBound(), 1))
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+const (
+	defaultUpdateCheckURL      = "https://api.github.com/repos/newrelic/nri-prometheus/releases/latest"
+	defaultUpdateCheckInterval = 24 * time.Hour
+	updateCheckTimeout         = 10 * time.Second
+	updateAvailableMetricName  = "nri_prometheus.update_available"
+)
+
+// UpdateCheckConfig configures the periodic check of the latest released
+// version of the integration.
+type UpdateCheckConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// URL of the release manifest, a JSON document with the latest version
+	// in its tag_name or version field. Defaults to the latest GitHub release.
+	URL string `mapstructure:"url"`
+	// Interval between checks. Defaults to 24h.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *UpdateCheckConfig) Validate() error {
+	if c.URL == "" {
+		c.URL = defaultUpdateCheckURL
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultUpdateCheckInterval
+	}
+	if c.Interval < 0 {
+		return errors.New("interval can't be negative")
+	}
+	return nil
+}
+
+// releaseManifest is the part of the release manifest with the version.
+type releaseManifest struct {
+	TagName string `json:"tag_name"`
+	Version string `json:"version"`
+}
+
+// RunUpdateCheck checks the latest version right away and then every
+// interval until the context is done. After each check the
+// nri_prometheus.update_available gauge is emitted, being 1 if there is a
+// newer version.
+func RunUpdateCheck(ctx context.Context, cfg UpdateCheckConfig, emitters []Emitter) {
+	client := &http.Client{Timeout: updateCheckTimeout}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		latest, err := latestVersion(ctx, client, cfg.URL)
+		if err != nil {
+			ilog.WithError(err).Debug("while checking the latest version of the integration")
+		} else if m, ok := updateAvailableMetric(Version, latest); ok {
+			if m.value == 1.0 {
+				ilog.Infof("a newer version of the integration is available: %s, running %s", latest, Version)
+			}
+			for _, e := range emitters {
+				if err := e.Emit([]Metric{m}); err != nil {
+					ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting update_available")
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// latestVersion fetches the release manifest and returns its version.
+func latestVersion(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", fmt.Sprintf("%s/%s", Name, Version))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: status %d", url, resp.StatusCode)
+	}
+	var manifest releaseManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", url, err)
+	}
+	if manifest.Version != "" {
+		return manifest.Version, nil
+	}
+	if manifest.TagName != "" {
+		return manifest.TagName, nil
+	}
+	return "", fmt.Errorf("no version in %s", url)
+}
+
+// updateAvailableMetric returns the gauge comparing the current and latest
+// versions. It returns false if any of them is not a release version.
+func updateAvailableMetric(current, latest string) (Metric, bool) {
+	newer, ok := newerVersion(current, latest)
+	if !ok {
+		return Metric{}, false
+	}
+	value := 0.0
+	if newer {
+		value = 1.0
+	}
+	return Metric{
+		name:       updateAvailableMetricName,
+		value:      value,
+		metricType: metricType_GAUGE,
+		attributes: labels.Set{
+			"integrationName":    Name,
+			"integrationVersion": current,
+			"latestVersion":      strings.TrimPrefix(latest, "v"),
+		},
+	}, true
+}
+
+// newerVersion returns true if latest is a newer version than current. It
+// returns false as second value if any of them is not a release version,
+// e.g. dev.
+func newerVersion(current, latest string) (bool, bool) {
+	c, ok := parseVersion(current)
+	if !ok {
+		return false, false
+	}
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false, false
+	}
+	for i := range c {
+		if l[i] != c[i] {
+			return l[i] > c[i], true
+		}
+	}
+	return false, true
+}
+
+// parseVersion returns the major, minor and patch numbers of versions like
+// v2.7.0, ignoring any pre-release or build suffix.
+func parseVersion(version string) ([3]int, bool) {
+	var parsed [3]int
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
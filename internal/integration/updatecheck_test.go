@@ -0,0 +1,60 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewerVersion(t *testing.T) {
+	for _, tc := range []struct {
+		current, latest string
+		newer, ok       bool
+	}{
+		{"2.7.0", "v2.8.0", true, true},
+		{"2.7.0", "v2.7.0", false, true},
+		{"2.10.0", "v2.9.3", false, true},
+		{"2.7.0-rc1", "2.7.1", true, true},
+		{"dev", "v2.8.0", false, false},
+		{"2.7.0", "latest", false, false},
+	} {
+		newer, ok := newerVersion(tc.current, tc.latest)
+		assert.Equal(t, tc.newer, newer, "%s -> %s", tc.current, tc.latest)
+		assert.Equal(t, tc.ok, ok, "%s -> %s", tc.current, tc.latest)
+	}
+}
+
+func TestRunUpdateCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"tag_name": "v99.0.0"}`))
+	}))
+	defer srv.Close()
+
+	defer func(v string) { Version = v }(Version)
+	Version = "2.7.0"
+
+	cfg := UpdateCheckConfig{URL: srv.URL}
+	require.NoError(t, cfg.Validate())
+	emitter := &recordingEmitter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunUpdateCheck(ctx, cfg, []Emitter{emitter})
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return len(emitter.emitted()) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	m := emitter.emitted()[0]
+	assert.Equal(t, "nri_prometheus.update_available", m.name)
+	assert.Equal(t, 1.0, m.value)
+	assert.Equal(t, "99.0.0", m.attributes["latestVersion"])
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValueMappingRule replaces the values of an attribute of the metrics whose
+// name matches MetricPrefix with canonical ones, e.g. prd and prod with
+// production.
+type ValueMappingRule struct {
+	// MetricPrefix selects the metrics the rule applies to. Empty selects
+	// all of them.
+	MetricPrefix string `mapstructure:"metric_prefix"`
+	// Attribute is the name of the attribute whose values are mapped.
+	Attribute string `mapstructure:"attribute"`
+	// Values maps the values to the canonical ones.
+	Values map[string]string `mapstructure:"values"`
+	// Default replaces the values not in Values. When empty, they are kept.
+	Default string `mapstructure:"default"`
+
+	RuleMetadata `mapstructure:",squash"`
+}
+
+// Validate returns an error if the rule is not valid.
+func (r ValueMappingRule) Validate() error {
+	if r.Attribute == "" {
+		return fmt.Errorf("attribute is required")
+	}
+	if len(r.Values) == 0 && r.Default == "" {
+		return fmt.Errorf("values or default is required")
+	}
+	return nil
+}
+
+// MapAttributeValues applies the rules to the metrics of the target. For each
+// attribute, the first rule matching a metric is applied. The metrics without
+// the attribute are not modified.
+func MapAttributeValues(targetMetrics *TargetMetrics, rules []ValueMappingRule) {
+	if len(rules) == 0 {
+		return
+	}
+	for _, m := range targetMetrics.Metrics {
+		for i, rule := range rules {
+			if !strings.HasPrefix(m.name, rule.MetricPrefix) || mappedBefore(m.name, rule.Attribute, rules[:i]) {
+				continue
+			}
+			value, ok := m.attributes[rule.Attribute]
+			if !ok {
+				continue
+			}
+			if mapped, ok := rule.Values[fmt.Sprint(value)]; ok {
+				m.attributes[rule.Attribute] = mapped
+			} else if rule.Default != "" {
+				m.attributes[rule.Attribute] = rule.Default
+			}
+		}
+	}
+}
+
+// mappedBefore returns true if any of the rules maps the attribute of the
+// metric.
+func mappedBefore(name, attribute string, rules []ValueMappingRule) bool {
+	for _, rule := range rules {
+		if rule.Attribute == attribute && strings.HasPrefix(name, rule.MetricPrefix) {
+			return true
+		}
+	}
+	return false
+}
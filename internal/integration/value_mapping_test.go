@@ -0,0 +1,43 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestValueMappingRuleValidate(t *testing.T) {
+	assert.NoError(t, ValueMappingRule{Attribute: "env", Values: map[string]string{"prd": "production"}}.Validate())
+	assert.NoError(t, ValueMappingRule{Attribute: "env", Default: "other"}.Validate())
+	assert.Error(t, ValueMappingRule{Values: map[string]string{"prd": "production"}}.Validate())
+	assert.Error(t, ValueMappingRule{Attribute: "env"}.Validate())
+}
+
+func TestMapAttributeValues(t *testing.T) {
+	rules := []ValueMappingRule{
+		{MetricPrefix: "redis_", Attribute: "env", Values: map[string]string{"prod": "production"}},
+		{Attribute: "env", Values: map[string]string{"prd": "production", "stg": "staging"}, Default: "other"},
+		{Attribute: "region", Values: map[string]string{"use1": "us-east-1"}},
+	}
+	pair := TargetMetrics{Metrics: []Metric{
+		{name: "http_requests_total", attributes: labels.Set{"env": "prd", "region": "use1"}},
+		{name: "http_requests_total", attributes: labels.Set{"env": "stg", "region": "euw1"}},
+		{name: "http_requests_total", attributes: labels.Set{"env": "dev"}},
+		{name: "redis_commands_total", attributes: labels.Set{"env": "prod"}},
+		{name: "redis_commands_total", attributes: labels.Set{"env": "prd"}},
+		{name: "up", attributes: labels.Set{}},
+	}}
+
+	MapAttributeValues(&pair, rules)
+
+	assert.Equal(t, labels.Set{"env": "production", "region": "us-east-1"}, pair.Metrics[0].attributes)
+	assert.Equal(t, labels.Set{"env": "staging", "region": "euw1"}, pair.Metrics[1].attributes)
+	assert.Equal(t, labels.Set{"env": "other"}, pair.Metrics[2].attributes)
+	assert.Equal(t, labels.Set{"env": "production"}, pair.Metrics[3].attributes)
+	assert.Equal(t, labels.Set{"env": "prd"}, pair.Metrics[4].attributes, "only the first matching rule is applied")
+	assert.Equal(t, labels.Set{}, pair.Metrics[5].attributes)
+}
@@ -0,0 +1,249 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+	"github.com/newrelic/nri-prometheus/internal/pkg/wal"
+)
+
+// DefaultWALPollInterval is how often the write-ahead log is read when it
+// isn't configured.
+const DefaultWALPollInterval = 5 * time.Second
+
+// walSeriesTTL is how long the series without samples are kept, since
+// Prometheus doesn't log their removal.
+const walSeriesTTL = time.Hour
+
+// WALConfig configures the ingestion of the samples of the write-ahead log of
+// Prometheus, running in agent mode, instead of scraping the targets.
+type WALConfig struct {
+	// Dir is the data directory of Prometheus, or its wal directory.
+	Dir string `mapstructure:"dir"`
+	// PollInterval is how often the new samples are read and emitted.
+	// Defaults to 5s.
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// Enabled returns true if the write-ahead log is read.
+func (c WALConfig) Enabled() bool {
+	return c.Dir != ""
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *WALConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("poll_interval can't be negative")
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = DefaultWALPollInterval
+	}
+	return nil
+}
+
+// walSeries is a series of the log.
+type walSeries struct {
+	name       string
+	target     string
+	metricType metricType
+	attributes labels.Set
+	lastSeen   time.Time
+}
+
+// WALTailer reads the samples of the write-ahead log and converts them into
+// metrics of a target per instance label.
+type WALTailer struct {
+	reader *wal.Reader
+	log    *logrus.Entry
+	now    func() time.Time
+	series map[uint64]*walSeries
+	// skipSamples discards the samples read, while catching up with the
+	// log at startup.
+	skipSamples bool
+	pairs       map[string]*TargetMetrics
+}
+
+// NewWALTailer returns a WALTailer of the log in the directory.
+func NewWALTailer(dir string) *WALTailer {
+	return &WALTailer{
+		reader: wal.NewReader(dir),
+		log:    logrus.WithField("component", "WALTailer"),
+		now:    time.Now,
+		series: map[uint64]*walSeries{},
+	}
+}
+
+// Start reads the series of the log written until now. Its samples are
+// discarded, so only the ones written after the integration starts are
+// emitted.
+func (w *WALTailer) Start() error {
+	w.skipSamples = true
+	defer func() { w.skipSamples = false }()
+	if err := w.reader.Start(w); err != nil {
+		return err
+	}
+	return w.reader.Read(w)
+}
+
+// Next returns the metrics of the samples written since the previous call,
+// by target.
+func (w *WALTailer) Next() ([]TargetMetrics, error) {
+	w.pairs = map[string]*TargetMetrics{}
+	err := w.reader.Read(w)
+	names := make([]string, 0, len(w.pairs))
+	for name := range w.pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]TargetMetrics, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, *w.pairs[name])
+	}
+	w.pairs = nil
+	w.expire()
+	return pairs, err
+}
+
+// Series implements wal.Handler.
+func (w *WALTailer) Series(series []wal.Series) {
+	now := w.now()
+	for _, s := range series {
+		ws := &walSeries{attributes: labels.Set{}, lastSeen: now}
+		for _, l := range s.Labels {
+			if l.Name == "__name__" {
+				ws.name = l.Value
+				continue
+			}
+			ws.attributes[l.Name] = l.Value
+		}
+		ws.target, _ = ws.attributes["instance"].(string)
+		if ws.target == "" {
+			ws.target, _ = ws.attributes["job"].(string)
+		}
+		if ws.target == "" {
+			ws.target = "wal"
+		}
+		ws.metricType = walMetricType(ws.name)
+		w.series[s.Ref] = ws
+	}
+}
+
+// Samples implements wal.Handler.
+func (w *WALTailer) Samples(samples []wal.Sample) {
+	if w.skipSamples {
+		return
+	}
+	now := w.now()
+	for _, s := range samples {
+		ws, ok := w.series[s.Ref]
+		if !ok {
+			walUnknownSeriesSamplesMetric.Inc()
+			continue
+		}
+		ws.lastSeen = now
+		if math.Float64bits(s.V) == wal.StaleNaN {
+			continue
+		}
+		pair, ok := w.pairs[ws.target]
+		if !ok {
+			pair = &TargetMetrics{Target: w.target(ws.target)}
+			w.pairs[ws.target] = pair
+		}
+		attributes := make(labels.Set, len(ws.attributes)+3)
+		labels.Accumulate(attributes, ws.attributes)
+		attributes["targetName"] = ws.target
+		attributes["nrMetricType"] = string(ws.metricType)
+		attributes["promMetricType"] = "untyped"
+		if ws.metricType == metricType_COUNTER {
+			attributes["promMetricType"] = "counter"
+		}
+		pair.Metrics = append(pair.Metrics, Metric{
+			name:       ws.name,
+			value:      s.V,
+			metricType: ws.metricType,
+			attributes: attributes,
+			timestamp:  time.Unix(0, s.T*int64(time.Millisecond)),
+		})
+	}
+}
+
+// target returns the target of the series with the given instance.
+func (w *WALTailer) target(name string) endpoints.Target {
+	return endpoints.New(name, url.URL{Scheme: "file", Path: w.reader.Dir()}, endpoints.Object{Name: name, Kind: "wal"})
+}
+
+// expire removes the series without samples for walSeriesTTL.
+func (w *WALTailer) expire() {
+	oldest := w.now().Add(-walSeriesTTL)
+	for ref, s := range w.series {
+		if s.lastSeen.Before(oldest) {
+			delete(w.series, ref)
+		}
+	}
+}
+
+// walMetricType returns the type of the series with the given name. The log
+// doesn't have the types of the metrics, so the cumulative ones are told by
+// the suffixes of their names.
+func walMetricType(name string) metricType {
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if strings.HasSuffix(name, suffix) {
+			return metricType_COUNTER
+		}
+	}
+	return metricType_GAUGE
+}
+
+// TailWAL emits the metrics of the samples written in the log every poll
+// interval, after processing them, until the context is done.
+func TailWAL(ctx context.Context, cfg WALConfig, processor Processor, emitters []Emitter) error {
+	tailer := NewWALTailer(cfg.Dir)
+	if err := tailer.Start(); err != nil {
+		return fmt.Errorf("reading the write-ahead log: %w", err)
+	}
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		pairs, err := tailer.Next()
+		if err != nil {
+			tailer.log.WithError(err).Warn("reading the write-ahead log")
+		}
+		emitWALPairs(pairs, processor, emitters)
+	}
+}
+
+// emitWALPairs processes the metrics and emits them.
+func emitWALPairs(pairs []TargetMetrics, processor Processor, emitters []Emitter) {
+	in := make(chan TargetMetrics, len(pairs))
+	for _, pair := range pairs {
+		in <- pair
+	}
+	close(in)
+	for pair := range processor(in) {
+		for _, e := range emitters {
+			if err := e.Emit(pair.Metrics); err != nil {
+				ilog.WithField("emitter", e.Name()).WithError(err).Warn("error emitting metrics")
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package integration
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+	"github.com/newrelic/nri-prometheus/internal/pkg/wal"
+)
+
+func TestWALTailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1600000000, 0)
+	tailer := NewWALTailer(dir)
+	tailer.now = func() time.Time { return now }
+	require.NoError(t, tailer.Start())
+
+	tailer.Series([]wal.Series{
+		{Ref: 1, Labels: []wal.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "instance", Value: "web:8080"}, {Name: "code", Value: "200"}}},
+		{Ref: 2, Labels: []wal.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "db:9187"}}},
+		{Ref: 3, Labels: []wal.Label{{Name: "__name__", Value: "go_goroutines"}, {Name: "instance", Value: "web:8080"}}},
+	})
+	tailer.pairs = map[string]*TargetMetrics{}
+	tailer.Samples([]wal.Sample{
+		{Ref: 1, T: 1600000000000, V: 10},
+		{Ref: 2, T: 1600000000000, V: 1},
+		{Ref: 3, T: 1600000000000, V: math.Float64frombits(wal.StaleNaN)},
+		{Ref: 4, T: 1600000000000, V: 1},
+	})
+
+	web := tailer.pairs["web:8080"]
+	require.NotNil(t, web)
+	assert.Equal(t, "web:8080", web.Target.Name)
+	require.Len(t, web.Metrics, 1, "the stale markers are discarded")
+	m := web.Metrics[0]
+	assert.Equal(t, "http_requests_total", m.name)
+	assert.Equal(t, metricType_COUNTER, m.metricType)
+	assert.Equal(t, 10.0, m.value)
+	assert.Equal(t, now, m.timestamp)
+	assert.Equal(t, labels.Set{
+		"code":           "200",
+		"instance":       "web:8080",
+		"targetName":     "web:8080",
+		"nrMetricType":   "count",
+		"promMetricType": "counter",
+	}, m.attributes)
+
+	db := tailer.pairs["db:9187"]
+	require.NotNil(t, db)
+	require.Len(t, db.Metrics, 1)
+	assert.Equal(t, metricType_GAUGE, db.Metrics[0].metricType)
+
+	// The series without samples expire.
+	tailer.now = func() time.Time { return now.Add(walSeriesTTL + time.Minute) }
+	pairs, err := tailer.Next()
+	require.NoError(t, err)
+	assert.Empty(t, pairs)
+	assert.Empty(t, tailer.series)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// APIServerProxyConfig configures the scrape of the pods and services through
+// the proxy of the Kubernetes API server, for the clusters whose pod IPs
+// aren't routable from the integration.
+type APIServerProxyConfig struct {
+	// Enabled scrapes the targets through the proxy.
+	Enabled bool `mapstructure:"enabled"`
+	// Kinds are the kinds of the objects scraped through the proxy: pod and
+	// service. Defaults to both.
+	Kinds []string `mapstructure:"kinds"`
+}
+
+// Validate returns an error if any of the kinds is unknown, and sets the
+// default kinds.
+func (c *APIServerProxyConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Kinds) == 0 {
+		c.Kinds = []string{"pod", "service"}
+	}
+	for _, kind := range c.Kinds {
+		if kind != "pod" && kind != "service" {
+			return fmt.Errorf("unsupported kind %q, must be pod or service", kind)
+		}
+	}
+	return nil
+}
+
+// WithAPIServerProxy configures the KubernetesTargetRetriever to return the
+// targets of the pods and services as URLs of the API server proxy, scraped
+// with the credentials of its Kubernetes configuration.
+func WithAPIServerProxy(cfg APIServerProxyConfig) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		ktr.apiServerProxyConfig = cfg
+		return nil
+	}
+}
+
+// apiServerProxy rewrites the URLs of the targets to scrape them through the
+// API server.
+type apiServerProxy struct {
+	host  url.URL
+	tls   TLSConfig
+	auth  TargetAuthConfig
+	kinds map[string]bool
+}
+
+// newAPIServerProxy returns the proxy of the API server of the Kubernetes
+// configuration. The credentials must be files, so they are reloaded when
+// rotated.
+func newAPIServerProxy(cfg APIServerProxyConfig, config *rest.Config) (*apiServerProxy, error) {
+	if config == nil {
+		return nil, fmt.Errorf("the api server proxy requires the kubernetes configuration")
+	}
+	if len(config.CAData) > 0 || len(config.CertData) > 0 || len(config.KeyData) > 0 ||
+		(config.BearerToken != "" && config.BearerTokenFile == "") || config.Password != "" ||
+		config.ExecProvider != nil || config.AuthProvider != nil {
+		return nil, fmt.Errorf("the api server proxy requires credentials in files: certificate-authority, " +
+			"client-certificate, client-key or tokenFile")
+	}
+	host := config.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api server host %q: %w", config.Host, err)
+	}
+	kinds := make(map[string]bool, len(cfg.Kinds))
+	for _, kind := range cfg.Kinds {
+		kinds[kind] = true
+	}
+	return &apiServerProxy{
+		host: url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path},
+		tls: TLSConfig{
+			CaFilePath:         config.CAFile,
+			CertFilePath:       config.CertFile,
+			KeyFilePath:        config.KeyFile,
+			InsecureSkipVerify: config.Insecure,
+			ServerName:         config.ServerName,
+		},
+		auth:  TargetAuthConfig{BearerTokenFile: config.BearerTokenFile},
+		kinds: kinds,
+	}, nil
+}
+
+// rewrite returns the targets of the object with the URLs of the proxy, if
+// its kind is scraped through it.
+func (p *apiServerProxy) rewrite(targets []Target, kind, namespace, name string) []Target {
+	if p == nil || !p.kinds[kind] {
+		return targets
+	}
+	for i := range targets {
+		// The scheme of the target is prefixed to the name, as the proxy
+		// connects over HTTP otherwise.
+		resource := name + ":" + targets[i].URL.Port()
+		if targets[i].URL.Scheme == "https" {
+			resource = "https:" + resource
+		}
+		u := p.host
+		u.Path = path.Join(p.host.Path, "/api/v1/namespaces", namespace, kind+"s", resource, "proxy") + targets[i].URL.Path
+		u.RawQuery = targets[i].URL.RawQuery
+		targets[i].URL = u
+		targets[i].TLSConfig = p.tls
+		targets[i].Auth = p.auth
+		targets[i].metadata = nil
+		targets[i].apiServerProxied = true
+	}
+	return targets
+}
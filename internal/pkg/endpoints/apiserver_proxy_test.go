@@ -0,0 +1,95 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func inClusterConfig() *rest.Config {
+	return &rest.Config{
+		Host:            "https://10.0.0.1:443",
+		BearerToken:     "token",
+		BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		TLSClientConfig: rest.TLSClientConfig{CAFile: "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"},
+	}
+}
+
+func TestAPIServerProxyConfigValidate(t *testing.T) {
+	cfg := APIServerProxyConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, []string{"pod", "service"}, cfg.Kinds)
+
+	cfg = APIServerProxyConfig{Enabled: true, Kinds: []string{"node"}}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestNewAPIServerProxy_RequiresCredentialFiles(t *testing.T) {
+	_, err := newAPIServerProxy(APIServerProxyConfig{Enabled: true}, nil)
+	assert.Error(t, err)
+
+	config := inClusterConfig()
+	config.BearerTokenFile = ""
+	_, err = newAPIServerProxy(APIServerProxyConfig{Enabled: true}, config)
+	assert.Error(t, err)
+
+	config = inClusterConfig()
+	config.CAData = []byte("ca")
+	_, err = newAPIServerProxy(APIServerProxyConfig{Enabled: true}, config)
+	assert.Error(t, err)
+}
+
+func TestAPIServerProxy_Rewrite(t *testing.T) {
+	cfg := APIServerProxyConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	retriever.apiServerProxy, _ = newAPIServerProxy(cfg, inClusterConfig())
+	require.NotNil(t, retriever.apiServerProxy)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-1",
+			Namespace: "prod",
+			Annotations: map[string]string{
+				"prometheus.io/scrape": "true",
+				"prometheus.io/port":   "8443",
+				"prometheus.io/path":   "/metrics?format=prometheus",
+				"prometheus.io/scheme": "https",
+			},
+		},
+		Status: v1.PodStatus{PodIP: "10.1.2.3"},
+	}
+	targets := retriever.podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "app-1", targets[0].Name)
+	assert.Equal(t, "https://10.0.0.1:443/api/v1/namespaces/prod/pods/https:app-1:8443/proxy/metrics?format=prometheus", targets[0].URL.String())
+	assert.Equal(t, TLSConfig{CaFilePath: "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"}, targets[0].TLSConfig)
+	assert.Equal(t, TargetAuthConfig{BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token"}, targets[0].Auth)
+	assert.Equal(t, targets[0].URL.String(), targets[0].Metadata()["scrapedTargetURL"])
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "api",
+			Namespace:   "prod",
+			Annotations: map[string]string{"prometheus.io/scrape": "true"},
+		},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 9090}}},
+	}
+	targets = retriever.serviceTargets(service)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "https://10.0.0.1:443/api/v1/namespaces/prod/services/api:9090/proxy/metrics", targets[0].URL.String())
+
+	// Only the configured kinds are scraped through the proxy.
+	cfg = APIServerProxyConfig{Enabled: true, Kinds: []string{"service"}}
+	retriever.apiServerProxy, _ = newAPIServerProxy(cfg, inClusterConfig())
+	targets = retriever.podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "https://10.1.2.3:8443/metrics?format=prometheus", targets[0].URL.String())
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// compositeRetriever merges the targets of several TargetRetrievers,
+// scraping only once the targets with the same URL.
+type compositeRetriever struct {
+	retrievers []TargetRetriever
+}
+
+// NewCompositeRetriever returns a TargetRetriever with the targets of the
+// given retrievers, deduplicated by URL. When a URL is discovered by several
+// retrievers, the target of the first one in precedence is kept, and the
+// labels of the others are added to it without overriding its own. The
+// retrievers not in precedence go after those in it, in the given order.
+func NewCompositeRetriever(precedence []string, retrievers ...TargetRetriever) TargetRetriever {
+	rank := make(map[string]int, len(precedence))
+	for i, name := range precedence {
+		rank[name] = i
+	}
+	sorted := append([]TargetRetriever(nil), retrievers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, iok := rank[sorted[i].Name()]
+		rj, jok := rank[sorted[j].Name()]
+		if iok && jok {
+			return ri < rj
+		}
+		return iok && !jok
+	})
+	return &compositeRetriever{retrievers: sorted}
+}
+
+// GetTargets returns the deduplicated targets, in the order they are first
+// discovered by the retrievers in precedence. Each target keeps the name of
+// the retriever that discovered it in its Retriever field.
+func (c *compositeRetriever) GetTargets() ([]Target, error) {
+	var targets []Target
+	byURL := make(map[string]int)
+	for _, retriever := range c.retrievers {
+		t, err := retriever.GetTargets()
+		if err != nil {
+			return nil, fmt.Errorf("getting the targets of %s: %w", retriever.Name(), err)
+		}
+		duplicated := 0
+		for _, target := range t {
+			target.Retriever = retriever.Name()
+			key := target.URL.String()
+			i, ok := byURL[key]
+			if !ok {
+				byURL[key] = len(targets)
+				targets = append(targets, target)
+				continue
+			}
+			duplicated++
+			kept := &targets[i]
+			merged := labels.Set{}
+			labels.Accumulate(merged, kept.Object.Labels)
+			labels.Accumulate(merged, target.Object.Labels)
+			kept.Object.Labels = merged
+			// The metadata must be built again with the merged labels.
+			kept.metadata = nil
+		}
+		duplicatedTargetsMetric.WithLabelValues(retriever.Name()).Set(float64(duplicated))
+	}
+	return targets, nil
+}
+
+// Watch starts watching all the retrievers, returning their errors.
+func (c *compositeRetriever) Watch() error {
+	var errs []string
+	for _, retriever := range c.retrievers {
+		if err := retriever.Watch(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", retriever.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("while watching the targets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Stop stops the retrievers that discover targets in background.
+func (c *compositeRetriever) Stop() {
+	for _, retriever := range c.retrievers {
+		if s, ok := retriever.(Stopper); ok {
+			s.Stop()
+		}
+	}
+}
+
+func (c *compositeRetriever) Name() string {
+	return "composite"
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+type staticRetriever struct {
+	name    string
+	targets []Target
+}
+
+func (s staticRetriever) GetTargets() ([]Target, error) { return s.targets, nil }
+func (s staticRetriever) Watch() error                  { return nil }
+func (s staticRetriever) Name() string                  { return s.name }
+
+func TestCompositeRetrieverDeduplicates(t *testing.T) {
+	target := func(rawURL string, lbls labels.Set) Target {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return New(rawURL, *u, Object{Name: "obj", Kind: "pod", Labels: lbls})
+	}
+	kubernetes := staticRetriever{name: "kubernetes", targets: []Target{
+		target("http://10.0.0.1:8080/metrics", labels.Set{"namespaceName": "default", "team": "kube"}),
+		target("http://10.0.0.2:8080/metrics", nil),
+	}}
+	fixed := staticRetriever{name: "fixed", targets: []Target{
+		target("http://10.0.0.1:8080/metrics", labels.Set{"team": "static"}),
+		target("http://10.0.0.3:8080/metrics", nil),
+	}}
+
+	composite := NewCompositeRetriever([]string{"fixed"}, kubernetes, fixed)
+	targets, err := composite.GetTargets()
+	require.NoError(t, err)
+
+	require.Len(t, targets, 3)
+	assert.Equal(t, "http://10.0.0.1:8080/metrics", targets[0].URL.String())
+	assert.Equal(t, "fixed", targets[0].Retriever)
+	assert.Equal(t, "static", targets[0].Metadata()["team"])
+	assert.Equal(t, "default", targets[0].Metadata()["namespaceName"])
+	assert.Equal(t, "http://10.0.0.3:8080/metrics", targets[1].URL.String())
+	assert.Equal(t, "http://10.0.0.2:8080/metrics", targets[2].URL.String())
+	assert.Equal(t, "kubernetes", targets[2].Retriever)
+
+	// The targets of the retrievers are not modified.
+	assert.Equal(t, labels.Set{"team": "static"}, fixed.targets[0].Object.Labels)
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Backoff of the watches of the Kubernetes resources that can't be listed or
+// watched, e.g. while the API server is unavailable.
+var (
+	watchInitialBackoff = time.Second
+	watchMaxBackoff     = 5 * time.Minute
+	// watchHealthyDuration is how long a watch must last for its
+	// disconnection not to be considered a failure.
+	watchHealthyDuration = time.Minute
+)
+
+// errWatchClosed is the failure of a watch closed by the API server right
+// after it was subscribed.
+var errWatchClosed = errors.New("the watch was closed right after subscribing")
+
+// DegradationReporter is implemented by the TargetRetrievers that keep
+// returning the last known targets while they can't discover them.
+type DegradationReporter interface {
+	// Degraded returns the resources whose targets can't be discovered, in
+	// the form retriever/kind.
+	Degraded() []string
+}
+
+// nextBackoff returns the backoff after the given one failed.
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > watchMaxBackoff {
+		return watchMaxBackoff
+	}
+	return backoff
+}
+
+// setDegraded records whether the targets of the resource kind can be
+// discovered. Only the first failure is logged as a warning, and the
+// recovery as info, so an unavailable API server doesn't flood the logs.
+func (k *KubernetesTargetRetriever) setDegraded(kind string, err error) {
+	log := klog.WithField("kind", kind)
+	if err == nil {
+		retrieverDegradedMetric.WithLabelValues(k.Name(), kind).Set(0)
+		if _, ok := k.degraded.Load(kind); ok {
+			k.degraded.Delete(kind)
+			log.Info("watching the resources again")
+		}
+		return
+	}
+	retrieverDegradedMetric.WithLabelValues(k.Name(), kind).Set(1)
+	if _, ok := k.degraded.LoadOrStore(kind, true); ok {
+		log.WithError(err).Debug("the resources still can't be watched")
+		return
+	}
+	log.WithError(err).Warn("the resources can't be watched, retrying with backoff and keeping the last known targets")
+}
+
+// Degraded returns the resources whose targets can't be discovered.
+func (k *KubernetesTargetRetriever) Degraded() []string {
+	var degraded []string
+	k.degraded.Range(func(kind, _ interface{}) bool {
+		degraded = append(degraded, k.Name()+"/"+kind.(string))
+		return true
+	})
+	sort.Strings(degraded)
+	return degraded
+}
+
+// Degraded returns the resources of the retriever whose targets can't be
+// discovered.
+func (r *refreshingRetriever) Degraded() []string {
+	if d, ok := r.TargetRetriever.(DegradationReporter); ok {
+		return d.Degraded()
+	}
+	return nil
+}
+
+// Degraded returns the resources of the retriever whose targets can't be
+// discovered.
+func (r *networkPolicyRetriever) Degraded() []string {
+	if d, ok := r.TargetRetriever.(DegradationReporter); ok {
+		return d.Degraded()
+	}
+	return nil
+}
+
+// Degraded returns the resources of the retrievers whose targets can't be
+// discovered.
+func (c *compositeRetriever) Degraded() []string {
+	var degraded []string
+	for _, retriever := range c.retrievers {
+		if d, ok := retriever.(DegradationReporter); ok {
+			degraded = append(degraded, d.Degraded()...)
+		}
+	}
+	return degraded
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(time.Second))
+	assert.Equal(t, watchMaxBackoff, nextBackoff(watchMaxBackoff/2+time.Second))
+	assert.Equal(t, watchMaxBackoff, nextBackoff(watchMaxBackoff))
+}
+
+func TestWatch_DegradedWhileTheWatchFails(t *testing.T) {
+	initial, max := watchInitialBackoff, watchMaxBackoff
+	watchInitialBackoff, watchMaxBackoff = 10*time.Millisecond, 40*time.Millisecond
+	defer func() { watchInitialBackoff, watchMaxBackoff = initial, max }()
+
+	client := fake.NewSimpleClientset()
+	require.NoError(t, populateFakeServiceData(client))
+	var failing, attempts int32 = 1, 0
+	client.PrependWatchReactor("services", func(k8stesting.Action) (bool, watch.Interface, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			atomic.AddInt32(&attempts, 1)
+			return true, nil, errors.New("the server is currently unable to handle the request")
+		}
+		return false, nil, nil
+	})
+	retriever := newFakeKubernetesTargetRetriever(client)
+	require.NoError(t, retriever.Watch())
+	defer retriever.Stop()
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"kubernetes/service"}, retriever.Degraded())
+	}, time.Second, 10*time.Millisecond)
+
+	// The last known targets are kept.
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "my-service", targets[0].Name)
+
+	// The watch is retried with backoff instead of right away.
+	time.Sleep(200 * time.Millisecond)
+	assert.LessOrEqual(t, atomic.LoadInt32(&attempts), int32(20))
+
+	atomic.StoreInt32(&failing, 0)
+	assert.Eventually(t, func() bool {
+		return len(retriever.Degraded()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCompositeRetriever_Degraded(t *testing.T) {
+	degraded := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	degraded.setDegraded("pod", errors.New("unavailable"))
+	degraded.setDegraded("node", errors.New("unavailable"))
+	healthy := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	healthy.clusterName = "east"
+
+	c := NewCompositeRetriever(nil, WithRefreshInterval(degraded, time.Minute), healthy).(DegradationReporter)
+	assert.Equal(t, []string{"kubernetes/node", "kubernetes/pod"}, c.Degraded())
+
+	degraded.setDegraded("pod", nil)
+	assert.Equal(t, []string{"kubernetes/node"}, c.Degraded())
+}
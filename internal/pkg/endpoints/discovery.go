@@ -0,0 +1,188 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Actions of the DiscoveryEvents.
+const (
+	DiscoveryAdded   = "added"
+	DiscoveryUpdated = "updated"
+	DiscoveryRemoved = "removed"
+	// DiscoveryTombstoned objects disappeared, but their removal isn't
+	// recorded until their tombstone expires.
+	DiscoveryTombstoned = "tombstoned"
+	// DiscoveryRestored objects reappeared before their tombstone expired.
+	DiscoveryRestored = "restored"
+)
+
+// Reasons of the DiscoveryEvents.
+const (
+	// ReasonDiscovered objects were found by the retriever.
+	ReasonDiscovered = "discovered"
+	// ReasonConfigured targets were added to the configuration.
+	ReasonConfigured = "configured"
+	// ReasonUnconfigured targets were removed from the configuration.
+	ReasonUnconfigured = "unconfigured"
+	// ReasonURLChanged objects have targets with other URLs, e.g. because
+	// their IP changed.
+	ReasonURLChanged = "url_changed"
+	// ReasonDeleted objects were deleted.
+	ReasonDeleted = "deleted"
+	// ReasonUnscrapable objects are not marked as scrapable anymore.
+	ReasonUnscrapable = "unscrapable"
+	// ReasonReappeared objects reappeared before their tombstone expired.
+	ReasonReappeared = "reappeared"
+	// ReasonTombstoneExpired objects didn't reappear before their tombstone
+	// expired.
+	ReasonTombstoneExpired = "tombstone_expired"
+)
+
+// defaultDiscoveryLogSize is the number of events kept by DefaultDiscoveryLog.
+const defaultDiscoveryLogSize = 1000
+
+// DefaultDiscoveryLog is the DiscoveryLog where the TargetRetrievers record
+// their events.
+var DefaultDiscoveryLog = NewDiscoveryLog(defaultDiscoveryLogSize)
+
+// DiscoveryEvent is a change in the targets of an object discovered by a
+// TargetRetriever.
+type DiscoveryEvent struct {
+	Time      time.Time `json:"time"`
+	Retriever string    `json:"retriever"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason,omitempty"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	// Targets are the URLs of the targets after the event.
+	Targets []string `json:"targets,omitempty"`
+	// PreviousTargets are the URLs of the targets before an update.
+	PreviousTargets []string `json:"previousTargets,omitempty"`
+}
+
+// DiscoveryLog keeps the latest DiscoveryEvents in a ring buffer.
+type DiscoveryLog struct {
+	mtx    sync.Mutex
+	events []DiscoveryEvent
+	next   int
+	full   bool
+	now    func() time.Time
+	// subscribers are called with each recorded event.
+	subscribers []func(DiscoveryEvent)
+}
+
+// NewDiscoveryLog returns a DiscoveryLog keeping the given number of events.
+func NewDiscoveryLog(size int) *DiscoveryLog {
+	return &DiscoveryLog{
+		events: make([]DiscoveryEvent, size),
+		now:    time.Now,
+	}
+}
+
+// Record adds the event to the log, replacing the oldest one if it's full,
+// and passes it to the subscribers. The time of the event is set if it's
+// zero.
+func (l *DiscoveryLog) Record(e DiscoveryEvent) {
+	discoveryEventsMetric.WithLabelValues(e.Retriever, e.Action).Inc()
+
+	l.mtx.Lock()
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	if len(l.events) > 0 {
+		l.events[l.next] = e
+		l.next = (l.next + 1) % len(l.events)
+		if l.next == 0 {
+			l.full = true
+		}
+	}
+	subscribers := l.subscribers
+	l.mtx.Unlock()
+
+	for _, f := range subscribers {
+		f(e)
+	}
+}
+
+// Subscribe makes the function be called with the events recorded from now
+// on, in the goroutine of the retriever recording them.
+func (l *DiscoveryLog) Subscribe(f func(DiscoveryEvent)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.subscribers = append(l.subscribers, f)
+}
+
+// Events returns the events in the log, the oldest first.
+func (l *DiscoveryLog) Events() []DiscoveryEvent {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if !l.full {
+		return append([]DiscoveryEvent(nil), l.events[:l.next]...)
+	}
+	events := make([]DiscoveryEvent, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+	return append(events, l.events[:l.next]...)
+}
+
+// ServeHTTP returns the events in the log as JSON. They can be filtered by
+// retriever with the retriever query parameter.
+func (l *DiscoveryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events := l.Events()
+	if retriever := r.URL.Query().Get("retriever"); retriever != "" {
+		filtered := events[:0]
+		for _, e := range events {
+			if e.Retriever == retriever {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(events)
+}
+
+// recordTargets records an event for the targets of an object, and the ones
+// it had before if it's an update.
+func recordTargets(log *DiscoveryLog, retriever, action, reason string, object Object, namespace string, targets, previous []Target) {
+	if log == nil {
+		return
+	}
+	log.Record(DiscoveryEvent{
+		Retriever:       retriever,
+		Action:          action,
+		Reason:          reason,
+		Kind:            object.Kind,
+		Namespace:       namespace,
+		Name:            object.Name,
+		Targets:         targetURLs(targets),
+		PreviousTargets: targetURLs(previous),
+	})
+}
+
+// targetURLs returns the redacted URLs of the targets.
+func targetURLs(targets []Target) []string {
+	var urls []string
+	for i := range targets {
+		urls = append(urls, redactedURLString(&targets[i].URL))
+	}
+	return urls
+}
+
+// sameTargets returns true if both slices have the same target URLs.
+func sameTargets(a, b []Target) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].URL.String() != b[i].URL.String() {
+			return false
+		}
+	}
+	return true
+}
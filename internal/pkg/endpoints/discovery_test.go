@@ -0,0 +1,105 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoveryLogRingBuffer(t *testing.T) {
+	log := NewDiscoveryLog(3)
+	for _, name := range []string{"a", "b"} {
+		log.Record(DiscoveryEvent{Retriever: "fixed", Action: DiscoveryAdded, Name: name})
+	}
+	assert.Equal(t, []string{"a", "b"}, eventNames(log.Events()))
+
+	for _, name := range []string{"c", "d", "e"} {
+		log.Record(DiscoveryEvent{Retriever: "kubernetes", Action: DiscoveryAdded, Name: name})
+	}
+	assert.Equal(t, []string{"c", "d", "e"}, eventNames(log.Events()))
+	assert.False(t, log.Events()[0].Time.IsZero())
+}
+
+func TestDiscoveryLogServeHTTP(t *testing.T) {
+	log := NewDiscoveryLog(10)
+	log.Record(DiscoveryEvent{Retriever: "fixed", Action: DiscoveryAdded, Name: "a"})
+	log.Record(DiscoveryEvent{Retriever: "kubernetes", Action: DiscoveryRemoved, Name: "b"})
+
+	rec := httptest.NewRecorder()
+	log.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/discovery?retriever=kubernetes", nil))
+
+	var events []DiscoveryEvent
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &events))
+	assert.Equal(t, []string{"b"}, eventNames(events))
+}
+
+func TestKubernetesDiscoveryEvents(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	retriever.discoveryLog = NewDiscoveryLog(10)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:       types.UID("uid"),
+			Name:      "my-pod",
+			Namespace: "test-ns",
+			Labels:    map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "8080"},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod}, true)
+	// Modifications that don't change the targets are not recorded.
+	retriever.processEvent(watch.Event{Type: watch.Modified, Object: pod}, false)
+	moved := pod.DeepCopy()
+	moved.Status.PodIP = "10.0.0.2"
+	retriever.processEvent(watch.Event{Type: watch.Modified, Object: moved}, false)
+	retriever.processEvent(watch.Event{Type: watch.Deleted, Object: moved}, true)
+
+	events := retriever.discoveryLog.Events()
+	require.Len(t, events, 3)
+	assert.Equal(t, DiscoveryAdded, events[0].Action)
+	assert.Equal(t, ReasonDiscovered, events[0].Reason)
+	assert.Equal(t, []string{"http://10.0.0.1:8080/metrics"}, events[0].Targets)
+	assert.Equal(t, DiscoveryUpdated, events[1].Action)
+	assert.Equal(t, ReasonURLChanged, events[1].Reason)
+	assert.Equal(t, []string{"http://10.0.0.2:8080/metrics"}, events[1].Targets)
+	assert.Equal(t, []string{"http://10.0.0.1:8080/metrics"}, events[1].PreviousTargets)
+	assert.Equal(t, DiscoveryRemoved, events[2].Action)
+	assert.Equal(t, ReasonDeleted, events[2].Reason)
+	for _, e := range events {
+		assert.Equal(t, "pod", e.Kind)
+		assert.Equal(t, "test-ns", e.Namespace)
+		assert.Equal(t, "my-pod", e.Name)
+		assert.Equal(t, "kubernetes", e.Retriever)
+	}
+}
+
+func TestDiscoveryLogSubscribe(t *testing.T) {
+	log := NewDiscoveryLog(0)
+	var received []DiscoveryEvent
+	log.Subscribe(func(e DiscoveryEvent) {
+		received = append(received, e)
+	})
+	log.Record(DiscoveryEvent{Retriever: "fixed", Action: DiscoveryAdded, Name: "a"})
+
+	require.Len(t, received, 1, "the events are passed even if the log doesn't keep them")
+	assert.Equal(t, "a", received[0].Name)
+	assert.False(t, received[0].Time.IsZero())
+}
+
+func eventNames(events []DiscoveryEvent) []string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	return names
+}
@@ -5,12 +5,24 @@ package endpoints
 
 import (
 	"fmt"
+	"net"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
 )
 
+// lowPriority is the priority value of the targets that are skipped first
+// when the integration is running out of resources.
+const lowPriority = "low"
+
+// schemeRegexp matches the URLs starting with a scheme, so the ones with
+// another URL in their query string still get the default one.
+var schemeRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
 // TargetRetriever is implemented by any type that can return the URL of a set of Prometheus metrics providers
 type TargetRetriever interface {
 	GetTargets() ([]Target, error)
@@ -18,11 +30,20 @@ type TargetRetriever interface {
 	Name() string
 }
 
+// Stopper is implemented by the TargetRetrievers that keep discovering
+// targets in background after Watch is called. Stop ends the discovery.
+type Stopper interface {
+	Stop()
+}
+
 // Object represents a kubernetes object like a pod or a service.
 type Object struct {
 	Name   string
 	Kind   string
 	Labels labels.Set
+	// Annotations are the annotations of the object kept by the retriever.
+	// They are not part of the metadata of its targets.
+	Annotations labels.Set
 }
 
 // Target is a prometheus endpoint which is exposed by an Object.
@@ -33,6 +54,55 @@ type Target struct {
 	metadata        labels.Set
 	TLSConfig       TLSConfig
 	MetricNamespace string
+	// MetricNamespaces route its metrics to namespaces by their prefix,
+	// before falling back to MetricNamespace.
+	MetricNamespaces []NamespaceRoute
+	// Retriever is the name of the TargetRetriever that discovered the target.
+	Retriever string
+	// LowPriority targets are the first ones to be skipped when the
+	// integration is running out of resources.
+	LowPriority bool
+	// SSHProxy is the SSH jump host used to reach the target, if any.
+	SSHProxy SSHProxyConfig
+	// ClusterName is the Kubernetes cluster the target belongs to, when it
+	// isn't the one of the integration.
+	ClusterName string
+	// LabelLimit is the maximum number of labels of its series, overriding
+	// the one of the integration when it isn't zero.
+	LabelLimit int
+	// Schedule has the time windows the target is scraped in. It's always
+	// scraped when nil.
+	Schedule *Schedule
+	// Identity identifies the target across the recreations of its pod,
+	// when it's configured. It's also its name.
+	Identity string
+	// apiServerProxied targets are scraped through the proxy of the
+	// Kubernetes API server, so their own address isn't connected to.
+	apiServerProxied bool
+	// volatileAttributes are removed from the metadata of the targets with
+	// an identity.
+	volatileAttributes []string
+	// Auth are the credentials the target is scraped with, if any.
+	Auth TargetAuthConfig
+	// Headers are set in its scrape requests.
+	Headers map[string]string
+	// ScrapeTimeout overrides the scrape timeout of the integration when it
+	// isn't zero.
+	ScrapeTimeout time.Duration
+	// Format is the format its payloads are parsed as. It's detected from
+	// their Content-Type when empty.
+	Format prometheus.Format
+	// OmitGaugeTimestamps is true if its gauges are emitted without
+	// timestamps, ignoring the ones it reports.
+	OmitGaugeTimestamps bool
+	// NetworkPolicy checks the addresses its scrapes connect to, if it was
+	// discovered by a retriever with a network policy.
+	NetworkPolicy *NetworkPolicy
+}
+
+// Matches returns true if ref is the name or the URL of the target.
+func (t *Target) Matches(ref string) bool {
+	return ref != "" && (ref == t.Name || ref == t.URL.String())
 }
 
 // Metadata returns the Target's metadata, if the current metadata is nil,
@@ -49,6 +119,12 @@ func (t *Target) Metadata() labels.Set {
 			metadata["scrapedTargetKind"] = t.Object.Kind
 		}
 		labels.Accumulate(metadata, t.Object.Labels)
+		if t.Identity != "" {
+			for _, a := range t.volatileAttributes {
+				delete(metadata, a)
+			}
+			metadata["targetIdentity"] = t.Identity
+		}
 
 		t.metadata = metadata
 	}
@@ -69,6 +145,26 @@ func redactedURLString(u *url.URL) string {
 	return ru.String()
 }
 
+// bracketIPv6 encloses in brackets the host of the URL if it's an IPv6 literal
+// without them, which can't be parsed. Its port can only be set with them,
+// e.g. http://[fd00::1]:9100, as the last group would be taken as the port.
+func bracketIPv6(rawURL string) string {
+	i := strings.Index(rawURL, "://") + len("://")
+	host := rawURL[i:]
+	if end := strings.IndexAny(host, "/?#"); end >= 0 {
+		host = host[:end]
+	}
+	// The userinfo, if any, is kept as is.
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		i += at + 1
+		host = host[at+1:]
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+		return rawURL
+	}
+	return rawURL[:i] + "[" + host + "]" + rawURL[i+len(host):]
+}
+
 // New returns a Target from the discovered information
 func New(name string, addr url.URL, object Object) Target {
 	return Target{
@@ -81,31 +177,55 @@ func New(name string, addr url.URL, object Object) Target {
 // EndpointToTarget returns a list of Targets from the provided TargetConfig struct.
 // The URL processing for every Target follows the next conventions:
 // - if no schema is provided, it assumes http
-// - if no path is provided, it assumes /metrics
+// - if no path is provided, it assumes /metrics, or / if disable_default_path is set
+// - the query string, the percent-encoded path and the userinfo are kept, and the fragment is removed
 // For example, hostname:8080 will be interpreted as http://hostname:8080/metrics
+// The URLs are expanded as templates by ExpandURL first.
 func EndpointToTarget(tc TargetConfig) ([]Target, error) {
 	targets := make([]Target, 0, len(tc.URLs))
-	for _, url := range tc.URLs {
-		t, err := urlToTarget(&url, tc.TLSConfig)
+	for _, template := range tc.URLs {
+		urls, err := ExpandURL(template.URL)
 		if err != nil {
 			return nil, err
 		}
-		targets = append(targets, t)
+		for _, u := range urls {
+			url := template
+			url.URL = u
+			t, err := urlToTarget(&url, tc)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, t)
+		}
 	}
 	return targets, nil
 }
 
-func urlToTarget(targetURL *TargetURL, TLSConfig TLSConfig) (Target, error) {
-	if !strings.Contains(targetURL.URL, "://") {
+func urlToTarget(targetURL *TargetURL, tc TargetConfig) (Target, error) {
+	if !schemeRegexp.MatchString(targetURL.URL) {
 		targetURL.URL = fmt.Sprint("http://", targetURL.URL)
 	}
+	targetURL.URL = bracketIPv6(targetURL.URL)
 
 	u, err := url.Parse(targetURL.URL)
 	if err != nil {
 		return Target{}, err
 	}
+	// The fragment is never sent, and would make the same URL look different.
+	u.Fragment = ""
 	if u.Path == "" {
 		u.Path = "/metrics"
+		if targetURL.DisableDefaultPath {
+			u.Path = "/"
+		}
+	}
+	schedule, err := tc.Schedule.Parse()
+	if err != nil {
+		return Target{}, err
+	}
+	tlsConfig := tc.TLSConfig
+	if !targetURL.TLSConfig.IsEmpty() {
+		tlsConfig = targetURL.TLSConfig
 	}
 
 	return Target{
@@ -115,8 +235,18 @@ func urlToTarget(targetURL *TargetURL, TLSConfig TLSConfig) (Target, error) {
 			Kind:   "user_provided",
 			Labels: make(labels.Set),
 		},
-		TLSConfig:       TLSConfig,
-		URL:             *u,
-		MetricNamespace: targetURL.MetricNamespace,
+		TLSConfig:           tlsConfig,
+		URL:                 *u,
+		MetricNamespace:     targetURL.MetricNamespace,
+		MetricNamespaces:    tc.MetricNamespaces,
+		LowPriority:         tc.Priority == lowPriority,
+		SSHProxy:            tc.SSHProxy,
+		LabelLimit:          tc.LabelLimit,
+		Schedule:            schedule,
+		Auth:                targetURL.Auth,
+		Headers:             targetURL.Headers,
+		ScrapeTimeout:       targetURL.Timeout,
+		Format:              targetURL.Format,
+		OmitGaugeTimestamps: targetURL.OmitGaugeTimestamps,
 	}, nil
 }
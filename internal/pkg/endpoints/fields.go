@@ -0,0 +1,117 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+// Prefixes of the references to the labels and annotations of the objects of
+// the targets, in the syntax of the fields of the Kubernetes downward API,
+// e.g. metadata.annotations['app.kubernetes.io/version'].
+const (
+	labelFieldPrefix      = "metadata.labels["
+	annotationFieldPrefix = "metadata.annotations["
+)
+
+// fieldLabels are the metadata of the targets holding the downward API
+// fields of their objects.
+var fieldLabels = map[string]string{
+	"metadata.namespace": "namespaceName",
+	"spec.nodeName":      "nodeName",
+}
+
+// Field returns the value of a field of the object of the target, and false
+// if it doesn't have it. The field is referenced with the syntax of the
+// downward API: metadata.name, metadata.namespace, spec.nodeName,
+// metadata.labels['<key>'] or metadata.annotations['<key>'], the latter only
+// for the annotations the retriever was configured to keep. Any other
+// reference is the name of a metadata attribute of the target, like
+// deploymentName.
+func (t *Target) Field(ref string) (interface{}, bool) {
+	if key, ok := subscriptField(ref, annotationFieldPrefix); ok {
+		v, ok := t.Object.Annotations[key]
+		return v, ok
+	}
+	if key, ok := subscriptField(ref, labelFieldPrefix); ok {
+		ref = "label." + key
+	} else if ref == "metadata.name" {
+		return t.Object.Name, t.Object.Name != ""
+	} else if l, ok := fieldLabels[ref]; ok {
+		ref = l
+	}
+	v, ok := t.Metadata()[ref]
+	return v, ok
+}
+
+// ValidateField returns an error if the field reference is malformed.
+func ValidateField(ref string) error {
+	if ref == "" {
+		return fmt.Errorf("empty field reference")
+	}
+	for _, prefix := range []string{annotationFieldPrefix, labelFieldPrefix} {
+		if strings.HasPrefix(ref, prefix) {
+			if key, ok := subscriptField(ref, prefix); !ok || key == "" {
+				return fmt.Errorf("invalid field reference %q, must be like %s'<key>']", ref, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// AnnotationField returns the key of the annotation referenced by the field,
+// and false if it doesn't reference an annotation.
+func AnnotationField(ref string) (string, bool) {
+	return subscriptField(ref, annotationFieldPrefix)
+}
+
+// subscriptField returns the key of a reference like prefix'<key>'], and
+// false if the reference doesn't have that form.
+func subscriptField(ref, prefix string) (string, bool) {
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, "]") {
+		return "", false
+	}
+	key := strings.TrimSuffix(strings.TrimPrefix(ref, prefix), "]")
+	for _, quote := range []string{"'", `"`} {
+		if len(key) >= 2 && strings.HasPrefix(key, quote) && strings.HasSuffix(key, quote) {
+			return key[1 : len(key)-1], true
+		}
+	}
+	return "", false
+}
+
+// WithTargetAnnotations configures the KubernetesTargetRetriever to keep the
+// given annotations of the objects in their targets, so they can be copied
+// to the metrics. The rest of the annotations are discarded, since some of
+// them, like the last applied configuration, can be large.
+func WithTargetAnnotations(keys []string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		ktr.annotations = keys
+		return nil
+	}
+}
+
+// annotate sets the kept annotations of the object in its targets.
+func (k *KubernetesTargetRetriever) annotate(object metav1.Object, targets []Target) []Target {
+	if len(k.annotations) == 0 {
+		return targets
+	}
+	annotations := labels.Set{}
+	for _, key := range k.annotations {
+		if v, ok := object.GetAnnotations()[key]; ok {
+			annotations[key] = v
+		}
+	}
+	if len(annotations) == 0 {
+		return targets
+	}
+	for i := range targets {
+		targets[i].Object.Annotations = annotations
+	}
+	return targets
+}
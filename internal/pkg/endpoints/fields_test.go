@@ -0,0 +1,69 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTarget_Field(t *testing.T) {
+	p := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-5d8f9c-x2k4q",
+			Namespace: "shop",
+			Labels:    map[string]string{"team": "payments"},
+			Annotations: map[string]string{
+				"app.kubernetes.io/version":                        "1.4.2",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d8f9c"}},
+		},
+		Spec: apiv1.PodSpec{
+			NodeName:   "node-a",
+			Containers: []apiv1.Container{{Name: "web", Ports: []apiv1.ContainerPort{{ContainerPort: 8080}}}},
+		},
+		Status: apiv1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	k := &KubernetesTargetRetriever{annotations: []string{"app.kubernetes.io/version", "missing"}}
+	targets := k.annotate(p, podTargets(p))
+	assert.Len(t, targets, 1)
+	target := targets[0]
+	assert.NotContains(t, target.Object.Annotations, "kubectl.kubernetes.io/last-applied-configuration",
+		"only the configured annotations are kept")
+	assert.NotContains(t, target.Metadata(), "app.kubernetes.io/version")
+
+	for ref, expected := range map[string]interface{}{
+		"metadata.name":           "web-5d8f9c-x2k4q",
+		"metadata.namespace":      "shop",
+		"spec.nodeName":           "node-a",
+		"metadata.labels['team']": "payments",
+		"metadata.annotations['app.kubernetes.io/version']": "1.4.2",
+		`metadata.annotations["app.kubernetes.io/version"]`: "1.4.2",
+		"deploymentName": "web",
+	} {
+		v, ok := target.Field(ref)
+		assert.True(t, ok, ref)
+		assert.Equal(t, expected, v, ref)
+	}
+	for _, ref := range []string{
+		"metadata.annotations['missing']",
+		"metadata.annotations['kubectl.kubernetes.io/last-applied-configuration']",
+		"metadata.labels['missing']",
+		"status.podIP",
+	} {
+		_, ok := target.Field(ref)
+		assert.False(t, ok, ref)
+	}
+}
+
+func TestValidateField(t *testing.T) {
+	assert.NoError(t, ValidateField("metadata.annotations['app.kubernetes.io/version']"))
+	assert.NoError(t, ValidateField("deploymentName"))
+	assert.Error(t, ValidateField(""))
+	assert.Error(t, ValidateField("metadata.annotations[version]"))
+	assert.Error(t, ValidateField("metadata.labels['']"))
+}
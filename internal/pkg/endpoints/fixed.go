@@ -3,23 +3,76 @@
 // SPDX-License-Identifier: Apache-2.0
 package endpoints
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
 
 type fixedRetriever struct {
+	mtx     sync.RWMutex
 	targets []Target
 }
 
+// TargetEditor is implemented by the TargetRetrievers whose targets can be
+// added and removed while the integration is running.
+type TargetEditor interface {
+	// AddTargets adds the targets of the configuration and returns them.
+	AddTargets(cfg TargetConfig) ([]Target, error)
+	// RemoveTargets removes the targets with the given name or URL and
+	// returns the number of targets removed.
+	RemoveTargets(ref string) int
+}
+
 // TargetConfig is used to parse endpoints from the configuration file.
 type TargetConfig struct {
 	Description string
 	URLs        []TargetURL `mapstructure:"urls"`
 	TLSConfig   TLSConfig   `mapstructure:"tls_config"`
+	// Priority is set to "low" for the targets that can be skipped when the
+	// integration is running out of memory.
+	Priority string `mapstructure:"priority"`
+	// SSHProxy is the SSH jump host used to reach the targets.
+	SSHProxy SSHProxyConfig `mapstructure:"ssh_proxy"`
+	// LabelLimit overrides the maximum number of labels of the series of the
+	// targets. Zero keeps the one of the integration.
+	LabelLimit int `mapstructure:"label_limit"`
+	// Schedule has the time windows the targets are scraped in.
+	Schedule ScheduleConfig `mapstructure:"schedule"`
+	// MetricNamespaces route the metrics of the targets to namespaces by
+	// the prefix of their name. The first matching route is used, and the
+	// metric_namespace of the URL if none matches.
+	MetricNamespaces []NamespaceRoute `mapstructure:"metric_namespaces"`
 }
 
 // A TargetURL is a combination of a URL and metadata about it
 type TargetURL struct {
 	URL             string `mapstructure:"url"`
 	MetricNamespace string `mapstructure:"metric_namespace"`
+	// TLSConfig replaces the one of the TargetConfig for the URL.
+	TLSConfig TLSConfig `mapstructure:"tls_config"`
+	// Auth are the credentials the URL is scraped with.
+	Auth TargetAuthConfig `mapstructure:"auth"`
+	// Headers are set in the scrape requests, besides the ones of the
+	// integration.
+	Headers map[string]string `mapstructure:"headers"`
+	// Timeout overrides the scrape_timeout of the integration.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Format forces the format the payloads are parsed as, instead of
+	// detecting it from their Content-Type: prometheus, openmetrics, json
+	// or influx.
+	Format prometheus.Format `mapstructure:"format"`
+	// DisableDefaultPath keeps the root path for the URLs without one,
+	// for the targets whose metrics are served at /, instead of defaulting
+	// to /metrics.
+	DisableDefaultPath bool `mapstructure:"disable_default_path"`
+	// OmitGaugeTimestamps emits the gauges of the URL without timestamps,
+	// so they take the time they are received at, for the targets whose
+	// clocks are unreliable.
+	OmitGaugeTimestamps bool `mapstructure:"omit_gauge_timestamps"`
 }
 
 // TLSConfig is used to store all the configuration required to use Mutual TLS authentication.
@@ -28,30 +81,171 @@ type TLSConfig struct {
 	CertFilePath       string `mapstructure:"cert_file_path"`
 	KeyFilePath        string `mapstructure:"key_file_path"`
 	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// MinVersion is the minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3.
+	MinVersion string `mapstructure:"min_version"`
+	// CipherSuites are the names of the cipher suites accepted, as defined by
+	// Go, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 ones can't be
+	// configured.
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// ServerName overrides the name used to validate the target certificate
+	// and sent as SNI.
+	ServerName string `mapstructure:"server_name"`
+}
+
+// SSHProxyConfig is used to store the configuration of an SSH jump host.
+type SSHProxyConfig struct {
+	// Host is the address of the jump host, with an optional port.
+	Host string `mapstructure:"host"`
+	User string `mapstructure:"user"`
+	// KeyFile is the private key used to authenticate in the jump host.
+	KeyFile string `mapstructure:"key_file"`
+	// KnownHostsFile is the file with the public key of the jump host. The
+	// default known hosts files of the SSH client are used when empty.
+	KnownHostsFile string `mapstructure:"known_hosts_file"`
 }
 
-// FixedRetriever creates a TargetRetriver that returns the targets belonging to the URLs passed as arguments
+// Enabled returns true if a jump host is configured.
+func (c SSHProxyConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// Validate returns an error if the configuration is set but not complete.
+func (c SSHProxyConfig) Validate() error {
+	if c == (SSHProxyConfig{}) {
+		return nil
+	}
+	if c.Host == "" || c.User == "" {
+		return fmt.Errorf("host and user are required")
+	}
+	return nil
+}
+
+// FixedRetriever creates a TargetRetriver that returns the targets belonging to the URLs passed as arguments.
+// The returned retriever is also a TargetEditor.
 func FixedRetriever(targetCfgs ...TargetConfig) (TargetRetriever, error) {
 	fixed := make([]Target, 0, len(targetCfgs))
 	for _, targetCfg := range targetCfgs {
-		targets, err := EndpointToTarget(targetCfg)
+		targets, err := fixedTargets(targetCfg)
 		if err != nil {
-			return nil, fmt.Errorf("parsing target %v: %v", targetCfg, err.Error())
+			return nil, err
 		}
 		fixed = append(fixed, targets...)
 	}
 	return &fixedRetriever{targets: fixed}, nil
 }
 
-func (f fixedRetriever) GetTargets() ([]Target, error) {
-	return f.targets, nil
+// fixedTargets validates the configuration and returns its targets.
+func fixedTargets(targetCfg TargetConfig) ([]Target, error) {
+	if err := targetCfg.TLSConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tls_config: %w", err)
+	}
+	if err := targetCfg.SSHProxy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid ssh_proxy: %w", err)
+	}
+	if targetCfg.LabelLimit < 0 {
+		return nil, fmt.Errorf("label_limit can't be negative")
+	}
+	if _, err := targetCfg.Schedule.Parse(); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	for i, r := range targetCfg.MetricNamespaces {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid metric_namespaces[%d]: %w", i, err)
+		}
+	}
+	for _, u := range targetCfg.URLs {
+		if err := u.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid url %s: %w", u.URL, err)
+		}
+	}
+	targets, err := EndpointToTarget(targetCfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing target %v: %v", targetCfg, err.Error())
+	}
+	// The same URL can be scraped several times, but not with different
+	// options.
+	type urlOptions struct {
+		TargetURL
+		namespaces []NamespaceRoute
+	}
+	urls := make(map[string]urlOptions, len(targets))
+	for _, t := range targets {
+		options := urlOptions{
+			TargetURL: TargetURL{
+				MetricNamespace:     t.MetricNamespace,
+				TLSConfig:           t.TLSConfig,
+				Auth:                t.Auth,
+				Headers:             t.Headers,
+				Timeout:             t.ScrapeTimeout,
+				Format:              t.Format,
+				OmitGaugeTimestamps: t.OmitGaugeTimestamps,
+			},
+			namespaces: t.MetricNamespaces,
+		}
+		if previous, ok := urls[t.URL.String()]; ok && !reflect.DeepEqual(previous, options) {
+			return nil, fmt.Errorf("url %s is repeated with different options", redactedURLString(&t.URL))
+		}
+		urls[t.URL.String()] = options
+	}
+	return targets, nil
+}
+
+func (f *fixedRetriever) GetTargets() ([]Target, error) {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	return append([]Target(nil), f.targets...), nil
 }
 
-func (f fixedRetriever) Watch() error {
-	// NOOP
+func (f *fixedRetriever) Watch() error {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	// The targets only change through the TargetEditor methods, so they are
+	// recorded as added here.
+	for i := range f.targets {
+		recordTargets(DefaultDiscoveryLog, f.Name(), DiscoveryAdded, ReasonConfigured, f.targets[i].Object, "", f.targets[i:i+1], nil)
+	}
 	return nil
 }
 
-func (f fixedRetriever) Name() string {
+func (f *fixedRetriever) Name() string {
 	return "fixed"
 }
+
+// AddTargets adds the targets of the configuration, replacing the existing
+// ones with the same URL.
+func (f *fixedRetriever) AddTargets(cfg TargetConfig) ([]Target, error) {
+	targets, err := fixedTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	for _, t := range targets {
+		f.removeLocked(t.URL.String())
+		f.targets = append(f.targets, t)
+		recordTargets(DefaultDiscoveryLog, f.Name(), DiscoveryAdded, ReasonConfigured, t.Object, "", []Target{t}, nil)
+	}
+	return targets, nil
+}
+
+// RemoveTargets removes the targets with the given name or URL.
+func (f *fixedRetriever) RemoveTargets(ref string) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.removeLocked(ref)
+}
+
+func (f *fixedRetriever) removeLocked(ref string) int {
+	kept := f.targets[:0]
+	removed := 0
+	for _, t := range f.targets {
+		if t.Matches(ref) {
+			removed++
+			recordTargets(DefaultDiscoveryLog, f.Name(), DiscoveryRemoved, ReasonUnconfigured, t.Object, "", []Target{t}, nil)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	f.targets = kept
+	return removed
+}
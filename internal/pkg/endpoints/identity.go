@@ -0,0 +1,83 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultVolatileAttributes are the attributes of the pod targets that change
+// when the pods are recreated.
+var defaultVolatileAttributes = []string{"scrapedTargetURL", "scrapedTargetName", "podName", "nodeName"}
+
+// IdentityConfig configures the identity of the pod targets, so the pods
+// recreated with a new IP or name are treated as the same target, keeping
+// the attributes of their metrics and the continuity of their counters.
+type IdentityConfig struct {
+	// Attributes are the attributes of the targets whose values identify
+	// them, e.g. namespaceName and podName for the pods of a StatefulSet, or
+	// namespaceName and label.pod-template-hash for a Deployment with a single
+	// replica. They must identify a single pod at a time. The targets without
+	// any of them keep being identified by their URL. Empty disables it.
+	Attributes []string `mapstructure:"attributes"`
+	// VolatileAttributes are the attributes removed from the targets with an
+	// identity, as they change when the pods are recreated. Defaults to
+	// scrapedTargetURL, scrapedTargetName, podName and nodeName.
+	VolatileAttributes []string `mapstructure:"volatile_attributes"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *IdentityConfig) Validate() error {
+	if len(c.Attributes) == 0 {
+		return nil
+	}
+	for _, a := range c.Attributes {
+		if a == "" {
+			return fmt.Errorf("the attributes can't be empty")
+		}
+	}
+	if len(c.VolatileAttributes) == 0 {
+		c.VolatileAttributes = defaultVolatileAttributes
+	}
+	return nil
+}
+
+// WithIdentity configures the KubernetesTargetRetriever to name the pod
+// targets after the values of the identity attributes, instead of the pods.
+func WithIdentity(cfg IdentityConfig) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		ktr.identity = cfg
+		return nil
+	}
+}
+
+// apply sets the identity of the targets that have all the identity
+// attributes.
+func (c IdentityConfig) apply(targets []Target) []Target {
+	if len(c.Attributes) == 0 {
+		return targets
+	}
+	for i := range targets {
+		values := make([]string, 0, len(c.Attributes))
+		for _, a := range c.Attributes {
+			v, ok := targets[i].Object.Labels[a]
+			if !ok || fmt.Sprint(v) == "" {
+				break
+			}
+			values = append(values, fmt.Sprint(v))
+		}
+		if len(values) < len(c.Attributes) {
+			continue
+		}
+		targets[i].Identity = strings.Join(values, "/")
+		targets[i].Name = targets[i].Identity
+		targets[i].volatileAttributes = c.VolatileAttributes
+		targets[i].metadata = nil
+	}
+	return targets
+}
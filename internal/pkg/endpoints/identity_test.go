@@ -0,0 +1,71 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIdentity(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	require.NoError(t, WithIdentity(IdentityConfig{Attributes: []string{"namespaceName", "label.app"}})(retriever))
+
+	pod := func(uid, name, ip string, labels map[string]string) *v1.Pod {
+		lbls := map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "8080"}
+		for k, v := range labels {
+			lbls[k] = v
+		}
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, Namespace: "test-ns", Labels: lbls},
+			Spec:       v1.PodSpec{NodeName: "node-" + uid},
+			Status:     v1.PodStatus{PodIP: ip},
+		}
+	}
+
+	// Given a pod that is recreated with another name and IP
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-1", "api-7d9f-abcde", "10.0.0.1", map[string]string{"app": "api"})}, true)
+	before, err := retriever.GetTargets()
+	require.NoError(t, err)
+	retriever.processEvent(watch.Event{Type: watch.Deleted, Object: pod("uid-1", "api-7d9f-abcde", "10.0.0.1", map[string]string{"app": "api"})}, true)
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-2", "api-7d9f-fghij", "10.0.0.2", map[string]string{"app": "api"})}, true)
+	after, err := retriever.GetTargets()
+	require.NoError(t, err)
+
+	// Then it's the same target, with the same attributes
+	require.Len(t, before, 1)
+	require.Len(t, after, 1)
+	assert.Equal(t, "test-ns/api", after[0].Name)
+	assert.Equal(t, before[0].Name, after[0].Name)
+	assert.Equal(t, before[0].Metadata(), after[0].Metadata())
+	assert.Equal(t, "test-ns/api", after[0].Metadata()["targetIdentity"])
+	assert.NotContains(t, after[0].Metadata(), "scrapedTargetURL")
+	assert.NotContains(t, after[0].Metadata(), "podName")
+	assert.Equal(t, "10.0.0.2", after[0].URL.Hostname(), "the target is scraped at its new URL")
+
+	// And the pods without the identity attributes keep their name
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-3", "other", "10.0.0.3", nil)}, true)
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	assert.ElementsMatch(t, []string{"test-ns/api", "other"}, names)
+}
+
+func TestIdentityConfigValidate(t *testing.T) {
+	cfg := IdentityConfig{Attributes: []string{"podName"}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, defaultVolatileAttributes, cfg.VolatileAttributes)
+
+	cfg = IdentityConfig{Attributes: []string{""}}
+	assert.Error(t, cfg.Validate())
+}
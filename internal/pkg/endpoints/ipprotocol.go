@@ -0,0 +1,60 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"net"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// IP protocols the targets can be preferred to be scraped with, in dual-stack
+// clusters and for the host names resolving to addresses of both.
+const (
+	IPv4 = "ipv4"
+	IPv6 = "ipv6"
+)
+
+// ValidateIPProtocol returns an error if the protocol isn't empty, meaning no
+// preference, or one of IPv4 and IPv6.
+func ValidateIPProtocol(protocol string) error {
+	switch protocol {
+	case "", IPv4, IPv6:
+		return nil
+	}
+	return fmt.Errorf("unknown IP protocol %q, valid values are %q and %q", protocol, IPv4, IPv6)
+}
+
+// MatchesIPProtocol returns true if the IP is of the given protocol.
+func MatchesIPProtocol(ip net.IP, protocol string) bool {
+	isIPv4 := ip.To4() != nil
+	return (protocol == IPv4 && isIPv4) || (protocol == IPv6 && !isIPv4)
+}
+
+// WithPreferIPProtocol configures the KubernetesTargetRetriever to scrape the
+// dual-stack pods through their IP of the given protocol. Otherwise, the
+// primary IP of the pod is used.
+func WithPreferIPProtocol(protocol string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if err := ValidateIPProtocol(protocol); err != nil {
+			return err
+		}
+		ktr.preferIPProtocol = protocol
+		return nil
+	}
+}
+
+// preferredPodIP returns the first IP of the pod of the given protocol, or its
+// primary one if there isn't any.
+func preferredPodIP(p *apiv1.Pod, protocol string) string {
+	if protocol == "" {
+		return p.Status.PodIP
+	}
+	for _, podIP := range p.Status.PodIPs {
+		if ip := net.ParseIP(podIP.IP); ip != nil && MatchesIPProtocol(ip, protocol) {
+			return podIP.IP
+		}
+	}
+	return p.Status.PodIP
+}
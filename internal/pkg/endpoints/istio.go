@@ -0,0 +1,200 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// Istio modes supported by the KubernetesTargetRetriever.
+const (
+	// IstioModeMerged scrapes the pods with an Istio sidecar through the
+	// endpoint where the sidecar merges its own metrics with the application
+	// ones. The endpoint is served in plain HTTP, even in STRICT mTLS.
+	IstioModeMerged = "merged"
+	// IstioModeMutualTLS scrapes the application port of the pods with an
+	// Istio sidecar using the Istio workload certificates, when the port is
+	// intercepted by the sidecar.
+	IstioModeMutualTLS = "mtls"
+)
+
+const (
+	istioSidecarStatusAnnotation  = "sidecar.istio.io/status"
+	istioMergeMetricsAnnotation   = "prometheus.istio.io/merge-metrics"
+	istioIncludeInboundAnnotation = "traffic.sidecar.istio.io/includeInboundPorts"
+	istioExcludeInboundAnnotation = "traffic.sidecar.istio.io/excludeInboundPorts"
+	istioProxyContainer           = "istio-proxy"
+	istioPrometheusAnnotationsEnv = "ISTIO_PROMETHEUS_ANNOTATIONS"
+	istioMergedMetricsPort        = "15020"
+	istioMergedMetricsPath        = "/stats/prometheus"
+	defaultIstioCertsDir          = "/etc/istio-certs"
+	istioRootCertFile             = "root-cert.pem"
+	istioCertChainFile            = "cert-chain.pem"
+	istioKeyFile                  = "key.pem"
+)
+
+// IstioConfig configures how the pods with an Istio sidecar are scraped.
+type IstioConfig struct {
+	// Mode is one of IstioModeMerged or IstioModeMutualTLS. Istio sidecars are
+	// not taken into account when empty.
+	Mode string `mapstructure:"mode"`
+	// CertsDir is the directory where the Istio workload certificates are
+	// written by the sidecar of the integration. Only used in IstioModeMutualTLS.
+	CertsDir string `mapstructure:"certs_dir"`
+}
+
+// Validate returns an error if the mode is not supported.
+func (c IstioConfig) Validate() error {
+	switch c.Mode {
+	case "", IstioModeMerged, IstioModeMutualTLS:
+		return nil
+	}
+	return fmt.Errorf("unsupported istio mode %q, must be %q or %q", c.Mode, IstioModeMerged, IstioModeMutualTLS)
+}
+
+// WithIstio configures the KubernetesTargetRetriever to scrape the pods with
+// an Istio sidecar as the given configuration says.
+func WithIstio(cfg IstioConfig) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		if cfg.CertsDir == "" {
+			cfg.CertsDir = defaultIstioCertsDir
+		}
+		ktr.istio = cfg
+		return nil
+	}
+}
+
+// isIstioInjected returns true if the pod has an Istio sidecar.
+func isIstioInjected(p *apiv1.Pod) bool {
+	if _, ok := p.Annotations[istioSidecarStatusAnnotation]; ok {
+		return true
+	}
+	return istioProxy(p) != nil
+}
+
+// istioProxy returns the Istio sidecar container of the pod, if any.
+func istioProxy(p *apiv1.Pod) *apiv1.Container {
+	for i := range p.Spec.Containers {
+		if p.Spec.Containers[i].Name == istioProxyContainer {
+			return &p.Spec.Containers[i]
+		}
+	}
+	// Native sidecars run as init containers.
+	for i := range p.Spec.InitContainers {
+		if p.Spec.InitContainers[i].Name == istioProxyContainer {
+			return &p.Spec.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+// istioPodTargets returns the targets of a pod with an Istio sidecar. In both
+// modes the ports of the sidecar are never scraped on their own, to avoid
+// getting its metrics twice.
+func istioPodTargets(p *apiv1.Pod, cfg IstioConfig) []Target {
+	if p.Status.PodIP == "" {
+		return nil
+	}
+
+	if cfg.Mode == IstioModeMerged && p.Annotations[istioMergeMetricsAnnotation] != "false" {
+		target := podTarget(p, istioMergedMetricsPort, istioMergedMetricsPath)
+		if target != nil {
+			// The merged endpoint is always plain HTTP.
+			target.URL.Scheme = "http"
+			target.TLSConfig = TLSConfig{}
+			return []Target{*target}
+		}
+		return []Target{}
+	}
+
+	targets := podTargets(istioApplicationPod(p))
+	if cfg.Mode != IstioModeMutualTLS {
+		return targets
+	}
+	for i := range targets {
+		if !isIstioInterceptedPort(p, targets[i].URL.Port()) {
+			continue
+		}
+		targets[i].URL.Scheme = "https"
+		targets[i].TLSConfig = TLSConfig{
+			CaFilePath:   filepath.Join(cfg.CertsDir, istioRootCertFile),
+			CertFilePath: filepath.Join(cfg.CertsDir, istioCertChainFile),
+			KeyFilePath:  filepath.Join(cfg.CertsDir, istioKeyFile),
+			// Workload certificates identify the service account, not the
+			// pod address.
+			InsecureSkipVerify: true,
+		}
+	}
+	return targets
+}
+
+// istioApplicationPod returns a copy of the pod without the Istio sidecar and
+// with the scrape annotations the pod had before Istio rewrote them to point
+// to the merged metrics endpoint.
+func istioApplicationPod(p *apiv1.Pod) *apiv1.Pod {
+	app := p.DeepCopy()
+	containers := app.Spec.Containers[:0]
+	for _, c := range app.Spec.Containers {
+		if c.Name != istioProxyContainer {
+			containers = append(containers, c)
+		}
+	}
+	app.Spec.Containers = containers
+
+	proxy := istioProxy(p)
+	if proxy == nil {
+		return app
+	}
+	for _, env := range proxy.Env {
+		if env.Name != istioPrometheusAnnotationsEnv {
+			continue
+		}
+		original := map[string]string{}
+		if err := json.Unmarshal([]byte(env.Value), &original); err != nil {
+			klog.WithError(err).WithField("pod", p.Name).Debug("can't parse original prometheus annotations of istio pod")
+			break
+		}
+		if app.Annotations == nil {
+			app.Annotations = map[string]string{}
+		}
+		delete(app.Annotations, defaultScrapePortLabel)
+		delete(app.Annotations, defaultScrapePathLabel)
+		if port, ok := original["port"]; ok {
+			app.Annotations[defaultScrapePortLabel] = port
+		}
+		if path, ok := original["path"]; ok {
+			app.Annotations[defaultScrapePathLabel] = path
+		}
+	}
+	return app
+}
+
+// isIstioInterceptedPort returns true if the inbound traffic to the port goes
+// through the Istio sidecar, so it requires mTLS when the mode is STRICT.
+func isIstioInterceptedPort(p *apiv1.Pod, port string) bool {
+	if containsPort(p.Annotations[istioExcludeInboundAnnotation], port) {
+		return false
+	}
+	include, ok := p.Annotations[istioIncludeInboundAnnotation]
+	if !ok || include == "*" {
+		return true
+	}
+	return containsPort(include, port)
+}
+
+func containsPort(ports, port string) bool {
+	for _, p := range strings.Split(ports, ",") {
+		if strings.TrimSpace(p) == port {
+			return true
+		}
+	}
+	return false
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -23,7 +24,6 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
-	"github.com/newrelic/nri-prometheus/internal/retry"
 )
 
 const trueStr = "true"
@@ -36,6 +36,7 @@ const (
 	defaultScrapeEnabledLabel = "prometheus.io/scrape"
 	defaultScrapePortLabel    = "prometheus.io/port"
 	defaultScrapePathLabel    = "prometheus.io/path"
+	scrapePriorityLabel       = "prometheus.io/priority"
 	defaultScrapePath         = "/metrics"
 )
 
@@ -84,7 +85,7 @@ func nodeAddress(node *apiv1.Node) (string, map[apiv1.NodeAddressType][]string,
 
 // listNodes gets all the scrapable nodes that are currently available
 func (k *KubernetesTargetRetriever) listNodes() error {
-	nodes, err := k.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	nodes, err := k.client.CoreV1().Nodes().List(k.nodeListOptions())
 	if err != nil {
 		return err
 	}
@@ -99,7 +100,7 @@ func (k *KubernetesTargetRetriever) listNodes() error {
 			klog.WithError(err).WithField("node", n.Name).Warnf("can't get targets for node. Ignoring")
 			continue
 		}
-		k.targets.Store(string(n.UID), targets)
+		k.storeTargets(&n, targets)
 	}
 	return nil
 }
@@ -134,10 +135,14 @@ func nodeTargets(n *apiv1.Node) ([]Target, error) {
 
 	object := Object{Name: n.Name, Kind: "node", Labels: lbls}
 
-	return []Target{
+	targets := []Target{
 		New(n.Name, nodeURL, object),
 		New("cadvisor_"+n.Name, cadvisorURL, object),
-	}, nil
+	}
+	for i := range targets {
+		targets[i].apiServerProxied = true
+	}
+	return targets, nil
 }
 
 // listServices gets the scrapable services that are currently available
@@ -148,7 +153,7 @@ func (k *KubernetesTargetRetriever) listServices() error {
 	}
 	for _, s := range services.Items {
 		if isObjectScrapable(&s, k.scrapeEnabledLabel) {
-			k.targets.Store(string(s.UID), serviceTargets(&s))
+			k.storeTargets(&s, k.serviceTargets(&s))
 		}
 	}
 	return nil
@@ -158,12 +163,22 @@ func isObjectScrapable(o metav1.Object, label string) bool {
 	return o.GetLabels()[label] == trueStr || o.GetAnnotations()[label] == trueStr
 }
 
-func objectTargets(object metav1.Object) []Target {
+// isLowPriority returns true if the object is annotated or labeled as a low
+// priority target. Annotations take precedence over labels.
+func isLowPriority(o metav1.Object) bool {
+	priority, ok := o.GetAnnotations()[scrapePriorityLabel]
+	if !ok {
+		priority = o.GetLabels()[scrapePriorityLabel]
+	}
+	return priority == lowPriority
+}
+
+func (k *KubernetesTargetRetriever) objectTargets(object metav1.Object) []Target {
 	switch obj := object.(type) {
 	case *apiv1.Service:
-		return serviceTargets(obj)
+		return k.serviceTargets(obj)
 	case *apiv1.Pod:
-		return podTargets(obj)
+		return k.podTargets(obj)
 	case *apiv1.Node:
 		targets, err := nodeTargets(obj)
 		if err != nil {
@@ -179,7 +194,7 @@ func serviceTarget(s *apiv1.Service, port, path string) *Target {
 	lbls := labels.Set{}
 	hostname := fmt.Sprintf("%s.%s.svc", s.Name, s.Namespace)
 	hostAndPort := net.JoinHostPort(hostname, port)
-	fullServiceURL := fmt.Sprintf("http://%s%s", hostAndPort, path)
+	fullServiceURL := fmt.Sprintf("%s://%s%s", objectScheme(s), hostAndPort, path)
 	addr, err := url.Parse(fullServiceURL)
 	if err != nil {
 		klog.WithError(err).WithField("service", s.Name).Errorf("couldn't parse service url, skipping: %s", fullServiceURL)
@@ -191,9 +206,18 @@ func serviceTarget(s *apiv1.Service, port, path string) *Target {
 	lbls["serviceName"] = s.Name
 	lbls["namespaceName"] = s.Namespace
 	target := New(s.Name, *addr, Object{Name: s.Name, Kind: "service", Labels: lbls})
+	target.LowPriority = isLowPriority(s)
+	target.TLSConfig = objectTLSConfig(s)
+	target.Schedule = objectSchedule(s)
 	return &target
 }
 
+// serviceTargets returns the targets of the service, through the API server
+// proxy if the retriever is configured to do so.
+func (k *KubernetesTargetRetriever) serviceTargets(s *apiv1.Service) []Target {
+	return k.apiServerProxy.rewrite(serviceTargets(s), "service", s.Namespace, s.Name)
+}
+
 // returns all the possible targets for a service (1 target per port)
 func serviceTargets(s *apiv1.Service) []Target {
 	// Annotations take precedence over labels.
@@ -234,13 +258,13 @@ func serviceTargets(s *apiv1.Service) []Target {
 }
 
 func (k *KubernetesTargetRetriever) listPods() error {
-	pods, err := k.client.CoreV1().Pods("").List(metav1.ListOptions{})
+	pods, err := k.client.CoreV1().Pods("").List(k.podListOptions())
 	if err != nil {
 		return err
 	}
 	for _, p := range pods.Items {
 		if isObjectScrapable(&p, k.scrapeEnabledLabel) {
-			k.targets.Store(string(p.UID), podTargets(&p))
+			k.storeTargets(&p, k.podTargets(&p))
 		}
 	}
 	return nil
@@ -261,7 +285,7 @@ func getPodDeployment(p *apiv1.Pod) string {
 func podTarget(p *apiv1.Pod, port, path string) *Target {
 	lbls := labels.Set{}
 	hostAndPort := net.JoinHostPort(p.Status.PodIP, port)
-	fullPodURL := fmt.Sprintf("http://%s%s", hostAndPort, path)
+	fullPodURL := fmt.Sprintf("%s://%s%s", objectScheme(p), hostAndPort, path)
 	addr, err := url.Parse(fullPodURL)
 	if err != nil {
 		klog.WithError(err).WithField("pod", p.Name).Errorf("couldn't parse pod url, skipping: %s", fullPodURL)
@@ -275,9 +299,29 @@ func podTarget(p *apiv1.Pod, port, path string) *Target {
 	lbls["nodeName"] = p.Spec.NodeName
 	lbls["deploymentName"] = getPodDeployment(p)
 	target := New(p.Name, *addr, Object{Name: p.Name, Kind: "pod", Labels: lbls})
+	target.LowPriority = isLowPriority(p)
+	target.TLSConfig = objectTLSConfig(p)
+	target.Schedule = objectSchedule(p)
 	return &target
 }
 
+// podTargets returns the targets of the pod, taking into account its Istio
+// sidecar, the preferred IP protocol and the API server proxy if the
+// retriever is configured to do so.
+func (k *KubernetesTargetRetriever) podTargets(p *apiv1.Pod) []Target {
+	if ip := preferredPodIP(p, k.preferIPProtocol); ip != p.Status.PodIP {
+		p = p.DeepCopy()
+		p.Status.PodIP = ip
+	}
+	var targets []Target
+	if k.istio.Mode != "" && isIstioInjected(p) {
+		targets = istioPodTargets(p, k.istio)
+	} else {
+		targets = podTargets(p)
+	}
+	return k.identity.apply(k.apiServerProxy.rewrite(targets, "pod", p.Namespace, p.Name))
+}
+
 func podTargets(p *apiv1.Pod) []Target {
 	//if the Pod has not yet been allocated to a Node, or Kubelet/CNI has not yet assigned an ipAddress,
 	// the pod is not yet scrapable.
@@ -343,6 +387,42 @@ func WithKubeConfig(kubeConfigFile string) Option {
 		}
 
 		ktr.client = client
+		ktr.config = config
+		return nil
+	}
+}
+
+// WithKubeConfigContext configures the KubernetesTargetRetriever to load the
+// Kubernetes configuration of the given context from a kubeconfig file. The
+// current context of the file is used if the context is empty.
+func WithKubeConfigContext(kubeConfigFile, context string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeConfigFile},
+			&clientcmd.ConfigOverrides{CurrentContext: context},
+		).ClientConfig()
+		if err != nil {
+			return fmt.Errorf("could not read kubeconfig file: %w", err)
+		}
+
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("could create kubernetes client: %w", err)
+		}
+
+		ktr.client = client
+		ktr.config = config
+		return nil
+	}
+}
+
+// WithClusterName configures the KubernetesTargetRetriever to tag its targets
+// with the name of the cluster they belong to. The retriever is named
+// kubernetes/<clusterName>, so it can be told apart from the ones of other
+// clusters.
+func WithClusterName(clusterName string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		ktr.clusterName = clusterName
 		return nil
 	}
 }
@@ -362,6 +442,7 @@ func WithInClusterConfig() Option {
 		}
 
 		ktr.client = client
+		ktr.config = config
 		return nil
 	}
 }
@@ -370,10 +451,39 @@ func WithInClusterConfig() Option {
 // and listens for the arrival of new data from them.
 type KubernetesTargetRetriever struct {
 	watching                          bool
+	stop                              chan struct{}
+	stopOnce                          sync.Once
 	client                            kubernetes.Interface
 	targets                           *sync.Map
 	scrapeEnabledLabel                string
 	requireScrapeEnabledLabelForNodes bool
+	istio                             IstioConfig
+	preferIPProtocol                  string
+	clusterName                       string
+	discoveryLog                      *DiscoveryLog
+	tombstones                        *tombstones
+	// zones is nil unless only the targets of the zone are returned.
+	zones *zoneFilter
+	// localNode is the node whose targets are discovered, or all of them
+	// when empty.
+	localNode string
+	// identity names the pod targets after their identity attributes.
+	identity IdentityConfig
+	// resyncInterval is how often the objects are listed again, besides
+	// watching their changes. Zero disables it.
+	resyncInterval time.Duration
+	// degraded holds the resource kinds that can't be listed or watched.
+	degraded sync.Map
+	// config is the Kubernetes configuration of the client.
+	config *rest.Config
+	// apiServerProxyConfig configures the scrape through the API server.
+	apiServerProxyConfig APIServerProxyConfig
+	// apiServerProxy rewrites the URLs of the targets scraped through the
+	// API server. Nil scrapes them directly.
+	apiServerProxy *apiServerProxy
+	// annotations are the keys of the annotations of the objects kept in
+	// their targets.
+	annotations []string
 }
 
 // NewKubernetesTargetRetriever creates a new KubernetesTargetRetriever
@@ -385,7 +495,10 @@ func NewKubernetesTargetRetriever(scrapeEnabledLabel string, requireScrapeEnable
 	}
 
 	ktr := &KubernetesTargetRetriever{
+		stop:                              make(chan struct{}),
 		targets:                           new(sync.Map),
+		discoveryLog:                      DefaultDiscoveryLog,
+		tombstones:                        newTombstones(0),
 		scrapeEnabledLabel:                scrapeEnabledLabel,
 		requireScrapeEnabledLabelForNodes: requireScrapeEnabledLabelForNodes,
 	}
@@ -400,6 +513,14 @@ func NewKubernetesTargetRetriever(scrapeEnabledLabel string, requireScrapeEnable
 		return nil, errors.New("newKubernetesTargetRetriever requires a valid Kubernetes configuration option, none are given")
 	}
 
+	if ktr.apiServerProxyConfig.Enabled {
+		proxy, err := newAPIServerProxy(ktr.apiServerProxyConfig, ktr.config)
+		if err != nil {
+			return nil, err
+		}
+		ktr.apiServerProxy = proxy
+	}
+
 	return ktr, nil
 }
 
@@ -422,13 +543,28 @@ func (k *KubernetesTargetRetriever) Watch() error {
 	return nil
 }
 
+// Stop ends the watch of the Kubernetes resources. The targets discovered so
+// far are still returned by GetTargets.
+func (k *KubernetesTargetRetriever) Stop() {
+	k.stopOnce.Do(func() {
+		close(k.stop)
+	})
+}
+
 // Name returns the identifying name of the KubernetesTargetRetriever.
 func (k *KubernetesTargetRetriever) Name() string {
+	if k.clusterName != "" {
+		return "kubernetes/" + k.clusterName
+	}
 	return "kubernetes"
 }
 
 // GetTargets returns a slice with all the targets currently registered.
 func (k *KubernetesTargetRetriever) GetTargets() ([]Target, error) {
+	for _, object := range k.tombstones.expire() {
+		recordTargets(k.discoveryLog, k.Name(), DiscoveryRemoved, ReasonTombstoneExpired, object.Object, object.namespace, nil, nil)
+	}
+
 	length := 0
 	k.targets.Range(func(_, _ interface{}) bool {
 		length++
@@ -439,12 +575,25 @@ func (k *KubernetesTargetRetriever) GetTargets() ([]Target, error) {
 		targets = append(targets, y.([]Target)...)
 		return true
 	})
+	if k.localNode != "" {
+		targets = k.localTargets(targets)
+	}
+	if k.zones != nil {
+		targets = k.zones.filter(k.client, targets)
+	}
+	if k.clusterName != "" {
+		for i := range targets {
+			targets[i].ClusterName = k.clusterName
+		}
+	}
 	return targets, nil
 }
 
 func (k *KubernetesTargetRetriever) listTargets() {
 	_ = k.listPods()
-	_ = k.listServices()
+	if k.localNode == "" {
+		_ = k.listServices()
+	}
 	_ = k.listNodes()
 }
 
@@ -455,28 +604,32 @@ func (k *KubernetesTargetRetriever) watchTargets() {
 }
 
 func (k *KubernetesTargetRetriever) getWatchableResources() []watchableResource {
-	return []watchableResource{{
+	resources := []watchableResource{{
 		name:                      "pod",
 		listFunction:              k.listPods,
 		requireScrapeEnabledLabel: true,
 		watchFunction: func() (watch.Interface, error) {
-			return k.client.CoreV1().Pods("").Watch(metav1.ListOptions{})
+			return k.client.CoreV1().Pods("").Watch(k.podListOptions())
 		},
 	}, {
 		name:                      "node",
 		listFunction:              k.listNodes,
 		requireScrapeEnabledLabel: k.requireScrapeEnabledLabelForNodes,
 		watchFunction: func() (watch.Interface, error) {
-			return k.client.CoreV1().Nodes().Watch(metav1.ListOptions{})
-		},
-	}, {
-		name:                      "service",
-		requireScrapeEnabledLabel: true,
-		listFunction:              k.listServices,
-		watchFunction: func() (watch.Interface, error) {
-			return k.client.CoreV1().Services("").Watch(metav1.ListOptions{})
+			return k.client.CoreV1().Nodes().Watch(k.nodeListOptions())
 		},
 	}}
+	if k.localNode == "" {
+		resources = append(resources, watchableResource{
+			name:                      "service",
+			requireScrapeEnabledLabel: true,
+			listFunction:              k.listServices,
+			watchFunction: func() (watch.Interface, error) {
+				return k.client.CoreV1().Services("").Watch(metav1.ListOptions{})
+			},
+		})
+	}
+	return resources
 }
 
 func (k *KubernetesTargetRetriever) processEvent(event watch.Event, requireLabel bool) {
@@ -519,7 +672,7 @@ func (k *KubernetesTargetRetriever) processEvent(event watch.Event, requireLabel
 			}
 			// If the object is not scrapable and we've seen it before, we remove it.
 			if !scrapable && seen {
-				k.targets.Delete(string(object.GetUID()))
+				k.deleteTargets(object, ReasonUnscrapable)
 				debugLogEvent(klog, event.Type, "deleted", object)
 			}
 		}
@@ -532,13 +685,13 @@ func (k *KubernetesTargetRetriever) processEvent(event watch.Event, requireLabel
 			// If the doesn't doesn't require label and we already have it, update its data.
 			// Things like the IP could be changing.
 			if seen {
-				k.targets.Store(string(object.GetUID()), objectTargets(object))
+				k.storeTargets(object, k.objectTargets(object))
 				debugLogEvent(klog, event.Type, "modified", object)
 				return
 			}
 		}
 	case watch.Deleted, watch.Error:
-		k.targets.Delete(string(object.GetUID()))
+		k.deleteTargets(object, ReasonDeleted)
 		debugLogEvent(klog, event.Type, "deleted", object)
 	}
 }
@@ -546,17 +699,67 @@ func (k *KubernetesTargetRetriever) processEvent(event watch.Event, requireLabel
 // addTarget adds the target to the cache
 func (k *KubernetesTargetRetriever) addTarget(object metav1.Object, event watch.EventType) {
 
-	targets := objectTargets(object)
+	targets := k.objectTargets(object)
 	// zero targets could be for pods that just have been scheduled, but no ipAddress assigned yet
 	if len(targets) == 0 {
 		debugLogEvent(klog, event, "ignored", object)
 		return
 	}
 
-	k.targets.Store(string(object.GetUID()), targets)
+	k.storeTargets(object, targets)
 	debugLogEvent(klog, event, "added", object)
 }
 
+// storeTargets caches the targets of the object, recording in the discovery
+// log whether they were added, updated or restored from a tombstone.
+func (k *KubernetesTargetRetriever) storeTargets(object metav1.Object, targets []Target) {
+	targets = k.annotate(object, targets)
+	uid := string(object.GetUID())
+	previous, seen := k.targets.Load(uid)
+	k.targets.Store(uid, targets)
+	switch {
+	case !seen && k.tombstones.restore(object):
+		k.recordTargets(DiscoveryRestored, ReasonReappeared, object, targets, nil)
+	case !seen:
+		k.recordTargets(DiscoveryAdded, ReasonDiscovered, object, targets, nil)
+	case !sameTargets(previous.([]Target), targets):
+		k.recordTargets(DiscoveryUpdated, ReasonURLChanged, object, targets, previous.([]Target))
+	}
+}
+
+// deleteTargets removes the targets of the object from the cache, recording
+// it in the discovery log with the reason. If tombstones are enabled, the
+// removal is only recorded if the object doesn't reappear before they expire.
+func (k *KubernetesTargetRetriever) deleteTargets(object metav1.Object, reason string) {
+	uid := string(object.GetUID())
+	if _, seen := k.targets.Load(uid); !seen {
+		return
+	}
+	k.targets.Delete(uid)
+	if k.tombstones.add(object) {
+		k.recordTargets(DiscoveryTombstoned, reason, object, nil, nil)
+		return
+	}
+	k.recordTargets(DiscoveryRemoved, reason, object, nil, nil)
+}
+
+func (k *KubernetesTargetRetriever) recordTargets(action, reason string, object metav1.Object, targets, previous []Target) {
+	recordTargets(k.discoveryLog, k.Name(), action, reason, Object{Name: object.GetName(), Kind: objectKind(object)}, object.GetNamespace(), targets, previous)
+}
+
+// objectKind returns the kind of the scrapable objects.
+func objectKind(object metav1.Object) string {
+	switch object.(type) {
+	case *apiv1.Service:
+		return "service"
+	case *apiv1.Pod:
+		return "pod"
+	case *apiv1.Node:
+		return "node"
+	}
+	return ""
+}
+
 func debugLogEvent(log *logrus.Entry, event watch.EventType, action string, object metav1.Object) {
 	log.WithFields(logrus.Fields{
 		"action": action,
@@ -569,34 +772,98 @@ func debugLogEvent(log *logrus.Entry, event watch.EventType, action string, obje
 // watchResource retrieves the scrapable resources and watches for changes
 // on such resources. If the watch connection is terminated, the process is
 // started again to ensure no updates are lost between watch restarts.
+// Failures are retried with exponential backoff, keeping the last known
+// targets meanwhile.
 func (k *KubernetesTargetRetriever) watchResource(resource watchableResource) {
+	backoff := watchInitialBackoff
 	for {
-		timer := prometheus.NewTimer(
-			prometheus.ObserverFunc(
-				listTargetsDurationByKind.WithLabelValues(k.Name(), resource.name).Set,
-			),
-		)
-		err := retry.Do(resource.listFunction)
-		timer.ObserveDuration()
-		if err != nil {
-			klog.WithError(err).Warnf("couldn't list %s resource, retrying", resource.name)
-			continue
+		select {
+		case <-k.stop:
+			return
+		default:
 		}
 
-		watches, err := resource.watchFunction()
-		if err != nil {
-			klog.WithError(err).Warnf(
-				"couldn't subscribe for %s resource watch, retrying",
-				resource.name,
-			)
+		err := k.listAndWatch(resource)
+		if err == errStopped {
+			return
+		}
+		k.setDegraded(resource.name, err)
+		if err == nil {
+			backoff = watchInitialBackoff
 			continue
 		}
-		for w := range watches.ResultChan() {
-			k.processEvent(w, resource.requireScrapeEnabledLabel)
+		select {
+		case <-k.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// errStopped is returned by listAndWatch when the retriever is stopped.
+var errStopped = errors.New("the retriever is stopped")
+
+// listAndWatch lists the resources and handles the events of their watch
+// until it is disconnected. It returns an error if they can't be listed or
+// watched, or the watch was closed right away.
+func (k *KubernetesTargetRetriever) listAndWatch(resource watchableResource) error {
+	timer := prometheus.NewTimer(
+		prometheus.ObserverFunc(
+			listTargetsDurationByKind.WithLabelValues(k.Name(), resource.name).Set,
+		),
+	)
+	err := resource.listFunction()
+	timer.ObserveDuration()
+	if err != nil {
+		return fmt.Errorf("listing the resources: %w", err)
+	}
+
+	watches, err := resource.watchFunction()
+	if err != nil {
+		return fmt.Errorf("subscribing to the watch of the resources: %w", err)
+	}
+	// The resources were listed and are being watched again.
+	k.setDegraded(resource.name, nil)
+	started := time.Now()
+	stopped, resync := k.processEvents(watches, resource.requireScrapeEnabledLabel)
+	if stopped {
+		return errStopped
+	}
+	if resync {
+		klog.Debugf("listing %s resources again", resource.name)
+		return nil
+	}
+	if time.Since(started) < watchHealthyDuration {
+		return errWatchClosed
+	}
+	klog.Debugf("disconnected from %s resource watch, reconnecting", resource.name)
+	return nil
+}
+
+// processEvents handles the events of the watch until it is disconnected, the
+// retriever is stopped, or the objects must be listed again, returning
+// whether it was stopped or it's time to resync.
+func (k *KubernetesTargetRetriever) processEvents(watches watch.Interface, requireLabel bool) (stopped, resync bool) {
+	var resyncC <-chan time.Time
+	if k.resyncInterval > 0 {
+		timer := time.NewTimer(k.resyncInterval)
+		defer timer.Stop()
+		resyncC = timer.C
+	}
+	for {
+		select {
+		case <-k.stop:
+			watches.Stop()
+			return true, false
+		case <-resyncC:
+			watches.Stop()
+			return false, true
+		case w, ok := <-watches.ResultChan():
+			if !ok {
+				return false, false
+			}
+			k.processEvent(w, requireLabel)
 		}
-		klog.WithError(err).Warnf(
-			"disconnected from %s resource watch, reconnecting",
-			resource.name,
-		)
 	}
 }
@@ -3,7 +3,9 @@
 package endpoints
 
 import (
+	"io/ioutil"
 	"net/url"
+	"os"
 	"strconv"
 	"sync"
 	"testing"
@@ -17,6 +19,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
@@ -202,6 +205,52 @@ func TestWatch_NodeReconnect(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestWatch_Stop(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	retriever := newFakeKubernetesTargetRetriever(client)
+	retriever.watching = true
+
+	watcher := watch.NewRaceFreeFake()
+	resource := watchableResource{
+		name:         "node",
+		listFunction: retriever.listNodes,
+		watchFunction: func() (watch.Interface, error) {
+			return watcher, nil
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		retriever.watchResource(resource)
+		close(done)
+	}()
+
+	// Wait until the watch is processing events.
+	watcher.Add(fakeNodeData()[0])
+	err := retry.Do(func() error {
+		targets, err := retriever.GetTargets()
+		if err != nil {
+			return err
+		}
+		if len(targets) != 2 {
+			return errors.New("targets len didn't match: " + strconv.Itoa(len(targets)))
+		}
+		return nil
+	}, retry.Timeout(2*time.Second), retry.Delay(100*time.Millisecond))
+	require.NoError(t, err)
+
+	retriever.Stop()
+	// Stopping twice must not panic.
+	retriever.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watch didn't stop")
+	}
+	assert.True(t, watcher.IsStopped())
+}
+
 func TestWatch_Nodes(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	retriever := newFakeKubernetesTargetRetriever(client)
@@ -266,6 +315,7 @@ func TestWatch_Nodes_NodesWithNoScrapeLabelAreNotBeingScraped(t *testing.T) {
 
 func newFakeKubernetesTargetRetriever(client *fake.Clientset) *KubernetesTargetRetriever {
 	return &KubernetesTargetRetriever{
+		stop:               make(chan struct{}),
 		client:             client,
 		targets:            new(sync.Map),
 		scrapeEnabledLabel: "prometheus.io/scrape",
@@ -670,6 +720,34 @@ func TestPodTargetsNoPort(t *testing.T) {
 	)
 }
 
+func TestPodTargetsPriority(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-pod",
+			Namespace: "test-ns",
+			Labels: map[string]string{
+				"prometheus.io/priority": "high",
+			},
+			Annotations: map[string]string{
+				"prometheus.io/scrape":   "true",
+				"prometheus.io/port":     "8080",
+				"prometheus.io/priority": "low",
+			},
+		},
+		Status: apiv1.PodStatus{
+			PodIP: "10.0.0.1",
+		},
+	}
+	targets := podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.True(t, targets[0].LowPriority, "annotations take precedence over labels")
+
+	delete(pod.Annotations, "prometheus.io/priority")
+	targets = podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.False(t, targets[0].LowPriority)
+}
+
 func TestPodTargetsPortAnnotation(t *testing.T) {
 	assert.ElementsMatch(
 		t,
@@ -1474,3 +1552,290 @@ func TestServiceTargetsPathLabel(t *testing.T) {
 		},
 	)
 }
+
+func TestIstioPodTargets(t *testing.T) {
+	istioPod := func(annotations map[string]string, proxyEnv ...apiv1.EnvVar) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-pod",
+				Namespace:   "test-ns",
+				Annotations: annotations,
+			},
+			Spec: apiv1.PodSpec{
+				Containers: []apiv1.Container{
+					{
+						Name:  "app",
+						Ports: []apiv1.ContainerPort{{ContainerPort: 8080}},
+					},
+					{
+						Name:  "istio-proxy",
+						Ports: []apiv1.ContainerPort{{ContainerPort: 15090}},
+						Env:   proxyEnv,
+					},
+				},
+			},
+			Status: apiv1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	certs := TLSConfig{
+		CaFilePath:         "/etc/istio-certs/root-cert.pem",
+		CertFilePath:       "/etc/istio-certs/cert-chain.pem",
+		KeyFilePath:        "/etc/istio-certs/key.pem",
+		InsecureSkipVerify: true,
+	}
+
+	testCases := []struct {
+		name     string
+		mode     string
+		pod      *apiv1.Pod
+		expected []string
+		tls      []TLSConfig
+	}{
+		{
+			name:     "disabled scrapes every port",
+			pod:      istioPod(nil),
+			expected: []string{"http://10.0.0.1:8080/metrics", "http://10.0.0.1:15090/metrics"},
+			tls:      []TLSConfig{{}, {}},
+		},
+		{
+			name:     "merged scrapes the merged endpoint",
+			mode:     IstioModeMerged,
+			pod:      istioPod(map[string]string{"sidecar.istio.io/status": "{}"}),
+			expected: []string{"http://10.0.0.1:15020/stats/prometheus"},
+			tls:      []TLSConfig{{}},
+		},
+		{
+			name:     "merged skips sidecar ports when merging is disabled",
+			mode:     IstioModeMerged,
+			pod:      istioPod(map[string]string{"prometheus.istio.io/merge-metrics": "false"}),
+			expected: []string{"http://10.0.0.1:8080/metrics"},
+			tls:      []TLSConfig{{}},
+		},
+		{
+			name:     "mtls scrapes the application port with the istio certificates",
+			mode:     IstioModeMutualTLS,
+			pod:      istioPod(nil),
+			expected: []string{"https://10.0.0.1:8080/metrics"},
+			tls:      []TLSConfig{certs},
+		},
+		{
+			name: "mtls restores the annotations rewritten by istio",
+			mode: IstioModeMutualTLS,
+			pod: istioPod(
+				map[string]string{"prometheus.io/port": "15020", "prometheus.io/path": "/stats/prometheus"},
+				apiv1.EnvVar{Name: "ISTIO_PROMETHEUS_ANNOTATIONS", Value: `{"scrape":"true","port":"9090","path":"/custom"}`},
+			),
+			expected: []string{"https://10.0.0.1:9090/custom"},
+			tls:      []TLSConfig{certs},
+		},
+		{
+			name:     "mtls uses plain http for ports not intercepted",
+			mode:     IstioModeMutualTLS,
+			pod:      istioPod(map[string]string{"traffic.sidecar.istio.io/excludeInboundPorts": "9000, 8080"}),
+			expected: []string{"http://10.0.0.1:8080/metrics"},
+			tls:      []TLSConfig{{}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &KubernetesTargetRetriever{}
+			require.NoError(t, WithIstio(IstioConfig{Mode: tc.mode})(k))
+
+			targets := k.podTargets(tc.pod)
+			var urls []string
+			var tls []TLSConfig
+			for _, target := range targets {
+				urls = append(urls, target.URL.String())
+				tls = append(tls, target.TLSConfig)
+			}
+			assert.Equal(t, tc.expected, urls)
+			assert.Equal(t, tc.tls, tls)
+		})
+	}
+}
+
+func TestIstioConfigValidate(t *testing.T) {
+	assert.NoError(t, IstioConfig{}.Validate())
+	assert.NoError(t, IstioConfig{Mode: IstioModeMerged}.Validate())
+	assert.NoError(t, IstioConfig{Mode: IstioModeMutualTLS}.Validate())
+	assert.Error(t, IstioConfig{Mode: "strict"}.Validate())
+}
+
+func TestPodTargetsTLSAnnotations(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-pod",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"prometheus.io/port":                     "8443",
+				"prometheus.io/scheme":                   "https",
+				"prometheus.io/tls-insecure-skip-verify": "true",
+				"prometheus.io/tls-min-version":          "1.2",
+				"prometheus.io/tls-cipher-suites":        "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_CBC_SHA",
+				"prometheus.io/tls-server-name":          "exporter.internal",
+			},
+		},
+		Status: apiv1.PodStatus{PodIP: "10.0.0.1"},
+	}
+
+	targets := podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "https://10.0.0.1:8443/metrics", targets[0].URL.String())
+	assert.Equal(t, TLSConfig{
+		InsecureSkipVerify: true,
+		MinVersion:         "1.2",
+		CipherSuites:       []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_128_CBC_SHA"},
+		ServerName:         "exporter.internal",
+	}, targets[0].TLSConfig)
+
+	// Invalid settings are ignored.
+	pod.Annotations["prometheus.io/tls-min-version"] = "2.0"
+	targets = podTargets(pod)
+	require.Len(t, targets, 1)
+	assert.True(t, targets[0].TLSConfig.IsEmpty())
+}
+
+func TestClusterNameTargets(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	require.NoError(t, WithClusterName("staging")(retriever))
+	retriever.targets.Store("uid", []Target{{Name: "my-pod"}})
+
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "staging", targets[0].ClusterName)
+	assert.Equal(t, "kubernetes/staging", retriever.Name())
+}
+
+func TestPodTargetsPreferIPProtocol(t *testing.T) {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-pod",
+			Annotations: map[string]string{"prometheus.io/port": "9100"},
+		},
+		Status: apiv1.PodStatus{
+			PodIP:  "10.0.0.1",
+			PodIPs: []apiv1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+		},
+	}
+	tests := []struct {
+		protocol string
+		url      string
+	}{
+		{"", "http://10.0.0.1:9100/metrics"},
+		{IPv4, "http://10.0.0.1:9100/metrics"},
+		{IPv6, "http://[fd00::1]:9100/metrics"},
+	}
+	for _, tt := range tests {
+		retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+		require.NoError(t, WithPreferIPProtocol(tt.protocol)(retriever))
+		targets := retriever.podTargets(pod)
+		require.Len(t, targets, 1)
+		assert.Equal(t, tt.url, targets[0].URL.String())
+	}
+	assert.Equal(t, "10.0.0.1", pod.Status.PodIP, "the pod isn't modified")
+
+	single := pod.DeepCopy()
+	single.Status.PodIPs = []apiv1.PodIP{{IP: "10.0.0.1"}}
+	assert.Equal(t, "10.0.0.1", preferredPodIP(single, IPv6), "the primary IP is used if there isn't any of the protocol")
+
+	assert.Error(t, WithPreferIPProtocol("ipv5")(newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())))
+}
+
+func TestLocalNode(t *testing.T) {
+	scrape := map[string]string{"prometheus.io/scrape": "true"}
+	node := func(name string) *apiv1.Node {
+		return &apiv1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name), Labels: scrape},
+			Status:     apiv1.NodeStatus{Addresses: []apiv1.NodeAddress{{Type: apiv1.NodeInternalIP, Address: "10.0.0.1"}}},
+		}
+	}
+	pod := func(name, node string) *apiv1.Pod {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				UID:         types.UID(name),
+				Labels:      scrape,
+				Annotations: map[string]string{"prometheus.io/port": "9100"},
+			},
+			Spec:   apiv1.PodSpec{NodeName: node},
+			Status: apiv1.PodStatus{PodIP: "10.1.0.1"},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		node("node-a"),
+		node("node-b"),
+		pod("pod-a", "node-a"),
+		pod("pod-b", "node-b"),
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "service", UID: "service", Labels: scrape},
+			Spec:       apiv1.ServiceSpec{ClusterIP: "10.2.0.1", Ports: []apiv1.ServicePort{{Port: 8080}}},
+		},
+	)
+
+	retriever := newFakeKubernetesTargetRetriever(client)
+	require.NoError(t, WithLocalNode("node-a")(retriever))
+	retriever.listTargets()
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	assert.ElementsMatch(t, []string{"pod-a", "node-a", "cadvisor_node-a"}, names)
+
+	for _, resource := range retriever.getWatchableResources() {
+		assert.NotEqual(t, "service", resource.name, "services aren't watched")
+	}
+	assert.Equal(t, "spec.nodeName=node-a", retriever.podListOptions().FieldSelector)
+	assert.Equal(t, "metadata.name=node-a", retriever.nodeListOptions().FieldSelector)
+
+	assert.Error(t, WithLocalNode("")(newFakeKubernetesTargetRetriever(client)))
+}
+
+func TestWithKubeConfigContext(t *testing.T) {
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	require.NoError(t, err)
+	defer os.Remove(kubeconfig.Name())
+	_, err = kubeconfig.WriteString(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: production
+  cluster:
+    server: https://production.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+contexts:
+- name: production
+  context:
+    cluster: production
+    user: scraper
+- name: staging
+  context:
+    cluster: staging
+    user: scraper
+current-context: production
+users:
+- name: scraper
+  user:
+    token: secret
+`)
+	require.NoError(t, err)
+	require.NoError(t, kubeconfig.Close())
+
+	retriever, err := NewKubernetesTargetRetriever("", false, WithKubeConfigContext(kubeconfig.Name(), "staging"))
+	require.NoError(t, err)
+	client := retriever.client.(*kubernetes.Clientset)
+	assert.Equal(t, "staging.example.com", client.CoreV1().RESTClient().Get().URL().Host)
+
+	retriever, err = NewKubernetesTargetRetriever("", false, WithKubeConfigContext(kubeconfig.Name(), ""))
+	require.NoError(t, err)
+	client = retriever.client.(*kubernetes.Clientset)
+	assert.Equal(t, "production.example.com", client.CoreV1().RESTClient().Get().URL().Host)
+
+	_, err = NewKubernetesTargetRetriever("", false, WithKubeConfigContext(kubeconfig.Name(), "unknown"))
+	assert.Error(t, err)
+}
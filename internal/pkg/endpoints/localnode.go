@@ -0,0 +1,57 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// WithLocalNode configures the KubernetesTargetRetriever to discover only the
+// pods scheduled on the given node, and the node itself, so the integration
+// can run as a DaemonSet with each replica scraping the targets of its node.
+// The services are not discovered, since every replica would scrape them.
+// An empty nodeName returns an error, since it usually comes from the
+// NODE_NAME environment variable set with the downward API.
+func WithLocalNode(nodeName string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if nodeName == "" {
+			return errors.New("the NODE_NAME environment variable is required to scrape only the local node")
+		}
+		ktr.localNode = nodeName
+		return nil
+	}
+}
+
+// podListOptions returns the options listing and watching the pods the
+// retriever discovers.
+func (k *KubernetesTargetRetriever) podListOptions() metav1.ListOptions {
+	if k.localNode == "" {
+		return metav1.ListOptions{}
+	}
+	return metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("spec.nodeName", k.localNode).String()}
+}
+
+// nodeListOptions returns the options listing and watching the nodes the
+// retriever discovers.
+func (k *KubernetesTargetRetriever) nodeListOptions() metav1.ListOptions {
+	if k.localNode == "" {
+		return metav1.ListOptions{}
+	}
+	return metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", k.localNode).String()}
+}
+
+// localTargets returns the targets of the local node. The API server already
+// filters the objects, but the targets discovered before are kept until they
+// are deleted, so they are filtered again.
+func (k *KubernetesTargetRetriever) localTargets(targets []Target) []Target {
+	filtered := targets[:0]
+	for _, t := range targets {
+		if targetNode(t) == k.localNode {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
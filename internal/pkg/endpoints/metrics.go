@@ -17,8 +17,54 @@ var (
 			"kind",
 		},
 	)
+	discoveryEventsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "discovery_events_total",
+		Help:      "The number of times the targets of an object were added, updated or removed",
+	},
+		[]string{
+			"retriever",
+			"action",
+		},
+	)
+	duplicatedTargetsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "duplicated_targets",
+		Help:      "The number of targets of a retriever not scraped because a retriever with higher precedence discovered them",
+	},
+		[]string{
+			"retriever",
+		},
+	)
+	retrieverDegradedMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "retriever_degraded",
+		Help:      "1 if the resources of a kind can't be listed or watched and their last known targets are scraped, 0 otherwise",
+	},
+		[]string{
+			"retriever",
+			"kind",
+		},
+	)
+	deniedTargetsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nr_stats",
+		Subsystem: "integration",
+		Name:      "denied_targets",
+		Help:      "The number of targets of a retriever not scraped because their addresses are not allowed by the network policy",
+	},
+		[]string{
+			"retriever",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(listTargetsDurationByKind)
+	prometheus.MustRegister(discoveryEventsMetric)
+	prometheus.MustRegister(duplicatedTargetsMetric)
+	prometheus.MustRegister(retrieverDegradedMetric)
+	prometheus.MustRegister(deniedTargetsMetric)
 }
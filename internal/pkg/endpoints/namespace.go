@@ -0,0 +1,37 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NamespaceRoute gives a metric namespace to the metrics of a target whose
+// names start with a prefix.
+type NamespaceRoute struct {
+	// Prefix of the names of the metrics. Empty matches all the metrics.
+	Prefix string `mapstructure:"prefix"`
+	// Namespace prefixes the names of the matching metrics.
+	Namespace string `mapstructure:"namespace"`
+}
+
+// Validate returns an error if the route has no namespace.
+func (r NamespaceRoute) Validate() error {
+	if r.Namespace == "" {
+		return fmt.Errorf("namespace is required")
+	}
+	return nil
+}
+
+// Namespace returns the metric namespace of the metric with the given name:
+// the one of the first of the MetricNamespaces whose prefix it starts with,
+// or the MetricNamespace of the target otherwise.
+func (t Target) Namespace(metric string) string {
+	for _, r := range t.MetricNamespaces {
+		if strings.HasPrefix(metric, r.Prefix) {
+			return r.Namespace
+		}
+	}
+	return t.MetricNamespace
+}
@@ -0,0 +1,326 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metadataNetworks are the addresses of the metadata services of the cloud
+// providers, whose credentials must not be reachable through the scrapes of
+// the discovered targets.
+var metadataNetworks = []string{
+	"169.254.169.254/32", // AWS, Azure, GCP, OpenStack, ...
+	"169.254.170.2/32",   // AWS ECS task metadata.
+	"fd00:ec2::254/128",  // AWS over IPv6.
+	"100.100.100.200/32", // Alibaba Cloud.
+}
+
+const (
+	// resolveTimeout bounds the resolution of the hosts of the targets.
+	resolveTimeout = 5 * time.Second
+	// resolvedTTL is how long the addresses of a host are reused.
+	resolvedTTL = time.Minute
+)
+
+// maxRedirects is the number of redirects followed by the scrapes of the
+// targets with a NetworkPolicy, as the HTTP clients do by default.
+const maxRedirects = 10
+
+// NetworkPolicyConfig restricts the addresses the discovered targets can be
+// scraped at, so the annotations of the pods and services can't make the
+// integration reach other networks. The static targets aren't checked. The
+// addresses of the cloud metadata services are never allowed.
+type NetworkPolicyConfig struct {
+	// Allow are the CIDRs the addresses of the targets must be in. All the
+	// addresses are allowed when empty.
+	Allow []string `mapstructure:"allow"`
+	// Deny are the CIDRs the addresses of the targets can't be in.
+	Deny []string `mapstructure:"deny"`
+}
+
+// Validate returns an error if the CIDRs can't be parsed.
+func (c NetworkPolicyConfig) Validate() error {
+	_, err := c.Policy()
+	return err
+}
+
+// NetworkPolicy is the parsed NetworkPolicyConfig. The targets it allowed
+// when they were discovered have it, so their scrapes check the addresses
+// they connect to, which may not be the ones checked if the names of the
+// targets are resolved again, or the targets redirect the scrapes.
+type NetworkPolicy struct {
+	allow, deny []*net.IPNet
+}
+
+// Policy returns the parsed policy, or an error if the CIDRs can't be parsed.
+func (c NetworkPolicyConfig) Policy() (*NetworkPolicy, error) {
+	p := &NetworkPolicy{}
+	var err error
+	if p.allow, err = parseCIDRs(c.Allow); err != nil {
+		return nil, fmt.Errorf("invalid allow: %w", err)
+	}
+	if p.deny, err = parseCIDRs(c.Deny); err != nil {
+		return nil, fmt.Errorf("invalid deny: %w", err)
+	}
+	metadata, err := parseCIDRs(metadataNetworks)
+	if err != nil {
+		return nil, err
+	}
+	p.deny = append(p.deny, metadata...)
+	return p, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// allows returns an error if the IP is denied, or not allowed.
+func (p *NetworkPolicy) allows(ip net.IP) error {
+	for _, n := range p.deny {
+		if n.Contains(ip) {
+			return fmt.Errorf("address %s is in the denied network %s", ip, n)
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, n := range p.allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("address %s is not in an allowed network", ip)
+}
+
+// Control is a net.Dialer Control function checking the address being
+// connected to, after the host of the target is resolved.
+func (p *NetworkPolicy) Control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("address %s is not an IP", host)
+	}
+	return p.allows(ip)
+}
+
+// CheckRedirect is an http.Client CheckRedirect function checking the
+// address the scrapes are redirected to. The addresses of the hosts are
+// checked by Control when connecting to them.
+func (p *NetworkPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	if ip := net.ParseIP(req.URL.Hostname()); ip != nil {
+		if err := p.allows(ip); err != nil {
+			return fmt.Errorf("redirect to %s: %w", redactedURLString(req.URL), err)
+		}
+	}
+	return nil
+}
+
+// WithNetworkPolicy wraps the retriever so its targets are only returned if
+// all the addresses their hosts resolve to are allowed by the policy. The
+// targets whose hosts can't be resolved are only returned if no networks are
+// explicitly allowed. The returned targets have the policy, to check the
+// addresses their scrapes connect to.
+func WithNetworkPolicy(retriever TargetRetriever, cfg NetworkPolicyConfig) (TargetRetriever, error) {
+	policy, err := cfg.Policy()
+	if err != nil {
+		return nil, err
+	}
+	return &networkPolicyRetriever{
+		TargetRetriever: retriever,
+		policy:          policy,
+		strict:          len(policy.allow) > 0,
+		lookup:          net.DefaultResolver.LookupIPAddr,
+		now:             time.Now,
+		resolved:        map[string]resolvedHost{},
+		log:             logrus.WithField("component", "NetworkPolicy"),
+	}, nil
+}
+
+// resolvedHost are the addresses a host resolved to.
+type resolvedHost struct {
+	ips  []net.IP
+	when time.Time
+}
+
+// networkPolicyRetriever filters the targets of a retriever by their
+// addresses.
+type networkPolicyRetriever struct {
+	TargetRetriever
+	policy *NetworkPolicy
+	// strict drops the targets whose hosts can't be resolved.
+	strict bool
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	now    func() time.Time
+	log    *logrus.Entry
+
+	mtx      sync.Mutex
+	resolved map[string]resolvedHost
+	// denied are the URLs of the targets denied in the last call, so
+	// they are only logged when they start being denied.
+	denied map[string]bool
+}
+
+// GetTargets returns the targets of the retriever whose addresses are
+// allowed. The targets scraped through the proxy of the API server are
+// returned as they are, as their own addresses aren't connected to.
+func (r *networkPolicyRetriever) GetTargets() ([]Target, error) {
+	targets, err := r.TargetRetriever.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+	resolved, errs := r.resolveAll(targets)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	allowed := make([]Target, 0, len(targets))
+	denied := map[string]bool{}
+	for _, t := range targets {
+		if t.apiServerProxied {
+			allowed = append(allowed, t)
+			continue
+		}
+		host := t.URL.Hostname()
+		if err := r.check(resolved[host], errs[host]); err != nil {
+			u := redactedURLString(&t.URL)
+			if !r.denied[u] {
+				r.log.WithField("target", u).WithError(err).Warn("not scraping the target, its address isn't allowed")
+			}
+			denied[u] = true
+			continue
+		}
+		t.NetworkPolicy = r.policy
+		allowed = append(allowed, t)
+	}
+	r.denied = denied
+	deniedTargetsMetric.WithLabelValues(r.Name()).Set(float64(len(targets) - len(allowed)))
+	return allowed, nil
+}
+
+// check returns an error if any of the addresses of a host isn't allowed, or
+// if it couldn't be resolved and only some networks are allowed.
+func (r *networkPolicyRetriever) check(ips []net.IP, resolveErr error) error {
+	if resolveErr != nil {
+		if r.strict {
+			return resolveErr
+		}
+		return nil
+	}
+	for _, ip := range ips {
+		if err := r.policy.allows(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveAll returns the addresses of the hosts of the targets, and the
+// errors of those that couldn't be resolved. The hosts are resolved
+// concurrently, without holding the lock, within resolveTimeout. Their
+// addresses are cached for resolvedTTL.
+func (r *networkPolicyRetriever) resolveAll(targets []Target) (map[string][]net.IP, map[string]error) {
+	resolved := map[string][]net.IP{}
+	errs := map[string]error{}
+	var pending []string
+	r.mtx.Lock()
+	r.expire()
+	for _, t := range targets {
+		host := t.URL.Hostname()
+		if _, ok := resolved[host]; ok || t.apiServerProxied {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			resolved[host] = []net.IP{ip}
+		} else if cached, ok := r.resolved[host]; ok {
+			resolved[host] = cached.ips
+		} else {
+			resolved[host] = nil
+			pending = append(pending, host)
+		}
+	}
+	r.mtx.Unlock()
+	if len(pending) == 0 {
+		return resolved, errs
+	}
+
+	type result struct {
+		host string
+		ips  []net.IP
+		err  error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	results := make(chan result, len(pending))
+	for _, host := range pending {
+		go func(host string) {
+			addrs, err := r.lookup(ctx, host)
+			if err != nil {
+				results <- result{host: host, err: fmt.Errorf("resolving %s: %w", host, err)}
+				return
+			}
+			ips := make([]net.IP, 0, len(addrs))
+			for _, a := range addrs {
+				ips = append(ips, a.IP)
+			}
+			results <- result{host: host, ips: ips}
+		}(host)
+	}
+
+	fresh := map[string][]net.IP{}
+	for range pending {
+		res := <-results
+		if res.err != nil {
+			errs[res.host] = res.err
+			continue
+		}
+		resolved[res.host] = res.ips
+		fresh[res.host] = res.ips
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for host, ips := range fresh {
+		r.resolved[host] = resolvedHost{ips: ips, when: r.now()}
+	}
+	return resolved, errs
+}
+
+// expire removes the addresses resolved longer than resolvedTTL ago.
+func (r *networkPolicyRetriever) expire() {
+	now := r.now()
+	for host, resolved := range r.resolved {
+		if now.Sub(resolved.when) >= resolvedTTL {
+			delete(r.resolved, host)
+		}
+	}
+}
+
+// Stop stops the retriever if it discovers targets in background.
+func (r *networkPolicyRetriever) Stop() {
+	if s, ok := r.TargetRetriever.(Stopper); ok {
+		s.Stop()
+	}
+}
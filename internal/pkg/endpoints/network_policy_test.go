@@ -0,0 +1,160 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkPolicyConfig_Validate(t *testing.T) {
+	assert.NoError(t, NetworkPolicyConfig{}.Validate())
+	assert.NoError(t, NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"fd00::/8"}}.Validate())
+	assert.Error(t, NetworkPolicyConfig{Allow: []string{"10.0.0.0"}}.Validate())
+	assert.Error(t, NetworkPolicyConfig{Deny: []string{"10.0.0.0/33"}}.Validate())
+}
+
+func TestWithNetworkPolicy(t *testing.T) {
+	target := func(rawURL string) Target {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return New(rawURL, *u, Object{Name: "obj", Kind: "pod"})
+	}
+	inner := staticRetriever{name: "kubernetes", targets: []Target{
+		target("http://10.0.0.1:8080/metrics"),
+		target("http://10.1.0.1:8080/metrics"),
+		target("http://192.168.0.1:8080/metrics"),
+		target("http://169.254.169.254/latest/meta-data"),
+		target("http://metadata.internal/computeMetadata"),
+		target("http://svc.default.svc:8080/metrics"),
+		target("http://unknown:8080/metrics"),
+	}}
+	hosts := map[string][]net.IPAddr{
+		"metadata.internal": {{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("169.254.169.254")}},
+		"svc.default.svc":   {{IP: net.ParseIP("10.0.0.3")}},
+	}
+	var lookups int32
+	lookup := func(_ context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddInt32(&lookups, 1)
+		if addrs, ok := hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	urls := func(targets []Target) []string {
+		var us []string
+		for _, t := range targets {
+			us = append(us, t.URL.String())
+		}
+		return us
+	}
+
+	t.Run("allowed networks", func(t *testing.T) {
+		retriever, err := WithNetworkPolicy(inner, NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}})
+		require.NoError(t, err)
+		retriever.(*networkPolicyRetriever).lookup = lookup
+		assert.Equal(t, "kubernetes", retriever.Name())
+
+		targets, err := retriever.GetTargets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://10.0.0.1:8080/metrics", "http://svc.default.svc:8080/metrics"}, urls(targets))
+	})
+
+	t.Run("all networks allowed", func(t *testing.T) {
+		retriever, err := WithNetworkPolicy(inner, NetworkPolicyConfig{})
+		require.NoError(t, err)
+		now := time.Now()
+		retriever.(*networkPolicyRetriever).lookup = lookup
+		retriever.(*networkPolicyRetriever).now = func() time.Time { return now }
+
+		// The metadata services are denied anyway, and the hosts that can't be
+		// resolved are allowed.
+		targets, err := retriever.GetTargets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"http://10.0.0.1:8080/metrics",
+			"http://10.1.0.1:8080/metrics",
+			"http://192.168.0.1:8080/metrics",
+			"http://svc.default.svc:8080/metrics",
+			"http://unknown:8080/metrics",
+		}, urls(targets))
+
+		// The resolved addresses are reused until they expire.
+		atomic.StoreInt32(&lookups, 0)
+		_, err = retriever.GetTargets()
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&lookups), "only the host that couldn't be resolved is looked up again")
+		now = now.Add(resolvedTTL)
+		_, err = retriever.GetTargets()
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), atomic.LoadInt32(&lookups))
+	})
+
+	t.Run("targets proxied through the API server", func(t *testing.T) {
+		proxied := target("http://10.1.0.2:8080/metrics")
+		proxied.apiServerProxied = true
+		retriever, err := WithNetworkPolicy(
+			staticRetriever{name: "kubernetes", targets: []Target{proxied}},
+			NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}},
+		)
+		require.NoError(t, err)
+
+		// The API server connects to them, so they aren't checked.
+		targets, err := retriever.GetTargets()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://10.1.0.2:8080/metrics"}, urls(targets))
+	})
+}
+
+func TestNetworkPolicy_ResolutionChange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	u, err := url.Parse("http://localhost:" + port + "/metrics")
+	require.NoError(t, err)
+	inner := staticRetriever{name: "kubernetes", targets: []Target{New("rebinding", *u, Object{})}}
+	retriever, err := WithNetworkPolicy(inner, NetworkPolicyConfig{Allow: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	// The host resolves to an allowed address when the target is discovered.
+	retriever.(*networkPolicyRetriever).lookup = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	require.NotNil(t, targets[0].NetworkPolicy)
+
+	// But to a denied one when it's scraped.
+	dialer := &net.Dialer{Timeout: time.Second, Control: targets[0].NetworkPolicy.Control}
+	_, err = dialer.Dial("tcp", targets[0].URL.Host)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in an allowed network")
+}
+
+func TestNetworkPolicy_CheckRedirect(t *testing.T) {
+	policy, err := NetworkPolicyConfig{Deny: []string{"10.1.0.0/16"}}.Policy()
+	require.NoError(t, err)
+	redirect := func(rawURL string, via int) error {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		require.NoError(t, err)
+		return policy.CheckRedirect(req, make([]*http.Request, via))
+	}
+
+	assert.NoError(t, redirect("http://10.0.0.1:8080/metrics", 1))
+	assert.NoError(t, redirect("http://exporter:8080/metrics", 1), "the hosts are checked when connecting")
+	assert.Error(t, redirect("http://10.1.0.1:8080/metrics", 1))
+	assert.Error(t, redirect("http://169.254.169.254/latest/meta-data", 1))
+	assert.Error(t, redirect("http://10.0.0.1:8080/metrics", maxRedirects))
+}
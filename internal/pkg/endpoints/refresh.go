@@ -0,0 +1,129 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RefreshConfig configures how often the retrievers refresh their targets,
+// to balance the load of the discovery against how soon the changes are
+// scraped.
+type RefreshConfig struct {
+	// Interval is how often the targets of the retrievers are refreshed. Zero
+	// refreshes them in every scrape cycle.
+	Interval time.Duration `mapstructure:"interval"`
+	// Retrievers overrides Interval for the retrievers with the given names,
+	// e.g. fixed for the static targets, kubernetes, or kubernetes/<name> for
+	// the additional clusters.
+	Retrievers map[string]time.Duration `mapstructure:"retrievers"`
+	// KubernetesResync is how often the Kubernetes retrievers list all the
+	// objects again, besides watching their changes. Zero lists them only
+	// when the API server closes the watch.
+	KubernetesResync time.Duration `mapstructure:"kubernetes_resync"`
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c *RefreshConfig) Validate() error {
+	if c.Interval < 0 || c.KubernetesResync < 0 {
+		return errors.New("interval and kubernetes_resync can't be negative")
+	}
+	for name, interval := range c.Retrievers {
+		if interval < 0 {
+			return errors.New("the interval of retriever " + name + " can't be negative")
+		}
+	}
+	return nil
+}
+
+// For returns the refresh interval of the retriever with the given name.
+func (c RefreshConfig) For(retriever string) time.Duration {
+	if interval, ok := c.Retrievers[retriever]; ok {
+		return interval
+	}
+	return c.Interval
+}
+
+// WithResyncInterval configures the KubernetesTargetRetriever to list all the
+// objects again with the given interval. Zero disables it.
+func WithResyncInterval(interval time.Duration) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		ktr.resyncInterval = interval
+		return nil
+	}
+}
+
+// WithRefreshInterval wraps the retriever so its targets are refreshed at most
+// once per interval, returning the previous ones in the meantime. The
+// retriever is returned as is if the interval is zero. The changes made
+// through the TargetEditor of the returned retriever are returned right away.
+func WithRefreshInterval(retriever TargetRetriever, interval time.Duration) TargetRetriever {
+	if interval <= 0 {
+		return retriever
+	}
+	r := &refreshingRetriever{TargetRetriever: retriever, interval: interval, now: time.Now}
+	if editor, ok := retriever.(TargetEditor); ok {
+		return &refreshingEditor{refreshingRetriever: r, editor: editor}
+	}
+	return r
+}
+
+// refreshingRetriever caches the targets of a retriever.
+type refreshingRetriever struct {
+	TargetRetriever
+	interval time.Duration
+	now      func() time.Time
+
+	mtx       sync.Mutex
+	targets   []Target
+	refreshed time.Time
+}
+
+// GetTargets returns the cached targets, refreshing them if they are older
+// than the interval.
+func (r *refreshingRetriever) GetTargets() ([]Target, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	now := r.now()
+	if r.refreshed.IsZero() || now.Sub(r.refreshed) >= r.interval {
+		targets, err := r.TargetRetriever.GetTargets()
+		if err != nil {
+			return nil, err
+		}
+		r.targets = targets
+		r.refreshed = now
+	}
+	return append([]Target(nil), r.targets...), nil
+}
+
+// invalidate makes the targets be refreshed the next time they are got.
+func (r *refreshingRetriever) invalidate() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.refreshed = time.Time{}
+}
+
+// Stop stops the retriever if it discovers targets in background.
+func (r *refreshingRetriever) Stop() {
+	if s, ok := r.TargetRetriever.(Stopper); ok {
+		s.Stop()
+	}
+}
+
+// refreshingEditor caches the targets of a retriever that is a TargetEditor.
+type refreshingEditor struct {
+	*refreshingRetriever
+	editor TargetEditor
+}
+
+func (r *refreshingEditor) AddTargets(cfg TargetConfig) ([]Target, error) {
+	defer r.invalidate()
+	return r.editor.AddTargets(cfg)
+}
+
+func (r *refreshingEditor) RemoveTargets(ref string) int {
+	defer r.invalidate()
+	return r.editor.RemoveTargets(ref)
+}
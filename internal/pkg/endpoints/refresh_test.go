@@ -0,0 +1,68 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWithRefreshInterval(t *testing.T) {
+	fixed, err := FixedRetriever(TargetConfig{URLs: []TargetURL{{URL: "http://a:9100"}}})
+	require.NoError(t, err)
+	assert.Equal(t, fixed, WithRefreshInterval(fixed, 0), "a zero interval doesn't wrap the retriever")
+
+	retriever := WithRefreshInterval(fixed, time.Minute)
+	now := time.Now()
+	retriever.(*refreshingEditor).now = func() time.Time { return now }
+	assert.Equal(t, "fixed", retriever.Name())
+
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+
+	// The changes of the retriever are returned once the interval passes
+	fixed.(TargetEditor).RemoveTargets("http://a:9100/metrics")
+	targets, err = retriever.GetTargets()
+	require.NoError(t, err)
+	assert.Len(t, targets, 1)
+	now = now.Add(time.Minute)
+	targets, err = retriever.GetTargets()
+	require.NoError(t, err)
+	assert.Empty(t, targets)
+
+	// And the ones made through the retriever right away
+	editor, ok := retriever.(TargetEditor)
+	require.True(t, ok)
+	_, err = editor.AddTargets(TargetConfig{URLs: []TargetURL{{URL: "http://b:9100"}}})
+	require.NoError(t, err)
+	targets, err = retriever.GetTargets()
+	require.NoError(t, err)
+	assert.Len(t, targets, 1)
+}
+
+func TestRefreshConfig(t *testing.T) {
+	cfg := RefreshConfig{Interval: time.Minute, Retrievers: map[string]time.Duration{"fixed": time.Hour}}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, time.Hour, cfg.For("fixed"))
+	assert.Equal(t, time.Minute, cfg.For("kubernetes"))
+
+	cfg.Retrievers["kubernetes"] = -time.Second
+	assert.Error(t, cfg.Validate())
+}
+
+func TestResyncInterval(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	require.NoError(t, WithResyncInterval(10*time.Millisecond)(retriever))
+
+	watcher := watch.NewFake()
+	stopped, resync := retriever.processEvents(watcher, true)
+	assert.False(t, stopped)
+	assert.True(t, resync, "the watch ends to list the objects again")
+	assert.True(t, watcher.IsStopped())
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations and labels with the scrape schedule of pods and services.
+const (
+	scrapeActiveWindowsLabel = "prometheus.io/scrape-active-windows"
+	scrapePausedWindowsLabel = "prometheus.io/scrape-paused-windows"
+	scrapeTimezoneLabel      = "prometheus.io/scrape-timezone"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ScheduleConfig configures the time windows in which the targets are
+// scraped. Windows are written as "[days ]HH:MM-HH:MM", where days is a
+// comma separated list of days or ranges of days, like "Mon-Fri" or
+// "Sat,Sun", and the window is every day when they are omitted. Windows
+// ending before they start, like 22:00-02:00, end the next day.
+type ScheduleConfig struct {
+	// Timezone of the windows, as a name of the IANA database. Defaults to
+	// the local time of the integration.
+	Timezone string `mapstructure:"timezone"`
+	// Active are the windows the targets are scraped in. When empty, they
+	// are scraped at any time except in the paused windows.
+	Active []string `mapstructure:"active"`
+	// Paused are the windows the targets are not scraped in.
+	Paused []string `mapstructure:"paused"`
+}
+
+// Schedule is a parsed ScheduleConfig. A nil Schedule is always active.
+type Schedule struct {
+	location *time.Location
+	active   []window
+	paused   []window
+}
+
+// window is a time window in some days of the week, in minutes of the day.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+// Parse returns the schedule of the configuration, or nil if it doesn't have
+// any window.
+func (c ScheduleConfig) Parse() (*Schedule, error) {
+	if len(c.Active) == 0 && len(c.Paused) == 0 {
+		return nil, nil
+	}
+	s := &Schedule{location: time.Local}
+	if c.Timezone != "" {
+		location, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+		s.location = location
+	}
+	var err error
+	if s.active, err = parseWindows(c.Active); err != nil {
+		return nil, fmt.Errorf("invalid active window: %w", err)
+	}
+	if s.paused, err = parseWindows(c.Paused); err != nil {
+		return nil, fmt.Errorf("invalid paused window: %w", err)
+	}
+	return s, nil
+}
+
+// Active returns true if the targets of the schedule are scraped at the given
+// time.
+func (s *Schedule) Active(now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	now = now.In(s.location)
+	for _, w := range s.paused {
+		if w.contains(now) {
+			return false
+		}
+	}
+	if len(s.active) == 0 {
+		return true
+	}
+	for _, w := range s.active {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if the time is in the window.
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// The window ends the next day.
+	yesterday := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+func parseWindows(specs []string) ([]window, error) {
+	windows := make([]window, 0, len(specs))
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseWindow parses a window written as "[days ]HH:MM-HH:MM".
+func parseWindow(spec string) (window, error) {
+	var w window
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		if err := parseDays(fields[0], &w.days); err != nil {
+			return w, err
+		}
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("must be [days ]HH:MM-HH:MM")
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("must be [days ]HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseMinute(times[0]); err != nil {
+		return w, err
+	}
+	if w.end, err = parseMinute(times[1]); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("the window is empty")
+	}
+	return w, nil
+}
+
+// parseDays sets the days of a comma separated list of days or ranges of
+// days, like Mon-Fri.
+func parseDays(spec string, days *[7]bool) error {
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid days %q", part)
+		}
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseMinute returns the minute of the day of a HH:MM time. 24:00 is the end
+// of the day.
+func parseMinute(spec string) (int, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", spec)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", spec)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", spec)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q", spec)
+	}
+	return hour*60 + minute, nil
+}
+
+// objectSchedule returns the scrape schedule the object is annotated or
+// labeled with, the windows separated by ;. Invalid schedules are ignored.
+func objectSchedule(o metav1.Object) *Schedule {
+	cfg := ScheduleConfig{
+		Timezone: objectSetting(o, scrapeTimezoneLabel),
+		Active:   splitWindows(objectSetting(o, scrapeActiveWindowsLabel)),
+		Paused:   splitWindows(objectSetting(o, scrapePausedWindowsLabel)),
+	}
+	schedule, err := cfg.Parse()
+	if err != nil {
+		klog.WithError(err).WithField("name", o.GetName()).Warn("ignoring invalid scrape schedule annotations")
+		return nil
+	}
+	return schedule
+}
+
+func splitWindows(value string) []string {
+	var windows []string
+	for _, w := range strings.Split(value, ";") {
+		if w = strings.TrimSpace(w); w != "" {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
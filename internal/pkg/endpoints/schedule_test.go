@@ -0,0 +1,90 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSchedule(t *testing.T) {
+	schedule, err := ScheduleConfig{
+		Timezone: "UTC",
+		Active:   []string{"Mon-Fri 09:00-18:00", "Sat 22:00-02:00"},
+		Paused:   []string{"12:00-13:00"},
+	}.Parse()
+	require.NoError(t, err)
+
+	at := func(day, clock string) time.Time {
+		// 2021-03-01 is a Monday.
+		date, err := time.Parse("Mon 2006-01-02 15:04", day+" "+clock)
+		require.NoError(t, err)
+		return date
+	}
+	tests := []struct {
+		time   time.Time
+		active bool
+	}{
+		{at("Mon 2021-03-01", "08:59"), false},
+		{at("Mon 2021-03-01", "09:00"), true},
+		{at("Fri 2021-03-05", "17:59"), true},
+		{at("Fri 2021-03-05", "18:00"), false},
+		{at("Wed 2021-03-03", "12:30"), false},
+		{at("Sat 2021-03-06", "12:00"), false},
+		{at("Sat 2021-03-06", "23:00"), true},
+		{at("Sun 2021-03-07", "01:59"), true},
+		{at("Sun 2021-03-07", "02:00"), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.active, schedule.Active(tt.time), tt.time.Format(time.RFC1123))
+	}
+
+	var always *Schedule
+	assert.True(t, always.Active(time.Now()))
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	schedule, err := ScheduleConfig{Timezone: "America/New_York", Paused: []string{"02:00-03:00"}}.Parse()
+	require.NoError(t, err)
+	assert.False(t, schedule.Active(time.Date(2021, 3, 1, 7, 30, 0, 0, time.UTC)))
+	assert.True(t, schedule.Active(time.Date(2021, 3, 1, 2, 30, 0, 0, time.UTC)))
+}
+
+func TestScheduleConfigParse(t *testing.T) {
+	schedule, err := ScheduleConfig{}.Parse()
+	require.NoError(t, err)
+	assert.Nil(t, schedule)
+
+	for _, window := range []string{"9:00", "Mon-Fri", "Funday 09:00-10:00", "09:00-09:00", "25:00-26:00", "09:60-10:00", "Mon 09:00-10:00 extra"} {
+		_, err := ScheduleConfig{Active: []string{window}}.Parse()
+		assert.Error(t, err, window)
+	}
+	_, err = ScheduleConfig{Timezone: "Mars/Olympus_Mons", Active: []string{"09:00-10:00"}}.Parse()
+	assert.Error(t, err)
+
+	_, err = FixedRetriever(TargetConfig{URLs: []TargetURL{{URL: "host:9100"}}, Schedule: ScheduleConfig{Paused: []string{"02:00"}}})
+	assert.Error(t, err)
+}
+
+func TestObjectSchedule(t *testing.T) {
+	pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "my-pod",
+		Annotations: map[string]string{
+			scrapePausedWindowsLabel: "02:00-03:00; Sat,Sun 00:00-24:00",
+			scrapeTimezoneLabel:      "UTC",
+		},
+	}}
+	schedule := objectSchedule(pod)
+	require.NotNil(t, schedule)
+	assert.Len(t, schedule.paused, 2)
+	assert.False(t, schedule.Active(time.Date(2021, 3, 6, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.Active(time.Date(2021, 3, 5, 12, 0, 0, 0, time.UTC)))
+
+	pod.Annotations[scrapePausedWindowsLabel] = "never"
+	assert.Nil(t, objectSchedule(pod), "invalid schedules are ignored")
+}
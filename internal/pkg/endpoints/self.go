@@ -10,21 +10,33 @@ import (
 const selfEndpoint = "localhost:8080"
 const selfDescription = "nri-prometheus"
 
+// InternalScheme is the scheme of the URLs of the targets whose metrics are
+// gathered by the integration in process, instead of scraped over HTTP.
+const InternalScheme = "internal"
+
+// SelfInternalURL is the URL of the target of the integration own metrics
+// when they are gathered in process.
+const SelfInternalURL = InternalScheme + "://" + selfDescription + "/metrics"
+
 type selfRetriever struct {
 	targets []Target
 }
 
-func newSelfTargetConfig() TargetConfig {
+func newSelfTargetConfig(target TargetURL) TargetConfig {
+	if target.URL == "" {
+		target.URL = selfEndpoint
+	}
 	return TargetConfig{
 		Description: selfDescription,
-		URLs:        []TargetURL{{URL: selfEndpoint}},
+		URLs:        []TargetURL{target},
 	}
 }
 
 // SelfRetriever creates a TargetRetriver that returns the targets belonging
-// to nri-prometheus.
-func SelfRetriever() (TargetRetriever, error) {
-	targets, err := EndpointToTarget(newSelfTargetConfig())
+// to nri-prometheus, served in the URL of the given target, localhost:8080
+// when it's empty.
+func SelfRetriever(target TargetURL) (TargetRetriever, error) {
+	targets, err := EndpointToTarget(newSelfTargetConfig(target))
 	if err != nil {
 		return nil, fmt.Errorf("parsing target %v: %v", selfDescription, err.Error())
 	}
@@ -0,0 +1,66 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TargetAuthConfig are the credentials a target is scraped with. They are
+// read from files, for every request, so they can be rotated.
+type TargetAuthConfig struct {
+	// Username and PasswordFile set the basic authentication.
+	Username     string `mapstructure:"username"`
+	PasswordFile string `mapstructure:"password_file"`
+	// BearerTokenFile is a file with the bearer token sent. It replaces the
+	// bearer_token_file of the integration.
+	BearerTokenFile string `mapstructure:"bearer_token_file"`
+}
+
+// IsEmpty returns true if no credentials are configured.
+func (c TargetAuthConfig) IsEmpty() bool {
+	return c == TargetAuthConfig{}
+}
+
+// Validate returns an error if the credentials are incomplete, or both
+// basic and bearer authentication are set.
+func (c TargetAuthConfig) Validate() error {
+	if (c.Username == "") != (c.PasswordFile == "") {
+		return fmt.Errorf("username and password_file must be set together")
+	}
+	if c.Username != "" && c.BearerTokenFile != "" {
+		return fmt.Errorf("basic authentication and bearer_token_file can't be set together")
+	}
+	return nil
+}
+
+// Validate returns an error if the options of the URL are not valid or
+// conflict with each other.
+func (u TargetURL) Validate() error {
+	if u.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if err := u.TLSConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid tls_config: %w", err)
+	}
+	if !u.TLSConfig.IsEmpty() && strings.HasPrefix(strings.ToLower(u.URL), "http://") {
+		return fmt.Errorf("tls_config is set but the url isn't https")
+	}
+	if err := u.Auth.Validate(); err != nil {
+		return fmt.Errorf("invalid auth: %w", err)
+	}
+	for name := range u.Headers {
+		if !u.Auth.IsEmpty() && http.CanonicalHeaderKey(name) == "Authorization" {
+			return fmt.Errorf("the Authorization header can't be set together with auth")
+		}
+	}
+	if u.Timeout < 0 {
+		return fmt.Errorf("timeout can't be negative")
+	}
+	if err := u.Format.Validate(); err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kubernetes annotations, or labels, to configure the TLS settings of a target.
+const (
+	scrapeSchemeLabel                = "prometheus.io/scheme"
+	scrapeTLSInsecureSkipVerifyLabel = "prometheus.io/tls-insecure-skip-verify"
+	scrapeTLSMinVersionLabel         = "prometheus.io/tls-min-version"
+	scrapeTLSCipherSuitesLabel       = "prometheus.io/tls-cipher-suites"
+	scrapeTLSServerNameLabel         = "prometheus.io/tls-server-name"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuites are the cipher suites of the tls package by name, the
+// insecure ones included. The ChaCha20-Poly1305 ones are also known by their
+// IANA names, ending in _SHA256.
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                      tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":              tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":                tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":          tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":        tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_AES_128_GCM_SHA256":                        tls.TLS_AES_128_GCM_SHA256,
+	"TLS_AES_256_GCM_SHA384":                        tls.TLS_AES_256_GCM_SHA384,
+	"TLS_CHACHA20_POLY1305_SHA256":                  tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// IsEmpty returns true if no TLS setting is configured.
+func (c TLSConfig) IsEmpty() bool {
+	return c.CaFilePath == "" &&
+		c.CertFilePath == "" &&
+		c.KeyFilePath == "" &&
+		!c.InsecureSkipVerify &&
+		c.MinVersion == "" &&
+		len(c.CipherSuites) == 0 &&
+		c.ServerName == ""
+}
+
+// Validate returns an error if the TLS version or any of the cipher suites
+// are unknown, or if only one of the certificate and key files is set.
+func (c TLSConfig) Validate() error {
+	if (c.CertFilePath == "") != (c.KeyFilePath == "") {
+		return fmt.Errorf("cert_file_path and key_file_path must be set together")
+	}
+	if _, err := c.TLSMinVersion(); err != nil {
+		return err
+	}
+	_, err := c.CipherSuiteIDs()
+	return err
+}
+
+// TLSMinVersion returns the tls package constant of MinVersion, or 0 if it's
+// not set.
+func (c TLSConfig) TLSMinVersion() (uint16, error) {
+	if c.MinVersion == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", c.MinVersion)
+	}
+	return version, nil
+}
+
+// CipherSuiteIDs returns the IDs of the CipherSuites, or nil if they're not
+// set. Insecure cipher suites are accepted, as they may be the only ones
+// supported by some exporters.
+func (c TLSConfig) CipherSuiteIDs() ([]uint16, error) {
+	if len(c.CipherSuites) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint16, 0, len(c.CipherSuites))
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// objectScheme returns the scheme used to scrape the object, http unless it's
+// annotated or labeled otherwise. Annotations take precedence over labels.
+func objectScheme(o metav1.Object) string {
+	if scheme := objectSetting(o, scrapeSchemeLabel); scheme == "https" {
+		return scheme
+	}
+	return "http"
+}
+
+// objectTLSConfig returns the TLS settings the object is annotated or labeled
+// with. Annotations take precedence over labels. Invalid settings are ignored.
+func objectTLSConfig(o metav1.Object) TLSConfig {
+	cfg := TLSConfig{
+		InsecureSkipVerify: objectSetting(o, scrapeTLSInsecureSkipVerifyLabel) == trueStr,
+		MinVersion:         objectSetting(o, scrapeTLSMinVersionLabel),
+		ServerName:         objectSetting(o, scrapeTLSServerNameLabel),
+	}
+	if suites := objectSetting(o, scrapeTLSCipherSuitesLabel); suites != "" {
+		for _, s := range strings.Split(suites, ",") {
+			cfg.CipherSuites = append(cfg.CipherSuites, strings.TrimSpace(s))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		klog.WithError(err).WithField("name", o.GetName()).Warn("ignoring invalid TLS annotations")
+		return TLSConfig{}
+	}
+	return cfg
+}
+
+func objectSetting(o metav1.Object, name string) string {
+	value, ok := o.GetAnnotations()[name]
+	if !ok {
+		value = o.GetLabels()[name]
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WithTombstoneTTL configures the KubernetesTargetRetriever to keep the
+// objects that disappear as tombstoned for the given time. If they reappear
+// meanwhile, for example during a rolling update, they are restored instead
+// of being removed and added again. Zero disables the tombstones.
+func WithTombstoneTTL(ttl time.Duration) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		ktr.tombstones = newTombstones(ttl)
+		return nil
+	}
+}
+
+// tombstonedObject is an object whose targets disappeared.
+type tombstonedObject struct {
+	Object
+	namespace string
+	expires   time.Time
+}
+
+// tombstones keeps the objects that disappeared, by kind, namespace and name,
+// as objects recreated with the same name get a new UID.
+type tombstones struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx     sync.Mutex
+	objects map[string]tombstonedObject
+}
+
+func newTombstones(ttl time.Duration) *tombstones {
+	return &tombstones{
+		ttl:     ttl,
+		now:     time.Now,
+		objects: make(map[string]tombstonedObject),
+	}
+}
+
+func tombstoneKey(object metav1.Object) string {
+	return objectKind(object) + "/" + object.GetNamespace() + "/" + object.GetName()
+}
+
+// add tombstones the object. It returns false if tombstones are disabled.
+func (t *tombstones) add(object metav1.Object) bool {
+	if t == nil || t.ttl <= 0 {
+		return false
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.objects[tombstoneKey(object)] = tombstonedObject{
+		Object:    Object{Name: object.GetName(), Kind: objectKind(object)},
+		namespace: object.GetNamespace(),
+		expires:   t.now().Add(t.ttl),
+	}
+	return true
+}
+
+// restore removes the tombstone of the object. It returns true if it existed
+// and hadn't expired.
+func (t *tombstones) restore(object metav1.Object) bool {
+	if t == nil {
+		return false
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	key := tombstoneKey(object)
+	tombstone, ok := t.objects[key]
+	if !ok {
+		return false
+	}
+	delete(t.objects, key)
+	return t.now().Before(tombstone.expires)
+}
+
+// expire removes the expired tombstones and returns their objects.
+func (t *tombstones) expire() []tombstonedObject {
+	if t == nil {
+		return nil
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var expired []tombstonedObject
+	now := t.now()
+	for key, tombstone := range t.objects {
+		if !now.Before(tombstone.expires) {
+			expired = append(expired, tombstone)
+			delete(t.objects, key)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTombstones(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	retriever.discoveryLog = NewDiscoveryLog(10)
+	require.NoError(t, WithTombstoneTTL(time.Minute)(retriever))
+	now := time.Now()
+	retriever.tombstones.now = func() time.Time { return now }
+
+	pod := func(uid string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:       types.UID(uid),
+				Name:      "my-pod",
+				Namespace: "test-ns",
+				Labels:    map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "8080"},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+
+	// Given a pod that is recreated before its tombstone expires
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-1")}, true)
+	retriever.processEvent(watch.Event{Type: watch.Deleted, Object: pod("uid-1")}, true)
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+	assert.Empty(t, targets)
+
+	now = now.Add(30 * time.Second)
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-2")}, true)
+	targets, err = retriever.GetTargets()
+	require.NoError(t, err)
+	assert.Len(t, targets, 1)
+
+	// And deleted again until its tombstone expires
+	retriever.processEvent(watch.Event{Type: watch.Deleted, Object: pod("uid-2")}, true)
+	now = now.Add(time.Minute)
+	_, err = retriever.GetTargets()
+	require.NoError(t, err)
+
+	var actions []string
+	for _, e := range retriever.discoveryLog.Events() {
+		actions = append(actions, e.Action)
+		assert.Equal(t, "pod", e.Kind)
+		assert.Equal(t, "test-ns", e.Namespace)
+		assert.Equal(t, "my-pod", e.Name)
+	}
+	assert.Equal(t, []string{
+		DiscoveryAdded,
+		DiscoveryTombstoned,
+		DiscoveryRestored,
+		DiscoveryTombstoned,
+		DiscoveryRemoved,
+	}, actions)
+
+	// Expired tombstones are not restored.
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod("uid-3")}, true)
+	events := retriever.discoveryLog.Events()
+	assert.Equal(t, DiscoveryAdded, events[len(events)-1].Action)
+}
+
+func TestTombstonesDisabled(t *testing.T) {
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	retriever.discoveryLog = NewDiscoveryLog(10)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:    types.UID("uid"),
+			Name:   "my-pod",
+			Labels: map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "8080"},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	retriever.processEvent(watch.Event{Type: watch.Added, Object: pod}, true)
+	retriever.processEvent(watch.Event{Type: watch.Deleted, Object: pod}, true)
+	events := retriever.discoveryLog.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, DiscoveryRemoved, events[1].Action)
+}
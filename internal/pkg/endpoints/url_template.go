@@ -0,0 +1,154 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedURLs is the maximum number of URLs a template expands into, so a
+// typo can't create an unbounded number of targets.
+const maxExpandedURLs = 10000
+
+// portRange matches a range of ports at the end of the host of a URL, like
+// host:9100-9110.
+var portRange = regexp.MustCompile(`:(\d+)-(\d+)$`)
+
+// ExpandURL returns the URLs of a template: every {start..end} numeric range
+// and {a,b,c} list is expanded, like http://node{01..20}:9100, as well as a
+// range of ports, like host:9100-9110. The numbers of the ranges starting
+// with zeros are padded to the same width.
+func ExpandURL(template string) ([]string, error) {
+	urls, err := expandBraces(template)
+	if err != nil {
+		return nil, err
+	}
+	var expanded []string
+	for _, u := range urls {
+		ports, err := expandPorts(u)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, ports...)
+		if len(expanded) > maxExpandedURLs {
+			return nil, fmt.Errorf("%s expands into more than %d urls", template, maxExpandedURLs)
+		}
+	}
+	return expanded, nil
+}
+
+// expandBraces expands the brace expressions of the template, from left to
+// right.
+func expandBraces(template string) ([]string, error) {
+	start := strings.Index(template, "{")
+	if start < 0 {
+		if strings.Contains(template, "}") {
+			return nil, fmt.Errorf("unopened } in %s", template)
+		}
+		return []string{template}, nil
+	}
+	end := strings.Index(template[start:], "}")
+	if end < 0 {
+		return nil, fmt.Errorf("unclosed { in %s", template)
+	}
+	end += start
+	values, err := braceValues(template[start+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %s in %s: %w", template[start:end+1], template, err)
+	}
+	if strings.Contains(template[:start], "}") {
+		return nil, fmt.Errorf("unopened } in %s", template)
+	}
+	suffixes, err := expandBraces(template[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(values)*len(suffixes) > maxExpandedURLs {
+		return nil, fmt.Errorf("%s expands into more than %d urls", template, maxExpandedURLs)
+	}
+	expanded := make([]string, 0, len(values)*len(suffixes))
+	for _, v := range values {
+		for _, s := range suffixes {
+			expanded = append(expanded, template[:start]+v+s)
+		}
+	}
+	return expanded, nil
+}
+
+// braceValues returns the values of a brace expression without the braces:
+// a numeric range like 01..20 or a list like a,b,c.
+func braceValues(expr string) ([]string, error) {
+	if strings.Contains(expr, "{") {
+		return nil, fmt.Errorf("nested braces aren't supported")
+	}
+	bounds := strings.Split(expr, "..")
+	if len(bounds) == 1 {
+		values := strings.Split(expr, ",")
+		for _, v := range values {
+			if v == "" {
+				return nil, fmt.Errorf("empty value")
+			}
+		}
+		return values, nil
+	}
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("ranges must be start..end")
+	}
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid range start %q", bounds[0])
+	}
+	last, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid range end %q", bounds[1])
+	}
+	if first < 0 || first > last {
+		return nil, fmt.Errorf("the range must be ascending and not negative")
+	}
+	if last-first >= maxExpandedURLs {
+		return nil, fmt.Errorf("the range has more than %d values", maxExpandedURLs)
+	}
+	width := 0
+	if (len(bounds[0]) > 1 && bounds[0][0] == '0') || (len(bounds[1]) > 1 && bounds[1][0] == '0') {
+		width = len(bounds[0])
+		if len(bounds[1]) > width {
+			width = len(bounds[1])
+		}
+	}
+	values := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		values = append(values, fmt.Sprintf("%0*d", width, i))
+	}
+	return values, nil
+}
+
+// expandPorts expands the range of ports of the URL, if any.
+func expandPorts(rawURL string) ([]string, error) {
+	hostStart := 0
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		hostStart = i + len("://")
+	}
+	hostEnd := len(rawURL)
+	if i := strings.IndexAny(rawURL[hostStart:], "/?#"); i >= 0 {
+		hostEnd = hostStart + i
+	}
+	host := rawURL[hostStart:hostEnd]
+	m := portRange.FindStringSubmatchIndex(host)
+	if m == nil {
+		return []string{rawURL}, nil
+	}
+	first, _ := strconv.Atoi(host[m[2]:m[3]])
+	last, _ := strconv.Atoi(host[m[4]:m[5]])
+	if first > last || last > 65535 {
+		return nil, fmt.Errorf("invalid port range %s in %s", host[m[0]+1:], rawURL)
+	}
+	prefix, suffix := rawURL[:hostStart+m[0]+1], rawURL[hostEnd:]
+	urls := make([]string, 0, last-first+1)
+	for port := first; port <= last; port++ {
+		urls = append(urls, prefix+strconv.Itoa(port)+suffix)
+	}
+	return urls, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandURL(t *testing.T) {
+	testCases := []struct {
+		template string
+		expected []string
+	}{
+		{template: "http://node:9100/metrics", expected: []string{"http://node:9100/metrics"}},
+		{template: "http://node{01..03}:9100/metrics", expected: []string{
+			"http://node01:9100/metrics", "http://node02:9100/metrics", "http://node03:9100/metrics",
+		}},
+		{template: "node{8..10}", expected: []string{"node8", "node9", "node10"}},
+		{template: "node{8..010}", expected: []string{"node008", "node009", "node010"}},
+		{template: "{db,cache}-{1..2}:9100", expected: []string{"db-1:9100", "db-2:9100", "cache-1:9100", "cache-2:9100"}},
+		{template: "host:9100-9102", expected: []string{"host:9100", "host:9101", "host:9102"}},
+		{template: "https://host:9100-9101/metrics?x=1-2", expected: []string{
+			"https://host:9100/metrics?x=1-2", "https://host:9101/metrics?x=1-2",
+		}},
+		{template: "http://[fd00::1]:9100-9101", expected: []string{"http://[fd00::1]:9100", "http://[fd00::1]:9101"}},
+		{template: "http://node-{a,b}:9100/metrics", expected: []string{"http://node-a:9100/metrics", "http://node-b:9100/metrics"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.template, func(t *testing.T) {
+			urls, err := ExpandURL(tc.template)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, urls)
+		})
+	}
+}
+
+func TestExpandURL_Invalid(t *testing.T) {
+	for _, template := range []string{
+		"node{01..03:9100",
+		"node}:9100",
+		"node{3..1}",
+		"node{a..b}",
+		"node{1..2..3}",
+		"node{a,,b}",
+		"node{{1..2}}",
+		"host:9110-9100",
+		"host:65535-65536",
+		"node{0..10000}",
+		"node{0..999}:9000-9100",
+	} {
+		t.Run(template, func(t *testing.T) {
+			_, err := ExpandURL(template)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFixedRetrieverExpandsTemplates(t *testing.T) {
+	retriever, err := FixedRetriever(TargetConfig{URLs: []TargetURL{{URL: "node{1..2}:9100-9101", MetricNamespace: "node"}}})
+	require.NoError(t, err)
+	targets, err := retriever.GetTargets()
+	require.NoError(t, err)
+
+	var urls []string
+	for _, target := range targets {
+		urls = append(urls, target.URL.String())
+		assert.Equal(t, "node", target.MetricNamespace)
+	}
+	assert.Equal(t, []string{
+		"http://node1:9100/metrics", "http://node1:9101/metrics",
+		"http://node2:9100/metrics", "http://node2:9101/metrics",
+	}, urls)
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultReplicaSelector     = "app=nri-prometheus"
+	defaultZoneRefreshInterval = time.Minute
+)
+
+// zoneLabels are the node labels with their zone, by precedence.
+var zoneLabels = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+
+// ZoneConfig configures the replicas of the integration to scrape only the
+// targets in their own availability zone. The targets in zones without any
+// replica, and the services, are scraped by the replicas of the first zone
+// with replicas, in alphabetical order.
+type ZoneConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Zone of the replica. When empty, it's the zone of its node.
+	Zone string `mapstructure:"zone"`
+	// ReplicaSelector is the label selector of the pods of the integration,
+	// to find the zones with replicas. Defaults to app=nri-prometheus.
+	ReplicaSelector string `mapstructure:"replica_selector"`
+	// RefreshInterval is how often the zones of the nodes and the replicas
+	// are listed. Defaults to 1m.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+}
+
+// Validate returns an error if the configuration is not valid, and sets the
+// default values of the missing settings.
+func (c *ZoneConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.RefreshInterval < 0 {
+		return errors.New("refresh_interval can't be negative")
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = defaultZoneRefreshInterval
+	}
+	if c.ReplicaSelector == "" {
+		c.ReplicaSelector = defaultReplicaSelector
+	}
+	return nil
+}
+
+// WithZoneAwareness configures the KubernetesTargetRetriever to return only
+// the targets of its zone, as the configuration says. nodeName is the node
+// the integration runs in, used to find its zone if it isn't configured.
+func WithZoneAwareness(cfg ZoneConfig, nodeName string) Option {
+	return func(ktr *KubernetesTargetRetriever) error {
+		if !cfg.Enabled {
+			return nil
+		}
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		if cfg.Zone == "" && nodeName == "" {
+			return errors.New("the zone or the NODE_NAME environment variable is required for the zone awareness")
+		}
+		ktr.zones = &zoneFilter{cfg: cfg, nodeName: nodeName, now: time.Now}
+		return nil
+	}
+}
+
+// zoneFilter keeps the targets that must be scraped by the replicas of a
+// zone.
+type zoneFilter struct {
+	cfg      ZoneConfig
+	nodeName string
+	now      func() time.Time
+
+	mtx       sync.Mutex
+	refreshed time.Time
+	zone      string
+	nodeZones map[string]string
+	// replicaZones are the zones with replicas.
+	replicaZones map[string]bool
+	// fallbackZone has the replicas scraping the targets of the zones
+	// without replicas.
+	fallbackZone string
+}
+
+// filter returns the targets that must be scraped by the replicas of the
+// zone. All of them are returned until the zones are known.
+func (z *zoneFilter) filter(client kubernetes.Interface, targets []Target) []Target {
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if z.now().Sub(z.refreshed) >= z.cfg.RefreshInterval {
+		if err := z.refresh(client); err != nil {
+			klog.WithError(err).Warn("couldn't list the zones of the nodes, keeping the previous ones")
+		}
+	}
+	if z.zone == "" || len(z.replicaZones) == 0 {
+		return targets
+	}
+
+	filtered := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		zone := z.nodeZones[targetNode(t)]
+		if !z.replicaZones[zone] {
+			zone = z.fallbackZone
+		}
+		if zone == z.zone {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// refresh lists the zones of the nodes and the ones with replicas.
+func (z *zoneFilter) refresh(client kubernetes.Interface) error {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	replicas, err := client.CoreV1().Pods("").List(metav1.ListOptions{LabelSelector: z.cfg.ReplicaSelector})
+	if err != nil {
+		return err
+	}
+
+	nodeZones := make(map[string]string, len(nodes.Items))
+	for i := range nodes.Items {
+		if zone := nodeZone(&nodes.Items[i]); zone != "" {
+			nodeZones[nodes.Items[i].Name] = zone
+		}
+	}
+	zone := z.cfg.Zone
+	if zone == "" {
+		zone = nodeZones[z.nodeName]
+	}
+	if zone == "" {
+		return fmt.Errorf("zone of node %q unknown", z.nodeName)
+	}
+
+	replicaZones := map[string]bool{zone: true}
+	fallbackZone := zone
+	for _, p := range replicas.Items {
+		if p.Status.Phase != apiv1.PodRunning {
+			continue
+		}
+		if replicaZone, ok := nodeZones[p.Spec.NodeName]; ok {
+			replicaZones[replicaZone] = true
+			if replicaZone < fallbackZone {
+				fallbackZone = replicaZone
+			}
+		}
+	}
+
+	z.zone = zone
+	z.nodeZones = nodeZones
+	z.replicaZones = replicaZones
+	z.fallbackZone = fallbackZone
+	z.refreshed = z.now()
+	return nil
+}
+
+// nodeZone returns the zone the node is labeled with.
+func nodeZone(n *apiv1.Node) string {
+	for _, label := range zoneLabels {
+		if zone := n.Labels[label]; zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
+
+// targetNode returns the node of the pod and node targets.
+func targetNode(t Target) string {
+	switch t.Object.Kind {
+	case "pod":
+		node, _ := t.Object.Labels["nodeName"].(string)
+		return node
+	case "node":
+		return t.Object.Name
+	}
+	return ""
+}
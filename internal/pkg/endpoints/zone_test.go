@@ -0,0 +1,84 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+func TestZoneConfigValidate(t *testing.T) {
+	cfg := ZoneConfig{Enabled: true}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, defaultZoneRefreshInterval, cfg.RefreshInterval)
+	assert.Equal(t, defaultReplicaSelector, cfg.ReplicaSelector)
+	assert.Error(t, (&ZoneConfig{Enabled: true, RefreshInterval: -time.Second}).Validate())
+
+	retriever := newFakeKubernetesTargetRetriever(fake.NewSimpleClientset())
+	assert.Error(t, WithZoneAwareness(ZoneConfig{Enabled: true}, "")(retriever))
+	require.NoError(t, WithZoneAwareness(ZoneConfig{}, "")(retriever))
+	assert.Nil(t, retriever.zones)
+}
+
+func TestZoneAwareness(t *testing.T) {
+	node := func(name, zone string) runtime.Object {
+		return &apiv1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"topology.kubernetes.io/zone": zone},
+		}}
+	}
+	replica := func(name, node string) runtime.Object {
+		return &apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "newrelic", Labels: map[string]string{"app": "nri-prometheus"}},
+			Spec:       apiv1.PodSpec{NodeName: node},
+			Status:     apiv1.PodStatus{Phase: apiv1.PodRunning},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		node("node-a", "zone-a"),
+		node("node-b", "zone-b"),
+		node("node-c", "zone-c"),
+		replica("nri-prometheus-a", "node-a"),
+		replica("nri-prometheus-b", "node-b"),
+	)
+	pod := func(name, node string) Target {
+		return Target{Name: name, Object: Object{Name: name, Kind: "pod", Labels: labels.Set{"nodeName": node}}}
+	}
+	targets := []Target{
+		pod("pod-a", "node-a"),
+		pod("pod-b", "node-b"),
+		pod("pod-c", "node-c"),
+		{Name: "node-b", Object: Object{Name: "node-b", Kind: "node"}},
+		{Name: "service", Object: Object{Name: "service", Kind: "service"}},
+	}
+
+	names := func(nodeName string) []string {
+		retriever := newFakeKubernetesTargetRetriever(client)
+		require.NoError(t, WithZoneAwareness(ZoneConfig{Enabled: true}, nodeName)(retriever))
+		for _, target := range targets {
+			retriever.targets.Store(target.Name, []Target{target})
+		}
+		found, err := retriever.GetTargets()
+		require.NoError(t, err)
+		var names []string
+		for _, target := range found {
+			names = append(names, target.Name)
+		}
+		return names
+	}
+
+	// zone-c has no replicas, its targets are scraped by the ones of zone-a,
+	// the first zone with replicas.
+	assert.ElementsMatch(t, []string{"pod-a", "pod-c", "service"}, names("node-a"))
+	assert.ElementsMatch(t, []string{"pod-b", "node-b"}, names("node-b"))
+	assert.Len(t, names("unknown-node"), len(targets), "all the targets are scraped if the zone is unknown")
+}
@@ -0,0 +1,12 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build fips && goexperiment.boringcrypto
+// +build fips,goexperiment.boringcrypto
+
+package fips
+
+// With the BoringCrypto toolchain, GOEXPERIMENT=boringcrypto, the crypto
+// operations use its FIPS validated module, and crypto/tls is restricted to
+// the approved settings in all the connections.
+import _ "crypto/tls/fipsonly"
@@ -0,0 +1,9 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !fips
+// +build !fips
+
+package fips
+
+const buildEnabled = false
@@ -0,0 +1,10 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build fips
+// +build fips
+
+package fips
+
+// buildEnabled enables the mode in the binaries built with the fips tag.
+const buildEnabled = true
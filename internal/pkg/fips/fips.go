@@ -0,0 +1,115 @@
+// Package fips restricts the TLS connections of the integration to the
+// FIPS 140-2 approved protocol versions, cipher suites and curves.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package fips
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+)
+
+// CipherSuites are the approved cipher suites, the TLS 1.2 AES-GCM ones.
+var CipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// curves are the approved elliptic curves.
+var curves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// TLS 1.3 is disabled because the Go cipher suites of TLS 1.3 can't be
+// configured, and they include ChaCha20-Poly1305.
+const (
+	minVersion = tls.VersionTLS12
+	maxVersion = tls.VersionTLS12
+)
+
+var enabled int32
+
+// Enable restricts the TLS configurations returned by Apply from now on.
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// Enabled returns true if the binary was built with the fips tag, or if the
+// mode was enabled at runtime.
+func Enabled() bool {
+	return buildEnabled || atomic.LoadInt32(&enabled) == 1
+}
+
+// Apply returns a copy of the TLS configuration, a new one if nil, restricted
+// to the approved versions, cipher suites and curves when the mode is
+// enabled. Otherwise it returns the same configuration.
+func Apply(cfg *tls.Config) *tls.Config {
+	if !Enabled() {
+		return cfg
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.MinVersion < minVersion {
+		cfg.MinVersion = minVersion
+	}
+	cfg.MaxVersion = maxVersion
+	cfg.CipherSuites = approved(cfg.CipherSuites)
+	cfg.CurvePreferences = curves
+	return cfg
+}
+
+// approved returns the approved cipher suites of the given ones, or all of
+// them if none is.
+func approved(suites []uint16) []uint16 {
+	var filtered []uint16
+	for _, id := range suites {
+		if isApproved(id) {
+			filtered = append(filtered, id)
+		}
+	}
+	if len(filtered) == 0 {
+		return CipherSuites
+	}
+	return filtered
+}
+
+func isApproved(id uint16) bool {
+	for _, approved := range CipherSuites {
+		if id == approved {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error if the TLS version or any of the cipher suites
+// aren't approved. Zero values are the defaults, which are.
+func Check(version uint16, suites []uint16) error {
+	if version != 0 && version != minVersion {
+		return fmt.Errorf("TLS version %s is not FIPS approved, only 1.2 is", versionName(version))
+	}
+	for _, id := range suites {
+		if !isApproved(id) {
+			return fmt.Errorf("cipher suite 0x%04x is not FIPS approved", id)
+		}
+	}
+	return nil
+}
+
+func versionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package fips
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	cfg := &tls.Config{
+		ServerName: "target",
+		MinVersion: tls.VersionTLS10,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	}
+	if !buildEnabled {
+		assert.Same(t, cfg, Apply(cfg), "the configuration isn't changed until enabled")
+	}
+
+	Enable()
+	require.True(t, Enabled())
+	restricted := Apply(cfg)
+	assert.Equal(t, "target", restricted.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), restricted.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), restricted.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, restricted.CipherSuites)
+	assert.Equal(t, uint16(tls.VersionTLS10), cfg.MinVersion, "the original configuration isn't modified")
+
+	assert.Equal(t, CipherSuites, Apply(nil).CipherSuites)
+}
+
+func TestCheck(t *testing.T) {
+	assert.NoError(t, Check(0, nil))
+	assert.NoError(t, Check(tls.VersionTLS12, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}))
+	assert.Error(t, Check(tls.VersionTLS11, nil))
+	assert.Error(t, Check(tls.VersionTLS13, nil))
+	assert.Error(t, Check(0, []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}))
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package labels
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// separator can't be part of valid UTF-8 names or values, so the fingerprints
+// of different label sets don't collide by concatenation.
+var separator = []byte{0xff}
+
+// Fingerprint returns a hash identifying the series with the given name and
+// labels, regardless of the order of the labels. The values that aren't
+// strings are hashed by their format, the same for the numbers of any type
+// with the same value, so the series keep their fingerprints when their
+// labels are decoded from JSON, where all the numbers are float64.
+func Fingerprint(name string, ls Set) uint64 {
+	names := make([]string, 0, len(ls))
+	for n := range ls {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	h := xxhash.New()
+	_, _ = h.WriteString(name)
+	for _, n := range names {
+		_, _ = h.Write(separator)
+		_, _ = h.WriteString(n)
+		_, _ = h.Write(separator)
+		if s, ok := ls[n].(string); ok {
+			_, _ = h.WriteString(s)
+		} else {
+			_, _ = h.WriteString(formatValue(ls[n]))
+		}
+	}
+	return h.Sum64()
+}
+
+// formatValue returns the format of a value that isn't a string. The numbers
+// are formatted as the float64 they are decoded to from JSON, without
+// exponent, so the integers are formatted as usual.
+func formatValue(v interface{}) string {
+	var f float64
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case float32:
+		// JSON encodes them with the digits of their own precision.
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(float64(n), 'g', -1, 32), 64)
+	case int:
+		f = float64(n)
+	case int8:
+		f = float64(n)
+	case int16:
+		f = float64(n)
+	case int32:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case uint:
+		f = float64(n)
+	case uint8:
+		f = float64(n)
+	case uint16:
+		f = float64(n)
+	case uint32:
+		f = float64(n)
+	case uint64:
+		f = float64(n)
+	case json.Number:
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return n.String()
+		}
+	default:
+		return fmt.Sprint(v)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	fp := Fingerprint("up", Set{"job": "node", "instance": "a:9100"})
+	assert.Equal(t, fp, Fingerprint("up", Set{"instance": "a:9100", "job": "node"}))
+	assert.Equal(t, Fingerprint("up", nil), Fingerprint("up", Set{}))
+
+	assert.NotEqual(t, fp, Fingerprint("down", Set{"job": "node", "instance": "a:9100"}))
+	assert.NotEqual(t, fp, Fingerprint("up", Set{"job": "node", "instance": "b:9100"}))
+	assert.NotEqual(t, fp, Fingerprint("up", Set{"job": "node"}))
+	// The names and values are not concatenated.
+	assert.NotEqual(t, Fingerprint("up", Set{"a": "bc"}), Fingerprint("up", Set{"ab": "c"}))
+	assert.NotEqual(t, Fingerprint("up", Set{"a": "b"}), Fingerprint("upa", Set{"": "b"}))
+	// The values that aren't strings are hashed as formatted.
+	assert.Equal(t, Fingerprint("up", Set{"port": "9100"}), Fingerprint("up", Set{"port": 9100}))
+	// The numbers are hashed by value, as they are decoded from JSON.
+	assert.Equal(t, Fingerprint("up", Set{"n": uint64(1e19)}), Fingerprint("up", Set{"n": 1e19}))
+	assert.Equal(t, Fingerprint("up", Set{"n": float32(0.1)}), Fingerprint("up", Set{"n": 0.1}))
+	assert.Equal(t, Fingerprint("up", Set{"n": uint8(3)}), Fingerprint("up", Set{"n": 3.0}))
+}
@@ -40,6 +40,22 @@ func DifferenceEqualValues(a, b Set) (Set, bool) {
 // If criteria is empty, returns src
 // The function ignores the values in criteria
 func Join(src, dst, criteria Set) (Set, bool) {
+	return JoinWith(src, dst, criteria, JoinOptions{})
+}
+
+// JoinOptions configures how JoinWith compares the labels in criteria.
+type JoinOptions struct {
+	// Names maps the names of the labels in criteria to the names they have
+	// in dst, when they are different.
+	Names map[string]string
+	// Normalizers transform, in order, the values of both label sets before
+	// they are compared.
+	Normalizers []Normalizer
+}
+
+// JoinWith is Join, with the label names and values in criteria compared as
+// configured by the options.
+func JoinWith(src, dst, criteria Set, opts JoinOptions) (Set, bool) {
 	ret := Set{}
 	for k, v := range src {
 		ret[k] = v
@@ -49,11 +65,15 @@ func Join(src, dst, criteria Set) (Set, bool) {
 		if !ok {
 			return nil, false
 		}
-		vd, ok := dst[name]
+		dstName := name
+		if n, ok := opts.Names[name]; ok {
+			dstName = n
+		}
+		vd, ok := dst[dstName]
 		if !ok {
 			return nil, false
 		}
-		if vs != vd {
+		if normalize(vs, opts.Normalizers) != normalize(vd, opts.Normalizers) {
 			return nil, false
 		}
 		delete(ret, name)
@@ -69,6 +89,14 @@ func Join(src, dst, criteria Set) (Set, bool) {
 // - If info1.Name == info2.Name AND DifferenceEqualValues(info1, b) == x, true and DifferenceEqualValues(info1, b) == y, true:
 //      - no metrics neither from info1.Name nor info2.Name are added to the result
 func ToAdd(infos []InfoSource, dst Set) Set {
+	return ToAddFormatted(infos, dst, func(label, info string) string {
+		return label + "." + info
+	})
+}
+
+// ToAddFormatted is ToAdd, with the names of the labels to add given by the
+// format function from the label name and the info name.
+func ToAddFormatted(infos []InfoSource, dst Set, format func(label, info string) string) Set {
 	// Time complexity of this implementation (assuming no hash collisions): O(IxL), where:
 	// - I is the number of _info fields
 	// - L is the average number of labels that should be added, from each info field
@@ -112,7 +140,7 @@ iterateInfos:
 	flatLabels := Set{}
 	for infoName, infoLabels := range labels {
 		for k, v := range infoLabels {
-			flatLabels[k+"."+infoName] = v
+			flatLabels[format(k, infoName)] = v
 		}
 	}
 	return flatLabels
@@ -254,3 +254,35 @@ func TestAccumulate(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinWith(t *testing.T) {
+	src := Set{"internal_ip": "10.0.0.1", "os": "linux"}
+	dst := Set{"instance": "10.0.0.1:9100"}
+	criteria := Set{"internal_ip": struct{}{}}
+
+	_, ok := JoinWith(src, dst, criteria, JoinOptions{})
+	assert.False(t, ok)
+	_, ok = JoinWith(src, dst, criteria, JoinOptions{Names: map[string]string{"internal_ip": "instance"}})
+	assert.False(t, ok)
+	toAdd, ok := JoinWith(src, dst, criteria, JoinOptions{
+		Names:       map[string]string{"internal_ip": "instance"},
+		Normalizers: []Normalizer{Normalizers["trim_port"]},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, Set{"os": "linux"}, toAdd)
+
+	toAdd, ok = JoinWith(Set{"host": "Web-1", "zone": "a"}, Set{"host": "https://web-1:443"}, Set{"host": struct{}{}}, JoinOptions{
+		Normalizers: []Normalizer{Normalizers["strip_scheme"], Normalizers["trim_port"], Normalizers["lowercase"]},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, Set{"zone": "a"}, toAdd)
+}
+
+func TestNormalizers(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", Normalizers["trim_port"]("10.0.0.1:9100"))
+	assert.Equal(t, "::1", Normalizers["trim_port"]("[::1]:9100"))
+	assert.Equal(t, "node-1", Normalizers["trim_port"]("node-1"))
+	assert.Equal(t, "host:8443/metrics", Normalizers["strip_scheme"]("https://host:8443/metrics"))
+	assert.Equal(t, "host", Normalizers["strip_scheme"]("host"))
+	assert.Equal(t, "web-1", Normalizers["lowercase"]("Web-1"))
+}
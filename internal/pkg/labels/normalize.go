@@ -0,0 +1,48 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package labels
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Normalizer transforms a label value before it's compared with another.
+type Normalizer func(string) string
+
+// Normalizers are the Normalizers available by name.
+var Normalizers = map[string]Normalizer{
+	// lowercase makes the comparison case-insensitive.
+	"lowercase": strings.ToLower,
+	// trim_port removes the port of host:port values, e.g. an instance.
+	"trim_port": trimPort,
+	// strip_scheme removes the scheme of URLs, e.g. https://.
+	"strip_scheme": stripScheme,
+}
+
+func trimPort(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return value
+}
+
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+func stripScheme(value string) string {
+	return urlScheme.ReplaceAllString(value, "")
+}
+
+// normalize returns the value transformed by the normalizers, if it's a
+// string.
+func normalize(value interface{}, normalizers []Normalizer) interface{} {
+	s, ok := value.(string)
+	if !ok || len(normalizers) == 0 {
+		return value
+	}
+	for _, n := range normalizers {
+		s = n(s)
+	}
+	return s
+}
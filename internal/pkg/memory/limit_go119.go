@@ -0,0 +1,22 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.19
+// +build go1.19
+
+package memory
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// setMemoryLimit sets the soft memory limit of the runtime, as GOMEMLIMIT
+// does. A GOMEMLIMIT environment variable takes precedence.
+func setMemoryLimit(limit int64) bool {
+	if _, ok := os.LookupEnv("GOMEMLIMIT"); ok {
+		return true
+	}
+	debug.SetMemoryLimit(limit)
+	return true
+}
@@ -0,0 +1,13 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !go1.19
+// +build !go1.19
+
+package memory
+
+// setMemoryLimit is a no-op, as soft memory limits are only supported by the
+// runtime since Go 1.19.
+func setMemoryLimit(_ int64) bool {
+	return false
+}
@@ -0,0 +1,122 @@
+// Package memory ...
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package memory
+
+import (
+	"io/ioutil"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var mlog = logrus.WithField("component", "memory")
+
+// cgroup files holding the memory limit of the container, for cgroups v2 and v1.
+var cgroupLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// cgroupUnlimited is the minimum value reported by cgroups v1 for containers
+// without memory limit.
+const cgroupUnlimited = 1 << 62
+
+// Config holds the runtime memory settings.
+type Config struct {
+	// GCPercent sets the garbage collection target percentage, as GOGC does.
+	// Zero keeps the runtime default.
+	GCPercent int
+	// Limit is the memory limit of the process in bytes. Zero uses the
+	// limit of the container cgroup, if any.
+	Limit int64
+	// Watermark is the fraction of Limit above which the process is
+	// considered to be under memory pressure. Zero disables it.
+	Watermark float64
+}
+
+// Guard reports whether the process memory is close to its limit.
+type Guard struct {
+	threshold uint64
+	inUse     func() uint64
+}
+
+// Apply configures the runtime with the given settings and returns a Guard
+// for the resolved memory limit. The returned Guard is nil when there is no
+// limit or watermark.
+func Apply(cfg Config) *Guard {
+	if cfg.GCPercent != 0 {
+		previous := debug.SetGCPercent(cfg.GCPercent)
+		mlog.Debugf("GC percent set to %d, previous value: %d", cfg.GCPercent, previous)
+	}
+
+	limit := cfg.Limit
+	if limit == 0 {
+		var ok bool
+		if limit, ok = CgroupLimit(); ok {
+			mlog.Debugf("using the container memory limit of %d bytes", limit)
+		}
+	}
+	if limit <= 0 {
+		return nil
+	}
+	if !setMemoryLimit(limit) {
+		mlog.Debug("the runtime doesn't support soft memory limits, only the watermark is enforced")
+	}
+
+	if cfg.Watermark <= 0 {
+		return nil
+	}
+	return NewGuard(uint64(float64(limit) * cfg.Watermark))
+}
+
+// NewGuard returns a Guard that reports memory pressure when the memory in use
+// is above the given threshold, in bytes.
+func NewGuard(threshold uint64) *Guard {
+	return &Guard{threshold: threshold, inUse: InUse}
+}
+
+// UnderPressure returns true when the memory in use is above the watermark.
+// A nil Guard is never under pressure.
+func (g *Guard) UnderPressure() bool {
+	if g == nil {
+		return false
+	}
+	return g.inUse() > g.threshold
+}
+
+// InUse returns the memory obtained from the OS by the runtime that hasn't
+// been released back.
+func InUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys - stats.HeapReleased
+}
+
+// CgroupLimit returns the memory limit of the container, read from the
+// cgroup filesystem. It returns false if there is no limit.
+func CgroupLimit() (int64, bool) {
+	for _, f := range cgroupLimitFiles {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		return parseCgroupLimit(string(content))
+	}
+	return 0, false
+}
+
+func parseCgroupLimit(content string) (int64, bool) {
+	value := strings.TrimSpace(content)
+	if value == "max" {
+		return 0, false
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroupLimit(t *testing.T) {
+	cases := []struct {
+		content string
+		limit   int64
+		ok      bool
+	}{
+		{content: "536870912\n", limit: 536870912, ok: true},
+		{content: "max\n", ok: false},
+		{content: "9223372036854771712\n", ok: false},
+		{content: "", ok: false},
+		{content: "garbage", ok: false},
+	}
+	for _, tc := range cases {
+		limit, ok := parseCgroupLimit(tc.content)
+		assert.Equal(t, tc.ok, ok, tc.content)
+		assert.Equal(t, tc.limit, limit, tc.content)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	var inUse uint64
+	g := NewGuard(100)
+	g.inUse = func() uint64 { return inUse }
+
+	inUse = 90
+	assert.False(t, g.UnderPressure())
+	inUse = 101
+	assert.True(t, g.UnderPressure())
+
+	var nilGuard *Guard
+	assert.False(t, nilGuard.UnderPressure())
+}
+
+func TestApplyWithoutWatermark(t *testing.T) {
+	assert.Nil(t, Apply(Config{Limit: 1 << 40}))
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Format is the format of a scraped payload.
+type Format string
+
+// The formats the payloads can be parsed from. The empty format detects it
+// from the Content-Type of the response.
+const (
+	FormatAuto        Format = ""
+	FormatPrometheus  Format = "prometheus"
+	FormatOpenMetrics Format = "openmetrics"
+	// FormatJSON is the format of prom2json: an array of metric families,
+	// with their samples.
+	FormatJSON Format = "json"
+	// FormatInflux is the InfluxDB line protocol. Each field is a gauge
+	// named after the measurement and the field, with the tags as labels.
+	FormatInflux Format = "influx"
+)
+
+// TextContentType is the Content-Type of the payloads in the Prometheus text
+// format.
+const TextContentType = "text/plain; version=0.0.4"
+
+// Validate returns an error if the format is not known.
+func (f Format) Validate() error {
+	switch f {
+	case FormatAuto, FormatPrometheus, FormatOpenMetrics, FormatJSON, FormatInflux:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, it must be prometheus, openmetrics, json or influx", string(f))
+}
+
+// DetectFormat returns the format of a payload with the given Content-Type.
+// The payloads whose Content-Type is unknown or missing are taken as the
+// Prometheus text format.
+func DetectFormat(contentType string) Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatPrometheus
+	}
+	switch mediaType {
+	case "application/openmetrics-text":
+		return FormatOpenMetrics
+	case "application/json":
+		return FormatJSON
+	}
+	return FormatPrometheus
+}
+
+// Transcode converts a payload in the given format to the Prometheus text
+// format, which Decode parses. The payloads in the Prometheus text format are
+// returned as they are.
+func Transcode(payload []byte, f Format) ([]byte, error) {
+	var mfs MetricFamiliesByName
+	var err error
+	switch f {
+	case FormatAuto, FormatPrometheus:
+		return payload, nil
+	case FormatOpenMetrics:
+		return rewriteOpenMetrics(payload), nil
+	case FormatJSON:
+		mfs, err = decodeJSON(payload)
+	case FormatInflux:
+		mfs, err = decodeInflux(payload)
+	default:
+		return nil, f.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding the %s payload: %w", f, err)
+	}
+	return encodeText(mfs)
+}
+
+// TranscodeResponse replaces the body of the response, in the given format,
+// by the payload converted to the Prometheus text format, and sets its
+// Content-Type accordingly. The body is closed if it's replaced.
+func TranscodeResponse(resp *http.Response, f Format) error {
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	resp.Header.Set("Content-Type", TextContentType)
+	if f == FormatAuto || f == FormatPrometheus {
+		return nil
+	}
+	payload, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	text, err := Transcode(payload, f)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(text))
+	resp.ContentLength = int64(len(text))
+	return nil
+}
+
+// encodeText encodes the metric families in the Prometheus text format,
+// sorted by name.
+func encodeText(mfs MetricFamiliesByName) ([]byte, error) {
+	names := make([]string, 0, len(mfs))
+	for name := range mfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		mf := mfs[name]
+		if _, err := expfmt.MetricFamilyToText(&b, &mf); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// addMetric adds the metric to the family with the given name and type,
+// creating it if needed.
+func addMetric(mfs MetricFamiliesByName, name, help string, typ dto.MetricType, m *dto.Metric) {
+	mf, ok := mfs[name]
+	if !ok {
+		mf = dto.MetricFamily{Name: &name, Type: &typ}
+		if help != "" {
+			mf.Help = &help
+		}
+	}
+	mf.Metric = append(mf.Metric, m)
+	mfs[name] = mf
+}
+
+// labelPairs returns the labels sorted by name.
+func labelPairs(ls map[string]string) []*dto.LabelPair {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]*dto.LabelPair, 0, len(names))
+	for _, name := range names {
+		name, value := name, ls[name]
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return pairs
+}
+
+// sanitizeName replaces the characters that are not valid in a metric name,
+// or in a label name if colons are not allowed, by underscores.
+func sanitizeName(name string, colons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(colons && c == ':') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package prometheus_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
+)
+
+func transcode(t *testing.T, payload string, f prometheus.Format) prometheus.MetricFamiliesByName {
+	t.Helper()
+	text, err := prometheus.Transcode([]byte(payload), f)
+	require.NoError(t, err)
+	mfs, err := prometheus.Decode(bytes.NewReader(text))
+	require.NoError(t, err)
+	return mfs
+}
+
+func TestTranscode_JSON(t *testing.T) {
+	mfs := transcode(t, `[
+		{"name": "http_requests_total", "help": "Requests.", "type": "COUNTER", "metrics": [
+			{"labels": {"code": "200"}, "value": "10", "timestamp_ms": "1600000000000"},
+			{"labels": {"code": "500"}, "value": 2}
+		]},
+		{"name": "latency_seconds", "type": "HISTOGRAM", "metrics": [
+			{"buckets": {"0.5": "3", "0.1": "1"}, "count": "4", "sum": "1.2"}
+		]}
+	]`, prometheus.FormatJSON)
+
+	requests := mfs["http_requests_total"]
+	assert.Equal(t, dto.MetricType_COUNTER, requests.GetType())
+	assert.Equal(t, "Requests.", requests.GetHelp())
+	require.Len(t, requests.Metric, 2)
+	assert.Equal(t, float64(10), requests.Metric[0].GetCounter().GetValue())
+	assert.Equal(t, int64(1600000000000), requests.Metric[0].GetTimestampMs())
+	assert.Equal(t, float64(2), requests.Metric[1].GetCounter().GetValue())
+	assert.Equal(t, "500", requests.Metric[1].Label[0].GetValue())
+
+	latency := mfs["latency_seconds"]
+	require.Len(t, latency.Metric, 1)
+	hist := latency.Metric[0].GetHistogram()
+	assert.Equal(t, uint64(4), hist.GetSampleCount())
+	require.Len(t, hist.Bucket, 3)
+	assert.Equal(t, 0.1, hist.Bucket[0].GetUpperBound())
+	assert.Equal(t, uint64(1), hist.Bucket[0].GetCumulativeCount())
+	assert.Equal(t, uint64(3), hist.Bucket[1].GetCumulativeCount())
+
+	_, err := prometheus.Transcode([]byte(`{"name": "up"}`), prometheus.FormatJSON)
+	assert.Error(t, err)
+}
+
+func TestTranscode_Influx(t *testing.T) {
+	mfs := transcode(t, `# a comment
+cpu,host=a,core\ id=0 value=0.5,usage_idle=90i,ok=true,state="running" 1600000000000000000
+disk\ io,host=a read=10u
+`, prometheus.FormatInflux)
+
+	require.Contains(t, mfs, "cpu")
+	cpu := mfs["cpu"].Metric[0]
+	assert.Equal(t, 0.5, cpu.GetUntyped().GetValue())
+	assert.Equal(t, int64(1600000000000), cpu.GetTimestampMs())
+	assert.Equal(t, "core_id", cpu.Label[0].GetName())
+	assert.Equal(t, float64(90), mfs["cpu_usage_idle"].Metric[0].GetUntyped().GetValue())
+	assert.Equal(t, float64(1), mfs["cpu_ok"].Metric[0].GetUntyped().GetValue())
+	assert.NotContains(t, mfs, "cpu_state")
+	assert.Equal(t, float64(10), mfs["disk_io_read"].Metric[0].GetUntyped().GetValue())
+
+	_, err := prometheus.Transcode([]byte("cpu\n"), prometheus.FormatInflux)
+	assert.Error(t, err)
+}
+
+func TestTranscode_OpenMetrics(t *testing.T) {
+	mfs := transcode(t, `# TYPE requests counter
+# HELP requests Requests.
+requests_total{code="200"} 10 1600000000.5 # {trace_id="abc"} 1 1600000000
+requests_created{code="200"} 1500000000
+# TYPE build info
+build_info{version="1.0"} 1
+# EOF
+`, prometheus.FormatOpenMetrics)
+
+	requests := mfs["requests_total"]
+	assert.Equal(t, dto.MetricType_COUNTER, requests.GetType())
+	assert.Equal(t, "Requests.", requests.GetHelp())
+	require.Len(t, requests.Metric, 1)
+	assert.Equal(t, float64(10), requests.Metric[0].GetCounter().GetValue())
+	assert.Equal(t, int64(1600000000500), requests.Metric[0].GetTimestampMs())
+	assert.NotContains(t, mfs, "requests_created")
+	build := mfs["build_info"]
+	assert.Equal(t, prometheus.MetricTypeInfo, build.GetType())
+}
+
+func TestGet_DetectsFormat(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "up", "type": "GAUGE", "metrics": [{"value": "1"}]}]`))
+	}))
+	defer ts.Close()
+
+	mfs, err := prometheus.Get(http.DefaultClient, ts.URL)
+	require.NoError(t, err)
+	require.Contains(t, mfs, "up")
+	up := mfs["up"]
+	assert.Equal(t, float64(1), up.Metric[0].GetGauge().GetValue())
+}
+
+func TestFormat_Validate(t *testing.T) {
+	assert.NoError(t, prometheus.FormatAuto.Validate())
+	assert.NoError(t, prometheus.FormatInflux.Validate())
+	assert.Error(t, prometheus.Format("xml").Validate())
+	assert.Equal(t, prometheus.FormatOpenMetrics, prometheus.DetectFormat("application/openmetrics-text; version=1.0.0"))
+	assert.Equal(t, prometheus.FormatPrometheus, prometheus.DetectFormat("text/plain; version=0.0.4"))
+	assert.Equal(t, prometheus.FormatPrometheus, prometheus.DetectFormat(""))
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// decodeInflux decodes the metric families of a payload in the InfluxDB line
+// protocol. Each numeric or boolean field of a line is a gauge named after
+// the measurement and the field, or after the measurement alone if the field
+// is named value. The string fields are skipped.
+func decodeInflux(payload []byte) (MetricFamiliesByName, error) {
+	mfs := MetricFamiliesByName{}
+	for i, line := range bytes.Split(payload, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if err := decodeInfluxLine(mfs, string(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return mfs, nil
+}
+
+func decodeInfluxLine(mfs MetricFamiliesByName, line string) error {
+	sections := splitInflux(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return fmt.Errorf("expected a measurement, fields and an optional timestamp")
+	}
+	series := splitInflux(sections[0], ',')
+	measurement := sanitizeName(unescapeInflux(series[0]), true)
+	if measurement == "" {
+		return fmt.Errorf("missing measurement")
+	}
+	tags := make(map[string]string, len(series)-1)
+	for _, tag := range series[1:] {
+		kv := splitInflux(tag, '=')
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[sanitizeName(unescapeInflux(kv[0]), false)] = unescapeInflux(kv[1])
+	}
+	var timestamp *int64
+	if len(sections) == 3 {
+		ns, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		ms := ns / 1e6
+		timestamp = &ms
+	}
+
+	for _, field := range splitInflux(sections[1], ',') {
+		kv := splitInflux(field, '=')
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid field %q", field)
+		}
+		value, ok, err := influxFieldValue(kv[1])
+		if err != nil {
+			return fmt.Errorf("field %s: %w", kv[0], err)
+		}
+		if !ok {
+			continue
+		}
+		name := measurement
+		if key := unescapeInflux(kv[0]); key != "value" {
+			name += "_" + sanitizeName(key, true)
+		}
+		m := &dto.Metric{Label: labelPairs(tags), Untyped: &dto.Untyped{Value: &value}, TimestampMs: timestamp}
+		addMetric(mfs, name, "", dto.MetricType_UNTYPED, m)
+	}
+	return nil
+}
+
+// influxFieldValue returns the value of a field, and false if it's a string.
+func influxFieldValue(s string) (float64, bool, error) {
+	if strings.HasPrefix(s, `"`) {
+		return 0, false, nil
+	}
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	// Integers have the i suffix, and unsigned integers the u one.
+	s = strings.TrimRight(s, "iu")
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value %q", s)
+	}
+	return v, true, nil
+}
+
+// splitInflux splits the line protocol element by the separator, except
+// where it's escaped by a backslash or within a quoted string.
+func splitInflux(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeInflux removes the backslashes escaping the commas, spaces and
+// equal signs of the measurements, tags and field keys.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=").Replace(s)
+}
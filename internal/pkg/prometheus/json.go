@@ -0,0 +1,147 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jsonFamily is a metric family in the prom2json format.
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+// jsonMetric is a sample of a family in the prom2json format. The quantiles
+// and buckets are set for the summaries and histograms.
+type jsonMetric struct {
+	Labels      map[string]string    `json:"labels"`
+	TimestampMs jsonNumber           `json:"timestamp_ms"`
+	Value       jsonNumber           `json:"value"`
+	Quantiles   map[string]jsonValue `json:"quantiles"`
+	Buckets     map[string]jsonValue `json:"buckets"`
+	Count       jsonNumber           `json:"count"`
+	Sum         jsonNumber           `json:"sum"`
+}
+
+// jsonValue is a number encoded as a JSON string, as prom2json does, or as a
+// JSON number.
+type jsonValue float64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %s", b)
+	}
+	*v = jsonValue(f)
+	return nil
+}
+
+// jsonNumber is an optional jsonValue.
+type jsonNumber struct {
+	set   bool
+	value float64
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *jsonNumber) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var v jsonValue
+	if err := v.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	n.set, n.value = true, float64(v)
+	return nil
+}
+
+var jsonTypes = map[string]dto.MetricType{
+	"":          dto.MetricType_UNTYPED,
+	"UNTYPED":   dto.MetricType_UNTYPED,
+	"COUNTER":   dto.MetricType_COUNTER,
+	"GAUGE":     dto.MetricType_GAUGE,
+	"SUMMARY":   dto.MetricType_SUMMARY,
+	"HISTOGRAM": dto.MetricType_HISTOGRAM,
+}
+
+// decodeJSON decodes the metric families of a payload in the prom2json
+// format.
+func decodeJSON(payload []byte) (MetricFamiliesByName, error) {
+	var families []jsonFamily
+	if err := json.Unmarshal(payload, &families); err != nil {
+		return nil, err
+	}
+	mfs := MetricFamiliesByName{}
+	for _, f := range families {
+		typ, ok := jsonTypes[strings.ToUpper(f.Type)]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q of %s", f.Type, f.Name)
+		}
+		if f.Name == "" {
+			return nil, fmt.Errorf("metric family without name")
+		}
+		for _, jm := range f.Metrics {
+			// The loop variable is reused, so its fields can't be referenced.
+			value, sum, count := jm.Value.value, jm.Sum.value, uint64(jm.Count.value)
+			m := &dto.Metric{Label: labelPairs(jm.Labels)}
+			if jm.TimestampMs.set {
+				ts := int64(jm.TimestampMs.value)
+				m.TimestampMs = &ts
+			}
+			switch typ {
+			case dto.MetricType_COUNTER:
+				m.Counter = &dto.Counter{Value: &value}
+			case dto.MetricType_GAUGE:
+				m.Gauge = &dto.Gauge{Value: &value}
+			case dto.MetricType_UNTYPED:
+				m.Untyped = &dto.Untyped{Value: &value}
+			case dto.MetricType_SUMMARY:
+				m.Summary = &dto.Summary{SampleCount: &count, SampleSum: &sum}
+				for _, q := range sortedBounds(jm.Quantiles) {
+					q := q
+					m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: &q.bound, Value: &q.value})
+				}
+			case dto.MetricType_HISTOGRAM:
+				m.Histogram = &dto.Histogram{SampleCount: &count, SampleSum: &sum}
+				for _, b := range sortedBounds(jm.Buckets) {
+					b, cumulative := b, uint64(b.value)
+					m.Histogram.Bucket = append(m.Histogram.Bucket, &dto.Bucket{UpperBound: &b.bound, CumulativeCount: &cumulative})
+				}
+			}
+			addMetric(mfs, f.Name, f.Help, typ, m)
+		}
+	}
+	return mfs, nil
+}
+
+// bound is a quantile or the upper bound of a bucket, with its value.
+type bound struct {
+	bound, value float64
+}
+
+// sortedBounds returns the quantiles or buckets sorted by bound. The bounds
+// that are not numbers are skipped.
+func sortedBounds(values map[string]jsonValue) []bound {
+	bounds := make([]bound, 0, len(values))
+	for k, v := range values {
+		b, err := strconv.ParseFloat(k, 64)
+		if err != nil || math.IsNaN(b) {
+			continue
+		}
+		bounds = append(bounds, bound{bound: b, value: float64(v)})
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].bound < bounds[j].bound })
+	return bounds
+}
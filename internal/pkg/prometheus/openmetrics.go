@@ -0,0 +1,185 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The OpenMetrics types that the Prometheus data model doesn't have. The
+// families of these types are decoded as gauges with this type instead.
+const (
+	// MetricTypeInfo is the type of the info families, which have a single
+	// sample named after the family with the _info suffix and value 1.
+	MetricTypeInfo dto.MetricType = 100
+	// MetricTypeStateset is the type of the stateset families, which have a
+	// sample per state, labeled with the state in a label named after the
+	// family, and value 1 for the states that are set.
+	MetricTypeStateset dto.MetricType = 101
+)
+
+const infoSuffix = "_info"
+
+// rewriteOpenMetricsTypes rewrites the TYPE lines of the info and stateset
+// families, which the text parser doesn't support, to gauges, and returns
+// the payload with the types of the rewritten families by name. The info
+// families are renamed with the _info suffix of their samples. The number of
+// lines isn't changed, so the parse errors are on the same line.
+func rewriteOpenMetricsTypes(payload []byte) ([]byte, map[string]dto.MetricType) {
+	if !bytes.Contains(payload, []byte(" info")) && !bytes.Contains(payload, []byte(" stateset")) {
+		return payload, nil
+	}
+
+	lines := bytes.SplitAfter(payload, []byte("\n"))
+	types := map[string]dto.MetricType{}
+	renames := map[string]string{}
+	for _, line := range lines {
+		name, typ, ok := typeLine(line)
+		if !ok {
+			continue
+		}
+		switch typ {
+		case "info":
+			renamed := name
+			if !strings.HasSuffix(name, infoSuffix) {
+				renamed = name + infoSuffix
+			}
+			renames[name] = renamed
+			types[renamed] = MetricTypeInfo
+		case "stateset":
+			types[name] = MetricTypeStateset
+		}
+	}
+	if len(types) == 0 {
+		return payload, nil
+	}
+
+	var rewritten bytes.Buffer
+	rewritten.Grow(len(payload))
+	for _, line := range lines {
+		if name, typ, ok := typeLine(line); ok && (typ == "info" || typ == "stateset") {
+			if renamed, ok := renames[name]; ok {
+				name = renamed
+			}
+			rewritten.WriteString("# TYPE " + name + " gauge" + lineEnd(line))
+			continue
+		}
+		if fields := strings.Fields(string(line)); len(fields) >= 3 && fields[0] == "#" && fields[1] == "HELP" {
+			if renamed, ok := renames[fields[2]]; ok && renamed != fields[2] {
+				rest := string(line[bytes.Index(line, []byte("HELP"))+len("HELP"):])
+				help := strings.TrimLeft(rest, " \t")[len(fields[2]):]
+				rewritten.WriteString("# HELP " + renamed + help)
+				continue
+			}
+		}
+		rewritten.Write(line)
+	}
+	return rewritten.Bytes(), types
+}
+
+// typeLine returns the family name and type of a TYPE line.
+func typeLine(line []byte) (name, typ string, ok bool) {
+	fields := strings.Fields(string(line))
+	if len(fields) != 4 || fields[0] != "#" || fields[1] != "TYPE" {
+		return "", "", false
+	}
+	return fields[2], fields[3], true
+}
+
+// lineEnd returns the line break the line ends with, if any.
+func lineEnd(line []byte) string {
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		return "\r\n"
+	}
+	if bytes.HasSuffix(line, []byte("\n")) {
+		return "\n"
+	}
+	return ""
+}
+
+// rewriteOpenMetrics rewrites the parts of an OpenMetrics payload that the
+// text parser doesn't support or reads differently: the counter families
+// are renamed with the _total suffix of their samples, the _created samples
+// are removed, the exemplars are removed and the timestamps are converted
+// from seconds to milliseconds. The number of lines isn't changed.
+func rewriteOpenMetrics(payload []byte) []byte {
+	lines := bytes.SplitAfter(payload, []byte("\n"))
+	types := map[string]string{}
+	var rewritten bytes.Buffer
+	rewritten.Grow(len(payload))
+	for _, line := range lines {
+		if name, typ, ok := typeLine(line); ok {
+			types[name] = typ
+			if typ == "counter" && !strings.HasSuffix(name, "_total") {
+				rewritten.WriteString("# TYPE " + name + "_total counter" + lineEnd(line))
+				continue
+			}
+			rewritten.Write(line)
+			continue
+		}
+		if fields := strings.Fields(string(line)); len(fields) >= 3 && fields[0] == "#" && fields[1] == "HELP" {
+			if types[fields[2]] == "counter" && !strings.HasSuffix(fields[2], "_total") {
+				rest := string(line[bytes.Index(line, []byte(fields[2]))+len(fields[2]):])
+				rewritten.WriteString("# HELP " + fields[2] + "_total" + rest)
+				continue
+			}
+		}
+		if len(bytes.TrimSpace(line)) == 0 || line[0] == '#' {
+			rewritten.Write(line)
+			continue
+		}
+		rewritten.WriteString(rewriteOpenMetricsSample(string(line), types))
+	}
+	return rewritten.Bytes()
+}
+
+// rewriteOpenMetricsSample returns the sample line without exemplar and with
+// the timestamp in milliseconds, or an empty line for the _created samples.
+func rewriteOpenMetricsSample(line string, types map[string]string) string {
+	end := lineEnd([]byte(line))
+	line = strings.TrimSuffix(line, end)
+	// The labels end at the first closing brace out of their values.
+	valuesAt := strings.IndexAny(line, " {")
+	if valuesAt < 0 {
+		return line + end
+	}
+	name := line[:valuesAt]
+	if strings.HasSuffix(name, "_created") {
+		switch types[strings.TrimSuffix(name, "_created")] {
+		case "counter", "summary", "histogram":
+			return end
+		}
+	}
+	if line[valuesAt] == '{' {
+		quoted := false
+		for i := valuesAt + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				quoted = !quoted
+			case '}':
+				if !quoted {
+					valuesAt = i + 1
+					i = len(line)
+				}
+			}
+		}
+	}
+	values := line[valuesAt:]
+	if exemplar := strings.Index(values, " # "); exemplar >= 0 {
+		values = values[:exemplar]
+	}
+	fields := strings.Fields(values)
+	if len(fields) == 2 {
+		if seconds, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			fields[1] = strconv.FormatInt(int64(math.Round(seconds*1000)), 10)
+		}
+	}
+	return line[:valuesAt] + " " + strings.Join(fields, " ") + end
+}
@@ -4,8 +4,11 @@
 package prometheus
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 
 	prom "github.com/prometheus/client_golang/prometheus"
@@ -51,27 +54,65 @@ func ResetTargetSize() {
 
 // Get scrapes the given URL and decodes the retrieved payload.
 func Get(client HTTPDoer, url string) (MetricFamiliesByName, error) {
-	mfs := MetricFamiliesByName{}
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := request(client, url)
 	if err != nil {
-		return mfs, err
+		return MetricFamiliesByName{}, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	countedBody := &countReadCloser{innerReadCloser: resp.Body}
+	mfs, err := Decode(countedBody)
 	if err != nil {
-		return mfs, err
+		return nil, err
 	}
 
+	recordPayloadSize(url, countedBody.count)
+	return mfs, nil
+}
+
+// GetPayload scrapes the given URL and copies the retrieved payload, without
+// decoding it, to the given writer. The payload can be decoded later with
+// Decode.
+func GetPayload(client HTTPDoer, url string, w io.Writer) error {
+	resp, err := request(client, url)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 300 {
-		return nil, fmt.Errorf("status code returned by the prometheus exporter indicates an error occurred: %d", resp.StatusCode)
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return err
 	}
 
-	countedBody := &countReadCloser{innerReadCloser: resp.Body}
-	d := expfmt.NewDecoder(countedBody, expfmt.FmtText)
+	recordPayloadSize(url, int(n))
+	return nil
+}
+
+// Decode decodes the metric families of a payload in the Prometheus text format.
+// The OpenMetrics info and stateset families are decoded as gauges with the
+// MetricTypeInfo and MetricTypeStateset types.
+func Decode(r io.Reader) (_ MetricFamiliesByName, err error) {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLines(payload); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrParserPanic, r)
+		}
+	}()
+	payload, types := rewriteOpenMetricsTypes(payload)
+
+	mfs := MetricFamiliesByName{}
+	d := expfmt.NewDecoder(bytes.NewReader(payload), expfmt.FmtText)
 	for {
 		var mf dto.MetricFamily
 		if err := d.Decode(&mf); err != nil {
@@ -80,11 +121,129 @@ func Get(client HTTPDoer, url string) (MetricFamiliesByName, error) {
 			}
 			return nil, err
 		}
+		if typ, ok := types[mf.GetName()]; ok {
+			mf.Type = &typ
+		}
 		mfs[mf.GetName()] = mf
 	}
+	return mfs, nil
+}
+
+// MaxLineLength is the maximum length of the lines of the payloads, which
+// bounds the length of the label values and the escaped strings.
+const MaxLineLength = 1 << 20
+
+// ErrParserPanic is returned when decoding a payload makes the parser panic.
+var ErrParserPanic = errors.New("the parser panicked")
+
+// checkLines returns a parse error for the first line of the payload with a
+// NUL byte or longer than MaxLineLength, which the parser accepts.
+func checkLines(payload []byte) error {
+	for line := 1; len(payload) > 0; line++ {
+		end := bytes.IndexByte(payload, '\n')
+		if end < 0 {
+			end = len(payload)
+		}
+		if end > MaxLineLength {
+			return expfmt.ParseError{Line: line, Msg: fmt.Sprintf("line longer than %d bytes", MaxLineLength)}
+		}
+		if bytes.IndexByte(payload[:end], 0) >= 0 {
+			return expfmt.ParseError{Line: line, Msg: "NUL byte"}
+		}
+		if end == len(payload) {
+			break
+		}
+		payload = payload[end+1:]
+	}
+	return nil
+}
 
-	bodySize := float64(countedBody.count)
+// GetLenient scrapes the given URL and decodes the retrieved payload with
+// DecodeLenient, returning the errors of the skipped lines.
+func GetLenient(client HTTPDoer, url string, maxErrors int) (MetricFamiliesByName, []error, error) {
+	resp, err := request(client, url)
+	if err != nil {
+		return MetricFamiliesByName{}, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	countedBody := &countReadCloser{innerReadCloser: resp.Body}
+	mfs, skipped, err := DecodeLenient(countedBody, maxErrors)
+	if err != nil {
+		return nil, skipped, err
+	}
+
+	recordPayloadSize(url, countedBody.count)
+	return mfs, skipped, nil
+}
+
+// DecodeLenient decodes the metric families of a payload in the Prometheus
+// text format like Decode, but skipping the malformed lines instead of
+// failing. It fails if there are more than maxErrors malformed lines. The
+// errors of the skipped lines are returned.
+func DecodeLenient(r io.Reader, maxErrors int) (MetricFamiliesByName, []error, error) {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := bytes.SplitAfter(payload, []byte("\n"))
+	// Numbers of the lines in the original payload, to report the errors
+	// after removing the skipped lines.
+	numbers := make([]int, len(lines))
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+
+	var skipped []error
+	for {
+		mfs, err := Decode(bytes.NewReader(bytes.Join(lines, nil)))
+		if err == nil {
+			return mfs, skipped, nil
+		}
+		perr, ok := err.(expfmt.ParseError)
+		if !ok || perr.Line < 1 || perr.Line > len(lines) {
+			return nil, skipped, err
+		}
+		i := perr.Line - 1
+		perr.Line = numbers[i]
+		skipped = append(skipped, perr)
+		if len(skipped) > maxErrors {
+			return nil, skipped, fmt.Errorf("more than %d malformed lines, the last one: %w", maxErrors, perr)
+		}
+		lines = append(lines[:i], lines[i+1:]...)
+		numbers = append(numbers[:i], numbers[i+1:]...)
+	}
+}
+
+func request(client HTTPDoer, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 300 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("status code returned by the prometheus exporter indicates an error occurred: %d", resp.StatusCode)
+	}
+	// The payloads in other formats are parsed as their Content-Type
+	// tells, converted to the text format.
+	if f := DetectFormat(resp.Header.Get("Content-Type")); f != FormatPrometheus {
+		if err := TranscodeResponse(resp, f); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func recordPayloadSize(url string, size int) {
+	bodySize := float64(size)
 	targetSize.With(prom.Labels{"target": url}).Set(bodySize)
 	totalScrapedPayload.Add(bodySize)
-	return mfs, nil
 }
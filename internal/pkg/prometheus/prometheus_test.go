@@ -5,9 +5,12 @@ package prometheus_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/newrelic/nri-prometheus/internal/pkg/prometheus"
 )
@@ -47,3 +50,76 @@ func TestGet(t *testing.T) {
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, expected, actual)
 }
+
+func TestDecodeLenient(t *testing.T) {
+	payload := `# TYPE up gauge
+up 1
+up{job="a" 1
+# TYPE requests_total counter
+requests_total 10
+requests_total{code="500"} abc
+`
+	mfs, skipped, err := prometheus.DecodeLenient(strings.NewReader(payload), 2)
+	require.NoError(t, err)
+	require.Len(t, skipped, 2)
+	assert.Contains(t, skipped[0].Error(), "line 3")
+	assert.Contains(t, skipped[1].Error(), "line 6")
+	assert.Len(t, mfs["up"].Metric, 1)
+	assert.Len(t, mfs["requests_total"].Metric, 1)
+
+	_, skipped, err = prometheus.DecodeLenient(strings.NewReader(payload), 1)
+	assert.Error(t, err)
+	assert.Len(t, skipped, 2)
+
+	_, err = prometheus.Decode(strings.NewReader(payload))
+	assert.Error(t, err)
+}
+
+func TestDecode_PathologicalLines(t *testing.T) {
+	nul := "# TYPE up gauge\nup{job=\"a\x00b\"} 1\nup{job=\"c\"} 1\n"
+	_, err := prometheus.Decode(strings.NewReader(nul))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+	assert.Contains(t, err.Error(), "NUL byte")
+
+	mfs, skipped, err := prometheus.DecodeLenient(strings.NewReader(nul), 1)
+	require.NoError(t, err)
+	assert.Len(t, skipped, 1)
+	assert.Len(t, mfs["up"].Metric, 1)
+
+	huge := "# TYPE up gauge\nup{job=\"" + strings.Repeat("\\\\", prometheus.MaxLineLength/2) + "\"} 1\n"
+	_, err = prometheus.Decode(strings.NewReader(huge))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+	assert.Contains(t, err.Error(), "line longer than")
+}
+
+func TestDecode_OpenMetricsTypes(t *testing.T) {
+	payload := `# HELP build Build information.
+# TYPE build info
+build_info{version="1.2.3",revision="abc"} 1
+# TYPE door stateset
+door{door="open"} 1
+door{door="closed"} 0
+# TYPE up gauge
+up 1
+# EOF
+`
+	mfs, err := prometheus.Decode(strings.NewReader(payload))
+	require.NoError(t, err)
+	require.Contains(t, mfs, "build_info")
+	info, door, up := mfs["build_info"], mfs["door"], mfs["up"]
+	assert.Equal(t, prometheus.MetricTypeInfo, info.GetType())
+	assert.Equal(t, "Build information.", info.GetHelp())
+	assert.Len(t, info.Metric, 1)
+	assert.Equal(t, prometheus.MetricTypeStateset, door.GetType())
+	assert.Len(t, door.Metric, 2)
+	assert.Equal(t, dto.MetricType_GAUGE, up.GetType())
+
+	mfs, skipped, err := prometheus.DecodeLenient(strings.NewReader(payload+"door{door=\"ajar\" 1\n"), 1)
+	require.NoError(t, err)
+	require.Len(t, skipped, 1)
+	assert.Contains(t, skipped[0].Error(), "line 10")
+	info = mfs["build_info"]
+	assert.Equal(t, prometheus.MetricTypeInfo, info.GetType())
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptSnappy = errors.New("corrupt snappy block")
+
+// Tags of the elements of a snappy block.
+const (
+	snappyLiteral = 0
+	snappyCopy1   = 1
+	snappyCopy2   = 2
+	snappyCopy4   = 3
+)
+
+// snappyDecode decodes a block in the snappy format, which Prometheus
+// compresses the records with.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, read := binary.Uvarint(src)
+	if read <= 0 || n > uint64(len(src))*255 {
+		return nil, errCorruptSnappy
+	}
+	src = src[read:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 0x03 {
+		case snappyLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				// The length is in the next 1 to 4 bytes.
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || len(src) < length {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case snappyCopy1:
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case snappyCopy2:
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case snappyCopy4:
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errCorruptSnappy
+		}
+		// The copies can overlap the bytes they add.
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
@@ -0,0 +1,408 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package wal reads the write-ahead log of Prometheus, as written by its
+// TSDB or in agent mode, while it's being written.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Layout of the segments: pages of 32KiB with records, split in fragments
+// when they don't fit in the rest of the page, each with a header of the
+// fragment type, its length and its CRC32.
+const (
+	pageSize         = 32 * 1024
+	recordHeaderSize = 7
+
+	fragmentPageTerm = 0
+	fragmentFull     = 1
+	fragmentFirst    = 2
+	fragmentMiddle   = 3
+	fragmentLast     = 4
+
+	fragmentTypeMask  = 0x07
+	fragmentSnappy    = 0x08
+	fragmentZstd      = 0x10
+	checkpointPrefix  = "checkpoint."
+	segmentNameDigits = 8
+)
+
+// Types of the records.
+const (
+	recordSeries  = 1
+	recordSamples = 2
+)
+
+// StaleNaN is the value Prometheus writes to mark a series as stale.
+const StaleNaN uint64 = 0x7ff0000000000002
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Label is a label of a series.
+type Label struct {
+	Name, Value string
+}
+
+// Series is the definition of a series, referenced by the samples.
+type Series struct {
+	Ref    uint64
+	Labels []Label
+}
+
+// Sample is a value of a series, at a timestamp in milliseconds.
+type Sample struct {
+	Ref uint64
+	T   int64
+	V   float64
+}
+
+// Handler receives the records read from the log.
+type Handler interface {
+	Series([]Series)
+	Samples([]Sample)
+}
+
+// Reader reads the log of a directory from where it was left, following its
+// segments as they are written.
+type Reader struct {
+	dir string
+	// segment is the index of the segment being read, -1 until the first
+	// one is found.
+	segment int
+	offset  int64
+	// pending are the fragments of a record whose last one isn't written
+	// yet.
+	pending    []byte
+	compressed bool
+}
+
+// NewReader returns a Reader of the log in the directory, which is the wal
+// directory of Prometheus or its data directory.
+func NewReader(dir string) *Reader {
+	if info, err := os.Stat(filepath.Join(dir, "wal")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "wal")
+	}
+	return &Reader{dir: dir, segment: -1}
+}
+
+// Dir returns the directory of the segments.
+func (r *Reader) Dir() string {
+	return r.dir
+}
+
+// Start reads the series of the last checkpoint, if any, and sets the reader
+// at its next segment.
+func (r *Reader) Start(h Handler) error {
+	checkpoint, index, err := lastCheckpoint(r.dir)
+	if err != nil || checkpoint == "" {
+		return err
+	}
+	segments, err := listSegments(checkpoint)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		cr := &Reader{dir: checkpoint, segment: s}
+		if err := cr.readSegment(seriesOnly{h}); err != nil {
+			return fmt.Errorf("reading checkpoint %s: %w", checkpoint, err)
+		}
+	}
+	r.segment, r.offset, r.pending = index+1, 0, nil
+	return nil
+}
+
+// seriesOnly passes only the series to the handler, since the samples of the
+// checkpoints were already sent.
+type seriesOnly struct {
+	h Handler
+}
+
+func (s seriesOnly) Series(series []Series) { s.h.Series(series) }
+func (s seriesOnly) Samples([]Sample)       {}
+
+// Read reads the records written since the previous call, up to the last
+// complete one. The segments are read in order, moving to the next one when
+// it's created.
+func (r *Reader) Read(h Handler) error {
+	segments, err := listSegments(r.dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	if r.segment < segments[0] {
+		// The segments were removed by a truncation, or it's the first
+		// read.
+		r.segment, r.offset, r.pending = segments[0], 0, nil
+	}
+	for {
+		if err := r.readSegment(h); err != nil {
+			return fmt.Errorf("reading segment %d: %w", r.segment, err)
+		}
+		next := -1
+		for _, s := range segments {
+			if s > r.segment {
+				next = s
+				break
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		// The writer moved to a new segment, so the current one is
+		// complete.
+		r.segment, r.offset, r.pending = next, 0, nil
+	}
+}
+
+// readSegment reads the complete records of the current segment after the
+// offset.
+func (r *Reader) readSegment(h Handler) error {
+	f, err := os.Open(segmentName(r.dir, r.segment))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(r.offset, 0); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	var pos int64
+	for {
+		off := r.offset + pos
+		if left := pageSize - off%pageSize; left < recordHeaderSize {
+			// The rest of the page is padding.
+			if pos+left > int64(len(data)) {
+				break
+			}
+			pos += left
+			continue
+		}
+		if pos+recordHeaderSize > int64(len(data)) {
+			break
+		}
+		header := data[pos : pos+recordHeaderSize]
+		typ := header[0] & fragmentTypeMask
+		if typ == fragmentPageTerm {
+			left := pageSize - off%pageSize
+			if pos+left > int64(len(data)) {
+				break
+			}
+			pos += left
+			continue
+		}
+		length := int64(binary.BigEndian.Uint16(header[1:3]))
+		if pos+recordHeaderSize+length > int64(len(data)) {
+			break
+		}
+		fragment := data[pos+recordHeaderSize : pos+recordHeaderSize+length]
+		if crc32.Checksum(fragment, castagnoli) != binary.BigEndian.Uint32(header[3:7]) {
+			return fmt.Errorf("corrupted record at offset %d", off)
+		}
+		pos += recordHeaderSize + length
+		if header[0]&fragmentZstd != 0 {
+			return fmt.Errorf("zstd compressed records are not supported")
+		}
+
+		switch typ {
+		case fragmentFull:
+			r.pending = append(r.pending[:0], fragment...)
+		case fragmentFirst:
+			r.pending = append(r.pending[:0], fragment...)
+			r.compressed = header[0]&fragmentSnappy != 0
+			continue
+		case fragmentMiddle:
+			r.pending = append(r.pending, fragment...)
+			continue
+		case fragmentLast:
+			r.pending = append(r.pending, fragment...)
+		default:
+			return fmt.Errorf("unknown fragment type %d at offset %d", typ, off)
+		}
+		compressed := r.compressed
+		if typ == fragmentFull {
+			compressed = header[0]&fragmentSnappy != 0
+		}
+		rec := r.pending
+		if compressed {
+			if rec, err = snappyDecode(rec); err != nil {
+				return fmt.Errorf("decompressing the record at offset %d: %w", off, err)
+			}
+		}
+		if err := decodeRecord(rec, h); err != nil {
+			return fmt.Errorf("decoding the record at offset %d: %w", off, err)
+		}
+		r.pending = r.pending[:0]
+	}
+	r.offset += pos
+	return nil
+}
+
+// decodeRecord decodes the series and samples records, ignoring the others.
+func decodeRecord(rec []byte, h Handler) error {
+	if len(rec) == 0 {
+		return nil
+	}
+	d := decoder{b: rec[1:]}
+	switch rec[0] {
+	case recordSeries:
+		var series []Series
+		for len(d.b) > 0 && d.err == nil {
+			s := Series{Ref: d.be64()}
+			n := d.uvarint()
+			for i := uint64(0); i < n && d.err == nil; i++ {
+				s.Labels = append(s.Labels, Label{Name: d.uvarintStr(), Value: d.uvarintStr()})
+			}
+			series = append(series, s)
+		}
+		if d.err != nil {
+			return d.err
+		}
+		h.Series(series)
+	case recordSamples:
+		if len(d.b) == 0 {
+			return nil
+		}
+		baseRef, baseT := d.be64(), int64(d.be64())
+		var samples []Sample
+		for len(d.b) > 0 && d.err == nil {
+			ref := int64(baseRef) + d.varint()
+			t := baseT + d.varint()
+			v := math.Float64frombits(d.be64())
+			samples = append(samples, Sample{Ref: uint64(ref), T: t, V: v})
+		}
+		if d.err != nil {
+			return d.err
+		}
+		h.Samples(samples)
+	}
+	return nil
+}
+
+var errShortRecord = errors.New("unexpected end of record")
+
+// decoder decodes the fields of a record, keeping the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) be64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 8 {
+		d.err = errShortRecord
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) uvarintStr() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.b)) < n {
+		d.err = errShortRecord
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// segmentName returns the file name of the segment with the given index.
+func segmentName(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("%0*d", segmentNameDigits, index))
+}
+
+// listSegments returns the indexes of the segments of the directory, sorted.
+func listSegments(dir string) ([]int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if index, err := strconv.Atoi(f.Name()); err == nil {
+			segments = append(segments, index)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// lastCheckpoint returns the directory of the last checkpoint and the index
+// of the last segment it includes, or an empty directory if there are no
+// checkpoints.
+func lastCheckpoint(dir string) (string, int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	last, index := "", -1
+	for _, f := range files {
+		if !f.IsDir() || !strings.HasPrefix(f.Name(), checkpointPrefix) {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(f.Name(), checkpointPrefix))
+		// Checkpoints being written have a .tmp suffix.
+		if err != nil || i <= index {
+			continue
+		}
+		last, index = filepath.Join(dir, f.Name()), i
+	}
+	return last, index, nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// segment encodes records as Prometheus writes them.
+type segment struct {
+	buf []byte
+}
+
+func (s *segment) record(rec []byte, flags byte) {
+	for first := true; ; first = false {
+		left := pageSize - len(s.buf)%pageSize
+		if left < recordHeaderSize {
+			s.buf = append(s.buf, make([]byte, left)...)
+			left = pageSize
+		}
+		n := len(rec)
+		if n > left-recordHeaderSize {
+			n = left - recordHeaderSize
+		}
+		last := n == len(rec)
+		typ := byte(fragmentMiddle)
+		switch {
+		case first && last:
+			typ = fragmentFull
+		case first:
+			typ = fragmentFirst
+		case last:
+			typ = fragmentLast
+		}
+		header := make([]byte, recordHeaderSize)
+		header[0] = typ | flags
+		binary.BigEndian.PutUint16(header[1:3], uint16(n))
+		binary.BigEndian.PutUint32(header[3:7], crc32.Checksum(rec[:n], castagnoli))
+		s.buf = append(append(s.buf, header...), rec[:n]...)
+		rec = rec[n:]
+		if last {
+			return
+		}
+	}
+}
+
+func seriesRecord(series ...Series) []byte {
+	b := []byte{recordSeries}
+	for _, s := range series {
+		b = appendBE64(b, s.Ref)
+		b = appendUvarint(b, uint64(len(s.Labels)))
+		for _, l := range s.Labels {
+			b = appendUvarint(b, uint64(len(l.Name)))
+			b = append(b, l.Name...)
+			b = appendUvarint(b, uint64(len(l.Value)))
+			b = append(b, l.Value...)
+		}
+	}
+	return b
+}
+
+func samplesRecord(samples ...Sample) []byte {
+	b := []byte{recordSamples}
+	b = appendBE64(b, samples[0].Ref)
+	b = appendBE64(b, uint64(samples[0].T))
+	for _, s := range samples {
+		b = appendVarint(b, int64(s.Ref)-int64(samples[0].Ref))
+		b = appendVarint(b, s.T-samples[0].T)
+		b = appendBE64(b, math.Float64bits(s.V))
+	}
+	return b
+}
+
+// snappyBlock encodes the bytes as a snappy block of a single literal.
+func snappyBlock(b []byte) []byte {
+	out := appendUvarint(nil, uint64(len(b)))
+	out = append(out, 61<<2)
+	out = append(out, byte(len(b)-1), byte((len(b)-1)>>8))
+	return append(out, b...)
+}
+
+func appendBE64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// recorder is a Handler keeping the records.
+type recorder struct {
+	series  []Series
+	samples []Sample
+}
+
+func (r *recorder) Series(s []Series)  { r.series = append(r.series, s...) }
+func (r *recorder) Samples(s []Sample) { r.samples = append(r.samples, s...) }
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	walDir := filepath.Join(dir, "wal")
+	require.NoError(t, os.Mkdir(walDir, 0755))
+
+	up := Series{Ref: 1, Labels: []Label{{"__name__", "up"}, {"instance", "node:9100"}}}
+	// A series spanning several pages, split in fragments.
+	big := Series{Ref: 2, Labels: []Label{{"__name__", "big"}, {"value", strings.Repeat("x", 2*pageSize)}}}
+	var seg segment
+	seg.record(seriesRecord(up), 0)
+	seg.record(seriesRecord(big), 0)
+	seg.record(samplesRecord(Sample{Ref: 1, T: 1000, V: 1}, Sample{Ref: 2, T: 999, V: 2.5}), 0)
+	require.NoError(t, ioutil.WriteFile(segmentName(walDir, 0), seg.buf, 0644))
+
+	r := NewReader(dir)
+	assert.Equal(t, walDir, r.Dir())
+	var rec recorder
+	require.NoError(t, r.Read(&rec))
+	assert.Equal(t, []Series{up, big}, rec.series)
+	assert.Equal(t, []Sample{{Ref: 1, T: 1000, V: 1}, {Ref: 2, T: 999, V: 2.5}}, rec.samples)
+
+	// The records written later are read from where it was left, even if
+	// they are compressed, or in a new segment.
+	rec = recorder{}
+	written := len(seg.buf)
+	seg.record(snappyBlock(samplesRecord(Sample{Ref: 1, T: 2000, V: 0})), fragmentSnappy)
+	f, err := os.OpenFile(segmentName(walDir, 0), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(seg.buf[written:])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	var next segment
+	next.record(samplesRecord(Sample{Ref: 1, T: 3000, V: 1}), 0)
+	require.NoError(t, ioutil.WriteFile(segmentName(walDir, 1), next.buf, 0644))
+
+	require.NoError(t, r.Read(&rec))
+	assert.Empty(t, rec.series)
+	assert.Equal(t, []Sample{{Ref: 1, T: 2000, V: 0}, {Ref: 1, T: 3000, V: 1}}, rec.samples)
+
+	rec = recorder{}
+	require.NoError(t, r.Read(&rec))
+	assert.Empty(t, rec.samples, "the records are read once")
+}
+
+func TestReader_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "checkpoint.00000003")
+	require.NoError(t, os.Mkdir(checkpoint, 0755))
+
+	up := Series{Ref: 1, Labels: []Label{{"__name__", "up"}}}
+	var seg segment
+	seg.record(seriesRecord(up), 0)
+	seg.record(samplesRecord(Sample{Ref: 1, T: 1000, V: 1}), 0)
+	require.NoError(t, ioutil.WriteFile(segmentName(checkpoint, 0), seg.buf, 0644))
+	// The segments included in the checkpoint are not read again.
+	require.NoError(t, ioutil.WriteFile(segmentName(dir, 3), seg.buf, 0644))
+	var next segment
+	next.record(samplesRecord(Sample{Ref: 1, T: 2000, V: 1}), 0)
+	require.NoError(t, ioutil.WriteFile(segmentName(dir, 4), next.buf, 0644))
+
+	r := NewReader(dir)
+	var rec recorder
+	require.NoError(t, r.Start(&rec))
+	assert.Equal(t, []Series{up}, rec.series)
+	assert.Empty(t, rec.samples)
+	require.NoError(t, r.Read(&rec))
+	assert.Equal(t, []Sample{{Ref: 1, T: 2000, V: 1}}, rec.samples)
+}
+
+func TestSnappyDecode(t *testing.T) {
+	// A literal "ab" and a copy of 6 bytes at offset 2, overlapping.
+	block := []byte{8, 1 << 2, 'a', 'b', snappyCopy1 | (6-4)<<2, 2}
+	decoded, err := snappyDecode(block)
+	require.NoError(t, err)
+	assert.Equal(t, "abababab", string(decoded))
+
+	_, err = snappyDecode([]byte{8, 1 << 2, 'a', 'b', snappyCopy1 | (6-4)<<2, 9})
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+// Package pipeline exposes the building blocks of the processing pipeline of
+// nri-prometheus, so other programs can parse, transform and emit their own
+// metrics with it: Parse, then ApplyRules, then Emit.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	internalscraper "github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+	"github.com/newrelic/nri-prometheus/pkg/scraper"
+)
+
+type (
+	// Metric is a metric of a target.
+	Metric = integration.Metric
+	// Labels are the attributes of a Metric.
+	Labels = labels.Set
+	// Target is the target the metrics are from. Its metadata and cluster
+	// name are added to them when the rules are applied.
+	Target = endpoints.Target
+	// ProcessingRule is a set of transformations of the metrics, like the
+	// ones of the transformations option.
+	ProcessingRule = integration.ProcessingRule
+	// AddAttributesRule adds attributes to the metrics.
+	AddAttributesRule = integration.AddAttributesRule
+	// RenameRule renames attributes of the metrics.
+	RenameRule = integration.RenameRule
+	// RenameMetricRule renames metrics.
+	RenameMetricRule = integration.RenameMetricRule
+	// IgnoreRule drops metrics.
+	IgnoreRule = integration.IgnoreRule
+	// CopyAttributesRule copies attributes between metrics.
+	CopyAttributesRule = integration.CopyAttributesRule
+	// HistogramBucketsRule reduces the buckets of histograms.
+	HistogramBucketsRule = integration.HistogramBucketsRule
+	// DerivedMetricRule computes gauges from other metrics.
+	DerivedMetricRule = integration.DerivedMetricRule
+	// RuleSet are the processing rules ready to be applied.
+	RuleSet = integration.RuleSet
+	// Emitter sends the metrics.
+	Emitter = integration.Emitter
+)
+
+// Parse decodes a payload in the Prometheus text format into the metrics of
+// the target with the given name.
+func Parse(r io.Reader, targetName string) ([]Metric, error) {
+	return integration.ParseMetrics(r, targetName)
+}
+
+// NewGauge returns a gauge with the given name, value and attributes.
+func NewGauge(name string, value float64, attributes Labels) Metric {
+	return integration.NewGaugeMetric(name, value, attributes)
+}
+
+// NewCount returns a counter with the given name, cumulative value and
+// attributes.
+func NewCount(name string, value float64, attributes Labels) Metric {
+	return integration.NewCountMetric(name, value, attributes)
+}
+
+// CompileRules prepares the processing rules to be applied. The RuleSet can
+// be reused for all the metrics.
+func CompileRules(rules []ProcessingRule) *RuleSet {
+	return integration.NewRuleSet(rules)
+}
+
+// ApplyRules applies the rules to the metrics of the target, returning the
+// transformed metrics.
+func ApplyRules(rules *RuleSet, target Target, metrics []Metric) []Metric {
+	pair := integration.TargetMetrics{Target: target, Metrics: metrics}
+	rules.Apply(&pair)
+	return pair.Metrics
+}
+
+// NewStdoutEmitter returns an Emitter printing the metrics as JSON.
+func NewStdoutEmitter() Emitter {
+	return integration.NewStdoutEmitter()
+}
+
+// NewTelemetryEmitter returns an Emitter sending the metrics to New Relic
+// through the Metric API of the region of the license key. It's configured
+// like the telemetry emitter of the integration, with its defaults and the
+// given options, e.g. scraper.WithLicenseKey.
+func NewTelemetryEmitter(opts ...scraper.Option) (Emitter, error) {
+	cfg, err := scraper.NewConfig(append([]scraper.Option{scraper.WithoutKubernetes()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Emitters = []string{"telemetry"}
+	emitters, err := internalscraper.NewEmitters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return emitters[0], nil
+}
+
+// Emit sends the metrics through all the emitters, returning the errors of
+// any of them.
+func Emit(metrics []Metric, emitters ...Emitter) error {
+	var result error
+	for _, e := range emitters {
+		if err := e.Emit(metrics); err != nil {
+			if result == nil {
+				result = fmt.Errorf("emitter %s: %w", e.Name(), err)
+			} else {
+				result = fmt.Errorf("emitter %s: %v: %w", e.Name(), err, result)
+			}
+		}
+	}
+	return result
+}
+
+// Flush sends the metrics kept in memory by the emitters, waiting until the
+// context is done.
+func Flush(ctx context.Context, emitters ...Emitter) {
+	integration.FlushEmitters(ctx, emitters)
+}
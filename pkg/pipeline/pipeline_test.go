@@ -0,0 +1,65 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/pkg/pipeline"
+	"github.com/newrelic/nri-prometheus/pkg/scraper"
+)
+
+type collectingEmitter struct {
+	metrics []pipeline.Metric
+}
+
+func (c *collectingEmitter) Name() string { return "collecting" }
+
+func (c *collectingEmitter) Emit(metrics []pipeline.Metric) error {
+	c.metrics = append(c.metrics, metrics...)
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	metrics, err := pipeline.Parse(strings.NewReader(`# TYPE go_goroutines gauge
+go_goroutines 8
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 10
+`), "app")
+	require.NoError(t, err)
+	metrics = append(metrics, pipeline.NewGauge("queue_size", 3, pipeline.Labels{"queue": "jobs"}))
+
+	rules := pipeline.CompileRules([]pipeline.ProcessingRule{{
+		IgnoreMetrics: []pipeline.IgnoreRule{{Prefixes: []string{"go_"}}},
+		AddAttributes: []pipeline.AddAttributesRule{{Attributes: map[string]interface{}{"team": "a"}}},
+	}})
+	target := pipeline.Target{Name: "app", URL: url.URL{Scheme: "http", Host: "app:8080", Path: "/metrics"}}
+	metrics = pipeline.ApplyRules(rules, target, metrics)
+
+	emitter := &collectingEmitter{}
+	require.NoError(t, pipeline.Emit(metrics, emitter))
+	require.Len(t, emitter.metrics, 2)
+	byName := map[string]pipeline.Metric{}
+	for _, m := range emitter.metrics {
+		byName[m.Name()] = m
+	}
+	assert.Equal(t, "count", byName["http_requests_total"].Type())
+	assert.Equal(t, 10.0, byName["http_requests_total"].Value())
+	assert.Equal(t, "200", byName["http_requests_total"].Attributes()["code"])
+	assert.Equal(t, "a", byName["queue_size"].Attributes()["team"])
+	assert.Equal(t, "gauge", byName["queue_size"].Type())
+}
+
+func TestNewTelemetryEmitter(t *testing.T) {
+	emitter, err := pipeline.NewTelemetryEmitter(scraper.WithLicenseKey("eu01xx6789012345678901234567890123456789"))
+	require.NoError(t, err)
+	assert.Equal(t, "telemetry", emitter.Name())
+
+	_, err = pipeline.NewTelemetryEmitter()
+	assert.Error(t, err, "the license key is required")
+}
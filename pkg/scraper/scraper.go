@@ -0,0 +1,151 @@
+// Package scraper exposes the scraper of nri-prometheus to the programs that
+// embed it, which can register their own target retrievers and emitters.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+	"github.com/newrelic/nri-prometheus/internal/pkg/labels"
+)
+
+type (
+	// Config is the configuration of the scraper.
+	Config = scraper.Config
+	// Target is a target to scrape.
+	Target = endpoints.Target
+	// Object is the Kubernetes object, or any other source, of a Target.
+	Object = endpoints.Object
+	// TargetRetriever discovers the targets to scrape.
+	TargetRetriever = endpoints.TargetRetriever
+	// Emitter sends the scraped metrics.
+	Emitter = integration.Emitter
+	// Metric is a scraped metric, after the processing rules are applied.
+	Metric = integration.Metric
+	// Labels are the labels of a Target or the attributes of a Metric.
+	Labels = labels.Set
+	// RetrieverFactory creates a TargetRetriever with the configuration.
+	RetrieverFactory = scraper.RetrieverFactory
+	// EmitterFactory creates an Emitter with the configuration.
+	EmitterFactory = scraper.EmitterFactory
+)
+
+// Option sets a setting of the Config.
+type Option func(cfg *Config)
+
+// NewConfig returns the configuration of the scraper with the given options,
+// and the defaults of the integration for the other settings, like the
+// integration without configuration file. The emitters default to the
+// telemetry one, sending the metrics to the Metric API of the region of the
+// license key.
+func NewConfig(opts ...Option) (*Config, error) {
+	cfg, err := scraper.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	scraper.CompleteConfig(cfg)
+	return cfg, nil
+}
+
+// WithClusterName sets the name of the cluster added to the metrics.
+func WithClusterName(name string) Option {
+	return func(cfg *Config) {
+		cfg.ClusterName = name
+	}
+}
+
+// WithLicenseKey sets the license key the metrics are sent with.
+func WithLicenseKey(key string) Option {
+	return func(cfg *Config) {
+		cfg.LicenseKey = scraper.LicenseKey(key)
+	}
+}
+
+// WithTargets adds static targets scraping the given URLs.
+func WithTargets(urls ...string) Option {
+	return func(cfg *Config) {
+		target := endpoints.TargetConfig{}
+		for _, u := range urls {
+			target.URLs = append(target.URLs, endpoints.TargetURL{URL: u})
+		}
+		cfg.TargetConfigs = append(cfg.TargetConfigs, target)
+	}
+}
+
+// WithEmitters sets the names of the emitters of the metrics, the built-in
+// ones or the registered with RegisterEmitter.
+func WithEmitters(names ...string) Option {
+	return func(cfg *Config) {
+		cfg.Emitters = names
+	}
+}
+
+// WithScrapeInterval sets how often the targets are scraped.
+func WithScrapeInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ScrapeDuration = interval.String()
+	}
+}
+
+// WithScrapeTimeout sets the time a target has to answer a scrape.
+func WithScrapeTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ScrapeTimeout = timeout
+	}
+}
+
+// WithoutKubernetes disables the discovery of the targets of the Kubernetes
+// cluster the program runs in, and the cluster attributes of the metrics.
+func WithoutKubernetes() Option {
+	return func(cfg *Config) {
+		cfg.DisableKubernetes = true
+	}
+}
+
+// WithoutLicenseKeyCheck skips the verification of the license key by the
+// emitters before the first scrape.
+func WithoutLicenseKeyCheck() Option {
+	return func(cfg *Config) {
+		cfg.DisableLicenseKeyCheck = true
+	}
+}
+
+// WithOnce makes Run discover, scrape and emit the targets a single time,
+// failing if any of them couldn't be scraped or emitted.
+func WithOnce() Option {
+	return func(cfg *Config) {
+		cfg.Once = true
+	}
+}
+
+// Run runs the scraper with the given configuration until the process is
+// stopped, or just once if it isn't standalone or WithOnce is set.
+func Run(cfg *Config) error {
+	return scraper.Run(cfg)
+}
+
+// RegisterRetriever registers a TargetRetriever, whose targets are scraped
+// along with the ones of the built-in retrievers. It must be called before
+// Run.
+func RegisterRetriever(name string, factory RetrieverFactory) {
+	scraper.RegisterRetriever(name, factory)
+}
+
+// RegisterEmitter registers an Emitter, used when its name is in the emitters
+// of the configuration. It must be called before Run.
+func RegisterEmitter(name string, factory EmitterFactory) {
+	scraper.RegisterEmitter(name, factory)
+}
+
+// NewTarget returns a Target scraping the given URL.
+func NewTarget(name string, addr url.URL, object Object) Target {
+	return endpoints.New(name, addr, object)
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package scraper_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/pkg/scraper"
+)
+
+type staticRetriever struct {
+	targets []scraper.Target
+}
+
+func (r staticRetriever) GetTargets() ([]scraper.Target, error) { return r.targets, nil }
+func (r staticRetriever) Watch() error                          { return nil }
+func (r staticRetriever) Name() string                          { return "embedded" }
+
+type collectingEmitter struct {
+	mtx     sync.Mutex
+	metrics []scraper.Metric
+}
+
+func (c *collectingEmitter) Name() string { return "collecting" }
+
+func (c *collectingEmitter) Emit(metrics []scraper.Metric) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.metrics = append(c.metrics, metrics...)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("# TYPE queue_size gauge\nqueue_size{queue=\"jobs\"} 3\n"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/metrics")
+	require.NoError(t, err)
+
+	scraper.RegisterRetriever("embedded", func(*scraper.Config) (scraper.TargetRetriever, error) {
+		return staticRetriever{targets: []scraper.Target{scraper.NewTarget("app", *u, scraper.Object{})}}, nil
+	})
+	emitter := &collectingEmitter{}
+	scraper.RegisterEmitter("collecting", func(*scraper.Config) (scraper.Emitter, error) {
+		return emitter, nil
+	})
+
+	cfg, err := scraper.NewConfig(
+		scraper.WithLicenseKey("key"),
+		scraper.WithEmitters("collecting"),
+		scraper.WithScrapeTimeout(time.Second),
+		scraper.WithoutKubernetes(),
+		scraper.WithOnce(),
+	)
+	require.NoError(t, err)
+	// The defaults of the integration are applied.
+	assert.Equal(t, "30s", cfg.ScrapeDuration)
+	assert.Equal(t, 4, cfg.WorkerThreads)
+
+	require.NoError(t, scraper.Run(cfg))
+
+	var scraped scraper.Metric
+	for _, m := range emitter.metrics {
+		if m.Name() == "queue_size" {
+			scraped = m
+		}
+	}
+	require.NotEmpty(t, scraped.Name(), "the metrics of the registered retriever's target are emitted")
+	assert.Equal(t, 3.0, scraped.Value())
+	assert.Equal(t, "jobs", scraped.Attributes()["queue"])
+	assert.Equal(t, "app", scraped.Attributes()["targetName"])
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package testsupport
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Exporter is a fake exporter serving a payload in the Prometheus text
+// format, which can be changed between the scrapes.
+type Exporter struct {
+	server *httptest.Server
+
+	mtx         sync.Mutex
+	payload     string
+	status      int
+	contentType string
+	scrapes     int
+}
+
+// NewExporter starts an Exporter serving the payload. It must be closed when
+// the test finishes.
+func NewExporter(payload string) *Exporter {
+	e := &Exporter{payload: payload, status: http.StatusOK, contentType: "text/plain; version=0.0.4"}
+	e.server = httptest.NewServer(http.HandlerFunc(e.serve))
+	return e
+}
+
+// Close stops serving the metrics.
+func (e *Exporter) Close() {
+	e.server.Close()
+}
+
+func (e *Exporter) serve(w http.ResponseWriter, _ *http.Request) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.scrapes++
+	w.Header().Set("Content-Type", e.contentType)
+	w.WriteHeader(e.status)
+	_, _ = io.WriteString(w, e.payload)
+}
+
+// URL returns the URL the metrics are served at.
+func (e *Exporter) URL() string {
+	return e.server.URL + "/metrics"
+}
+
+// SetPayload changes the payload served in the next scrapes.
+func (e *Exporter) SetPayload(payload string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.payload = payload
+}
+
+// SetStatus changes the status code of the next scrapes, to simulate
+// failures.
+func (e *Exporter) SetStatus(status int) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.status = status
+}
+
+// SetContentType changes the Content-Type of the next scrapes.
+func (e *Exporter) SetContentType(contentType string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.contentType = contentType
+}
+
+// Scrapes returns the number of times the exporter was scraped.
+func (e *Exporter) Scrapes() int {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.scrapes
+}
+
+// ReceivedMetric is a metric received by the MetricAPI, with the common
+// attributes of its batch.
+type ReceivedMetric struct {
+	Name string `json:"name"`
+	// Type is gauge, count or summary.
+	Type string `json:"type"`
+	// Value is a float64, or a map with the count, sum, min and max of the
+	// summaries.
+	Value      interface{}            `json:"value"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// MetricAPI is a fake New Relic Metric and Event API, keeping the metrics and
+// events it receives.
+type MetricAPI struct {
+	server *httptest.Server
+
+	mtx     sync.Mutex
+	metrics []ReceivedMetric
+	events  []map[string]interface{}
+}
+
+// NewMetricAPI starts a MetricAPI, which must be closed when the test
+// finishes. The requests it can't decode fail the test.
+func NewMetricAPI(t testing.TB) *MetricAPI {
+	api := &MetricAPI{}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := api.receive(r); err != nil {
+			t.Errorf("fake Metric API: decoding %s: %v", r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return api
+}
+
+// Close stops the Metric API.
+func (api *MetricAPI) Close() {
+	api.server.Close()
+}
+
+// URL returns the URL of the Metric API.
+func (api *MetricAPI) URL() string {
+	return api.server.URL + "/metric/v1"
+}
+
+// EventsURL returns the URL of the Event API.
+func (api *MetricAPI) EventsURL() string {
+	return api.server.URL + "/events/v1"
+}
+
+func (api *MetricAPI) receive(r *http.Request) error {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		body = gzr
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/events") {
+		var events []map[string]interface{}
+		if err := json.NewDecoder(body).Decode(&events); err != nil {
+			return err
+		}
+		api.mtx.Lock()
+		defer api.mtx.Unlock()
+		api.events = append(api.events, events...)
+		return nil
+	}
+
+	var batches []struct {
+		Common struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"common"`
+		Metrics []ReceivedMetric `json:"metrics"`
+	}
+	if err := json.NewDecoder(body).Decode(&batches); err != nil {
+		return err
+	}
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	for _, b := range batches {
+		for _, m := range b.Metrics {
+			if m.Attributes == nil {
+				m.Attributes = make(map[string]interface{}, len(b.Common.Attributes))
+			}
+			for name, value := range b.Common.Attributes {
+				if _, ok := m.Attributes[name]; !ok {
+					m.Attributes[name] = value
+				}
+			}
+			api.metrics = append(api.metrics, m)
+		}
+	}
+	return nil
+}
+
+// Metrics returns all the received metrics.
+func (api *MetricAPI) Metrics() []ReceivedMetric {
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	return append([]ReceivedMetric(nil), api.metrics...)
+}
+
+// Metric returns the received metrics with the given name.
+func (api *MetricAPI) Metric(name string) []ReceivedMetric {
+	var metrics []ReceivedMetric
+	for _, m := range api.Metrics() {
+		if m.Name == name {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Events returns all the received events, with their attributes.
+func (api *MetricAPI) Events() []map[string]interface{} {
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	return append([]map[string]interface{}(nil), api.events...)
+}
+
+// Reset forgets the received metrics and events.
+func (api *MetricAPI) Reset() {
+	api.mtx.Lock()
+	defer api.mtx.Unlock()
+	api.metrics = nil
+	api.events = nil
+}
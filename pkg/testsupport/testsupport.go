@@ -0,0 +1,77 @@
+// Package testsupport runs nri-prometheus end to end in the tests of other
+// programs: fake exporters are discovered and scraped, the processing rules
+// are applied and the metrics are emitted to a fake Metric API, so the rule
+// files can be validated in CI.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package testsupport
+
+import (
+	"time"
+
+	"github.com/newrelic/nri-prometheus/internal/cmd/scraper"
+	"github.com/newrelic/nri-prometheus/internal/integration"
+	"github.com/newrelic/nri-prometheus/internal/pkg/endpoints"
+)
+
+type (
+	// Config is the configuration of the integration.
+	Config = scraper.Config
+	// TargetConfig configures static targets.
+	TargetConfig = endpoints.TargetConfig
+	// TargetURL is a URL of a TargetConfig.
+	TargetURL = endpoints.TargetURL
+	// ProcessingRule is a set of transformations of the metrics, like the
+	// ones of the transformations option.
+	ProcessingRule = integration.ProcessingRule
+)
+
+// NewConfig returns a configuration scraping the exporters, with the default
+// values of the configuration file, and emitting to the MetricAPI. The
+// Kubernetes discovery is disabled. It can be changed before Run.
+func NewConfig(api *MetricAPI, exporters ...*Exporter) *Config {
+	target := TargetConfig{Description: "testsupport exporters"}
+	for _, e := range exporters {
+		target.URLs = append(target.URLs, TargetURL{URL: e.URL()})
+	}
+	return &Config{
+		Standalone:              true,
+		Emitters:                []string{"telemetry"},
+		LicenseKey:              "testsupport",
+		DisableLicenseKeyCheck:  true,
+		MetricAPIURL:            api.URL(),
+		EventAPIURL:             api.EventsURL(),
+		EmitterHarvestPeriod:    integration.BoundedHarvesterDefaultHarvestPeriod.String(),
+		MinEmitterHarvestPeriod: integration.BoundedHarvesterDefaultMinReportInterval.String(),
+		MaxStoredMetrics:        integration.BoundedHarvesterDefaultMetricsCap,
+		DisableKubernetes:       true,
+		ScrapeDuration:          "30s",
+		ScrapeTimeout:           5 * time.Second,
+		WorkerThreads:           4,
+		TargetConfigs:           []TargetConfig{target},
+	}
+}
+
+// Run runs the given number of scrape cycles: the targets are discovered,
+// scraped, processed and emitted. The counters are emitted as deltas, so they
+// are only emitted from the second cycle on. It returns an error if the
+// configuration is not valid or any target couldn't be scraped or emitted.
+func Run(cfg *Config, cycles int) error {
+	cycle, err := scraper.NewCycleRunner(cfg)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < cycles; i++ {
+		if err := cycle(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadRules returns the processing rules in the transformations key of a
+// YAML file, like the configuration file, to set them as the ProcessingRules
+// of the configuration.
+func LoadRules(path string) ([]ProcessingRule, error) {
+	return scraper.ReadProcessingRules(path)
+}
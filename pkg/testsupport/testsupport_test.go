@@ -0,0 +1,87 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package testsupport_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-prometheus/pkg/testsupport"
+)
+
+const payload = `# HELP temperature The temperature.
+# TYPE temperature gauge
+temperature{room="kitchen"} 21.5
+temperature{room="garage"} 12
+# HELP requests_total The requests.
+# TYPE requests_total counter
+requests_total{code="200"} 10
+# HELP debug_info Debugging metric.
+# TYPE debug_info gauge
+debug_info 1
+`
+
+const rules = `transformations:
+  - description: "test rules"
+    ignore_metrics:
+      - prefixes:
+          - debug_
+    rename_attributes:
+      - metric_prefix: temperature
+        attributes:
+          room: location
+`
+
+func TestRun(t *testing.T) {
+	exporter := testsupport.NewExporter(payload)
+	defer exporter.Close()
+	api := testsupport.NewMetricAPI(t)
+	defer api.Close()
+
+	dir, err := ioutil.TempDir("", "testsupport")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(rules), 0600))
+	loaded, err := testsupport.LoadRules(path)
+	require.NoError(t, err)
+
+	cfg := testsupport.NewConfig(api, exporter)
+	cfg.ProcessingRules = loaded
+	// The counters are emitted from the second cycle on.
+	require.NoError(t, testsupport.Run(cfg, 2))
+	assert.Equal(t, 2, exporter.Scrapes())
+
+	temperatures := api.Metric("temperature")
+	require.Len(t, temperatures, 4, "two series in two cycles")
+	locations := map[interface{}]interface{}{}
+	for _, m := range temperatures {
+		assert.Equal(t, "gauge", m.Type)
+		locations[m.Attributes["location"]] = m.Value
+	}
+	assert.Equal(t, map[interface{}]interface{}{"kitchen": 21.5, "garage": 12.0}, locations)
+	assert.Empty(t, api.Metric("debug_info"))
+
+	requests := api.Metric("requests_total")
+	require.Len(t, requests, 1)
+	assert.Equal(t, "count", requests[0].Type)
+	assert.Equal(t, 0.0, requests[0].Value)
+	assert.Equal(t, "200", requests[0].Attributes["code"])
+}
+
+func TestRun_ScrapeError(t *testing.T) {
+	exporter := testsupport.NewExporter(payload)
+	defer exporter.Close()
+	exporter.SetStatus(http.StatusInternalServerError)
+	api := testsupport.NewMetricAPI(t)
+	defer api.Close()
+
+	assert.Error(t, testsupport.Run(testsupport.NewConfig(api, exporter), 1))
+	assert.Empty(t, api.Metric("temperature"))
+}